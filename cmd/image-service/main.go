@@ -2,24 +2,38 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"net/http"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/DMarby/picsum-photos/internal/admission"
 	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/cache/lru"
 	"github.com/DMarby/picsum-photos/internal/cache/memory"
 	"github.com/DMarby/picsum-photos/internal/cache/redis"
 	"github.com/DMarby/picsum-photos/internal/cmd"
 	"github.com/DMarby/picsum-photos/internal/database"
 	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
 	"github.com/DMarby/picsum-photos/internal/database/postgresql"
+	"github.com/DMarby/picsum-photos/internal/handler"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/image"
 	"github.com/DMarby/picsum-photos/internal/image/vips"
 	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/quota"
+	memoryQuota "github.com/DMarby/picsum-photos/internal/quota/memory"
+	"github.com/DMarby/picsum-photos/internal/signature"
 	"github.com/DMarby/picsum-photos/internal/storage"
 	fileStorage "github.com/DMarby/picsum-photos/internal/storage/file"
+	"github.com/DMarby/picsum-photos/internal/storage/modtime"
+	"github.com/DMarby/picsum-photos/internal/storage/retry"
 	"github.com/DMarby/picsum-photos/internal/storage/spaces"
+	"github.com/DMarby/picsum-photos/internal/storage/tiered"
 
 	api "github.com/DMarby/picsum-photos/internal/imageapi"
 
@@ -27,14 +41,93 @@ import (
 	"go.uber.org/zap"
 )
 
+// modTimeCacheSize is how many bytes of the modification times of the images are cached, which is tens of thousands of images
+const modTimeCacheSize = 4 << 20
+
 // Comandline flags
 var (
 	// Global
-	listen   = flag.String("listen", ":8081", "listen address")
-	loglevel = zap.LevelFlag("log-level", zap.InfoLevel, "log level (default \"info\") (debug, info, warn, error, dpanic, panic, fatal)")
+	listen                = flag.String("listen", ":8081", "listen address")
+	metricsListen         = flag.String("metrics-listen", "127.0.0.1:8082", "listen address of the metrics at /debug/vars, kept off the public listen address (empty to disable)")
+	readTimeout           = flag.Duration("read-timeout", cmd.DefaultTimeouts.Read, "max duration of reading a whole request")
+	readHeaderTimeout     = flag.Duration("read-header-timeout", cmd.DefaultTimeouts.ReadHeader, "max duration of reading the headers of a request, which limits slow clients holding on to connections")
+	writeTimeout          = flag.Duration("write-timeout", cmd.DefaultTimeouts.Write, "max duration of writing a response, has to be longer than the timeout of the handlers so that slow encodes still get a response")
+	idleTimeout           = flag.Duration("idle-timeout", cmd.DefaultTimeouts.Idle, "max duration of keeping an idle keep-alive connection open")
+	maxURLLength          = flag.Int("max-url-length", handler.DefaultURLLimits.MaxLength, "max length of request urls, longer urls get a 414 (0 to disable)")
+	maxQueryParams        = flag.Int("max-query-params", handler.DefaultURLLimits.MaxParams, "max amount of query params in request urls, more params get a 400 (0 to disable)")
+	unprocessable         = flag.Bool("unprocessable-params", false, "respond with 422 instead of 400 to params that are well-formed, but out of range or contradicting each other")
+	imageIDPattern        = flag.String("image-id-pattern", params.DefaultImageIDPattern, "regular expression that the whole image id has to match, checked before looking up the image (empty to allow any id)")
+	tenantPattern         = flag.String("tenant-pattern", "", "regular expression that the whole tenant has to match, enables the /t/{tenant}/id/{id} routes that scope the images to the tenant, for example \"acme|globex\" (disabled by default)")
+	slowRequests          = flag.Duration("log-slow-requests", 0, "log requests that take longer than this, and requests that fail with a server error, at the info level (0 to disable)")
+	dailyQuota            = flag.Int("daily-quota", 0, "max amount of requests from each client ip within the quota window, more requests get a 429 (0 to disable)")
+	quotaWindow           = flag.Duration("quota-window", 24*time.Hour, "length of the quota window, windows of whole days start at midnight utc")
+	quotaRolling          = flag.Bool("quota-rolling", false, "start the quota window at the first request of each client, instead of at fixed times")
+	quotaExempt           = flag.String("quota-exempt", "", "comma separated list of networks of trusted clients that aren't limited by the quota, for example \"10.0.0.0/8,192.0.2.1\"")
+	quotaProxies          = flag.String("quota-trusted-proxies", "", "comma separated list of networks of the proxies in front of the service, whose requests are counted against the client ip from X-Forwarded-For or Forwarded, for example \"10.0.0.0/8\"")
+	noSniff               = flag.Bool("nosniff", handler.DefaultSecurityHeaders.NoSniff, "set the X-Content-Type-Options: nosniff header, so that browsers don't sniff the content type of responses")
+	contentSecurityPolicy = flag.String("content-security-policy", handler.DefaultSecurityHeaders.ContentSecurityPolicy, "value of the Content-Security-Policy header of responses (empty to not set it)")
+	referrerPolicy        = flag.String("referrer-policy", handler.DefaultSecurityHeaders.ReferrerPolicy, "value of the Referrer-Policy header of responses (empty to not set it)")
+	basePath              = flag.String("base-path", "", "path to serve the routes under, such as /images when running behind a reverse proxy that serves them under a path (defaults to the root)")
+	trailingSlash         = flag.String("trailing-slash", "redirect", "how paths with a trailing slash are handled, redirecting them to the path without it or serving them the same (redirect, accept)")
+	alphaFormat           = flag.String("alpha-format", "", "format to output masked images, and images with a background with alpha, in when they're requested as .jpg, which has no alpha channel (webp, empty to reject those requests)")
+	processingVersion     = flag.String("processing-version", params.ProcessingVersion, "version of the image processing that's part of the cache keys of the images and of the format and quality decisions, change it to change the keys when the processed images change (empty to leave it out of the keys)")
+	saveDataQuality       = flag.Int("save-data-quality", api.DefaultSaveDataQuality, "quality of the images for clients that send Save-Data: on, which also get the smallest format they accept (1-100, 0 to disable)")
+	signingKey            = flag.String("signing-key", "", "key that only serves the routes with a valid signature in the path, /s/{signature}/id/{id}/..., other than the health check, must match between the services (empty to disable signing)")
+	disableEffects        = flag.String("disable-effects", "", "comma separated list of the effects to disable, by their names in the capabilities, for example \"blend,overlay,text\" (empty to enable all of them)")
+	ignoreDisabledEffects = flag.Bool("ignore-disabled-effects", false, "ignore the disabled effects in requests, instead of rejecting the request")
+	maxEffects            = flag.Int("max-effects", 0, "most distinct effects a request can combine, by their names in the capabilities, rejecting the requests with more (0 for no limit)")
+	filters               = flag.String("filters", params.DefaultFilters, "semicolon separated list of the filters of the filter param, in the form name=query with the color and tonal adjustments and the vignette, for example \"vintage=sepia=40&contrast=-10;noir=grayscale&contrast=30\"")
+	debugParams           = flag.Bool("debug-params", false, "allow the debug param, which responds with how the request was resolved instead of the image")
+	sourceFormatHeader    = flag.Bool("source-format-header", false, "set the X-Image-Source-Format header of images to the format of their source, for finding sources in unexpected formats")
+	loglevel              = zap.LevelFlag("log-level", zap.InfoLevel, "log level (default \"info\") (debug, info, warn, error, dpanic, panic, fatal)")
+
+	// Images
+	noUpscale         = flag.Bool("no-upscale", false, "don't upscale images beyond their native size")
+	noAutoRotate      = flag.Bool("no-auto-rotate", false, "don't rotate images by their exif orientation unless the autorotate param is set, for sources that are already upright")
+	rounding          = flag.String("dimension-rounding", "round", "how fractional pixel dimensions are rounded to whole pixels, must match between the services (round, floor, ceil)")
+	cropBounds        = flag.String("crop-bounds", "strict", "how crops that extend outside of the source image are handled, rejecting them or intersecting them with the image (strict, clamp)")
+	qualityBounds     = flag.String("quality-bounds", "", "semicolon separated list of formats with the range of the quality param for them, for example \"jpeg:1-90;webp:10-85\" (unbounded by default)")
+	clampQuality      = flag.Bool("clamp-quality", false, "clamp qualities outside of the quality bounds to them, instead of rejecting the request")
+	exifGPS           = flag.Bool("exif-gps", false, "include the gps location in the exif metadata of source images")
+	strictExtract     = flag.Bool("strict-extract-alpha", false, "fail requests extracting the alpha channel of images without one, instead of returning an opaque image")
+	optimizeCoding    = flag.Bool("jpeg-optimize-coding", true, "optimize the huffman coding of jpeg images, making them a few percent smaller at the cost of a slower encode")
+	legacyUserAgents  = flag.String("legacy-user-agents", "", "case insensitive regular expression matching the user agents of clients that can't decode webp or progressive jpeg images, which always get a baseline jpeg, for example \"MSIE [5-8]\\.\" (disabled by default)")
+	embedICCProfile   = flag.Bool("embed-icc-profile", false, "embed the icc profile in srgb images")
+	maxSourcePixels   = flag.Int("max-source-pixels", 100000000, "max amount of pixels in a source image, larger images are rejected before being decoded (0 to disable)")
+	sourceFormats     = flag.String("source-formats", "jpeg,png,webp", "comma separated list of source image formats to decode, others are rejected before being decoded (jpeg, png, webp, gif, tiff, heif, svg), heif decodes HEIC and AVIF images and requires libvips with libheif")
+	effectOrder       = flag.String("effect-order", "", "comma separated list of effects, in the order to apply them in, the effects that aren't listed are applied after them in the canonical order, for example \"sharpen,blur\" (defaults to the canonical order)")
+	dprQuality        = flag.String("dpr-quality", "", "comma separated dpr:quality pairs for lowering the quality of high dpr images, for example \"1:75,2:60,3:50\" (disabled by default)")
+	sizeQuality       = flag.String("size-quality", "", "comma separated size:quality pairs for the default quality of images by the longest side of the requested size, for example \"0:85,500:75,1500:65\" (disabled by default)")
+	qualityPresets    = flag.String("quality-presets", api.DefaultQualityPresets, "semicolon separated formats with the quality of the low, medium and high quality presets, for example \"jpeg:low=50,medium=75,high=90;webp:low=45,medium=70,high=85\"")
+	workers           = flag.Int("workers", 0, "max amount of images to process concurrently (0 for one per cpu)")
+	threads           = flag.Int("threads", 0, "max amount of threads to process and encode each image with, where workers * threads shouldn't exceed the cpus (0 for a single thread)")
+	maxPixelBudget    = flag.Int64("max-inflight-pixels", 0, "max amount of output pixels being processed at once, requests over the budget get a 503 (0 to disable)")
+	degradeLoad       = flag.Float64("degrade-load", 0, "share of the max-inflight-pixels budget in use at which images are encoded with a lower quality and the fastest settings, for example 0.8 (0 to disable)")
+	degradeQuality    = flag.Int("degrade-quality", 50, "max encoder quality of images while degraded by degrade-load, from 1 to 100")
+	fallbackAfter     = flag.Duration("encode-fallback-after", 0, "how long processing an image can take before it's retried with the next step of the encode-fallback chain, the first attempt to finish is served (0 to disable)")
+	fallbackChain     = flag.String("encode-fallback", api.DefaultEncodeFallback, "comma separated chain of cheaper encoder settings to fall back to, applied on top of each other (lossy, fastest, jpeg)")
+	downloadProgress  = flag.Bool("download-progress", false, "track the progress of the downloads that are given a job id, streamed as server-sent events from /download/status/{job}")
+	asyncWorkers      = flag.Int("async-workers", 0, "max amount of async requests to process in the background at once, polled from /jobs/{job} and kept in memory once processed (0 to disable async requests)")
+	asyncQueue        = flag.Int("async-queue", api.DefaultAsyncQueue, "max amount of async requests waiting for one of the async-workers, further async requests get a 503")
+	formatCacheSize   = flag.Int64("format-cache-size", api.DefaultFormatCacheSize, "max amount of bytes of the decisions of format=auto, quality=auto and lossless=auto, and of the exif, palettes and hashes of the images, kept in memory, the least recently used are evicted first")
+	formatCacheTTL    = flag.Duration("format-cache-ttl", api.DefaultFormatCacheTTL, "how long the decisions of format=auto, quality=auto and lossless=auto, and the exif, palettes and hashes of the images, are kept in memory before they're made again (0 to keep them until they're evicted)")
+	asyncCacheSize    = flag.Int64("async-cache-size", api.DefaultAsyncCacheSize, "max amount of bytes of images processed by the async-workers kept in memory, the least recently used are evicted first")
+	autoSharpenRatio  = flag.Float64("auto-sharpen-ratio", 0, "sharpen images that are this many times smaller than the source image by default, unless the sharpen param is set or they're resized with the nearest filter, for example 3 (0 to disable)")
+	autoSharpenSigma  = flag.Float64("auto-sharpen-sigma", 0.5, "how much auto-sharpen-ratio sharpens images by, from 0 to 5")
+	maxBlurRatio      = flag.Float64("max-blur-ratio", 0, "max blur of images as a ratio of their shorter side, larger blurs are clamped to it, for example 0.02 (0 to disable)")
+	defaultDPI        = flag.Int("default-dpi", 0, "resolution to embed in jpeg images when the dpi param isn't set, from 1 to 2400 (0 keeps the resolution of the source image)")
+	timingAllowOrigin = flag.Bool("timing-allow-origin", false, "set the Timing-Allow-Origin header on images to the cors allowed origin, so that clients can read their detailed resource timing")
+	webpEffort        = flag.Int("webp-effort", image.DefaultEncodeEffort, "default webp encoder effort, from 0 (fastest) to 6 (smallest)")
+	autoQualitySSIM   = flag.Float64("auto-quality-ssim", api.DefaultAutoQualitySSIM, "structural similarity to the full quality image that quality=auto aims for, from 0 to 1")
+	losslessThreshold = flag.Float64("auto-lossless-threshold", api.DefaultAutoLosslessThreshold, "share of the pixels that the main colors of an image have to cover for lossless=auto to encode it losslessly, from 0 to 1")
+	cacheTTLs         = flag.String("cache-ttls", "", "semicolon separated list of cache ttls overriding the Cache-Control header of successful responses, in the form pattern=duration, for example \"/id/*/*/*=8760h\" (disabled by default)")
 
 	// Storage
-	storageBackend = flag.String("storage", "file", "which storage backend to use (file, spaces)")
+	storageBackend       = flag.String("storage", "file", "which storage backend to use (file, spaces), or a comma separated list of them to try in order, for example \"file,spaces\" for local copies of popular images in front of the full set")
+	storageWriteBack     = flag.Bool("storage-write-back", false, "write images found in a later storage backend back to the earlier ones, in the background")
+	storageRetryAttempts = flag.Int("storage-retry-attempts", 3, "max amount of attempts at getting an image from storage when it fails with a transient error (1 to disable retries)")
+	storageRetryBackoff  = flag.Duration("storage-retry-backoff", 100*time.Millisecond, "time to wait before retrying to get an image from storage, doubled for each retry")
+	storageModTimeTTL    = flag.Duration("storage-modtime-ttl", time.Minute, "how long the modification times of the images are cached for the If-Modified-Since of the requests, before the storage is asked again (0 to ask the storage for each request)")
 
 	// Storage - File
 	storageFilePath = flag.String("storage-file-path", "./test/fixtures/file", "path to the file storage")
@@ -77,8 +170,154 @@ func main() {
 	shutdownCtx, shutdown := context.WithCancel(context.Background())
 	defer shutdown()
 
+	if *webpEffort < 0 || *webpEffort > 6 {
+		log.Fatalf("invalid webp effort %d, must be between 0 and 6", *webpEffort)
+	}
+
+	if *degradeLoad > 0 && *maxPixelBudget <= 0 {
+		log.Fatalf("degrade-load requires max-inflight-pixels to be set")
+	}
+
+	if *degradeQuality < 1 || *degradeQuality > 100 {
+		log.Fatalf("invalid degrade quality %d, must be between 1 and 100", *degradeQuality)
+	}
+
+	if *threads < 0 || *threads > runtime.NumCPU() {
+		log.Fatalf("invalid threads %d, must be between 0 and the %d cpus", *threads, runtime.NumCPU())
+	}
+
+	if *saveDataQuality < 0 || *saveDataQuality > 100 {
+		log.Fatalf("invalid save data quality %d, must be between 1 and 100, or 0 to disable", *saveDataQuality)
+	}
+
+	if *autoSharpenSigma < 0 || *autoSharpenSigma > 5 {
+		log.Fatalf("invalid auto sharpen sigma %f, must be between 0 and 5", *autoSharpenSigma)
+	}
+
+	if *losslessThreshold <= 0 || *losslessThreshold > 1 {
+		log.Fatalf("invalid auto lossless threshold %f, must be between 0 and 1", *losslessThreshold)
+	}
+
+	if *maxBlurRatio < 0 || *maxBlurRatio > 1 {
+		log.Fatalf("invalid max blur ratio %f, must be between 0 and 1", *maxBlurRatio)
+	}
+
+	if *defaultDPI < 0 || *defaultDPI > 2400 {
+		log.Fatalf("invalid default dpi %d, must be between 0 and 2400", *defaultDPI)
+	}
+
+	dprQualityMapping, err := api.ParseDPRQuality(*dprQuality)
+	if err != nil {
+		log.Fatalf("error parsing dpr quality: %s", err)
+	}
+
+	sizeQualityMapping, err := api.ParseSizeQuality(*sizeQuality)
+	if err != nil {
+		log.Fatalf("error parsing size quality: %s", err)
+	}
+
+	qualityPresetMapping, err := api.ParseQualityPresets(*qualityPresets)
+	if err != nil {
+		log.Fatalf("error parsing quality presets: %s", err)
+	}
+
+	// Validate the timeouts of the http server
+	serverTimeouts := cmd.Timeouts{Read: *readTimeout, ReadHeader: *readHeaderTimeout, Write: *writeTimeout, Idle: *idleTimeout}
+	if err := serverTimeouts.Validate(cmd.HandlerTimeout); err != nil {
+		log.Fatalf("error validating timeouts: %s", err)
+	}
+
+	// Parse the dimension rounding
+	dimensionRounding, err := params.ParseRounding(*rounding)
+	if err != nil {
+		log.Fatalf("error parsing dimension rounding: %s", err)
+	}
+
+	// Parse the crop bounds policy
+	cropBoundsPolicy, err := params.ParseCropBounds(*cropBounds)
+	if err != nil {
+		log.Fatalf("error parsing crop bounds: %s", err)
+	}
+
+	// Parse the bounds of the quality param
+	qualityBoundsPolicy, err := params.ParseQualityBounds(*qualityBounds, *clampQuality)
+	if err != nil {
+		log.Fatalf("error parsing quality bounds: %s", err)
+	}
+
+	// Parse the filters of the filter param
+	filterPresets, err := params.ParseFilters(*filters)
+	if err != nil {
+		log.Fatalf("error parsing filters: %s", err)
+	}
+
+	// Parse the image id pattern
+	imageIDRegexp, err := params.ParseImageIDPattern(*imageIDPattern)
+	if err != nil {
+		log.Fatalf("error parsing image id pattern: %s", err)
+	}
+
+	// Parse the tenant pattern
+	tenantRegexp, err := params.ParseImageIDPattern(*tenantPattern)
+	if err != nil {
+		log.Fatalf("error parsing tenant pattern: %s", err)
+	}
+
+	// Parse the user agents of legacy clients
+	legacyRegexp, err := api.ParseUserAgents(*legacyUserAgents)
+	if err != nil {
+		log.Fatalf("error parsing legacy user agents: %s", err)
+	}
+
+	// Parse the cache ttls
+	routeCacheTTLs, err := handler.ParseCacheTTLs(*cacheTTLs)
+	if err != nil {
+		log.Fatalf("error parsing cache ttls: %s", err)
+	}
+
+	// Parse the allowed source formats
+	allowedSourceFormats, err := image.ParseSourceFormats(*sourceFormats)
+	if err != nil {
+		log.Fatalf("error parsing source formats: %s", err)
+	}
+
+	effects, err := image.ParseEffectOrder(*effectOrder)
+	if err != nil {
+		log.Fatalf("error parsing effect order: %s", err)
+	}
+
+	// Parse the base path of the routes
+	routeBasePath, err := handler.ParseBasePath(*basePath)
+	if err != nil {
+		log.Fatalf("error parsing base path: %s", err)
+	}
+
+	// Parse the trailing slash policy
+	trailingSlashPolicy, err := handler.ParseTrailingSlash(*trailingSlash)
+	if err != nil {
+		log.Fatalf("error parsing trailing slash policy: %s", err)
+	}
+
+	// Parse the format for images with alpha
+	outputAlphaFormat, err := params.ParseAlphaFormat(*alphaFormat)
+	if err != nil {
+		log.Fatalf("error parsing alpha format: %s", err)
+	}
+
+	// Parse the effects to disable
+	disabledEffects, err := params.ParseDisabledEffects(*disableEffects, *ignoreDisabledEffects)
+	if err != nil {
+		log.Fatalf("error parsing disabled effects: %s", err)
+	}
+
+	// Sign the urls of the image service, when a key is set
+	var urlSigner *signature.Signer
+	if *signingKey != "" {
+		urlSigner = &signature.Signer{Key: []byte(*signingKey)}
+	}
+
 	// Initialize the storage, cache and database
-	storage, cache, database, err := setupBackends()
+	storage, cache, database, err := setupBackends(log)
 	if err != nil {
 		log.Fatalf("error initializing backends: %s", err)
 	}
@@ -89,7 +328,9 @@ func main() {
 	imageProcessorCtx, imageProcessorCancel := context.WithCancel(context.Background())
 	defer imageProcessorCancel()
 
-	imageProcessor, err := vips.New(imageProcessorCtx, log, image.NewCache(cache, storage))
+	// Retry transient storage errors when loading images, the health checker and modification times use the storage as is
+	sourceCache := image.NewCache(cache, retry.New(storage, *storageRetryAttempts, *storageRetryBackoff))
+	imageProcessor, err := vips.New(imageProcessorCtx, log, sourceCache, *maxSourcePixels, *workers, *threads, vips.NewOrderedRegistry(effects), allowedSourceFormats)
 	if err != nil {
 		log.Fatalf("error initializing image processor %s", err.Error())
 	}
@@ -107,20 +348,147 @@ func main() {
 	}
 	go checker.Run()
 
+	// Initialize the admission controller, and expose its usage in the metrics
+	var admissionController *admission.Controller
+	if *maxPixelBudget > 0 {
+		admissionController = admission.New(*maxPixelBudget)
+		expvar.Publish("admission", expvar.Func(func() interface{} {
+			return admissionController.Stats()
+		}))
+	}
+
+	// Initialize the degradation under high load, and expose whether it's active in the metrics
+	var degradation *api.Degradation
+	if *degradeLoad > 0 {
+		degradation = api.NewDegradation(*degradeLoad, *degradeQuality)
+		expvar.Publish("degradation", expvar.Func(func() interface{} {
+			return degradation.Stats()
+		}))
+	}
+
+	// Parse the chain of cheaper encodes to fall back to for slow images
+	encodeFallback, err := api.ParseEncodeFallback(*fallbackChain, *fallbackAfter)
+	if err != nil {
+		log.Fatalf("error parsing encode fallback: %s", err)
+	}
+
+	// Track the progress of the downloads that clients follow
+	var downloadJobs *api.DownloadJobs
+	if *downloadProgress {
+		downloadJobs = api.NewDownloadJobs(api.DefaultDownloadJobWait)
+	}
+
+	// Process the images of async requests in the background, keeping them in memory for the requests that follow
+	var asyncJobs *api.AsyncJobs
+	if *asyncWorkers > 0 {
+		if *asyncQueue < 0 {
+			log.Fatalf("invalid async queue %d, can't be negative", *asyncQueue)
+		}
+
+		if *asyncCacheSize <= 0 {
+			log.Fatalf("invalid async cache size %d, must be positive", *asyncCacheSize)
+		}
+
+		asyncJobs = api.NewAsyncJobs(imageProcessorCtx, *asyncWorkers, *asyncQueue, *asyncCacheSize)
+	}
+
+	// Expose the bytes of the images served by format and size in the metrics
+	bandwidth := api.NewBandwidth()
+	expvar.Publish("bandwidth", expvar.Func(func() interface{} {
+		return bandwidth.Stats()
+	}))
+
+	// Set up the quota of requests for each client
+	var requestQuota *quota.Quota
+	if *dailyQuota > 0 {
+		if *quotaWindow <= 0 {
+			log.Fatalf("invalid quota window %s, must be positive", *quotaWindow)
+		}
+
+		exemptNetworks, err := quota.ParseNetworks(*quotaExempt)
+		if err != nil {
+			log.Fatalf("error parsing quota exempt networks: %s", err)
+		}
+
+		trustedProxies, err := quota.ParseNetworks(*quotaProxies)
+		if err != nil {
+			log.Fatalf("error parsing quota trusted proxies: %s", err)
+		}
+
+		requestQuota = &quota.Quota{
+			Limit:          *dailyQuota,
+			Window:         *quotaWindow,
+			Rolling:        *quotaRolling,
+			Exempt:         exemptNetworks,
+			TrustedProxies: trustedProxies,
+			Provider:       memoryQuota.New(),
+		}
+	}
+
+	// Keep the format, quality and lossless decisions and the metadata of the images in memory of their own, bounded by size, as the decisions are keyed by the params of the requests
+	if *formatCacheSize <= 0 {
+		log.Fatalf("invalid format cache size %d, must be positive", *formatCacheSize)
+	}
+
+	formatCache := lru.New(*formatCacheSize, *formatCacheTTL)
+
 	// Start and listen on http
 	api := &api.API{
-		ImageProcessor: imageProcessor,
-		Database:       database,
-		HealthChecker:  checker,
-		Log:            log,
-		HandlerTimeout: cmd.HandlerTimeout,
-	}
-	server := &http.Server{
-		Addr:         *listen,
-		Handler:      api.Router(),
-		ReadTimeout:  cmd.ReadTimeout,
-		WriteTimeout: cmd.WriteTimeout,
+		ImageProcessor:    &image.SingleFlightProcessor{Processor: imageProcessor},
+		Database:          database,
+		HealthChecker:     checker,
+		Log:               log,
+		HandlerTimeout:    cmd.HandlerTimeout,
+		NoUpscale:         *noUpscale,
+		EncodeEffort:      *webpEffort,
+		EmbedICCProfile:   *embedICCProfile,
+		FormatCache:       formatCache,
+		DPRQuality:        dprQualityMapping,
+		Admission:         admissionController,
+		TimingAllowOrigin: *timingAllowOrigin,
+		SourceModTime:     getModTimeProvider(storage, *storageModTimeTTL),
+		CacheTTLs:         routeCacheTTLs,
+		AutoQualitySSIM:   *autoQualitySSIM,
+		Rounding:          dimensionRounding,
+		Sources:           sourceCache,
+		ExifGPS:           *exifGPS,
+		URLLimits:         handler.URLLimits{MaxLength: *maxURLLength, MaxParams: *maxQueryParams},
+		StrictExtract:     *strictExtract,
+		OptimizeCoding:    *optimizeCoding,
+		Unprocessable:     *unprocessable,
+		Degradation:       degradation,
+		ImageIDPattern:    imageIDRegexp,
+		TenantPattern:     tenantRegexp,
+		SlowRequests:      *slowRequests,
+		DebugParams:       *debugParams,
+		AutoSharpen:       api.AutoSharpen{Ratio: *autoSharpenRatio, Sigma: *autoSharpenSigma},
+		LegacyUserAgents:  legacyRegexp,
+		DefaultDPI:        *defaultDPI,
+		Quota:             requestQuota,
+		NoAutoRotate:      *noAutoRotate,
+		SecurityHeaders:   handler.SecurityHeaders{NoSniff: *noSniff, ContentSecurityPolicy: *contentSecurityPolicy, ReferrerPolicy: *referrerPolicy},
+		QualityPresets:    qualityPresetMapping,
+		BasePath:          routeBasePath,
+		AlphaFormat:       outputAlphaFormat,
+		ProcessingVersion: *processingVersion,
+		SaveDataQuality:   *saveDataQuality,
+		Signer:            urlSigner,
+		DisabledEffects:   disabledEffects,
+		CropBounds:        cropBoundsPolicy,
+		TrailingSlash:     trailingSlashPolicy,
+		QualityBounds:     qualityBoundsPolicy,
+		EncodeFallback:    encodeFallback,
+		SizeQuality:       sizeQualityMapping,
+		DownloadJobs:      downloadJobs,
+		LosslessThreshold: *losslessThreshold,
+		MaxBlurRatio:      *maxBlurRatio,
+		SourceHeader:      *sourceFormatHeader,
+		Bandwidth:         bandwidth,
+		MaxEffects:        *maxEffects,
+		AsyncJobs:         asyncJobs,
+		Filters:           filterPresets,
 	}
+	server := cmd.NewServer(*listen, api.Router(), serverTimeouts)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil {
@@ -131,29 +499,62 @@ func main() {
 
 	log.Infof("http server listening on %s", *listen)
 
+	// Serve the metrics on their own listener, so that they're only reachable from the internal network
+	var metricsServer *http.Server
+	if *metricsListen != "" {
+		metricsRouter := http.NewServeMux()
+		metricsRouter.Handle("/debug/vars", handler.Metrics())
+		metricsServer = cmd.NewServer(*metricsListen, metricsRouter, serverTimeouts)
+
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("shutting down the metrics server: %s", err)
+				shutdown()
+			}
+		}()
+
+		log.Infof("metrics server listening on %s", *metricsListen)
+	}
+
 	// Wait for shutdown or error
 	err = cmd.WaitForInterrupt(shutdownCtx)
 	log.Infof("shutting down: %s", err)
 
 	// Shut down http server
-	serverCtx, serverCancel := context.WithTimeout(context.Background(), cmd.WriteTimeout)
+	serverCtx, serverCancel := context.WithTimeout(context.Background(), serverTimeouts.Write)
 	defer serverCancel()
 	if err := server.Shutdown(serverCtx); err != nil {
 		log.Warnf("error shutting down: %s", err)
 	}
+
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	// Finish writing back the images that were found in a later storage backend
+	if tieredStorage, ok := storage.(*tiered.Provider); ok {
+		tieredStorage.Wait()
+	}
 }
 
-func setupBackends() (storage storage.Provider, cache cache.Provider, database database.Provider, err error) {
-	// Storage
-	switch *storageBackend {
-	case "file":
-		storage, err = fileStorage.New(*storageFilePath)
-	case "spaces":
-		storage, err = spaces.New(*storageSpacesSpace, *storageSpacesRegion, *storageSpacesAccessKey, *storageSpacesSecretKey)
-	default:
-		err = fmt.Errorf("invalid storage backend")
+// getModTimeProvider returns the storage backend as a ModTimeProvider if it can report when images were modified, to support If-Modified-Since
+// The modification times are cached for the ttl, so that requests don't ask the storage for each of their source images
+func getModTimeProvider(provider storage.Provider, ttl time.Duration) storage.ModTimeProvider {
+	modTimeProvider, ok := provider.(storage.ModTimeProvider)
+	if !ok {
+		return nil
 	}
 
+	if ttl <= 0 {
+		return modTimeProvider
+	}
+
+	return modtime.New(modTimeProvider, lru.New(modTimeCacheSize, ttl))
+}
+
+func setupBackends(log *logger.Logger) (storage storage.Provider, cache cache.Provider, database database.Provider, err error) {
+	// Storage
+	storage, err = setupStorage(log)
 	if err != nil {
 		return
 	}
@@ -184,3 +585,32 @@ func setupBackends() (storage storage.Provider, cache cache.Provider, database d
 
 	return
 }
+
+// setupStorage sets up the storage backend, or a tiered storage trying each of the backends in order when there's more than one
+func setupStorage(log *logger.Logger) (storage.Provider, error) {
+	var tiers []storage.Provider
+	for _, backend := range strings.Split(*storageBackend, ",") {
+		var tier storage.Provider
+		var err error
+		switch strings.TrimSpace(backend) {
+		case "file":
+			tier, err = fileStorage.New(*storageFilePath)
+		case "spaces":
+			tier, err = spaces.New(*storageSpacesSpace, *storageSpacesRegion, *storageSpacesAccessKey, *storageSpacesSecretKey)
+		default:
+			err = fmt.Errorf("invalid storage backend")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	if len(tiers) == 1 {
+		return tiers[0], nil
+	}
+
+	return tiered.New(log, tiers, *storageWriteBack), nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"net/http"
+	"time"
 
 	"github.com/DMarby/picsum-photos/internal/api"
 	"github.com/DMarby/picsum-photos/internal/cmd"
@@ -12,8 +12,13 @@ import (
 	"github.com/DMarby/picsum-photos/internal/database"
 	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
 	"github.com/DMarby/picsum-photos/internal/database/postgresql"
+	"github.com/DMarby/picsum-photos/internal/handler"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/quota"
+	memoryQuota "github.com/DMarby/picsum-photos/internal/quota/memory"
+	"github.com/DMarby/picsum-photos/internal/signature"
 
 	"github.com/jamiealquiza/envy"
 	"go.uber.org/zap"
@@ -26,10 +31,48 @@ const (
 // Comandline flags
 var (
 	// Global
-	listen          = flag.String("listen", ":8080", "listen address")
-	rootURL         = flag.String("root-url", "https://picsum.photos", "root url")
-	imageServiceURL = flag.String("image-service-url", "https://i.picsum.photos", "image service url")
-	loglevel        = zap.LevelFlag("log-level", zap.InfoLevel, "log level (default \"info\") (debug, info, warn, error, dpanic, panic, fatal)")
+	listen                = flag.String("listen", ":8080", "listen address")
+	readTimeout           = flag.Duration("read-timeout", cmd.DefaultTimeouts.Read, "max duration of reading a whole request")
+	readHeaderTimeout     = flag.Duration("read-header-timeout", cmd.DefaultTimeouts.ReadHeader, "max duration of reading the headers of a request, which limits slow clients holding on to connections")
+	writeTimeout          = flag.Duration("write-timeout", cmd.DefaultTimeouts.Write, "max duration of writing a response, has to be longer than the timeout of the handlers so that slow encodes still get a response")
+	idleTimeout           = flag.Duration("idle-timeout", cmd.DefaultTimeouts.Idle, "max duration of keeping an idle keep-alive connection open")
+	rootURL               = flag.String("root-url", "https://picsum.photos", "root url")
+	imageServiceURL       = flag.String("image-service-url", "https://i.picsum.photos", "image service url, including the base path of the image service if it has one")
+	maxURLLength          = flag.Int("max-url-length", handler.DefaultURLLimits.MaxLength, "max length of request urls, longer urls get a 414 (0 to disable)")
+	maxQueryParams        = flag.Int("max-query-params", handler.DefaultURLLimits.MaxParams, "max amount of query params in request urls, more params get a 400 (0 to disable)")
+	unprocessable         = flag.Bool("unprocessable-params", false, "respond with 422 instead of 400 to params that are well-formed, but out of range or contradicting each other")
+	imageIDPattern        = flag.String("image-id-pattern", params.DefaultImageIDPattern, "regular expression that the whole image id has to match, checked before looking up the image (empty to allow any id)")
+	tenantPattern         = flag.String("tenant-pattern", "", "regular expression that the whole tenant has to match, enables the /t/{tenant} routes that scope the images, lists and random images to the tenant, and leaves the images of tenants out of the other routes, for example \"acme|globex\" (disabled by default)")
+	slowRequests          = flag.Duration("log-slow-requests", 0, "log requests that take longer than this, and requests that fail with a server error, at the info level (0 to disable)")
+	dailyQuota            = flag.Int("daily-quota", 0, "max amount of requests from each client ip within the quota window, more requests get a 429 (0 to disable)")
+	quotaWindow           = flag.Duration("quota-window", 24*time.Hour, "length of the quota window, windows of whole days start at midnight utc")
+	quotaRolling          = flag.Bool("quota-rolling", false, "start the quota window at the first request of each client, instead of at fixed times")
+	quotaExempt           = flag.String("quota-exempt", "", "comma separated list of networks of trusted clients that aren't limited by the quota, for example \"10.0.0.0/8,192.0.2.1\"")
+	quotaProxies          = flag.String("quota-trusted-proxies", "", "comma separated list of networks of the proxies in front of the service, whose requests are counted against the client ip from X-Forwarded-For or Forwarded, for example \"10.0.0.0/8\"")
+	noSniff               = flag.Bool("nosniff", handler.DefaultSecurityHeaders.NoSniff, "set the X-Content-Type-Options: nosniff header, so that browsers don't sniff the content type of responses")
+	contentSecurityPolicy = flag.String("content-security-policy", handler.DefaultSecurityHeaders.ContentSecurityPolicy, "value of the Content-Security-Policy header of responses (empty to not set it)")
+	referrerPolicy        = flag.String("referrer-policy", handler.DefaultSecurityHeaders.ReferrerPolicy, "value of the Referrer-Policy header of responses (empty to not set it)")
+	basePath              = flag.String("base-path", "", "path to serve the routes under, such as /images when running behind a reverse proxy that serves them under a path (defaults to the root)")
+	trailingSlash         = flag.String("trailing-slash", "redirect", "how paths with a trailing slash are handled, redirecting them to the path without it or serving them the same (redirect, accept)")
+	alphaFormat           = flag.String("alpha-format", "", "format to output masked images, and images with a background with alpha, in when they're requested as .jpg, which has no alpha channel (webp, empty to reject those requests)")
+	signingKey            = flag.String("signing-key", "", "key that signs the image service urls it redirects to, must match between the services (empty to disable signing)")
+	disableEffects        = flag.String("disable-effects", "", "comma separated list of the effects to disable, by their names in the capabilities, for example \"blend,overlay,text\" (empty to enable all of them)")
+	ignoreDisabledEffects = flag.Bool("ignore-disabled-effects", false, "ignore the disabled effects in requests, instead of rejecting the request")
+	maxEffects            = flag.Int("max-effects", 0, "most distinct effects a request can combine, by their names in the capabilities, rejecting the requests with more (0 for no limit)")
+	filters               = flag.String("filters", params.DefaultFilters, "semicolon separated list of the filters of the filter param, in the form name=query with the color and tonal adjustments and the vignette, for example \"vintage=sepia=40&contrast=-10;noir=grayscale&contrast=30\"")
+	loglevel              = zap.LevelFlag("log-level", zap.InfoLevel, "log level (default \"info\") (debug, info, warn, error, dpanic, panic, fatal)")
+
+	// Images
+	noUpscale     = flag.Bool("no-upscale", false, "don't upscale images beyond their native size")
+	rounding      = flag.String("dimension-rounding", "round", "how fractional pixel dimensions are rounded to whole pixels, must match between the services (round, floor, ceil)")
+	cropBounds    = flag.String("crop-bounds", "strict", "how crops that extend outside of the source image are handled, rejecting them or intersecting them with the image (strict, clamp)")
+	qualityBounds = flag.String("quality-bounds", "", "semicolon separated list of formats with the range of the quality param for them, for example \"jpeg:1-90;webp:10-85\" (unbounded by default)")
+	clampQuality  = flag.Bool("clamp-quality", false, "clamp qualities outside of the quality bounds to them, instead of rejecting the request")
+	fit           = flag.String("default-fit", "cover", "how images are resized when the fit isn't set in the url (cover, contain, fill, inside, outside, pad)")
+	ignoreAuto    = flag.Bool("ignore-unknown-auto", false, "ignore unsupported features in the auto param, instead of rejecting the request")
+	clientHints   = flag.Bool("client-hints", false, "request the Sec-CH-Width and Sec-CH-DPR client hints, and use them when the width or dpr isn't set in the url")
+	presets       = flag.String("presets", "og=1200x630", "semicolon separated list of image presets, in the form name=widthxheight?query")
+	cacheTTLs     = flag.String("cache-ttls", "", "semicolon separated list of cache ttls overriding the Cache-Control header of successful responses, in the form pattern=duration, for example \"/v2/list=1m;/id/*/info=1h\" (disabled by default)")
 
 	// Database
 	databaseBackend = flag.String("database", "file", "which database backend to use (file, postgresql)")
@@ -63,6 +106,96 @@ func main() {
 	}
 	defer database.Shutdown()
 
+	// Parse the image presets
+	imagePresets, err := params.ParsePresets(*presets)
+	if err != nil {
+		log.Fatalf("error parsing presets: %s", err)
+	}
+
+	// Validate the timeouts of the http server
+	serverTimeouts := cmd.Timeouts{Read: *readTimeout, ReadHeader: *readHeaderTimeout, Write: *writeTimeout, Idle: *idleTimeout}
+	if err := serverTimeouts.Validate(cmd.HandlerTimeout); err != nil {
+		log.Fatalf("error validating timeouts: %s", err)
+	}
+
+	// Parse the dimension rounding
+	dimensionRounding, err := params.ParseRounding(*rounding)
+	if err != nil {
+		log.Fatalf("error parsing dimension rounding: %s", err)
+	}
+
+	// Parse the crop bounds policy
+	cropBoundsPolicy, err := params.ParseCropBounds(*cropBounds)
+	if err != nil {
+		log.Fatalf("error parsing crop bounds: %s", err)
+	}
+
+	// Parse the bounds of the quality param
+	qualityBoundsPolicy, err := params.ParseQualityBounds(*qualityBounds, *clampQuality)
+	if err != nil {
+		log.Fatalf("error parsing quality bounds: %s", err)
+	}
+
+	// Parse the filters of the filter param
+	filterPresets, err := params.ParseFilters(*filters)
+	if err != nil {
+		log.Fatalf("error parsing filters: %s", err)
+	}
+
+	// Parse the default fit
+	defaultFit, err := params.ParseFit(*fit)
+	if err != nil {
+		log.Fatalf("error parsing default fit: %s", err)
+	}
+
+	// Parse the image id pattern
+	imageIDRegexp, err := params.ParseImageIDPattern(*imageIDPattern)
+	if err != nil {
+		log.Fatalf("error parsing image id pattern: %s", err)
+	}
+
+	// Parse the tenant pattern
+	tenantRegexp, err := params.ParseImageIDPattern(*tenantPattern)
+	if err != nil {
+		log.Fatalf("error parsing tenant pattern: %s", err)
+	}
+
+	// Parse the cache ttls
+	routeCacheTTLs, err := handler.ParseCacheTTLs(*cacheTTLs)
+	if err != nil {
+		log.Fatalf("error parsing cache ttls: %s", err)
+	}
+
+	// Parse the base path of the routes
+	routeBasePath, err := handler.ParseBasePath(*basePath)
+	if err != nil {
+		log.Fatalf("error parsing base path: %s", err)
+	}
+
+	// Parse the trailing slash policy
+	trailingSlashPolicy, err := handler.ParseTrailingSlash(*trailingSlash)
+	if err != nil {
+		log.Fatalf("error parsing trailing slash policy: %s", err)
+	}
+
+	// Parse the format for images with alpha
+	outputAlphaFormat, err := params.ParseAlphaFormat(*alphaFormat)
+	if err != nil {
+		log.Fatalf("error parsing alpha format: %s", err)
+	}
+
+	// Parse the effects to disable
+	disabledEffects, err := params.ParseDisabledEffects(*disableEffects, *ignoreDisabledEffects)
+	if err != nil {
+		log.Fatalf("error parsing disabled effects: %s", err)
+	}
+
+	// Sign the urls of the image service, when a key is set
+	var urlSigner *signature.Signer
+	if *signingKey != "" {
+		urlSigner = &signature.Signer{Key: []byte(*signingKey)}
+	}
+
 	// Initialize and start the health checker
 	checkerCtx, checkerCancel := context.WithCancel(context.Background())
 	defer checkerCancel()
@@ -74,22 +207,67 @@ func main() {
 	}
 	go checker.Run()
 
+	// Set up the quota of requests for each client
+	var requestQuota *quota.Quota
+	if *dailyQuota > 0 {
+		if *quotaWindow <= 0 {
+			log.Fatalf("invalid quota window %s, must be positive", *quotaWindow)
+		}
+
+		exemptNetworks, err := quota.ParseNetworks(*quotaExempt)
+		if err != nil {
+			log.Fatalf("error parsing quota exempt networks: %s", err)
+		}
+
+		trustedProxies, err := quota.ParseNetworks(*quotaProxies)
+		if err != nil {
+			log.Fatalf("error parsing quota trusted proxies: %s", err)
+		}
+
+		requestQuota = &quota.Quota{
+			Limit:          *dailyQuota,
+			Window:         *quotaWindow,
+			Rolling:        *quotaRolling,
+			Exempt:         exemptNetworks,
+			TrustedProxies: trustedProxies,
+			Provider:       memoryQuota.New(),
+		}
+	}
+
 	// Start and listen on http
 	api := &api.API{
-		Database:        database,
-		HealthChecker:   checker,
-		Log:             log,
-		RootURL:         *rootURL,
-		ImageServiceURL: *imageServiceURL,
-		StaticPath:      staticPath,
-		HandlerTimeout:  cmd.HandlerTimeout,
-	}
-	server := &http.Server{
-		Addr:         *listen,
-		Handler:      api.Router(),
-		ReadTimeout:  cmd.ReadTimeout,
-		WriteTimeout: cmd.WriteTimeout,
+		Database:          database,
+		HealthChecker:     checker,
+		Log:               log,
+		RootURL:           *rootURL,
+		ImageServiceURL:   *imageServiceURL,
+		StaticPath:        staticPath,
+		HandlerTimeout:    cmd.HandlerTimeout,
+		NoUpscale:         *noUpscale,
+		Presets:           imagePresets,
+		ClientHints:       *clientHints,
+		CacheTTLs:         routeCacheTTLs,
+		Rounding:          dimensionRounding,
+		URLLimits:         handler.URLLimits{MaxLength: *maxURLLength, MaxParams: *maxQueryParams},
+		DefaultFit:        defaultFit,
+		IgnoreUnknownAuto: *ignoreAuto,
+		Unprocessable:     *unprocessable,
+		ImageIDPattern:    imageIDRegexp,
+		TenantPattern:     tenantRegexp,
+		SlowRequests:      *slowRequests,
+		Quota:             requestQuota,
+		SecurityHeaders:   handler.SecurityHeaders{NoSniff: *noSniff, ContentSecurityPolicy: *contentSecurityPolicy, ReferrerPolicy: *referrerPolicy},
+		BasePath:          routeBasePath,
+		AlphaFormat:       outputAlphaFormat,
+		Signer:            urlSigner,
+		DisabledEffects:   disabledEffects,
+		CropBounds:        cropBoundsPolicy,
+		TrailingSlash:     trailingSlashPolicy,
+		QualityBounds:     qualityBoundsPolicy,
+		MaxEffects:        *maxEffects,
+		Filters:           filterPresets,
 	}
+	server := cmd.NewServer(*listen, api.Router(), serverTimeouts)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil {
@@ -105,7 +283,7 @@ func main() {
 	log.Infof("shutting down: %s", err)
 
 	// Shut down http server
-	serverCtx, serverCancel := context.WithTimeout(context.Background(), cmd.WriteTimeout)
+	serverCtx, serverCancel := context.WithTimeout(context.Background(), serverTimeouts.Write)
 	defer serverCancel()
 	if err := server.Shutdown(serverCtx); err != nil {
 		log.Warnf("error shutting down: %s", err)
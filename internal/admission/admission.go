@@ -0,0 +1,122 @@
+package admission
+
+import (
+	"sync"
+	"time"
+)
+
+// minRetryAfter is the shortest delay suggested to rejected clients, as Retry-After is in whole seconds
+const minRetryAfter = time.Second
+
+// averageWeight is the weight of each processing time in the moving average
+const averageWeight = 0.1
+
+// Controller limits the amount of image processing that can be in flight at once, based on a pixel budget
+// The pixel count of a request is used as an estimate of how much memory processing it will use
+type Controller struct {
+	budget   int64
+	inUse    int64
+	inFlight int64
+	rejected int64
+	average  time.Duration
+	mutex    sync.Mutex
+}
+
+// Stats contains the current usage of the controller
+type Stats struct {
+	Budget   int64   `json:"budget"`
+	InUse    int64   `json:"in_use"`
+	InFlight int64   `json:"in_flight"`
+	Rejected int64   `json:"rejected"`
+	Load     float64 `json:"load"`
+}
+
+// New creates a new controller with a budget of the given amount of pixels
+func New(budget int64) *Controller {
+	return &Controller{
+		budget: budget,
+	}
+}
+
+// Acquire reserves pixels from the budget, and returns whether there was enough left for them
+// A request is always admitted when nothing else is in flight, so that requests larger than the budget can still be processed
+func (c *Controller) Acquire(pixels int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.inFlight > 0 && c.inUse+pixels > c.budget {
+		c.rejected++
+		return false
+	}
+
+	c.inUse += pixels
+	c.inFlight++
+	return true
+}
+
+// Release returns previously acquired pixels to the budget, after processing them for elapsed
+func (c *Controller) Release(pixels int64, elapsed time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.inUse -= pixels
+	c.inFlight--
+
+	// Keep a moving average of the processing time, to estimate how soon the budget frees up again
+	if c.average == 0 {
+		c.average = elapsed
+	} else {
+		c.average += time.Duration(averageWeight * float64(elapsed-c.average))
+	}
+}
+
+// RetryAfter returns a best-effort estimate of how long a rejected request should wait before retrying
+// It's the average processing time, as that's roughly how soon an in-flight request returns its pixels to the budget
+func (c *Controller) RetryAfter() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.average < minRetryAfter {
+		return minRetryAfter
+	}
+
+	return c.average
+}
+
+// InFlight returns the amount of requests that are currently being processed
+func (c *Controller) InFlight() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.inFlight
+}
+
+// Load returns the share of the budget that's in use, which can go above 1 when a request larger than the budget is in flight
+func (c *Controller) Load() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.load()
+}
+
+func (c *Controller) load() float64 {
+	if c.budget <= 0 {
+		return 0
+	}
+
+	return float64(c.inUse) / float64(c.budget)
+}
+
+// Stats returns the current usage of the controller
+func (c *Controller) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return Stats{
+		Budget:   c.budget,
+		InUse:    c.inUse,
+		InFlight: c.inFlight,
+		Rejected: c.rejected,
+		Load:     c.load(),
+	}
+}
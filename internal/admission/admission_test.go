@@ -0,0 +1,107 @@
+package admission_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/admission"
+)
+
+func TestController(t *testing.T) {
+	t.Run("admits requests within the budget", func(t *testing.T) {
+		controller := admission.New(100)
+
+		if !controller.Acquire(40) || !controller.Acquire(60) {
+			t.Error("request within the budget was rejected")
+		}
+
+		if stats := controller.Stats(); stats.InUse != 100 || stats.InFlight != 2 {
+			t.Errorf("wrong stats %#v", stats)
+		}
+	})
+
+	t.Run("rejects requests when the budget is exhausted", func(t *testing.T) {
+		controller := admission.New(100)
+		controller.Acquire(80)
+
+		if controller.Acquire(40) {
+			t.Error("request over the budget was admitted")
+		}
+
+		if stats := controller.Stats(); stats.InUse != 80 || stats.InFlight != 1 || stats.Rejected != 1 {
+			t.Errorf("wrong stats %#v", stats)
+		}
+	})
+
+	t.Run("admits requests again once the budget is released", func(t *testing.T) {
+		controller := admission.New(100)
+		controller.Acquire(80)
+		controller.Release(80, time.Millisecond)
+
+		if !controller.Acquire(40) {
+			t.Error("request was rejected after the budget was released")
+		}
+
+		if stats := controller.Stats(); stats.InUse != 40 || stats.InFlight != 1 {
+			t.Errorf("wrong stats %#v", stats)
+		}
+	})
+
+	t.Run("admits requests larger than the budget when nothing is in flight", func(t *testing.T) {
+		controller := admission.New(100)
+
+		if !controller.Acquire(500) {
+			t.Error("request was rejected with nothing in flight")
+		}
+
+		if controller.Acquire(1) {
+			t.Error("request was admitted with the budget exhausted")
+		}
+	})
+	t.Run("reports the load", func(t *testing.T) {
+		controller := admission.New(100)
+		controller.Acquire(40)
+		controller.Acquire(40)
+
+		if load := controller.Load(); load != 0.8 {
+			t.Errorf("wrong load %f", load)
+		}
+
+		controller.Release(40, time.Millisecond)
+		if stats := controller.Stats(); stats.Load != 0.4 {
+			t.Errorf("wrong stats %#v", stats)
+		}
+	})
+
+	t.Run("estimates the retry delay from the processing time", func(t *testing.T) {
+		controller := admission.New(100)
+
+		if retryAfter := controller.RetryAfter(); retryAfter != time.Second {
+			t.Errorf("wrong retry after without any requests %s", retryAfter)
+		}
+
+		controller.Acquire(10)
+		controller.Release(10, 3*time.Second)
+		if retryAfter := controller.RetryAfter(); retryAfter != 3*time.Second {
+			t.Errorf("wrong retry after %s", retryAfter)
+		}
+
+		// Later requests move the average
+		controller.Acquire(10)
+		controller.Release(10, 13*time.Second)
+		if retryAfter := controller.RetryAfter(); retryAfter != 4*time.Second {
+			t.Errorf("wrong moving average %s", retryAfter)
+		}
+	})
+
+	t.Run("reports the requests in flight", func(t *testing.T) {
+		controller := admission.New(100)
+		controller.Acquire(40)
+		controller.Acquire(40)
+		controller.Acquire(40)
+
+		if inFlight := controller.InFlight(); inFlight != 2 {
+			t.Errorf("wrong requests in flight %d", inFlight)
+		}
+	})
+}
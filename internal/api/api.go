@@ -3,6 +3,8 @@ package api
 import (
 	"net/http"
 	"path"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/DMarby/picsum-photos/internal/handler"
@@ -10,18 +12,44 @@ import (
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/quota"
+	"github.com/DMarby/picsum-photos/internal/signature"
 	"github.com/gorilla/mux"
 )
 
 // API is a http api
 type API struct {
-	Database        database.Provider
-	HealthChecker   *health.Checker
-	Log             *logger.Logger
-	RootURL         string
-	ImageServiceURL string
-	StaticPath      string
-	HandlerTimeout  time.Duration
+	Database          database.Provider
+	HealthChecker     *health.Checker
+	Log               *logger.Logger
+	RootURL           string
+	ImageServiceURL   string
+	StaticPath        string
+	HandlerTimeout    time.Duration
+	NoUpscale         bool
+	Presets           map[string]params.Preset
+	ClientHints       bool
+	CacheTTLs         handler.CacheTTLs
+	Rounding          params.Rounding
+	URLLimits         handler.URLLimits
+	DefaultFit        string
+	IgnoreUnknownAuto bool
+	Unprocessable     bool
+	ImageIDPattern    *regexp.Regexp
+	TenantPattern     *regexp.Regexp
+	SlowRequests      time.Duration
+	Quota             *quota.Quota
+	SecurityHeaders   handler.SecurityHeaders
+	BasePath          string
+	AlphaFormat       string
+	Signer            *signature.Signer
+	DisabledEffects   params.DisabledEffects
+	CropBounds        params.CropBounds
+	TrailingSlash     handler.TrailingSlash
+	QualityBounds     params.QualityBounds
+	MaxEffects        int
+	Filters           params.Filters
 }
 
 // Utility methods for logging
@@ -29,18 +57,165 @@ func (a *API) logError(r *http.Request, message string, err error) {
 	a.Log.Errorw(message, handler.LogFields(r, "error", err)...)
 }
 
+// rootURL returns the url that the routes are served from, for building urls to them
+func (a *API) rootURL() string {
+	return a.RootURL + a.BasePath
+}
+
 // Router returns a http router
 func (a *API) Router() http.Handler {
 	router := mux.NewRouter()
 
 	router.NotFoundHandler = handler.Handler(a.notFoundHandler)
 
-	// Redirect trailing slashes
+	// Ask clients to send the hints used to pick the image size
+	if a.ClientHints {
+		router.Use(acceptClientHints)
+	}
+
+	// Redirect trailing slashes, unless the deployment accepts them, in which case they're stripped before routing
 	router.StrictSlash(true)
 
+	// Mount the routes under the base path, when running behind a reverse proxy that serves them under a path
+	routes := router
+	if a.BasePath != "" {
+		routes = router.PathPrefix(a.BasePath).Subrouter()
+	}
+
 	// Healthcheck
-	router.Handle("/health", handler.Health(a.HealthChecker)).Methods("GET")
+	routes.Handle("/health", handler.Health(a.HealthChecker)).Methods("GET")
+
+	// Image list and random image routes
+	a.listRoutes(routes)
+
+	// Image by ID routes
+	a.imageRoutes(routes)
+
+	// The same routes for the images of tenants, under /t/{tenant}, where the lists and random images only include the images of the tenant
+	if a.TenantPattern != nil {
+		tenantRoutes := routes.PathPrefix("/t/{tenant}").Subrouter()
+		a.listRoutes(tenantRoutes)
+		a.imageRoutes(tenantRoutes)
+	}
+
+	// Capabilities
+	routes.Handle("/capabilities", handler.Handler(a.capabilitiesHandler)).Methods("GET")
 
+	// Query parameters:
+	// ?grayscale - Grayscale the image
+	// ?saturation={amount} - Multiply the saturation of the image by {amount} (0-3), saturation=0 is the same as grayscale
+	// ?vibrance={amount} - Boost (or mute) the least saturated colors by {amount} (-100-100), applied after the saturation
+	// ?colorize={hue} - Recolor the image to the single {hue} (0-360), keeping its lightness
+	// ?colorize={color} - Recolor the image to the hue and chroma of the hex or named {color}, such as colorize=ff8800
+	// ?sepia={amount} - Tone the image sepia by {amount} (0-100), blending it with the original colors, defaults to 100 without an amount, applied to grayscale images as well
+	// ?gamma={gamma} - Gamma correct the image by {gamma} (0.1-3), where above 1 brightens the midtones, applied after the color adjustments and before the threshold
+	// ?brightness={amount} - Brighten (or darken) the image by {amount} (-100-100) percent, applied after the gamma correction
+	// ?contrast={amount} - Increase (or reduce) the contrast of the image by {amount} (-100-100), applied after the brightness, contrast=-100 is flat gray
+	// ?vignette - Darken the image toward its edges, vignette={intensity} (0-100) sets how dark the corners get, defaults to 50 without an intensity, applied after the contrast and relative to the size of the image
+	// ?bri, ?con and ?sat - Short aliases of brightness, contrast and saturation used by other image services, the long names take precedence
+	// ?filter={name} - Apply the named combination of adjustments from the config, such as filter=vintage, the adjustments in the request take precedence over the ones of the filter
+	// ?threshold={level} - Convert the image to pure black and white, with the pixels at or above the luminance {level} (0-255) becoming white
+	// ?threshold={level}&dither - Dither the black and white image, so that gray areas become a pattern of black and white pixels
+	// ?blur - Blur the image
+	// ?blur={amount} - Blur the image by {amount}, blur=0 disables blur
+	// ?blur&placeholder - Use faster, lower quality resizing and blur, for low quality image placeholders, blurred images up to 64x64 always use it
+	// ?blur&blurbefore - Blur the image at its native resolution, up to 4 times the requested size, before resizing it, which is smoother for downscaled images but slower, as the blur is scaled up with the image
+	// ?blur&bluredge={edge} - Fill in the pixels past the edges of the image with {edge} (extend, mirror, wrap) when blurring, defaults to extend
+	// ?blur&blurscale={scale} - Apply the blur amount as is at every size (absolute), or as the blur of a 500px image scaled with the longest side of the image (relative), defaults to absolute
+	// ?blur&blurregion={x},{y},{width},{height} - Only blur the region of the resized image, in pixels from the top left before padding it to ?ratio
+	// ?sharpen - Sharpen the image after resizing it
+	// ?sharpen={amount} - Sharpen the image by {amount} (0-5), sharpen=0 disables sharpening, including the default sharpening of downscaled images on deployments that enable it
+	// Boolean params can be disabled explicitly, such as ?grayscale=false
+	// Extensions are case insensitive, and paths with a trailing slash are redirected to the path without it, unless the deployment accepts them as the same path
+	// ?fit={fit} - Resize the image with {fit} (cover, contain, fill, inside, outside, pad) (defaults to cover, or the default fit of the deployment), fit=crop is an alias of cover
+	// fit=inside resizes the image to fit within the size without upscaling it, and fit=outside to cover the size, both keeping the aspect ratio of the image
+	// The returned image has the resized dimensions rather than the requested ones, and with noupscale, fit=outside is limited to the size of the image as well
+	// fit=pad resizes the image to fit within the size like fit=contain, and always pads it to exactly the requested size with bg (defaults to white), even with noupscale
+	// ?resize-filter={filter} - Resize the image using {filter} (lanczos, cubic, linear, nearest)
+	// ?effort={level} - Encode the image with effort {level} (0-6, WebP only)
+	// ?colorspace={colorspace} - Convert the image to {colorspace} (srgb, p3)
+	// ?format=auto - Return the smallest of the formats the client accepts
+	// ?quality={quality} - Encode the image with {quality} (1-100), or within the quality bounds of the format when the deployment configures them, where qualities outside of the bounds are rejected or clamped to them
+	// ?quality={low,medium,high} - Encode the image with the quality the image service maps the preset to for the format
+	// ?quality=auto - Encode the image with the lowest quality that looks the same as the full quality image, images larger than 2048x2048 pixels keep the default quality
+	// ?auto={features} - Comma separated shorthand for the automatic features, auto=compress is quality=auto and auto=format is format=auto
+	// ?lossless - Encode the image losslessly (WebP only)
+	// ?lossless=auto - Encode graphics losslessly and photos lossy, by how much of the image its main colors cover (WebP only)
+	// ?nearlossless={level} - Encode the image near losslessly, preprocessing it with {level} (0-100, where 100 is the same as lossless) to compress better (WebP only)
+	// lossless and nearlossless can't be combined with each other, or with quality
+	// ?dpr={dpr} - Scale the image by the device pixel ratio {dpr} (1-4)
+	// ?ratio={width}:{height} - Pad the image to the aspect ratio {width}:{height}
+	// ?bg={color} - Pad the image with the hex or named {color}, such as bg=000000 or bg=black (defaults to white), also pads images with fit=contain to the requested size
+	// ?bg=transparent - Leave the padding transparent, only with the .webp extension, unless -alpha-format is set to serve .jpg requests with a background with alpha as WebP
+	// ?bg={color}{alpha} - Pad the image with the hex {color} at the 2 digit hex {alpha}, such as bg=00000080 for half transparent black, or 4 digits such as bg=0008, with the same extensions as bg=transparent
+	// ?flatten - Flatten transparent areas of the image onto the background {color} of bg (defaults to white), which JPEG images always are as they have no alpha channel
+	// ?frame={frame} - Use the 0-indexed {frame} of an animated image
+	// ?crop={x},{y},{width},{height} - Crop the source image to the region before resizing it, either in pixels or in percent of the source dimensions such as crop=10%,10%,80%,80%
+	// ?crop=ar:{width}:{height} - Crop the source image to the largest region of the aspect ratio {width}:{height}, placed by gravity (defaults to center), a width/height of 0 keeps its native resolution
+	// ?trim - Trim the borders of the image that match the color of the top left corner
+	// ?trimcolor={color} - Trim the borders of the image that match the hex or named {color}
+	// ?trimtol={tolerance} - Trim colors within {tolerance} of the border color (0-255, defaults to 10)
+	// ?text={text} - Draw {text} on the image, an empty text draws the dimensions of the image (max 100 characters), sized to the smaller side of the image and shrunk to fit within it
+	// ?textcolor={color} - Draw the text in the hex or named {color} (defaults to white)
+	// ?gravity={gravity} - Place the text and the aspect ratio crop at {gravity} (center, north, northeast, east, southeast, south, southwest, west, northwest)
+	// ?orient={orientation} - Use the EXIF {orientation} (1-8) instead of the one in the image
+	// ?autorotate={bool} - Whether to rotate the image by its EXIF orientation, overriding the default of the deployment, can't be combined with orient
+	// ?blend={id} - Blend the image with {id} on top of it
+	// ?blendmode={mode} - Blend the images using {mode} (normal, multiply, screen, overlay)
+	// ?blendopacity={opacity} - Blend the image on top with {opacity} (0-1)
+	// ?overlay={id} - Place {id} on top of the image, fit within a percentage of its size
+	// ?overlaypos={gravity} - Place the overlay at {gravity} (center, north, northeast, east, southeast, south, southwest, west, northwest), defaults to southeast
+	// ?overlayopacity={opacity} - Place the overlay with {opacity} (0-100)
+	// ?overlaysize={percent} - Fit the overlay within {percent} (1-100) of the size of the image, defaults to 20
+	// ?mask={id} - Use {id} as a grayscale mask for the alpha channel of the image, requires the .webp extension, unless -alpha-format is set to serve masked .jpg requests as WebP
+	// ?invertregion={x},{y},{width},{height} - Invert the colors of the region of the returned image, in pixels from the top left
+	// ?colorblind={type} - Simulate how the image looks with the {type} of color blindness (protanopia, deuteranopia, tritanopia), applied after the other effects
+	// ?extract={channel} - Output only the {channel} as a grayscale image (red, green, blue, alpha, luminance), images without alpha have an opaque alpha channel
+	// ?noupscale - Don't upscale the image beyond its native size
+	// Redirects for images that noupscale clamps have the headers X-Image-Clamped: true and X-Image-Dimensions: {width}x{height} with the size the image was scaled to
+	// ?dpi={dpi} - Embed the resolution {dpi} (1-2400) in the image for printing, without changing its pixels, requires the .jpg extension
+	// ?pixelformat={format} - Lay out the uncompressed pixels of images with the .bin extension as {format} (rgb8, rgba8, gray8) (defaults to rgb8)
+	// The .bin extension returns the raw 8 bit pixels row by row, with the layout in the X-Pixel-Format, X-Pixel-Width, X-Pixel-Height and X-Pixel-Channels headers
+	// Params that depend on another param, such as ?gravity without ?text, are rejected rather than ignored
+	// Deployments can limit how many distinct effects of the capabilities a request combines, where requests with more are rejected, with the params of an effect counting as one
+	// The effects are applied in the order of effect_order in /capabilities: blur, sharpen, saturation, vibrance, colorize, sepia, gamma, brightness, contrast, vignette, threshold, pad (ratio and bg), text, invertregion, colorblind
+	// Grayscale is applied while resizing and blend before the effects, and overlay, mask and extract after them
+
+	// Deprecated query parameters:
+	// ?image={id} - Get image by id
+
+	// Deprecated routes
+	routes.Handle("/list", handler.Handler(a.deprecatedListHandler)).Methods("GET")
+	routes.Handle("/g/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.deprecatedImageHandler)).Methods("GET")
+	routes.Handle("/g/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.deprecatedImageHandler)).Methods("GET")
+
+	// Static files
+	routes.HandleFunc("/", serveFile(path.Join(a.StaticPath, "index.html")))
+	routes.HandleFunc("/images", serveFile(path.Join(a.StaticPath, "images.html")))
+	routes.HandleFunc("/favicon.ico", serveFile(path.Join(a.StaticPath, "assets/images/favicon/favicon.ico")))
+	routes.PathPrefix("/assets/").HandlerFunc(fileHeaders(http.StripPrefix(a.BasePath+"/assets/", http.FileServer(http.Dir(path.Join(a.StaticPath, "assets/")))).ServeHTTP))
+
+	// Set up handlers for adding a request id, handling panics, request logging, setting CORS and security headers, limiting the requests of each client to the quota, limiting the url size, cache ttls, handler execution timeout, and trailing slashes
+	// They're wrapped around the router from the innermost to the outermost, so a request passes through them from the bottom up
+	// The request id and the panic recovery wrap everything so that they cover the logging, and the quota is checked before any work is done
+	// The static assets keep their trailing slashes, as the file server redirects directories to them
+	var h http.Handler = router
+	h = handler.StripTrailingSlash(a.TrailingSlash, a.BasePath, []string{"/assets/"}, h)
+	h = http.TimeoutHandler(h, a.HandlerTimeout, "Something went wrong. Timed out.")
+	h = handler.Compress(h)
+	h = handler.CacheControl(a.CacheTTLs, h)
+	h = handler.LimitURL(a.URLLimits, h)
+	h = handler.Quota(a.Log, a.Quota, h)
+	h = handler.AddSecurityHeaders(a.SecurityHeaders, h)
+	h = handler.CORS(nil, h)
+	h = handler.Logger(a.Log, a.SlowRequests, h)
+	h = handler.Recovery(a.Log, h)
+	return handler.AddRequestID(h)
+}
+
+// imageRoutes adds the routes for images by id to the router
+// listRoutes registers the image list and random image routes on the router
+func (a *API) listRoutes(router *mux.Router) {
 	// Image list
 	router.Handle("/v2/list", handler.Handler(a.listHandler)).Methods("GET")
 
@@ -48,45 +223,63 @@ func (a *API) Router() http.Handler {
 	// ?page={page} - What page to display
 	// ?limit={limit} - How many entries to display per page
 
+	// Random image list
+	router.Handle("/random", handler.Handler(a.randomListHandler)).Methods("GET")
+
+	// Query parameters:
+	// ?count={count} - How many distinct images to include (1-100, defaults to 1), fewer when there aren't that many images
+	// ?seed={seed} - Seed the selection, so that the same seed returns the same images
+
 	// Image routes
 	oldRouter := router.PathPrefix("").Subrouter()
 	oldRouter.Use(a.deprecatedParams)
 
-	oldRouter.Handle("/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.randomImageRedirectHandler)).Methods("GET")
-	oldRouter.Handle("/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.randomImageRedirectHandler)).Methods("GET")
+	oldRouter.Handle("/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.randomImageRedirectHandler)).Methods("GET", "HEAD")
+	oldRouter.Handle("/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.randomImageRedirectHandler)).Methods("GET", "HEAD")
+	oldRouter.Handle("/{dimensions:[0-9]*x[0-9x]*}{extension:(?:\\..*)?}", handler.Handler(a.randomImageRedirectHandler)).Methods("GET", "HEAD")
 
-	// Image by ID routes
-	router.Handle("/id/{id}/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.imageRedirectHandler)).Methods("GET")
-	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.imageRedirectHandler)).Methods("GET")
+	// Image by seed routes
+	router.Handle("/seed/{seed}/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET", "HEAD")
+	router.Handle("/seed/{seed}/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET", "HEAD")
+	router.Handle("/seed/{seed}/{dimensions:[0-9]*x[0-9x]*}{extension:(?:\\..*)?}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET", "HEAD")
+	router.Handle("/seed/{seed}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET", "HEAD")
+}
 
-	// Image info routes
-	router.Handle("/id/{id}/info", handler.Handler(a.infoHandler)).Methods("GET")
+func (a *API) imageRoutes(router *mux.Router) {
+	router.Handle("/id/{id}/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.imageRedirectHandler)).Methods("GET", "HEAD")
+	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.imageRedirectHandler)).Methods("GET", "HEAD")
+	router.Handle("/id/{id}/{dimensions:[0-9]*x[0-9x]*}{extension:(?:\\..*)?}", handler.Handler(a.imageRedirectHandler)).Methods("GET", "HEAD")
 
-	// Image by seed routes
-	router.Handle("/seed/{seed}/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET")
-	router.Handle("/seed/{seed}/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.seedImageRedirectHandler)).Methods("GET")
+	// Image by id with the size in the query params
+	// ?w={width}&h={height} - Size of the image, where both have to be at least 1 like in ?size
+	// ?size={width}x{height} - Size of the image in a single param, which takes precedence over ?w and ?h
+	router.Handle("/id/{id}", handler.Handler(a.imageRedirectHandler)).Methods("GET", "HEAD")
 
-	// Query parameters:
-	// ?grayscale - Grayscale the image
-	// ?blur - Blur the image
-	// ?blur={amount} - Blur the image by {amount}
+	// Describe the params the image routes accept to OPTIONS requests
+	imageOptions := handler.Options([]string{"GET", "HEAD"}, params.DescribeRoute(params.DescribeImageParams()))
+	router.Handle("/id/{id}/{size:[0-9]+}{extension:(?:\\..*)?}", imageOptions).Methods("OPTIONS")
+	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", imageOptions).Methods("OPTIONS")
+	router.Handle("/id/{id}/{dimensions:[0-9]*x[0-9x]*}{extension:(?:\\..*)?}", imageOptions).Methods("OPTIONS")
+	router.Handle("/id/{id}", handler.Options([]string{"GET", "HEAD"}, params.DescribeRoute(params.DescribeSizeParams(), params.DescribeImageParams()))).Methods("OPTIONS")
 
-	// Deprecated query parameters:
-	// ?image={id} - Get image by id
+	// Image by preset routes
+	router.Handle("/id/{id}/preset/{preset:[a-zA-Z0-9_-]+}{extension:(?:\\..*)?}", handler.Handler(a.presetImageRedirectHandler)).Methods("GET", "HEAD")
 
-	// Deprecated routes
-	router.Handle("/list", handler.Handler(a.deprecatedListHandler)).Methods("GET")
-	router.Handle("/g/{size:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.deprecatedImageHandler)).Methods("GET")
-	router.Handle("/g/{width:[0-9]+}/{height:[0-9]+}{extension:(?:\\..*)?}", handler.Handler(a.deprecatedImageHandler)).Methods("GET")
+	// Image info routes
+	router.Handle("/id/{id}/info", handler.Handler(a.infoHandler)).Methods("GET")
 
-	// Static files
-	router.HandleFunc("/", serveFile(path.Join(a.StaticPath, "index.html")))
-	router.HandleFunc("/images", serveFile(path.Join(a.StaticPath, "images.html")))
-	router.HandleFunc("/favicon.ico", serveFile(path.Join(a.StaticPath, "assets/images/favicon/favicon.ico")))
-	router.PathPrefix("/assets/").HandlerFunc(fileHeaders(http.StripPrefix("/assets/", http.FileServer(http.Dir(path.Join(a.StaticPath, "assets/")))).ServeHTTP))
+	// Info about several images at once, keyed by their ids
+	// ?ids={ids} - Comma separated list of up to 100 ids, where the ids of images that don't exist are null
+	router.Handle("/info", handler.Handler(a.bulkInfoHandler)).Methods("GET")
+
+	// Image aspect ratio routes
+	router.Handle("/id/{id}/aspect", handler.Handler(a.aspectHandler)).Methods("GET")
 
-	// Set up handlers for adding a request id, handling panics, request logging, setting CORS headers, and handler execution timeout
-	return handler.AddRequestID(handler.Recovery(a.Log, handler.Logger(a.Log, handler.CORS(nil, http.TimeoutHandler(router, a.HandlerTimeout, "Something went wrong. Timed out.")))))
+	// Image srcset routes
+	// ?widths={widths} - Comma separated list of widths to include
+	// ?w={width}&dprs={dprs} - Comma separated list of device pixel ratios of {width} to include, instead of widths
+	// ?fm={format} - Format of the images (jpg, webp)
+	router.Handle("/id/{id}/srcset", handler.Handler(a.srcSetHandler)).Methods("GET")
 }
 
 // Handle not found errors
@@ -113,3 +306,11 @@ func serveFile(name string) func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, name)
 	})
 }
+
+// acceptClientHints is a middleware for sending the Accept-CH header, which asks the client to send the client hints
+func acceptClientHints(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-CH", strings.Join(params.ClientHints, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
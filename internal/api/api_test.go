@@ -7,14 +7,20 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/DMarby/picsum-photos/internal/api"
 	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/signature"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 
 	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
@@ -52,9 +58,11 @@ func TestAPI(t *testing.T) {
 
 	staticPath := "../../web"
 
-	router := (&api.API{db, checker, log, rootURL, imageServiceURL, staticPath, time.Minute}).Router()
-	paginationRouter := (&api.API{dbMultiple, checker, log, rootURL, imageServiceURL, staticPath, time.Minute}).Router()
-	mockDatabaseRouter := (&api.API{&mockDatabase.Provider{}, mockChecker, log, rootURL, imageServiceURL, staticPath, time.Minute}).Router()
+	presets, _ := params.ParsePresets("og=1200x630;thumbnail=100x100?grayscale&quality=60")
+
+	router := (&api.API{Database: db, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: staticPath, HandlerTimeout: time.Minute, Presets: presets}).Router()
+	paginationRouter := (&api.API{Database: dbMultiple, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: staticPath, HandlerTimeout: time.Minute, Presets: presets}).Router()
+	mockDatabaseRouter := (&api.API{Database: &mockDatabase.Provider{}, HealthChecker: mockChecker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: staticPath, HandlerTimeout: time.Minute, Presets: presets}).Router()
 
 	tests := []struct {
 		Name             string
@@ -262,6 +270,64 @@ func TestAPI(t *testing.T) {
 				"Cache-Control": "no-cache, no-store, must-revalidate",
 			},
 		},
+		{
+			Name:             "/id/{id}/srcset returns a srcset",
+			URL:              "/id/1/srcset?widths=300,600",
+			Router:           router,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedResponse: []byte(fmt.Sprintf("%[1]s/id/1/300/400.jpg 300w, %[1]s/id/1/600/800.jpg 600w\n", rootURL)),
+			ExpectedHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+			},
+		},
+		{
+			Name:             "/id/{id}/srcset returns a srcset in the given format",
+			URL:              "/id/1/srcset?widths=150,%20450&fm=webp",
+			Router:           router,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedResponse: []byte(fmt.Sprintf("%[1]s/id/1/150/200.webp 150w, %[1]s/id/1/450/600.webp 450w\n", rootURL)),
+			ExpectedHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+			},
+		},
+		{
+			// Like the widths, the dprs that scale the height of a portrait image past the max image size are left out
+			Name:             "/id/{id}/srcset leaves out dprs that are too tall",
+			URL:              "/id/1/srcset?w=1000&dprs=1,3.75,4",
+			Router:           router,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedResponse: []byte(fmt.Sprintf("%[1]s/id/1/1000/1333.jpg 1x, %[1]s/id/1/3750/5000.jpg 3.75x\n", rootURL)),
+			ExpectedHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+			},
+		},
+		{
+			// The height of a portrait image reaches the max image size first, so the widths past it are left out
+			Name:             "/id/{id}/srcset leaves out widths that are too tall",
+			URL:              "/id/1/srcset?widths=300,3750,4000",
+			Router:           router,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedResponse: []byte(fmt.Sprintf("%[1]s/id/1/300/400.jpg 300w, %[1]s/id/1/3750/5000.jpg 3750w\n", rootURL)),
+			ExpectedHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+			},
+		},
+
+		{
+			Name:             "/id/{id}/srcset returns a srcset with density descriptors",
+			URL:              "/id/1/srcset?w=300&dprs=1,1.5,%203",
+			Router:           router,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedResponse: []byte(fmt.Sprintf("%[1]s/id/1/300/400.jpg 1x, %[1]s/id/1/450/600.jpg 1.5x, %[1]s/id/1/900/1200.jpg 3x\n", rootURL)),
+			ExpectedHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Cache-Control": "no-cache, no-store, must-revalidate",
+			},
+		},
 
 		// Static page handling
 		{"index", "/", router, http.StatusOK, readFile(path.Join(staticPath, "index.html")), map[string]string{"Content-Type": "text/html; charset=utf-8", "Cache-Control": "public, max-age=3600"}},
@@ -270,15 +336,185 @@ func TestAPI(t *testing.T) {
 
 		// Errors
 		{"invalid image id", "/id/nonexistant/200/300", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid image id", "/id/nonexistant/srcset?widths=400", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"missing srcset widths", "/id/1/srcset", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid srcset widths", "/id/1/srcset?widths=400,abc", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset widths larger then max allowed", "/id/1/srcset?widths=400,5001", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset widths too tall for the image", "/id/1/srcset?widths=4000,4500", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid srcset format", "/id/1/srcset?widths=400&fm=png", router, http.StatusBadRequest, []byte("Invalid file extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid srcset dprs", "/id/1/srcset?w=300&dprs=1,abc", router, http.StatusBadRequest, []byte("Invalid dprs\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset dpr larger then max allowed", "/id/1/srcset?w=300&dprs=1,5", router, http.StatusBadRequest, []byte("Invalid dprs\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset dpr larger then max image size", "/id/1/srcset?w=2000&dprs=1,3", router, http.StatusBadRequest, []byte("Invalid dprs\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset dprs too tall for the image", "/id/1/srcset?w=4000&dprs=1,1.2", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"missing srcset width for dprs", "/id/1/srcset?dprs=1,2", router, http.StatusBadRequest, []byte("Invalid widths\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"srcset widths with dprs", "/id/1/srcset?widths=300&w=300&dprs=1,2", router, http.StatusBadRequest, []byte("Conflicting params: widths conflicts with dprs\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"unknown preset", "/id/1/preset/unknown", router, http.StatusNotFound, []byte("Preset does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid preset extension", "/id/1/preset/og.png", router, http.StatusBadRequest, []byte("Invalid file extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid image id", "/id/nonexistant/preset/og", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"invalid image id", "/id/nonexistant/info", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"invalid size", "/id/1/1/9223372036854775808", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},   // Number larger then max int size to fail int parsing
 		{"invalid size", "/id/1/9223372036854775808/1", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},   // Number larger then max int size to fail int parsing
 		{"invalid size", "/id/1/5500/1", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},                  // Number larger then maxImageSize to fail int parsing
 		{"invalid size", "/seed/1/9223372036854775808/1", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}}, // Number larger then maxImageSize to fail int parsing
 		{"invalid size", "/9223372036854775808", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},          // Number larger then maxImageSize to fail int parsing
+		{"invalid size", "/id/1/800x", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},                    // {width}x without a height
+		{"invalid size", "/id/1/xx", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},                      // no width or height
+		{"invalid size", "/id/1/0x600", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},                   // {width}x{height} has to be positive
+		{"invalid size", "/id/1/800x600x1", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},               // too many dimensions
 		{"invalid blur amount", "/id/1/100/100?blur=11", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
-		{"invalid blur amount", "/id/1/100/100?blur=0", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur amount", "/id/1/100/100?blur=-1", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"invalid file extension", "/id/1/100/100.png", router, http.StatusBadRequest, []byte("Invalid file extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid resize filter", "/id/1/100/100?resize-filter=bicubic", router, http.StatusBadRequest, []byte("Invalid resize filter\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100?quality=0", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100?quality=101", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100?quality=best", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100?dpr=0.5", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100?dpr=5", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100?dpr=retina", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid aspect ratio", "/id/1/100/100?ratio=16", router, http.StatusBadRequest, []byte("Invalid aspect ratio\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid aspect ratio", "/id/1/100/100?ratio=16:nine", router, http.StatusBadRequest, []byte("Invalid aspect ratio\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid background", "/id/1/100/100?ratio=16:9&bg=fffff", router, http.StatusBadRequest, []byte("Invalid background color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid background color name", "/id/1/100/100?ratio=16:9&bg=reddish", router, http.StatusBadRequest, []byte("Invalid background color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"transparent background with jpeg", "/id/1/100/100.jpg?ratio=16:9&bg=transparent", router, http.StatusBadRequest, []byte("Transparent background requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"transparent background with format=auto", "/id/1/100/100?ratio=16:9&bg=transparent&format=auto", router, http.StatusBadRequest, []byte("Transparent background requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid background alpha", "/id/1/100/100.webp?ratio=16:9&bg=ff00aazz", router, http.StatusBadRequest, []byte("Invalid background color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"background alpha with a color name", "/id/1/100/100.webp?ratio=16:9&bg=red80", router, http.StatusBadRequest, []byte("Invalid background color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"background with alpha with jpeg", "/id/1/100/100.jpg?ratio=16:9&bg=00000080", router, http.StatusBadRequest, []byte("Transparent background requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"background with alpha with format=auto", "/id/1/100/100?ratio=16:9&bg=0008&format=auto", router, http.StatusBadRequest, []byte("Transparent background requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"padded canvas larger then max allowed", "/id/1/4000/100?ratio=1:2", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid frame", "/id/1/100/100?frame=-1", router, http.StatusBadRequest, []byte("Invalid frame\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid trim color", "/id/1/100/100?trimcolor=whitish", router, http.StatusBadRequest, []byte("Invalid trim color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid trim tolerance", "/id/1/100/100?trim&trimtol=high", router, http.StatusBadRequest, []byte("Invalid trim tolerance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid trim tolerance", "/id/1/100/100?trim&trimtol=256", router, http.StatusBadRequest, []byte("Invalid trim tolerance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid text", "/id/1/100/100?text=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", router, http.StatusBadRequest, []byte("Invalid text\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid text color", "/id/1/100/100?text=hi&textcolor=ff", router, http.StatusBadRequest, []byte("Invalid text color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"transparent text color", "/id/1/100/100?text=hi&textcolor=transparent", router, http.StatusBadRequest, []byte("Invalid text color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid gravity", "/id/1/100/100?text=hi&gravity=up", router, http.StatusBadRequest, []byte("Invalid gravity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid orientation", "/id/1/100/100?orient=0", router, http.StatusBadRequest, []byte("Invalid orientation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid orientation", "/id/1/100/100?orient=9", router, http.StatusBadRequest, []byte("Invalid orientation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid orientation", "/id/1/100/100?orient=up", router, http.StatusBadRequest, []byte("Invalid orientation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend mode", "/id/1/100/100?blend=1&blendmode=darken", router, http.StatusBadRequest, []byte("Invalid blend mode\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend opacity", "/id/1/100/100?blend=1&blendopacity=-0.5", router, http.StatusBadRequest, []byte("Invalid blend opacity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend opacity", "/id/1/100/100?blend=1&blendopacity=half", router, http.StatusBadRequest, []byte("Invalid blend opacity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend image id", "/id/1/100/100?blend=nonexistant", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay position", "/id/1/100/100?overlay=1&overlaypos=top", router, http.StatusBadRequest, []byte("Invalid overlay position\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay opacity", "/id/1/100/100?overlay=1&overlayopacity=101", router, http.StatusBadRequest, []byte("Invalid overlay opacity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay opacity", "/id/1/100/100?overlay=1&overlayopacity=0.5", router, http.StatusBadRequest, []byte("Invalid overlay opacity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay size", "/id/1/100/100?overlay=1&overlaysize=0", router, http.StatusBadRequest, []byte("Invalid overlay size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay image id", "/id/1/100/100?overlay=nonexistant", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur edge", "/id/1/100/100?blur&bluredge=clamp", router, http.StatusBadRequest, []byte("Invalid blur edge\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur scale", "/id/1/100/100?blur&blurscale=percent", router, http.StatusBadRequest, []byte("Invalid blur scale\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"missing query height", "/id/1?w=200", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid query width", "/id/1?w=-1&h=100", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"empty query width", "/id/1?w=0&h=100", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"empty query height", "/id/1?w=100&h=0", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"malformed query size", "/id/1?size=200", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"malformed query size", "/id/1?size=200x", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"malformed query size", "/id/1?size=200x100x50", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid query size", "/id/1?size=0x100", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"query size out of range", "/id/1?size=5500x100", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"query width out of range", "/id/1?w=5500&h=100", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid saturation", "/id/1/100/100?saturation=-1", router, http.StatusBadRequest, []byte("Invalid saturation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid saturation", "/id/1/100/100?saturation=high", router, http.StatusBadRequest, []byte("Invalid saturation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid vibrance", "/id/1/100/100?vibrance=101", router, http.StatusBadRequest, []byte("Invalid vibrance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid vibrance", "/id/1/100/100?vibrance=0.5", router, http.StatusBadRequest, []byte("Invalid vibrance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid colorize hue", "/id/1/100/100?colorize=361", router, http.StatusBadRequest, []byte("Invalid colorize\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid colorize color", "/id/1/100/100?colorize=ff880", router, http.StatusBadRequest, []byte("Invalid colorize\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid pixel format", "/id/1/100/100.bin?pixelformat=rgb16", router, http.StatusBadRequest, []byte("Invalid pixel format\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: pixelformat without .bin", "/id/1/100/100.webp?pixelformat=gray8", router, http.StatusBadRequest, []byte("Conflicting params: pixelformat requires the .bin extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid sepia", "/id/1/100/100?sepia=abc", router, http.StatusBadRequest, []byte("Invalid sepia\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"sepia out of range", "/id/1/100/100?sepia=101", router, http.StatusBadRequest, []byte("Invalid sepia\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"negative sepia", "/id/1/100/100?sepia=-1", router, http.StatusBadRequest, []byte("Invalid sepia\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid extract", "/id/1/100/100?extract=cyan", router, http.StatusBadRequest, []byte("Invalid extract\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid fit", "/id/1/100/100?fit=stretch", router, http.StatusBadRequest, []byte("Invalid fit\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid auto", "/id/1/100/100?auto=compress,enhance", router, http.StatusBadRequest, []byte("Invalid auto\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid gamma", "/id/1/100/100?gamma=abc", router, http.StatusBadRequest, []byte("Invalid gamma\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid gamma", "/id/1/100/100?gamma=0.05", router, http.StatusBadRequest, []byte("Invalid gamma\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid gamma", "/id/1/100/100?gamma=3.5", router, http.StatusBadRequest, []byte("Invalid gamma\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid brightness", "/id/1/100/100?brightness=abc", router, http.StatusBadRequest, []byte("Invalid brightness\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid brightness", "/id/1/100/100?brightness=101", router, http.StatusBadRequest, []byte("Invalid brightness\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid brightness alias", "/id/1/100/100?bri=-101", router, http.StatusBadRequest, []byte("Invalid brightness\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid colorblind", "/id/1/100/100?colorblind=achromatopsia", router, http.StatusBadRequest, []byte("Invalid colorblind\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid contrast", "/id/1/100/100?contrast=1.5", router, http.StatusBadRequest, []byte("Invalid contrast\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid contrast", "/id/1/100/100?contrast=-101", router, http.StatusBadRequest, []byte("Invalid contrast\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid contrast alias", "/id/1/100/100?con=abc", router, http.StatusBadRequest, []byte("Invalid contrast\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid vignette", "/id/1/100/100?vignette=abc", router, http.StatusBadRequest, []byte("Invalid vignette\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"vignette out of range", "/id/1/100/100?vignette=101", router, http.StatusBadRequest, []byte("Invalid vignette\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"negative vignette", "/id/1/100/100?vignette=-1", router, http.StatusBadRequest, []byte("Invalid vignette\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid saturation alias", "/id/1/100/100?sat=4", router, http.StatusBadRequest, []byte("Invalid saturation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: colorize with the saturation alias", "/id/1/100/100?colorize=120&sat=2", router, http.StatusBadRequest, []byte("Conflicting params: colorize conflicts with grayscale, saturation, and vibrance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid threshold", "/id/1/100/100?threshold=abc", router, http.StatusBadRequest, []byte("Invalid threshold\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid threshold", "/id/1/100/100?threshold=256", router, http.StatusBadRequest, []byte("Invalid threshold\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid threshold", "/id/1/100/100?threshold=-1", router, http.StatusBadRequest, []byte("Invalid threshold\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid invert region", "/id/1/100/100?invertregion=0,0,10", router, http.StatusBadRequest, []byte("Invalid invert region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid invert region", "/id/1/100/100?invertregion=-1,0,10,10", router, http.StatusBadRequest, []byte("Invalid invert region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid invert region", "/id/1/100/100?invertregion=0,0,0,10", router, http.StatusBadRequest, []byte("Invalid invert region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur region", "/id/1/100/100?blur&blurregion=0,0,10", router, http.StatusBadRequest, []byte("Invalid blur region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur region", "/id/1/100/100?blur&blurregion=0,0,0,10", router, http.StatusBadRequest, []byte("Invalid blur region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"blur region outside of the image", "/id/1/100/100?blur&blurregion=50,50,60,10", router, http.StatusBadRequest, []byte("Invalid blur region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"blur region outside of the unpadded image", "/id/1/100/100?blur&ratio=2:1&blurregion=150,0,50,100", router, http.StatusBadRequest, []byte("Invalid blur region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"blur region without blur", "/id/1/100/100?blurregion=0,0,10,10", router, http.StatusBadRequest, []byte("Conflicting params: blurregion requires blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invert region outside of the image", "/id/1/100/100?invertregion=50,50,60,10", router, http.StatusBadRequest, []byte("Invalid invert region\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid crop", "/id/1/100/100?crop=0,0,10", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid crop", "/id/1/100/100?crop=-1,0,10,10", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid crop", "/id/1/100/100?crop=0,0,0,10", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid crop", "/id/1/100/100?crop=0,0,10.5,10", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"mixed pixels and percentages", "/id/1/100/100?crop=0,0,50%25,10", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"crop percentage above 100", "/id/1/100/100?crop=0,0,101%25,10%25", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"crop percentages outside of the image", "/id/1/100/100?crop=10%25,0%25,95%25,10%25", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"crop outside of the image", "/id/1/100/100?crop=250,0,100,100", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"overflowing crop", "/id/1/100/100?crop=0,0,9223372036854775807,1", router, http.StatusBadRequest, []byte("Invalid crop\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"crop with trim", "/id/1/100/100?crop=0,0,10,10&trim", router, http.StatusBadRequest, []byte("Conflicting params: crop conflicts with trim\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"autorotate with orient", "/id/1/100/100?autorotate=false&orient=6", router, http.StatusBadRequest, []byte("Conflicting params: autorotate conflicts with orient\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"mask without the webp extension", "/id/1/100/100?mask=1", router, http.StatusBadRequest, []byte("Mask requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"mask with automatic format selection", "/id/1/100/100.webp?mask=1&format=auto", router, http.StatusBadRequest, []byte("Mask requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid mask image id", "/id/1/100/100.webp?mask=nonexistant", router, http.StatusNotFound, []byte("Mask image nonexistant does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid near lossless", "/id/1/100/100?nearlossless=abc", router, http.StatusBadRequest, []byte("Invalid near lossless level\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid near lossless", "/id/1/100/100?nearlossless=-1", router, http.StatusBadRequest, []byte("Invalid near lossless level\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid near lossless", "/id/1/100/100?nearlossless=101", router, http.StatusBadRequest, []byte("Invalid near lossless level\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: lossless with nearlossless", "/id/1/100/100?lossless&nearlossless=60", router, http.StatusBadRequest, []byte("Conflicting params: nearlossless conflicts with lossless\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: lossless with quality", "/id/1/100/100?lossless&quality=80", router, http.StatusBadRequest, []byte("Conflicting params: lossless and nearlossless conflict with quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: lossless with a quality preset", "/id/1/100/100?lossless&quality=low", router, http.StatusBadRequest, []byte("Conflicting params: lossless and nearlossless conflict with quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: nearlossless with auto quality", "/id/1/100/100?nearlossless=60&auto=compress", router, http.StatusBadRequest, []byte("Conflicting params: lossless and nearlossless conflict with quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: auto lossless with nearlossless", "/id/1/100/100?lossless=auto&nearlossless=60", router, http.StatusBadRequest, []byte("Conflicting params: nearlossless conflicts with lossless\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: auto lossless with quality", "/id/1/100/100?lossless=auto&quality=80", router, http.StatusBadRequest, []byte("Conflicting params: lossless and nearlossless conflict with quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid sharpen", "/id/1/100/100?sharpen=abc", router, http.StatusBadRequest, []byte("Invalid sharpen\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid sharpen", "/id/1/100/100?sharpen=-1", router, http.StatusBadRequest, []byte("Invalid sharpen\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid sharpen", "/id/1/100/100?sharpen=6", router, http.StatusBadRequest, []byte("Invalid sharpen\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: sharpen with blur", "/id/1/100/100?sharpen&blur", router, http.StatusBadRequest, []byte("Conflicting params: sharpen conflicts with blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: gravity without text", "/id/1/100/100?gravity=north", router, http.StatusBadRequest, []byte("Conflicting params: gravity requires text or crop=ar\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: textcolor without text", "/id/1/100/100?textcolor=000", router, http.StatusBadRequest, []byte("Conflicting params: textcolor requires text\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: bg without ratio", "/id/1/100/100?bg=000", router, http.StatusBadRequest, []byte("Conflicting params: bg requires ratio, fit=contain, fit=pad, or flatten\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: ratio with fit=pad", "/id/1/100/100?ratio=16:9&fit=pad", router, http.StatusBadRequest, []byte("Conflicting params: ratio conflicts with fit=pad\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: flatten with bg=transparent", "/id/1/100/100.webp?ratio=16:9&bg=transparent&flatten", router, http.StatusBadRequest, []byte("Conflicting params: flatten conflicts with bg=transparent\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: flatten with a bg with alpha", "/id/1/100/100.webp?bg=00000080&flatten", router, http.StatusBadRequest, []byte("Conflicting params: flatten conflicts with a bg with alpha\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: trimtol without trim", "/id/1/100/100?trimtol=5", router, http.StatusBadRequest, []byte("Conflicting params: trimtol requires trim\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: trimtol with trim disabled", "/id/1/100/100?trim=false&trimtol=5", router, http.StatusBadRequest, []byte("Conflicting params: trimtol requires trim\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: blendmode without blend", "/id/1/100/100?blendmode=screen", router, http.StatusBadRequest, []byte("Conflicting params: blendmode requires blend\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: blendopacity without blend", "/id/1/100/100?blendopacity=0.5", router, http.StatusBadRequest, []byte("Conflicting params: blendopacity requires blend\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: overlaypos without overlay", "/id/1/100/100?overlaypos=north", router, http.StatusBadRequest, []byte("Conflicting params: overlaypos requires overlay\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: overlayopacity without overlay", "/id/1/100/100?overlayopacity=50", router, http.StatusBadRequest, []byte("Conflicting params: overlayopacity requires overlay\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: overlaysize without overlay", "/id/1/100/100?overlaysize=50", router, http.StatusBadRequest, []byte("Conflicting params: overlaysize requires overlay\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: blurscale without blur", "/id/1/100/100?blurscale=relative", router, http.StatusBadRequest, []byte("Conflicting params: blurscale requires blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: bluredge without blur", "/id/1/100/100?bluredge=mirror", router, http.StatusBadRequest, []byte("Conflicting params: bluredge requires blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: format=auto with the webp extension", "/id/1/100/100.webp?format=auto", router, http.StatusBadRequest, []byte("Conflicting params: format=auto conflicts with the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: auto=format with the webp extension", "/id/1/100/100.webp?auto=format", router, http.StatusBadRequest, []byte("Conflicting params: format=auto conflicts with the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: placeholder without blur", "/id/1/16/16?placeholder", router, http.StatusBadRequest, []byte("Conflicting params: placeholder requires blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: blurbefore without blur", "/id/1/100/100?blurbefore", router, http.StatusBadRequest, []byte("Conflicting params: blurbefore requires blur\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: blurbefore with blurregion", "/id/1/100/100?blur&blurbefore&blurregion=0,0,50,50", router, http.StatusBadRequest, []byte("Conflicting params: blurbefore conflicts with blurregion and blend\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: dither without threshold", "/id/1/100/100?dither", router, http.StatusBadRequest, []byte("Conflicting params: dither requires threshold\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: threshold with colorize", "/id/1/100/100?threshold=128&colorize=120", router, http.StatusBadRequest, []byte("Conflicting params: threshold conflicts with colorize\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params: colorize with grayscale", "/id/1/100/100?colorize=120&grayscale", router, http.StatusBadRequest, []byte("Conflicting params: colorize conflicts with grayscale, saturation, and vibrance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"size with dpr larger then max allowed", "/id/1/3000/3000?dpr=2", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid format", "/id/1/100/100?format=png", router, http.StatusBadRequest, []byte("Invalid format\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid colorspace", "/id/1/100/100?colorspace=adobergb", router, http.StatusBadRequest, []byte("Invalid colorspace\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpi", "/id/1/100/100?dpi=0", router, http.StatusBadRequest, []byte("Invalid dpi\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpi", "/id/1/100/100?dpi=high", router, http.StatusBadRequest, []byte("Invalid dpi\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"dpi larger then max allowed", "/id/1/100/100?dpi=2401", router, http.StatusBadRequest, []byte("Invalid dpi\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"dpi with the webp extension", "/id/1/100/100.webp?dpi=300", router, http.StatusBadRequest, []byte("DPI requires the .jpg extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"dpi with format=auto", "/id/1/100/100?dpi=300&format=auto", router, http.StatusBadRequest, []byte("DPI requires the .jpg extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid effort", "/id/1/100/100?effort=7", router, http.StatusBadRequest, []byte("Invalid effort\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid effort", "/id/1/100/100?effort=fast", router, http.StatusBadRequest, []byte("Invalid effort\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		// Deprecated handler errors
 		{"invalid size", "/g/9223372036854775808", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}}, // Number larger then max int size to fail int parsing
 		// Database errors
@@ -290,6 +526,7 @@ func TestAPI(t *testing.T) {
 		{"Get() database", "/id/1/100/100", mockDatabaseRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"Get() database", "/g/100?image=1", mockDatabaseRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"Get() database info", "/id/1/info", mockDatabaseRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"Get() database bulk info", "/info?ids=1", mockDatabaseRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		// 404
 		{"404", "/asdf", router, http.StatusNotFound, []byte("page not found\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 	}
@@ -317,6 +554,25 @@ func TestAPI(t *testing.T) {
 		}
 	}
 
+	t.Run("/id/{id}/srcset returns json when accepted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/srcset?widths=300", nil)
+		req.Header.Set("Accept", "application/json")
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("wrong content type, %#v", contentType)
+		}
+
+		expected := marshalJson(api.SrcSet{SrcSet: fmt.Sprintf("%s/id/1/300/400.jpg 300w", rootURL)})
+		if !reflect.DeepEqual(w.Body.Bytes(), expected) {
+			t.Errorf("wrong response %#v", w.Body.String())
+		}
+	})
+
 	redirectTests := []struct {
 		Name            string
 		URL             string
@@ -342,9 +598,24 @@ func TestAPI(t *testing.T) {
 		{"/:width/:height.webp", "/200/300.webp", "/id/1/200/300.webp", true, false},
 		{"/:size?grayscale", "/200?grayscale", "/id/1/200/200.jpg?grayscale", true, false},
 		{"/:width/:height?grayscale", "/200/300?grayscale", "/id/1/200/300.jpg?grayscale", true, false},
+		{"/:widthx:height", "/200x300", "/id/1/200/300.jpg", true, false},
+		{"/:widthx:height.webp", "/200x300.webp", "/id/1/200/300.webp", true, false},
 		// JPG
 		{"/id/:id/:width/:height", "/id/1/200/120", "/id/1/200/120.jpg", true, false},
 		{"/id/:id/:width/:height.jpg", "/id/1/200/120.jpg", "/id/1/200/120.jpg", true, false},
+		{"/id/:id/:widthx:height", "/id/1/800x600", "/id/1/800/600.jpg", true, false},
+		{"/id/:id/:widthx:height.jpg?blur", "/id/1/800x600.jpg?blur", "/id/1/800/600.jpg?blur=5", true, false},
+		{"/seed/:seed/:widthx:height", "/seed/1/200x120", "/id/1/200/120.jpg", true, false},
+		// Query dimensions
+		{"/id/:id?w&h", "/id/1?w=200&h=120", "/id/1/200/120.jpg", true, false},
+		{"/id/:id?w&h with other params", "/id/1?grayscale&h=120&w=200", "/id/1/200/120.jpg?grayscale", true, false},
+		{"/seed/:seed?w&h", "/seed/1?w=200&h=120", "/id/1/200/120.jpg", true, false},
+		{"path dimensions take precedence over the query", "/id/1/300/200?w=200&h=120", "/id/1/300/200.jpg", true, false},
+		{"/id/:id?size={width}x{height}", "/id/1?size=800x600", "/id/1/800/600.jpg", true, false},
+		{"/seed/:seed?size={width}x{height}", "/seed/1?size=200x120&grayscale", "/id/1/200/120.jpg?grayscale", true, false},
+		{"query size takes precedence over w and h", "/id/1?size=800x600&w=200&h=120", "/id/1/800/600.jpg", true, false},
+		{"path dimensions take precedence over the query size", "/id/1/300/200?size=800x600", "/id/1/300/200.jpg", true, false},
+		{"path size takes precedence over the query size", "/id/1/300?size=800x600", "/id/1/300/300.jpg", true, false},
 		{"/id/:id/:width/:height?blur", "/id/1/200/200?blur", "/id/1/200/200.jpg?blur=5", true, false},
 		{"/id/:id/:width/:height.jpg?blur", "/id/1/200/200.jpg?blur", "/id/1/200/200.jpg?blur=5", true, false},
 		{"/id/:id/:width/:height?grayscale", "/id/1/200/200?grayscale", "/id/1/200/200.jpg?grayscale", true, false},
@@ -363,6 +634,164 @@ func TestAPI(t *testing.T) {
 		{"width/height larger then max allowed but same size as image", "/id/1/300/400.webp", "/id/1/300/400.webp", true, false},
 		{"width/height of 0 returns original image width", "/id/1/0/0.webp", "/id/1/300/400.webp", true, false},
 
+		// Resize filter
+		{"/id/:id/:width/:height?resize-filter", "/id/1/200/200?resize-filter=nearest", "/id/1/200/200.jpg?resize-filter=nearest", true, false},
+		{"/id/:id/:width/:height?blur&resize-filter", "/id/1/200/200?resize-filter=cubic&blur", "/id/1/200/200.jpg?blur=5&resize-filter=cubic", true, false},
+		{"default resize filter is omitted", "/id/1/200/200?resize-filter=lanczos", "/id/1/200/200.jpg", true, false},
+
+		// Effort
+		{"/id/:id/:width/:height.webp?effort", "/id/1/200/200.webp?effort=6", "/id/1/200/200.webp?effort=6", true, false},
+		{"/id/:id/:width/:height.webp?effort=0", "/id/1/200/200.webp?effort=0", "/id/1/200/200.webp?effort=0", true, false},
+		{"/id/:id/:width/:height.webp?blur&effort", "/id/1/200/200.webp?effort=2&blur", "/id/1/200/200.webp?blur=5&effort=2", true, false},
+
+		// Colorspace
+		{"/id/:id/:width/:height?colorspace", "/id/1/200/200?colorspace=p3", "/id/1/200/200.jpg?colorspace=p3", true, false},
+		{"default colorspace is omitted", "/id/1/200/200?colorspace=srgb", "/id/1/200/200.jpg", true, false},
+
+		// Automatic format
+		{"/id/:id/:width/:height?format=auto", "/id/1/200/200?format=auto", "/id/1/200/200.jpg?format=auto", true, false},
+		{"/id/:id/:width/:height?grayscale&format=auto", "/id/1/200/200?format=AUTO&grayscale", "/id/1/200/200.jpg?grayscale&format=auto", true, false},
+
+		// Quality and dpr
+		{"/id/:id/:width/:height?quality", "/id/1/200/200?quality=50", "/id/1/200/200.jpg?quality=50", true, false},
+		{"/id/:id/:width/:height?dpr keeps the logical size", "/id/1/200/300?dpr=2", "/id/1/200/300.jpg?dpr=2", true, false},
+		{"/id/:id/:width/:height?dpr=1.5", "/id/1/200/300?dpr=1.5", "/id/1/200/300.jpg?dpr=1.5", true, false},
+		// Aspect ratio padding
+		{"/id/:id/:width/:height?ratio landscape", "/id/1/200/200?ratio=16:9", "/id/1/200/200.jpg?ratio=16:9", true, false},
+		{"/id/:id/:width/:height?ratio portrait", "/id/1/200/200?ratio=9:16", "/id/1/200/200.jpg?ratio=9:16", true, false},
+		{"/id/:id/:width/:height?ratio&bg", "/id/1/200/200?ratio=4:3&bg=F0A", "/id/1/200/200.jpg?ratio=4:3&bg=ff00aa", true, false},
+		{"/id/:id/:width/:height?ratio&bg={name}", "/id/1/200/200?ratio=4:3&bg=RebeccaPurple", "/id/1/200/200.jpg?ratio=4:3&bg=663399", true, false},
+		{"/id/:id/:width/:height.webp?ratio&bg=transparent", "/id/1/200/200.webp?ratio=4:3&bg=Transparent", "/id/1/200/200.webp?ratio=4:3&bg=transparent", true, false},
+		{"/id/:id/:width/:height.webp?ratio&bg with alpha", "/id/1/200/200.webp?ratio=4:3&bg=FF00AA80", "/id/1/200/200.webp?ratio=4:3&bg=ff00aa80", true, false},
+		{"/id/:id/:width/:height.webp?ratio&bg with a 4 digit alpha", "/id/1/200/200.webp?ratio=4:3&bg=F0A8", "/id/1/200/200.webp?ratio=4:3&bg=ff00aa88", true, false},
+		{"opaque bg alpha is omitted", "/id/1/200/200?ratio=4:3&bg=ff00aaff", "/id/1/200/200.jpg?ratio=4:3&bg=ff00aa", true, false},
+		{"/id/:id/:width/:height?flatten", "/id/1/200/200.webp?flatten", "/id/1/200/200.webp?flatten", true, false},
+		{"/id/:id/:width/:height?flatten&bg", "/id/1/200/200?flatten&bg=red", "/id/1/200/200.jpg?bg=ff0000&flatten", true, false},
+		{"/id/:id/:width/:height?dpi={dpi}", "/id/1/200/200?dpi=300", "/id/1/200/200.jpg?dpi=300", true, false},
+		{"/id/:id/:width/:height?frame", "/id/1/200/200?frame=2", "/id/1/200/200.jpg?frame=2", true, false},
+		{"default frame is omitted", "/id/1/200/200?frame=0", "/id/1/200/200.jpg", true, false},
+		// Trimming
+		{"/id/:id/:width/:height?trim", "/id/1/200/200?trim", "/id/1/200/200.jpg?trim", true, false},
+		{"/id/:id/:width/:height?trim=false", "/id/1/200/200?trim=false", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?trim&trimtol", "/id/1/200/200?trim&trimtol=5", "/id/1/200/200.jpg?trim&trimtol=5", true, false},
+		{"/id/:id/:width/:height?trimcolor&trimtol", "/id/1/200/200?trimcolor=FFF&trimtol=5", "/id/1/200/200.jpg?trim&trimcolor=ffffff&trimtol=5", true, false},
+		{"/id/:id/:width/:height?trimcolor={name}", "/id/1/200/200?trimcolor=white", "/id/1/200/200.jpg?trim&trimcolor=ffffff", true, false},
+		// Text
+		{"/id/:id/:width/:height?text", "/id/1/200/200?text=Hello%20world", "/id/1/200/200.jpg?text=Hello+world", true, false},
+		{"/id/:id/:width/:height?text without a value", "/id/1/200/200?text", "/id/1/200/200.jpg?text=", true, false},
+		{"/id/:id/:width/:height?text&textcolor&gravity", "/id/1/200/200?text=a%26b&textcolor=000&gravity=SouthEast", "/id/1/200/200.jpg?text=a%26b&textcolor=000000&gravity=southeast", true, false},
+		{"/id/:id/:width/:height?text&textcolor={name}", "/id/1/200/200?text=hi&textcolor=navy", "/id/1/200/200.jpg?text=hi&textcolor=000080", true, false},
+		{"unicode text at the max length", "/id/1/200/200?text=%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5", "/id/1/200/200.jpg?text=%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5%C3%A5", true, false},
+		{"/id/:id/:width/:height?orient=1", "/id/1/200/200?orient=1", "/id/1/200/200.jpg?orient=1", true, false},
+		{"/id/:id/:width/:height?orient=8", "/id/1/200/200?orient=8", "/id/1/200/200.jpg?orient=8", true, false},
+		{"/id/:id/:width/:height?autorotate=false", "/id/1/200/200?autorotate=false", "/id/1/200/200.jpg?autorotate=false", true, false},
+		{"/id/:id/:width/:height?autorotate", "/id/1/200/200?autorotate", "/id/1/200/200.jpg?autorotate=true", true, false},
+		// Color adjustments
+		{"/id/:id/:width/:height?saturation", "/id/1/200/200?saturation=1.5", "/id/1/200/200.jpg?saturation=1.5", true, false},
+		{"/id/:id/:width/:height?saturation=0", "/id/1/200/200?saturation=0", "/id/1/200/200.jpg?saturation=0", true, false},
+		{"/id/:id/:width/:height?vibrance", "/id/1/200/200?vibrance=-40", "/id/1/200/200.jpg?vibrance=-40", true, false},
+		{"/id/:id/:width/:height?saturation&vibrance", "/id/1/200/200?vibrance=40&saturation=2", "/id/1/200/200.jpg?saturation=2&vibrance=40", true, false},
+		{"default saturation and vibrance are omitted", "/id/1/200/200?saturation=1&vibrance=0", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?colorize={hue}", "/id/1/200/200?colorize=120.50", "/id/1/200/200.jpg?colorize=120.5", true, false},
+		{"/id/:id/:width/:height?colorize={color}", "/id/1/200/200?colorize=F80", "/id/1/200/200.jpg?colorize=ff8800", true, false},
+		{"/id/:id/:width/:height?colorize={name}", "/id/1/200/200?colorize=orange", "/id/1/200/200.jpg?colorize=ffa500", true, false},
+		{"colorize with six digits is a color", "/id/1/200/200?colorize=112200", "/id/1/200/200.jpg?colorize=112200", true, false},
+		{"/id/:id/:width/:height?sepia", "/id/1/200/200?sepia", "/id/1/200/200.jpg?sepia=100", true, false},
+		{"/id/:id/:width/:height?sepia={amount}", "/id/1/200/200?sepia=60", "/id/1/200/200.jpg?sepia=60", true, false},
+		{"/id/:id/:width/:height.bin", "/id/1/200/200.bin", "/id/1/200/200.bin", true, false},
+		{"/id/:id/:width/:height.bin?pixelformat={format}", "/id/1/200/200.BIN?pixelformat=GRAY8", "/id/1/200/200.bin?pixelformat=gray8", true, false},
+		{"pixelformat=rgb8 is the default", "/id/1/200/200.bin?pixelformat=rgb8", "/id/1/200/200.bin", true, false},
+		{"sepia=0 is no sepia", "/id/1/200/200?sepia=0", "/id/1/200/200.jpg", true, false},
+		{"sepia composes with grayscale", "/id/1/200/200?grayscale&sepia=60", "/id/1/200/200.jpg?grayscale&sepia=60", true, false},
+		{"/id/:id/:width/:height?blur&placeholder", "/id/1/16/16?placeholder&blur=2", "/id/1/16/16.jpg?blur=2&placeholder", true, false},
+		{"/id/:id/:width/:height?blur&blurbefore", "/id/1/200/200?blurbefore&blur=2", "/id/1/200/200.jpg?blur=2&blurbefore", true, false},
+		{"/id/:id/:width/:height?blur&bluredge", "/id/1/200/200?bluredge=Mirror&blur=2", "/id/1/200/200.jpg?blur=2&bluredge=mirror", true, false},
+		{"/id/:id/:width/:height?blur&blurscale", "/id/1/200/200?blurscale=Relative&blur=2", "/id/1/200/200.jpg?blur=2&blurscale=relative", true, false},
+		{"default blur scale is omitted", "/id/1/200/200?blur=2&blurscale=absolute", "/id/1/200/200.jpg?blur=2", true, false},
+		{"default blur edge is omitted", "/id/1/200/200?blur=2&bluredge=extend", "/id/1/200/200.jpg?blur=2", true, false},
+		{"/id/:id/:width/:height?lossless", "/id/1/200/200.webp?lossless", "/id/1/200/200.webp?lossless", true, false},
+		{"/id/:id/:width/:height?lossless=auto", "/id/1/200/200.webp?lossless=Auto", "/id/1/200/200.webp?lossless=auto", true, false},
+		{"/id/:id/:width/:height?nearlossless={level}", "/id/1/200/200.webp?nearlossless=60", "/id/1/200/200.webp?nearlossless=60", true, false},
+		{"/id/:id/:width/:height?sharpen", "/id/1/200/200?sharpen", "/id/1/200/200.jpg?sharpen=1", true, false},
+		{"/id/:id/:width/:height?sharpen={amount}", "/id/1/200/200?sharpen=1.50", "/id/1/200/200.jpg?sharpen=1.5", true, false},
+		{"sharpen=0 is kept to disable the default sharpening", "/id/1/200/200?sharpen=false", "/id/1/200/200.jpg?sharpen=0", true, false},
+		{"sharpen=0 with blur", "/id/1/200/200?sharpen=0&blur", "/id/1/200/200.jpg?blur=5&sharpen=0", true, false},
+		{"/id/:id/:width/:height?gamma={gamma}", "/id/1/200/200?gamma=2.20", "/id/1/200/200.jpg?gamma=2.2", true, false},
+		{"gamma of 1 is left out", "/id/1/200/200?gamma=1", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?brightness={amount}", "/id/1/200/200?brightness=-20", "/id/1/200/200.jpg?brightness=-20", true, false},
+		{"/id/:id/:width/:height?contrast={amount}", "/id/1/200/200?contrast=30", "/id/1/200/200.jpg?contrast=30", true, false},
+		{"/id/:id/:width/:height?gamma&brightness&contrast", "/id/1/200/200?contrast=30&brightness=10&gamma=2", "/id/1/200/200.jpg?gamma=2&brightness=10&contrast=30", true, false},
+		{"default brightness and contrast are omitted", "/id/1/200/200?brightness=0&contrast=0", "/id/1/200/200.jpg", true, false},
+		{"bri is an alias of brightness", "/id/1/200/200?bri=20", "/id/1/200/200.jpg?brightness=20", true, false},
+		{"con is an alias of contrast", "/id/1/200/200?con=-40", "/id/1/200/200.jpg?contrast=-40", true, false},
+		{"sat is an alias of saturation", "/id/1/200/200?sat=1.5", "/id/1/200/200.jpg?saturation=1.5", true, false},
+		{"brightness takes precedence over bri", "/id/1/200/200?bri=20&brightness=-10", "/id/1/200/200.jpg?brightness=-10", true, false},
+		{"contrast takes precedence over con", "/id/1/200/200?contrast=10&con=abc", "/id/1/200/200.jpg?contrast=10", true, false},
+		{"/id/:id/:width/:height?vignette", "/id/1/200/200?vignette", "/id/1/200/200.jpg?vignette=50", true, false},
+		{"/id/:id/:width/:height?vignette={intensity}", "/id/1/200/200?vignette=80", "/id/1/200/200.jpg?vignette=80", true, false},
+		{"vignette=0 is no vignette", "/id/1/200/200?vignette=0", "/id/1/200/200.jpg", true, false},
+		{"vignette=false is no vignette", "/id/1/200/200?vignette=false", "/id/1/200/200.jpg", true, false},
+		{"vignette composes with the tonal adjustments", "/id/1/200/200?vignette=20&contrast=30&sepia", "/id/1/200/200.jpg?sepia=100&contrast=30&vignette=20", true, false},
+		{"saturation takes precedence over sat", "/id/1/200/200?sat=2&saturation=0.5", "/id/1/200/200.jpg?saturation=0.5", true, false},
+		{"/id/:id/:width/:height?threshold={level}", "/id/1/200/200?threshold=0", "/id/1/200/200.jpg?threshold=0", true, false},
+		{"/id/:id/:width/:height?threshold={level}&dither", "/id/1/200/200?dither&grayscale&threshold=128", "/id/1/200/200.jpg?grayscale&threshold=128&dither", true, false},
+		{"/id/:id/:width/:height?extract={channel}", "/id/1/200/200?extract=Alpha", "/id/1/200/200.jpg?extract=alpha", true, false},
+		{"/id/:id/:width/:height?invertregion={x},{y},{width},{height}", "/id/1/200/200?invertregion=10,%2020,100,50", "/id/1/200/200.jpg?invertregion=10,20,100,50", true, false},
+		{"/id/:id/:width/:height?colorblind={type}", "/id/1/200/200?colorblind=Deuteranopia", "/id/1/200/200.jpg?colorblind=deuteranopia", true, false},
+		{"/id/:id/:width/:height?crop={x},{y},{width},{height}", "/id/1/200/200?crop=10,%2020,100,50", "/id/1/200/200.jpg?crop=10,20,100,50", true, false},
+		{"/id/:id/:width/:height?crop in percent", "/id/1/200/200?crop=10%25,10%25,80.5%25,80%25", "/id/1/200/200.jpg?crop=10%25,10%25,80.5%25,80%25", true, false},
+		{"width/height of 0 returns the size of the pixel crop", "/id/1/0/0?crop=0,0,100,200", "/id/1/100/200.jpg?crop=0,0,100,200", true, false},
+		{"width/height of 0 returns the size of the percentage crop", "/id/1/0/0?crop=10%25,10%25,80%25,80%25", "/id/1/240/320.jpg?crop=10%25,10%25,80%25,80%25", true, false},
+		{"/id/:id/:width/:height?blur&blurregion={x},{y},{width},{height}", "/id/1/200/200?blurregion=10,20,100,50&blur=3", "/id/1/200/200.jpg?blur=3&blurregion=10,20,100,50", true, false},
+		{"invert region of the padded image", "/id/1/100/100?ratio=2:1&invertregion=150,0,50,100", "/id/1/100/100.jpg?invertregion=150,0,50,100&ratio=2:1", true, false},
+		{"/id/:id/:width/:height?fit={fit}", "/id/1/200/200?fit=Fill", "/id/1/200/200.jpg?fit=fill", true, false},
+		{"/id/:id/:width/:height?fit=contain&bg={color}", "/id/1/200/200?fit=contain&bg=000", "/id/1/200/200.jpg?fit=contain&bg=000000", true, false},
+		{"/id/:id/:width/:height?fit=pad", "/id/1/200/100?fit=pad", "/id/1/200/100.jpg?fit=pad", true, false},
+		{"/id/:id/:width/:height?fit=pad&bg={color}", "/id/1/200/100?fit=pad&bg=000", "/id/1/200/100.jpg?fit=pad&bg=000000", true, false},
+		// Masking
+		{"/id/:id/:width/:height.webp?mask", "/id/1/200/200.webp?mask=1", "/id/1/200/200.webp?mask=1", true, false},
+		// Blending
+		{"/id/:id/:width/:height?blend", "/id/1/200/200?blend=1", "/id/1/200/200.jpg?blend=1", true, false},
+		{"/id/:id/:width/:height?blend&blendmode&blendopacity", "/id/1/200/200?blend=1&blendmode=Multiply&blendopacity=0.5", "/id/1/200/200.jpg?blend=1&blendmode=multiply&blendopacity=0.5", true, false},
+		{"default blend mode and opacity are omitted", "/id/1/200/200?blend=1&blendmode=normal&blendopacity=1", "/id/1/200/200.jpg?blend=1", true, false},
+		// Overlays
+		{"/id/:id/:width/:height?overlay", "/id/1/200/200?overlay=1", "/id/1/200/200.jpg?overlay=1", true, false},
+		{"/id/:id/:width/:height?overlay&overlaypos&overlayopacity&overlaysize", "/id/1/200/200?overlay=1&overlaypos=NorthWest&overlayopacity=80&overlaysize=50", "/id/1/200/200.jpg?overlay=1&overlaypos=northwest&overlayopacity=80&overlaysize=50", true, false},
+		{"default overlay position, opacity and size are omitted", "/id/1/200/200?overlay=1&overlaypos=southeast&overlayopacity=100&overlaysize=20", "/id/1/200/200.jpg?overlay=1", true, false},
+		{"default dpr is omitted", "/id/1/200/300?dpr=1", "/id/1/200/300.jpg", true, false},
+		{"/id/:id/:width/:height?quality=auto", "/id/1/200/300?quality=Auto", "/id/1/200/300.jpg?quality=auto", true, false},
+		{"/id/:id/:width/:height?auto=compress", "/id/1/200/300?auto=compress", "/id/1/200/300.jpg?quality=auto", true, false},
+		{"/id/:id/:width/:height?auto=format", "/id/1/200/300?auto=format", "/id/1/200/300.jpg?format=auto", true, false},
+		{"/id/:id/:width/:height?auto=compress,format", "/id/1/200/300?auto=Compress,%20format", "/id/1/200/300.jpg?format=auto&quality=auto", true, false},
+		{"/id/:id/:width/:height?quality=low", "/id/1/200/300?quality=low", "/id/1/200/300.jpg?quality=low", true, false},
+		{"/id/:id/:width/:height?quality=medium", "/id/1/200/300?quality=Medium", "/id/1/200/300.jpg?quality=medium", true, false},
+		{"/id/:id/:width/:height?quality=high", "/id/1/200/300.webp?quality=high", "/id/1/200/300.webp?quality=high", true, false},
+		{"quality preset takes precedence over auto=compress", "/id/1/200/300?auto=compress&quality=high", "/id/1/200/300.jpg?quality=high", true, false},
+		{"quality takes precedence over auto=compress", "/id/1/200/300?auto=compress&quality=80", "/id/1/200/300.jpg?quality=80", true, false},
+		{"/id/:id/:width/:height?quality&dpr", "/id/1/200/300?dpr=3&quality=40", "/id/1/200/300.jpg?quality=40&dpr=3", true, false},
+
+		// Explicit boolean values
+		{"/id/:id/:width/:height?grayscale=true", "/id/1/200/200?grayscale=true", "/id/1/200/200.jpg?grayscale", true, false},
+		{"/id/:id/:width/:height?grayscale=false", "/id/1/200/200?grayscale=false", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?grayscale=0", "/id/1/200/200?grayscale=0", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?grayscale", "/id/1/200/200?grayscale", "/id/1/200/200.jpg?grayscale", true, false},
+		{"/id/:id/:width/:height?blur=0", "/id/1/200/200?blur=0", "/id/1/200/200.jpg", true, false},
+		{"/id/:id/:width/:height?blur=false", "/id/1/200/200?blur=false&grayscale", "/id/1/200/200.jpg?grayscale", true, false},
+		{"/id/:id/:width/:height?blur=true", "/id/1/200/200?blur=true", "/id/1/200/200.jpg?blur=5", true, false},
+		{"/id/:id/:width/:height?noupscale=false", "/id/1/600/800?noupscale=false", "/id/1/600/800.jpg", true, false},
+
+		// Presets
+		{"/id/:id/preset/:preset", "/id/1/preset/og", "/id/1/1200/630.jpg", true, false},
+		{"/id/:id/preset/:preset.webp", "/id/1/preset/og.webp", "/id/1/1200/630.webp", true, false},
+		{"/id/:id/preset/:preset with query", "/id/1/preset/thumbnail", "/id/1/100/100.jpg?grayscale&quality=60", true, false},
+		{"/id/:id/preset/:preset query overrides", "/id/1/preset/thumbnail.jpg?quality=80&blur", "/id/1/100/100.jpg?blur=5&grayscale&quality=80", true, false},
+
+		// No upscaling
+		{"/id/:id/:width/:height?noupscale below native size", "/id/1/200/300?noupscale", "/id/1/200/300.jpg", true, false},
+		{"/id/:id/:width/:height?noupscale above native size", "/id/1/600/800?noupscale", "/id/1/300/400.jpg", true, false},
+		{"/id/:id/:width/:height?noupscale keeps the aspect ratio", "/id/1/600/400?noupscale", "/id/1/300/200.jpg", true, false},
+		{"/id/:id/:width/:height upscales by default", "/id/1/600/800", "/id/1/600/800.jpg", true, false},
+
 		// Default blur amount
 		{"/:size?blur", "/200?blur", "/id/1/200/200.jpg?blur=5", true, false},
 		{"/:width/:height?blur", "/200/300?blur", "/id/1/200/300.jpg?blur=5", true, false},
@@ -456,6 +885,286 @@ func TestAPI(t *testing.T) {
 	}
 }
 
+func TestParsePresets(t *testing.T) {
+	tests := []struct {
+		Value         string
+		ExpectedError bool
+	}{
+		{"", false},
+		{"og=1200x630", false},
+		{"og=1200x630;thumbnail=100x100?grayscale&blur=2", false},
+		{"og", true},
+		{"=1200x630", true},
+		{"og=1200", true},
+		{"og=axb", true},
+		{"og=1200x630?%zz", true},
+	}
+
+	for _, test := range tests {
+		_, err := params.ParsePresets(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+		}
+	}
+}
+
+func TestHead(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	tests := []struct {
+		Name        string
+		URL         string
+		ExpectedURL string
+	}{
+		{"/:size", "/200", "/id/1/200/200.jpg"},
+		{"/id/:id/:width/:height", "/id/1/200/300?blur", "/id/1/200/300.jpg?blur=5"},
+		{"/seed/:seed/:width/:height.webp", "/seed/1/200/300.webp", "/id/1/200/300.webp"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("HEAD", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedURL {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	tests := []struct {
+		Name          string
+		URL           string
+		ExpectedParam string
+		NotExpected   string
+	}{
+		{"/id/:id/:width/:height", "/id/1/200/300", "blur", "w"},
+		{"/id/:id/:size.webp", "/id/1/200.webp", "blur", "w"},
+		{"/id/:id", "/id/1", "w", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s: wrong allow header %s", test.Name, allow)
+		}
+
+		var route params.Route
+		if err := json.Unmarshal(w.Body.Bytes(), &route); err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			continue
+		}
+
+		described := make(map[string]params.Param)
+		for _, p := range route.Params {
+			described[p.Name] = p
+		}
+
+		if _, ok := described[test.ExpectedParam]; !ok {
+			t.Errorf("%s: missing param %s", test.Name, test.ExpectedParam)
+		}
+
+		if _, ok := described[test.NotExpected]; test.NotExpected != "" && ok {
+			t.Errorf("%s: unexpected param %s", test.Name, test.NotExpected)
+		}
+	}
+
+	t.Run("describes the constraints of the params", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "/id/1/200/300", nil)
+		router.ServeHTTP(w, req)
+
+		var route params.Route
+		if err := json.Unmarshal(w.Body.Bytes(), &route); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]params.Param{
+			"blur":       {Name: "blur", Type: "int", Range: &params.Range{Min: 1, Max: 10}},
+			"brightness": {Name: "brightness", Type: "int", Range: &params.Range{Min: -100, Max: 100}, Alias: "bri"},
+			"fit":        {Name: "fit", Type: "enum", Values: []string{"cover", "contain", "fill", "inside", "outside", "pad", "crop"}},
+			"text":       {Name: "text", Type: "string", MaxLength: 100},
+			"grayscale":  {Name: "grayscale", Type: "bool"},
+			"colorblind": {Name: "colorblind", Type: "enum", Values: []string{"protanopia", "deuteranopia", "tritanopia"}},
+		}
+
+		for _, p := range route.Params {
+			if e, ok := expected[p.Name]; ok {
+				if !reflect.DeepEqual(p, e) {
+					t.Errorf("wrong description of %s %#v", p.Name, p)
+				}
+				delete(expected, p.Name)
+			}
+		}
+
+		if len(expected) != 0 {
+			t.Errorf("missing params %#v", expected)
+		}
+	})
+
+	t.Run("rejects other methods", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "/id/1/info", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("wrong response code, %#v", w.Code)
+		}
+	})
+}
+
+func TestNoUpscale(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, NoUpscale: true}).Router()
+
+	// Redirects to clamped images say so, with the size they're clamped to
+	tests := []struct {
+		Name               string
+		URL                string
+		ExpectedURL        string
+		ExpectedDimensions string
+	}{
+		{"below native size", "/id/1/200/300", "/id/1/200/300.jpg", ""},
+		{"native size", "/id/1/300/400", "/id/1/300/400.jpg", ""},
+		{"above native size", "/id/1/600/800", "/id/1/300/400.jpg", "300x400"},
+		{"above native width", "/id/1/600/400", "/id/1/300/200.jpg", "300x200"},
+		{"above native height", "/id/1/300/800", "/id/1/150/400.jpg", "150x400"},
+		{"above native size with dpr", "/id/1/200/200?dpr=2", "/id/1/200/200.jpg?dpr=2", "300x300"},
+		{"above the max image size with dpr", "/id/1/3000/4000?dpr=2", "/id/1/300/400.jpg?dpr=2", "300x400"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedURL {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+
+		clamped := w.Header().Get("X-Image-Clamped")
+		if dimensions := w.Header().Get("X-Image-Dimensions"); dimensions != test.ExpectedDimensions || (clamped == "true") != (test.ExpectedDimensions != "") {
+			t.Errorf("%s: wrong clamped headers %q %q", test.Name, clamped, dimensions)
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	redirect := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	accept := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, TrailingSlash: handler.AcceptTrailingSlash}).Router()
+
+	// Both policies resolve uppercase extensions and trailing slashes to the same canonical image url, the redirect policy in an extra hop
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"uppercase extension", "/id/1/200/300.JPG", redirect, http.StatusFound, imageServiceURL + "/id/1/200/300.jpg"},
+		{"mixed case extension", "/id/1/200/300.WebP", redirect, http.StatusFound, imageServiceURL + "/id/1/200/300.webp"},
+		{"uppercase extension accepted", "/id/1/200/300.JPG", accept, http.StatusFound, imageServiceURL + "/id/1/200/300.jpg"},
+		{"trailing slash redirected", "/id/1/200/300/", redirect, http.StatusMovedPermanently, "/id/1/200/300"},
+		{"trailing slash redirected with the query", "/id/1/200/300.JPG/?grayscale", redirect, http.StatusMovedPermanently, "/id/1/200/300.JPG?grayscale"},
+		{"trailing slash accepted", "/id/1/200/300/", accept, http.StatusFound, imageServiceURL + "/id/1/200/300.jpg"},
+		{"trailing slash accepted with the query", "/id/1/200/300.JPG/?grayscale", accept, http.StatusFound, imageServiceURL + "/id/1/200/300.jpg?grayscale"},
+		{"trailing slash of a random image accepted", "/200/300/", accept, http.StatusFound, ""},
+		{"trailing slash of the list accepted", "/v2/list/", accept, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); test.ExpectedLocation != "" && location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	routers := map[params.Rounding]http.Handler{}
+	for _, rounding := range []params.Rounding{params.Round, params.Floor, params.Ceil} {
+		routers[rounding] = (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, NoUpscale: true, Rounding: rounding}).Router()
+	}
+
+	// The image is 300x400, so fitting larger sizes within it scales them by a fraction
+	tests := []struct {
+		Name        string
+		URL         string
+		Rounding    params.Rounding
+		ExpectedURL string
+	}{
+		{"round half up", "/id/1/800/500", params.Round, "/id/1/300/188.jpg"},
+		{"floor half", "/id/1/800/500", params.Floor, "/id/1/300/187.jpg"},
+		{"ceil half", "/id/1/800/500", params.Ceil, "/id/1/300/188.jpg"},
+		{"round third down", "/id/1/900/700", params.Round, "/id/1/300/233.jpg"},
+		{"floor third", "/id/1/900/700", params.Floor, "/id/1/300/233.jpg"},
+		{"ceil third", "/id/1/900/700", params.Ceil, "/id/1/300/234.jpg"},
+		{"ceil doesn't exceed the image", "/id/1/1500/1000", params.Ceil, "/id/1/300/200.jpg"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		routers[test.Rounding].ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedURL {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	for value, expected := range map[string]params.Rounding{"": params.Round, "round": params.Round, "floor": params.Floor, "ceil": params.Ceil} {
+		if rounding, err := params.ParseRounding(value); err != nil || rounding != expected {
+			t.Errorf("%q: wrong rounding %s %v", value, rounding, err)
+		}
+	}
+
+	if _, err := params.ParseRounding("truncate"); err == nil {
+		t.Error("no error for an invalid rounding")
+	}
+}
+
 func marshalJson(v interface{}) []byte {
 	fixture, _ := json.Marshal(v)
 	return append(fixture[:], []byte("\n")...)
@@ -465,3 +1174,1251 @@ func readFile(path string) []byte {
 	fixture, _ := ioutil.ReadFile(path)
 	return fixture
 }
+
+func TestCapabilities(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	presets, _ := params.ParsePresets("thumbnail=100x100?grayscale;og=1200x630")
+
+	tests := []struct {
+		Name              string
+		Router            http.Handler
+		ExpectedPresets   []string
+		ExpectedNoUpscale bool
+	}{
+		{"default config", (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router(), []string{}, false},
+		{"presets and noupscale", (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, NoUpscale: true, Presets: presets}).Router(), []string{"og", "thumbnail"}, true},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/capabilities", nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: wrong content type, %#v", test.Name, contentType)
+		}
+
+		var capabilities api.Capabilities
+		if err := json.Unmarshal(w.Body.Bytes(), &capabilities); err != nil {
+			t.Errorf("%s: error decoding response, %s", test.Name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(capabilities.Presets, test.ExpectedPresets) {
+			t.Errorf("%s: wrong presets, %#v", test.Name, capabilities.Presets)
+		}
+
+		if capabilities.NoUpscale != test.ExpectedNoUpscale {
+			t.Errorf("%s: wrong noupscale, %#v", test.Name, capabilities.NoUpscale)
+		}
+
+		if !reflect.DeepEqual(capabilities.Extensions, []string{".jpg", ".webp", ".bin"}) {
+			t.Errorf("%s: wrong extensions, %#v", test.Name, capabilities.Extensions)
+		}
+
+		// The same order as the documentation of the routes
+		if !reflect.DeepEqual(capabilities.EffectOrder, []string{"blur", "sharpen", "saturation", "vibrance", "colorize", "sepia", "gamma", "brightness", "contrast", "vignette", "threshold", "pad", "text", "invertregion", "colorblind"}) {
+			t.Errorf("%s: wrong effect order, %#v", test.Name, capabilities.EffectOrder)
+		}
+
+		if capabilities.MaxSize != 5000 || capabilities.Blur != (params.Range{Min: 1, Max: 10}) {
+			t.Errorf("%s: wrong limits, %d %#v", test.Name, capabilities.MaxSize, capabilities.Blur)
+		}
+	}
+}
+
+func TestClientHints(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	hintsRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, ClientHints: true}).Router()
+
+	tests := []struct {
+		Name             string
+		Router           http.Handler
+		URL              string
+		Hints            map[string]string
+		ExpectedURL      string
+		ExpectedAcceptCH string
+	}{
+		{"width hint keeps the aspect ratio", hintsRouter, "/id/1/0/0", map[string]string{"Sec-CH-Width": "200"}, "/id/1/200/267.jpg", "Sec-CH-Width, Sec-CH-DPR"},
+		{"width hint is in physical pixels", hintsRouter, "/id/1/0/0", map[string]string{"Sec-CH-Width": "400", "Sec-CH-DPR": "2"}, "/id/1/200/267.jpg?dpr=2", "Sec-CH-Width, Sec-CH-DPR"},
+		{"width hint with a height in the url", hintsRouter, "/id/1/0/100", map[string]string{"Sec-CH-Width": "200"}, "/id/1/200/100.jpg", "Sec-CH-Width, Sec-CH-DPR"},
+		{"dpr hint", hintsRouter, "/id/1/100/100", map[string]string{"Sec-CH-DPR": "2"}, "/id/1/100/100.jpg?dpr=2", "Sec-CH-Width, Sec-CH-DPR"},
+		{"url width overrides the width hint", hintsRouter, "/id/1/100/100", map[string]string{"Sec-CH-Width": "400"}, "/id/1/100/100.jpg", "Sec-CH-Width, Sec-CH-DPR"},
+		{"url dpr overrides the dpr hint", hintsRouter, "/id/1/100/100?dpr=1.5", map[string]string{"Sec-CH-DPR": "3"}, "/id/1/100/100.jpg?dpr=1.5", "Sec-CH-Width, Sec-CH-DPR"},
+		{"invalid hints are ignored", hintsRouter, "/id/1/0/0", map[string]string{"Sec-CH-Width": "wide", "Sec-CH-DPR": "9"}, "/id/1/300/400.jpg", "Sec-CH-Width, Sec-CH-DPR"},
+		{"width hint larger then max allowed is ignored", hintsRouter, "/id/1/0/0", map[string]string{"Sec-CH-Width": "6000"}, "/id/1/300/400.jpg", "Sec-CH-Width, Sec-CH-DPR"},
+		{"hints are ignored when disabled", router, "/id/1/0/0", map[string]string{"Sec-CH-Width": "200", "Sec-CH-DPR": "2"}, "/id/1/300/400.jpg", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		for header, value := range test.Hints {
+			req.Header.Set(header, value)
+		}
+		test.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedURL {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+
+		if acceptCH := w.Header().Get("Accept-CH"); acceptCH != test.ExpectedAcceptCH {
+			t.Errorf("%s: wrong accept-ch header %#v", test.Name, acceptCH)
+		}
+
+		if vary := w.Header().Get("Vary"); vary != test.ExpectedAcceptCH {
+			t.Errorf("%s: wrong vary header %#v", test.Name, vary)
+		}
+	}
+}
+
+func TestDefaultFit(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	containRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, DefaultFit: params.FitContain}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"unset fit is cover by default", "/id/1/200/300", router, http.StatusFound, "/id/1/200/300.jpg"},
+		{"unset fit is the configured default", "/id/1/200/300", containRouter, http.StatusFound, "/id/1/200/300.jpg?fit=contain"},
+		{"fit overrides the configured default", "/id/1/200/300?fit=cover", containRouter, http.StatusFound, "/id/1/200/300.jpg"},
+		{"fit=crop is the same as cover", "/id/1/200/300?fit=crop", router, http.StatusFound, "/id/1/200/300.jpg"},
+		{"fit=crop overrides the configured default like cover", "/id/1/200/300?fit=Crop", containRouter, http.StatusFound, "/id/1/200/300.jpg"},
+		{"fit=crop composes with gravity like cover", "/id/1/200/300?fit=crop&text&gravity=north", containRouter, http.StatusFound, "/id/1/200/300.jpg?text=&gravity=north"},
+		{"bg requires contain with fit=crop", "/id/1/200/300?fit=crop&bg=000", containRouter, http.StatusBadRequest, ""},
+		{"configured default allows bg", "/id/1/200/300?bg=000", containRouter, http.StatusFound, "/id/1/200/300.jpg?fit=contain&bg=000000"},
+		{"configured default composes with gravity", "/id/1/200/300?text&gravity=north", containRouter, http.StatusFound, "/id/1/200/300.jpg?fit=contain&text=&gravity=north"},
+		{"bg requires contain when overriding the default", "/id/1/200/300?fit=fill&bg=000", containRouter, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedLocation == "" {
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	for value, expected := range map[string]string{"": params.FitCover, "cover": params.FitCover, "Contain": params.FitContain, "fill": params.FitFill, "crop": params.FitCover, "CROP": params.FitCover} {
+		if fit, err := params.ParseFit(value); err != nil || fit != expected {
+			t.Errorf("%q: wrong fit %s %v", value, fit, err)
+		}
+	}
+
+	if _, err := params.ParseFit("stretch"); err == nil {
+		t.Error("no error for an invalid fit")
+	}
+}
+
+func TestInsideOutsideFit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	// Image 1 is a 300x400 portrait image, and quadrants is a 200x100 landscape image
+	portraitDB, _ := fileDatabase.New("../../test/fixtures/file/metadata.json")
+	landscapeDB, _ := fileDatabase.New("../../test/fixtures/file/metadata_exif.json")
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Database: portraitDB,
+		Log:      log,
+	}
+	checker.Run()
+
+	portrait := (&api.API{Database: portraitDB, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	portraitNoUpscale := (&api.API{Database: portraitDB, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, NoUpscale: true}).Router()
+	landscape := (&api.API{Database: landscapeDB, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedLocation string
+	}{
+		{"portrait inside square", "/id/1/200/200?fit=inside", portrait, "/id/1/150/200.jpg?fit=inside"},
+		{"portrait outside square", "/id/1/200/200?fit=outside", portrait, "/id/1/200/267.jpg?fit=outside"},
+		{"portrait inside rectangle", "/id/1/100/50?fit=inside", portrait, "/id/1/38/50.jpg?fit=inside"},
+		{"portrait outside rectangle", "/id/1/100/50?fit=outside", portrait, "/id/1/100/133.jpg?fit=outside"},
+		{"inside doesn't upscale", "/id/1/600/600?fit=inside", portrait, "/id/1/300/400.jpg?fit=inside"},
+		{"outside upscales", "/id/1/600/600?fit=outside", portrait, "/id/1/600/800.jpg?fit=outside"},
+		{"outside with noupscale", "/id/1/600/600?fit=outside", portraitNoUpscale, "/id/1/300/400.jpg?fit=outside"},
+		{"landscape inside square", "/id/quadrants/100/100?fit=inside", landscape, "/id/quadrants/100/50.jpg?fit=inside"},
+		{"landscape outside square", "/id/quadrants/100/100?fit=outside", landscape, "/id/quadrants/200/100.jpg?fit=outside"},
+		{"landscape inside rectangle", "/id/quadrants/50/150?fit=inside", landscape, "/id/quadrants/50/25.jpg?fit=inside"},
+		{"landscape outside rectangle", "/id/quadrants/50/150?fit=outside", landscape, "/id/quadrants/300/150.jpg?fit=outside"},
+		{"portrait pad keeps the size", "/id/1/200/100?fit=pad", portrait, "/id/1/200/100.jpg?fit=pad"},
+		{"pad with noupscale keeps the size", "/id/1/600/600?fit=pad", portraitNoUpscale, "/id/1/600/600.jpg?fit=pad"},
+		{"landscape pad keeps the size", "/id/quadrants/100/300?fit=pad", landscape, "/id/quadrants/100/300.jpg?fit=pad"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	// The image service resizes the redirected dimensions again, so they have to stay the same
+	images := []*database.Image{{Width: 300, Height: 400}, {Width: 200, Height: 100}, {Width: 1000, Height: 7}}
+	for _, image := range images {
+		for _, fit := range []string{params.FitInside, params.FitOutside} {
+			for width := 1; width <= 400; width += 7 {
+				for height := 1; height <= 400; height += 11 {
+					p := &params.Params{Width: width, Height: height, Fit: fit, DPR: 1}
+					resizedWidth, resizedHeight := p.Dimensions(image)
+
+					p.Width, p.Height = resizedWidth, resizedHeight
+					if againWidth, againHeight := p.Dimensions(image); againWidth != resizedWidth || againHeight != resizedHeight {
+						t.Errorf("%dx%d %s %dx%d: resized to %dx%d, then to %dx%d", image.Width, image.Height, fit, width, height, resizedWidth, resizedHeight, againWidth, againHeight)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestImageIDPattern(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	// The mock database fails every lookup, so a 500 means that the lookup was attempted
+	db := &mockDatabase.Provider{}
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Database: db,
+		Log:      log,
+	}
+	checker.Run()
+
+	pattern, err := params.ParseImageIDPattern(params.DefaultImageIDPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := (&api.API{Database: db, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, ImageIDPattern: pattern}).Router()
+	anyRouter := (&api.API{Database: db, HealthChecker: checker, Log: log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	tests := []struct {
+		Name           string
+		URL            string
+		Router         http.Handler
+		ExpectedStatus int
+	}{
+		{"numeric id", "/id/1/100/100", router, http.StatusInternalServerError},
+		{"alphanumeric id", "/id/Abc123/100/100", router, http.StatusInternalServerError},
+		{"invalid id", "/id/a_b/100/100", router, http.StatusBadRequest},
+		{"invalid id with a size", "/id/a%27b/100", router, http.StatusBadRequest},
+		{"invalid id info", "/id/a-b/info", router, http.StatusBadRequest},
+		{"invalid id srcset", "/id/a.b/srcset?widths=300,600", router, http.StatusBadRequest},
+		{"any id without a pattern", "/id/a_b/100/100", anyRouter, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus == http.StatusBadRequest && w.Body.String() != "Invalid image id\n" {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+	}
+
+	// The whole id has to match the pattern
+	numeric, _ := params.ParseImageIDPattern("[0-9]+")
+	for id, expected := range map[string]bool{"123": true, "1a": false, "a1": false, "": false} {
+		if valid := params.ValidImageID(numeric, id); valid != expected {
+			t.Errorf("%q: wrong validity %t", id, valid)
+		}
+	}
+
+	if _, err := params.ParseImageIDPattern("[0-9"); err == nil {
+		t.Error("no error for an invalid pattern")
+	}
+}
+
+func TestTenants(t *testing.T) {
+	f := newFixtures("metadata_tenants.json")
+	defer f.Close()
+
+	pattern, _ := params.ParseImageIDPattern(params.DefaultImageIDPattern)
+	tenants, _ := params.ParseImageIDPattern("acme|globex")
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, ImageIDPattern: pattern, TenantPattern: tenants}).Router()
+	disabledRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, ImageIDPattern: pattern}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+	}{
+		{"image of a tenant", "/t/acme/id/1/info", router, http.StatusOK, `{"id":"1","author":"Acme","width":300,"height":400,"url":"https://acme.example.com","download_url":"https://example.com/t/acme/id/1/300/400"}` + "\n", ""},
+		{"same id in another tenant", "/t/globex/id/1/info", router, http.StatusOK, `{"id":"1","author":"Globex","width":200,"height":100,"url":"https://globex.example.com","download_url":"https://example.com/t/globex/id/1/200/100"}` + "\n", ""},
+		{"same id without a tenant", "/id/1/info", router, http.StatusOK, `{"id":"1","author":"John Doe","width":300,"height":400,"url":"https://picsum.photos","download_url":"https://example.com/id/1/300/400"}` + "\n", ""},
+		{"redirects within the tenant", "/t/acme/id/1/200/200?blend=1", router, http.StatusFound, "", imageServiceURL + "/t/acme/id/1/200/200.jpg?blend=1"},
+		{"tenant with an id", "/t/acme/id/1/300", router, http.StatusFound, "", imageServiceURL + "/t/acme/id/1/300/300.jpg"},
+		{"unknown tenant", "/t/initech/id/1/info", router, http.StatusBadRequest, "Invalid tenant\n", ""},
+		{"bulk info of a tenant", "/t/acme/info?ids=1,globex/1", router, http.StatusOK, `{"1":{"id":"1","author":"Acme","width":300,"height":400,"url":"https://acme.example.com","download_url":"https://example.com/t/acme/id/1/300/400"},"globex/1":null}` + "\n", ""},
+		{"bulk info of an unknown tenant", "/t/initech/info?ids=1", router, http.StatusBadRequest, "Invalid tenant\n", ""},
+		{"id of another tenant", "/id/1/200/200?blend=globex/1", router, http.StatusBadRequest, "Invalid image id\n", ""},
+		{"id of another tenant within a tenant", "/t/acme/id/1/200/200?blend=globex/1", router, http.StatusBadRequest, "Invalid image id\n", ""},
+		{"escaped id of another tenant", "/id/globex%2F1/info", router, http.StatusNotFound, "", ""},
+		{"tenants disabled", "/t/acme/id/1/info", disabledRouter, http.StatusNotFound, "", ""},
+		{"list of a tenant", "/t/acme/v2/list", router, http.StatusOK, `[{"id":"1","author":"Acme","width":300,"height":400,"url":"https://acme.example.com","download_url":"https://example.com/t/acme/id/1/300/400"}]` + "\n", ""},
+		{"list without a tenant", "/v2/list", router, http.StatusOK, `[{"id":"1","author":"John Doe","width":300,"height":400,"url":"https://picsum.photos","download_url":"https://example.com/id/1/300/400"}]` + "\n", ""},
+		{"page past the list of a tenant", "/t/acme/v2/list?page=2", router, http.StatusOK, "[]\n", ""},
+		{"list of an unknown tenant", "/t/initech/v2/list", router, http.StatusBadRequest, "Invalid tenant\n", ""},
+		{"random list of a tenant", "/t/globex/random?count=10", router, http.StatusOK, `[{"id":"1","author":"Globex","width":200,"height":100,"url":"https://globex.example.com","download_url":"https://example.com/t/globex/id/1/200/100"}]` + "\n", ""},
+		{"random list without a tenant", "/random?count=10", router, http.StatusOK, `[{"id":"1","author":"John Doe","width":300,"height":400,"url":"https://picsum.photos","download_url":"https://example.com/id/1/300/400"}]` + "\n", ""},
+		{"random image of a tenant", "/t/acme/200/200", router, http.StatusFound, "", imageServiceURL + "/t/acme/id/1/200/200.jpg"},
+		{"random image without a tenant", "/200/200", router, http.StatusFound, "", imageServiceURL + "/id/1/200/200.jpg"},
+		{"seeded image of a tenant", "/t/globex/seed/picsum/200/200", router, http.StatusFound, "", imageServiceURL + "/t/globex/id/1/200/200.jpg"},
+		{"seeded image without a tenant", "/seed/picsum/200/200", router, http.StatusFound, "", imageServiceURL + "/id/1/200/200.jpg"},
+		{"random image of an unknown tenant", "/t/initech/200/200", router, http.StatusBadRequest, "Invalid tenant\n", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestIgnoreUnknownAuto(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	ignoreRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, IgnoreUnknownAuto: true}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"unknown feature is rejected by default", "/id/1/200/300?auto=enhance", router, http.StatusBadRequest, ""},
+		{"unknown feature is ignored", "/id/1/200/300?auto=enhance", ignoreRouter, http.StatusFound, "/id/1/200/300.jpg"},
+		{"known features are kept", "/id/1/200/300?auto=enhance,format", ignoreRouter, http.StatusFound, "/id/1/200/300.jpg?format=auto"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedLocation == "" {
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestAlphaFormat(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	alphaRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, AlphaFormat: ".webp"}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"mask is rejected by default", "/id/1/200/200?mask=1", router, http.StatusBadRequest, ""},
+		{"mask is upgraded to webp", "/id/1/200/200?mask=1", alphaRouter, http.StatusFound, "/id/1/200/200.webp?mask=1"},
+		{"mask with the jpg extension is upgraded to webp", "/id/1/200/200.jpg?mask=1", alphaRouter, http.StatusFound, "/id/1/200/200.webp?mask=1"},
+		{"mask with automatic format selection is still rejected", "/id/1/200/200?mask=1&format=auto", alphaRouter, http.StatusBadRequest, ""},
+		{"image without a mask is kept as jpg", "/id/1/200/200", alphaRouter, http.StatusFound, "/id/1/200/200.jpg"},
+		{"background with alpha is rejected by default", "/id/1/200/200?ratio=4:3&bg=00000080", router, http.StatusBadRequest, ""},
+		{"background with alpha is upgraded to webp", "/id/1/200/200.jpg?ratio=4:3&bg=00000080", alphaRouter, http.StatusFound, "/id/1/200/200.webp?ratio=4:3&bg=00000080"},
+		{"transparent background is upgraded to webp", "/id/1/200/200?ratio=4:3&bg=transparent", alphaRouter, http.StatusFound, "/id/1/200/200.webp?ratio=4:3&bg=transparent"},
+		{"opaque background is kept as jpg", "/id/1/200/200?ratio=4:3&bg=000000", alphaRouter, http.StatusFound, "/id/1/200/200.jpg?ratio=4:3&bg=000000"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedLocation == "" {
+			continue
+		}
+
+		if location := w.Header().Get("Location"); location != imageServiceURL+test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestParseAlphaFormat(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected string
+		Error    bool
+	}{
+		{"", "", false},
+		{"webp", ".webp", false},
+		{"png", "", true},
+	}
+
+	for _, test := range tests {
+		extension, err := params.ParseAlphaFormat(test.Value)
+		if (err != nil) != test.Error {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+			continue
+		}
+
+		if extension != test.Expected {
+			t.Errorf("%q: wrong extension %q", test.Value, extension)
+		}
+	}
+}
+
+func TestUnprocessable(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	unprocessableRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, Unprocessable: true}).Router()
+
+	tests := []struct {
+		Name                  string
+		URL                   string
+		ExpectedStatus        int
+		ExpectedUnprocessable int
+	}{
+		{"malformed quality", "/id/1/100/100?quality=abc", http.StatusBadRequest, http.StatusBadRequest},
+		{"malformed size", "/id/1/800x", http.StatusBadRequest, http.StatusBadRequest},
+		{"quality out of range", "/id/1/100/100?quality=101", http.StatusBadRequest, http.StatusUnprocessableEntity},
+		{"blur out of range", "/id/1/100/100?blur=999", http.StatusBadRequest, http.StatusUnprocessableEntity},
+		{"size out of range", "/id/1/5500/1", http.StatusBadRequest, http.StatusUnprocessableEntity},
+		{"conflicting params", "/id/1/100/100?bg=000", http.StatusBadRequest, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		for _, mode := range []struct {
+			Router         http.Handler
+			ExpectedStatus int
+		}{{router, test.ExpectedStatus}, {unprocessableRouter, test.ExpectedUnprocessable}} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", test.URL, nil)
+			mode.Router.ServeHTTP(w, req)
+			if w.Code != mode.ExpectedStatus {
+				t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			}
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cacheKey := func(width string, height string, extension string, query string) string {
+		req, _ := http.NewRequest("GET", "/?"+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"width": width, "height": height, "extension": extension})
+
+		p, err := params.GetParams(req)
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+
+		return params.CacheKey("1", p)
+	}
+
+	equivalent := []struct {
+		Name string
+		A    string
+		B    string
+	}{
+		{"param order", "blur=2&grayscale&quality=80", "quality=80&grayscale&blur=2"},
+		{"default values", "", "gravity=center&blendopacity=1&saturation=1&dpr=1&fit=cover"},
+		{"default blur amount", "blur", "blur=5"},
+		{"case", "fit=Contain&bg=FFF", "bg=FFF&fit=contain"},
+		{"debug", "blur=2", "blur=2&debug"},
+		{"numeric formatting", "gamma=2.20", "gamma=2.2"},
+	}
+
+	for _, test := range equivalent {
+		if a, b := cacheKey("200", "300", "", test.A), cacheKey("200", "300", "", test.B); a != b {
+			t.Errorf("%s: different keys %s and %s", test.Name, a, b)
+		}
+	}
+
+	if a, b := cacheKey("200", "300", "", ""), cacheKey("200", "300", ".jpg", ""); a != b {
+		t.Errorf("default extension: different keys %s and %s", a, b)
+	}
+
+	different := []struct {
+		Name string
+		A    string
+		B    string
+	}{
+		{"blur amount", "blur=2", "blur=3"},
+		{"grayscale", "", "grayscale"},
+		{"quality", "quality=80", "quality=81"},
+		{"overlay", "overlay=1", "overlay=1&overlaypos=north"},
+		{"fit", "", "fit=fill"},
+	}
+
+	for _, test := range different {
+		if a, b := cacheKey("200", "300", "", test.A), cacheKey("200", "300", "", test.B); a == b {
+			t.Errorf("%s: same key %s", test.Name, a)
+		}
+	}
+
+	if a, b := cacheKey("200", "300", "", ""), cacheKey("300", "200", "", ""); a == b {
+		t.Errorf("dimensions: same key %s", a)
+	}
+
+	if a, b := cacheKey("200", "300", "", ""), cacheKey("200", "300", ".webp", ""); a == b {
+		t.Errorf("extension: same key %s", a)
+	}
+
+	// The settings of the service that affect the output are included as well
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = mux.SetURLVars(req, map[string]string{"width": "200", "height": "300"})
+	p, _ := params.GetParams(req)
+	key := params.CacheKey("1", p)
+
+	p.NoUpscale = true
+	p.Rounding = params.Floor
+	if params.CacheKey("1", p) == key {
+		t.Errorf("same key %s for the service settings", key)
+	}
+
+	// The processing version changes the key, and the key stays the same for the same version
+	p, _ = params.GetParams(req)
+	p.ProcessingVersion = params.ProcessingVersion
+	versioned := params.CacheKey("1", p)
+	if versioned == key {
+		t.Errorf("same key %s with the processing version", key)
+	}
+
+	if again := params.CacheKey("1", p); again != versioned {
+		t.Errorf("different keys %s and %s for the same processing version", versioned, again)
+	}
+
+	p.ProcessingVersion = "0"
+	if params.CacheKey("1", p) == versioned {
+		t.Errorf("same key %s for another processing version", versioned)
+	}
+}
+
+func TestAspect(t *testing.T) {
+	f := newFixtures("metadata_aspect.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedCache    string
+	}{
+		{"landscape", "/id/landscape/aspect", http.StatusOK, `{"ratio":1.7778,"orientation":"landscape","width":1920,"height":1080}` + "\n", "public, max-age=2592000"},
+		{"portrait", "/id/portrait/aspect", http.StatusOK, `{"ratio":0.75,"orientation":"portrait","width":300,"height":400}` + "\n", "public, max-age=2592000"},
+		{"square", "/id/square/aspect", http.StatusOK, `{"ratio":1,"orientation":"square","width":500,"height":500}` + "\n", "public, max-age=2592000"},
+		{"nonexistent image", "/id/nonexistant/aspect", http.StatusNotFound, "Image does not exist\n", "no-cache, no-store, must-revalidate"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != test.ExpectedCache {
+			t.Errorf("%s: wrong cache control %s", test.Name, cacheControl)
+		}
+	}
+}
+
+func TestBulkInfo(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	pattern, _ := params.ParseImageIDPattern(params.DefaultImageIDPattern)
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, ImageIDPattern: pattern}).Router()
+
+	info := `{"id":"1","author":"John Doe","width":300,"height":400,"url":"https://picsum.photos","download_url":"https://example.com/id/1/300/400"}`
+
+	tests := []struct {
+		Name             string
+		URL              string
+		ExpectedStatus   int
+		ExpectedResponse string
+	}{
+		{"single image", "/info?ids=1", http.StatusOK, `{"1":` + info + "}\n"},
+		{"existing and missing images", "/info?ids=1,nonexistant", http.StatusOK, `{"1":` + info + `,"nonexistant":null}` + "\n"},
+		{"invalid id", "/info?ids=1,a-b", http.StatusOK, `{"1":` + info + `,"a-b":null}` + "\n"},
+		{"duplicate ids", "/info?ids=1,1", http.StatusOK, `{"1":` + info + "}\n"},
+		{"missing ids", "/info", http.StatusBadRequest, "Invalid ids\n"},
+		{"too many ids", "/info?ids=" + strings.Repeat("1,", 100) + "1", http.StatusBadRequest, "Too many ids, at most 100 are allowed\n"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	signer := &signature.Signer{Key: []byte("secret")}
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, Signer: signer}).Router()
+
+	tests := []struct {
+		Name          string
+		URL           string
+		ExpectedPath  string
+		ExpectedQuery url.Values
+	}{
+		{"without params", "/id/1/200/300", "/id/1/200/300.jpg", url.Values{}},
+		{"with params", "/id/1/200/300?blur=2&grayscale", "/id/1/200/300.jpg", url.Values{"blur": {"2"}, "grayscale": {""}}},
+		{"with text", "/id/1/200/300?text=hello%20world", "/id/1/200/300.jpg", url.Values{"text": {"hello world"}}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Errorf("%s: invalid redirect %s", test.Name, w.Header().Get("Location"))
+			continue
+		}
+
+		sig, path, ok := signature.SplitPath(location.Path)
+		if !ok || path != test.ExpectedPath || !reflect.DeepEqual(location.Query(), test.ExpectedQuery) {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+			continue
+		}
+
+		if !signer.Valid(sig, path, location.Query()) {
+			t.Errorf("%s: invalid signature %s", test.Name, sig)
+		}
+	}
+}
+
+func TestDisabledEffects(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	disabled, _ := params.ParseDisabledEffects("blur, text", false)
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, DisabledEffects: disabled}).Router()
+
+	ignored, _ := params.ParseDisabledEffects("blur,text", true)
+	ignoringRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, DisabledEffects: ignored}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+	}{
+		{"disabled effect", "/id/1/200/300?blur", router, http.StatusBadRequest, "Effect disabled: blur\n", ""},
+		{"disabled effect with other params", "/id/1/200/300?grayscale&text=hello", router, http.StatusBadRequest, "Effect disabled: text\n", ""},
+		{"invalid params of a disabled effect", "/id/1/200/300?blur=100", router, http.StatusBadRequest, "Effect disabled: blur\n", ""},
+		{"enabled effect", "/id/1/200/300?grayscale", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale"},
+		{"ignored effect", "/id/1/200/300?blur&grayscale", ignoringRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale"},
+		{"ignored effect with the params that require it", "/id/1/200/300?blur=100&bluredge=mirror&blurregion=0,0,10,10", ignoringRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg"},
+		{"ignored text with gravity", "/id/1/200/300?text=hello&gravity=north", ignoringRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	t.Run("rejects unknown effects", func(t *testing.T) {
+		if _, err := params.ParseDisabledEffects("blur,grain", false); err == nil {
+			t.Error("no error for an unknown effect")
+		}
+	})
+}
+
+func TestMaxEffects(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, MaxEffects: 2}).Router()
+	unlimitedRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	ignored, _ := params.ParseDisabledEffects("blur", true)
+	ignoringRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, DisabledEffects: ignored, MaxEffects: 2}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+	}{
+		{"below the max", "/id/1/200/300?grayscale", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale"},
+		{"at the max", "/id/1/200/300?grayscale&sepia", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale&sepia=100"},
+		{"above the max", "/id/1/200/300?grayscale&sepia&blur", router, http.StatusBadRequest, "Too many effects: 3 of at most 2\n", ""},
+		{"the same effect with more params", "/id/1/200/300?blur=5&bluredge=mirror&grayscale", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?blur=5&bluredge=mirror&grayscale"},
+		{"ignored effects don't count", "/id/1/200/300?grayscale&sepia&blur", ignoringRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale&sepia=100"},
+		{"no max", "/id/1/200/300?grayscale&sepia&blur", unlimitedRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?blur=5&grayscale&sepia=100"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	filters, err := params.ParseFilters(params.DefaultFilters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	custom, err := params.ParseFilters("noir=grayscale&contrast=30;Soft=brightness=10&sat=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled, _ := params.ParseDisabledEffects("sepia", false)
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, Filters: filters}).Router()
+	customRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, Filters: custom}).Router()
+	unconfiguredRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	limitedRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, MaxEffects: 2, Filters: filters}).Router()
+	disabledRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, DisabledEffects: disabled, Filters: filters}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+	}{
+		{"default filter", "/id/1/200/300?filter=vintage", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?saturation=0.8&sepia=40&contrast=-10&vignette=30"},
+		{"filter name is case insensitive", "/id/1/200/300?filter=Vintage", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?saturation=0.8&sepia=40&contrast=-10&vignette=30"},
+		{"adjustments in the request take precedence", "/id/1/200/300?filter=vintage&sepia=80&bri=5", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?saturation=0.8&sepia=80&brightness=5&contrast=-10&vignette=30"},
+		{"filter with a colorize", "/id/1/200/300?filter=cool", router, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?colorize=210&brightness=5"},
+		{"custom filter", "/id/1/200/300?filter=noir", customRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?grayscale&contrast=30"},
+		{"custom filter with an alias", "/id/1/200/300?filter=soft", customRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg?saturation=0.5&brightness=10"},
+		{"default filters are replaced by the custom ones", "/id/1/200/300?filter=vintage", customRouter, http.StatusBadRequest, "Invalid filter\n", ""},
+		{"unknown filter", "/id/1/200/300?filter=sepiatone", router, http.StatusBadRequest, "Invalid filter\n", ""},
+		{"no filters configured", "/id/1/200/300?filter=vintage", unconfiguredRouter, http.StatusBadRequest, "Invalid filter\n", ""},
+		{"empty filter", "/id/1/200/300?filter=", unconfiguredRouter, http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg"},
+		{"adjustments of the filter count towards the max effects", "/id/1/200/300?filter=vintage", limitedRouter, http.StatusBadRequest, "Too many effects: 4 of at most 2\n", ""},
+		{"adjustments of the filter can be disabled", "/id/1/200/300?filter=vintage", disabledRouter, http.StatusBadRequest, "Effect disabled: sepia\n", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	t.Run("rejects invalid filters", func(t *testing.T) {
+		for _, value := range []string{
+			"vintage",
+			"=sepia=40",
+			"vintage=blur=5",
+			"vintage=sepia=200",
+			"vintage=contrast=high",
+			"vintage=colorize=400",
+			"vintage=sepia=40;Vintage=contrast=10",
+		} {
+			if _, err := params.ParseFilters(value); err == nil {
+				t.Errorf("no error for %q", value)
+			}
+		}
+	})
+}
+
+func TestBasePath(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, BasePath: "/images"}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+		ExpectedLink     string
+	}{
+		{"image info", "/images/id/1/info", http.StatusOK, `{"id":"1","author":"John Doe","width":300,"height":400,"url":"https://picsum.photos","download_url":"https://example.com/images/id/1/300/400"}` + "\n", "", ""},
+		{"image redirect", "/images/id/1/200/300", http.StatusFound, "", imageServiceURL + "/id/1/200/300.jpg", ""},
+		{"srcset", "/images/id/1/srcset?widths=300", http.StatusOK, "https://example.com/images/id/1/300/400.jpg 300w\n", "", ""},
+		{"list", "/images/v2/list?limit=1", http.StatusOK, "", "", `<https://example.com/images/v2/list?page=2&limit=1>; rel="next"`},
+		{"health", "/images/health", http.StatusOK, "", "", ""},
+		{"static files", "/images/assets/images/favicon/favicon.ico", http.StatusOK, "", "", ""},
+		{"routes outside the base path", "/id/1/info", http.StatusNotFound, "", "", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+
+		if link := w.Header().Get("Link"); test.ExpectedLink != "" && link != test.ExpectedLink {
+			t.Errorf("%s: wrong link %s", test.Name, link)
+		}
+	}
+}
+
+func TestAspectRatioCrop(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	// The image is 300x400
+	tests := []struct {
+		Name             string
+		URL              string
+		ExpectedStatus   int
+		ExpectedResponse string
+		ExpectedLocation string
+	}{
+		{"native resolution", "/id/1/0/0?crop=ar:16:9", http.StatusFound, "", imageServiceURL + "/id/1/300/169.jpg?crop=ar:16:9"},
+		{"same ratio as the source", "/id/1/0/0?crop=ar:3:4", http.StatusFound, "", imageServiceURL + "/id/1/300/400.jpg?crop=ar:3:4"},
+		{"taller than the source", "/id/1/0/0?crop=ar:1:2", http.StatusFound, "", imageServiceURL + "/id/1/200/400.jpg?crop=ar:1:2"},
+		{"width of 0", "/id/1/0/100?crop=ar:1:1", http.StatusFound, "", imageServiceURL + "/id/1/300/100.jpg?crop=ar:1:1"},
+		{"resized", "/id/1/160/90?crop=ar:16:9", http.StatusFound, "", imageServiceURL + "/id/1/160/90.jpg?crop=ar:16:9"},
+		{"with gravity", "/id/1/0/0?crop=AR:16:9&gravity=north", http.StatusFound, "", imageServiceURL + "/id/1/300/169.jpg?crop=ar:16:9&gravity=north"},
+		{"missing term", "/id/1/0/0?crop=ar:16", http.StatusBadRequest, "Invalid crop\n", ""},
+		{"zero term", "/id/1/0/0?crop=ar:0:9", http.StatusBadRequest, "Invalid crop\n", ""},
+		{"fractional term", "/id/1/0/0?crop=ar:1.5:1", http.StatusBadRequest, "Invalid crop\n", ""},
+		{"too extreme", "/id/1/0/0?crop=ar:5001:1", http.StatusBadRequest, "Invalid crop\n", ""},
+		{"with trim", "/id/1/0/0?crop=ar:16:9&trim", http.StatusBadRequest, "Conflicting params: crop conflicts with trim\n", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedResponse != "" && w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	regions := []struct {
+		Name           string
+		Image          database.Image
+		Ratio          params.AspectRatio
+		Gravity        string
+		Rounding       params.Rounding
+		ExpectedRegion params.Region
+	}{
+		{"portrait to landscape", database.Image{Width: 300, Height: 400}, params.AspectRatio{Width: 16, Height: 9}, params.GravityCenter, params.Round, params.Region{X: 0, Y: 116, Width: 300, Height: 169}},
+		{"portrait to landscape, floored", database.Image{Width: 300, Height: 400}, params.AspectRatio{Width: 16, Height: 9}, params.GravityCenter, params.Floor, params.Region{X: 0, Y: 116, Width: 300, Height: 168}},
+		{"landscape to square", database.Image{Width: 200, Height: 100}, params.AspectRatio{Width: 1, Height: 1}, params.GravityCenter, params.Round, params.Region{X: 50, Y: 0, Width: 100, Height: 100}},
+		{"landscape to wider", database.Image{Width: 200, Height: 100}, params.AspectRatio{Width: 4, Height: 1}, params.GravityCenter, params.Round, params.Region{X: 0, Y: 25, Width: 200, Height: 50}},
+		{"square to portrait", database.Image{Width: 500, Height: 500}, params.AspectRatio{Width: 2, Height: 3}, params.GravityCenter, params.Round, params.Region{X: 84, Y: 0, Width: 333, Height: 500}},
+		{"same ratio", database.Image{Width: 1920, Height: 1080}, params.AspectRatio{Width: 16, Height: 9}, params.GravityCenter, params.Round, params.Region{X: 0, Y: 0, Width: 1920, Height: 1080}},
+		{"thin strip", database.Image{Width: 1000, Height: 7}, params.AspectRatio{Width: 1, Height: 1}, params.GravityCenter, params.Round, params.Region{X: 497, Y: 0, Width: 7, Height: 7}},
+		{"west", database.Image{Width: 200, Height: 100}, params.AspectRatio{Width: 1, Height: 1}, params.GravityWest, params.Round, params.Region{X: 0, Y: 0, Width: 100, Height: 100}},
+		{"east", database.Image{Width: 200, Height: 100}, params.AspectRatio{Width: 1, Height: 1}, params.GravityEast, params.Round, params.Region{X: 100, Y: 0, Width: 100, Height: 100}},
+		{"north", database.Image{Width: 300, Height: 400}, params.AspectRatio{Width: 16, Height: 9}, params.GravityNorth, params.Round, params.Region{X: 0, Y: 0, Width: 300, Height: 169}},
+		{"south", database.Image{Width: 300, Height: 400}, params.AspectRatio{Width: 16, Height: 9}, params.GravitySouth, params.Round, params.Region{X: 0, Y: 231, Width: 300, Height: 169}},
+		{"southeast only moves along the cropped side", database.Image{Width: 200, Height: 100}, params.AspectRatio{Width: 1, Height: 1}, params.GravitySouthEast, params.Round, params.Region{X: 100, Y: 0, Width: 100, Height: 100}},
+	}
+
+	for _, test := range regions {
+		p := &params.Params{Crop: params.Crop{Ratio: test.Ratio}, Gravity: test.Gravity, Rounding: test.Rounding}
+		if region := p.CropRegion(&test.Image); region != test.ExpectedRegion {
+			t.Errorf("%s: wrong region %+v", test.Name, region)
+		}
+	}
+}
+
+func TestCropBounds(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	strictRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+	clampRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, CropBounds: params.CropClamp}).Router()
+
+	// The image is 300x400
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"partially outside with strict", "/id/1/100/100?crop=250,350,100,100", strictRouter, http.StatusBadRequest, ""},
+		{"partially outside with clamp", "/id/1/100/100?crop=250,350,100,100", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?crop=250,350,50,50"},
+		{"width outside with clamp", "/id/1/100/100?crop=100,0,300,100", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?crop=100,0,200,100"},
+		{"inside with clamp", "/id/1/100/100?crop=0,0,100,100", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?crop=0,0,100,100"},
+		{"entirely outside with clamp", "/id/1/100/100?crop=300,0,100,100", clampRouter, http.StatusBadRequest, ""},
+		{"size of the clamped crop", "/id/1/0/0?crop=250,350,100,100", clampRouter, http.StatusFound, imageServiceURL + "/id/1/50/50.jpg?crop=250,350,50,50"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus == http.StatusBadRequest && w.Body.String() != "Invalid crop\n" {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	t.Run("rejects unknown policies", func(t *testing.T) {
+		if _, err := params.ParseCropBounds("wrap"); err == nil {
+			t.Error("no error for an unknown policy")
+		}
+	})
+}
+
+func TestQualityBounds(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	rejectBounds, err := params.ParseQualityBounds("jpeg:10-90;webp:20-80", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clampBounds, err := params.ParseQualityBounds("jpeg:10-90;webp:20-80", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejectRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, QualityBounds: rejectBounds}).Router()
+	clampRouter := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute, QualityBounds: clampBounds}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		ExpectedStatus   int
+		ExpectedLocation string
+	}{
+		{"jpeg at the max with reject", "/id/1/100/100?quality=90", rejectRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?quality=90"},
+		{"jpeg at the min with reject", "/id/1/100/100?quality=10", rejectRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?quality=10"},
+		{"jpeg above the max with reject", "/id/1/100/100?quality=91", rejectRouter, http.StatusBadRequest, ""},
+		{"jpeg below the min with reject", "/id/1/100/100?quality=9", rejectRouter, http.StatusBadRequest, ""},
+		{"webp at the max with reject", "/id/1/100/100.webp?quality=80", rejectRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.webp?quality=80"},
+		{"webp above the max with reject", "/id/1/100/100.webp?quality=81", rejectRouter, http.StatusBadRequest, ""},
+		{"webp below the min with reject", "/id/1/100/100.webp?quality=19", rejectRouter, http.StatusBadRequest, ""},
+		{"auto format within jpeg but not webp with reject", "/id/1/100/100?quality=85&format=auto", rejectRouter, http.StatusBadRequest, ""},
+		{"jpeg above the max with clamp", "/id/1/100/100?quality=95", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?quality=90"},
+		{"jpeg below the min with clamp", "/id/1/100/100?quality=5", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?quality=10"},
+		{"webp above the max with clamp", "/id/1/100/100.webp?quality=95", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.webp?quality=80"},
+		{"webp below the min with clamp", "/id/1/100/100.webp?quality=5", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.webp?quality=20"},
+		{"auto format with clamp", "/id/1/100/100?quality=85&format=auto", clampRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg?format=auto&quality=80"},
+		{"outside of the quality param with clamp", "/id/1/100/100?quality=101", clampRouter, http.StatusBadRequest, ""},
+		{"no quality with reject", "/id/1/100/100", rejectRouter, http.StatusFound, imageServiceURL + "/id/1/100/100.jpg"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus == http.StatusBadRequest && !strings.HasPrefix(w.Body.String(), "Invalid quality") {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+
+		if location := w.Header().Get("Location"); location != test.ExpectedLocation {
+			t.Errorf("%s: wrong redirect %s", test.Name, location)
+		}
+	}
+
+	t.Run("rejects invalid bounds", func(t *testing.T) {
+		for _, value := range []string{"jpeg", "png:1-90", "jpeg:90", "jpeg:0-90", "jpeg:10-101", "jpeg:90-10", "jpeg:a-90"} {
+			if _, err := params.ParseQualityBounds(value, false); err == nil {
+				t.Errorf("%q: no error for invalid bounds", value)
+			}
+		}
+	})
+}
+
+func TestRandomList(t *testing.T) {
+	f := newFixtures("metadata_multiple.json")
+	defer f.Close()
+
+	router := (&api.API{Database: f.Database, HealthChecker: f.Checker, Log: f.Log, RootURL: rootURL, ImageServiceURL: imageServiceURL, StaticPath: "../../web", HandlerTimeout: time.Minute}).Router()
+
+	getIDs := func(t *testing.T, url string) []string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: wrong response code, %#v", url, w.Code)
+		}
+
+		var list []api.ListImage
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]string, len(list))
+		for i, image := range list {
+			ids[i] = image.ID
+		}
+
+		return ids
+	}
+
+	t.Run("returns distinct images", func(t *testing.T) {
+		// The database has two images, so asking for more returns both of them once
+		for i := 0; i < 20; i++ {
+			ids := getIDs(t, "/random?count=5")
+			if len(ids) != 2 || ids[0] == ids[1] {
+				t.Fatalf("wrong images %v", ids)
+			}
+		}
+
+		if ids := getIDs(t, "/random"); len(ids) != 1 {
+			t.Errorf("wrong amount of images by default %v", ids)
+		}
+	})
+
+	t.Run("returns the same images for a seed", func(t *testing.T) {
+		expected := getIDs(t, "/random?count=2&seed=gallery")
+		for i := 0; i < 5; i++ {
+			if ids := getIDs(t, "/random?count=2&seed=gallery"); !reflect.DeepEqual(ids, expected) {
+				t.Errorf("wrong images %v for the seed, expected %v", ids, expected)
+			}
+		}
+
+		// A smaller count is the start of the same selection
+		if ids := getIDs(t, "/random?count=1&seed=gallery"); !reflect.DeepEqual(ids, expected[:1]) {
+			t.Errorf("wrong images %v for a smaller count, expected %v", ids, expected[:1])
+		}
+	})
+
+	t.Run("rejects invalid counts", func(t *testing.T) {
+		for _, count := range []string{"0", "101", "abc"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/random?count="+count, nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("count %s: wrong response code, %#v", count, w.Code)
+			}
+		}
+	})
+}
+
+// fixtures are the dependencies that the tests create an API with, backed by the metadata in test/fixtures/file
+type fixtures struct {
+	Ctx      context.Context
+	Log      *logger.Logger
+	Database *fileDatabase.Provider
+	Checker  *health.Checker
+	cancel   context.CancelFunc
+}
+
+// newFixtures loads the images of the metadata file in test/fixtures/file, and runs the health checker on them
+// Close stops the health checker once the test is done
+func newFixtures(metadata string) *fixtures {
+	ctx, cancel := context.WithCancel(context.Background())
+	log := logger.New(zap.FatalLevel)
+
+	db, _ := fileDatabase.New("../../test/fixtures/file/" + metadata)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Database: db,
+		Log:      log,
+	}
+	checker.Run()
+
+	return &fixtures{
+		Ctx:      ctx,
+		Log:      log,
+		Database: db,
+		Checker:  checker,
+		cancel:   cancel,
+	}
+}
+
+// Close stops the health checker and flushes the logger
+func (f *fixtures) Close() {
+	f.cancel()
+	f.Log.Sync()
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/gorilla/mux"
+)
+
+// Orientations of an image
+const (
+	orientationLandscape = "landscape"
+	orientationPortrait  = "portrait"
+	orientationSquare    = "square"
+)
+
+// Aspect contains the aspect ratio and orientation of an image, for layout calculations
+type Aspect struct {
+	Ratio       float64 `json:"ratio"`
+	Orientation string  `json:"orientation"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+}
+
+// Returns the aspect ratio and orientation of an image
+// The size of an image never changes, so it's cached like the images themselves
+func (a *API) aspectHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	vars := mux.Vars(r)
+	image, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	orientation := orientationSquare
+	if image.Width > image.Height {
+		orientation = orientationLandscape
+	} else if image.Width < image.Height {
+		orientation = orientationPortrait
+	}
+
+	aspect := Aspect{
+		Ratio:       math.Round(float64(image.Width)/float64(image.Height)*10000) / 10000,
+		Orientation: orientation,
+		Width:       image.Width,
+		Height:      image.Height,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+
+	if err := json.NewEncoder(w).Encode(aspect); err != nil {
+		a.logError(r, "error encoding image aspect", err)
+		return handler.InternalServerError()
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// Capabilities describes what the server supports with its current configuration
+type Capabilities struct {
+	params.Capabilities
+	Presets   []string `json:"presets"`
+	NoUpscale bool     `json:"noupscale"`
+}
+
+// Returns what the server supports, so that clients can avoid sending unsupported params
+func (a *API) capabilitiesHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	presets := make([]string, 0, len(a.Presets))
+	for name := range a.Presets {
+		presets = append(presets, name)
+	}
+	sort.Strings(presets)
+
+	capabilities := Capabilities{
+		Capabilities: params.GetCapabilities(),
+		Presets:      presets,
+		NoUpscale:    a.NoUpscale,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	if err := json.NewEncoder(w).Encode(capabilities); err != nil {
+		a.logError(r, "error encoding capabilities", err)
+		return handler.InternalServerError()
+	}
+
+	return nil
+}
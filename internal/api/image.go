@@ -2,7 +2,10 @@ package api
 
 import (
 	"fmt"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/DMarby/picsum-photos/internal/handler"
@@ -30,6 +33,31 @@ func (a *API) imageRedirectHandler(w http.ResponseWriter, r *http.Request) *hand
 	return a.validateAndRedirect(w, r, p, image)
 }
 
+func (a *API) presetImageRedirectHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	// Get the preset
+	vars := mux.Vars(r)
+	preset, ok := a.Presets[vars["preset"]]
+	if !ok {
+		return &handler.Error{Message: "Preset does not exist", Code: http.StatusNotFound}
+	}
+
+	// Resolve the preset into the path and query parameters
+	p, err := params.GetPresetParams(r, preset)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	// Get the image from the database
+	imageID := vars["id"]
+	image, handlerErr := a.getImage(r, imageID)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	// Validate the params and redirect to the image service
+	return a.validateAndRedirect(w, r, p, image)
+}
+
 func (a *API) randomImageRedirectHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
 	// Get the path and query parameters
 	p, err := params.GetParams(r)
@@ -38,10 +66,9 @@ func (a *API) randomImageRedirectHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get a random image
-	image, err := a.Database.GetRandom()
-	if err != nil {
-		a.logError(r, "error getting random image from database", err)
-		return handler.InternalServerError()
+	image, handlerErr := a.getRandomImage(r, 0, false)
+	if handlerErr != nil {
+		return handlerErr
 	}
 
 	// Validate the params and redirect to the image service
@@ -63,10 +90,9 @@ func (a *API) seedImageRedirectHandler(w http.ResponseWriter, r *http.Request) *
 	murmurHash := murmur3.Sum64([]byte(imageSeed))
 
 	// Get a random image by the hash
-	image, err := a.Database.GetRandomWithSeed(int64(murmurHash))
-	if err != nil {
-		a.logError(r, "error getting random image from database", err)
-		return handler.InternalServerError()
+	image, handlerErr := a.getRandomImage(r, int64(murmurHash), true)
+	if handlerErr != nil {
+		return handlerErr
 	}
 
 	// Validate the params and redirect to the image service
@@ -74,6 +100,12 @@ func (a *API) seedImageRedirectHandler(w http.ResponseWriter, r *http.Request) *
 }
 
 func (a *API) getImage(r *http.Request, imageID string) (*database.Image, *handler.Error) {
+	// Reject malformed ids before they reach the database, and scope them to the tenant
+	imageID, err := params.ResolveImageID(r, a.ImageIDPattern, a.TenantPattern, imageID)
+	if err != nil {
+		return nil, handler.BadRequest(err.Error())
+	}
+
 	databaseImage, err := a.Database.Get(imageID)
 	if err != nil {
 		if err == database.ErrNotFound {
@@ -87,16 +119,150 @@ func (a *API) getImage(r *http.Request, imageID string) (*database.Image, *handl
 	return databaseImage, nil
 }
 
+// getRandomImage returns a random image, or the image picked by the seed when seeded
+// With tenants enabled, it's picked from the images of the tenant of the route, so that the images of tenants aren't served outside of it
+func (a *API) getRandomImage(r *http.Request, seed int64, seeded bool) (*database.Image, *handler.Error) {
+	if a.TenantPattern == nil {
+		var image *database.Image
+		var err error
+		if seeded {
+			image, err = a.Database.GetRandomWithSeed(seed)
+		} else {
+			image, err = a.Database.GetRandom()
+		}
+
+		if err != nil {
+			a.logError(r, "error getting random image from database", err)
+			return nil, handler.InternalServerError()
+		}
+
+		return image, nil
+	}
+
+	images, handlerErr := a.tenantImages(r)
+	if handlerErr != nil {
+		return nil, handlerErr
+	}
+
+	if len(images) == 0 {
+		return nil, &handler.Error{Message: database.ErrNotFound.Error(), Code: http.StatusNotFound}
+	}
+
+	i := rand.Intn(len(images))
+	if seeded {
+		i = rand.New(rand.NewSource(seed)).Intn(len(images))
+	}
+
+	return &images[i], nil
+}
+
+// getMask gets the mask image from the database, with an error that tells it apart from the image itself
+func (a *API) getMask(r *http.Request, imageID string) (*database.Image, *handler.Error) {
+	databaseImage, handlerErr := a.getImage(r, imageID)
+	if handlerErr != nil && handlerErr.Code == http.StatusNotFound {
+		return nil, &handler.Error{Message: fmt.Sprintf("Mask image %s does not exist", imageID), Code: http.StatusNotFound}
+	}
+
+	return databaseImage, handlerErr
+}
+
 func (a *API) validateAndRedirect(w http.ResponseWriter, r *http.Request, p *params.Params, image *database.Image) *handler.Error {
+	// Params in the URL always take precedence over the client hints
+	if a.ClientHints {
+		params.ApplyClientHints(r, p, image)
+		w.Header().Set("Vary", strings.Join(params.ClientHints, ", "))
+	}
+
+	// The default fit of the deployment is validated along with the other params, and is always passed on to the image service
+	if p.Fit == "" {
+		p.Fit = a.DefaultFit
+	}
+
+	p.IgnoreUnknownAuto = a.IgnoreUnknownAuto
+	p.DisabledEffects = a.DisabledEffects
+	p.MaxEffects = a.MaxEffects
+	p.Filters = a.Filters
+	p.CropBounds = a.CropBounds
+	p.QualityBounds = a.QualityBounds
+	p.Rounding = a.Rounding
+
+	// The size limits are checked on the output size, which noupscale and the rounding change
+	if a.NoUpscale {
+		p.NoUpscale = true
+	}
+
+	p.UpgradeAlphaFormat(a.AlphaFormat)
+
 	if err := p.Validate(image); err != nil {
-		return handler.BadRequest(err.Error())
+		return a.invalidParams(err)
+	}
+
+	// The image to blend with has to exist as well
+	if p.Blend != "" {
+		if _, handlerErr := a.getImage(r, p.Blend); handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	// And the image to overlay
+	if p.Overlay != "" {
+		if _, handlerErr := a.getImage(r, p.Overlay); handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	// As does the mask
+	if p.Mask != "" {
+		if _, handlerErr := a.getMask(r, p.Mask); handlerErr != nil {
+			return handlerErr
+		}
 	}
 
 	width, height := p.Dimensions(image)
 
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header()["Content-Type"] = nil
-	http.Redirect(w, r, fmt.Sprintf("%s/id/%s/%d/%d%s%s", a.ImageServiceURL, image.ID, width, height, p.Extension, params.BuildQuery(p.Grayscale, p.Blur, p.BlurAmount)), http.StatusFound)
+
+	// The redirect already has the clamped size, so tell the client that it's smaller than requested
+	if clampedWidth, clampedHeight, clamped := p.ClampedDimensions(image); clamped {
+		w.Header().Set("X-Image-Clamped", "true")
+		w.Header().Set("X-Image-Dimensions", fmt.Sprintf("%dx%d", clampedWidth, clampedHeight))
+	}
+
+	path := fmt.Sprintf("%s/%d/%d%s", imagePath(image.ID), width, height, p.Extension)
+	query := params.BuildQuery(p)
+
+	// Sign the path and query for image services that only process signed urls
+	if a.Signer != nil {
+		values, err := url.ParseQuery(strings.TrimPrefix(query, "?"))
+		if err != nil {
+			a.logError(r, "error parsing query to sign", err)
+			return handler.InternalServerError()
+		}
+
+		path = a.Signer.SignPath(path, values)
+	}
+
+	http.Redirect(w, r, a.ImageServiceURL+path+query, http.StatusFound)
 
 	return nil
 }
+
+// imagePath returns the path of the routes for an image, under the tenant for the images of tenants
+func imagePath(id string) string {
+	if tenant, imageID := params.SplitTenantImageID(id); tenant != "" {
+		return fmt.Sprintf("/t/%s/id/%s", tenant, imageID)
+	}
+
+	return "/id/" + id
+}
+
+// invalidParams returns the error for params that are well-formed, but out of range or contradicting each other
+// They're bad requests like malformed params, unless the API is configured to tell them apart as unprocessable
+func (a *API) invalidParams(err error) *handler.Error {
+	if a.Unprocessable {
+		return handler.UnprocessableEntity(err.Error())
+	}
+
+	return handler.BadRequest(err.Error())
+}
@@ -3,12 +3,17 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/params"
 	"github.com/gorilla/mux"
+	"github.com/twmb/murmur3"
 )
 
 const (
@@ -16,6 +21,10 @@ const (
 	defaultLimit = 30
 	// Max number of items per page
 	maxLimit = 100
+	// Max number of ids of a bulk info request
+	maxInfoIDs = 100
+	// Max number of images of a random list
+	maxRandomCount = 100
 )
 
 // ListImage contains metadata and download information about an image
@@ -27,15 +36,9 @@ type ListImage struct {
 // Returns info about an image
 func (a *API) infoHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
 	vars := mux.Vars(r)
-	imageID := vars["id"]
-	image, err := a.Database.Get(imageID)
-	if err != nil {
-		if err == database.ErrNotFound {
-			return &handler.Error{Message: err.Error(), Code: http.StatusNotFound}
-		}
-
-		a.logError(r, "error getting image from database", err)
-		return handler.InternalServerError()
+	image, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
 	}
 
 	listImage := a.getListImage(*image)
@@ -51,6 +54,52 @@ func (a *API) infoHandler(w http.ResponseWriter, r *http.Request) *handler.Error
 	return nil
 }
 
+// Returns info about several images, with a comma separated `ids` query parameter
+// The info is keyed by the requested ids, where images that don't exist or have an invalid id are null
+func (a *API) bulkInfoHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	query := r.URL.Query().Get("ids")
+	if query == "" {
+		return handler.BadRequest("Invalid ids")
+	}
+
+	ids := strings.Split(query, ",")
+	if len(ids) > maxInfoIDs {
+		return handler.BadRequest(fmt.Sprintf("Too many ids, at most %d are allowed", maxInfoIDs))
+	}
+
+	images := map[string]*ListImage{}
+	for _, id := range ids {
+		// Invalid ids are left out like missing images, while an invalid tenant fails the request
+		if _, err := params.ResolveImageID(r, a.ImageIDPattern, a.TenantPattern, id); err == params.ErrInvalidImageID {
+			images[id] = nil
+			continue
+		}
+
+		image, handlerErr := a.getImage(r, id)
+		if handlerErr != nil {
+			if handlerErr.Code != http.StatusNotFound {
+				return handlerErr
+			}
+
+			images[id] = nil
+			continue
+		}
+
+		listImage := a.getListImage(*image)
+		images[id] = &listImage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	if err := json.NewEncoder(w).Encode(images); err != nil {
+		a.logError(r, "error encoding image info", err)
+		return handler.InternalServerError()
+	}
+
+	return nil
+}
+
 // Paginated list, with `page` and `limit` query parameters
 func (a *API) listHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
 	limit := getLimit(r)
@@ -58,10 +107,9 @@ func (a *API) listHandler(w http.ResponseWriter, r *http.Request) *handler.Error
 
 	offset := limit * (page - 1)
 
-	databaseList, err := a.Database.List(offset, limit)
-	if err != nil {
-		a.logError(r, "error getting image list from database", err)
-		return handler.InternalServerError()
+	databaseList, handlerErr := a.listImages(r, offset, limit)
+	if handlerErr != nil {
+		return handlerErr
 	}
 
 	list := []ListImage{}
@@ -76,7 +124,50 @@ func (a *API) listHandler(w http.ResponseWriter, r *http.Request) *handler.Error
 	// If we've ran out of items, don't include the next page in the Link header
 	end := len(list) < limit
 	w.Header().Set("Access-Control-Expose-Headers", "Link")
-	w.Header().Set("Link", a.getLinkHeader(page, limit, end))
+	w.Header().Set("Link", a.getLinkHeader(r, page, limit, end))
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		a.logError(r, "error encoding image list", err)
+		return handler.InternalServerError()
+	}
+
+	return nil
+}
+
+// Returns a list of distinct random images, with `count` and `seed` query parameters
+// The same seed returns the same images, as long as the images don't change
+func (a *API) randomListHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	count := 1
+	if value := r.URL.Query().Get("count"); value != "" {
+		var err error
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 1 || count > maxRandomCount {
+			return handler.BadRequest(fmt.Sprintf("Invalid count, it has to be between 1 and %d", maxRandomCount))
+		}
+	}
+
+	databaseList, handlerErr := a.tenantImages(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	source := rand.NewSource(time.Now().UnixNano())
+	if seed, ok := r.URL.Query()["seed"]; ok {
+		source = rand.NewSource(int64(murmur3.Sum64([]byte(seed[0]))))
+	}
+
+	// Picking from a permutation keeps the images distinct, and there can't be more of them than there are images
+	list := []ListImage{}
+	for _, i := range rand.New(source).Perm(len(databaseList)) {
+		if len(list) == count {
+			break
+		}
+
+		list = append(list, a.getListImage(databaseList[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 
 	if err := json.NewEncoder(w).Encode(list); err != nil {
 		a.logError(r, "error encoding image list", err)
@@ -108,30 +199,95 @@ func getPage(r *http.Request) int {
 	return page
 }
 
-func (a *API) getLinkHeader(page, limit int, end bool) string {
+func (a *API) getLinkHeader(r *http.Request, page, limit int, end bool) string {
+	// The pages of a tenant link to the list of the tenant
+	listURL := a.rootURL() + "/v2/list"
+	if tenant, ok := mux.Vars(r)["tenant"]; ok {
+		listURL = fmt.Sprintf("%s/t/%s/v2/list", a.rootURL(), tenant)
+	}
+
 	// This will return a next even if there's only enough items for a single page, but lets ignore that for now
 	if page == 1 {
-		return fmt.Sprintf("<%s/v2/list?page=%d&limit=%d>; rel=\"next\"", a.RootURL, page+1, limit)
+		return fmt.Sprintf("<%s?page=%d&limit=%d>; rel=\"next\"", listURL, page+1, limit)
 	}
 
 	if end {
-		return fmt.Sprintf("<%s/v2/list?page=%d&limit=%d>; rel=\"prev\"", a.RootURL, page-1, limit)
+		return fmt.Sprintf("<%s?page=%d&limit=%d>; rel=\"prev\"", listURL, page-1, limit)
 	}
 
-	return fmt.Sprintf("<%s/v2/list?page=%d&limit=%d>; rel=\"prev\", <%s/v2/list?page=%d&limit=%d>; rel=\"next\"",
-		a.RootURL, page-1, limit, a.RootURL, page+1, limit,
+	return fmt.Sprintf("<%s?page=%d&limit=%d>; rel=\"prev\", <%s?page=%d&limit=%d>; rel=\"next\"",
+		listURL, page-1, limit, listURL, page+1, limit,
 	)
 }
 
+// listImages returns a page of the images, out of the images of the tenant of the route when tenants are enabled
+func (a *API) listImages(r *http.Request, offset, limit int) ([]database.Image, *handler.Error) {
+	if a.TenantPattern == nil {
+		databaseList, err := a.Database.List(offset, limit)
+		if err != nil {
+			a.logError(r, "error getting image list from database", err)
+			return nil, handler.InternalServerError()
+		}
+
+		return databaseList, nil
+	}
+
+	databaseList, handlerErr := a.tenantImages(r)
+	if handlerErr != nil {
+		return nil, handlerErr
+	}
+
+	if offset > len(databaseList) {
+		return []database.Image{}, nil
+	}
+
+	if end := offset + limit; end < len(databaseList) {
+		return databaseList[offset:end], nil
+	}
+
+	return databaseList[offset:], nil
+}
+
+// tenantImages returns all the images of the tenant of the route, or the images without a tenant outside of the tenant routes
+// Without tenants enabled, that's every image
+func (a *API) tenantImages(r *http.Request) ([]database.Image, *handler.Error) {
+	tenant, err := params.RouteTenant(r, a.TenantPattern)
+	if err != nil {
+		return nil, handler.BadRequest(err.Error())
+	}
+
+	databaseList, err := a.Database.ListAll()
+	if err != nil {
+		a.logError(r, "error getting image list from database", err)
+		return nil, handler.InternalServerError()
+	}
+
+	if a.TenantPattern == nil {
+		return databaseList, nil
+	}
+
+	images := []database.Image{}
+	for _, image := range databaseList {
+		if imageTenant, _ := params.SplitTenantImageID(image.ID); imageTenant == tenant {
+			images = append(images, image)
+		}
+	}
+
+	return images, nil
+}
+
+// getListImage returns the info of an image, where the images of tenants have the id within the tenant
 func (a *API) getListImage(image database.Image) ListImage {
+	_, imageID := params.SplitTenantImageID(image.ID)
+
 	return ListImage{
 		Image: database.Image{
-			ID:     image.ID,
+			ID:     imageID,
 			Author: image.Author,
 			Width:  image.Width,
 			Height: image.Height,
 			URL:    image.URL,
 		},
-		DownloadURL: fmt.Sprintf("%s/id/%s/%d/%d", a.RootURL, image.ID, image.Width, image.Height),
+		DownloadURL: fmt.Sprintf("%s%s/%d/%d", a.rootURL(), imagePath(image.ID), image.Width, image.Height),
 	}
 }
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// SrcSet contains a srcset attribute value
+type SrcSet struct {
+	SrcSet string `json:"srcset"`
+}
+
+// Returns a srcset attribute value for an image, in the given widths, or for the given dprs of a width
+func (a *API) srcSetHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	p, err := params.GetSrcSetParams(r)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	vars := mux.Vars(r)
+	imageID := vars["id"]
+	image, handlerErr := a.getImage(r, imageID)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	// Keep the aspect ratio of the image for each width
+	var candidates []string
+	if p.HasDensities() {
+		for _, dpr := range p.DPRs {
+			// The scaled widths are within the max image size, but the heights of portrait images may not be, those dprs are left out
+			width := int(math.Round(float64(p.Width) * dpr))
+			height, ok := params.SrcSetHeight(width, image)
+			if !ok {
+				continue
+			}
+
+			candidates = append(candidates, fmt.Sprintf("%s%s/%d/%d%s %sx", a.rootURL(), imagePath(image.ID), width, height, p.Extension, strconv.FormatFloat(dpr, 'f', -1, 64)))
+		}
+	} else {
+		for _, width := range p.Widths {
+			// Portrait images get taller than the max image size before they get wider than it, those widths are left out
+			height, ok := params.SrcSetHeight(width, image)
+			if !ok {
+				continue
+			}
+
+			candidates = append(candidates, fmt.Sprintf("%s%s/%d/%d%s %dw", a.rootURL(), imagePath(image.ID), width, height, p.Extension, width))
+		}
+	}
+
+	if len(candidates) == 0 {
+		return handler.BadRequest(params.ErrInvalidWidths.Error())
+	}
+
+	srcSet := strings.Join(candidates, ", ")
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Vary", "Accept")
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(SrcSet{SrcSet: srcSet}); err != nil {
+			a.logError(r, "error encoding srcset", err)
+			return handler.InternalServerError()
+		}
+
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, srcSet)
+
+	return nil
+}
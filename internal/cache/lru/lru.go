@@ -0,0 +1,102 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+)
+
+// Provider implements an in-memory cache that's bounded by the total size of the objects in it, and by how long it keeps them
+// The least recently used objects are evicted to make room for new ones, so that caching objects by the url can't grow without bounds
+type Provider struct {
+	maxBytes int64
+	ttl      time.Duration
+	mutex    sync.Mutex
+	size     int64
+	order    *list.List // The most recently used entries first
+	entries  map[string]*list.Element
+}
+
+// entry is an object in the cache, along with its key and when it expires
+type entry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// New returns a new Provider instance that holds up to maxBytes of keys and objects, and keeps each object for up to ttl (0 to keep them until they're evicted)
+func New(maxBytes int64, ttl time.Duration) *Provider {
+	return &Provider{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns an object from the cache if it exists and hasn't expired
+func (p *Provider) Get(key string) (data []byte, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	element, exists := p.entries[key]
+	if !exists {
+		return nil, cache.ErrNotFound
+	}
+
+	e := element.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		p.remove(element)
+		return nil, cache.ErrNotFound
+	}
+
+	p.order.MoveToFront(element)
+	return e.data, nil
+}
+
+// Set adds an object to the cache, evicting the least recently used objects until it fits
+// Objects larger than the whole cache aren't cached
+func (p *Provider) Set(key string, data []byte) (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if element, exists := p.entries[key]; exists {
+		p.remove(element)
+	}
+
+	size := entrySize(key, data)
+	if size > p.maxBytes {
+		return nil
+	}
+
+	e := &entry{key: key, data: data}
+	if p.ttl > 0 {
+		e.expires = time.Now().Add(p.ttl)
+	}
+
+	p.entries[key] = p.order.PushFront(e)
+	p.size += size
+
+	for p.size > p.maxBytes {
+		p.remove(p.order.Back())
+	}
+
+	return nil
+}
+
+// Shutdown shuts down the cache
+func (p *Provider) Shutdown() {}
+
+// remove removes the entry from the cache, with the mutex held
+func (p *Provider) remove(element *list.Element) {
+	e := p.order.Remove(element).(*entry)
+	delete(p.entries, e.key)
+	p.size -= entrySize(e.key, e.data)
+}
+
+// entrySize returns how much of the cache an object takes up
+func entrySize(key string, data []byte) int64 {
+	return int64(len(key) + len(data))
+}
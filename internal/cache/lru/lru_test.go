@@ -0,0 +1,94 @@
+package lru_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/cache/lru"
+)
+
+func TestLRU(t *testing.T) {
+	t.Run("get item", func(t *testing.T) {
+		provider := lru.New(100, 0)
+		provider.Set("foo", []byte("bar"))
+
+		data, err := provider.Get("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "bar" {
+			t.Fatal("wrong data")
+		}
+	})
+
+	t.Run("get nonexistant item", func(t *testing.T) {
+		provider := lru.New(100, 0)
+		if _, err := provider.Get("notfound"); err != cache.ErrNotFound {
+			t.Fatalf("wrong error %s", err)
+		}
+	})
+
+	t.Run("evicts the least recently used items", func(t *testing.T) {
+		// Each item takes up 4 bytes, so only two of them fit
+		provider := lru.New(8, 0)
+		provider.Set("a", []byte("aaa"))
+		provider.Set("b", []byte("bbb"))
+
+		// Getting a makes b the least recently used
+		provider.Get("a")
+		provider.Set("c", []byte("ccc"))
+
+		if _, err := provider.Get("b"); err != cache.ErrNotFound {
+			t.Error("least recently used item wasn't evicted")
+		}
+
+		for _, key := range []string{"a", "c"} {
+			if _, err := provider.Get(key); err != nil {
+				t.Errorf("item %s was evicted", key)
+			}
+		}
+	})
+
+	t.Run("replacing an item frees its size", func(t *testing.T) {
+		provider := lru.New(8, 0)
+		provider.Set("a", []byte("aaa"))
+		provider.Set("a", []byte("aaa"))
+		provider.Set("b", []byte("bbb"))
+
+		for _, key := range []string{"a", "b"} {
+			if _, err := provider.Get(key); err != nil {
+				t.Errorf("item %s was evicted", key)
+			}
+		}
+	})
+
+	t.Run("doesn't cache items larger than the cache", func(t *testing.T) {
+		provider := lru.New(8, 0)
+		provider.Set("a", []byte("aaa"))
+		provider.Set("b", []byte("bbbbbbbbbb"))
+
+		if _, err := provider.Get("b"); err != cache.ErrNotFound {
+			t.Error("item larger than the cache was cached")
+		}
+
+		if _, err := provider.Get("a"); err != nil {
+			t.Error("item was evicted for an item that doesn't fit")
+		}
+	})
+
+	t.Run("expires items", func(t *testing.T) {
+		provider := lru.New(100, 10*time.Millisecond)
+		provider.Set("foo", []byte("bar"))
+
+		if _, err := provider.Get("foo"); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if _, err := provider.Get("foo"); err != cache.ErrNotFound {
+			t.Error("item didn't expire")
+		}
+	})
+}
@@ -11,9 +11,12 @@ import (
 )
 
 // Http timeouts
+// The write timeout is longer than the handler timeout, so that handlers that time out still get to respond
 const (
 	ReadTimeout    = 5 * time.Second
+	HeaderTimeout  = 5 * time.Second
 	WriteTimeout   = time.Minute
+	IdleTimeout    = 2 * time.Minute
 	HandlerTimeout = 45 * time.Second
 )
 
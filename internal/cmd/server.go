@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Timeouts are the timeouts of the http server, which limit how long slow or idle clients can hold on to connections
+type Timeouts struct {
+	Read       time.Duration // Reading the whole request
+	ReadHeader time.Duration // Reading the request headers
+	Write      time.Duration // From the end of reading the request headers to the end of writing the response
+	Idle       time.Duration // Waiting for the next request on a keep-alive connection
+}
+
+// DefaultTimeouts are the default timeouts of the http server
+var DefaultTimeouts = Timeouts{
+	Read:       ReadTimeout,
+	ReadHeader: HeaderTimeout,
+	Write:      WriteTimeout,
+	Idle:       IdleTimeout,
+}
+
+// Validate returns an error if any of the timeouts is disabled, or if the write timeout doesn't leave time to respond after the handler timeout
+func (t Timeouts) Validate(handlerTimeout time.Duration) error {
+	if t.Read <= 0 || t.ReadHeader <= 0 || t.Write <= 0 || t.Idle <= 0 {
+		return fmt.Errorf("timeouts have to be positive")
+	}
+
+	if t.Write <= handlerTimeout {
+		return fmt.Errorf("write timeout %s has to be longer than the handler timeout %s", t.Write, handlerTimeout)
+	}
+
+	return nil
+}
+
+// NewServer returns a http server for the handler on the address, with the timeouts
+func NewServer(addr string, handler http.Handler, timeouts Timeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       timeouts.Read,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
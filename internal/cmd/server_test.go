@@ -0,0 +1,40 @@
+package cmd_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cmd"
+)
+
+func TestNewServer(t *testing.T) {
+	timeouts := cmd.Timeouts{Read: time.Second, ReadHeader: 2 * time.Second, Write: 3 * time.Second, Idle: 4 * time.Second}
+	server := cmd.NewServer(":8080", http.NotFoundHandler(), timeouts)
+
+	if server.Addr != ":8080" {
+		t.Errorf("wrong address %s", server.Addr)
+	}
+
+	if server.ReadTimeout != time.Second || server.ReadHeaderTimeout != 2*time.Second || server.WriteTimeout != 3*time.Second || server.IdleTimeout != 4*time.Second {
+		t.Errorf("wrong timeouts %s %s %s %s", server.ReadTimeout, server.ReadHeaderTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+}
+
+func TestValidateTimeouts(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Timeouts      cmd.Timeouts
+		ExpectedValid bool
+	}{
+		{"defaults", cmd.DefaultTimeouts, true},
+		{"disabled timeout", cmd.Timeouts{Read: time.Second, Write: time.Minute, Idle: time.Minute}, false},
+		{"write timeout within the handler timeout", cmd.Timeouts{Read: time.Second, ReadHeader: time.Second, Write: cmd.HandlerTimeout, Idle: time.Minute}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.Timeouts.Validate(cmd.HandlerTimeout); (err == nil) != test.ExpectedValid {
+			t.Errorf("%s: wrong validation %v", test.Name, err)
+		}
+	}
+}
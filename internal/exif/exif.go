@@ -0,0 +1,365 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrInvalidMetadata is returned when an image has EXIF metadata that can't be parsed
+var ErrInvalidMetadata = errors.New("invalid exif metadata")
+
+// Metadata is the EXIF metadata of an image, fields that aren't in the image are left out
+type Metadata struct {
+	Camera     *Camera     `json:"camera,omitempty"`
+	Exposure   *Exposure   `json:"exposure,omitempty"`
+	GPS        *GPS        `json:"gps,omitempty"`
+	Dimensions *Dimensions `json:"dimensions,omitempty"`
+}
+
+// Camera is the camera and lens that took the image
+type Camera struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	Lens  string `json:"lens,omitempty"`
+}
+
+// Exposure is the exposure settings of the image
+type Exposure struct {
+	Time        string  `json:"time,omitempty"`
+	FNumber     float64 `json:"fnumber,omitempty"`
+	ISO         int     `json:"iso,omitempty"`
+	FocalLength float64 `json:"focal_length,omitempty"`
+}
+
+// GPS is where the image was taken, in decimal degrees and meters above sea level
+type GPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// Dimensions is the size of the image as recorded by the camera
+type Dimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Tags
+const (
+	tagMake            = 0x010F
+	tagModel           = 0x0110
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagExposureTime    = 0x829A
+	tagFNumber         = 0x829D
+	tagISO             = 0x8827
+	tagFocalLength     = 0x920A
+	tagPixelXDimension = 0xA002
+	tagPixelYDimension = 0xA003
+	tagLensModel       = 0xA434
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// Types, and the size of a single value of each
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]int{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSRational: 8,
+}
+
+// Parse reads the EXIF metadata of a JPEG image
+// Images without EXIF metadata, including other formats, give empty metadata rather than an error
+func Parse(buffer []byte) (*Metadata, error) {
+	tiff, err := findExif(buffer)
+	if err != nil || tiff == nil {
+		return &Metadata{}, err
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown byte order", ErrInvalidMetadata)
+	}
+
+	if order.Uint16(tiff[2:4]) != 42 {
+		return nil, fmt.Errorf("%w: not a tiff header", ErrInvalidMetadata)
+	}
+
+	p := &parser{tiff: tiff, order: order}
+
+	ifd0, err := p.readIFD(order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	exifIFD, err := p.readSubIFD(ifd0, tagExifIFD)
+	if err != nil {
+		return nil, err
+	}
+
+	gpsIFD, err := p.readSubIFD(ifd0, tagGPSIFD)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &Metadata{}
+
+	camera := Camera{
+		Make:  p.string(ifd0[tagMake]),
+		Model: p.string(ifd0[tagModel]),
+		Lens:  p.string(exifIFD[tagLensModel]),
+	}
+	if camera != (Camera{}) {
+		metadata.Camera = &camera
+	}
+
+	exposure := Exposure{
+		FNumber:     p.float(exifIFD[tagFNumber]),
+		ISO:         p.int(exifIFD[tagISO]),
+		FocalLength: p.float(exifIFD[tagFocalLength]),
+	}
+	if numerator, denominator, ok := p.rational(exifIFD[tagExposureTime], 0); ok {
+		exposure.Time = formatExposureTime(numerator, denominator)
+	}
+	if exposure != (Exposure{}) {
+		metadata.Exposure = &exposure
+	}
+
+	latitude, latitudeOK := p.coordinate(gpsIFD[tagGPSLatitude], gpsIFD[tagGPSLatitudeRef], "S")
+	longitude, longitudeOK := p.coordinate(gpsIFD[tagGPSLongitude], gpsIFD[tagGPSLongitudeRef], "W")
+	if latitudeOK && longitudeOK {
+		metadata.GPS = &GPS{Latitude: latitude, Longitude: longitude}
+
+		if numerator, denominator, ok := p.rational(gpsIFD[tagGPSAltitude], 0); ok {
+			altitude := float64(numerator) / float64(denominator)
+			// An altitude ref of 1 means below sea level
+			if p.int(gpsIFD[tagGPSAltitudeRef]) == 1 {
+				altitude = -altitude
+			}
+			metadata.GPS.Altitude = &altitude
+		}
+	}
+
+	width, height := p.int(exifIFD[tagPixelXDimension]), p.int(exifIFD[tagPixelYDimension])
+	if width > 0 && height > 0 {
+		metadata.Dimensions = &Dimensions{Width: width, Height: height}
+	}
+
+	return metadata, nil
+}
+
+// HasExif returns whether a JPEG image has EXIF metadata, including metadata that can't be parsed
+func HasExif(buffer []byte) bool {
+	tiff, err := findExif(buffer)
+	return err != nil || tiff != nil
+}
+
+// findExif returns the TIFF structure in the APP1 segment of a JPEG, or nil if there isn't one
+func findExif(buffer []byte) ([]byte, error) {
+	if len(buffer) < 4 || buffer[0] != 0xFF || buffer[1] != 0xD8 {
+		return nil, nil
+	}
+
+	offset := 2
+	for offset+4 <= len(buffer) {
+		if buffer[offset] != 0xFF {
+			return nil, fmt.Errorf("%w: invalid jpeg marker", ErrInvalidMetadata)
+		}
+
+		marker := buffer[offset+1]
+		// The metadata segments are before the image data, so stop at the start of scan or end of image
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(buffer[offset+2 : offset+4]))
+		if length < 2 || offset+2+length > len(buffer) {
+			return nil, fmt.Errorf("%w: truncated jpeg segment", ErrInvalidMetadata)
+		}
+
+		segment := buffer[offset+4 : offset+2+length]
+		if marker == 0xE1 && strings.HasPrefix(string(segment), "Exif\x00\x00") {
+			if len(segment) < 14 {
+				return nil, fmt.Errorf("%w: truncated tiff header", ErrInvalidMetadata)
+			}
+
+			return segment[6:], nil
+		}
+
+		offset += 2 + length
+	}
+
+	return nil, nil
+}
+
+// entry is a single tag in an IFD
+type entry struct {
+	Type  uint16
+	Count uint32
+	Value []byte
+}
+
+// parser reads values from the TIFF structure of the EXIF metadata
+type parser struct {
+	tiff  []byte
+	order binary.ByteOrder
+}
+
+// readIFD reads the entries of the IFD at the offset, values that don't fit in the entry are read from their offset
+func (p *parser) readIFD(offset uint32) (map[uint16]entry, error) {
+	if uint64(offset)+2 > uint64(len(p.tiff)) {
+		return nil, fmt.Errorf("%w: ifd out of range", ErrInvalidMetadata)
+	}
+
+	count := int(p.order.Uint16(p.tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(p.tiff) {
+		return nil, fmt.Errorf("%w: truncated ifd", ErrInvalidMetadata)
+	}
+
+	entries := make(map[uint16]entry, count)
+	for i := 0; i < count; i++ {
+		raw := p.tiff[start+i*12 : start+(i+1)*12]
+		tag := p.order.Uint16(raw[0:2])
+		valueType := p.order.Uint16(raw[2:4])
+		valueCount := p.order.Uint32(raw[4:8])
+
+		// Skip types that none of the read tags use
+		size, ok := typeSizes[valueType]
+		if !ok {
+			continue
+		}
+
+		length := uint64(size) * uint64(valueCount)
+		value := raw[8:12]
+		if length > 4 {
+			valueOffset := uint64(p.order.Uint32(raw[8:12]))
+			if valueOffset+length > uint64(len(p.tiff)) {
+				return nil, fmt.Errorf("%w: value out of range", ErrInvalidMetadata)
+			}
+			value = p.tiff[valueOffset : valueOffset+length]
+		}
+
+		entries[tag] = entry{Type: valueType, Count: valueCount, Value: value[:length]}
+	}
+
+	return entries, nil
+}
+
+// readSubIFD reads the IFD that the tag points to, a missing tag gives an empty IFD
+func (p *parser) readSubIFD(ifd map[uint16]entry, tag uint16) (map[uint16]entry, error) {
+	e, ok := ifd[tag]
+	if !ok || (e.Type != typeLong && e.Type != typeUndefined) || len(e.Value) < 4 {
+		return map[uint16]entry{}, nil
+	}
+
+	return p.readIFD(p.order.Uint32(e.Value))
+}
+
+func (p *parser) string(e entry) string {
+	if e.Type != typeASCII && e.Type != typeUndefined {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(e.Value), "\x00"))
+}
+
+func (p *parser) int(e entry) int {
+	if e.Count < 1 {
+		return 0
+	}
+
+	switch e.Type {
+	case typeByte:
+		return int(e.Value[0])
+	case typeShort:
+		return int(p.order.Uint16(e.Value))
+	case typeLong:
+		return int(p.order.Uint32(e.Value))
+	default:
+		return 0
+	}
+}
+
+// rational returns the numerator and denominator of the i-th value, with ok false if it's missing or divides by zero
+func (p *parser) rational(e entry, i int) (numerator int64, denominator int64, ok bool) {
+	if (e.Type != typeRational && e.Type != typeSRational) || uint32(i) >= e.Count {
+		return 0, 0, false
+	}
+
+	value := e.Value[i*8:]
+	if e.Type == typeSRational {
+		numerator, denominator = int64(int32(p.order.Uint32(value[0:4]))), int64(int32(p.order.Uint32(value[4:8])))
+	} else {
+		numerator, denominator = int64(p.order.Uint32(value[0:4])), int64(p.order.Uint32(value[4:8]))
+	}
+
+	return numerator, denominator, denominator != 0
+}
+
+func (p *parser) float(e entry) float64 {
+	numerator, denominator, ok := p.rational(e, 0)
+	if !ok {
+		return 0
+	}
+
+	return float64(numerator) / float64(denominator)
+}
+
+// coordinate converts degrees, minutes, and seconds to decimal degrees, negative in the direction of the negative ref
+func (p *parser) coordinate(e entry, ref entry, negativeRef string) (float64, bool) {
+	var degrees float64
+	for i, unit := range []float64{1, 60, 3600} {
+		numerator, denominator, ok := p.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		degrees += float64(numerator) / float64(denominator) / unit
+	}
+
+	if p.string(ref) == negativeRef {
+		degrees = -degrees
+	}
+
+	// Round to the precision of the seconds, to avoid floating point noise in the output
+	return math.Round(degrees*1e6) / 1e6, true
+}
+
+// formatExposureTime formats the exposure time as a fraction of a second, such as 1/250, or in seconds when it's longer
+func formatExposureTime(numerator, denominator int64) string {
+	if numerator <= 0 {
+		return ""
+	}
+
+	if numerator >= denominator {
+		return fmt.Sprintf("%g", float64(numerator)/float64(denominator))
+	}
+
+	return fmt.Sprintf("1/%d", int64(math.Round(float64(denominator)/float64(numerator))))
+}
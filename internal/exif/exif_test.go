@@ -0,0 +1,101 @@
+package exif_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/exif"
+)
+
+func TestParse(t *testing.T) {
+	// exif.jpg has big endian EXIF metadata with camera, exposure, GPS, and dimension tags
+	buffer, err := ioutil.ReadFile("../../test/fixtures/file/exif.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := exif.Parse(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	altitude := 28.5
+	expected := &exif.Metadata{
+		Camera:     &exif.Camera{Make: "Picsum", Model: "Test Camera", Lens: "35mm f/2.8"},
+		Exposure:   &exif.Exposure{Time: "1/250", FNumber: 2.8, ISO: 200, FocalLength: 35},
+		GPS:        &exif.GPS{Latitude: 59.329333, Longitude: 18.068667, Altitude: &altitude},
+		Dimensions: &exif.Dimensions{Width: 64, Height: 48},
+	}
+
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("wrong metadata %#v", metadata)
+	}
+
+	t.Run("images without metadata give empty metadata", func(t *testing.T) {
+		for _, name := range []string{"1.jpg", "quadrants.jpg", "corrupt.jpg"} {
+			buffer, err := ioutil.ReadFile("../../test/fixtures/file/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metadata, err := exif.Parse(buffer)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(metadata, &exif.Metadata{}) {
+				t.Errorf("%s: wrong metadata %#v", name, metadata)
+			}
+		}
+	})
+
+	t.Run("reports whether images have exif metadata", func(t *testing.T) {
+		tests := map[string]bool{
+			"exif.jpg":      true,
+			"plain.jpg":     false,
+			"quadrants.jpg": false,
+		}
+
+		for name, expected := range tests {
+			buffer, err := ioutil.ReadFile("../../test/fixtures/file/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hasExif := exif.HasExif(buffer); hasExif != expected {
+				t.Errorf("%s: wrong result %t", name, hasExif)
+			}
+		}
+
+		// Metadata that can't be parsed is still metadata
+		if !exif.HasExif(buffer[:40]) {
+			t.Error("truncated metadata wasn't found")
+		}
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		corrupt := func(offset int, value ...byte) []byte {
+			corrupted := append([]byte{}, buffer...)
+			copy(corrupted[offset:], value)
+			return corrupted
+		}
+
+		// The APP1 segment length is at 4, and the TIFF header starts at 12
+		tests := map[string][]byte{
+			"truncated segment":  corrupt(4, 0xFF, 0xFF),
+			"unknown byte order": corrupt(12, 'X', 'X'),
+			"not a tiff header":  corrupt(14, 0, 0),
+			"ifd out of range":   corrupt(16, 0xFF, 0xFF, 0xFF, 0xFF),
+			"truncated buffer":   buffer[:40],
+		}
+
+		for name, corrupted := range tests {
+			if _, err := exif.Parse(corrupted); !errors.Is(err, exif.ErrInvalidMetadata) {
+				t.Errorf("%s: wrong error %#v", name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseBasePath parses the path that the routes are mounted under, such as /images when running behind a reverse proxy
+// The path is returned without a trailing slash, and the root is returned as an empty path
+func ParseBasePath(value string) (string, error) {
+	if value == "" || value == "/" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(value, "/") {
+		return "", fmt.Errorf("invalid base path %q, must start with /", value)
+	}
+
+	return strings.TrimRight(value, "/"), nil
+}
@@ -0,0 +1,34 @@
+package handler_test
+
+import (
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestParseBasePath(t *testing.T) {
+	tests := []struct {
+		Value         string
+		Expected      string
+		ExpectedError bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"/images", "/images", false},
+		{"/images/", "/images", false},
+		{"/v1/images", "/v1/images", false},
+		{"images", "", true},
+	}
+
+	for _, test := range tests {
+		basePath, err := handler.ParseBasePath(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+			continue
+		}
+
+		if basePath != test.Expected {
+			t.Errorf("%q: wrong base path %q", test.Value, basePath)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// CacheTTLs maps url path patterns, as matched by path.Match, to how long responses to them can be cached
+// A ttl of 0 disables caching
+type CacheTTLs map[string]time.Duration
+
+// ParseCacheTTLs parses a semicolon separated list of cache ttls, in the form pattern=duration
+// For example "/id/*/*/*=8760h;/v2/list=1m;/health=0"
+func ParseCacheTTLs(value string) (CacheTTLs, error) {
+	ttls := make(CacheTTLs)
+	if value == "" {
+		return ttls, nil
+	}
+
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid cache ttl %q", entry)
+		}
+
+		pattern := parts[0]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern for cache ttl %s", pattern)
+		}
+
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid duration for cache ttl %s", pattern)
+		}
+
+		ttls[pattern] = ttl
+	}
+
+	return ttls, nil
+}
+
+// TTL returns the ttl for a url path, and whether one is configured for it
+// When several patterns match, the longest one is used, so that more specific patterns take precedence
+func (c CacheTTLs) TTL(urlPath string) (ttl time.Duration, ok bool) {
+	best := ""
+	for pattern, patternTTL := range c {
+		if matched, _ := path.Match(pattern, urlPath); !matched {
+			continue
+		}
+
+		// Break ties by sorting the patterns, so that the result doesn't depend on the map iteration order
+		if !ok || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+			ttl = patternTTL
+			ok = true
+		}
+	}
+
+	return ttl, ok
+}
+
+// CacheControl is a handler that sets the Cache-Control header of successful responses to the ttl configured for the path
+// It replaces the header set by the wrapped handler, errors and paths without a ttl are left as is
+func CacheControl(ttls CacheTTLs, next http.Handler) http.Handler {
+	if len(ttls) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl, ok := ttls.TTL(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&cacheControlResponseWriter{ResponseWriter: w, ttl: ttl}, r)
+	})
+}
+
+type cacheControlResponseWriter struct {
+	http.ResponseWriter
+	ttl         time.Duration
+	wroteHeader bool
+}
+
+func (c *cacheControlResponseWriter) WriteHeader(code int) {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+
+		if code < http.StatusBadRequest {
+			c.Header().Set("Cache-Control", cacheControlValue(c.ttl))
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheControlResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush sends what's been written so far to the client, for responses that are streamed
+func (c *cacheControlResponseWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// cacheControlValue returns the Cache-Control header value for a ttl
+func cacheControlValue(ttl time.Duration) string {
+	if ttl == 0 {
+		return "no-cache, no-store, must-revalidate"
+	}
+
+	return fmt.Sprintf("public, max-age=%d", int64(ttl/time.Second))
+}
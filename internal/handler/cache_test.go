@@ -0,0 +1,105 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestParseCacheTTLs(t *testing.T) {
+	ttls, err := handler.ParseCacheTTLs("/id/*/*/*=8760h; /v2/list=1m;/health=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := handler.CacheTTLs{"/id/*/*/*": 8760 * time.Hour, "/v2/list": time.Minute, "/health": 0}
+	if len(ttls) != len(expected) {
+		t.Fatalf("wrong ttls %#v", ttls)
+	}
+
+	for pattern, ttl := range expected {
+		if ttls[pattern] != ttl {
+			t.Errorf("wrong ttl for %s: %s", pattern, ttls[pattern])
+		}
+	}
+
+	for _, value := range []string{"/id/*", "=1h", "/id/*=forever", "/id/*=-1h", "/id/[=1h"} {
+		if _, err := handler.ParseCacheTTLs(value); err == nil {
+			t.Errorf("no error for %q", value)
+		}
+	}
+}
+
+func TestCacheTTLs(t *testing.T) {
+	ttls := handler.CacheTTLs{
+		"/id/*/*/*":    8760 * time.Hour,
+		"/id/*/*":      24 * time.Hour,
+		"/id/*/info":   time.Hour,
+		"/*/*":         30 * time.Second,
+		"/v2/list":     time.Minute,
+		"/seed/*/*/*":  0,
+		"/capabilit*s": time.Hour,
+	}
+
+	tests := []struct {
+		Path       string
+		ExpectedOK bool
+		Expected   time.Duration
+	}{
+		{"/id/1/200/300.jpg", true, 8760 * time.Hour},
+		{"/id/1/200", true, 24 * time.Hour},
+		{"/id/1/info", true, time.Hour},
+		{"/200/300", true, 30 * time.Second},
+		{"/v2/list", true, time.Minute},
+		{"/seed/picsum/200/300", true, 0},
+		{"/capabilities", true, time.Hour},
+		{"/health", false, 0},
+		{"/id/1/200/300/400", false, 0},
+	}
+
+	for _, test := range tests {
+		ttl, ok := ttls.TTL(test.Path)
+		if ok != test.ExpectedOK || ttl != test.Expected {
+			t.Errorf("%s: wrong ttl %s %t", test.Path, ttl, ok)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	ttls := handler.CacheTTLs{
+		"/id/*/*/*": 8760 * time.Hour,
+		"/*/*":      0,
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		if r.URL.Query().Get("status") == "error" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		Name     string
+		Path     string
+		Expected string
+	}{
+		{"immutable route", "/id/1/200/300", "public, max-age=31536000"},
+		{"dynamic route", "/200/300", "no-cache, no-store, must-revalidate"},
+		{"route without a ttl", "/health", "public, max-age=60"},
+		{"errors are left as is", "/id/1/200/300?status=error", "public, max-age=60"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.Path, nil)
+		handler.CacheControl(ttls, next).ServeHTTP(w, req)
+
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != test.Expected {
+			t.Errorf("%s: wrong Cache-Control header %s", test.Name, cacheControl)
+		}
+	}
+}
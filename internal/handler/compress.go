@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compress is a handler that gzips text and JSON responses for clients that accept it
+// Images are already compressed, so they're passed through as is
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := &compressResponseWriter{ResponseWriter: w, acceptsGzip: r.Method != "HEAD" && acceptsGzip(r)}
+		defer writer.close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	acceptsGzip bool
+	gzip        *gzip.Writer
+	wroteHeader bool
+}
+
+func (c *compressResponseWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	// Responses that can be compressed vary by whether the client accepts it, whether or not this one does
+	header := c.Header()
+	if compressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+
+		if c.acceptsGzip && code != http.StatusNoContent && code != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+			c.gzip = gzip.NewWriter(c.ResponseWriter)
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		// The content type decides whether to compress, so sniff it like the http server would
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.gzip != nil {
+		return c.gzip.Write(b)
+	}
+
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush sends what's been written so far to the client, for responses that are streamed
+func (c *compressResponseWriter) Flush() {
+	if c.gzip != nil {
+		c.gzip.Flush()
+	}
+
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close flushes the rest of the compressed response
+func (c *compressResponseWriter) close() {
+	if c.gzip != nil {
+		c.gzip.Close()
+	}
+}
+
+// compressible returns whether a response of the content type benefits from being compressed
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json"
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of the request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, encoding := range strings.Split(header, ",") {
+			parts := strings.Split(encoding, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+
+			// An encoding with a quality of 0 is explicitly not accepted
+			if len(parts) > 1 {
+				if q := strings.TrimSpace(parts[1]); strings.HasPrefix(q, "q=") {
+					if value, err := strconv.ParseFloat(q[2:], 64); err == nil && value == 0 {
+						return false
+					}
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
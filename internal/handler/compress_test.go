@@ -0,0 +1,107 @@
+package handler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestCompress(t *testing.T) {
+	json := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1"}`))
+	})
+
+	text := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Image does not exist", http.StatusNotFound)
+	})
+
+	image := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("\xff\xd8\xff"))
+	})
+
+	tests := []struct {
+		Name             string
+		Handler          http.Handler
+		AcceptEncoding   string
+		ExpectedEncoding string
+		ExpectedVary     string
+		ExpectedBody     string
+	}{
+		{"json", json, "gzip, deflate, br", "gzip", "Accept-Encoding", `{"id":"1"}`},
+		{"text", text, "gzip", "gzip", "Accept-Encoding", "Image does not exist\n"},
+		{"json without accept encoding", json, "", "", "Accept-Encoding", `{"id":"1"}`},
+		{"json with gzip not accepted", json, "gzip;q=0, deflate", "", "Accept-Encoding", `{"id":"1"}`},
+		{"json with any encoding", json, "*", "gzip", "Accept-Encoding", `{"id":"1"}`},
+		{"image", image, "gzip", "", "", "\xff\xd8\xff"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/id/1/info", nil)
+		if test.AcceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.AcceptEncoding)
+		}
+		handler.Compress(test.Handler).ServeHTTP(w, req)
+
+		if encoding := w.Header().Get("Content-Encoding"); encoding != test.ExpectedEncoding {
+			t.Errorf("%s: wrong content encoding %s", test.Name, encoding)
+			continue
+		}
+
+		if vary := w.Header().Get("Vary"); vary != test.ExpectedVary {
+			t.Errorf("%s: wrong vary %s", test.Name, vary)
+		}
+
+		body := w.Body.Bytes()
+		if test.ExpectedEncoding == "gzip" {
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Errorf("%s: invalid gzip %s", test.Name, err)
+				continue
+			}
+
+			body, _ = ioutil.ReadAll(reader)
+		}
+
+		if string(body) != test.ExpectedBody {
+			t.Errorf("%s: wrong body %s", test.Name, body)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	// A streamed response is flushed through the gzip writer, so that the client can decompress what's been written so far
+	stream := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Write([]byte("event: progress\n\n"))
+		rw.(http.Flusher).Flush()
+
+		if !w.Flushed {
+			t.Fatal("response wasn't flushed")
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := make([]byte, len("event: progress\n\n"))
+		if _, err := io.ReadFull(reader, body); err != nil || string(body) != "event: progress\n\n" {
+			t.Errorf("wrong flushed body %q, %v", body, err)
+		}
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.Compress(stream).ServeHTTP(w, req)
+}
@@ -5,18 +5,17 @@ import (
 	"strings"
 )
 
+// AllowedOrigin is the origin that's allowed to make cross-origin requests
+const AllowedOrigin = "*"
+
 // CORS is a handler for setting CORS headers
+// OPTIONS requests without Access-Control-Request-Method aren't preflight requests, so they're passed on for the routes to describe themselves
 // Based on https://github.com/gorilla/handlers/blob/master/cors.go
 func CORS(exposedHeaders []string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		if r.Method == "OPTIONS" {
-			if _, ok := r.Header["Access-Control-Request-Method"]; !ok {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
+		w.Header().Set("Access-Control-Allow-Origin", AllowedOrigin)
 
+		if _, preflight := r.Header["Access-Control-Request-Method"]; r.Method == "OPTIONS" && preflight {
 			method := r.Header.Get("Access-Control-Request-Method")
 			if method != "GET" {
 				w.WriteHeader(http.StatusMethodNotAllowed)
@@ -38,3 +37,12 @@ func CORS(exposedHeaders []string, next http.Handler) http.Handler {
 		}
 	})
 }
+
+// TimingAllowOrigin is a handler for setting the Timing-Allow-Origin header
+// This lets the CORS allowed origin read the detailed resource timing of cross-origin responses
+func TimingAllowOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Timing-Allow-Origin", AllowedOrigin)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -29,12 +29,16 @@ func TestCORS(t *testing.T) {
 			},
 		},
 		{
-			Name:           "bad request with missing request method header",
+			Name:           "passes on option requests without a request method header",
 			Method:         "OPTIONS",
-			ExpectedStatus: http.StatusBadRequest,
+			ExpectedStatus: http.StatusOK,
 			Headers: map[string]string{
 				"Origin": "http://www.example.com/",
 			},
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "*",
+				"Access-Control-Expose-Headers": "Link, Picsum-ID",
+			},
 		},
 		{
 			Name:           "bad request with wrong request method header",
@@ -100,3 +104,23 @@ func TestCORS(t *testing.T) {
 		}
 	}
 }
+
+func TestTimingAllowOrigin(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://www.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler.TimingAllowOrigin(testHandler).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("wrong response code, %#v", rr.Code)
+	}
+
+	if headerValue := rr.Header().Get("Timing-Allow-Origin"); headerValue != "*" {
+		t.Errorf("wrong header value for Timing-Allow-Origin, %#v", headerValue)
+	}
+}
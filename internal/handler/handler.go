@@ -27,6 +27,14 @@ func BadRequest(message string) *Error {
 	}
 }
 
+// UnprocessableEntity is a convenience function for returning an unprocessable entity error
+func UnprocessableEntity(message string) *Error {
+	return &Error{
+		Message: message,
+		Code:    http.StatusUnprocessableEntity,
+	}
+}
+
 const jsonMediaType = "application/json"
 
 // Handler wraps a http handler and deals with responding to errors
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// URLLimits are the limits on the size of request URLs, 0 disables a limit
+type URLLimits struct {
+	MaxLength int
+	MaxParams int
+}
+
+// DefaultURLLimits are generous enough for any combination of the supported params
+var DefaultURLLimits = URLLimits{
+	MaxLength: 8192,
+	MaxParams: 100,
+}
+
+// LimitURL is a handler that rejects requests with URLs over the limits, before the query is parsed
+func LimitURL(limits URLLimits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI := r.RequestURI
+		if requestURI == "" {
+			requestURI = r.URL.RequestURI()
+		}
+
+		if limits.MaxLength > 0 && len(requestURI) > limits.MaxLength {
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			http.Error(w, "URL too long", http.StatusRequestURITooLong)
+			return
+		}
+
+		// Count the separators rather than parsing the query, as parsing is what the limit protects
+		if limits.MaxParams > 0 && r.URL.RawQuery != "" && strings.Count(r.URL.RawQuery, "&")+1 > limits.MaxParams {
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			http.Error(w, "Too many query params", http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
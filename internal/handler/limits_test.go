@@ -0,0 +1,42 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestLimitURL(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	limits := handler.URLLimits{MaxLength: 100, MaxParams: 5}
+
+	tests := []struct {
+		Name           string
+		Limits         handler.URLLimits
+		URL            string
+		ExpectedStatus int
+	}{
+		{"normal url", limits, "/id/1/200/300?grayscale&blur=2", http.StatusOK},
+		{"params at the limit", limits, "/200/300?a&b&c&d&e", http.StatusOK},
+		{"too many params", limits, "/200/300?a&b&c&d&e&f", http.StatusBadRequest},
+		{"too long", limits, "/200/300?text=" + strings.Repeat("a", 100), http.StatusRequestURITooLong},
+		{"limits disabled", handler.URLLimits{}, "/200/300?" + strings.Repeat("a&", 1000), http.StatusOK},
+		{"default limits", handler.DefaultURLLimits, "/200/300?" + strings.Repeat("grayscale&", 1000), http.StatusRequestURITooLong},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		handler.LimitURL(test.Limits, next).ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
@@ -8,7 +8,8 @@ import (
 )
 
 // Logger is a handler that logs requests using Zap
-func Logger(log *logger.Logger, h http.Handler) http.Handler {
+// Completed requests are logged at the debug level, and requests that fail with a server error, or take longer than slowRequests, at the info level (0 to disable)
+func Logger(log *logger.Logger, slowRequests time.Duration, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		id := GetReqID(ctx)
@@ -27,7 +28,19 @@ func Logger(log *logger.Logger, h http.Handler) http.Handler {
 		start := time.Now()
 		h.ServeHTTP(responseWriter, r)
 
-		log.Debugw("request completed", append(fields, "status-code", responseWriter.statusCode, "elapsed-ms", float64(time.Since(start).Nanoseconds())/1000000.0)...)
+		elapsed := time.Since(start)
+		fields = append(fields, "status-code", responseWriter.statusCode, "elapsed-ms", float64(elapsed.Nanoseconds())/1000000.0)
+		log.Debugw("request completed", fields...)
+
+		if slowRequests <= 0 {
+			return
+		}
+
+		if responseWriter.statusCode >= http.StatusInternalServerError {
+			log.Infow("request failed", fields...)
+		} else if elapsed >= slowRequests {
+			log.Infow("slow request", fields...)
+		}
 	})
 }
 
@@ -41,6 +54,13 @@ func (l *loggingResponseWriter) WriteHeader(code int) {
 	l.ResponseWriter.WriteHeader(code)
 }
 
+// Flush sends what's been written so far to the client, for responses that are streamed
+func (l *loggingResponseWriter) Flush() {
+	if flusher, ok := l.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // LogFields logs the given keys and values for a request
 func LogFields(r *http.Request, keysAndValues ...interface{}) []interface{} {
 	ctx := r.Context()
@@ -0,0 +1,73 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+	})
+
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Image does not exist", http.StatusNotFound)
+	})
+
+	tests := []struct {
+		Name            string
+		Handler         http.Handler
+		SlowRequests    time.Duration
+		ExpectedMessage string
+	}{
+		{"fast request", fast, 10 * time.Millisecond, ""},
+		{"slow request", slow, 10 * time.Millisecond, "slow request"},
+		{"failed request", failing, 10 * time.Millisecond, "request failed"},
+		{"client error", notFound, 10 * time.Millisecond, ""},
+		{"disabled", slow, 0, ""},
+	}
+
+	for _, test := range tests {
+		core, logs := observer.New(zapcore.InfoLevel)
+		log := &logger.Logger{SugaredLogger: zap.New(core).Sugar()}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/id/1/200/300?blur=2", nil)
+		handler.Logger(log, test.SlowRequests, test.Handler).ServeHTTP(w, req)
+
+		entries := logs.All()
+		if test.ExpectedMessage == "" {
+			if len(entries) != 0 {
+				t.Errorf("%s: logged %#v", test.Name, entries)
+			}
+			continue
+		}
+
+		if len(entries) != 1 || entries[0].Message != test.ExpectedMessage {
+			t.Errorf("%s: wrong log entries %#v", test.Name, entries)
+			continue
+		}
+
+		fields := entries[0].ContextMap()
+		if fields["uri"] != "/id/1/200/300?blur=2" || fields["status-code"] == nil || fields["elapsed-ms"] == nil {
+			t.Errorf("%s: wrong log fields %#v", test.Name, fields)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// metricsHidden are the expvar vars that are left out of the metrics
+// The command line includes the flags, which contain secrets such as the signing key and the storage credentials
+var metricsHidden = map[string]bool{
+	"cmdline": true,
+}
+
+// Metrics is a handler that serves the expvar vars as JSON, like expvar.Handler, without the command line
+func Metrics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		fmt.Fprintf(w, "{\n")
+		first := true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if metricsHidden[kv.Key] {
+				return
+			}
+
+			if !first {
+				fmt.Fprintf(w, ",\n")
+			}
+
+			first = false
+			fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+		})
+		fmt.Fprintf(w, "\n}\n")
+	})
+}
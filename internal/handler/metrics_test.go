@@ -0,0 +1,37 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestMetrics(t *testing.T) {
+	expvar.NewInt("metrics_test").Set(42)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/vars", nil)
+	handler.Metrics().ServeHTTP(w, req)
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("invalid json %s", w.Body.String())
+	}
+
+	if string(vars["metrics_test"]) != "42" {
+		t.Errorf("wrong value %s", vars["metrics_test"])
+	}
+
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("missing memstats")
+	}
+
+	// The command line includes the flags, with the secrets in them
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("cmdline is published")
+	}
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Options is a handler for describing a route to OPTIONS requests
+// The methods of the route are returned in the Allow header, along with OPTIONS, and the description as the JSON body
+func Options(methods []string, description interface{}) Handler {
+	allow := strings.Join(append(append([]string{}, methods...), "OPTIONS"), ", ")
+
+	return Handler(func(w http.ResponseWriter, r *http.Request) *Error {
+		w.Header().Set("Allow", allow)
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(description); err != nil {
+			return InternalServerError()
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestOptions(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("OPTIONS", "/id/1/200/300", nil)
+
+	handler.Options([]string{"GET", "HEAD"}, map[string]string{"name": "blur"}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong response code, %#v", rr.Code)
+	}
+
+	if allow := rr.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("wrong allow header %s", allow)
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("wrong content type %s", contentType)
+	}
+
+	if body := rr.Body.String(); body != "{\"name\":\"blur\"}\n" {
+		t.Errorf("wrong body %s", body)
+	}
+}
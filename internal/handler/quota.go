@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/quota"
+)
+
+// Quota is a handler that rejects clients that have made more requests than the quota allows within its window with a 429
+// Clients are identified by their ip, and the ones in the exempt networks aren't counted, a nil quota disables it
+// Requests from the trusted proxies are counted against the client that the proxies forwarded them for instead
+// The remaining requests are returned in the X-RateLimit headers, and failing to count a request lets it through
+func Quota(log *logger.Logger, q *quota.Quota, next http.Handler) http.Handler {
+	if q == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, q)
+		if q.Exempted(net.ParseIP(ip)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		remaining, reset, ok, err := q.Take(ip, now)
+		if err != nil {
+			log.Errorw("error counting request against the quota", LogFields(r, "error", err)...)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(q.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			http.Error(w, "Quota exceeded, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the ip of the client that made the request, without the port
+// When the request came from a trusted proxy, it's the ip that the proxies forwarded it for, from X-Forwarded-For or else Forwarded
+// The forwarded ips are walked from the closest proxy, as only the ones added by the trusted proxies can be trusted
+func clientIP(r *http.Request, q *quota.Quota) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !q.TrustedProxy(net.ParseIP(ip)) {
+		return ip
+	}
+
+	forwarded := forwardedFor(r)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		// The client is unknown past an address that isn't an ip, so the request is counted against the last proxy
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+
+		ip = forwarded[i]
+		if !q.TrustedProxy(net.ParseIP(ip)) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedFor returns the ips that the request was forwarded for, from the client to the closest proxy
+// They're taken from X-Forwarded-For when present, or else from the for parameters of Forwarded
+func forwardedFor(r *http.Request) []string {
+	var forwarded []string
+	if values := r.Header["X-Forwarded-For"]; len(values) > 0 {
+		for _, value := range strings.Split(strings.Join(values, ","), ",") {
+			forwarded = append(forwarded, strings.TrimSpace(value))
+		}
+
+		return forwarded
+	}
+
+	for _, element := range strings.Split(strings.Join(r.Header["Forwarded"], ","), ",") {
+		for _, pair := range strings.Split(element, ";") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) != 2 || !strings.EqualFold(parts[0], "for") {
+				continue
+			}
+
+			forwarded = append(forwarded, forwardedNode(parts[1]))
+		}
+	}
+
+	return forwarded
+}
+
+// forwardedNode returns the ip of a node of Forwarded, without the quotes, the brackets of ipv6 and the port
+func forwardedNode(node string) string {
+	node = strings.Trim(node, "\"")
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+}
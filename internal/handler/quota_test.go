@@ -0,0 +1,146 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/quota"
+	"github.com/DMarby/picsum-photos/internal/quota/memory"
+	"go.uber.org/zap"
+)
+
+func TestQuota(t *testing.T) {
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	exempt, _ := quota.ParseNetworks("10.0.0.0/8")
+	q := &quota.Quota{Limit: 2, Window: 100 * time.Millisecond, Rolling: true, Exempt: exempt, Provider: memory.New()}
+	quotaHandler := handler.Quota(log, q, ok)
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/id/1/200/300", nil)
+		req.RemoteAddr = remoteAddr
+		quotaHandler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, expectedRemaining := range []string{"1", "0"} {
+		w := request("192.0.2.1:1234")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: wrong response code, %#v", i, w.Code)
+		}
+
+		if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != expectedRemaining {
+			t.Errorf("request %d: wrong remaining %s", i, remaining)
+		}
+
+		if limit := w.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Errorf("request %d: wrong limit %s", i, limit)
+		}
+
+		if _, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64); err != nil {
+			t.Errorf("request %d: wrong reset %s", i, w.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	// The port of the client doesn't matter
+	w := request("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("wrong response code over the quota, %#v", w.Code)
+	}
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("wrong retry after %s", retryAfter)
+	}
+
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("wrong remaining over the quota %s", remaining)
+	}
+
+	t.Run("exempts trusted clients", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			w := request("10.0.0.1:1234")
+			if w.Code != http.StatusOK {
+				t.Fatalf("wrong response code, %#v", w.Code)
+			}
+
+			if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "" {
+				t.Errorf("counted an exempt client, %s remaining", remaining)
+			}
+		}
+	})
+
+	t.Run("resets after the window", func(t *testing.T) {
+		time.Sleep(150 * time.Millisecond)
+
+		w := request("192.0.2.1:1234")
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code after the window, %#v", w.Code)
+		}
+
+		if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "1" {
+			t.Errorf("wrong remaining after the window %s", remaining)
+		}
+	})
+
+	t.Run("trusted proxies", func(t *testing.T) {
+		proxies, _ := quota.ParseNetworks("192.0.2.10,198.51.100.0/24")
+		proxied := handler.Quota(log, &quota.Quota{Limit: 1, Window: time.Minute, TrustedProxies: proxies, Provider: memory.New()}, ok)
+
+		// Each request is counted against the expected client, so once it's over the quota the client doesn't get through again
+		tests := []struct {
+			Name       string
+			RemoteAddr string
+			Header     string
+			Value      string
+			Client     string
+		}{
+			{"forwarded-for from a trusted proxy", "192.0.2.10:1234", "X-Forwarded-For", "203.0.113.1", "203.0.113.1:1"},
+			{"forwarded-for through several trusted proxies", "192.0.2.10:1234", "X-Forwarded-For", "203.0.113.2, 198.51.100.7", "203.0.113.2:1"},
+			{"forwarded-for spoofed by the client", "192.0.2.10:1234", "X-Forwarded-For", "203.0.113.3, 203.0.113.4", "203.0.113.4:1"},
+			{"forwarded from a trusted proxy", "192.0.2.10:1234", "Forwarded", `for="[2001:db8::1]:4711";proto=https`, "[2001:db8::1]:1"},
+			{"forwarded through several trusted proxies", "192.0.2.10:1234", "Forwarded", "for=203.0.113.5, for=198.51.100.7:80", "203.0.113.5:1"},
+			{"obfuscated client", "198.51.100.8:1234", "Forwarded", "for=_hidden", "198.51.100.8:1"},
+			{"forwarded-for from an untrusted client", "203.0.113.6:1234", "X-Forwarded-For", "203.0.113.7", "203.0.113.6:1"},
+			{"forwarded from an untrusted client", "203.0.113.8:1234", "Forwarded", "for=203.0.113.9", "203.0.113.8:1"},
+		}
+
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/id/1/200/300", nil)
+			req.RemoteAddr = test.RemoteAddr
+			req.Header.Set(test.Header, test.Value)
+			proxied.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+				continue
+			}
+
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "/id/1/200/300", nil)
+			req.RemoteAddr = test.Client
+			proxied.ServeHTTP(w, req)
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("%s: request wasn't counted against %s, %#v", test.Name, test.Client, w.Code)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.Quota(log, nil, ok).ServeHTTP(w, httptest.NewRequest("GET", "/id/1/200/300", nil))
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("wrong response %#v %#v", w.Code, w.Header())
+		}
+	})
+}
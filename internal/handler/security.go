@@ -0,0 +1,35 @@
+package handler
+
+import "net/http"
+
+// SecurityHeaders are the security headers that are set on every response, empty policies aren't set
+type SecurityHeaders struct {
+	NoSniff               bool
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+}
+
+// DefaultSecurityHeaders keep browsers from sniffing the content type of responses, as some of them serve user-influenced content
+var DefaultSecurityHeaders = SecurityHeaders{
+	NoSniff: true,
+}
+
+// AddSecurityHeaders is a handler that sets the security headers on responses
+// They're set before the response is handled, so handlers that need a stricter policy, such as for original source images, can override them
+func AddSecurityHeaders(headers SecurityHeaders, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headers.NoSniff {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+
+		if headers.ContentSecurityPolicy != "" {
+			w.Header().Set("Content-Security-Policy", headers.ContentSecurityPolicy)
+		}
+
+		if headers.ReferrerPolicy != "" {
+			w.Header().Set("Referrer-Policy", headers.ReferrerPolicy)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestAddSecurityHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	// Handlers can set a stricter policy of their own
+	strict := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		Name            string
+		Headers         handler.SecurityHeaders
+		Handler         http.Handler
+		ExpectedHeaders map[string]string
+	}{
+		{"defaults", handler.DefaultSecurityHeaders, ok, map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Content-Security-Policy": "",
+			"Referrer-Policy":         "",
+		}},
+		{"configured", handler.SecurityHeaders{NoSniff: true, ContentSecurityPolicy: "default-src 'self'", ReferrerPolicy: "no-referrer"}, ok, map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Content-Security-Policy": "default-src 'self'",
+			"Referrer-Policy":         "no-referrer",
+		}},
+		{"disabled", handler.SecurityHeaders{}, ok, map[string]string{
+			"X-Content-Type-Options":  "",
+			"Content-Security-Policy": "",
+			"Referrer-Policy":         "",
+		}},
+		{"overridden by the handler", handler.SecurityHeaders{NoSniff: true, ContentSecurityPolicy: "default-src 'self'"}, strict, map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Content-Security-Policy": "default-src 'none'; sandbox",
+		}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/id/1/200/300", nil)
+		handler.AddSecurityHeaders(test.Headers, test.Handler).ServeHTTP(w, req)
+
+		for expectedHeader, expectedValue := range test.ExpectedHeaders {
+			if headerValue := w.Header().Get(expectedHeader); headerValue != expectedValue {
+				t.Errorf("%s: wrong header value for %s, %#v", test.Name, expectedHeader, headerValue)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/signature"
+)
+
+// Signature is a handler that only serves requests with a valid signature in front of the path under the base path, /s/{signature}/id/{id}/...
+// The request is served as the path without the signature, while the unsigned paths, such as the health check, are served without one
+// The signature covers the query params as well, and a nil signer disables it
+func Signature(signer *signature.Signer, basePath string, unsigned []string, next http.Handler) http.Handler {
+	if signer == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, basePath)
+		for _, unsignedPath := range unsigned {
+			if path == unsignedPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		sig, signedPath, ok := signature.SplitPath(path)
+		if !ok {
+			Handler(forbidden("Missing signature")).ServeHTTP(w, r)
+			return
+		}
+
+		if !signer.Valid(sig, signedPath, r.URL.Query()) {
+			Handler(forbidden("Invalid signature")).ServeHTTP(w, r)
+			return
+		}
+
+		// Serve the request without the signature, like http.StripPrefix
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = basePath + signedPath
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// forbidden returns a handler that rejects the request with a 403 and the message
+func forbidden(message string) Handler {
+	return func(w http.ResponseWriter, r *http.Request) *Error {
+		return &Error{Message: message, Code: http.StatusForbidden}
+	}
+}
@@ -0,0 +1,53 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/signature"
+)
+
+func TestSignature(t *testing.T) {
+	path := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+
+	signer := &signature.Signer{Key: []byte("secret")}
+	signed := signer.SignPath("/id/1/200/300.jpg", url.Values{"blur": {"2"}})
+
+	tests := []struct {
+		Name             string
+		Handler          http.Handler
+		URL              string
+		ExpectedStatus   int
+		ExpectedResponse string
+	}{
+		{"valid signature", handler.Signature(signer, "", []string{"/health"}, path), signed + "?blur=2", http.StatusOK, "/id/1/200/300.jpg"},
+		{"valid signature under the base path", handler.Signature(signer, "/images", []string{"/health"}, path), "/images" + signed + "?blur=2", http.StatusOK, "/images/id/1/200/300.jpg"},
+		{"tampered path", handler.Signature(signer, "", []string{"/health"}, path), "/s/" + signer.Sign("/id/1/200/300.jpg", url.Values{"blur": {"2"}}) + "/id/2/200/300.jpg?blur=2", http.StatusForbidden, "Invalid signature\n"},
+		{"tampered query", handler.Signature(signer, "", []string{"/health"}, path), signed + "?blur=3", http.StatusForbidden, "Invalid signature\n"},
+		{"missing query", handler.Signature(signer, "", []string{"/health"}, path), signed, http.StatusForbidden, "Invalid signature\n"},
+		{"missing signature", handler.Signature(signer, "", []string{"/health"}, path), "/id/1/200/300.jpg?blur=2", http.StatusForbidden, "Missing signature\n"},
+		{"unsigned path", handler.Signature(signer, "", []string{"/health"}, path), "/health", http.StatusOK, "/health"},
+		{"unsigned path under the base path", handler.Signature(signer, "/images", []string{"/health"}, path), "/images/health", http.StatusOK, "/images/health"},
+		{"disabled", handler.Signature(nil, "", nil, path), "/id/1/200/300.jpg", http.StatusOK, "/id/1/200/300.jpg"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.URL, nil)
+		test.Handler.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if w.Body.String() != test.ExpectedResponse {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+	}
+}
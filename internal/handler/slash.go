@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrailingSlash is how requests with a trailing slash in their path are handled
+type TrailingSlash int
+
+const (
+	// RedirectTrailingSlash redirects paths with a trailing slash to the path without it
+	RedirectTrailingSlash TrailingSlash = iota
+	// AcceptTrailingSlash serves paths with a trailing slash the same as the path without it
+	AcceptTrailingSlash
+)
+
+var trailingSlashNames = map[TrailingSlash]string{
+	RedirectTrailingSlash: "redirect",
+	AcceptTrailingSlash:   "accept",
+}
+
+// String returns the name of the trailing slash policy
+func (t TrailingSlash) String() string {
+	return trailingSlashNames[t]
+}
+
+// ParseTrailingSlash parses the name of a trailing slash policy, an empty name gives the default of redirecting
+func ParseTrailingSlash(value string) (TrailingSlash, error) {
+	if value == "" {
+		return RedirectTrailingSlash, nil
+	}
+
+	for policy, name := range trailingSlashNames {
+		if name == value {
+			return policy, nil
+		}
+	}
+
+	return RedirectTrailingSlash, fmt.Errorf("invalid trailing slash policy %q", value)
+}
+
+// StripTrailingSlash is a handler that serves paths with a trailing slash as the path without it, when the policy accepts them
+// The root under the base path and the paths under the kept prefixes, such as directories of static files, keep their slash
+// With the redirect policy, the router redirects the paths instead
+func StripTrailingSlash(policy TrailingSlash, basePath string, kept []string, next http.Handler) http.Handler {
+	if policy != AcceptTrailingSlash {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if !strings.HasSuffix(path, "/") || path == basePath+"/" || keptPath(strings.TrimPrefix(path, basePath), kept) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimSuffix(path, "/")
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// keptPath returns whether the path is under one of the prefixes
+func keptPath(path string, kept []string) bool {
+	for _, prefix := range kept {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+)
+
+func TestParseTrailingSlash(t *testing.T) {
+	tests := []struct {
+		Value         string
+		Expected      handler.TrailingSlash
+		ExpectedError bool
+	}{
+		{"", handler.RedirectTrailingSlash, false},
+		{"redirect", handler.RedirectTrailingSlash, false},
+		{"accept", handler.AcceptTrailingSlash, false},
+		{"strip", handler.RedirectTrailingSlash, true},
+	}
+
+	for _, test := range tests {
+		policy, err := handler.ParseTrailingSlash(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+			continue
+		}
+
+		if policy != test.Expected {
+			t.Errorf("%q: wrong policy %s", test.Value, policy)
+		}
+	}
+}
+
+func TestStripTrailingSlash(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+
+	tests := []struct {
+		Name         string
+		Policy       handler.TrailingSlash
+		BasePath     string
+		URL          string
+		ExpectedPath string
+	}{
+		{"accepted", handler.AcceptTrailingSlash, "", "/id/1/200/300/", "/id/1/200/300"},
+		{"accepted with a query", handler.AcceptTrailingSlash, "", "/id/1/200/300/?grayscale", "/id/1/200/300"},
+		{"without a trailing slash", handler.AcceptTrailingSlash, "", "/id/1/200/300", "/id/1/200/300"},
+		{"root", handler.AcceptTrailingSlash, "", "/", "/"},
+		{"root under the base path", handler.AcceptTrailingSlash, "/images", "/images/", "/images/"},
+		{"under the base path", handler.AcceptTrailingSlash, "/images", "/images/id/1/", "/images/id/1"},
+		{"kept prefix", handler.AcceptTrailingSlash, "", "/assets/css/", "/assets/css/"},
+		{"kept prefix under the base path", handler.AcceptTrailingSlash, "/images", "/images/assets/css/", "/images/assets/css/"},
+		{"redirected by the router", handler.RedirectTrailingSlash, "", "/id/1/200/300/", "/id/1/200/300/"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		handler.StripTrailingSlash(test.Policy, test.BasePath, []string{"/assets/"}, next).ServeHTTP(w, req)
+
+		if path := w.Body.String(); path != test.ExpectedPath {
+			t.Errorf("%s: wrong path %s", test.Name, path)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Effects that are applied to the resized image, named after the params that enable them
+const (
+	EffectBlur         = "blur"
+	EffectSharpen      = "sharpen"
+	EffectSaturation   = "saturation"
+	EffectVibrance     = "vibrance"
+	EffectColorize     = "colorize"
+	EffectSepia        = "sepia"
+	EffectGamma        = "gamma"
+	EffectBrightness   = "brightness"
+	EffectContrast     = "contrast"
+	EffectVignette     = "vignette"
+	EffectThreshold    = "threshold"
+	EffectPad          = "pad"
+	EffectText         = "text"
+	EffectInvertRegion = "invertregion"
+	EffectColorblind   = "colorblind"
+)
+
+// EffectOrder is the canonical order that the effects are applied in, which doesn't change between releases
+// Grayscale is applied while resizing and blend before the effects, and overlay, mask and extract after them
+var EffectOrder = []string{
+	EffectBlur,
+	EffectSharpen,
+	EffectSaturation,
+	EffectVibrance,
+	EffectColorize,
+	EffectSepia,
+	EffectGamma,
+	EffectBrightness,
+	EffectContrast,
+	EffectVignette,
+	EffectThreshold,
+	EffectPad,
+	EffectText,
+	EffectInvertRegion,
+	EffectColorblind,
+}
+
+// ParseEffectOrder parses a comma separated list of effects, in the order to apply them in
+// The effects that aren't listed are applied after the listed ones, in the canonical order, and an empty value is the canonical order
+func ParseEffectOrder(value string) ([]string, error) {
+	if value == "" {
+		return EffectOrder, nil
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isEffect(name) {
+			return nil, fmt.Errorf("invalid effect %q", name)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate effect %q", name)
+		}
+
+		seen[name] = true
+		order = append(order, name)
+	}
+
+	for _, effect := range EffectOrder {
+		if !seen[effect] {
+			order = append(order, effect)
+		}
+	}
+
+	return order, nil
+}
+
+func isEffect(name string) bool {
+	for _, effect := range EffectOrder {
+		if name == effect {
+			return true
+		}
+	}
+
+	return false
+}
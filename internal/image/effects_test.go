@@ -0,0 +1,54 @@
+package image_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+func TestEffectOrder(t *testing.T) {
+	// The canonical order is documented for the clients, so changing it changes how their images look
+	expected := []string{"blur", "sharpen", "saturation", "vibrance", "colorize", "sepia", "gamma", "brightness", "contrast", "vignette", "threshold", "pad", "text", "invertregion", "colorblind"}
+	if !reflect.DeepEqual(image.EffectOrder, expected) {
+		t.Errorf("wrong effect order %v", image.EffectOrder)
+	}
+}
+
+func TestParseEffectOrder(t *testing.T) {
+	order, err := image.ParseEffectOrder("")
+	if err != nil || !reflect.DeepEqual(order, image.EffectOrder) {
+		t.Errorf("wrong default order %v, %v", order, err)
+	}
+
+	order, err = image.ParseEffectOrder("sharpen, Blur,saturation,vibrance,colorize,sepia,gamma,brightness,contrast,vignette,threshold,pad,text,invertregion,colorblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order[0] != image.EffectSharpen || order[1] != image.EffectBlur {
+		t.Errorf("wrong order %v", order)
+	}
+
+	// The effects that aren't listed are applied after the listed ones, in the canonical order
+	order, err = image.ParseEffectOrder("threshold,sharpen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"threshold", "sharpen", "blur", "saturation", "vibrance", "colorize", "sepia", "gamma", "brightness", "contrast", "vignette", "pad", "text", "invertregion", "colorblind"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("wrong partial order %v", order)
+	}
+
+	for _, value := range []string{
+		"blur,sharpen,blur",
+		"blur,blur,saturation,vibrance,colorize,sepia,gamma,brightness,contrast,vignette,threshold,pad,text,invertregion,colorblind",
+		"grain,sharpen,saturation,vibrance,colorize,sepia,gamma,brightness,contrast,vignette,threshold,pad,text,invertregion,colorblind",
+		"blur,",
+	} {
+		if _, err := image.ParseEffectOrder(value); err == nil {
+			t.Errorf("no error for %q", value)
+		}
+	}
+}
@@ -1,8 +1,25 @@
 package image
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
 // Processor is an image processor
 type Processor interface {
 	ProcessImage(ctx context.Context, task *Task) (processedImage []byte, err error)
 }
+
+// Errors
+var (
+	// ErrUnsupportedSourceFormat is returned when a source image is corrupt or in an unsupported format
+	ErrUnsupportedSourceFormat = errors.New("unsupported or corrupt source image")
+	// ErrSourceFormatNotAllowed is returned when a source image is in a format that isn't in the allowlist
+	ErrSourceFormatNotAllowed = errors.New("source image format not allowed")
+	// ErrSourceTooLarge is returned when a source image has more pixels than allowed
+	ErrSourceTooLarge = errors.New("source image too large")
+	// ErrNoAlphaChannel is returned when the alpha channel is extracted from an image without one, and that isn't allowed
+	ErrNoAlphaChannel = errors.New("image has no alpha channel")
+	// ErrFrameOutOfRange is returned when a frame is requested that the source image doesn't have
+	ErrFrameOutOfRange = errors.New("frame out of range")
+)
@@ -0,0 +1,46 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// SingleFlightProcessor is a processor that coalesces concurrent identical tasks, so that they only get processed once
+type SingleFlightProcessor struct {
+	Processor Processor
+	group     singleflight.Group
+}
+
+// ProcessImage processes an image, sharing the result with any concurrent calls for an identical task
+// The returned buffer may be shared between callers and must not be modified
+func (p *SingleFlightProcessor) ProcessImage(ctx context.Context, task *Task) (processedImage []byte, err error) {
+	for {
+		resultChan := p.group.DoChan(task.Key(), func() (interface{}, error) {
+			return p.Processor.ProcessImage(ctx, task)
+		})
+
+		select {
+		case result := <-resultChan:
+			// The task was cancelled by the caller that started it, start it again if we're still waiting for it
+			if (result.Err == context.Canceled || result.Err == context.DeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+
+			if result.Err != nil {
+				return nil, result.Err
+			}
+
+			image, ok := result.Val.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("error getting result")
+			}
+
+			return image, nil
+		// Stop waiting if the request goes away, the task will still finish for the other callers
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
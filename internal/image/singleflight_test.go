@@ -0,0 +1,83 @@
+package image_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+type countingProcessor struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (p *countingProcessor) ProcessImage(ctx context.Context, task *image.Task) (processedImage []byte, err error) {
+	atomic.AddInt32(&p.calls, 1)
+	<-p.release
+	return []byte(task.ImageID), nil
+}
+
+func TestSingleFlightProcessor(t *testing.T) {
+	t.Run("processes concurrent identical tasks once", func(t *testing.T) {
+		counter := &countingProcessor{release: make(chan struct{})}
+		processor := &image.SingleFlightProcessor{Processor: counter}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG).Blur(5))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if string(buf) != "1" {
+					t.Errorf("wrong result %s", buf)
+				}
+			}()
+		}
+
+		// Give the goroutines time to join the in-flight task before letting it finish
+		time.Sleep(100 * time.Millisecond)
+		close(counter.release)
+		wg.Wait()
+
+		if calls := atomic.LoadInt32(&counter.calls); calls != 1 {
+			t.Errorf("processed %d times", calls)
+		}
+	})
+
+	t.Run("processes different tasks separately", func(t *testing.T) {
+		counter := &countingProcessor{release: make(chan struct{})}
+		close(counter.release)
+		processor := &image.SingleFlightProcessor{Processor: counter}
+
+		processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG))
+		processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.WebP))
+		processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG).Grayscale())
+
+		if calls := atomic.LoadInt32(&counter.calls); calls != 3 {
+			t.Errorf("processed %d times", calls)
+		}
+	})
+
+	t.Run("stops waiting when the context is cancelled", func(t *testing.T) {
+		counter := &countingProcessor{release: make(chan struct{})}
+		defer close(counter.release)
+		processor := &image.SingleFlightProcessor{Processor: counter}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := processor.ProcessImage(ctx, image.NewTask("1", 100, 100, "testing", image.JPEG))
+		if err != context.Canceled {
+			t.Errorf("wrong error %#v", err)
+		}
+	})
+}
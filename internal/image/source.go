@@ -0,0 +1,142 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// SourceFormat is the format of a source image, as identified by its magic bytes
+type SourceFormat int
+
+const (
+	// SourceUnknown is a source image that's not in any of the known formats
+	SourceUnknown SourceFormat = iota
+	// SourceJPEG is a JPEG source image
+	SourceJPEG
+	// SourcePNG is a PNG source image
+	SourcePNG
+	// SourceWebP is a WebP source image
+	SourceWebP
+	// SourceGIF is a GIF source image
+	SourceGIF
+	// SourceTIFF is a TIFF source image
+	SourceTIFF
+	// SourceHEIF is a HEIF or AVIF source image
+	SourceHEIF
+	// SourceSVG is an SVG, or other XML based, source image
+	SourceSVG
+)
+
+// DefaultSourceFormats are the source formats that are allowed by default
+var DefaultSourceFormats = []SourceFormat{SourceJPEG, SourcePNG, SourceWebP}
+
+var sourceFormatNames = map[SourceFormat]string{
+	SourceJPEG: "jpeg",
+	SourcePNG:  "png",
+	SourceWebP: "webp",
+	SourceGIF:  "gif",
+	SourceTIFF: "tiff",
+	SourceHEIF: "heif",
+	SourceSVG:  "svg",
+}
+
+// String returns the name of the source format
+func (f SourceFormat) String() string {
+	if name, ok := sourceFormatNames[f]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+var sourceContentTypes = map[SourceFormat]string{
+	SourceJPEG: "image/jpeg",
+	SourcePNG:  "image/png",
+	SourceWebP: "image/webp",
+	SourceGIF:  "image/gif",
+	SourceTIFF: "image/tiff",
+	SourceHEIF: "image/heif",
+	SourceSVG:  "image/svg+xml",
+}
+
+// ContentType returns the mime type of the source format, unknown formats are application/octet-stream
+func (f SourceFormat) ContentType() string {
+	if contentType, ok := sourceContentTypes[f]; ok {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+// ParseSourceFormats parses a comma separated list of source format names, such as "jpeg,png,webp"
+func ParseSourceFormats(value string) ([]SourceFormat, error) {
+	var formats []SourceFormat
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		format := SourceUnknown
+		for f, formatName := range sourceFormatNames {
+			if formatName == name {
+				format = f
+			}
+		}
+
+		if format == SourceUnknown {
+			return nil, fmt.Errorf("invalid source format %q", name)
+		}
+
+		formats = append(formats, format)
+	}
+
+	return formats, nil
+}
+
+// SniffSourceFormat identifies the format of a source image from its magic bytes
+func SniffSourceFormat(buffer []byte) SourceFormat {
+	switch {
+	case bytes.HasPrefix(buffer, []byte{0xFF, 0xD8, 0xFF}):
+		return SourceJPEG
+	case bytes.HasPrefix(buffer, []byte("\x89PNG\r\n\x1a\n")):
+		return SourcePNG
+	case len(buffer) >= 12 && bytes.Equal(buffer[0:4], []byte("RIFF")) && bytes.Equal(buffer[8:12], []byte("WEBP")):
+		return SourceWebP
+	case bytes.HasPrefix(buffer, []byte("GIF87a")) || bytes.HasPrefix(buffer, []byte("GIF89a")):
+		return SourceGIF
+	case bytes.HasPrefix(buffer, []byte("II*\x00")) || bytes.HasPrefix(buffer, []byte("MM\x00*")):
+		return SourceTIFF
+	case len(buffer) >= 12 && bytes.Equal(buffer[4:8], []byte("ftyp")) && isHEIFBrand(buffer[8:12]):
+		return SourceHEIF
+	}
+
+	// XML can start with a byte order mark and whitespace, and anything that looks like markup is treated as SVG
+	text := bytes.TrimLeft(bytes.TrimPrefix(buffer, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if bytes.HasPrefix(text, []byte("<")) {
+		return SourceSVG
+	}
+
+	return SourceUnknown
+}
+
+func isHEIFBrand(brand []byte) bool {
+	switch string(brand) {
+	case "heic", "heix", "hevc", "hevx", "mif1", "msf1", "avif", "avis":
+		return true
+	default:
+		return false
+	}
+}
+
+// AllowsSourceFormat returns whether the format is in the list of allowed formats
+func AllowsSourceFormat(allowed []SourceFormat, format SourceFormat) bool {
+	for _, f := range allowed {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package image_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+func TestSniffSourceFormat(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Buffer   []byte
+		Expected image.SourceFormat
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}, image.SourceJPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), image.SourcePNG},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), image.SourceWebP},
+		{"gif", []byte("GIF89a\x01\x00"), image.SourceGIF},
+		{"tiff", []byte("II*\x00\x08\x00"), image.SourceTIFF},
+		{"heif", []byte("\x00\x00\x00\x18ftypheic"), image.SourceHEIF},
+		{"avif", []byte("\x00\x00\x00\x1cftypavif"), image.SourceHEIF},
+		{"svg", []byte("\xEF\xBB\xBF  <?xml version=\"1.0\"?><svg/>"), image.SourceSVG},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom"), image.SourceUnknown},
+		{"riff without webp", []byte("RIFF\x00\x00\x00\x00WAVE"), image.SourceUnknown},
+		{"text", []byte("this is not an image"), image.SourceUnknown},
+		{"empty", []byte{}, image.SourceUnknown},
+	}
+
+	for _, test := range tests {
+		if format := image.SniffSourceFormat(test.Buffer); format != test.Expected {
+			t.Errorf("%s: wrong format %s", test.Name, format)
+		}
+	}
+
+	// The fixtures are named .jpg regardless of their format
+	fixtures := map[string]image.SourceFormat{
+		"1.jpg":         image.SourceJPEG,
+		"quadrants.jpg": image.SourcePNG,
+		"animated.jpg":  image.SourceGIF,
+		"corrupt.jpg":   image.SourceUnknown,
+	}
+
+	for name, expected := range fixtures {
+		buffer, err := ioutil.ReadFile("../../test/fixtures/file/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if format := image.SniffSourceFormat(buffer); format != expected {
+			t.Errorf("%s: wrong format %s", name, format)
+		}
+	}
+}
+
+func TestSourceContentType(t *testing.T) {
+	tests := map[image.SourceFormat]string{
+		image.SourceJPEG:    "image/jpeg",
+		image.SourcePNG:     "image/png",
+		image.SourceWebP:    "image/webp",
+		image.SourceSVG:     "image/svg+xml",
+		image.SourceUnknown: "application/octet-stream",
+	}
+
+	for format, expected := range tests {
+		if contentType := format.ContentType(); contentType != expected {
+			t.Errorf("%s: wrong content type %s", format, contentType)
+		}
+	}
+}
+
+func TestOutputContentType(t *testing.T) {
+	// The mime types are compared exactly, so that a non-canonical image/jpg or a charset fails
+	tests := []struct {
+		Format              image.OutputFormat
+		ExpectedContentType string
+		ExpectedExtension   string
+	}{
+		{image.JPEG, "image/jpeg", ".jpg"},
+		{image.WebP, "image/webp", ".webp"},
+	}
+
+	for _, test := range tests {
+		if contentType := test.Format.ContentType(); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.ExpectedExtension, contentType)
+		}
+
+		if extension := test.Format.Extension(); extension != test.ExpectedExtension {
+			t.Errorf("%s: wrong extension %s", test.ExpectedExtension, extension)
+		}
+	}
+}
+
+func TestParseSourceFormats(t *testing.T) {
+	formats, err := image.ParseSourceFormats("jpeg, PNG,,gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []image.SourceFormat{image.SourceJPEG, image.SourcePNG, image.SourceGIF}
+	if len(formats) != len(expected) {
+		t.Fatalf("wrong formats %v", formats)
+	}
+
+	for i, format := range expected {
+		if formats[i] != format {
+			t.Errorf("wrong format %s at %d", formats[i], i)
+		}
+	}
+
+	if !image.AllowsSourceFormat(formats, image.SourceGIF) || image.AllowsSourceFormat(formats, image.SourceWebP) {
+		t.Errorf("wrong allowlist %v", formats)
+	}
+
+	for _, value := range []string{"jpeg,bmp", "unknown"} {
+		if _, err := image.ParseSourceFormats(value); err == nil {
+			t.Errorf("no error for %q", value)
+		}
+	}
+}
@@ -1,17 +1,224 @@
 package image
 
+import "fmt"
+
 // Task is an image processing task
 type Task struct {
-	ImageID        string
-	Width          int
-	Height         int
-	ApplyBlur      bool
-	BlurAmount     int
-	ApplyGrayscale bool
-	UserComment    string
-	OutputFormat   OutputFormat
+	ImageID          string
+	Width            int
+	Height           int
+	ApplyBlur        bool
+	BlurAmount       float64
+	BlurEdgeMode     Edge
+	ApplyBlurRegion  bool
+	BlurArea         Region
+	BlurSource       bool
+	FastPlaceholder  bool
+	ApplySharpen     bool
+	SharpenSigma     float64
+	ApplySaturation  bool
+	SaturationAmount float64
+	ApplyVibrance    bool
+	VibranceAmount   int
+	ApplySepia       bool
+	SepiaAmount      int
+	ApplyColorize    bool
+	ColorizeHue      float64
+	ColorizeChroma   float64
+	ApplyGamma       bool
+	GammaExponent    float64
+	ApplyBrightness  bool
+	BrightnessAmount int
+	ApplyContrast    bool
+	ContrastAmount   int
+	ApplyVignette    bool
+	VignetteAmount   int
+	ApplyThreshold   bool
+	ThresholdLevel   int
+	DitherThreshold  bool
+	SourceFrame      int
+	ApplySheet       bool
+	SheetColumns     int
+	SheetFrames      int
+	Orientation      int
+	ApplyCrop        bool
+	CropArea         Region
+	ApplyTrim        bool
+	TrimByColor      bool
+	TrimBackground   Color
+	TrimThreshold    int
+	ApplyPad         bool
+	CanvasWidth      int
+	CanvasHeight     int
+	Background       Color
+	TransparentPad   bool
+	BackgroundAlpha  uint8
+	ApplyFlatten     bool
+	ApplyInvert      bool
+	InvertArea       Region
+	ApplyColorblind  bool
+	ColorblindMode   ColorblindMode
+	ApplyText        bool
+	Text             string
+	TextColor        Color
+	TextGravity      Gravity
+	BlendImageID     string
+	BlendMode        BlendMode
+	BlendOpacity     float64
+	OverlayImageID   string
+	OverlayGravity   Gravity
+	OverlayOpacity   float64
+	OverlayPercent   int
+	MaskImageID      string
+	ApplyExtract     bool
+	ExtractChannel   Channel
+	RequireAlpha     bool
+	ResizeFilter     ResizeFilter
+	FitMode          Fit
+	EncodeEffort     int
+	EncodeQuality    int
+	OptimizeHuffman  bool
+	ProgressiveJPEG  bool
+	EncodeLossless   bool
+	LosslessLevel    int
+	ColorSpace       ColorSpace
+	EmbedICCProfile  bool
+	UserComment      string
+	OutputDPI        int
+	OutputFormat     OutputFormat
+	RawPixelFormat   PixelFormat
+	Version          string
+}
+
+// ColorSpace is the color space to output in
+type ColorSpace int
+
+const (
+	// SRGB represents the sRGB color space
+	SRGB ColorSpace = iota
+	// DisplayP3 represents the Display P3 color space
+	DisplayP3
+)
+
+// Color is an RGB color
+type Color struct {
+	R uint8
+	G uint8
+	B uint8
+}
+
+// Region is a rectangle of the image, in pixels from the top left
+type Region struct {
+	Left   int
+	Top    int
+	Width  int
+	Height int
 }
 
+// Gravity is the position of something placed on the image
+type Gravity int
+
+const (
+	// Center places it in the center of the image
+	Center Gravity = iota
+	// North places it at the top
+	North
+	// NorthEast places it at the top right
+	NorthEast
+	// East places it at the right
+	East
+	// SouthEast places it at the bottom right
+	SouthEast
+	// South places it at the bottom
+	South
+	// SouthWest places it at the bottom left
+	SouthWest
+	// West places it at the left
+	West
+	// NorthWest places it at the top left
+	NorthWest
+)
+
+// Edge is how the pixels past the edges of the image are filled in, for effects that read them
+type Edge int
+
+const (
+	// ExtendEdge repeats the pixels at the edges
+	ExtendEdge Edge = iota
+	// MirrorEdge reflects the image at the edges
+	MirrorEdge
+	// WrapEdge continues with the opposite side of the image
+	WrapEdge
+)
+
+// BlendMode is the mode to blend an image on top of another with
+type BlendMode int
+
+const (
+	// Normal places the other image on top
+	Normal BlendMode = iota
+	// Multiply multiplies the colors of the images
+	Multiply
+	// Screen inverts, multiplies and inverts the colors of the images again
+	Screen
+	// Overlay multiplies or screens the colors depending on the colors of the image below
+	Overlay
+)
+
+// Channel is a channel of the image to extract
+type Channel int
+
+const (
+	// RedChannel is the red channel
+	RedChannel Channel = iota
+	// GreenChannel is the green channel
+	GreenChannel
+	// BlueChannel is the blue channel
+	BlueChannel
+	// AlphaChannel is the alpha channel
+	AlphaChannel
+	// LuminanceChannel is the lightness of the image, the same as grayscale
+	LuminanceChannel
+)
+
+// ColorblindMode is a type of color blindness to simulate
+type ColorblindMode int
+
+const (
+	// Protanopia is color blindness without the red cones
+	Protanopia ColorblindMode = iota
+	// Deuteranopia is color blindness without the green cones
+	Deuteranopia
+	// Tritanopia is color blindness without the blue cones
+	Tritanopia
+)
+
+// Fit is how the image is resized to the requested size
+type Fit int
+
+const (
+	// Cover scales the image to cover the size, cropping off the excess
+	Cover Fit = iota
+	// Contain scales the image to fit within the size, without cropping it
+	Contain
+	// Fill stretches the image to the size
+	Fill
+)
+
+// ResizeFilter is the interpolation filter to use when resizing
+type ResizeFilter int
+
+const (
+	// Lanczos represents the lanczos3 filter
+	Lanczos ResizeFilter = iota
+	// Cubic represents the cubic filter
+	Cubic
+	// Linear represents the linear filter
+	Linear
+	// Nearest represents the nearest-neighbour filter
+	Nearest
+)
+
 // OutputFormat is the image format to output to
 type OutputFormat int
 
@@ -20,28 +227,417 @@ const (
 	JPEG OutputFormat = iota
 	// WebP represents the WebP format
 	WebP
+	// Raw represents the uncompressed pixels of the image, in the pixel format of the task
+	Raw
+)
+
+var outputContentTypes = map[OutputFormat]string{
+	JPEG: "image/jpeg",
+	WebP: "image/webp",
+	Raw:  "application/octet-stream",
+}
+
+// ContentType returns the canonical mime type of the output format, without a charset as images are binary
+func (f OutputFormat) ContentType() string {
+	if contentType, ok := outputContentTypes[f]; ok {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+var outputExtensions = map[OutputFormat]string{
+	JPEG: ".jpg",
+	WebP: ".webp",
+	Raw:  ".bin",
+}
+
+// Extension returns the file extension of the output format, including the dot
+func (f OutputFormat) Extension() string {
+	return outputExtensions[f]
+}
+
+// PixelFormat is the layout of the pixels of raw output, with 8 bits per channel
+type PixelFormat int
+
+const (
+	// RGB8 has a red, green and blue channel
+	RGB8 PixelFormat = iota
+	// RGBA8 has a red, green, blue and alpha channel
+	RGBA8
+	// Gray8 has a single grayscale channel
+	Gray8
+)
+
+var pixelFormatChannels = map[PixelFormat]int{
+	RGB8:  3,
+	RGBA8: 4,
+	Gray8: 1,
+}
+
+// Channels returns the number of channels of each pixel, which is also its size in bytes
+func (f PixelFormat) Channels() int {
+	return pixelFormatChannels[f]
+}
+
+const (
+	// DefaultEncodeEffort is the encoder effort level used when none is set
+	DefaultEncodeEffort = 4
+	// DefaultQuality is the encoder quality used when none is set
+	DefaultQuality = 75
+	// MaxLosslessLevel is the near lossless level that doesn't preprocess the image, making it fully lossless
+	MaxLosslessLevel = 100
 )
 
 // NewTask creates a new image processing task
 func NewTask(imageID string, width int, height int, userComment string, format OutputFormat) *Task {
 	return &Task{
-		ImageID:      imageID,
-		Width:        width,
-		Height:       height,
-		UserComment:  userComment,
-		OutputFormat: format,
+		ImageID:         imageID,
+		Width:           width,
+		Height:          height,
+		EncodeEffort:    DefaultEncodeEffort,
+		EncodeQuality:   DefaultQuality,
+		OptimizeHuffman: true,
+		ProgressiveJPEG: true,
+		UserComment:     userComment,
+		OutputFormat:    format,
 	}
 }
 
 // Blur applies gaussian blur to the image
-func (t *Task) Blur(amount int) *Task {
+func (t *Task) Blur(amount float64) *Task {
 	t.ApplyBlur = true
 	t.BlurAmount = amount
 	return t
 }
 
-// Grayscale turns the image into grayscale
-func (t *Task) Grayscale() *Task {
-	t.ApplyGrayscale = true
+// BlurEdge sets how the pixels past the edges of the image are filled in when blurring it
+func (t *Task) BlurEdge(edge Edge) *Task {
+	t.BlurEdgeMode = edge
+	return t
+}
+
+// BlurRegion limits the blur to a region of the resized image, leaving the rest of it sharp
+func (t *Task) BlurRegion(region Region) *Task {
+	t.ApplyBlurRegion = true
+	t.BlurArea = region
+	return t
+}
+
+// BlurBeforeResize blurs the image at the resolution of the source before resizing it, rather than after
+// The amount is still in pixels of the resized image, and it's scaled along with the image
+func (t *Task) BlurBeforeResize() *Task {
+	t.BlurSource = true
+	return t
+}
+
+// Sharpen sharpens the image after resizing it, where sigma is the size of the details to sharpen
+func (t *Task) Sharpen(sigma float64) *Task {
+	t.ApplySharpen = true
+	t.SharpenSigma = sigma
+	return t
+}
+
+// Placeholder processes a blurred image with faster, lower quality resampling and blur, for use as a low quality image placeholder
+// The difference is hidden by the blur, as long as the image is small
+func (t *Task) Placeholder() *Task {
+	t.FastPlaceholder = true
+	return t
+}
+
+// ProcessingVersion sets the version of the processing that the task is processed with
+// It doesn't change the processing, but it's part of the key, so that the results cached by the key change along with the version
+func (t *Task) ProcessingVersion(version string) *Task {
+	t.Version = version
+	return t
+}
+
+// Key returns a key that uniquely identifies the task, for identifying identical tasks
+// It's built from all the fields so that new options are always included
+func (t *Task) Key() string {
+	return fmt.Sprintf("%+v", *t)
+}
+
+// Filter sets the interpolation filter to use when resizing the image
+func (t *Task) Filter(filter ResizeFilter) *Task {
+	t.ResizeFilter = filter
+	return t
+}
+
+// Fit sets how the image is resized to the size of the task
+func (t *Task) Fit(fit Fit) *Task {
+	t.FitMode = fit
+	return t
+}
+
+// Effort sets the encoder effort level, trading encoding speed for a smaller output
+// Only applies to WebP, ranges from 0 (fastest) to 6 (smallest)
+func (t *Task) Effort(level int) *Task {
+	t.EncodeEffort = level
+	return t
+}
+
+// OptimizeCoding sets whether the Huffman coding is optimized when encoding JPEG images, which is on by default
+// It makes the image a few percent smaller, at the cost of a slower encode
+func (t *Task) OptimizeCoding(enabled bool) *Task {
+	t.OptimizeHuffman = enabled
+	return t
+}
+
+// Baseline encodes JPEG images as baseline instead of progressive, for clients that can't decode progressive images
+func (t *Task) Baseline() *Task {
+	t.ProgressiveJPEG = false
+	return t
+}
+
+// Lossless encodes the image losslessly, only applies to WebP
+func (t *Task) Lossless() *Task {
+	return t.NearLossless(MaxLosslessLevel)
+}
+
+// NearLossless encodes the image losslessly after preprocessing it with level (0-100) to compress better, only applies to WebP
+// Lower levels preprocess more, and 100 is the same as lossless
+func (t *Task) NearLossless(level int) *Task {
+	t.EncodeLossless = true
+	t.LosslessLevel = level
 	return t
 }
+
+// ConvertColorSpace converts the image to the given color space
+func (t *Task) ConvertColorSpace(colorSpace ColorSpace) *Task {
+	t.ColorSpace = colorSpace
+	return t
+}
+
+// EmbedProfile embeds the ICC profile of the output color space in the image
+func (t *Task) EmbedProfile() *Task {
+	t.EmbedICCProfile = true
+	return t
+}
+
+// PixelFormat sets the layout of the pixels of raw output, only applies to Raw
+func (t *Task) PixelFormat(format PixelFormat) *Task {
+	t.RawPixelFormat = format
+	return t
+}
+
+// DPI embeds the resolution in the image, for printing it at a physical size
+// Only JPEG images carry it, in the density of their header
+func (t *Task) DPI(dpi int) *Task {
+	t.OutputDPI = dpi
+	return t
+}
+
+// Quality sets the encoder quality, from 1 to 100
+func (t *Task) Quality(quality int) *Task {
+	t.EncodeQuality = quality
+	return t
+}
+
+// Frame selects the 0-indexed frame of an animated source image to process
+func (t *Task) Frame(frame int) *Task {
+	t.SourceFrame = frame
+	return t
+}
+
+// ContactSheet lays out up to the given amount of evenly spaced frames of an animated source image in a grid with the given amount of columns
+// Each frame is resized to the width and height of the task, so they are the size of a cell
+func (t *Task) ContactSheet(columns int, frames int) *Task {
+	t.ApplySheet = true
+	t.SheetColumns = columns
+	t.SheetFrames = frames
+	return t
+}
+
+// Orient overrides the EXIF orientation (1-8) of the source image
+func (t *Task) Orient(orientation int) *Task {
+	t.Orientation = orientation
+	return t
+}
+
+// Crop crops the source image to a region before resizing it, in pixels of the oriented source image
+func (t *Task) Crop(region Region) *Task {
+	t.ApplyCrop = true
+	t.CropArea = region
+	return t
+}
+
+// Trim crops off the borders of the source image before resizing it
+// The border color is taken from the top left pixel, and pixels within threshold of it are trimmed
+func (t *Task) Trim(threshold int) *Task {
+	t.ApplyTrim = true
+	t.TrimByColor = false
+	t.TrimThreshold = threshold
+	return t
+}
+
+// TrimColor crops off the borders of the given color from the source image before resizing it
+func (t *Task) TrimColor(color Color, threshold int) *Task {
+	t.ApplyTrim = true
+	t.TrimByColor = true
+	t.TrimBackground = color
+	t.TrimThreshold = threshold
+	return t
+}
+
+// Pad centers the image on a canvas of the given size, filling the rest with the background color
+func (t *Task) Pad(width int, height int, background Color) *Task {
+	t.ApplyPad = true
+	t.CanvasWidth = width
+	t.CanvasHeight = height
+	t.Background = background
+	return t
+}
+
+// PadTransparent centers the image on a canvas of the given size, leaving the rest transparent
+func (t *Task) PadTransparent(width int, height int) *Task {
+	t.ApplyPad = true
+	t.CanvasWidth = width
+	t.CanvasHeight = height
+	t.TransparentPad = true
+	return t
+}
+
+// PadTranslucent centers the image on a canvas of the given size, filling the rest with the background color at the given alpha
+// The padding has an alpha channel like PadTransparent, which is the same as an alpha of 0
+func (t *Task) PadTranslucent(width int, height int, background Color, alpha uint8) *Task {
+	t.PadTransparent(width, height)
+	t.Background = background
+	t.BackgroundAlpha = alpha
+	return t
+}
+
+// Flatten composites the transparent areas of the image onto the background color, and removes the alpha channel
+// It shares the background color with Pad, as both fill in the areas around the image
+func (t *Task) Flatten(background Color) *Task {
+	t.ApplyFlatten = true
+	t.Background = background
+	return t
+}
+
+// DrawText draws text in the given color onto the image, at the position given by gravity
+func (t *Task) DrawText(text string, color Color, gravity Gravity) *Task {
+	t.ApplyText = true
+	t.Text = text
+	t.TextColor = color
+	t.TextGravity = gravity
+	return t
+}
+
+// InvertRegion inverts the colors of a region of the image, after the other effects so that it applies to the final image
+func (t *Task) InvertRegion(region Region) *Task {
+	t.ApplyInvert = true
+	t.InvertArea = region
+	return t
+}
+
+// Colorblind simulates how the image looks with the type of color blindness, after the other effects so that it applies to the final image
+func (t *Task) Colorblind(mode ColorblindMode) *Task {
+	t.ApplyColorblind = true
+	t.ColorblindMode = mode
+	return t
+}
+
+// Blend resizes another image to the same size and blends it on top of the image, with the given mode and opacity (0-1)
+func (t *Task) Blend(imageID string, mode BlendMode, opacity float64) *Task {
+	t.BlendImageID = imageID
+	t.BlendMode = mode
+	t.BlendOpacity = opacity
+	return t
+}
+
+// Overlay fits another image within percent (1-100) of the size of the image, and places it on top at the position given by gravity, with the given opacity (0-1)
+// It's placed after the effects, so that they don't apply to the overlay
+func (t *Task) Overlay(imageID string, gravity Gravity, opacity float64, percent int) *Task {
+	t.OverlayImageID = imageID
+	t.OverlayGravity = gravity
+	t.OverlayOpacity = opacity
+	t.OverlayPercent = percent
+	return t
+}
+
+// Saturate multiplies the saturation of the image by amount
+func (t *Task) Saturate(amount float64) *Task {
+	t.ApplySaturation = true
+	t.SaturationAmount = amount
+	return t
+}
+
+// Sepia tones the image sepia by amount (0-100), where 100 is full sepia and lower amounts blend it with the original colors
+func (t *Task) Sepia(amount int) *Task {
+	t.ApplySepia = true
+	t.SepiaAmount = amount
+	return t
+}
+
+// Vibrance boosts the saturation of the muted colors in the image by amount (-100-100), leaving saturated colors and skin tones mostly unchanged
+func (t *Task) Vibrance(amount int) *Task {
+	t.ApplyVibrance = true
+	t.VibranceAmount = amount
+	return t
+}
+
+// Colorize replaces the colors of the image with a single hue (0-360) and chroma in LCh, keeping the lightness of each pixel
+func (t *Task) Colorize(hue float64, chroma float64) *Task {
+	t.ApplyColorize = true
+	t.ColorizeHue = hue
+	t.ColorizeChroma = chroma
+	return t
+}
+
+// Gamma applies gamma correction to the image, where a gamma above 1 brightens the midtones, and below 1 darkens them
+func (t *Task) Gamma(gamma float64) *Task {
+	t.ApplyGamma = true
+	t.GammaExponent = gamma
+	return t
+}
+
+// Brightness brightens (or darkens) the image by amount (-100-100) percent of the full range
+func (t *Task) Brightness(amount int) *Task {
+	t.ApplyBrightness = true
+	t.BrightnessAmount = amount
+	return t
+}
+
+// Contrast increases (or reduces) the contrast of the image by amount (-100-100), where -100 turns it into flat gray
+func (t *Task) Contrast(amount int) *Task {
+	t.ApplyContrast = true
+	t.ContrastAmount = amount
+	return t
+}
+
+// Vignette darkens the image toward its edges by amount (0-100), where 100 turns the corners black
+func (t *Task) Vignette(amount int) *Task {
+	t.ApplyVignette = true
+	t.VignetteAmount = amount
+	return t
+}
+
+// Threshold converts the image to pure black and white, with the pixels at or above the luminance level (0-255) becoming white
+// It runs after the color adjustments, so that it's applied to the grayscale image when combined with grayscale
+func (t *Task) Threshold(level int, dither bool) *Task {
+	t.ApplyThreshold = true
+	t.ThresholdLevel = level
+	t.DitherThreshold = dither
+	return t
+}
+
+// Mask resizes a grayscale image to the size of the image, and uses it as the alpha channel of the image
+func (t *Task) Mask(imageID string) *Task {
+	t.MaskImageID = imageID
+	return t
+}
+
+// Extract outputs only the given channel of the processed image, as a grayscale image
+// Extracting the alpha channel of an image without one gives an opaque image, unless requireAlpha is set, which makes it fail
+func (t *Task) Extract(channel Channel, requireAlpha bool) *Task {
+	t.ApplyExtract = true
+	t.ExtractChannel = channel
+	t.RequireAlpha = requireAlpha
+	return t
+}
+
+// Grayscale turns the image into grayscale, which is the same as a saturation of 0
+func (t *Task) Grayscale() *Task {
+	return t.Saturate(0)
+}
@@ -0,0 +1,54 @@
+package vips
+
+import (
+	"math"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/vips"
+)
+
+// blurSourceScale is the most that images are blurred at above the size of the task, as the blur scales up with the image
+// Past it the blur is much slower to compute, for a difference that doesn't show once the image is resized
+const blurSourceScale = 4
+
+// blursSource returns whether the image of the task is blurred at its native resolution before it's resized
+// Blur regions are in pixels of the resized image and contact sheets are made of resized frames, so they're always blurred after resizing
+func blursSource(task *image.Task) bool {
+	return task.ApplyBlur && task.BlurSource && !task.ApplyBlurRegion && !task.ApplySheet
+}
+
+// blurSourceSize returns the size to resize the source image to before blurring it, and the ratio to scale it by after that
+// The source is resized to the framing of the task at about its native resolution, the same way as for the upscaler, up to blurSourceScale times the size of the task
+func blurSourceSize(buffer []byte, task *image.Task) (width int, height int, ratio float64) {
+	// Images that can't be read are left to fail when resizing them
+	sourceWidth, sourceHeight, err := vips.ImageSize(buffer)
+	if err != nil {
+		return task.Width, task.Height, 0
+	}
+
+	ratio = math.Max(upscaleRatio(sourceWidth, sourceHeight, task), 1.0/blurSourceScale)
+	return scaleDimension(task.Width, 1/ratio), scaleDimension(task.Height, 1/ratio), ratio
+}
+
+// blurSource blurs the image at the resolution it was resized to, and scales it by the ratio to the size of the task
+// The blur amount is in pixels of the resized image, so it's scaled up along with the image, which is what makes it slower than blurring after resizing
+func blurSource(i *resizedImage, task *image.Task, ratio float64) (*resizedImage, error) {
+	img, err := vips.Blur(i.vipsImage, task.BlurAmount/ratio, task.FastPlaceholder, getExtend(task.BlurEdgeMode))
+	if err != nil {
+		return nil, err
+	}
+
+	// Contained images are smaller than the task on one side, so the size to scale to follows the blurred image, up to the size of the task
+	nativeWidth, nativeHeight := vips.ImageDimensions(img)
+	width := int(math.Min(float64(task.Width), float64(scaleDimension(nativeWidth, ratio))))
+	height := int(math.Min(float64(task.Height), float64(scaleDimension(nativeHeight, ratio))))
+
+	img, err = vips.Scale(img, width, height, getResizeOptions(task).Kernel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: img,
+	}, nil
+}
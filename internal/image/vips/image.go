@@ -1,6 +1,9 @@
 package vips
 
-import "github.com/DMarby/picsum-photos/internal/vips"
+import (
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/vips"
+)
 
 // resizedImage is a resized image
 type resizedImage struct {
@@ -9,8 +12,8 @@ type resizedImage struct {
 
 // resizeImage loads an image from a byte buffer, resizes it and returns an Image object for further use
 // Note that it does not use the processor worker queue, use ProcessImage for that
-func resizeImage(buffer []byte, width int, height int) (*resizedImage, error) {
-	image, err := vips.ResizeImage(buffer, width, height)
+func resizeImage(buffer []byte, width int, height int, options vips.ResizeOptions) (*resizedImage, error) {
+	image, err := vips.ResizeImage(buffer, width, height, options)
 
 	if err != nil {
 		return nil, err
@@ -21,9 +24,164 @@ func resizeImage(buffer []byte, width int, height int) (*resizedImage, error) {
 	}, nil
 }
 
-// grayscale turns an image into grayscale
-func (i *resizedImage) grayscale() (*resizedImage, error) {
-	image, err := vips.Grayscale(i.vipsImage)
+// getResizeOptions maps the loading and resizing settings of a task to the vips resize options
+func getResizeOptions(task *image.Task) vips.ResizeOptions {
+	// Placeholders are blurred anyway, so they don't need the high quality resampling
+	kernel := getKernel(task.ResizeFilter)
+	if task.FastPlaceholder && kernel != vips.KernelNearest {
+		kernel = vips.KernelLinear
+	}
+
+	return vips.ResizeOptions{
+		Kernel:      kernel,
+		Fit:         getFit(task.FitMode),
+		Frame:       task.SourceFrame,
+		Orientation: task.Orientation,
+		Crop:        getCrop(task),
+		Trim:        getTrim(task),
+	}
+}
+
+// getCrop maps the crop settings of a task to the vips crop options
+func getCrop(task *image.Task) vips.Crop {
+	return vips.Crop{
+		Enabled: task.ApplyCrop,
+		Left:    task.CropArea.Left,
+		Top:     task.CropArea.Top,
+		Width:   task.CropArea.Width,
+		Height:  task.CropArea.Height,
+	}
+}
+
+// getTrim maps the trim settings of a task to the vips trim options
+func getTrim(task *image.Task) vips.Trim {
+	return vips.Trim{
+		Enabled:   task.ApplyTrim,
+		UseColor:  task.TrimByColor,
+		R:         task.TrimBackground.R,
+		G:         task.TrimBackground.G,
+		B:         task.TrimBackground.B,
+		Threshold: task.TrimThreshold,
+	}
+}
+
+// getGravity maps a gravity to the matching vips compass direction
+func getGravity(gravity image.Gravity) vips.Gravity {
+	switch gravity {
+	case image.North:
+		return vips.GravityNorth
+	case image.NorthEast:
+		return vips.GravityNorthEast
+	case image.East:
+		return vips.GravityEast
+	case image.SouthEast:
+		return vips.GravitySouthEast
+	case image.South:
+		return vips.GravitySouth
+	case image.SouthWest:
+		return vips.GravitySouthWest
+	case image.West:
+		return vips.GravityWest
+	case image.NorthWest:
+		return vips.GravityNorthWest
+	default:
+		return vips.GravityCenter
+	}
+}
+
+// getExtend maps an edge to the matching vips extend mode
+func getExtend(edge image.Edge) vips.Extend {
+	switch edge {
+	case image.MirrorEdge:
+		return vips.ExtendMirror
+	case image.WrapEdge:
+		return vips.ExtendRepeat
+	default:
+		return vips.ExtendCopy
+	}
+}
+
+// getBlendMode maps a blend mode to the matching vips blend mode
+func getBlendMode(mode image.BlendMode) vips.BlendMode {
+	switch mode {
+	case image.Multiply:
+		return vips.BlendModeMultiply
+	case image.Screen:
+		return vips.BlendModeScreen
+	case image.Overlay:
+		return vips.BlendModeOverlay
+	default:
+		return vips.BlendModeNormal
+	}
+}
+
+// getChannel maps a channel to the matching vips channel
+func getChannel(channel image.Channel) vips.Channel {
+	switch channel {
+	case image.GreenChannel:
+		return vips.ChannelGreen
+	case image.BlueChannel:
+		return vips.ChannelBlue
+	case image.AlphaChannel:
+		return vips.ChannelAlpha
+	case image.LuminanceChannel:
+		return vips.ChannelLuminance
+	default:
+		return vips.ChannelRed
+	}
+}
+
+// getFit maps a fit to the matching vips fit
+func getFit(fit image.Fit) vips.Fit {
+	switch fit {
+	case image.Contain:
+		return vips.FitContain
+	case image.Fill:
+		return vips.FitFill
+	default:
+		return vips.FitCover
+	}
+}
+
+// getKernel maps a resize filter to the matching vips kernel
+func getKernel(filter image.ResizeFilter) vips.Kernel {
+	switch filter {
+	case image.Cubic:
+		return vips.KernelCubic
+	case image.Linear:
+		return vips.KernelLinear
+	case image.Nearest:
+		return vips.KernelNearest
+	default:
+		return vips.KernelLanczos3
+	}
+}
+
+// iccTransform converts an image to the given color space
+func (i *resizedImage) iccTransform(colorSpace image.ColorSpace, embed bool) (*resizedImage, error) {
+	image, err := vips.ICCTransform(i.vipsImage, getProfile(colorSpace), embed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: image,
+	}, nil
+}
+
+// getProfile maps a color space to the matching ICC profile built into libvips
+func getProfile(colorSpace image.ColorSpace) string {
+	switch colorSpace {
+	case image.DisplayP3:
+		return "p3"
+	default:
+		return "srgb"
+	}
+}
+
+// flatten composites the transparent areas of the image onto the background color, and removes the alpha channel
+func (i *resizedImage) flatten(background image.Color) (*resizedImage, error) {
+	image, err := vips.Flatten(i.vipsImage, background.R, background.G, background.B)
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +191,14 @@ func (i *resizedImage) grayscale() (*resizedImage, error) {
 	}, nil
 }
 
-// blur applies gaussian blur to an image
-func (i *resizedImage) blur(blur int) (*resizedImage, error) {
-	image, err := vips.Blur(i.vipsImage, blur)
+// removeOpaqueAlpha removes the alpha channel of the image if none of its pixels are transparent, so that it isn't encoded
+func (i *resizedImage) removeOpaqueAlpha() (*resizedImage, error) {
+	opaque, err := vips.IsOpaque(i.vipsImage)
+	if err != nil || !opaque {
+		return i, err
+	}
+
+	image, err := vips.RemoveAlpha(i.vipsImage)
 	if err != nil {
 		return nil, err
 	}
@@ -50,9 +213,43 @@ func (i *resizedImage) setUserComment(comment string) {
 	vips.SetUserComment(i.vipsImage, comment)
 }
 
-// saveToJpegBuffer returns the image as a JPEG byte buffer
-func (i *resizedImage) saveToJpegBuffer() ([]byte, error) {
-	imageBuffer, err := vips.SaveToJpegBuffer(i.vipsImage)
+// setResolution sets the resolution of the image in dots per inch, without changing its pixels
+func (i *resizedImage) setResolution(dpi int) (*resizedImage, error) {
+	image, err := vips.SetResolution(i.vipsImage, float64(dpi))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: image,
+	}, nil
+}
+
+// saveToJpegBuffer returns the image as a JPEG byte buffer, encoded with the given quality, optionally optimizing the Huffman coding and as a progressive image
+func (i *resizedImage) saveToJpegBuffer(quality int, optimizeCoding bool, progressive bool) ([]byte, error) {
+	imageBuffer, err := vips.SaveToJpegBuffer(i.vipsImage, quality, optimizeCoding, progressive)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return imageBuffer, nil
+}
+
+// saveToRawBuffer returns the uncompressed pixels of the image, with the given number of 8 bit channels
+func (i *resizedImage) saveToRawBuffer(channels int) ([]byte, error) {
+	imageBuffer, err := vips.SaveToRawBuffer(i.vipsImage, channels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return imageBuffer, nil
+}
+
+// saveToLosslessWebPBuffer returns the image as a lossless WebP byte buffer, encoded with the given near lossless level and effort level
+func (i *resizedImage) saveToLosslessWebPBuffer(level int, effort int) ([]byte, error) {
+	imageBuffer, err := vips.SaveToLosslessWebPBuffer(i.vipsImage, level, effort)
 
 	if err != nil {
 		return nil, err
@@ -61,9 +258,9 @@ func (i *resizedImage) saveToJpegBuffer() ([]byte, error) {
 	return imageBuffer, nil
 }
 
-// saveToWebPBuffer returns the image as a WebP byte buffer
-func (i *resizedImage) saveToWebPBuffer() ([]byte, error) {
-	imageBuffer, err := vips.SaveToWebPBuffer(i.vipsImage)
+// saveToWebPBuffer returns the image as a WebP byte buffer, encoded with the given quality and effort level
+func (i *resizedImage) saveToWebPBuffer(quality int, effort int) ([]byte, error) {
+	imageBuffer, err := vips.SaveToWebPBuffer(i.vipsImage, quality, effort)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,13 @@
+package vips
+
+import (
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// grayscalesFirst returns whether the image of the task is converted to grayscale while resizing it, rather than by the saturation step
+// Blurred grayscale placeholders are the most common kind of low quality image placeholder, and both resizing and blurring them are faster
+// with a single band, so they're converted before resizing when nothing but the blur and sharpening would run before the saturation step
+// Blended images are left to the steps, as the image to blend with would keep its colors
+func (r *Registry) grayscalesFirst(task *image.Task) bool {
+	return r.grayscaleFirst && task.FastPlaceholder && task.ApplyBlur && task.ApplySaturation && task.SaturationAmount == 0 && task.BlendImageID == ""
+}
@@ -0,0 +1,293 @@
+package vips
+
+import (
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/vips"
+)
+
+// Step is a processing step that's applied to an image after it has been resized, and before it's encoded
+// A step takes ownership of the image it's given, and returns the image to continue processing with
+// Steps that don't apply to the task should return the image unchanged
+type Step interface {
+	Process(img vips.Image, task *image.Task) (vips.Image, error)
+}
+
+// StepFunc allows an ordinary function to be used as a Step
+type StepFunc func(img vips.Image, task *image.Task) (vips.Image, error)
+
+// Process calls f(img, task)
+func (f StepFunc) Process(img vips.Image, task *image.Task) (vips.Image, error) {
+	return f(img, task)
+}
+
+// Registry is an ordered list of processing steps, along with the optional upscaler to use instead of resizing
+// Steps have to be registered before the registry is passed to New, as it's not safe to modify while processing images
+type Registry struct {
+	steps           []Step
+	upscaler        Upscaler
+	minUpscaleRatio float64
+	grayscaleFirst  bool
+}
+
+// builtinSteps are the built-in processing steps, by the effect they apply
+var builtinSteps = map[string]Step{
+	image.EffectBlur:         StepFunc(blurStep),
+	image.EffectSharpen:      StepFunc(sharpenStep),
+	image.EffectSaturation:   StepFunc(saturationStep),
+	image.EffectVibrance:     StepFunc(vibranceStep),
+	image.EffectColorize:     StepFunc(colorizeStep),
+	image.EffectSepia:        StepFunc(sepiaStep),
+	image.EffectGamma:        StepFunc(gammaStep),
+	image.EffectBrightness:   StepFunc(brightnessStep),
+	image.EffectContrast:     StepFunc(contrastStep),
+	image.EffectVignette:     StepFunc(vignetteStep),
+	image.EffectThreshold:    StepFunc(thresholdStep),
+	image.EffectPad:          StepFunc(padStep),
+	image.EffectText:         StepFunc(textStep),
+	image.EffectInvertRegion: StepFunc(invertRegionStep),
+	image.EffectColorblind:   StepFunc(colorblindStep),
+}
+
+// NewRegistry returns a registry containing the built-in processing steps, in the canonical order of the effects
+func NewRegistry() *Registry {
+	return NewOrderedRegistry(image.EffectOrder)
+}
+
+// NewOrderedRegistry returns a registry containing the built-in processing steps in the given order
+// The order has to contain each of the effects once, as returned by image.ParseEffectOrder
+func NewOrderedRegistry(order []string) *Registry {
+	steps := make([]Step, 0, len(order))
+	grayscaleFirst, saturated := true, false
+	for _, effect := range order {
+		steps = append(steps, builtinSteps[effect])
+
+		// The blur and sharpening look the same before and after converting to grayscale, but the other effects don't
+		if effect == image.EffectSaturation {
+			saturated = true
+		} else if !saturated && effect != image.EffectBlur && effect != image.EffectSharpen {
+			grayscaleFirst = false
+		}
+	}
+
+	return &Registry{
+		steps:          steps,
+		grayscaleFirst: grayscaleFirst,
+	}
+}
+
+// Register adds a step to the end of the registry
+// Custom steps run after the built-in effects, but before the color space conversion and encoding
+func (r *Registry) Register(step Step) {
+	r.steps = append(r.steps, step)
+}
+
+// process runs the image through each of the steps in order
+func (r *Registry) process(i *resizedImage, task *image.Task) (*resizedImage, error) {
+	img := i.vipsImage
+	for _, step := range r.steps {
+		var err error
+		img, err = step.Process(img, task)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resizedImage{
+		vipsImage: img,
+	}, nil
+}
+
+// blurStep applies gaussian blur to the image, or a region of it, approximated for placeholders
+// Images that are blurred before resizing are left as is, as they're blurred already
+func blurStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyBlur || blursSource(task) {
+		return img, nil
+	}
+
+	if task.ApplyBlurRegion {
+		region := task.BlurArea
+		return vips.BlurRegion(img, task.BlurAmount, task.FastPlaceholder, getExtend(task.BlurEdgeMode), region.Left, region.Top, region.Width, region.Height)
+	}
+
+	return vips.Blur(img, task.BlurAmount, task.FastPlaceholder, getExtend(task.BlurEdgeMode))
+}
+
+// sharpenStep sharpens the image, before the color adjustments so that it's applied to the colors of the resized image
+func sharpenStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplySharpen {
+		return img, nil
+	}
+
+	return vips.Sharpen(img, task.SharpenSigma)
+}
+
+// saturationStep multiplies the saturation of the image
+// A saturation of 0 is the same as grayscale, so it takes the faster path of converting the image to grayscale
+func saturationStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplySaturation {
+		return img, nil
+	}
+
+	if task.SaturationAmount == 0 {
+		return vips.Grayscale(img)
+	}
+
+	return vips.Saturate(img, task.SaturationAmount)
+}
+
+// vibranceStep adjusts the saturation of the muted colors of the image, after the saturation so that they compose
+// Grayscale images have no colors left to adjust, so they're left as is
+func vibranceStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyVibrance || (task.ApplySaturation && task.SaturationAmount == 0) {
+		return img, nil
+	}
+
+	return vips.Vibrance(img, float64(task.VibranceAmount)/100)
+}
+
+// colorizeStep replaces the colors of the image with a single hue, after the other color adjustments as it replaces their result
+func colorizeStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyColorize {
+		return img, nil
+	}
+
+	return vips.Colorize(img, task.ColorizeHue, task.ColorizeChroma)
+}
+
+// sepiaMatrix is the common sepia tone matrix, applied in linear light like the other recombinations
+var sepiaMatrix = [9]float64{
+	0.393, 0.769, 0.189,
+	0.349, 0.686, 0.168,
+	0.272, 0.534, 0.131,
+}
+
+// sepiaStep tones the image sepia, blending the sepia matrix with the identity by the amount so that partial amounts keep some of the original colors
+// It runs after colorize, and a grayscale image is toned the same way, as its bands are equal
+func sepiaStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplySepia {
+		return img, nil
+	}
+
+	return vips.Recomb(img, sepiaBlend(float64(task.SepiaAmount)/100))
+}
+
+// sepiaBlend returns the matrix that's the amount (0-1) of the way from the identity to the sepia matrix
+func sepiaBlend(amount float64) [9]float64 {
+	var matrix [9]float64
+	for i, value := range sepiaMatrix {
+		identity := 0.0
+		if i%4 == 0 {
+			identity = 1
+		}
+
+		matrix[i] = identity + (value-identity)*amount
+	}
+
+	return matrix
+}
+
+// gammaStep applies gamma correction to the image, after the color adjustments and before converting it to black and white
+func gammaStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyGamma {
+		return img, nil
+	}
+
+	return vips.Gamma(img, task.GammaExponent)
+}
+
+// brightnessStep brightens (or darkens) the image, after the gamma correction so that it shifts the corrected tones
+func brightnessStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyBrightness {
+		return img, nil
+	}
+
+	return vips.Brightness(img, float64(task.BrightnessAmount)/100)
+}
+
+// contrastStep stretches (or compresses) the tones of the image around the midpoint, after the brightness so that they compose
+func contrastStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyContrast {
+		return img, nil
+	}
+
+	return vips.Contrast(img, float64(task.ContrastAmount)/100)
+}
+
+// vignetteStep darkens the image toward its edges, after the tonal adjustments so that they don't lift the darkened edges again
+// It runs before the padding, so that it follows the edges of the image rather than the canvas
+func vignetteStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyVignette {
+		return img, nil
+	}
+
+	return vips.Vignette(img, float64(task.VignetteAmount)/100)
+}
+
+// thresholdStep converts the image to black and white, after the color adjustments so that they're taken into account
+func thresholdStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyThreshold {
+		return img, nil
+	}
+
+	return vips.Threshold(img, task.ThresholdLevel, task.DitherThreshold)
+}
+
+// padStep centers the image on a canvas of the requested size
+func padStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyPad {
+		return img, nil
+	}
+
+	if task.TransparentPad {
+		return vips.EmbedAlpha(img, task.CanvasWidth, task.CanvasHeight, task.Background.R, task.Background.G, task.Background.B, task.BackgroundAlpha)
+	}
+
+	return vips.Embed(img, task.CanvasWidth, task.CanvasHeight, task.Background.R, task.Background.G, task.Background.B)
+}
+
+// textStep draws the requested text onto the image
+func textStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyText {
+		return img, nil
+	}
+
+	return vips.DrawText(img, task.Text, task.TextColor.R, task.TextColor.G, task.TextColor.B, getGravity(task.TextGravity))
+}
+
+// invertRegionStep inverts the colors of a region of the image, last so that the region is of the final image
+func invertRegionStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyInvert {
+		return img, nil
+	}
+
+	region := task.InvertArea
+	return vips.InvertRegion(img, region.Left, region.Top, region.Width, region.Height)
+}
+
+// colorblindMatrices are the matrices that simulate the types of color blindness in linear RGB, from Machado, Oliveira and Fernandes (2009) at full severity
+var colorblindMatrices = map[image.ColorblindMode][9]float64{
+	image.Protanopia: {
+		0.152286, 1.052583, -0.204868,
+		0.114503, 0.786281, 0.099216,
+		-0.003882, -0.048116, 1.051998,
+	},
+	image.Deuteranopia: {
+		0.367322, 0.860646, -0.227968,
+		0.280085, 0.672501, 0.047413,
+		-0.011820, 0.042940, 0.968881,
+	},
+	image.Tritanopia: {
+		1.255528, -0.076749, -0.178779,
+		-0.078411, 0.930809, 0.147602,
+		0.004733, 0.691367, 0.303900,
+	},
+}
+
+// colorblindStep simulates color blindness, after the other effects so that it shows how the final image is seen
+func colorblindStep(img vips.Image, task *image.Task) (vips.Image, error) {
+	if !task.ApplyColorblind {
+		return img, nil
+	}
+
+	return vips.Recomb(img, colorblindMatrices[task.ColorblindMode])
+}
@@ -0,0 +1,104 @@
+package vips
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/vips"
+)
+
+// DefaultUpscaleRatio is a ratio to upscale images by before using the upscaler, below which resizing looks about as good
+const DefaultUpscaleRatio = 2
+
+// Upscaler upscales images that are requested larger than their source, such as with a super-resolution model
+// It's given the image resized to the framing of the task at about its native resolution, and returns it upscaled to width x height
+// Images that aren't returned at width x height are resized the rest of the way, so an upscaler can leave part of the upscaling to resizing
+// Like a Step, it takes ownership of the image it's given
+type Upscaler interface {
+	Upscale(img vips.Image, width int, height int) (vips.Image, error)
+}
+
+// NoopUpscaler is an Upscaler that returns the images as is, leaving them to be upscaled by resizing
+type NoopUpscaler struct{}
+
+// Upscale returns the image as is
+func (NoopUpscaler) Upscale(img vips.Image, width int, height int) (vips.Image, error) {
+	return img, nil
+}
+
+// SetUpscaler sets the upscaler to use instead of resizing for images that are upscaled by more than minRatio
+// Like the steps, it has to be set before the registry is passed to New
+func (r *Registry) SetUpscaler(upscaler Upscaler, minRatio float64) {
+	r.upscaler = upscaler
+	r.minUpscaleRatio = minRatio
+}
+
+// upscaleSize returns the size to resize the source image to, and the ratio to upscale it by with the upscaler of the registry after that
+// Images that are upscaled by more than the ratio of the registry are resized at their native resolution
+// Otherwise they're resized to the size of the task, with a ratio of 0 as they're not upscaled after
+func (r *Registry) upscaleSize(buffer []byte, task *image.Task) (width int, height int, ratio float64) {
+	// The blur hides the detail that the upscaler adds, so images that are blurred before resizing aren't upscaled with it
+	if r.upscaler == nil || task.ApplySheet || blursSource(task) {
+		return task.Width, task.Height, 0
+	}
+
+	// Images that can't be read are left to fail when resizing them
+	sourceWidth, sourceHeight, err := vips.ImageSize(buffer)
+	if err != nil {
+		return task.Width, task.Height, 0
+	}
+
+	ratio = upscaleRatio(sourceWidth, sourceHeight, task)
+	if ratio <= r.minUpscaleRatio {
+		return task.Width, task.Height, 0
+	}
+
+	return scaleDimension(task.Width, 1/ratio), scaleDimension(task.Height, 1/ratio), ratio
+}
+
+// upscale upscales the image by the ratio with the upscaler of the registry, and resizes it the rest of the way
+// Contained images are smaller than the task on one side, so the size to upscale to follows the resized image, up to the size of the task
+func (r *Registry) upscale(i *resizedImage, task *image.Task, ratio float64) (*resizedImage, error) {
+	nativeWidth, nativeHeight := vips.ImageDimensions(i.vipsImage)
+	width := int(math.Min(float64(task.Width), float64(scaleDimension(nativeWidth, ratio))))
+	height := int(math.Min(float64(task.Height), float64(scaleDimension(nativeHeight, ratio))))
+
+	img, err := r.upscaler.Upscale(i.vipsImage, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("error upscaling image: %w", err)
+	}
+
+	if upscaledWidth, upscaledHeight := vips.ImageDimensions(img); upscaledWidth != width || upscaledHeight != height {
+		img, err = vips.Scale(img, width, height, getKernel(task.ResizeFilter))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resizedImage{
+		vipsImage: img,
+	}, nil
+}
+
+// upscaleRatio returns how much a source image of width x height is upscaled by to the size of the task
+// It's estimated from the source, or the crop of it, as trimming and the orientation are only known once the image is loaded
+// Contained images are scaled by the smaller of the ratios of the sides, and covered or filled images by the larger one
+func upscaleRatio(width int, height int, task *image.Task) float64 {
+	if task.ApplyCrop {
+		width, height = task.CropArea.Width, task.CropArea.Height
+	}
+
+	horizontal := float64(task.Width) / float64(width)
+	vertical := float64(task.Height) / float64(height)
+	if task.FitMode == image.Contain {
+		return math.Min(horizontal, vertical)
+	}
+
+	return math.Max(horizontal, vertical)
+}
+
+// scaleDimension scales a width or height by the ratio, to at least a pixel
+func scaleDimension(dimension int, ratio float64) int {
+	return int(math.Max(1, math.Round(float64(dimension)*ratio)))
+}
@@ -17,20 +17,52 @@ type Processor struct {
 }
 
 // New initializes a new processor instance
-func New(ctx context.Context, log *logger.Logger, cache *image.Cache) (*Processor, error) {
+// Source images with more than maxSourcePixels pixels are rejected before being decoded, 0 disables the limit
+// Up to workers images are processed concurrently, 0 uses one worker per CPU
+// Each image is processed and encoded with up to threads threads, 0 uses a single thread, so that workers * threads shouldn't exceed the CPUs
+// The threads are set for libvips as a whole, so they're shared with other processors
+// The images are run through the steps in the registry after being resized, nil uses the built-in steps
+// Source images in formats other than sourceFormats are rejected before being decoded, nil allows image.DefaultSourceFormats
+func New(ctx context.Context, log *logger.Logger, cache *image.Cache, maxSourcePixels int, workers int, threads int, steps *Registry, sourceFormats []image.SourceFormat) (*Processor, error) {
 	err := vips.Initialize(log)
 	if err != nil {
 		return nil, err
 	}
 
-	workers := getWorkerCount()
-	workerQueue := queue.New(ctx, workers, taskProcessor(cache))
+	if workers <= 0 {
+		workers = getWorkerCount()
+	}
+
+	if threads <= 0 {
+		threads = 1
+	}
+	vips.SetConcurrency(threads)
+
+	if steps == nil {
+		steps = NewRegistry()
+	}
+
+	if sourceFormats == nil {
+		sourceFormats = image.DefaultSourceFormats
+	}
+
+	if err := checkSourceFormats(sourceFormats); err != nil {
+		return nil, err
+	}
+
+	sources := &sourceLoader{
+		cache:           cache,
+		maxSourcePixels: maxSourcePixels,
+		formats:         sourceFormats,
+	}
+
+	workerQueue := queue.New(ctx, workers, taskProcessor(sources, steps))
 	instance := &Processor{
 		queue: workerQueue,
 	}
 
 	go workerQueue.Run()
-	log.Infof("starting vips worker queue with %d workers", workers)
+	log.Infof("starting vips worker queue with %d workers, using %d threads each", workers, threads)
 
 	return instance, err
 }
@@ -56,32 +88,97 @@ func (p *Processor) ProcessImage(ctx context.Context, task *image.Task) (process
 	return image, nil
 }
 
-func taskProcessor(cache *image.Cache) func(ctx context.Context, data interface{}) (interface{}, error) {
+func taskProcessor(sources *sourceLoader, steps *Registry) func(ctx context.Context, data interface{}) (interface{}, error) {
 	return func(ctx context.Context, data interface{}) (interface{}, error) {
 		task, ok := data.(*image.Task)
 		if !ok {
 			return nil, fmt.Errorf("invalid data")
 		}
 
-		imageBuffer, err := cache.Get(ctx, task.ImageID)
+		imageBuffer, err := sources.load(ctx, task.ImageID)
 		if err != nil {
-			return nil, fmt.Errorf("error getting image from cache: %s", err)
+			return nil, err
+		}
+
+		// Frame 0 always exists, so only read the frame count when another frame is requested
+		if task.SourceFrame > 0 {
+			frames, err := vips.ImageFrames(imageBuffer)
+			if err != nil {
+				return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, task.ImageID, err)
+			}
+
+			if task.SourceFrame >= frames {
+				return nil, fmt.Errorf("%w: image %s: frame %d of %d", image.ErrFrameOutOfRange, task.ImageID, task.SourceFrame, frames)
+			}
 		}
 
-		processedImage, err := resizeImage(imageBuffer, task.Width, task.Height)
+		// Loading the image from the buffer is where corrupt or unsupported source images fail
+		var processedImage *resizedImage
+		// Images that are upscaled by enough to use the upscaler are resized at their native resolution, and upscaled once loaded
+		width, height, ratio := steps.upscaleSize(imageBuffer, task)
+		// Images that are blurred before resizing are resized at their native resolution as well, and scaled down once blurred
+		var blurRatio float64
+		if blursSource(task) {
+			width, height, blurRatio = blurSourceSize(imageBuffer, task)
+		}
+		if task.ApplySheet {
+			processedImage, err = contactSheet(imageBuffer, task)
+		} else {
+			options := getResizeOptions(task)
+			options.Grayscale = steps.grayscalesFirst(task)
+			processedImage, err = resizeImage(imageBuffer, width, height, options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, task.ImageID, err)
+		}
+
+		if blurRatio > 0 {
+			processedImage, err = blurSource(processedImage, task, blurRatio)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if ratio > 0 {
+			processedImage, err = steps.upscale(processedImage, task, ratio)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Blend before the steps, so that effects such as blur apply to the combined image
+		if task.BlendImageID != "" {
+			processedImage, err = blendImage(ctx, sources, processedImage, task)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		processedImage, err = steps.process(processedImage, task)
 		if err != nil {
 			return nil, err
 		}
 
-		if task.ApplyBlur {
-			processedImage, err = processedImage.blur(task.BlurAmount)
+		// Overlay after the steps, so that the effects don't apply to the overlay
+		if task.OverlayImageID != "" {
+			processedImage, err = overlayImage(ctx, sources, processedImage, task)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		if task.ApplyGrayscale {
-			processedImage, err = processedImage.grayscale()
+		// Mask after the steps, so that the mask applies to the final size and shape of the image
+		if task.MaskImageID != "" {
+			processedImage, err = maskImage(ctx, sources, processedImage, task)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Flatten after the mask, so that the areas it makes transparent are filled in with the background too
+		// JPEG and most raw pixel formats have no alpha channel, so transparent images are always flattened, rather than onto the black of the encoder
+		if (task.ApplyFlatten || !outputsAlpha(task)) && vips.HasAlpha(processedImage.vipsImage) {
+			processedImage, err = processedImage.flatten(task.Background)
 			if err != nil {
 				return nil, err
 			}
@@ -89,12 +186,51 @@ func taskProcessor(cache *image.Cache) func(ctx context.Context, data interface{
 
 		processedImage.setUserComment(task.UserComment)
 
+		// The images are in sRGB already, so only convert them if another profile is requested or it should be embedded
+		if task.ColorSpace != image.SRGB || task.EmbedICCProfile {
+			processedImage, err = processedImage.iccTransform(task.ColorSpace, task.EmbedICCProfile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Extract last, so that the channel is read from the final image, in the output color space
+		if task.ApplyExtract {
+			processedImage, err = extractChannel(processedImage, task)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Sources like GIFs are loaded with an alpha channel even when they're opaque, which only adds to the size of a WebP
+		// It's checked after everything else, so that the alpha channel is kept when the effects make any pixel transparent
+		if task.OutputFormat == image.WebP && vips.HasAlpha(processedImage.vipsImage) {
+			processedImage, err = processedImage.removeOpaqueAlpha()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Set the resolution right before encoding, so that it's the density written to the header of the image
+		if task.OutputDPI > 0 && task.OutputFormat == image.JPEG {
+			processedImage, err = processedImage.setResolution(task.OutputDPI)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		var buffer []byte
 		switch task.OutputFormat {
 		case image.JPEG:
-			buffer, err = processedImage.saveToJpegBuffer()
+			buffer, err = processedImage.saveToJpegBuffer(task.EncodeQuality, task.OptimizeHuffman, task.ProgressiveJPEG)
 		case image.WebP:
-			buffer, err = processedImage.saveToWebPBuffer()
+			if task.EncodeLossless {
+				buffer, err = processedImage.saveToLosslessWebPBuffer(task.LosslessLevel, task.EncodeEffort)
+			} else {
+				buffer, err = processedImage.saveToWebPBuffer(task.EncodeQuality, task.EncodeEffort)
+			}
+		case image.Raw:
+			buffer, err = processedImage.saveToRawBuffer(task.RawPixelFormat.Channels())
 		}
 
 		if err != nil {
@@ -105,6 +241,219 @@ func taskProcessor(cache *image.Cache) func(ctx context.Context, data interface{
 	}
 }
 
+// outputsAlpha returns whether the output format of the task has an alpha channel
+func outputsAlpha(task *image.Task) bool {
+	switch task.OutputFormat {
+	case image.WebP:
+		return true
+	case image.Raw:
+		return task.RawPixelFormat == image.RGBA8
+	default:
+		return false
+	}
+}
+
+// sourceLoaders are the libvips loaders that decode each of the source formats
+var sourceLoaders = map[image.SourceFormat]string{
+	image.SourceJPEG: "jpegload_buffer",
+	image.SourcePNG:  "pngload_buffer",
+	image.SourceWebP: "webpload_buffer",
+	image.SourceGIF:  "gifload_buffer",
+	image.SourceTIFF: "tiffload_buffer",
+	image.SourceHEIF: "heifload_buffer",
+	image.SourceSVG:  "svgload_buffer",
+}
+
+// checkSourceFormats returns an error for the first of the formats that libvips can't decode
+// The loaders of formats such as HEIF depend on optional libraries, so allowing them without the library would fail every request for those images
+func checkSourceFormats(formats []image.SourceFormat) error {
+	for _, format := range formats {
+		if loader, ok := sourceLoaders[format]; ok && !vips.HasLoader(loader) {
+			return fmt.Errorf("libvips can't decode %s source images, as it's built without %s", format, loader)
+		}
+	}
+
+	return nil
+}
+
+// sourceLoader gets source images from the cache, and rejects the ones that shouldn't be decoded
+type sourceLoader struct {
+	cache           *image.Cache
+	maxSourcePixels int
+	formats         []image.SourceFormat
+}
+
+// load gets a source image from the cache
+// The format is sniffed from the magic bytes, and the size is checked from the image header, before decoding it
+// This avoids running out of memory on huge images, and exposing the decoders of formats that aren't needed
+func (s *sourceLoader) load(ctx context.Context, imageID string) ([]byte, error) {
+	imageBuffer, err := s.cache.Get(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting image from cache: %s", err)
+	}
+
+	format := image.SniffSourceFormat(imageBuffer)
+	if format == image.SourceUnknown {
+		return nil, fmt.Errorf("%w: image %s: unknown format", image.ErrUnsupportedSourceFormat, imageID)
+	}
+
+	if !image.AllowsSourceFormat(s.formats, format) {
+		return nil, fmt.Errorf("%w: image %s: %s", image.ErrSourceFormatNotAllowed, imageID, format)
+	}
+
+	if s.maxSourcePixels > 0 {
+		width, height, err := vips.ImageSize(imageBuffer)
+		if err != nil {
+			return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, imageID, err)
+		}
+
+		if width*height > s.maxSourcePixels {
+			return nil, fmt.Errorf("%w: image %s: %dx%d", image.ErrSourceTooLarge, imageID, width, height)
+		}
+	}
+
+	return imageBuffer, nil
+}
+
+// contactSheet resizes evenly spaced frames of the source image to the size of a cell, and joins them in a grid
+// Static source images only have a single frame, which makes for a contact sheet of one cell
+func contactSheet(buffer []byte, task *image.Task) (*resizedImage, error) {
+	frames, err := vips.ImageFrames(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	count := task.SheetFrames
+	if frames < count {
+		count = frames
+	}
+
+	options := getResizeOptions(task)
+	cells := make([]vips.Image, 0, count)
+	for i := 0; i < count; i++ {
+		options.Frame = i * frames / count
+
+		cell, err := vips.ResizeImage(buffer, task.Width, task.Height, options)
+		if err != nil {
+			for _, cell := range cells {
+				vips.UnrefImage(cell)
+			}
+			return nil, err
+		}
+
+		cells = append(cells, cell)
+	}
+
+	columns := task.SheetColumns
+	if count < columns {
+		columns = count
+	}
+
+	sheet, err := vips.JoinImages(cells, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: sheet,
+	}, nil
+}
+
+// blendImage resizes the image to blend to the size of the task, and blends it on top of the resized image
+func blendImage(ctx context.Context, sources *sourceLoader, i *resizedImage, task *image.Task) (*resizedImage, error) {
+	overlayBuffer, err := sources.load(ctx, task.BlendImageID)
+	if err != nil {
+		vips.UnrefImage(i.vipsImage)
+		return nil, err
+	}
+
+	overlay, err := resizeImage(overlayBuffer, task.Width, task.Height, vips.ResizeOptions{Kernel: getKernel(task.ResizeFilter)})
+	if err != nil {
+		vips.UnrefImage(i.vipsImage)
+		return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, task.BlendImageID, err)
+	}
+
+	blended, err := vips.Blend(i.vipsImage, overlay.vipsImage, getBlendMode(task.BlendMode), task.BlendOpacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: blended,
+	}, nil
+}
+
+// overlayImage fits the image to overlay within a percentage of the size of the processed image, and places it on top
+func overlayImage(ctx context.Context, sources *sourceLoader, i *resizedImage, task *image.Task) (*resizedImage, error) {
+	overlayBuffer, err := sources.load(ctx, task.OverlayImageID)
+	if err != nil {
+		vips.UnrefImage(i.vipsImage)
+		return nil, err
+	}
+
+	// The processed image can be larger than the task when padded, so size the overlay from the image itself
+	width, height := vips.ImageDimensions(i.vipsImage)
+	width = width * task.OverlayPercent / 100
+	if width < 1 {
+		width = 1
+	}
+
+	height = height * task.OverlayPercent / 100
+	if height < 1 {
+		height = 1
+	}
+
+	overlay, err := resizeImage(overlayBuffer, width, height, vips.ResizeOptions{Kernel: getKernel(task.ResizeFilter), Fit: vips.FitContain})
+	if err != nil {
+		vips.UnrefImage(i.vipsImage)
+		return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, task.OverlayImageID, err)
+	}
+
+	overlaid, err := vips.Overlay(i.vipsImage, overlay.vipsImage, getGravity(task.OverlayGravity), task.OverlayOpacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: overlaid,
+	}, nil
+}
+
+// maskImage uses the mask image of the task as the alpha channel of the processed image
+func maskImage(ctx context.Context, sources *sourceLoader, i *resizedImage, task *image.Task) (*resizedImage, error) {
+	maskBuffer, err := sources.load(ctx, task.MaskImageID)
+	if err != nil {
+		vips.UnrefImage(i.vipsImage)
+		return nil, err
+	}
+
+	masked, err := vips.Mask(i.vipsImage, maskBuffer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: image %s: %s", image.ErrUnsupportedSourceFormat, task.MaskImageID, err)
+	}
+
+	return &resizedImage{
+		vipsImage: masked,
+	}, nil
+}
+
+// extractChannel replaces the processed image with the channel of the task, as a grayscale image
+func extractChannel(i *resizedImage, task *image.Task) (*resizedImage, error) {
+	if task.ExtractChannel == image.AlphaChannel && task.RequireAlpha && !vips.HasAlpha(i.vipsImage) {
+		vips.UnrefImage(i.vipsImage)
+		return nil, fmt.Errorf("%w: image %s", image.ErrNoAlphaChannel, task.ImageID)
+	}
+
+	extracted, err := vips.ExtractChannel(i.vipsImage, getChannel(task.ExtractChannel))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resizedImage{
+		vipsImage: extracted,
+	}, nil
+}
+
 // Shutdown shuts down the image processor and deinitialises vips
 func (p *Processor) Shutdown() {
 	vips.Shutdown()
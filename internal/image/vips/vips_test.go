@@ -1,16 +1,24 @@
 package vips_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
 	"io/ioutil"
+	"math"
 	"reflect"
 	"runtime"
+	"strings"
 
 	"github.com/DMarby/picsum-photos/internal/image"
 	"github.com/DMarby/picsum-photos/internal/image/vips"
 	"github.com/DMarby/picsum-photos/internal/logger"
 	"github.com/DMarby/picsum-photos/internal/storage/file"
+	libvips "github.com/DMarby/picsum-photos/internal/vips"
 	"github.com/DMarby/picsum-photos/internal/cache/memory"
 	"go.uber.org/zap"
 
@@ -30,7 +38,8 @@ func setup() (context.CancelFunc, *vips.Processor, []byte, error) {
 
 	cache := image.NewCache(memory.New(), storage)
 
-	processor, err := vips.New(ctx, log, cache)
+	// GIF is allowed, so that the frames of animated.jpg can be tested
+	processor, err := vips.New(ctx, log, cache, 100000000, 0, 0, nil, []image.SourceFormat{image.SourceJPEG, image.SourcePNG, image.SourceGIF})
 	if err != nil {
 		cancel()
 		return nil, nil, nil, err
@@ -74,6 +83,70 @@ func TestVips(t *testing.T) {
 			}
 		})
 
+		t.Run("process image returns an unsupported source format error for corrupt images", func(t *testing.T) {
+			for _, id := range []string{"corrupt", "truncated"} {
+				_, err := processor.ProcessImage(context.Background(), image.NewTask(id, 500, 500, "testing", image.JPEG))
+				if !errors.Is(err, image.ErrUnsupportedSourceFormat) {
+					t.Errorf("%s: wrong error %#v", id, err)
+					continue
+				}
+
+				if !strings.Contains(err.Error(), fmt.Sprintf("image %s", id)) {
+					t.Errorf("%s: error is missing the image id %s", id, err)
+				}
+			}
+		})
+
+		t.Run("process image rejects source formats that aren't allowed", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			defaultProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 1.jpg is a JPEG and quadrants.jpg is a PNG, which are allowed by default
+			for _, id := range []string{"1", "quadrants"} {
+				if _, err := defaultProcessor.ProcessImage(context.Background(), image.NewTask(id, 100, 100, "testing", image.JPEG)); err != nil {
+					t.Errorf("%s: %s", id, err)
+				}
+			}
+
+			// animated.jpg is a GIF, which isn't
+			_, err = defaultProcessor.ProcessImage(context.Background(), image.NewTask("animated", 100, 100, "testing", image.JPEG))
+			if !errors.Is(err, image.ErrSourceFormatNotAllowed) {
+				t.Errorf("wrong error %#v", err)
+			}
+
+			// It's also checked for the images to blend with
+			_, err = defaultProcessor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG).Blend("animated", image.Normal, 1))
+			if !errors.Is(err, image.ErrSourceFormatNotAllowed) {
+				t.Errorf("wrong error for blend %#v", err)
+			}
+		})
+
+		t.Run("allows heif sources when libvips can decode them", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// libvips decodes HEIC and AVIF images when it's built with libheif
+			storage, _ := file.New("../../../test/fixtures/file")
+			_, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, nil, []image.SourceFormat{image.SourceJPEG, image.SourceHEIF})
+			if supported := libvips.HasLoader("heifload_buffer"); supported != (err == nil) {
+				t.Errorf("wrong error %v with heif support %t", err, supported)
+			}
+		})
+
+		t.Run("process image rejects source images that are too large", func(t *testing.T) {
+			// huge.jpg is a PNG header that claims to be 20000x20000
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("huge", 500, 500, "testing", image.JPEG))
+			if !errors.Is(err, image.ErrSourceTooLarge) {
+				t.Errorf("wrong error %#v", err)
+			}
+		})
+
 		t.Run("full test jpeg", func(t *testing.T) {
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../../test/fixtures/image/complete_result_%s.jpg", runtime.GOOS))
 			testResult := fullTest(processor, buf, image.JPEG)
@@ -82,11 +155,1397 @@ func TestVips(t *testing.T) {
 			}
 		})
 
-		t.Run("full test webp", func(t *testing.T) {
-			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../../test/fixtures/image/complete_result_%s.webp", runtime.GOOS))
-			testResult := fullTest(processor, buf, image.WebP)
-			if !reflect.DeepEqual(testResult, resultFixture) {
-				t.Error("image data doesn't match")
+		t.Run("full test webp", func(t *testing.T) {
+			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../../test/fixtures/image/complete_result_%s.webp", runtime.GOOS))
+			testResult := fullTest(processor, buf, image.WebP)
+			if !reflect.DeepEqual(testResult, resultFixture) {
+				t.Error("image data doesn't match")
+			}
+		})
+
+		t.Run("selects the frame of an animated image", func(t *testing.T) {
+			// animated.jpg is a GIF with a red, a green, and a blue frame
+			tests := []struct {
+				Frame    int
+				Expected func(r, g, b uint32) bool
+			}{
+				{0, func(r, g, b uint32) bool { return r > 0xc000 && g < 0x4000 && b < 0x4000 }},
+				{2, func(r, g, b uint32) bool { return b > 0xc000 && r < 0x4000 && g < 0x4000 }},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("animated", 100, 100, "testing", image.JPEG).Frame(test.Frame))
+				if err != nil {
+					t.Errorf("frame %d: %s", test.Frame, err)
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Errorf("frame %d: %s", test.Frame, err)
+					continue
+				}
+
+				if r, g, b, _ := decoded.At(50, 50).RGBA(); !test.Expected(r, g, b) {
+					t.Errorf("frame %d: wrong color %d %d %d", test.Frame, r, g, b)
+				}
+			}
+
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("animated", 100, 100, "testing", image.JPEG).Frame(3))
+			if !errors.Is(err, image.ErrFrameOutOfRange) {
+				t.Errorf("wrong error for out of range frame %#v", err)
+			}
+		})
+
+		t.Run("lays out the frames in a contact sheet", func(t *testing.T) {
+			// animated.jpg has three frames, so the last cell of the second row is left black
+			decoded := decodeJPEG(t, processor, image.NewTask("animated", 30, 40, "testing", image.JPEG).ContactSheet(2, 12))
+			if bounds := decoded.Bounds(); bounds.Dx() != 60 || bounds.Dy() != 80 {
+				t.Fatalf("wrong size %dx%d", bounds.Dx(), bounds.Dy())
+			}
+
+			expected := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {0, 0, 0, 255}}
+			for i, cell := range expected {
+				if c := decoded.At(i%2*30+15, i/2*40+20); !closeColor(c, cell) {
+					t.Errorf("cell %d: wrong color %#v", i, c)
+				}
+			}
+
+			// Static images have a single frame, so the sheet is a single cell
+			decoded = decodeJPEG(t, processor, image.NewTask("1", 30, 40, "testing", image.JPEG).ContactSheet(4, 12))
+			if bounds := decoded.Bounds(); bounds.Dx() != 30 || bounds.Dy() != 40 {
+				t.Errorf("wrong size of a static image %dx%d", bounds.Dx(), bounds.Dy())
+			}
+		})
+
+		t.Run("trims borders", func(t *testing.T) {
+			// bordered.jpg is a red PNG with a 50px white border
+			white := image.Color{R: 255, G: 255, B: 255}
+			black := image.Color{}
+
+			tests := []struct {
+				Name          string
+				Task          *image.Task
+				ExpectTrimmed bool
+			}{
+				{"untrimmed", image.NewTask("bordered", 100, 100, "testing", image.JPEG), false},
+				{"trim using the corner color", image.NewTask("bordered", 100, 100, "testing", image.JPEG).Trim(10), true},
+				{"trim using the border color", image.NewTask("bordered", 100, 100, "testing", image.JPEG).TrimColor(white, 10), true},
+				{"trim using the border color with another filter", image.NewTask("bordered", 100, 100, "testing", image.JPEG).TrimColor(white, 10).Filter(image.Cubic), true},
+				{"trim using a mismatched color", image.NewTask("bordered", 100, 100, "testing", image.JPEG).TrimColor(black, 10), false},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), test.Task)
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				// The corner is red when the border has been trimmed off, and white otherwise
+				_, g, _, _ := decoded.At(2, 2).RGBA()
+				if trimmed := g < 0x4000; trimmed != test.ExpectTrimmed {
+					t.Errorf("%s: expected trimmed to be %t", test.Name, test.ExpectTrimmed)
+				}
+			}
+		})
+
+		t.Run("draws text", func(t *testing.T) {
+			white := image.Color{R: 255, G: 255, B: 255}
+			plain, err := processor.ProcessImage(context.Background(), image.NewTask("1", 300, 200, "testing", image.JPEG))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			centered, err := processor.ProcessImage(context.Background(), image.NewTask("1", 300, 200, "testing", image.JPEG).DrawText("300x200", white, image.Center))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Text containing pango markup is drawn as is
+			markup, err := processor.ProcessImage(context.Background(), image.NewTask("1", 300, 200, "testing", image.JPEG).DrawText("<b>&", white, image.SouthEast))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Equal(plain, centered) || bytes.Equal(centered, markup) {
+				t.Error("text wasn't drawn")
+			}
+
+			config, err := jpeg.DecodeConfig(bytes.NewReader(centered))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Width != 300 || config.Height != 200 {
+				t.Errorf("wrong size %dx%d", config.Width, config.Height)
+			}
+		})
+
+		t.Run("fits the text within the image", func(t *testing.T) {
+			// gray.jpg is a solid gray of 128, so the white text is what's brighter than it
+			white := image.Color{R: 255, G: 255, B: 255}
+			tests := []struct {
+				Name   string
+				Width  int
+				Height int
+				Text   string
+			}{
+				{"small", 60, 40, "60x40"},
+				{"large", 1600, 1200, "1600x1200"},
+				{"wide", 1000, 100, "1000x100"},
+				{"tall", 100, 1000, "100x1000"},
+				{"wrapped text that's too tall at the proportional size", 200, 60, "the quick brown fox jumps over the lazy dog"},
+				{"a word that's too wide at the proportional size", 200, 200, "supercalifragilisticexpialidocious"},
+			}
+
+			for _, test := range tests {
+				img := decodeJPEG(t, processor, image.NewTask("gray", test.Width, test.Height, "testing", image.JPEG).DrawText(test.Text, white, image.Center))
+				bounds := brightBounds(img)
+				if bounds.Empty() {
+					t.Errorf("%s: text wasn't drawn", test.Name)
+					continue
+				}
+
+				// The text is kept within the margins, allowing for a couple of pixels of ringing around it from the JPEG compression
+				margin := test.Width
+				if test.Height < margin {
+					margin = test.Height
+				}
+				margin = margin/20 - 2
+				if margin < 0 {
+					margin = 0
+				}
+
+				inside := goimage.Rect(margin, margin, test.Width-margin, test.Height-margin)
+				if !bounds.In(inside) {
+					t.Errorf("%s: text at %v outside of %v", test.Name, bounds, inside)
+				}
+
+				// And it's centered
+				centerX, centerY := (bounds.Min.X+bounds.Max.X)/2, (bounds.Min.Y+bounds.Max.Y)/2
+				if abs(centerX-test.Width/2) > test.Width/10 || abs(centerY-test.Height/2) > test.Height/10 {
+					t.Errorf("%s: text at %v isn't centered", test.Name, bounds)
+				}
+			}
+
+			// Images too small for the margins still get the text drawn, clipped to the image
+			if _, err := processor.ProcessImage(context.Background(), image.NewTask("gray", 1, 1, "testing", image.JPEG).DrawText("1x1", white, image.Center)); err != nil {
+				t.Error(err)
+			}
+		})
+
+		t.Run("overrides the orientation", func(t *testing.T) {
+			// quadrants.jpg is a 200x100 PNG with red, green, blue and white quadrants, from the top left to the bottom right
+			tests := []struct {
+				Orientation int
+				Expected    [4]string // Top left, top right, bottom left, bottom right
+			}{
+				{1, [4]string{"red", "green", "blue", "white"}},
+				{2, [4]string{"green", "red", "white", "blue"}},
+				{3, [4]string{"white", "blue", "green", "red"}},
+				{4, [4]string{"blue", "white", "red", "green"}},
+				{5, [4]string{"red", "blue", "green", "white"}},
+				{6, [4]string{"blue", "red", "white", "green"}},
+				{7, [4]string{"white", "green", "blue", "red"}},
+				{8, [4]string{"green", "white", "red", "blue"}},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.JPEG).Orient(test.Orientation))
+				if err != nil {
+					t.Errorf("orientation %d: %s", test.Orientation, err)
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Errorf("orientation %d: %s", test.Orientation, err)
+					continue
+				}
+
+				corners := [4]string{colorName(decoded.At(5, 5)), colorName(decoded.At(45, 5)), colorName(decoded.At(5, 45)), colorName(decoded.At(45, 45))}
+				if corners != test.Expected {
+					t.Errorf("orientation %d: wrong corners %v", test.Orientation, corners)
+				}
+			}
+		})
+
+		t.Run("rotates by the orientation of the image", func(t *testing.T) {
+			// rotated.jpg is stored with a red left half and a blue right half, and has an EXIF orientation of 6
+			tests := []struct {
+				Name     string
+				Task     *image.Task
+				Expected [4]string // Top left, top right, bottom left, bottom right
+			}{
+				{"rotated", image.NewTask("rotated", 40, 40, "testing", image.JPEG).Fit(image.Fill), [4]string{"red", "red", "blue", "blue"}},
+				{"as stored", image.NewTask("rotated", 40, 40, "testing", image.JPEG).Fit(image.Fill).Orient(1), [4]string{"red", "blue", "red", "blue"}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, test.Task)
+				corners := [4]string{colorName(decoded.At(5, 5)), colorName(decoded.At(35, 5)), colorName(decoded.At(5, 35)), colorName(decoded.At(35, 35))}
+				if corners != test.Expected {
+					t.Errorf("%s: wrong corners %v", test.Name, corners)
+				}
+			}
+		})
+
+		t.Run("blends images", func(t *testing.T) {
+			// gray.jpg is a solid (128, 128, 128) PNG, blended on top of the red top left quadrant of quadrants.jpg
+			tests := []struct {
+				Name     string
+				Mode     image.BlendMode
+				Opacity  float64
+				Expected color.RGBA
+			}{
+				{"normal", image.Normal, 1, color.RGBA{128, 128, 128, 255}},
+				{"normal with half opacity", image.Normal, 0.5, color.RGBA{191, 64, 64, 255}},
+				{"multiply", image.Multiply, 1, color.RGBA{128, 0, 0, 255}},
+				{"screen", image.Screen, 1, color.RGBA{255, 128, 128, 255}},
+				{"overlay", image.Overlay, 1, color.RGBA{255, 0, 0, 255}},
+				{"no opacity", image.Multiply, 0, color.RGBA{255, 0, 0, 255}},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.JPEG).Blend("gray", test.Mode, test.Opacity))
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				if c := decoded.At(5, 5); !closeColor(c, test.Expected) {
+					t.Errorf("%s: wrong color %v", test.Name, c)
+				}
+			}
+		})
+
+		t.Run("fails blending with a missing image", func(t *testing.T) {
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.JPEG).Blend("missing", image.Normal, 1))
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+
+		t.Run("overlays images", func(t *testing.T) {
+			// gray.jpg is a solid (128, 128, 128) 200x100 PNG, fit within half of quadrants.jpg, so that it's 100x50 with a 5px margin
+			tests := []struct {
+				Name     string
+				Gravity  image.Gravity
+				Opacity  float64
+				Expected map[goimage.Point]color.RGBA
+			}{
+				{"northwest", image.NorthWest, 1, map[goimage.Point]color.RGBA{
+					{20, 20}: {128, 128, 128, 255}, {180, 80}: {255, 255, 255, 255}, {2, 2}: {255, 0, 0, 255},
+				}},
+				{"northwest with opacity", image.NorthWest, 0.5, map[goimage.Point]color.RGBA{
+					{20, 20}: {191, 64, 64, 255}, {180, 80}: {255, 255, 255, 255},
+				}},
+				{"southeast", image.SouthEast, 1, map[goimage.Point]color.RGBA{
+					{180, 80}: {128, 128, 128, 255}, {20, 20}: {255, 0, 0, 255}, {197, 97}: {255, 255, 255, 255},
+				}},
+				{"southeast with opacity", image.SouthEast, 0.5, map[goimage.Point]color.RGBA{
+					{180, 80}: {191, 191, 191, 255}, {20, 20}: {255, 0, 0, 255},
+				}},
+				{"no opacity", image.SouthEast, 0, map[goimage.Point]color.RGBA{
+					{180, 80}: {255, 255, 255, 255},
+				}},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Overlay("gray", test.Gravity, test.Opacity, 50))
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Errorf("%s: %s", test.Name, err)
+					continue
+				}
+
+				for point, expected := range test.Expected {
+					if c := decoded.At(point.X, point.Y); !closeColor(c, expected) {
+						t.Errorf("%s: wrong color %v at %v", test.Name, c, point)
+					}
+				}
+			}
+		})
+
+		t.Run("fails overlaying a missing image", func(t *testing.T) {
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.JPEG).Overlay("missing", image.SouthEast, 1, 20))
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+
+		t.Run("adjusts the saturation and vibrance", func(t *testing.T) {
+			// muted.jpg is a PNG with muted blue, skin tone and saturated blue vertical stripes, from left to right
+			stripes := func(task *image.Task) [3]color.Color {
+				buf, err := processor.ProcessImage(context.Background(), task)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return [3]color.Color{decoded.At(50, 50), decoded.At(150, 50), decoded.At(250, 50)}
+			}
+
+			original := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG))
+			saturated := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG).Saturate(1.5))
+			desaturated := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG).Saturate(0))
+			vibrant := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG).Vibrance(100))
+			muted := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG).Vibrance(-100))
+			both := stripes(image.NewTask("muted", 300, 100, "testing", image.JPEG).Saturate(1.5).Vibrance(100))
+
+			if spread(saturated[0]) <= spread(original[0]) || spread(saturated[1]) <= spread(original[1]) {
+				t.Errorf("saturation didn't boost all the colors, %v", saturated)
+			}
+
+			if spread(desaturated[0]) > 4 || spread(desaturated[2]) > 4 {
+				t.Errorf("saturation 0 didn't remove the colors, %v", desaturated)
+			}
+
+			// Vibrance boosts the muted color more than the skin tone, and leaves the saturated color as is
+			if spread(vibrant[0])-spread(original[0]) <= spread(vibrant[1])-spread(original[1]) {
+				t.Errorf("vibrance boosted the skin tone more than the muted color, %v", vibrant)
+			}
+
+			if !closeColor(vibrant[2], color.RGBA{0, 0, 255, 255}) {
+				t.Errorf("vibrance changed the saturated color, %v", vibrant[2])
+			}
+
+			if spread(muted[0]) >= spread(original[0])/2 || colorName(muted[2]) != "blue" {
+				t.Errorf("negative vibrance didn't mute only the muted color, %v", muted)
+			}
+
+			// Vibrance is applied on top of the saturation
+			if spread(both[0]) <= spread(saturated[0]) {
+				t.Errorf("vibrance didn't compose with saturation, %v", both)
+			}
+		})
+
+		t.Run("colorizes the image", func(t *testing.T) {
+			// muted.jpg is a PNG with muted blue, skin tone and saturated blue vertical stripes, from left to right
+			// Hue 40 with chroma 100 in LCh is close to pure red
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("muted", 300, 100, "testing", image.JPEG).Colorize(40, 100))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lightness []int
+			for i, x := range []int{50, 150, 250} {
+				r, g, b, _ := decoded.At(x, 50).RGBA()
+				if r <= g || r <= b {
+					t.Errorf("stripe %d isn't red, %v", i, decoded.At(x, 50))
+				}
+
+				lightness = append(lightness, int(color.GrayModel.Convert(decoded.At(x, 50)).(color.Gray).Y))
+			}
+
+			// The lightness of the stripes keeps the same order, from the skin tone to the saturated blue
+			if !(lightness[1] > lightness[0] && lightness[0] > lightness[2]) {
+				t.Errorf("colorize didn't keep the order of the lightness, %v", lightness)
+			}
+
+			// A solid gray keeps its lightness, so colorizing it with no chroma leaves it as is
+			buf, err = processor.ProcessImage(context.Background(), image.NewTask("gray", 200, 100, "testing", image.JPEG).Colorize(40, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err = jpeg.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c := decoded.At(50, 50); !closeColor(c, color.RGBA{128, 128, 128, 255}) {
+				t.Errorf("colorize changed the lightness of gray, %v", c)
+			}
+		})
+
+		t.Run("tones the image sepia", func(t *testing.T) {
+			// gray.jpg is a solid gray of 128, which the sepia matrix warms up in linear light, and a partial amount only halfway
+			full := decodeJPEG(t, processor, image.NewTask("gray", 200, 100, "testing", image.JPEG).Sepia(100)).At(50, 50)
+			if !closeColor(full, color.RGBA{147, 139, 124, 255}) {
+				t.Errorf("wrong full sepia color %v", full)
+			}
+
+			half := decodeJPEG(t, processor, image.NewTask("gray", 200, 100, "testing", image.JPEG).Sepia(50)).At(50, 50)
+			if !closeColor(half, color.RGBA{138, 134, 126, 255}) {
+				t.Errorf("wrong partial sepia color %v", half)
+			}
+
+			// The partial amount is less saturated than full sepia
+			if spread(half) >= spread(full) {
+				t.Errorf("partial sepia isn't between the original and full sepia, %v and %v", half, full)
+			}
+		})
+
+		t.Run("darkens the image toward the edges", func(t *testing.T) {
+			// gray.jpg is a solid gray of 128, the center is left as is and the corners are darkened by the full intensity
+			// The points are at the same place relative to the size of the image, so the vignette is the same at both sizes
+			tests := []struct {
+				Name     string
+				Width    int
+				Height   int
+				Amount   int
+				Expected []color.RGBA // At the center, the middle of the left edge and the top left corner
+			}{
+				{"full intensity", 200, 100, 100, []color.RGBA{{128, 128, 128, 255}, {65, 65, 65, 255}, {2, 2, 2, 255}}},
+				{"half intensity", 200, 100, 50, []color.RGBA{{128, 128, 128, 255}, {96, 96, 96, 255}, {65, 65, 65, 255}}},
+				{"half intensity at twice the size", 400, 200, 50, []color.RGBA{{128, 128, 128, 255}, {96, 96, 96, 255}, {65, 65, 65, 255}}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, image.NewTask("gray", test.Width, test.Height, "testing", image.JPEG).Vignette(test.Amount))
+				points := []struct{ X, Y int }{{test.Width / 2, test.Height / 2}, {0, test.Height / 2}, {0, 0}}
+				for i, point := range points {
+					if c := decoded.At(point.X, point.Y); !closeColor(c, test.Expected[i]) {
+						t.Errorf("%s: wrong color %v at %d,%d", test.Name, c, point.X, point.Y)
+					}
+				}
+			}
+		})
+
+		t.Run("masks the image", func(t *testing.T) {
+			// mask.jpg is a grayscale PNG that's black on the left half and white on the right half
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.WebP).Mask("mask"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			masked, err := libvips.ResizeImage(buf, 50, 50, libvips.ResizeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer libvips.UnrefImage(masked)
+
+			if !libvips.HasAlpha(masked) {
+				t.Error("masked image has no alpha channel")
+			}
+		})
+
+		t.Run("pads with a transparent background", func(t *testing.T) {
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.WebP).PadTransparent(200, 200))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			padded, err := libvips.ResizeImage(buf, 200, 200, libvips.ResizeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer libvips.UnrefImage(padded)
+
+			if !libvips.HasAlpha(padded) {
+				t.Error("padded image has no alpha channel")
+			}
+		})
+
+		t.Run("flattens transparent frames onto the background", func(t *testing.T) {
+			// animated_transparent.jpg is a GIF with a frame that's red on the left, and a frame that's blue on the right,
+			// both transparent on the other half and disposed of to the background, so the red doesn't show through the second frame
+			white := color.RGBA{255, 255, 255, 255}
+			tests := []struct {
+				Frame int
+				Left  color.RGBA
+				Right color.RGBA
+			}{
+				{0, color.RGBA{255, 0, 0, 255}, white},
+				{1, white, color.RGBA{0, 0, 255, 255}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, image.NewTask("animated_transparent", 100, 100, "testing", image.JPEG).Frame(test.Frame).Flatten(image.Color{R: 255, G: 255, B: 255}))
+				if c := decoded.At(25, 50); !closeColor(c, test.Left) {
+					t.Errorf("frame %d: wrong color on the left %#v", test.Frame, c)
+				}
+
+				if c := decoded.At(75, 50); !closeColor(c, test.Right) {
+					t.Errorf("frame %d: wrong color on the right %#v", test.Frame, c)
+				}
+			}
+
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("animated_transparent", 100, 100, "testing", image.WebP).Flatten(image.Color{R: 255, G: 255, B: 255}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			flattened, err := libvips.ResizeImage(buf, 100, 100, libvips.ResizeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer libvips.UnrefImage(flattened)
+
+			if libvips.HasAlpha(flattened) {
+				t.Error("flattened image has an alpha channel")
+			}
+		})
+
+		t.Run("removes the alpha channel of opaque images", func(t *testing.T) {
+			// GIFs are loaded with an alpha channel, animated.jpg is fully opaque and animated_transparent.jpg is half transparent
+			tests := []struct {
+				ImageID       string
+				ExpectedAlpha bool
+			}{
+				{"animated", false},
+				{"animated_transparent", true},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask(test.ImageID, 100, 100, "testing", image.WebP))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				encoded, err := libvips.ResizeImage(buf, 100, 100, libvips.ResizeOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if alpha := libvips.HasAlpha(encoded); alpha != test.ExpectedAlpha {
+					t.Errorf("%s: wrong alpha channel %t", test.ImageID, alpha)
+				}
+				libvips.UnrefImage(encoded)
+			}
+		})
+
+		t.Run("resizes with the fit", func(t *testing.T) {
+			// quadrants.jpg is 200x100, so containing it keeps the aspect ratio, and filling it stretches it
+			tests := []struct {
+				Name           string
+				Fit            image.Fit
+				Filter         image.ResizeFilter
+				ExpectedWidth  int
+				ExpectedHeight int
+			}{
+				{"cover", image.Cover, image.Lanczos, 100, 100},
+				{"contain", image.Contain, image.Lanczos, 100, 50},
+				{"fill", image.Fill, image.Lanczos, 100, 100},
+				{"contain with another filter", image.Contain, image.Cubic, 100, 50},
+				{"fill with another filter", image.Fill, image.Cubic, 100, 100},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 100, 100, "testing", image.JPEG).Fit(test.Fit).Filter(test.Filter))
+				if err != nil {
+					t.Fatalf("%s: %s", test.Name, err)
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Fatalf("%s: %s", test.Name, err)
+				}
+
+				if bounds := decoded.Bounds(); bounds.Dx() != test.ExpectedWidth || bounds.Dy() != test.ExpectedHeight {
+					t.Errorf("%s: wrong size %v", test.Name, bounds)
+				}
+
+				// Filling keeps all four quadrants, where covering crops off the sides
+				if test.Fit == image.Fill {
+					if c := decoded.At(25, 25); !closeColor(c, color.RGBA{255, 0, 0, 255}) {
+						t.Errorf("%s: wrong color in the top left %v", test.Name, c)
+					}
+
+					if c := decoded.At(75, 75); !closeColor(c, color.RGBA{255, 255, 255, 255}) {
+						t.Errorf("%s: wrong color in the bottom right %v", test.Name, c)
+					}
+				}
+			}
+		})
+
+		t.Run("inverts a region", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.JPEG).InvertRegion(image.Region{Left: 0, Top: 0, Width: 100, Height: 50}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the top left quadrant is inverted, from red to cyan
+			expected := []struct {
+				X, Y  int
+				Color color.RGBA
+			}{
+				{50, 25, color.RGBA{0, 255, 255, 255}},
+				{150, 25, color.RGBA{0, 255, 0, 255}},
+				{50, 75, color.RGBA{0, 0, 255, 255}},
+				{150, 75, color.RGBA{255, 255, 255, 255}},
+			}
+
+			for _, e := range expected {
+				if c := decoded.At(e.X, e.Y); !closeColor(c, e.Color) {
+					t.Errorf("wrong color at %d,%d %v", e.X, e.Y, c)
+				}
+			}
+
+			// Regions outside of the image are clipped to it
+			if _, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.JPEG).InvertRegion(image.Region{Left: 150, Top: 50, Width: 100, Height: 100})); err != nil {
+				t.Errorf("error inverting a region outside of the image %s", err)
+			}
+		})
+
+		t.Run("crops the source image", func(t *testing.T) {
+			// Cropping quadrants.jpg to the right half leaves the green and white quadrants, which are then resized to the full size
+			decoded := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Fit(image.Fill).Crop(image.Region{Left: 100, Top: 0, Width: 100, Height: 100}))
+
+			expected := []struct {
+				X, Y  int
+				Color color.RGBA
+			}{
+				{50, 25, color.RGBA{0, 255, 0, 255}},
+				{150, 25, color.RGBA{0, 255, 0, 255}},
+				{50, 75, color.RGBA{255, 255, 255, 255}},
+				{150, 75, color.RGBA{255, 255, 255, 255}},
+			}
+
+			for _, e := range expected {
+				if c := decoded.At(e.X, e.Y); !closeColor(c, e.Color) {
+					t.Errorf("wrong color at %d,%d %v", e.X, e.Y, c)
+				}
+			}
+
+			// Which also takes the slower path when resizing with another kernel
+			decoded = decodeJPEG(t, processor, image.NewTask("quadrants", 50, 50, "testing", image.JPEG).Filter(image.Nearest).Crop(image.Region{Left: 0, Top: 50, Width: 100, Height: 50}))
+			if c := decoded.At(25, 25); !closeColor(c, color.RGBA{0, 0, 255, 255}) {
+				t.Errorf("wrong color of the cropped bottom left quadrant %v", c)
+			}
+		})
+
+		t.Run("handles the edges when blurring", func(t *testing.T) {
+			// The top left corner of quadrants.jpg is red, and wrapping brings in the green, blue and white quadrants on the opposite sides
+			extend := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(10))
+			mirror := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(10).BlurEdge(image.MirrorEdge))
+			wrap := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(10).BlurEdge(image.WrapEdge))
+
+			for name, decoded := range map[string]goimage.Image{"extend": extend, "mirror": mirror} {
+				if c := decoded.At(1, 1); !closeColor(c, color.RGBA{255, 0, 0, 255}) {
+					t.Errorf("%s: wrong color at the corner %v", name, c)
+				}
+			}
+
+			if _, g, b, _ := wrap.At(1, 1).RGBA(); g>>8 < 40 || b>>8 < 40 {
+				t.Errorf("wrap: wrong color at the corner %v", wrap.At(1, 1))
+			}
+
+			// Away from the edges the blur is the same
+			if c1, c2 := extend.At(100, 50), wrap.At(100, 50); !closeColor(c1, color.RGBAModel.Convert(c2).(color.RGBA)) {
+				t.Errorf("different colors in the middle of the image %v and %v", c1, c2)
+			}
+		})
+
+		t.Run("embeds the resolution", func(t *testing.T) {
+			for _, dpi := range []int{72, 300, 1200} {
+				buf, err := processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG).DPI(dpi))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if density := jfifDPI(buf); density != dpi {
+					t.Errorf("wrong density %d for %d dpi", density, dpi)
+				}
+
+				// The pixels are the same as without the resolution
+				config, err := jpeg.DecodeConfig(bytes.NewReader(buf))
+				if err != nil || config.Width != 100 || config.Height != 100 {
+					t.Errorf("wrong size %#v %s", config, err)
+				}
+			}
+		})
+
+		t.Run("blurs a region", func(t *testing.T) {
+			// Blurring the top left quadrant of quadrants.jpg only mixes the red with the green and blue next to it within the region
+			light := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(2).BlurRegion(image.Region{Left: 0, Top: 0, Width: 100, Height: 50}))
+			heavy := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(10).BlurRegion(image.Region{Left: 0, Top: 0, Width: 100, Height: 50}))
+
+			// Outside of the region the quadrants stay sharp, where a blur of the whole image would have mixed in the red
+			for name, decoded := range map[string]goimage.Image{"light": light, "heavy": heavy} {
+				expected := []struct {
+					X, Y  int
+					Color color.RGBA
+				}{
+					{115, 25, color.RGBA{0, 255, 0, 255}},
+					{50, 65, color.RGBA{0, 0, 255, 255}},
+					{150, 75, color.RGBA{255, 255, 255, 255}},
+					{10, 10, color.RGBA{255, 0, 0, 255}},
+				}
+
+				for _, e := range expected {
+					if c := decoded.At(e.X, e.Y); !closeColor(c, e.Color) {
+						t.Errorf("%s: wrong color at %d,%d %v", name, e.X, e.Y, c)
+					}
+				}
+			}
+
+			// The heavier blur reaches further into the region from its edge with the green quadrant
+			_, lightGreen, _, _ := light.At(95, 10).RGBA()
+			_, heavyGreen, _, _ := heavy.At(95, 10).RGBA()
+			if lightGreen>>8 > 12 || heavyGreen>>8 < 50 {
+				t.Errorf("wrong blur amounts, green of %d and %d", lightGreen>>8, heavyGreen>>8)
+			}
+		})
+
+		t.Run("blurs before resizing", func(t *testing.T) {
+			// The blur is scaled up with the image, so blurring first should look about the same as blurring the resized image
+			tests := []struct {
+				Name string
+				Task func() *image.Task
+			}{
+				{"downscaled", func() *image.Task { return image.NewTask("1", 100, 100, "testing", image.JPEG).Blur(5) }},
+				{"contained", func() *image.Task { return image.NewTask("quadrants", 100, 100, "testing", image.JPEG).Fit(image.Contain).Blur(2) }},
+				{"upscaled", func() *image.Task { return image.NewTask("quadrants", 400, 200, "testing", image.JPEG).Blur(5) }},
+			}
+
+			for _, test := range tests {
+				after := decodeJPEG(t, processor, test.Task())
+				before := decodeJPEG(t, processor, test.Task().BlurBeforeResize())
+
+				if after.Bounds() != before.Bounds() {
+					t.Fatalf("%s: wrong size %v", test.Name, before.Bounds())
+				}
+
+				var diff float64
+				bounds := after.Bounds()
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					for x := bounds.Min.X; x < bounds.Max.X; x++ {
+						r1, g1, b1, _ := after.At(x, y).RGBA()
+						r2, g2, b2, _ := before.At(x, y).RGBA()
+						diff += math.Abs(float64(r1>>8)-float64(r2>>8)) + math.Abs(float64(g1>>8)-float64(g2>>8)) + math.Abs(float64(b1>>8)-float64(b2>>8))
+					}
+				}
+
+				if mean := diff / float64(bounds.Dx()*bounds.Dy()*3); mean == 0 || mean > 12 {
+					t.Errorf("%s: wrong difference between blurring before and after resizing, mean difference %f", test.Name, mean)
+				}
+			}
+		})
+
+		t.Run("processes placeholders", func(t *testing.T) {
+			// The faster resizing and blur of placeholders should look about the same once the image is blurred
+			full := decodeJPEG(t, processor, image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5))
+			fast := decodeJPEG(t, processor, image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5).Placeholder())
+
+			if full.Bounds() != fast.Bounds() {
+				t.Fatalf("wrong size %v", fast.Bounds())
+			}
+
+			var diff float64
+			bounds := full.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r1, g1, b1, _ := full.At(x, y).RGBA()
+					r2, g2, b2, _ := fast.At(x, y).RGBA()
+					diff += math.Abs(float64(r1>>8)-float64(r2>>8)) + math.Abs(float64(g1>>8)-float64(g2>>8)) + math.Abs(float64(b1>>8)-float64(b2>>8))
+				}
+			}
+
+			if mean := diff / float64(bounds.Dx()*bounds.Dy()*3); mean > 8 {
+				t.Errorf("placeholder differs too much from the full quality image, mean difference %f", mean)
+			}
+		})
+
+		t.Run("processes grayscale placeholders", func(t *testing.T) {
+			// Grayscale placeholders are converted to grayscale before resizing, unless an effect other than blur runs before the saturation
+			order, err := image.ParseEffectOrder("blur,sharpen,vibrance,saturation,colorize,sepia,gamma,brightness,contrast,vignette,threshold,pad,text,invertregion,colorblind")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			generalProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, vips.NewOrderedRegistry(order), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			task := func() *image.Task { return image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5).Placeholder().Grayscale() }
+			fast := decodeJPEG(t, processor, task())
+			general := decodeJPEG(t, generalProcessor, task())
+
+			if fast.Bounds() != general.Bounds() {
+				t.Fatalf("wrong size %v", fast.Bounds())
+			}
+
+			var diff float64
+			bounds := fast.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r1, g1, b1, _ := fast.At(x, y).RGBA()
+					r2, g2, b2, _ := general.At(x, y).RGBA()
+					diff += math.Abs(float64(r1>>8)-float64(r2>>8)) + math.Abs(float64(g1>>8)-float64(g2>>8)) + math.Abs(float64(b1>>8)-float64(b2>>8))
+
+					if r1 != g1 || g1 != b1 {
+						t.Fatalf("colors left at %d,%d %v", x, y, fast.At(x, y))
+					}
+				}
+			}
+
+			if mean := diff / float64(bounds.Dx()*bounds.Dy()*3); mean > 4 {
+				t.Errorf("grayscale placeholder differs too much from the general path, mean difference %f", mean)
+			}
+		})
+
+		t.Run("sharpens", func(t *testing.T) {
+			sharpened, err := processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG).Sharpen(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			unsharpened, err := processor.ProcessImage(context.Background(), image.NewTask("1", 100, 100, "testing", image.JPEG))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(sharpened, unsharpened) {
+				t.Error("image data matches with and without sharpening")
+			}
+
+			// The flat colors of quadrants.jpg don't have any details to sharpen
+			decoded := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Sharpen(1))
+			if c := decoded.At(50, 25); !closeColor(c, color.RGBA{255, 0, 0, 255}) {
+				t.Errorf("wrong color %v", c)
+			}
+		})
+
+		t.Run("applies gamma", func(t *testing.T) {
+			// A gamma above 1 brightens the midtones, and below 1 darkens them
+			rect := goimage.Rect(0, 0, 100, 100)
+			original := meanLuminance(decodeJPEG(t, processor, image.NewTask("1", 100, 100, "testing", image.JPEG)), rect)
+			brightened := meanLuminance(decodeJPEG(t, processor, image.NewTask("1", 100, 100, "testing", image.JPEG).Gamma(2.2)), rect)
+			darkened := meanLuminance(decodeJPEG(t, processor, image.NewTask("1", 100, 100, "testing", image.JPEG).Gamma(0.5)), rect)
+
+			if brightened < original+10 || darkened > original-10 {
+				t.Errorf("wrong mean luminance, %f with gamma 2.2 and %f with gamma 0.5, from %f", brightened, darkened, original)
+			}
+
+			// gray.jpg is a solid gray of 128, which is raised to the power of 1/gamma in the 0-1 range
+			if c := decodeJPEG(t, processor, image.NewTask("gray", 100, 100, "testing", image.JPEG).Gamma(2.2)).At(50, 50); !closeColor(c, color.RGBA{186, 186, 186, 255}) {
+				t.Errorf("wrong gray with gamma 2.2 %v", c)
+			}
+
+			if c := decodeJPEG(t, processor, image.NewTask("gray", 100, 100, "testing", image.JPEG).Gamma(0.5)).At(50, 50); !closeColor(c, color.RGBA{64, 64, 64, 255}) {
+				t.Errorf("wrong gray with gamma 0.5 %v", c)
+			}
+
+			// The fully saturated colors of quadrants.jpg, and white, are unchanged
+			decoded := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Gamma(2.2))
+			expected := []struct {
+				X, Y  int
+				Color color.RGBA
+			}{
+				{50, 25, color.RGBA{255, 0, 0, 255}},
+				{150, 25, color.RGBA{0, 255, 0, 255}},
+				{50, 75, color.RGBA{0, 0, 255, 255}},
+				{150, 75, color.RGBA{255, 255, 255, 255}},
+			}
+
+			for _, e := range expected {
+				if c := decoded.At(e.X, e.Y); !closeColor(c, e.Color) {
+					t.Errorf("wrong color at %d,%d %v", e.X, e.Y, c)
+				}
+			}
+		})
+
+		t.Run("adjusts brightness and contrast", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+			tests := []struct {
+				Name     string
+				Task     *image.Task
+				Expected color.RGBA
+			}{
+				{"brightens", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Brightness(20), color.RGBA{255, 51, 51, 255}},
+				{"darkens to black", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Brightness(-100), color.RGBA{0, 0, 0, 255}},
+				{"increased contrast leaves the saturated colors as is", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Contrast(100), color.RGBA{255, 0, 0, 255}},
+				{"no contrast is flat gray", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Contrast(-100), color.RGBA{128, 128, 128, 255}},
+				{"reduced contrast", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Contrast(-50), color.RGBA{191, 64, 64, 255}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, test.Task)
+				if c := decoded.At(50, 25); !closeColor(c, test.Expected) {
+					t.Errorf("%s: wrong color %v", test.Name, c)
+				}
+			}
+		})
+
+		t.Run("simulates color blindness", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+			// The expected colors are the quadrants run through the matrices in linear light, grays such as white are left as is
+			points := []struct{ X, Y int }{{50, 25}, {150, 25}, {50, 75}, {150, 75}}
+			tests := []struct {
+				Name     string
+				Mode     image.ColorblindMode
+				Expected []color.RGBA
+			}{
+				{"protanopia", image.Protanopia, []color.RGBA{{109, 95, 0, 255}, {255, 229, 0, 255}, {0, 89, 255, 255}, {255, 255, 255, 255}}},
+				{"deuteranopia", image.Deuteranopia, []color.RGBA{{163, 144, 0, 255}, {239, 214, 58, 255}, {0, 61, 251, 255}, {255, 255, 255, 255}}},
+				{"tritanopia", image.Tritanopia, []color.RGBA{{255, 0, 15, 255}, {0, 247, 217, 255}, {0, 107, 150, 255}, {255, 255, 255, 255}}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Colorblind(test.Mode))
+				for i, point := range points {
+					if c := decoded.At(point.X, point.Y); !closeColor(c, test.Expected[i]) {
+						t.Errorf("%s: wrong color %v at %d,%d", test.Name, c, point.X, point.Y)
+					}
+				}
+			}
+		})
+
+		t.Run("thresholds to black and white", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+			points := []struct{ X, Y int }{{50, 25}, {150, 25}, {50, 75}, {150, 75}}
+
+			tests := []struct {
+				Name     string
+				Task     *image.Task
+				Expected []uint8
+			}{
+				{"threshold 150", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Threshold(150, false), []uint8{0, 255, 0, 255}},
+				{"threshold 240", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Threshold(240, false), []uint8{0, 0, 0, 255}},
+				{"threshold 0", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Threshold(0, false), []uint8{255, 255, 255, 255}},
+				{"grayscale then threshold", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Grayscale().Threshold(150, false), []uint8{0, 255, 0, 255}},
+			}
+
+			for _, test := range tests {
+				decoded := decodeJPEG(t, processor, test.Task)
+				for i, point := range points {
+					expected := test.Expected[i]
+					if c := decoded.At(point.X, point.Y); !closeColor(c, color.RGBA{expected, expected, expected, 255}) {
+						t.Errorf("%s: wrong value %v at %v", test.Name, c, point)
+					}
+				}
+			}
+
+			// Dithering turns the red quadrant, which is darker than the threshold, into a pattern of black and white pixels
+			// The white quadrant is never dithered, as it's at the top of the range
+			for _, dither := range []bool{false, true} {
+				decoded := decodeJPEG(t, processor, image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Threshold(150, dither))
+
+				red := meanLuminance(decoded, goimage.Rect(20, 10, 80, 40))
+				white := meanLuminance(decoded, goimage.Rect(120, 60, 180, 90))
+				if dither && (red < 20 || red > 235) {
+					t.Errorf("dithered: red quadrant isn't dithered, mean %f", red)
+				}
+
+				if !dither && red > 20 {
+					t.Errorf("not dithered: red quadrant isn't black, mean %f", red)
+				}
+
+				if white < 235 {
+					t.Errorf("dithered %t: white quadrant isn't white, mean %f", dither, white)
+				}
+			}
+		})
+
+		t.Run("extracts a channel", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+			points := []struct{ X, Y int }{{50, 25}, {150, 25}, {50, 75}, {150, 75}}
+
+			tests := []struct {
+				Name     string
+				Task     *image.Task
+				Expected []uint8
+			}{
+				{"red", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.RedChannel, false), []uint8{255, 0, 0, 255}},
+				{"green", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.GreenChannel, false), []uint8{0, 255, 0, 255}},
+				{"blue", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.BlueChannel, false), []uint8{0, 0, 255, 255}},
+				{"opaque alpha", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.AlphaChannel, false), []uint8{255, 255, 255, 255}},
+				// mask.jpg is black on the left half and white on the right half, so it's transparent on the left
+				{"alpha", image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Mask("mask").Extract(image.AlphaChannel, true), []uint8{0, 255, 0, 255}},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), test.Task)
+				if err != nil {
+					t.Fatalf("%s: %s", test.Name, err)
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf))
+				if err != nil {
+					t.Fatalf("%s: %s", test.Name, err)
+				}
+
+				if decoded.ColorModel() != color.GrayModel {
+					t.Errorf("%s: image isn't grayscale", test.Name)
+				}
+
+				for i, point := range points {
+					expected := test.Expected[i]
+					if c := decoded.At(point.X, point.Y); !closeColor(c, color.RGBA{expected, expected, expected, 255}) {
+						t.Errorf("%s: wrong value %v at %v", test.Name, c, point)
+					}
+				}
+			}
+
+			// Luminance is weighted, so that green is the lightest and blue is the darkest of the primary colors
+			buf, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.LuminanceChannel, false))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var luminance []uint8
+			for _, point := range points {
+				luminance = append(luminance, color.GrayModel.Convert(decoded.At(point.X, point.Y)).(color.Gray).Y)
+			}
+
+			if !(luminance[3] > luminance[1] && luminance[1] > luminance[0] && luminance[0] > luminance[2]) {
+				t.Errorf("wrong order of the luminance, %v", luminance)
+			}
+		})
+
+		t.Run("fails extracting a missing alpha channel when it's required", func(t *testing.T) {
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Extract(image.AlphaChannel, true))
+			if !errors.Is(err, image.ErrNoAlphaChannel) {
+				t.Errorf("wrong error %#v", err)
+			}
+		})
+
+		t.Run("fails masking with a missing image", func(t *testing.T) {
+			_, err := processor.ProcessImage(context.Background(), image.NewTask("quadrants", 50, 50, "testing", image.WebP).Mask("missing"))
+			if err == nil {
+				t.Error("no error")
+			}
+		})
+
+		t.Run("grayscale is the same as a saturation of 0", func(t *testing.T) {
+			grayscale, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).Grayscale())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			desaturated, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).Saturate(1.5).Saturate(0).Vibrance(100))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(grayscale, desaturated) {
+				t.Error("saturation 0 doesn't match grayscale")
+			}
+		})
+
+		t.Run("runs registered custom steps", func(t *testing.T) {
+			var calls []string
+			registry := vips.NewRegistry()
+			registry.Register(vips.StepFunc(func(img libvips.Image, task *image.Task) (libvips.Image, error) {
+				calls = append(calls, task.ImageID)
+				return libvips.Grayscale(img)
+			}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			customProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, registry, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			custom, err := customProcessor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(calls, []string{"1"}) {
+				t.Errorf("custom step wasn't called once, %#v", calls)
+			}
+
+			// The custom step grayscales the image, so it should match the built-in grayscale step
+			grayscale, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).Grayscale())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(custom, grayscale) {
+				t.Error("custom step wasn't applied")
+			}
+		})
+
+		t.Run("upscales past the ratio with the upscaler", func(t *testing.T) {
+			upscaler := &recordingUpscaler{}
+			registry := vips.NewRegistry()
+			registry.SetUpscaler(upscaler, vips.DefaultUpscaleRatio)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			upscalingProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, registry, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 1.jpg is 300x400
+			tests := []struct {
+				Name          string
+				Task          *image.Task
+				ExpectedCalls []string
+				ExpectedSize  goimage.Point
+			}{
+				{"downscaled", image.NewTask("1", 100, 100, "testing", image.JPEG), nil, goimage.Pt(100, 100)},
+				{"upscaled below the ratio", image.NewTask("1", 500, 500, "testing", image.JPEG), nil, goimage.Pt(500, 500)},
+				{"upscaled past the ratio", image.NewTask("1", 1000, 1000, "testing", image.JPEG), []string{"300x300 to 1000x1000"}, goimage.Pt(1000, 1000)},
+				{"contained past the ratio", image.NewTask("1", 1000, 1000, "testing", image.JPEG).Fit(image.Contain), []string{"300x400 to 750x1000"}, goimage.Pt(750, 1000)},
+			}
+
+			for _, test := range tests {
+				upscaler.calls = nil
+				decoded := decodeJPEG(t, upscalingProcessor, test.Task)
+
+				if !reflect.DeepEqual(upscaler.calls, test.ExpectedCalls) {
+					t.Errorf("%s: wrong upscaler calls %#v", test.Name, upscaler.calls)
+				}
+
+				// The upscaler returns the image as is, so it's resized the rest of the way
+				if size := decoded.Bounds().Size(); size != test.ExpectedSize {
+					t.Errorf("%s: wrong size %v", test.Name, size)
+				}
+			}
+		})
+
+		t.Run("applies the effects in the configured order", func(t *testing.T) {
+			order, err := image.ParseEffectOrder("sharpen,saturation,vibrance,colorize,sepia,gamma,brightness,contrast,vignette,threshold,blur,pad,text,invertregion,colorblind")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			orderedProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, vips.NewOrderedRegistry(order), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Blurring after the threshold softens the black and white edge between the top quadrants, rather than thresholding the blurred image
+			task := image.NewTask("quadrants", 200, 100, "testing", image.JPEG).Blur(5).Threshold(150, false)
+			edge := goimage.Rect(80, 10, 120, 40)
+			if gray := grayPixels(decodeJPEG(t, processor, task), edge); gray > 60 {
+				t.Errorf("canonical order has %d gray pixels", gray)
+			}
+
+			if gray := grayPixels(decodeJPEG(t, orderedProcessor, task), edge); gray < 200 {
+				t.Errorf("configured order has %d gray pixels", gray)
+			}
+		})
+
+		t.Run("sets the threads of each image", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storage, _ := file.New("../../../test/fixtures/file")
+			threadedProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 2, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if threads := libvips.Concurrency(); threads != 2 {
+				t.Errorf("wrong threads %d", threads)
+			}
+
+			if decoded := decodeJPEG(t, threadedProcessor, image.NewTask("1", 200, 200, "testing", image.JPEG)); decoded.Bounds().Size() != goimage.Pt(200, 200) {
+				t.Errorf("wrong size %s", decoded.Bounds().Size())
+			}
+
+			// The default is a single thread, which the rest of the tests use
+			if _, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 1, 0, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if threads := libvips.Concurrency(); threads != 1 {
+				t.Errorf("wrong default threads %d", threads)
+			}
+		})
+
+		t.Run("converts and embeds the color space profile", func(t *testing.T) {
+			srgb, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Contains(srgb, []byte("ICC_PROFILE")) {
+				t.Error("srgb image has an embedded profile")
+			}
+
+			embedded, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).EmbedProfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(embedded, []byte("ICC_PROFILE")) {
+				t.Error("srgb image is missing the embedded profile")
+			}
+
+			p3, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).ConvertColorSpace(image.DisplayP3).EmbedProfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(p3, []byte("ICC_PROFILE")) {
+				t.Error("p3 image is missing the embedded profile")
+			}
+
+			if reflect.DeepEqual(embedded, p3) {
+				t.Error("p3 image data matches srgb image data")
+			}
+		})
+
+		t.Run("keeps grayscale images in the color space profile", func(t *testing.T) {
+			srgb, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).Grayscale())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p3, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.JPEG).Grayscale().ConvertColorSpace(image.DisplayP3).EmbedProfile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Contains(p3, []byte("ICC_PROFILE")) {
+				t.Error("grayscale image has an embedded rgb profile")
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(p3))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := decoded.(*goimage.Gray); !ok {
+				t.Errorf("wrong color model %T", decoded)
+			}
+
+			if !reflect.DeepEqual(srgb, p3) {
+				t.Error("p3 image data doesn't match srgb image data")
+			}
+		})
+
+		t.Run("optimizes the jpeg huffman coding", func(t *testing.T) {
+			optimized, err := processor.ProcessImage(context.Background(), image.NewTask("1", 300, 400, "testing", image.JPEG))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			unoptimized, err := processor.ProcessImage(context.Background(), image.NewTask("1", 300, 400, "testing", image.JPEG).OptimizeCoding(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(optimized) > len(unoptimized) {
+				t.Errorf("optimized image is larger, %d > %d bytes", len(optimized), len(unoptimized))
+			}
+		})
+
+		t.Run("uses the task effort", func(t *testing.T) {
+			fastest, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.WebP).Effort(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			smallest, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.WebP).Effort(6))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(fastest, smallest) {
+				t.Error("image data matches for different effort levels")
+			}
+		})
+
+		t.Run("encodes lossless webp", func(t *testing.T) {
+			lossy, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.WebP))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lossless, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.WebP).Lossless())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nearLossless, err := processor.ProcessImage(context.Background(), image.NewTask("1", 500, 500, "testing", image.WebP).NearLossless(40))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The preprocessing makes the near lossless image compress better, but it's still much larger than the lossy one
+			if len(nearLossless) >= len(lossless) || len(lossy) >= len(nearLossless) {
+				t.Errorf("wrong sizes, %d bytes lossy, %d bytes near lossless and %d bytes lossless", len(lossy), len(nearLossless), len(lossless))
+			}
+		})
+
+		t.Run("outputs raw pixels", func(t *testing.T) {
+			// quadrants.jpg is a PNG that's red in the top left, and transparent padding gives it an alpha channel to drop or keep
+			// Without alpha, the padding is flattened onto the background of the task, which is black when it isn't set
+			tests := []struct {
+				Name        string
+				Task        *image.Task
+				PixelFormat image.PixelFormat
+				FirstPixel  []byte
+			}{
+				{"rgb8", image.NewTask("quadrants", 200, 100, "testing", image.Raw), image.RGB8, []byte{255, 0, 0}},
+				{"rgba8", image.NewTask("quadrants", 200, 100, "testing", image.Raw), image.RGBA8, []byte{255, 0, 0, 255}},
+				{"gray8", image.NewTask("quadrants", 200, 100, "testing", image.Raw), image.Gray8, nil},
+				{"rgb8 with alpha", image.NewTask("quadrants", 200, 100, "testing", image.Raw).PadTransparent(200, 200), image.RGB8, []byte{0, 0, 0}},
+				{"rgba8 with alpha", image.NewTask("quadrants", 200, 100, "testing", image.Raw).PadTransparent(200, 200), image.RGBA8, []byte{0, 0, 0, 0}},
+			}
+
+			for _, test := range tests {
+				buf, err := processor.ProcessImage(context.Background(), test.Task.PixelFormat(test.PixelFormat))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				width, height := test.Task.Width, test.Task.Height
+				if test.Task.ApplyPad {
+					width, height = test.Task.CanvasWidth, test.Task.CanvasHeight
+				}
+
+				if len(buf) != width*height*test.PixelFormat.Channels() {
+					t.Errorf("%s: wrong length %d", test.Name, len(buf))
+					continue
+				}
+
+				for i, expected := range test.FirstPixel {
+					if diff := int(buf[i]) - int(expected); diff < -12 || diff > 12 {
+						t.Errorf("%s: wrong first pixel %v", test.Name, buf[:len(test.FirstPixel)])
+						break
+					}
+				}
 			}
 		})
 	})
@@ -107,4 +1566,204 @@ func BenchmarkVips(b *testing.B) {
 	b.Run("full test webp", func(b *testing.B) {
 		fullTest(processor, buf, image.WebP)
 	})
+
+	// Blurred placeholders, with and without the faster resizing and blur
+	b.Run("placeholder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processor.ProcessImage(context.Background(), image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5))
+		}
+	})
+
+	b.Run("fast placeholder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processor.ProcessImage(context.Background(), image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5).Placeholder())
+		}
+	})
+
+	// Blurred grayscale placeholders, converted to grayscale before resizing, and by the saturation step after blurring
+	b.Run("grayscale placeholder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processor.ProcessImage(context.Background(), image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5).Placeholder().Grayscale())
+		}
+	})
+
+	order, err := image.ParseEffectOrder("blur,sharpen,vibrance,saturation,colorize,sepia,gamma,brightness,contrast,vignette,threshold,pad,text,invertregion,colorblind")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancelGeneral := context.WithCancel(context.Background())
+	defer cancelGeneral()
+
+	storage, _ := file.New("../../../test/fixtures/file")
+	generalProcessor, err := vips.New(ctx, logger.New(zap.ErrorLevel), image.NewCache(memory.New(), storage), 100000000, 0, 0, vips.NewOrderedRegistry(order), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer generalProcessor.Shutdown()
+
+	b.Run("general grayscale placeholder", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			generalProcessor.ProcessImage(context.Background(), image.NewTask("1", 32, 32, "testing", image.JPEG).Blur(5).Placeholder().Grayscale())
+		}
+	})
+
+	// Blurring the resized image, and blurring at the native resolution before resizing
+	b.Run("blur after resizing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processor.ProcessImage(context.Background(), image.NewTask("1", 300, 300, "testing", image.JPEG).Blur(5))
+		}
+	})
+
+	b.Run("blur before resizing", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processor.ProcessImage(context.Background(), image.NewTask("1", 300, 300, "testing", image.JPEG).Blur(5).BlurBeforeResize())
+		}
+	})
+}
+
+// colorName returns the name of the primary color, or white, that a color is closest to
+func colorName(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	switch {
+	case r > 0x8000 && g > 0x8000 && b > 0x8000:
+		return "white"
+	case r > 0x8000:
+		return "red"
+	case g > 0x8000:
+		return "green"
+	case b > 0x8000:
+		return "blue"
+	default:
+		return "unknown"
+	}
+}
+
+// jfifDPI returns the density in the JFIF header of a JPEG image in dots per inch, or 0 when it has none
+func jfifDPI(buf []byte) int {
+	start := bytes.Index(buf, []byte("JFIF\x00"))
+	if start < 0 || len(buf) < start+12 {
+		return 0
+	}
+
+	// The version is followed by the unit, and the horizontal and vertical density
+	header := buf[start+5:]
+	x, y := int(header[3])<<8|int(header[4]), int(header[5])<<8|int(header[6])
+	if x != y {
+		return 0
+	}
+
+	switch header[2] {
+	case 1:
+		return x
+	case 2:
+		return int(math.Round(float64(x) * 2.54))
+	default:
+		return 0
+	}
+}
+
+// closeColor returns whether a color is within the margin of error of JPEG compression of the expected color
+func closeColor(c color.Color, expected color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	within := func(value uint32, expected uint8) bool {
+		diff := int(value>>8) - int(expected)
+		return diff > -12 && diff < 12
+	}
+
+	return within(r, expected.R) && within(g, expected.G) && within(b, expected.B)
+}
+
+// spread returns the difference between the largest and smallest channel of a color, as a measure of how saturated it is
+func spread(c color.Color) int {
+	r, g, b, _ := c.RGBA()
+	max, min := r, r
+	for _, v := range []uint32{g, b} {
+		if v > max {
+			max = v
+		}
+
+		if v < min {
+			min = v
+		}
+	}
+
+	return int(max>>8) - int(min>>8)
+}
+
+// decodeJPEG processes the task, and decodes the resulting JPEG image
+func decodeJPEG(t *testing.T, processor *vips.Processor, task *image.Task) goimage.Image {
+	t.Helper()
+
+	buf, err := processor.ProcessImage(context.Background(), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+// brightBounds returns the smallest rectangle containing the pixels of the image that are close to white
+func brightBounds(img goimage.Image) goimage.Rectangle {
+	var bounds goimage.Rectangle
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y > 200 {
+				bounds = bounds.Union(goimage.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return bounds
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+// grayPixels returns how many pixels within a rectangle of the image are neither close to black nor to white
+func grayPixels(img goimage.Image, rect goimage.Rectangle) int {
+	gray := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if value := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y; value > 60 && value < 195 {
+				gray++
+			}
+		}
+	}
+
+	return gray
+}
+
+// meanLuminance returns the average gray value (0-255) of the pixels within a rectangle of the image
+// recordingUpscaler records the sizes it upscales images from and to, and returns the images as is
+type recordingUpscaler struct {
+	calls []string
+}
+
+func (u *recordingUpscaler) Upscale(img libvips.Image, width int, height int) (libvips.Image, error) {
+	imageWidth, imageHeight := libvips.ImageDimensions(img)
+	u.calls = append(u.calls, fmt.Sprintf("%dx%d to %dx%d", imageWidth, imageHeight, width, height))
+	return img, nil
+}
+
+func meanLuminance(img goimage.Image, rect goimage.Rectangle) float64 {
+	var sum float64
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+
+	return sum / float64(rect.Dx()*rect.Dy())
 }
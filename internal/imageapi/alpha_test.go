@@ -0,0 +1,100 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestAlphaFormat(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+	alphaRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AlphaFormat: ".webp"}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		ExpectedStatus      int
+		ExpectedContentType string
+	}{
+		{"mask is rejected by default", "/id/1/100/100.jpg?mask=1", router, http.StatusBadRequest, ""},
+		{"mask is served as webp", "/id/1/100/100.jpg?mask=1", alphaRouter, http.StatusOK, "image/webp"},
+		{"image without a mask is served as jpg", "/id/1/100/100.jpg", alphaRouter, http.StatusOK, "image/jpeg"},
+		{"background with alpha is rejected by default", "/id/1/100/100.jpg?ratio=2:1&bg=00000080", router, http.StatusBadRequest, ""},
+		{"background with alpha is served as webp", "/id/1/100/100.jpg?ratio=2:1&bg=00000080", alphaRouter, http.StatusOK, "image/webp"},
+		{"opaque background is served as jpg", "/id/1/100/100.jpg?ratio=2:1&bg=000000ff", alphaRouter, http.StatusOK, "image/jpeg"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+	}
+}
+
+func TestBackgroundAlpha(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		ExpectedTransparent bool
+		ExpectedBackground  image.Color
+		ExpectedAlpha       uint8
+	}{
+		{"8 digits", "/id/1/100/100.webp?ratio=2:1&bg=ff880080", true, image.Color{R: 255, G: 136}, 128},
+		{"4 digits", "/id/1/100/100.webp?fit=pad&bg=f80c", true, image.Color{R: 255, G: 136}, 204},
+		{"fully transparent", "/id/1/100/100.webp?ratio=2:1&bg=ff880000", true, image.Color{R: 255, G: 136}, 0},
+		{"transparent", "/id/1/100/100.webp?ratio=2:1&bg=transparent", true, image.Color{}, 0},
+		{"opaque alpha", "/id/1/100/100.webp?ratio=2:1&bg=ff8800ff", false, image.Color{R: 255, G: 136}, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		task := processor.task
+		if !task.ApplyPad || task.TransparentPad != test.ExpectedTransparent {
+			t.Errorf("%s: wrong padding, transparent %t", test.Name, task.TransparentPad)
+		}
+
+		if task.Background != test.ExpectedBackground || task.BackgroundAlpha != test.ExpectedAlpha {
+			t.Errorf("%s: wrong background %#v with alpha %d", test.Name, task.Background, task.BackgroundAlpha)
+		}
+	}
+}
@@ -2,24 +2,79 @@ package imageapi
 
 import (
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/DMarby/picsum-photos/internal/handler"
 
+	"github.com/DMarby/picsum-photos/internal/admission"
+	"github.com/DMarby/picsum-photos/internal/cache"
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/image"
 	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/quota"
+	"github.com/DMarby/picsum-photos/internal/signature"
+	"github.com/DMarby/picsum-photos/internal/storage"
 	"github.com/gorilla/mux"
 )
 
 // API is a http api
 type API struct {
-	ImageProcessor image.Processor
-	Database       database.Provider
-	HealthChecker  *health.Checker
-	Log            *logger.Logger
-	HandlerTimeout time.Duration
+	ImageProcessor    image.Processor
+	Database          database.Provider
+	HealthChecker     *health.Checker
+	Log               *logger.Logger
+	HandlerTimeout    time.Duration
+	NoUpscale         bool
+	EncodeEffort      int
+	EmbedICCProfile   bool
+	FormatCache       cache.Provider
+	DPRQuality        DPRQuality
+	Admission         *admission.Controller
+	TimingAllowOrigin bool
+	SourceModTime     storage.ModTimeProvider
+	CacheTTLs         handler.CacheTTLs
+	AutoQualitySSIM   float64
+	Rounding          params.Rounding
+	Sources           *image.Cache
+	ExifGPS           bool
+	URLLimits         handler.URLLimits
+	StrictExtract     bool
+	OptimizeCoding    bool
+	Unprocessable     bool
+	Degradation       *Degradation
+	ImageIDPattern    *regexp.Regexp
+	TenantPattern     *regexp.Regexp
+	SlowRequests      time.Duration
+	DebugParams       bool
+	AutoSharpen       AutoSharpen
+	LegacyUserAgents  *regexp.Regexp
+	DefaultDPI        int
+	Quota             *quota.Quota
+	NoAutoRotate      bool
+	SecurityHeaders   handler.SecurityHeaders
+	QualityPresets    QualityPresets
+	Bandwidth         *Bandwidth
+	BasePath          string
+	AlphaFormat       string
+	ProcessingVersion string
+	SaveDataQuality   int
+	Signer            *signature.Signer
+	DisabledEffects   params.DisabledEffects
+	LosslessThreshold float64
+	MaxBlurRatio      float64
+	SourceHeader      bool
+	CropBounds        params.CropBounds
+	TrailingSlash     handler.TrailingSlash
+	QualityBounds     params.QualityBounds
+	EncodeFallback    *EncodeFallback
+	SizeQuality       SizeQuality
+	DownloadJobs      *DownloadJobs
+	MaxEffects        int
+	AsyncJobs         *AsyncJobs
+	Filters           params.Filters
 }
 
 // Utility methods for logging
@@ -33,22 +88,183 @@ func (a *API) Router() http.Handler {
 
 	router.NotFoundHandler = handler.Handler(a.notFoundHandler)
 
-	// Redirect trailing slashes
+	// Redirect trailing slashes, unless the deployment accepts them, in which case they're stripped before routing
 	router.StrictSlash(true)
 
+	// Mount the routes under the base path, when running behind a reverse proxy that serves them under a path
+	routes := router
+	if a.BasePath != "" {
+		routes = router.PathPrefix(a.BasePath).Subrouter()
+	}
+
 	// Healthcheck
-	router.Handle("/health", handler.Health(a.HealthChecker)).Methods("GET")
+	routes.Handle("/health", handler.Health(a.HealthChecker)).Methods("GET")
 
 	// Image by ID routes
-	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:\\..*}", handler.Handler(a.imageHandler)).Methods("GET")
+	var imageHandler http.Handler = handler.Handler(a.imageHandler)
+	if a.TimingAllowOrigin {
+		imageHandler = handler.TimingAllowOrigin(imageHandler)
+	}
+
+	a.imageRoutes(routes, imageHandler)
+
+	// The same routes for the images of tenants, under /t/{tenant}
+	if a.TenantPattern != nil {
+		a.imageRoutes(routes.PathPrefix("/t/{tenant}").Subrouter(), imageHandler)
+	}
+
+	// Async job routes, when the deployment enables async requests
+	// Returns 202 with the status of the job while it's pending, and redirects to the image with 303 once it's been processed
+	if a.AsyncJobs != nil {
+		routes.Handle("/jobs/{job}", handler.Handler(a.asyncJobHandler)).Methods("GET")
+	}
 
 	// Query parameters:
 	// ?grayscale - Grayscale the image
+	// ?saturation={amount} - Multiply the saturation of the image by {amount} (0-3), saturation=0 is the same as grayscale
+	// ?vibrance={amount} - Boost (or mute) the least saturated colors by {amount} (-100-100), applied after the saturation
+	// ?colorize={hue} - Recolor the image to the single {hue} (0-360), keeping its lightness
+	// ?colorize={color} - Recolor the image to the hue and chroma of the hex or named {color}, such as colorize=ff8800
+	// ?sepia={amount} - Tone the image sepia by {amount} (0-100), blending it with the original colors, defaults to 100 without an amount, applied to grayscale images as well
+	// ?gamma={gamma} - Gamma correct the image by {gamma} (0.1-3), where above 1 brightens the midtones, applied after the color adjustments and before the threshold
+	// ?brightness={amount} - Brighten (or darken) the image by {amount} (-100-100) percent, applied after the gamma correction
+	// ?contrast={amount} - Increase (or reduce) the contrast of the image by {amount} (-100-100), applied after the brightness, contrast=-100 is flat gray
+	// ?vignette - Darken the image toward its edges, vignette={intensity} (0-100) sets how dark the corners get, defaults to 50 without an intensity, applied after the contrast and relative to the size of the image
+	// ?bri, ?con and ?sat - Short aliases of brightness, contrast and saturation used by other image services, the long names take precedence
+	// ?filter={name} - Apply the named combination of adjustments from the config, such as filter=vintage, the adjustments in the request take precedence over the ones of the filter
+	// ?threshold={level} - Convert the image to pure black and white, with the pixels at or above the luminance {level} (0-255) becoming white
+	// ?threshold={level}&dither - Dither the black and white image, so that gray areas become a pattern of black and white pixels
 	// ?blur - Blur the image
-	// ?blur={amount} - Blur the image by {amount}
+	// ?blur={amount} - Blur the image by {amount}, blur=0 disables blur
+	// ?blur&placeholder - Use faster, lower quality resizing and blur, for low quality image placeholders, blurred images up to 64x64 always use it
+	// ?blur&blurbefore - Blur the image at its native resolution, up to 4 times the requested size, before resizing it, which is smoother for downscaled images but slower, as the blur is scaled up with the image
+	// ?blur&bluredge={edge} - Fill in the pixels past the edges of the image with {edge} (extend, mirror, wrap) when blurring, defaults to extend
+	// ?blur&blurscale={scale} - Apply the blur amount as is at every size (absolute), or as the blur of a 500px image scaled with the longest side of the image (relative), defaults to absolute
+	// ?blur&blurregion={x},{y},{width},{height} - Only blur the region of the resized image, in pixels from the top left before padding it to ?ratio
+	// ?sharpen - Sharpen the image after resizing it
+	// ?sharpen={amount} - Sharpen the image by {amount} (0-5), sharpen=0 disables sharpening, including the default sharpening of downscaled images on deployments that enable it
+	// Boolean params can be disabled explicitly, such as ?grayscale=false
+	// Extensions are case insensitive, and paths with a trailing slash are redirected to the path without it, unless the deployment accepts them as the same path
+	// ?fit={fit} - Resize the image with {fit} (cover, contain, fill, inside, outside, pad) (defaults to cover), fit=crop is an alias of cover
+	// fit=inside resizes the image to fit within the size without upscaling it, and fit=outside to cover the size, both keeping the aspect ratio of the image
+	// The returned image has the resized dimensions rather than the requested ones, and with noupscale, fit=outside is limited to the size of the image as well
+	// fit=pad resizes the image to fit within the size like fit=contain, and always pads it to exactly the requested size with bg (defaults to white), even with noupscale
+	// ?resize-filter={filter} - Resize the image using {filter} (lanczos, cubic, linear, nearest)
+	// ?effort={level} - Encode the image with effort {level} (0-6, WebP only)
+	// ?colorspace={colorspace} - Convert the image to {colorspace} (srgb, p3)
+	// ?format=auto - Return the smallest of the formats the client accepts
+	// ?quality={quality} - Encode the image with {quality} (1-100), or within the quality bounds of the format when the deployment configures them, where qualities outside of the bounds are rejected or clamped to them
+	// ?quality={low,medium,high} - Encode the image with the quality the image service maps the preset to for the format
+	// ?quality=auto - Encode the image with the lowest quality that looks the same as the full quality image, images larger than 2048x2048 pixels keep the default quality
+	// Without a quality, the image is encoded with the quality of its dpr in -dpr-quality and of the longest side of its requested size in -size-quality, the lower of the two when both are configured
+	// ?auto={features} - Comma separated shorthand for the automatic features, auto=compress is quality=auto and auto=format is format=auto
+	// ?lossless - Encode the image losslessly (WebP only)
+	// ?lossless=auto - Encode graphics losslessly and photos lossy, by how much of the image its main colors cover (WebP only)
+	// ?nearlossless={level} - Encode the image near losslessly, preprocessing it with {level} (0-100, where 100 is the same as lossless) to compress better (WebP only)
+	// lossless and nearlossless can't be combined with each other, or with quality
+	// Images that take longer than -encode-fallback-after to process are retried with the cheaper settings of -encode-fallback, and served with the header X-Encode-Fallback: true when a retry finishes first
+	// Clients that send Save-Data: on get the -save-data-quality, and .jpg images in the smallest format they accept, unless the params ask for a quality
+	// ?dpr={dpr} - Scale the image by the device pixel ratio {dpr} (1-4), the Content-DPR header of the image is the ratio it was scaled by
+	// ?ratio={width}:{height} - Pad the image to the aspect ratio {width}:{height}
+	// ?bg={color} - Pad the image with the hex or named {color}, such as bg=000000 or bg=black (defaults to white), also pads images with fit=contain to the requested size
+	// ?bg=transparent - Leave the padding transparent, only with the .webp extension, unless -alpha-format is set to serve .jpg requests with a background with alpha as WebP
+	// ?bg={color}{alpha} - Pad the image with the hex {color} at the 2 digit hex {alpha}, such as bg=00000080 for half transparent black, or 4 digits such as bg=0008, with the same extensions as bg=transparent
+	// ?flatten - Flatten transparent areas of the image onto the background {color} of bg (defaults to white), which JPEG images always are as they have no alpha channel
+	// ?frame={frame} - Use the 0-indexed {frame} of an animated image
+	// ?crop={x},{y},{width},{height} - Crop the source image to the region before resizing it, either in pixels or in percent of the source dimensions such as crop=10%,10%,80%,80%
+	// ?crop=ar:{width}:{height} - Crop the source image to the largest region of the aspect ratio {width}:{height}, placed by gravity (defaults to center), a width/height of 0 keeps its native resolution
+	// ?trim - Trim the borders of the image that match the color of the top left corner
+	// ?trimcolor={color} - Trim the borders of the image that match the hex or named {color}
+	// ?trimtol={tolerance} - Trim colors within {tolerance} of the border color (0-255, defaults to 10)
+	// ?text={text} - Draw {text} on the image, an empty text draws the dimensions of the image (max 100 characters), sized to the smaller side of the image and shrunk to fit within it
+	// ?textcolor={color} - Draw the text in the hex or named {color} (defaults to white)
+	// ?gravity={gravity} - Place the text and the aspect ratio crop at {gravity} (center, north, northeast, east, southeast, south, southwest, west, northwest)
+	// ?orient={orientation} - Use the EXIF {orientation} (1-8) instead of the one in the image
+	// ?autorotate={bool} - Whether to rotate the image by its EXIF orientation, overriding the default of the deployment, can't be combined with orient
+	// ?blend={id} - Blend the image with {id} on top of it
+	// ?blendmode={mode} - Blend the images using {mode} (normal, multiply, screen, overlay)
+	// ?blendopacity={opacity} - Blend the image on top with {opacity} (0-1)
+	// ?overlay={id} - Place {id} on top of the image, fit within a percentage of its size
+	// ?overlaypos={gravity} - Place the overlay at {gravity} (center, north, northeast, east, southeast, south, southwest, west, northwest), defaults to southeast
+	// ?overlayopacity={opacity} - Place the overlay with {opacity} (0-100)
+	// ?overlaysize={percent} - Fit the overlay within {percent} (1-100) of the size of the image, defaults to 20
+	// ?mask={id} - Use {id} as a grayscale mask for the alpha channel of the image, requires the .webp extension, unless -alpha-format is set to serve masked .jpg requests as WebP
+	// ?invertregion={x},{y},{width},{height} - Invert the colors of the region of the returned image, in pixels from the top left
+	// ?colorblind={type} - Simulate how the image looks with the {type} of color blindness (protanopia, deuteranopia, tritanopia), applied after the other effects
+	// ?extract={channel} - Output only the {channel} as a grayscale image (red, green, blue, alpha, luminance), images without alpha have an opaque alpha channel
+	// ?noupscale - Don't upscale the image beyond its native size
+	// Images that noupscale clamps have the headers X-Image-Clamped: true and X-Image-Dimensions: {width}x{height} with the size the image was scaled to
+	// ?dpi={dpi} - Embed the resolution {dpi} (1-2400) in the image for printing, without changing its pixels, requires the .jpg extension
+	// ?pixelformat={format} - Lay out the uncompressed pixels of images with the .bin extension as {format} (rgb8, rgba8, gray8) (defaults to rgb8)
+	// The .bin extension returns the raw 8 bit pixels row by row, with the layout in the X-Pixel-Format, X-Pixel-Width, X-Pixel-Height and X-Pixel-Channels headers
+	// ?debug - Respond with the resolved params and image task as json instead of processing the image, when enabled on the deployment
+	// The quality and format picked by quality=auto and format=auto aren't included, as they require encoding the image
+	// ?sizeonly - Respond with the size in bytes that the image is encoded to as json, instead of the image itself
+	// ?async - Process the image in the background and respond with 202 and the url of the job in the Location header, which redirects to the image once it's been processed, when enabled on the deployment
+	// Async requests for an image that has been processed already are redirected to it right away, and the image is served from the output cache
+	// ?beacon - Respond with a cacheable 1x1 pixel instead of the image, without reading the image, which is transparent with the .webp extension and white with .jpg, or a pixel of the pixel format with .bin
+	// Params that depend on another param, such as ?gravity without ?text, are rejected rather than ignored
+	// Deployments can limit how many distinct effects of the capabilities a request combines, where requests with more are rejected, with the params of an effect counting as one
+	// The effects are applied in the order of effect_order in /capabilities, unless the deployment configures another order: blur, sharpen, saturation, vibrance, colorize, sepia, gamma, brightness, contrast, vignette, threshold, pad (ratio and bg), text, invertregion, colorblind
+	// Grayscale is applied while resizing and blend before the effects, and overlay, mask and extract after them
+	// JPEG sources without EXIF metadata that are requested as a .jpg at their own size, without any params, are returned as they're stored
+
+	// With signing enabled, the routes are only served with a valid signature of the path and query in front of them, /s/{signature}/id/{id}/...
+	// The health check is served without one
+
+	// Set up handlers for adding a request id, handling panics, request logging, setting CORS and security headers, limiting the requests of each client to the quota, checking the signature, limiting the url size, cache ttls, handler execution timeout (except for the streamed download progress), and trailing slashes
+	// They're wrapped around the router from the innermost to the outermost, so a request passes through them from the bottom up
+	// The request id and the panic recovery wrap everything so that they cover the logging, and the quota and signature are checked before any work is done
+	var h http.Handler = router
+	h = handler.StripTrailingSlash(a.TrailingSlash, a.BasePath, nil, h)
+	h = http.TimeoutHandler(h, a.HandlerTimeout, "Something went wrong. Timed out.")
+	h = a.streamRoutes(h)
+	h = handler.Compress(h)
+	h = handler.CacheControl(a.CacheTTLs, h)
+	h = handler.LimitURL(a.URLLimits, h)
+	h = handler.Signature(a.Signer, a.BasePath, []string{"/health"}, h)
+	h = handler.Quota(a.Log, a.Quota, h)
+	h = handler.AddSecurityHeaders(a.SecurityHeaders, h)
+	h = handler.CORS([]string{"Picsum-ID"}, h)
+	h = handler.Logger(a.Log, a.SlowRequests, h)
+	h = handler.Recovery(a.Log, h)
+	return handler.AddRequestID(h)
+}
+
+// imageRoutes adds the routes for images by id to the router
+func (a *API) imageRoutes(router *mux.Router, imageHandler http.Handler) {
+	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:\\..*}", imageHandler).Methods("GET", "HEAD")
+
+	// Describe the params the image route accepts to OPTIONS requests
+	router.Handle("/id/{id}/{width:[0-9]+}/{height:[0-9]+}{extension:\\..*}", handler.Options([]string{"GET", "HEAD"}, params.DescribeRoute(params.DescribeImageParams()))).Methods("OPTIONS")
+
+	// Source metadata routes
+	router.Handle("/id/{id}/exif", handler.Handler(a.exifHandler)).Methods("GET")
+
+	// Original source image routes, with support for range requests
+	router.Handle("/id/{id}/original", handler.Handler(a.originalHandler)).Methods("GET", "HEAD")
+
+	// Palette routes
+	// ?n={amount} - How many colors to include (1-16, defaults to 5)
+	// ?precision={precision} - How accurate the colors are, low is the fastest and high is the slowest but analyzes the most of the image (low, medium, or high, defaults to medium)
+	router.Handle("/id/{id}/palette", handler.Handler(a.paletteHandler)).Methods("GET")
+
+	// Perceptual hash routes, for finding near duplicate images
+	router.Handle("/id/{id}/phash", handler.Handler(a.phashHandler)).Methods("GET")
+	// ?a={id}&b={id} - The images to compare, returns the amount of bits that differ between their hashes (0-64)
+	router.Handle("/compare", handler.Handler(a.compareHandler)).Methods("GET")
+
+	// Contact sheet routes, for the frames of animated images
+	// ?cols={columns} - How many frames to lay out across (1-10, defaults to 4)
+	// ?frames={frames} - How many evenly spaced frames to include at most (1-100, defaults to 12), static images have a single frame
+	// ?w={width} - The width of each frame, the height follows the aspect ratio of the image (defaults to 200)
+	router.Handle("/id/{id}/contactsheet", handler.Handler(a.contactSheetHandler)).Methods("GET")
 
-	// Set up handlers for adding a request id, handling panics, request logging, setting CORS headers, and handler execution timeout
-	return handler.AddRequestID(handler.Recovery(a.Log, handler.Logger(a.Log, handler.CORS([]string{"Picsum-ID"}, http.TimeoutHandler(router, a.HandlerTimeout, "Something went wrong. Timed out.")))))
+	// Download routes, bundling variants of the image in a ZIP
+	// ?sizes={sizes} - Comma separated list of the sizes to include, either a width with the height following the aspect ratio of the image, or {width}x{height}
+	// ?formats={formats} - Comma separated list of the formats to include each size in (jpg, webp, defaults to jpg), up to 12 sizes and formats combined
+	// ?job={job} - Track the progress of the download as {job} (up to 64 letters, digits, dashes and underscores) for /download/status/{job}, when the deployment enables it
+	router.Handle("/id/{id}/download.zip", handler.Handler(a.downloadHandler)).Methods("GET")
 }
 
 // Handle not found errors
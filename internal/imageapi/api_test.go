@@ -4,13 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"image/jpeg"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"runtime"
+	"strconv"
 	"time"
 
+	"github.com/DMarby/picsum-photos/internal/admission"
 	"github.com/DMarby/picsum-photos/internal/health"
 	"github.com/DMarby/picsum-photos/internal/image"
 	api "github.com/DMarby/picsum-photos/internal/imageapi"
@@ -43,10 +47,12 @@ func TestAPI(t *testing.T) {
 
 	storage, _ := fileStorage.New("../../test/fixtures/file")
 	db, _ := fileDatabase.New("../../test/fixtures/file/metadata.json")
+	corruptDB, _ := fileDatabase.New("../../test/fixtures/file/metadata_corrupt.json")
 	cache := memoryCache.New()
 	imageCache := image.NewCache(cache, storage)
-	imageProcessor, _ := vipsProcessor.New(ctx, log, imageCache)
-	mockStorageImageProcessor, _ := vipsProcessor.New(ctx, log, image.NewCache(memoryCache.New(), &mockStorage.Provider{}))
+	imageProcessor, _ := vipsProcessor.New(ctx, log, imageCache, 100000000, 0, 0, nil, nil)
+	mockStorageImageProcessor, _ := vipsProcessor.New(ctx, log, image.NewCache(memoryCache.New(), &mockStorage.Provider{}), 100000000, 0, 0, nil, nil)
+	gifImageProcessor, _ := vipsProcessor.New(ctx, log, imageCache, 100000000, 0, 0, nil, []image.SourceFormat{image.SourceJPEG, image.SourcePNG, image.SourceGIF})
 
 	checker := &health.Checker{
 		Ctx:      ctx,
@@ -66,10 +72,17 @@ func TestAPI(t *testing.T) {
 	}
 	mockChecker.Run()
 
-	router := (&api.API{imageProcessor, db, checker, log, time.Minute}).Router()
-	mockStorageRouter := (&api.API{mockStorageImageProcessor, db, mockChecker, log, time.Minute}).Router()
-	mockProcessorRouter := (&api.API{&mockProcessor.Processor{}, db, checker, log, time.Minute}).Router()
-	mockDatabaseRouter := (&api.API{imageProcessor, &mockDatabase.Provider{}, checker, log, time.Minute}).Router()
+	router := (&api.API{ImageProcessor: imageProcessor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	mockStorageRouter := (&api.API{ImageProcessor: mockStorageImageProcessor, Database: db, HealthChecker: mockChecker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	mockProcessorRouter := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	mockDatabaseRouter := (&api.API{ImageProcessor: imageProcessor, Database: &mockDatabase.Provider{}, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	exhaustedAdmission := admission.New(100)
+	exhaustedAdmission.Acquire(100)
+	exhaustedAdmissionRouter := (&api.API{ImageProcessor: imageProcessor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Admission: exhaustedAdmission, Sources: imageCache, OptimizeCoding: true}).Router()
+	corruptImageRouter := (&api.API{ImageProcessor: imageProcessor, Database: corruptDB, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	gifImageRouter := (&api.API{ImageProcessor: gifImageProcessor, Database: corruptDB, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+	modTimeRouter := (&api.API{ImageProcessor: imageProcessor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), SourceModTime: storage, Sources: imageCache, OptimizeCoding: true}).Router()
+	timingRouter := (&api.API{ImageProcessor: imageProcessor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), TimingAllowOrigin: true, Sources: imageCache, OptimizeCoding: true}).Router()
 
 	tests := []struct {
 		Name             string
@@ -116,8 +129,44 @@ func TestAPI(t *testing.T) {
 		{"invalid size", "/id/1/9223372036854775808/1.jpg", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}}, // Number larger then max int size to fail int parsing
 		{"invalid size", "/id/1/5500/1.jpg", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},                // Number larger then maxImageSize to fail int parsing
 		{"invalid blur amount", "/id/1/100/100.jpg?blur=11", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
-		{"invalid blur amount", "/id/1/100/100.jpg?blur=0", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur amount", "/id/1/100/100.jpg?blur=-1", router, http.StatusBadRequest, []byte("Invalid blur amount\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		{"invalid file extension", "/id/1/100/100.png", router, http.StatusBadRequest, []byte("Invalid file extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid resize filter", "/id/1/100/100.jpg?resize-filter=bicubic", router, http.StatusBadRequest, []byte("Invalid resize filter\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"unknown filter", "/id/1/100/100.jpg?filter=vintage", router, http.StatusBadRequest, []byte("Invalid filter\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100.jpg?quality=0", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100.jpg?quality=101", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid quality", "/id/1/100/100.jpg?quality=high", router, http.StatusBadRequest, []byte("Invalid quality\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100.jpg?dpr=0.5", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100.jpg?dpr=5", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid dpr", "/id/1/100/100.jpg?dpr=retina", router, http.StatusBadRequest, []byte("Invalid dpr\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"size with dpr larger then max allowed", "/id/1/3000/3000.jpg?dpr=2", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid format", "/id/1/100/100.jpg?format=png", router, http.StatusBadRequest, []byte("Invalid format\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid colorspace", "/id/1/100/100.jpg?colorspace=adobergb", router, http.StatusBadRequest, []byte("Invalid colorspace\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid effort", "/id/1/100/100.jpg?effort=7", router, http.StatusBadRequest, []byte("Invalid effort\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid aspect ratio", "/id/1/100/100.jpg?ratio=16", router, http.StatusBadRequest, []byte("Invalid aspect ratio\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid aspect ratio", "/id/1/100/100.jpg?ratio=0:9", router, http.StatusBadRequest, []byte("Invalid aspect ratio\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid background", "/id/1/100/100.jpg?ratio=16:9&bg=red", router, http.StatusBadRequest, []byte("Invalid background color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"padded canvas larger then max allowed", "/id/1/4000/100.jpg?ratio=1:2", router, http.StatusBadRequest, []byte("Invalid size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid frame", "/id/1/100/100.jpg?frame=-1", router, http.StatusBadRequest, []byte("Invalid frame\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid frame", "/id/1/100/100.jpg?frame=last", router, http.StatusBadRequest, []byte("Invalid frame\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid trim color", "/id/1/100/100.jpg?trimcolor=fffffff", router, http.StatusBadRequest, []byte("Invalid trim color\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid trim tolerance", "/id/1/100/100.jpg?trim&trimtol=-1", router, http.StatusBadRequest, []byte("Invalid trim tolerance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid text", "/id/1/100/100.jpg?text=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", router, http.StatusBadRequest, []byte("Invalid text\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid gravity", "/id/1/100/100.jpg?text&gravity=middle", router, http.StatusBadRequest, []byte("Invalid gravity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid orientation", "/id/1/100/100.jpg?orient=-1", router, http.StatusBadRequest, []byte("Invalid orientation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend mode", "/id/1/100/100.jpg?blend=1&blendmode=darken", router, http.StatusBadRequest, []byte("Invalid blend mode\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend opacity", "/id/1/100/100.jpg?blend=1&blendopacity=1.5", router, http.StatusBadRequest, []byte("Invalid blend opacity\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blend image id", "/id/1/100/100.jpg?blend=nonexistant", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay position", "/id/1/100/100.jpg?overlay=1&overlaypos=top", router, http.StatusBadRequest, []byte("Invalid overlay position\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay size", "/id/1/100/100.jpg?overlaysize=101&overlay=1", router, http.StatusBadRequest, []byte("Invalid overlay size\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid overlay image id", "/id/1/100/100.jpg?overlay=nonexistant", router, http.StatusNotFound, []byte("Image does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid blur edge", "/id/1/100/100.jpg?blur&bluredge=clamp", router, http.StatusBadRequest, []byte("Invalid blur edge\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid saturation", "/id/1/100/100.jpg?saturation=4", router, http.StatusBadRequest, []byte("Invalid saturation\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid vibrance", "/id/1/100/100.jpg?vibrance=-101", router, http.StatusBadRequest, []byte("Invalid vibrance\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"mask without the webp extension", "/id/1/100/100.jpg?mask=1", router, http.StatusBadRequest, []byte("Mask requires the .webp extension\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid mask image id", "/id/1/100/100.webp?mask=nonexistant", router, http.StatusNotFound, []byte("Mask image nonexistant does not exist\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"conflicting params", "/id/1/100/100.jpg?gravity=north", router, http.StatusBadRequest, []byte("Conflicting params: gravity requires text or crop=ar\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"invalid effort", "/id/1/100/100.jpg?effort=fast", router, http.StatusBadRequest, []byte("Invalid effort\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		// Storage errors
 		{"Get() storage", "/id/1/100/100.jpg", mockStorageRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		// Database errors
@@ -126,6 +175,13 @@ func TestAPI(t *testing.T) {
 		{"404", "/asdf", router, http.StatusNotFound, []byte("page not found\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 		// Processor errors
 		{"processor error", "/id/1/100/100.jpg", mockProcessorRouter, http.StatusInternalServerError, []byte("Something went wrong\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"admission budget exhausted", "/id/1/100/100.jpg", exhaustedAdmissionRouter, http.StatusServiceUnavailable, []byte("Server is too busy, try again later\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate", "Retry-After": "1", "X-Queue-Depth": "1"}},
+		{"corrupt source image", "/id/corrupt/100/100.jpg", corruptImageRouter, http.StatusUnprocessableEntity, []byte("Image corrupt could not be decoded\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"source image too large", "/id/huge/100/100.jpg", corruptImageRouter, http.StatusUnprocessableEntity, []byte("Image huge is too large to process\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"source format not allowed", "/id/animated/100/100.jpg", corruptImageRouter, http.StatusUnprocessableEntity, []byte("Image animated is in a source format that isn't allowed\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"frame out of range", "/id/animated/100/100.jpg?frame=3", gifImageRouter, http.StatusBadRequest, []byte("Image animated does not have frame 3\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"frame of a still image", "/id/1/100/100.jpg?frame=1", router, http.StatusBadRequest, []byte("Image 1 does not have frame 1\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
+		{"truncated source image", "/id/truncated/100/100.jpg", corruptImageRouter, http.StatusUnprocessableEntity, []byte("Image truncated could not be decoded\n"), map[string]string{"Content-Type": "text/plain; charset=utf-8", "Cache-Control": "no-cache, no-store, must-revalidate"}},
 	}
 
 	for _, test := range tests {
@@ -164,7 +220,10 @@ func TestAPI(t *testing.T) {
 		{"/id/:id/:width/:height.jpg", "/id/1/200/120.jpg", readFixture("width_height", "jpg"), "inline; filename=\"1-200x120.jpg\"", "image/jpeg"},
 		{"/id/:id/:width/:height.jpg?blur", "/id/1/200/200.jpg?blur", readFixture("blur", "jpg"), "inline; filename=\"1-200x200-blur_5.jpg\"", "image/jpeg"},
 		{"/id/:id/:width/:height.jpg?grayscale", "/id/1/200/200.jpg?grayscale", readFixture("grayscale", "jpg"), "inline; filename=\"1-200x200-grayscale.jpg\"", "image/jpeg"},
+		{"/id/:id/:width/:height.jpg?saturation=0", "/id/1/200/200.jpg?saturation=0", readFixture("grayscale", "jpg"), "inline; filename=\"1-200x200.jpg\"", "image/jpeg"},
+		{"grayscale takes precedence over saturation", "/id/1/200/200.jpg?grayscale&saturation=2&vibrance=50", readFixture("grayscale", "jpg"), "inline; filename=\"1-200x200-grayscale.jpg\"", "image/jpeg"},
 		{"/id/:id/:width/:height.jpg?blur&grayscale", "/id/1/200/200.jpg?blur&grayscale", readFixture("all", "jpg"), "inline; filename=\"1-200x200-blur_5-grayscale.jpg\"", "image/jpeg"},
+		{"/id/:id/:width/:height.jpg?blur=0&grayscale=false", "/id/1/200/120.jpg?blur=0&grayscale=false", readFixture("width_height", "jpg"), "inline; filename=\"1-200x120.jpg\"", "image/jpeg"},
 		{"width/height larger then max allowed but same size as image", "/id/1/300/400.jpg", readFixture("max_allowed", "jpg"), "inline; filename=\"1-300x400.jpg\"", "image/jpeg"},
 		{"width/height of 0 returns original image width", "/id/1/0/0.jpg", readFixture("max_allowed", "jpg"), "inline; filename=\"1-300x400.jpg\"", "image/jpeg"},
 
@@ -207,6 +266,159 @@ func TestAPI(t *testing.T) {
 		}
 	}
 
+	t.Run("HEAD returns the headers without the body", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("HEAD", "/id/1/200/120.jpg", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("wrong content type, %#v", contentType)
+		}
+
+		if contentLength := w.Header().Get("Content-Length"); contentLength != strconv.Itoa(len(readFixture("width_height", "jpg"))) {
+			t.Errorf("wrong content length, %#v", contentLength)
+		}
+
+		if w.Body.Len() != 0 {
+			t.Errorf("unexpected body of length %d", w.Body.Len())
+		}
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		info, err := os.Stat("../../test/fixtures/file/1.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := info.ModTime().UTC()
+
+		tests := []struct {
+			Name            string
+			Router          http.Handler
+			IfModifiedSince string
+			ExpectedStatus  int
+			ExpectedLastMod string
+		}{
+			{"no header", modTimeRouter, "", http.StatusOK, modTime.Format(http.TimeFormat)},
+			{"unmodified since", modTimeRouter, modTime.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified, modTime.Format(http.TimeFormat)},
+			{"unmodified at the same second", modTimeRouter, modTime.Format(http.TimeFormat), http.StatusNotModified, modTime.Format(http.TimeFormat)},
+			{"modified since", modTimeRouter, modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, modTime.Format(http.TimeFormat)},
+			{"invalid date", modTimeRouter, "yesterday", http.StatusOK, modTime.Format(http.TimeFormat)},
+			{"storage without modification times", router, modTime.Add(time.Hour).Format(http.TimeFormat), http.StatusOK, ""},
+		}
+
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/id/1/200/120.jpg", nil)
+			if test.IfModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", test.IfModifiedSince)
+			}
+			test.Router.ServeHTTP(w, req)
+
+			if w.Code != test.ExpectedStatus {
+				t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+				continue
+			}
+
+			if lastModified := w.Header().Get("Last-Modified"); lastModified != test.ExpectedLastMod {
+				t.Errorf("%s: wrong last modified header, %#v", test.Name, lastModified)
+			}
+
+			if test.ExpectedStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("%s: unexpected body of length %d", test.Name, w.Body.Len())
+			}
+		}
+	})
+
+	t.Run("Timing-Allow-Origin is only set when configured", func(t *testing.T) {
+		for _, test := range []struct {
+			Router   http.Handler
+			Expected string
+		}{{router, ""}, {timingRouter, "*"}} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("HEAD", "/id/1/200/120.jpg", nil)
+			test.Router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("wrong response code, %#v", w.Code)
+			}
+
+			if headerValue := w.Header().Get("Timing-Allow-Origin"); headerValue != test.Expected {
+				t.Errorf("wrong header value for Timing-Allow-Origin, %#v", headerValue)
+			}
+		}
+	})
+
+	autoFormatTests := []struct {
+		Name                string
+		Accept              string
+		ExpectedContentType string
+	}{
+		{"no accept header", "", "image/jpeg"},
+		{"jpeg only", "image/jpeg", "image/jpeg"},
+		{"browser without webp", "image/png,image/*;q=0.8,*/*;q=0.5", "image/jpeg"},
+		{"browser with webp", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp"},
+	}
+
+	for _, test := range autoFormatTests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/200/120.jpg?format=auto", nil)
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type, %#v", test.Name, contentType)
+		}
+
+		if vary := w.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s: wrong vary header, %#v", test.Name, vary)
+		}
+	}
+
+	ratioTests := []struct {
+		Name                       string
+		URL                        string
+		ExpectedWidth              int
+		ExpectedHeight             int
+		ExpectedContentDisposition string
+	}{
+		{"landscape ratio pads the sides", "/id/1/200/200.jpg?ratio=16:9", 356, 200, "inline; filename=\"1-356x200.jpg\""},
+		{"portrait ratio pads the top and bottom", "/id/1/200/200.jpg?ratio=9:16&bg=000", 200, 356, "inline; filename=\"1-200x356.jpg\""},
+		{"wide image with landscape ratio pads the top and bottom", "/id/1/400/100.jpg?ratio=16:9", 400, 225, "inline; filename=\"1-400x225.jpg\""},
+		{"image already matching the ratio is unchanged", "/id/1/160/90.jpg?ratio=16:9", 160, 90, "inline; filename=\"1-160x90.jpg\""},
+	}
+
+	for _, test := range ratioTests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentDisposition := w.Header().Get("Content-Disposition"); contentDisposition != test.ExpectedContentDisposition {
+			t.Errorf("%s: wrong content disposition header, %#v", test.Name, contentDisposition)
+		}
+
+		config, err := jpeg.DecodeConfig(w.Body)
+		if err != nil {
+			t.Errorf("%s: error decoding image, %s", test.Name, err)
+			continue
+		}
+
+		if config.Width != test.ExpectedWidth || config.Height != test.ExpectedHeight {
+			t.Errorf("%s: wrong image size, %dx%d", test.Name, config.Width, config.Height)
+		}
+	}
+
 	redirectTests := []struct {
 		Name        string
 		URL         string
@@ -245,3 +457,49 @@ func readFile(path string) []byte {
 	fixture, _ := ioutil.ReadFile(path)
 	return fixture
 }
+
+// fixtures are the dependencies that the tests create an API with, backed by the images in test/fixtures/file
+type fixtures struct {
+	Ctx      context.Context
+	Log      *logger.Logger
+	Storage  *fileStorage.Provider
+	Database *fileDatabase.Provider
+	Sources  *image.Cache
+	Checker  *health.Checker
+	cancel   context.CancelFunc
+}
+
+// newFixtures loads the images of the metadata file in test/fixtures/file, and runs the health checker on them
+// Close stops the health checker once the test is done
+func newFixtures(metadata string) *fixtures {
+	ctx, cancel := context.WithCancel(context.Background())
+	log := logger.New(zap.FatalLevel)
+
+	storage, _ := fileStorage.New("../../test/fixtures/file")
+	db, _ := fileDatabase.New("../../test/fixtures/file/" + metadata)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Storage:  storage,
+		Database: db,
+		Cache:    memoryCache.New(),
+		Log:      log,
+	}
+	checker.Run()
+
+	return &fixtures{
+		Ctx:      ctx,
+		Log:      log,
+		Storage:  storage,
+		Database: db,
+		Sources:  image.NewCache(memoryCache.New(), storage),
+		Checker:  checker,
+		cancel:   cancel,
+	}
+}
+
+// Close stops the health checker and flushes the logger
+func (f *fixtures) Close() {
+	f.cancel()
+	f.Log.Sync()
+}
@@ -0,0 +1,363 @@
+package imageapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/cache/lru"
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// DefaultAsyncQueue is how many async jobs can wait for a worker by default
+const DefaultAsyncQueue = 100
+
+// DefaultAsyncCacheSize is how many bytes of processed images the async jobs keep by default
+const DefaultAsyncCacheSize = 256 << 20
+
+// asyncJobRetention is how long finished jobs and the images they processed are kept, for the clients that poll them late
+const asyncJobRetention = time.Hour
+
+// asyncRetryAfter is how many seconds the clients are asked to wait between polling a pending job
+const asyncRetryAfter = "1"
+
+// asyncPending is the status of jobs that are waiting for a worker, or being processed
+const asyncPending = "pending"
+
+// AsyncJobs processes the images of async requests in the background with a fixed amount of workers, and keeps them in the output cache
+// Requests for an image that a job has processed are served from the output cache, rather than processing it again
+// The output cache is its own, bounded by size and by the retention of the jobs, so that the processed images don't fill up the shared cache
+type AsyncJobs struct {
+	ctx   context.Context
+	cache cache.Provider
+	queue chan *asyncJob
+	mutex sync.Mutex
+	jobs  map[string]*asyncJob
+}
+
+// asyncJob is the processing of a single image in the background
+type asyncJob struct {
+	id       string
+	location string
+	process  func(ctx context.Context) *handler.Error
+	done     bool
+	err      *handler.Error
+}
+
+// asyncJobResponse is the status of an async job, returned while it's pending
+type asyncJobResponse struct {
+	Job    string `json:"job"`
+	Status string `json:"status"`
+	URL    string `json:"url"`
+}
+
+// NewAsyncJobs creates a new AsyncJobs that keeps up to cacheSize bytes of processed images, and starts the workers that process the jobs until the context is done
+// Up to queue jobs wait for one of the workers, further async requests are rejected until there's room again
+func NewAsyncJobs(ctx context.Context, workers int, queue int, cacheSize int64) *AsyncJobs {
+	jobs := &AsyncJobs{
+		ctx:   ctx,
+		cache: lru.New(cacheSize, asyncJobRetention),
+		queue: make(chan *asyncJob, queue),
+		jobs:  make(map[string]*asyncJob),
+	}
+
+	for i := 0; i < workers; i++ {
+		go jobs.work()
+	}
+
+	return jobs
+}
+
+// work processes the queued jobs one at a time
+func (a *AsyncJobs) work() {
+	for {
+		select {
+		case job := <-a.queue:
+			err := job.process(a.ctx)
+			a.finish(job, err)
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// submit queues the job, unless one with the same id is pending, in which case that one is returned instead
+// It returns false when the queue is full
+func (a *AsyncJobs) submit(job *asyncJob) (*asyncJob, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Finished jobs are processed again, as jobs are only submitted when their image isn't in the output cache,
+	// either because it failed, and the error may not last, or because the image has been evicted
+	if existing, ok := a.jobs[job.id]; ok && !existing.done {
+		return existing, true
+	}
+
+	select {
+	case a.queue <- job:
+	default:
+		return nil, false
+	}
+
+	a.jobs[job.id] = job
+	return job, true
+}
+
+// finish records the outcome of the job, and keeps it around for a while for the clients that poll it
+func (a *AsyncJobs) finish(job *asyncJob, err *handler.Error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	job.done = true
+	job.err = err
+
+	time.AfterFunc(asyncJobRetention, func() {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+
+		// The job may have been replaced by a retry since
+		if a.jobs[job.id] == job {
+			delete(a.jobs, job.id)
+		}
+	})
+}
+
+// status returns whether the job is done, and the error it failed with if any
+func (a *AsyncJobs) status(id string) (job *asyncJob, done bool, err *handler.Error, ok bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	job, ok = a.jobs[id]
+	if !ok {
+		return nil, false, nil, false
+	}
+
+	return job, job.done, job.err, true
+}
+
+// result returns the extension and the image that a job processed for the key, if it's in the output cache
+func (a *AsyncJobs) result(key string) (string, []byte, bool, error) {
+	data, err := a.cache.Get(outputKey(key))
+	if err == cache.ErrNotFound {
+		return "", nil, false, nil
+	} else if err != nil {
+		return "", nil, false, err
+	}
+
+	// The extension is stored in front of the image, as format=auto can pick any of the formats
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return "", nil, false, nil
+	}
+
+	return string(data[:i]), data[i+1:], true, nil
+}
+
+// store stores the image that a job processed for the key in the output cache, along with its extension
+func (a *AsyncJobs) store(key string, extension string, processedImage []byte) error {
+	data := make([]byte, 0, len(extension)+1+len(processedImage))
+	data = append(data, extension...)
+	data = append(data, '\n')
+	data = append(data, processedImage...)
+
+	return a.cache.Set(outputKey(key), data)
+}
+
+// outputKey returns the key of the output cache for the key of a job
+func outputKey(key string) string {
+	return "output:" + key
+}
+
+// asyncKey returns the key that identifies the image of a request, which async jobs are processed and cached by
+// It covers the params of the request as well as the task, which some request headers change, such as Save-Data,
+// and the formats the client accepts when format=auto picks between them
+func asyncKey(r *http.Request, p *params.Params, databaseImage *database.Image, task *image.Task) string {
+	key := params.CacheKey(databaseImage.ID, p) + "\n" + task.Key()
+	if p.AutoFormat {
+		for _, format := range acceptedFormats(r) {
+			key += "\n" + formatName(format)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// asyncImage serves the image from the output cache when a job has processed it, or starts a job to process it for async requests
+// It returns false when the request is left to be processed as usual
+func (a *API) asyncImage(w http.ResponseWriter, r *http.Request, imageID string, p *params.Params, databaseImage *database.Image, width int, height int, task *image.Task) (*handler.Error, bool) {
+	key := asyncKey(r, p, databaseImage, task)
+	extension, processedImage, ok, err := a.AsyncJobs.result(key)
+	if err != nil {
+		a.logError(r, "error getting async job result from cache", err)
+	}
+
+	if !ok && !p.Async {
+		return nil, false
+	}
+
+	// The response depends on the formats the client accepts
+	if p.AutoFormat {
+		w.Header().Add("Vary", "Accept")
+	}
+
+	// Async requests for an image that has been processed already are redirected to it, like a finished job
+	if ok && p.Async {
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		http.Redirect(w, r, a.imageLocation(r), http.StatusSeeOther)
+		return nil, true
+	}
+
+	if ok {
+		p.Extension = extension
+		return a.writeImage(w, r, imageID, p, databaseImage, width, height, processedImage, false, false), true
+	}
+
+	// Identical requests get the same job, so that the image is only processed once
+	job, ok := a.AsyncJobs.submit(&asyncJob{
+		id:       key[:32],
+		location: a.imageLocation(r),
+		process:  a.processAsync(r, key, p, databaseImage, task),
+	})
+	if !ok {
+		w.Header().Set("Retry-After", asyncRetryAfter)
+		return &handler.Error{Message: "Server is too busy, try again later", Code: http.StatusServiceUnavailable}, true
+	}
+
+	return a.writeAsyncPending(w, r, job), true
+}
+
+// processAsync returns the processing of a job, which processes the image like a request would, and stores it in the output cache
+// The jobs aren't in a hurry, so they're neither degraded under load nor fall back to cheaper encodes
+// The request is only used for logging and the formats the client accepts, as the job runs after the request has finished
+func (a *API) processAsync(r *http.Request, key string, p *params.Params, databaseImage *database.Image, task *image.Task) func(ctx context.Context) *handler.Error {
+	return func(ctx context.Context) *handler.Error {
+		if p.AutoQuality {
+			quality, err := a.autoQuality(ctx, r, task)
+			if err != nil {
+				return a.processingError(r, databaseImage, p, err)
+			}
+
+			task.Quality(quality)
+		}
+
+		if p.AutoLossless && (task.OutputFormat == image.WebP || p.AutoFormat) {
+			lossless, err := a.autoLossless(ctx, r, task)
+			if err != nil {
+				return a.processingError(r, databaseImage, p, err)
+			}
+
+			if lossless {
+				task.Lossless()
+			}
+		}
+
+		var processedImage []byte
+		var err error
+		extension := p.Extension
+		if p.AutoFormat {
+			var format image.OutputFormat
+			format, processedImage, err = a.processAutoFormat(ctx, r, task, a.presetQualities(p, false))
+			extension = format.Extension()
+		} else {
+			processedImage, err = a.ImageProcessor.ProcessImage(ctx, task)
+		}
+
+		if err != nil {
+			return a.processingError(r, databaseImage, p, err)
+		}
+
+		if err := a.AsyncJobs.store(key, extension, processedImage); err != nil {
+			a.logError(r, "error caching async job result", err)
+			return handler.InternalServerError()
+		}
+
+		return nil
+	}
+}
+
+// asyncJobHandler returns the status of an async job while it's pending, and redirects to the image once it's been processed
+// Jobs that failed return the error that the request for the image would have
+func (a *API) asyncJobHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	job, done, err, ok := a.AsyncJobs.status(mux.Vars(r)["job"])
+	if !ok {
+		return &handler.Error{Message: "Job not found", Code: http.StatusNotFound}
+	}
+
+	if !done {
+		return a.writeAsyncPending(w, r, job)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	http.Redirect(w, r, job.location, http.StatusSeeOther)
+	return nil
+}
+
+// writeAsyncPending responds that the job is pending, with the url to poll in the Location header and the body
+func (a *API) writeAsyncPending(w http.ResponseWriter, r *http.Request, job *asyncJob) *handler.Error {
+	jobURL := a.signedURL(a.BasePath+"/jobs/"+job.id, "")
+	data, err := json.Marshal(asyncJobResponse{
+		Job:    job.id,
+		Status: asyncPending,
+		URL:    jobURL,
+	})
+	if err != nil {
+		a.logError(r, "error encoding async job response", err)
+		return handler.InternalServerError()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Location", jobURL)
+	w.Header().Set("Retry-After", asyncRetryAfter)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(append(data, '\n'))
+
+	return nil
+}
+
+// imageLocation returns the url of the image of an async request, which is the same request without async
+// The rest of the query is kept as it was written
+func (a *API) imageLocation(r *http.Request) string {
+	var kept []string
+	for _, param := range strings.Split(r.URL.RawQuery, "&") {
+		name := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); param == "" || (err == nil && unescaped == "async") {
+			continue
+		}
+
+		kept = append(kept, param)
+	}
+
+	return a.signedURL(r.URL.Path, strings.Join(kept, "&"))
+}
+
+// signedURL returns the url of the path under the base path and the query, with the signature in front of the path when signing is enabled
+func (a *API) signedURL(path string, rawQuery string) string {
+	if a.Signer != nil {
+		query, _ := url.ParseQuery(rawQuery)
+		path = a.BasePath + a.Signer.SignPath(strings.TrimPrefix(path, a.BasePath), query)
+	}
+
+	if rawQuery == "" {
+		return path
+	}
+
+	return path + "?" + rawQuery
+}
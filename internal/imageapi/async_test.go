@@ -0,0 +1,272 @@
+package imageapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/signature"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// failingProcessor fails to process every image, as if the source was too large
+type failingProcessor struct{}
+
+func (p *failingProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	return nil, image.ErrSourceTooLarge
+}
+
+// asyncJob is the status of a pending async job
+type asyncJob struct {
+	Job    string `json:"job"`
+	Status string `json:"status"`
+	URL    string `json:"url"`
+}
+
+func TestAsync(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &gatedProcessor{gate: make(chan struct{})}
+	jobs := api.NewAsyncJobs(f.Ctx, 1, 10, api.DefaultAsyncCacheSize)
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AsyncJobs: jobs}).Router()
+
+	// get requests the url, giving up on requests that would wait for the gate
+	get := func(router http.Handler, url string) *httptest.ResponseRecorder {
+		reqCtx, reqCancel := context.WithTimeout(context.Background(), time.Second)
+		defer reqCancel()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req.WithContext(reqCtx))
+		return w
+	}
+
+	// pending checks that the response is a pending job, and returns it
+	pending := func(t *testing.T, w *httptest.ResponseRecorder) asyncJob {
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		var job asyncJob
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("invalid json %s", w.Body.String())
+		}
+
+		if job.Status != "pending" || job.URL != "/jobs/"+job.Job || len(job.Job) != 32 {
+			t.Errorf("wrong job %#v", job)
+		}
+
+		if location := w.Header().Get("Location"); location != job.URL {
+			t.Errorf("wrong location %s", location)
+		}
+
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+			t.Errorf("wrong retry after %s", retryAfter)
+		}
+
+		return job
+	}
+
+	// finished polls the job until it's no longer pending
+	finished := func(t *testing.T, router http.Handler, url string) *httptest.ResponseRecorder {
+		for i := 0; i < 100; i++ {
+			w := get(router, url)
+			if w.Code != http.StatusAccepted {
+				return w
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Fatal("job never finished")
+		return nil
+	}
+
+	t.Run("processes the image in the background", func(t *testing.T) {
+		job := pending(t, get(router, "/id/1/100/100.jpg?async=1&grayscale"))
+
+		// The job is pending until the processor is let through
+		pending(t, get(router, job.URL))
+
+		// Identical requests get the same job
+		if again := pending(t, get(router, "/id/1/100/100.jpg?grayscale&async=1")); again.Job != job.Job {
+			t.Errorf("wrong job for an identical request %s", again.Job)
+		}
+
+		processor.gate <- struct{}{}
+
+		w := finished(t, router, job.URL)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("wrong response code once finished, %#v", w.Code)
+		}
+
+		location := w.Header().Get("Location")
+		if location != "/id/1/100/100.jpg?grayscale" {
+			t.Fatalf("wrong location %s", location)
+		}
+
+		// The image is served from the output cache, without processing it again
+		w = get(router, location)
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code for the image, %#v", w.Code)
+		}
+
+		if body := w.Body.String(); body != "100x100.jpg" {
+			t.Errorf("wrong image %s", body)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		// Async requests for the processed image are redirected to it right away
+		w = get(router, "/id/1/100/100.jpg?async=1&grayscale")
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != location {
+			t.Errorf("wrong response for a processed image, %#v %s", w.Code, w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("processes other params as another job", func(t *testing.T) {
+		job := pending(t, get(router, "/id/1/100/100.jpg?async=1&blur=5"))
+		grayscale := pending(t, get(router, "/id/1/100/100.jpg?async=1&blur=5&grayscale"))
+		if job.Job == grayscale.Job {
+			t.Errorf("same job for other params %s", job.Job)
+		}
+
+		processor.gate <- struct{}{}
+		processor.gate <- struct{}{}
+
+		for _, job := range []asyncJob{job, grayscale} {
+			if w := finished(t, router, job.URL); w.Code != http.StatusSeeOther {
+				t.Errorf("wrong response code once finished, %#v", w.Code)
+			}
+		}
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		if w := get(router, "/jobs/unknown"); w.Code != http.StatusNotFound {
+			t.Errorf("wrong response code, %#v", w.Code)
+		}
+	})
+
+	t.Run("returns the error of failed jobs", func(t *testing.T) {
+		failingJobs := api.NewAsyncJobs(f.Ctx, 1, 10, api.DefaultAsyncCacheSize)
+		failingRouter := (&api.API{ImageProcessor: &failingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AsyncJobs: failingJobs}).Router()
+
+		job := pending(t, get(failingRouter, "/id/1/100/100.jpg?async=1"))
+		w := finished(t, failingRouter, job.URL)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if body := w.Body.String(); body != "Image 1 is too large to process\n" {
+			t.Errorf("wrong response %s", body)
+		}
+	})
+
+	t.Run("rejects jobs once the queue is full", func(t *testing.T) {
+		// Without workers, the jobs stay in the queue
+		queuedJobs := api.NewAsyncJobs(f.Ctx, 0, 1, api.DefaultAsyncCacheSize)
+		queuedRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AsyncJobs: queuedJobs}).Router()
+
+		pending(t, get(queuedRouter, "/id/1/100/100.jpg?async=1"))
+
+		w := get(queuedRouter, "/id/1/200/200.jpg?async=1")
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("wrong response code, %#v", w.Code)
+		}
+
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+			t.Errorf("wrong retry after %s", retryAfter)
+		}
+	})
+
+	t.Run("signs the urls", func(t *testing.T) {
+		signer := &signature.Signer{Key: []byte("secret")}
+		signedJobs := api.NewAsyncJobs(f.Ctx, 1, 10, api.DefaultAsyncCacheSize)
+		signedRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, Signer: signer, AsyncJobs: signedJobs}).Router()
+
+		query := url.Values{"async": {"1"}, "blur": {"2"}}
+		w := get(signedRouter, signer.SignPath("/id/1/100/100.jpg", query)+"?"+query.Encode())
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		jobURL := w.Header().Get("Location")
+		if !strings.HasPrefix(jobURL, signature.Prefix) {
+			t.Fatalf("unsigned job url %s", jobURL)
+		}
+
+		processor.gate <- struct{}{}
+
+		w = finished(t, signedRouter, jobURL)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("wrong response code once finished, %#v", w.Code)
+		}
+
+		w = get(signedRouter, w.Header().Get("Location"))
+		if w.Code != http.StatusOK || w.Body.String() != "100x100.jpg" {
+			t.Errorf("wrong response for the image, %#v %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("evicts the oldest images", func(t *testing.T) {
+		// The output cache only fits a single processed image, along with its key
+		boundedJobs := api.NewAsyncJobs(f.Ctx, 1, 10, 100)
+		boundedRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AsyncJobs: boundedJobs}).Router()
+
+		for _, url := range []string{"/id/1/100/100.jpg?async=1", "/id/1/200/200.jpg?async=1"} {
+			job := pending(t, get(boundedRouter, url))
+			processor.gate <- struct{}{}
+
+			if w := finished(t, boundedRouter, job.URL); w.Code != http.StatusSeeOther {
+				t.Fatalf("wrong response code once finished, %#v", w.Code)
+			}
+		}
+
+		// The newest image is still there
+		if w := get(boundedRouter, "/id/1/200/200.jpg?async=1"); w.Code != http.StatusSeeOther {
+			t.Errorf("wrong response code for the newest image, %#v", w.Code)
+		}
+
+		// While the oldest one has been evicted, and is processed again
+		job := pending(t, get(boundedRouter, "/id/1/100/100.jpg?async=1"))
+		processor.gate <- struct{}{}
+
+		w := finished(t, boundedRouter, job.URL)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("wrong response code once processed again, %#v", w.Code)
+		}
+
+		w = get(boundedRouter, w.Header().Get("Location"))
+		if w.Code != http.StatusOK || w.Body.String() != "100x100.jpg" {
+			t.Errorf("wrong response for the image, %#v %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabledRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+		w := get(disabledRouter, "/id/1/100/100.jpg?async=1")
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if body := w.Body.String(); body != "Async processing is disabled\n" {
+			t.Errorf("wrong response %s", body)
+		}
+
+		if w := get(disabledRouter, "/jobs/unknown"); w.Code != http.StatusNotFound {
+			t.Errorf("wrong response code for a job, %#v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package imageapi
+
+import (
+	"context"
+	goimage "image"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// DefaultAutoLosslessThreshold is the share of the pixels that the main colors of an image have to cover for lossless=auto to encode it losslessly
+const DefaultAutoLosslessThreshold = 0.9
+
+const (
+	classifySize   = 64 // The size that the image is scaled down to fit within before classifying it
+	classifyColors = 16 // How many of the most common colors are counted towards the threshold
+)
+
+// autoLossless returns whether to encode the image of the task losslessly, which is the case for graphics with large areas of flat colors
+// Photos compress better lossy, and the decision only depends on the source image, so it's cached per image id
+func (a *API) autoLossless(ctx context.Context, r *http.Request, task *image.Task) (bool, error) {
+	key := "lossless:" + task.ImageID
+	if data, err := a.FormatCache.Get(key); err == nil {
+		if lossless, err := strconv.ParseBool(string(data)); err == nil {
+			return lossless, nil
+		}
+	} else if err != cache.ErrNotFound {
+		a.logError(r, "error getting lossless decision from cache", err)
+	}
+
+	threshold := a.LosslessThreshold
+	if threshold == 0 {
+		threshold = DefaultAutoLosslessThreshold
+	}
+
+	// Go can only decode JPEG, so classify a high quality JPEG of the scaled down image
+	classifyTask := image.NewTask(task.ImageID, classifySize, classifySize, "", image.JPEG).Fit(image.Contain).Quality(95)
+	processedImage, err := a.ImageProcessor.ProcessImage(ctx, classifyTask)
+	if err != nil {
+		return false, err
+	}
+
+	decoded, err := decodeJpeg(processedImage, nil)
+	if err != nil {
+		return false, err
+	}
+
+	lossless := isGraphic(decoded, threshold)
+	if err := a.FormatCache.Set(key, []byte(strconv.FormatBool(lossless))); err != nil {
+		a.logError(r, "error caching lossless decision", err)
+	}
+
+	return lossless, nil
+}
+
+// isGraphic returns whether the most common colors of the image cover at least the threshold of its pixels
+// The colors are reduced to 4 bits per channel first, so that the noise of the JPEG encode doesn't split up flat areas
+func isGraphic(img goimage.Image, threshold float64) bool {
+	bounds := img.Bounds()
+	pixels := bounds.Dx() * bounds.Dy()
+	if pixels == 0 {
+		return false
+	}
+
+	counts := make(map[uint32]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			counts[(r>>12)<<8|(g>>12)<<4|b>>12]++
+		}
+	}
+
+	sorted := make([]int, 0, len(counts))
+	for _, count := range counts {
+		sorted = append(sorted, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	covered := 0
+	for i := 0; i < classifyColors && i < len(sorted); i++ {
+		covered += sorted[i]
+	}
+
+	return float64(covered)/float64(pixels) >= threshold
+}
@@ -0,0 +1,59 @@
+package imageapi
+
+import (
+	"bytes"
+	goimage "image"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"testing"
+)
+
+// classifiedFixture decodes a fixture, and re-encodes it the same way as the scaled down images that are classified
+func classifiedFixture(t *testing.T, path string) goimage.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	img, _, err := goimage.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeJpeg(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestIsGraphic(t *testing.T) {
+	// quadrants.jpg is a PNG with four flat colors, and plain.jpg is a photo
+	graphic := classifiedFixture(t, "../../test/fixtures/file/quadrants.jpg")
+	photo := classifiedFixture(t, "../../test/fixtures/file/plain.jpg")
+
+	if !isGraphic(graphic, DefaultAutoLosslessThreshold) {
+		t.Error("graphic is encoded lossy")
+	}
+
+	if isGraphic(photo, DefaultAutoLosslessThreshold) {
+		t.Error("photo is encoded losslessly")
+	}
+
+	// A lower threshold counts more images as graphics
+	if !isGraphic(photo, 0.05) {
+		t.Error("photo is encoded lossy with a low threshold")
+	}
+
+	if isGraphic(goimage.NewRGBA(goimage.Rect(0, 0, 0, 0)), DefaultAutoLosslessThreshold) {
+		t.Error("empty image is encoded losslessly")
+	}
+}
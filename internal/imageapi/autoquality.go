@@ -0,0 +1,201 @@
+package imageapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// DefaultAutoQualitySSIM is the structural similarity to the full quality image that quality=auto aims for by default
+const DefaultAutoQualitySSIM = 0.95
+
+const (
+	minAutoQuality       = 30
+	maxAutoQuality       = 95
+	referenceQuality     = 100
+	ssimWindowSize       = 8
+	maxAutoQualitySteps  = 5           // The max amount of encodes the search takes besides the reference, which settles within 2 of the lowest quality
+	autoQualityProxySize = 512         // The size that the image is scaled down to fit within while searching for the quality
+	maxAutoQualityPixels = 2048 * 2048 // Images with more pixels than this keep the quality of the task, as even the scaled down search is too slow for them
+)
+
+// autoQuality returns the lowest quality where the image of the task still has the configured ssim compared to the full quality image
+// Finding it takes several encodes, so they're of the image scaled down, and the decision is cached per task
+func (a *API) autoQuality(ctx context.Context, r *http.Request, task *image.Task) (int, error) {
+	if task.Width*task.Height > maxAutoQualityPixels {
+		return task.EncodeQuality, nil
+	}
+
+	// Go can only decode JPEG, so search using JPEG encodes, and use the same quality for the other formats
+	search := *task
+	search.OutputFormat = image.JPEG
+	search.EncodeQuality = 0
+
+	key := "quality:" + search.Key()
+	if data, err := a.FormatCache.Get(key); err == nil {
+		if quality, err := strconv.Atoi(string(data)); err == nil {
+			return quality, nil
+		}
+	} else if err != cache.ErrNotFound {
+		a.logError(r, "error getting quality decision from cache", err)
+	}
+
+	target := a.AutoQualitySSIM
+	if target == 0 {
+		target = DefaultAutoQualitySSIM
+	}
+
+	proxy := proxyTask(search)
+	quality, err := findQuality(func(quality int) ([]byte, error) {
+		encodeTask := proxy
+		return a.ImageProcessor.ProcessImage(ctx, encodeTask.Quality(quality))
+	}, target)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := a.FormatCache.Set(key, []byte(strconv.Itoa(quality))); err != nil {
+		a.logError(r, "error caching quality decision", err)
+	}
+
+	return quality, nil
+}
+
+// proxyTask returns the task scaled down to fit within autoQualityProxySize, along with the sizes of its effects in pixels
+// How much the quality degrades the image mostly depends on its content rather than its size, so the scaled down image is searched instead
+func proxyTask(task image.Task) image.Task {
+	width, height := task.Width, task.Height
+	if task.ApplyPad {
+		width, height = task.CanvasWidth, task.CanvasHeight
+	}
+
+	longest := width
+	if height > longest {
+		longest = height
+	}
+
+	if longest <= autoQualityProxySize {
+		return task
+	}
+
+	scale := float64(autoQualityProxySize) / float64(longest)
+	scaleSize := func(size int) int {
+		if size == 0 {
+			return 0
+		}
+
+		return int(math.Max(1, math.Round(float64(size)*scale)))
+	}
+
+	scaleRegion := func(region image.Region) image.Region {
+		return image.Region{Left: int(math.Round(float64(region.Left) * scale)), Top: int(math.Round(float64(region.Top) * scale)), Width: scaleSize(region.Width), Height: scaleSize(region.Height)}
+	}
+
+	task.Width, task.Height = scaleSize(task.Width), scaleSize(task.Height)
+	task.CanvasWidth, task.CanvasHeight = scaleSize(task.CanvasWidth), scaleSize(task.CanvasHeight)
+	task.BlurAmount *= scale
+	task.SharpenSigma *= scale
+	task.BlurArea = scaleRegion(task.BlurArea)
+	task.InvertArea = scaleRegion(task.InvertArea)
+	return task
+}
+
+// findQuality binary searches for the lowest quality where the JPEG returned by encode has at least the target ssim compared to the reference quality
+// The ssim increases with the quality, so the search settles on the boundary where it crosses the target
+// It stops after maxAutoQualitySteps encodes, at the lowest quality that's known to reach the target
+func findQuality(encode func(quality int) ([]byte, error), target float64) (int, error) {
+	reference, err := decodeJpeg(encode(referenceQuality))
+	if err != nil {
+		return 0, err
+	}
+
+	low, high := minAutoQuality, maxAutoQuality
+	for step := 0; low < high && step < maxAutoQualitySteps; step++ {
+		quality := (low + high) / 2
+
+		candidate, err := decodeJpeg(encode(quality))
+		if err != nil {
+			return 0, err
+		}
+
+		if ssim(reference, candidate) >= target {
+			high = quality
+		} else {
+			low = quality + 1
+		}
+	}
+
+	return high, nil
+}
+
+func decodeJpeg(buffer []byte, err error) (goimage.Image, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(buffer))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encoded image: %s", err)
+	}
+
+	return decoded, nil
+}
+
+// ssim returns the mean structural similarity of the luma of two images of the same size, over non-overlapping windows
+// It's a coarse version of SSIM, which is enough to compare encodes of the same image
+func ssim(a goimage.Image, b goimage.Image) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	bounds := a.Bounds()
+	offset := b.Bounds().Min.Sub(bounds.Min)
+
+	var total float64
+	var windows int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += ssimWindowSize {
+		for x := bounds.Min.X; x < bounds.Max.X; x += ssimWindowSize {
+			var sumA, sumB, sumAA, sumBB, sumAB, n float64
+			for wy := y; wy < y+ssimWindowSize && wy < bounds.Max.Y; wy++ {
+				for wx := x; wx < x+ssimWindowSize && wx < bounds.Max.X; wx++ {
+					la := luma(a.At(wx, wy))
+					lb := luma(b.At(wx+offset.X, wy+offset.Y))
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+					n++
+				}
+			}
+
+			meanA, meanB := sumA/n, sumB/n
+			varianceA := sumAA/n - meanA*meanA
+			varianceB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varianceA + varianceB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+
+	return total / float64(windows)
+}
+
+func luma(c color.Color) float64 {
+	return float64(color.GrayModel.Convert(c).(color.Gray).Y)
+}
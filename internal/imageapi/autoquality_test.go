@@ -0,0 +1,181 @@
+package imageapi
+
+import (
+	"bytes"
+	"context"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/cache/memory"
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+func jpegEncoder(img goimage.Image) func(quality int) ([]byte, error) {
+	return func(quality int) ([]byte, error) {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		return buf.Bytes(), err
+	}
+}
+
+func TestFindQuality(t *testing.T) {
+	simple := goimage.NewGray(goimage.Rect(0, 0, 256, 256))
+	detailed := goimage.NewGray(goimage.Rect(0, 0, 256, 256))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			simple.SetGray(x, y, color.Gray{uint8(x / 2)})
+			detailed.SetGray(x, y, color.Gray{uint8(random.Intn(256))})
+		}
+	}
+
+	simpleQuality, err := findQuality(jpegEncoder(simple), DefaultAutoQualitySSIM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detailedQuality, err := findQuality(jpegEncoder(detailed), DefaultAutoQualitySSIM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if simpleQuality >= detailedQuality {
+		t.Errorf("simple image got quality %d, detailed image got quality %d", simpleQuality, detailedQuality)
+	}
+
+	if simpleQuality < minAutoQuality || detailedQuality > maxAutoQuality {
+		t.Errorf("quality outside of the search range, %d %d", simpleQuality, detailedQuality)
+	}
+
+	// A higher target needs a higher quality
+	strictQuality, err := findQuality(jpegEncoder(detailed), 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strictQuality < detailedQuality {
+		t.Errorf("stricter target got a lower quality, %d < %d", strictQuality, detailedQuality)
+	}
+
+	// The search is bounded, besides the encode of the reference
+	encodes := 0
+	_, err = findQuality(func(quality int) ([]byte, error) {
+		encodes++
+		return jpegEncoder(detailed)(quality)
+	}, DefaultAutoQualitySSIM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if encodes > maxAutoQualitySteps+1 {
+		t.Errorf("searching took %d encodes", encodes)
+	}
+}
+
+// sizedProcessor encodes a gradient of the size of the task as a JPEG of its quality, and records the tasks
+type sizedProcessor struct {
+	tasks []image.Task
+}
+
+func (p *sizedProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	p.tasks = append(p.tasks, *task)
+
+	img := goimage.NewGray(goimage.Rect(0, 0, task.Width, task.Height))
+	for y := 0; y < task.Height; y++ {
+		for x := 0; x < task.Width; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x + y)})
+		}
+	}
+
+	return jpegEncoder(img)(task.EncodeQuality)
+}
+
+func TestAutoQuality(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/id/1/2000/1000.jpg?quality=auto", nil)
+
+	t.Run("searches a scaled down image", func(t *testing.T) {
+		processor := &sizedProcessor{}
+		a := &API{ImageProcessor: processor, FormatCache: memory.New()}
+
+		task := image.NewTask("1", 2000, 1000, "", image.JPEG).Blur(10)
+		quality, err := a.autoQuality(context.Background(), req, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if quality < minAutoQuality || quality > maxAutoQuality {
+			t.Errorf("quality outside of the search range %d", quality)
+		}
+
+		if len(processor.tasks) == 0 || len(processor.tasks) > maxAutoQualitySteps+1 {
+			t.Fatalf("wrong amount of encodes %d", len(processor.tasks))
+		}
+
+		for _, searched := range processor.tasks {
+			if searched.Width != 512 || searched.Height != 256 || searched.BlurAmount != 2.56 {
+				t.Errorf("searched the wrong task %dx%d blur %f", searched.Width, searched.Height, searched.BlurAmount)
+			}
+		}
+
+		// The task itself isn't scaled down
+		if task.Width != 2000 || task.Height != 1000 || task.BlurAmount != 10 {
+			t.Errorf("scaled down the task %dx%d blur %f", task.Width, task.Height, task.BlurAmount)
+		}
+	})
+
+	t.Run("keeps the quality of large images", func(t *testing.T) {
+		processor := &sizedProcessor{}
+		a := &API{ImageProcessor: processor, FormatCache: memory.New()}
+
+		task := image.NewTask("1", 5000, 5000, "", image.JPEG).Quality(80)
+		quality, err := a.autoQuality(context.Background(), req, task)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if quality != 80 || len(processor.tasks) != 0 {
+			t.Errorf("searched a large image, quality %d with %d encodes", quality, len(processor.tasks))
+		}
+	})
+}
+
+func TestProxyTask(t *testing.T) {
+	small := *image.NewTask("1", 300, 200, "", image.JPEG)
+	if proxy := proxyTask(small); proxy != small {
+		t.Errorf("scaled a small task %#v", proxy)
+	}
+
+	// Padded images are scaled by the size of the canvas they're padded to
+	padded := *image.NewTask("1", 1000, 500, "", image.JPEG).Pad(2048, 1024, image.Color{}).BlurRegion(image.Region{Left: 100, Top: 200, Width: 400, Height: 2})
+	proxy := proxyTask(padded)
+	if proxy.Width != 250 || proxy.Height != 125 || proxy.CanvasWidth != 512 || proxy.CanvasHeight != 256 {
+		t.Errorf("wrong scaled size %dx%d on %dx%d", proxy.Width, proxy.Height, proxy.CanvasWidth, proxy.CanvasHeight)
+	}
+
+	if proxy.BlurArea != (image.Region{Left: 25, Top: 50, Width: 100, Height: 1}) {
+		t.Errorf("wrong scaled blur region %#v", proxy.BlurArea)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	img := goimage.NewGray(goimage.Rect(0, 0, 20, 20))
+	inverted := goimage.NewGray(goimage.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x * 10)})
+			inverted.SetGray(x, y, color.Gray{uint8(255 - x*10)})
+		}
+	}
+
+	if similarity := ssim(img, img); similarity < 0.9999 {
+		t.Errorf("identical images have ssim %f", similarity)
+	}
+
+	if similarity := ssim(img, inverted); similarity > 0.5 {
+		t.Errorf("inverted images have ssim %f", similarity)
+	}
+}
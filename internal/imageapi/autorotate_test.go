@@ -0,0 +1,58 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestAutoRotate(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+	noAutoRotateRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, NoAutoRotate: true}).Router()
+
+	// Orientation 0 rotates the image by its EXIF orientation, and 1 keeps it as it's stored
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		ExpectedStatus      int
+		ExpectedOrientation int
+	}{
+		{"unset", "/id/1/100/100.jpg", router, http.StatusOK, 0},
+		{"enabled", "/id/1/100/100.jpg?autorotate", router, http.StatusOK, 0},
+		{"disabled", "/id/1/100/100.jpg?autorotate=false", router, http.StatusOK, 1},
+		{"unset when disabled by default", "/id/1/100/100.jpg", noAutoRotateRouter, http.StatusOK, 1},
+		{"enabled when disabled by default", "/id/1/100/100.jpg?autorotate=true", noAutoRotateRouter, http.StatusOK, 0},
+		{"disabled when disabled by default", "/id/1/100/100.jpg?autorotate=0", noAutoRotateRouter, http.StatusOK, 1},
+		{"with orient", "/id/1/100/100.jpg?autorotate=false&orient=6", router, http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			continue
+		}
+
+		if orientation := processor.task.Orientation; orientation != test.ExpectedOrientation {
+			t.Errorf("%s: wrong orientation %d", test.Name, orientation)
+		}
+	}
+}
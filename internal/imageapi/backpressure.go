@@ -0,0 +1,15 @@
+package imageapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// backpressureHeaders tells a client that was rejected by the admission controller when to retry, and how many requests are ahead of it
+// Both are best-effort estimates, as the budget depends on the size of the requests rather than their count
+func (a *API) backpressureHeaders(w http.ResponseWriter) {
+	retryAfter := int64(math.Ceil(a.Admission.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.Header().Set("X-Queue-Depth", strconv.FormatInt(a.Admission.InFlight(), 10))
+}
@@ -0,0 +1,64 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/admission"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestBackpressure(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	tests := []struct {
+		Name               string
+		InUse              int64
+		AverageTime        time.Duration
+		ExpectedStatus     int
+		ExpectedRetryAfter string
+		ExpectedQueueDepth string
+	}{
+		{"admitted", 0, 0, http.StatusOK, "", ""},
+		{"rejected", 1000, 0, http.StatusServiceUnavailable, "1", "1"},
+		{"rejected after slow requests", 1000, 2500 * time.Millisecond, http.StatusServiceUnavailable, "3", "1"},
+	}
+
+	for _, test := range tests {
+		// Simulate a request that took the average time, and other requests being processed
+		controller := admission.New(1000)
+		if test.AverageTime > 0 {
+			controller.Acquire(1)
+			controller.Release(1, test.AverageTime)
+		}
+
+		if test.InUse > 0 {
+			controller.Acquire(test.InUse)
+		}
+
+		router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Admission: controller, Sources: f.Sources, OptimizeCoding: true}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/100/100.jpg", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != test.ExpectedRetryAfter {
+			t.Errorf("%s: wrong retry after %s", test.Name, retryAfter)
+		}
+
+		if queueDepth := w.Header().Get("X-Queue-Depth"); queueDepth != test.ExpectedQueueDepth {
+			t.Errorf("%s: wrong queue depth %s", test.Name, queueDepth)
+		}
+	}
+}
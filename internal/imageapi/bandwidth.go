@@ -0,0 +1,84 @@
+package imageapi
+
+import (
+	"strconv"
+	"sync"
+)
+
+// bandwidthBuckets are the upper bounds of the size buckets, by the longest side of the image
+var bandwidthBuckets = []int{256, 512, 1024, 2048}
+
+// Bandwidth adds up the bytes of the images that are served, by format and size, to find the variants that use the most bandwidth
+type Bandwidth struct {
+	mutex sync.Mutex
+	stats map[string]map[string]*BandwidthStats
+}
+
+// BandwidthStats contains how many images were served, and how many bytes they added up to
+type BandwidthStats struct {
+	Images int64 `json:"images"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// NewBandwidth creates a new Bandwidth
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{
+		stats: make(map[string]map[string]*BandwidthStats),
+	}
+}
+
+// record adds an image of the format and size to the stats
+func (b *Bandwidth) record(format string, width int, height int, bytes int) {
+	bucket := bandwidthBucket(width, height)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	buckets, ok := b.stats[format]
+	if !ok {
+		buckets = make(map[string]*BandwidthStats)
+		b.stats[format] = buckets
+	}
+
+	stats, ok := buckets[bucket]
+	if !ok {
+		stats = &BandwidthStats{}
+		buckets[bucket] = stats
+	}
+
+	stats.Images++
+	stats.Bytes += int64(bytes)
+}
+
+// Stats returns the images served and their bytes for each format and size bucket
+// The buckets are named after the largest size they include, such as "<=512", with the images past the largest bucket in ">2048"
+func (b *Bandwidth) Stats() map[string]map[string]BandwidthStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stats := make(map[string]map[string]BandwidthStats, len(b.stats))
+	for format, buckets := range b.stats {
+		stats[format] = make(map[string]BandwidthStats, len(buckets))
+		for bucket, bucketStats := range buckets {
+			stats[format][bucket] = *bucketStats
+		}
+	}
+
+	return stats
+}
+
+// bandwidthBucket returns the name of the size bucket for an image
+func bandwidthBucket(width int, height int) string {
+	size := width
+	if height > size {
+		size = height
+	}
+
+	for _, bucket := range bandwidthBuckets {
+		if size <= bucket {
+			return "<=" + strconv.Itoa(bucket)
+		}
+	}
+
+	return ">" + strconv.Itoa(bandwidthBuckets[len(bandwidthBuckets)-1])
+}
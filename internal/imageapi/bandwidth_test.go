@@ -0,0 +1,86 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestBandwidth(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := &logger.Logger{SugaredLogger: zap.New(core).Sugar()}
+
+	bandwidth := api.NewBandwidth()
+	router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, Bandwidth: bandwidth}).Router()
+
+	requests := []struct {
+		Method string
+		URL    string
+	}{
+		{"GET", "/id/1/300/200.jpg"},
+		{"GET", "/id/1/200/500.jpg?grayscale"},
+		{"GET", "/id/1/100/100.webp"},
+		{"GET", "/id/1/3000/100.jpg"},
+		{"HEAD", "/id/1/300/200.jpg"},
+	}
+
+	served := 0
+	for _, request := range requests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(request.Method, request.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: wrong response code, %#v", request.URL, w.Code)
+		}
+
+		served = w.Body.Len()
+	}
+
+	t.Run("logs the size of the images", func(t *testing.T) {
+		entries := logs.FilterMessage("image served").All()
+		if len(entries) != 4 {
+			t.Fatalf("wrong amount of log entries %d", len(entries))
+		}
+
+		fields := entries[1].ContextMap()
+		if fields["image-id"] != "1" || fields["format"] != "jpeg" || fields["params"] != "?grayscale" || fields["bytes"] != int64(len("image")) {
+			t.Errorf("wrong log fields %#v", fields)
+		}
+	})
+
+	t.Run("adds up the bytes by format and size", func(t *testing.T) {
+		// HEAD requests don't serve the image
+		if served != 0 {
+			t.Fatalf("served %d bytes for a HEAD request", served)
+		}
+
+		expected := map[string]map[string]api.BandwidthStats{
+			"jpeg": {
+				"<=512": {Images: 2, Bytes: 2 * int64(len("image"))},
+				">2048": {Images: 1, Bytes: int64(len("image"))},
+			},
+			"webp": {
+				"<=256": {Images: 1, Bytes: int64(len("image"))},
+			},
+		}
+
+		if stats := bandwidth.Stats(); !reflect.DeepEqual(stats, expected) {
+			t.Errorf("wrong stats %#v", stats)
+		}
+	})
+}
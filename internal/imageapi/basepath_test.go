@@ -0,0 +1,42 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestBasePath(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, BasePath: "/images"}).Router()
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{"image", "/images/id/1/100/100.jpg", http.StatusOK},
+		{"original image", "/images/id/1/original", http.StatusOK},
+		{"health", "/images/health", http.StatusOK},
+		{"metrics aren't served on the public routes", "/images/debug/vars", http.StatusNotFound},
+		{"routes outside the base path", "/id/1/100/100.jpg", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package imageapi
+
+import (
+	"bytes"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// webpBeacon is a lossless 1x1 WebP image of a fully transparent pixel
+var webpBeacon = []byte{
+	'R', 'I', 'F', 'F', 20, 0, 0, 0, 'W', 'E', 'B', 'P',
+	'V', 'P', '8', 'L', 8, 0, 0, 0,
+	0x2f,                   // The signature of lossless WebP
+	0x00, 0x00, 0x00, 0x10, // A width and height of 1, with the alpha bit set
+	0x88, 0x88, 0x08, // No transforms or color cache, and prefix codes of a single zero symbol, so every channel is 0
+}
+
+// jpegBeacon is a 1x1 JPEG image of a white pixel, as JPEG has no transparency
+var jpegBeacon = encodeJPEGBeacon()
+
+func encodeJPEGBeacon() []byte {
+	img := goimage.NewGray(goimage.Rect(0, 0, 1, 1))
+	img.SetGray(0, 0, color.Gray{Y: 255})
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+// rawBeacon returns the raw pixels of a 1x1 image in the pixel format, transparent for rgba8 and white otherwise, like the other beacons
+func rawBeacon(pixelFormat image.PixelFormat) []byte {
+	if pixelFormat == image.RGBA8 {
+		return make([]byte, pixelFormat.Channels())
+	}
+
+	return bytes.Repeat([]byte{255}, pixelFormat.Channels())
+}
+
+// writeBeacon responds with a 1x1 pixel in the format of the extension, for lazy loading placeholders and analytics
+// Every image gets the same pixel, so it's cached for as long as the images are
+func writeBeacon(w http.ResponseWriter, r *http.Request, extension string, pixelFormat string) {
+	format := getOutputFormat(extension)
+	data := jpegBeacon
+	switch format {
+	case image.WebP:
+		data = webpBeacon
+	case image.Raw:
+		data = rawBeacon(getPixelFormat(pixelFormat))
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(data)
+}
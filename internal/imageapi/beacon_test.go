@@ -0,0 +1,145 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/health"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"go.uber.org/zap"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
+)
+
+// countingStorage counts the source images that are read from it
+type countingStorage struct {
+	gets int
+}
+
+func (s *countingStorage) Get(ctx context.Context, id string) ([]byte, error) {
+	s.gets++
+	return nil, nil
+}
+
+func TestBeacon(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	storage := &countingStorage{}
+	db, _ := fileDatabase.New("../../test/fixtures/file/metadata.json")
+	imageCache := image.NewCache(memoryCache.New(), storage)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Storage:  storage,
+		Database: db,
+		Cache:    memoryCache.New(),
+		Log:      log,
+	}
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true}).Router()
+
+	get := func(t *testing.T, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=2592000" {
+			t.Errorf("wrong cache control %s", cacheControl)
+		}
+
+		return w
+	}
+
+	t.Run("returns a transparent webp pixel", func(t *testing.T) {
+		w := get(t, "/id/1/300/200.webp?beacon=1&blur")
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/webp" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		data := w.Body.Bytes()
+		if len(data) < 25 || string(data[0:4]) != "RIFF" || string(data[8:16]) != "WEBPVP8L" || data[20] != 0x2f {
+			t.Fatalf("not a lossless webp image %x", data)
+		}
+
+		if size := binary.LittleEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+			t.Errorf("wrong riff size %d", size)
+		}
+
+		// The header of the lossless bitstream is 14 bits each of the width and height minus one, followed by whether alpha is used
+		header := binary.LittleEndian.Uint32(data[21:25])
+		width, height, alpha := header&0x3fff+1, header>>14&0x3fff+1, header>>28&1
+		if width != 1 || height != 1 {
+			t.Errorf("wrong size %dx%d", width, height)
+		}
+
+		if alpha != 1 {
+			t.Error("pixel isn't transparent")
+		}
+	})
+
+	t.Run("returns a raw pixel", func(t *testing.T) {
+		w := get(t, "/id/1/300/200.bin?beacon")
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/octet-stream" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), []byte{255, 255, 255}) {
+			t.Errorf("wrong rgb8 pixel %v", w.Body.Bytes())
+		}
+
+		w = get(t, "/id/1/300/200.bin?beacon&pixelformat=rgba8")
+		if !bytes.Equal(w.Body.Bytes(), []byte{0, 0, 0, 0}) {
+			t.Errorf("wrong rgba8 pixel %v", w.Body.Bytes())
+		}
+	})
+
+	t.Run("returns a white jpeg pixel", func(t *testing.T) {
+		w := get(t, "/id/1/300/200.jpg?beacon")
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		decoded, err := jpeg.Decode(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bounds := decoded.Bounds(); bounds.Dx() != 1 || bounds.Dy() != 1 {
+			t.Errorf("wrong size %dx%d", bounds.Dx(), bounds.Dy())
+		}
+
+		if r, g, b, _ := decoded.At(0, 0).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+			t.Errorf("wrong color %d %d %d", r, g, b)
+		}
+	})
+
+	t.Run("doesn't read the image", func(t *testing.T) {
+		get(t, "/id/nonexistant/100/100.webp?beacon")
+
+		if storage.gets != 0 {
+			t.Errorf("read %d source images", storage.gets)
+		}
+
+		if processor.task != nil {
+			t.Error("processed the image")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestBlurScale(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name               string
+		URL                string
+		ExpectedBlurAmount float64
+	}{
+		{"absolute by default", "/id/1/2000/1000.jpg?blur=4", 4},
+		{"absolute small image", "/id/1/100/100.jpg?blur=4&blurscale=absolute", 4},
+		{"relative at the reference size", "/id/1/500/250.jpg?blur=4&blurscale=relative", 4},
+		{"relative large image", "/id/1/1000/2000.jpg?blur=4&blurscale=relative", 16},
+		{"relative small image", "/id/1/250/100.jpg?blur=4&blurscale=relative", 2},
+		{"relative tiny image", "/id/1/20/20.jpg?blur=4&blurscale=relative", 0.5},
+		{"relative with dpr", "/id/1/250/100.jpg?blur=4&blurscale=relative&dpr=2", 4},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.BlurAmount != test.ExpectedBlurAmount {
+			t.Errorf("%s: wrong blur amount %v", test.Name, processor.task.BlurAmount)
+		}
+	}
+
+	t.Run("clamps to the max blur ratio", func(t *testing.T) {
+		clampingRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, MaxBlurRatio: 0.01}).Router()
+
+		tests := []struct {
+			Name               string
+			URL                string
+			ExpectedBlurAmount float64
+		}{
+			{"relative large image", "/id/1/5000/4000.jpg?blur=10&blurscale=relative", 40},
+			{"absolute large image", "/id/1/5000/4000.jpg?blur=10", 10},
+			{"absolute small image", "/id/1/400/200.jpg?blur=10", 2},
+			{"absolute tiny image", "/id/1/20/20.jpg?blur=10", 0.5},
+		}
+
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", test.URL, nil)
+			clampingRouter.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+				continue
+			}
+
+			if processor.task.BlurAmount != test.ExpectedBlurAmount {
+				t.Errorf("%s: wrong blur amount %v", test.Name, processor.task.BlurAmount)
+			}
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestClampedHeaders(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	// The image is 300x400
+	tests := []struct {
+		Name               string
+		URL                string
+		NoUpscale          bool
+		ExpectedClamped    string
+		ExpectedDimensions string
+	}{
+		{"smaller than the image", "/id/1/100/100.jpg?noupscale", false, "", ""},
+		{"larger without noupscale", "/id/1/600/600.jpg", false, "", ""},
+		{"clamped", "/id/1/600/600.jpg?noupscale", false, "true", "300x300"},
+		{"clamped by the deployment", "/id/1/600/800.jpg", true, "true", "300x400"},
+		{"clamped by the dpr", "/id/1/200/200.jpg?dpr=2&noupscale", false, "true", "300x300"},
+		{"native size", "/id/1/300/400.jpg?noupscale", false, "", ""},
+		{"clamped within the padding", "/id/1/600/600.jpg?fit=pad&noupscale", false, "true", "300x300"},
+	}
+
+	for _, test := range tests {
+		router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, NoUpscale: test.NoUpscale, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if clamped := w.Header().Get("X-Image-Clamped"); clamped != test.ExpectedClamped {
+			t.Errorf("%s: wrong clamped header %q", test.Name, clamped)
+		}
+
+		if dimensions := w.Header().Get("X-Image-Dimensions"); dimensions != test.ExpectedDimensions {
+			t.Errorf("%s: wrong dimensions header %q", test.Name, dimensions)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package imageapi
+
+import (
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// contactSheetHandler returns a JPEG with evenly spaced frames of an animated image laid out in a grid
+func (a *API) contactSheetHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	sheet, err := params.GetContactSheet(r, databaseImage)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	task := image.NewTask(databaseImage.ID, sheet.CellWidth, sheet.CellHeight, "", image.JPEG).ContactSheet(sheet.Columns, sheet.Frames)
+	processedImage, err := a.ImageProcessor.ProcessImage(r.Context(), task)
+	if err != nil {
+		return a.processingError(r, databaseImage, &params.Params{}, err)
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+	w.Write(processedImage)
+
+	return nil
+}
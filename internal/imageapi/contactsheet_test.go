@@ -0,0 +1,76 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestContactSheet(t *testing.T) {
+	f := newFixtures("metadata_corrupt.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name            string
+		URL             string
+		ExpectedStatus  int
+		ExpectedColumns int
+		ExpectedFrames  int
+		ExpectedWidth   int
+		ExpectedHeight  int
+	}{
+		{"defaults", "/id/animated/contactsheet", http.StatusOK, 4, 12, 200, 267},
+		{"layout", "/id/animated/contactsheet?cols=2&frames=3&w=30", http.StatusOK, 2, 3, 30, 40},
+		{"fewer frames than columns", "/id/animated/contactsheet?cols=10&frames=2&w=500", http.StatusOK, 10, 2, 500, 667},
+		{"invalid cols", "/id/animated/contactsheet?cols=0", http.StatusBadRequest, 0, 0, 0, 0},
+		{"too many cols", "/id/animated/contactsheet?cols=11", http.StatusBadRequest, 0, 0, 0, 0},
+		{"invalid frames", "/id/animated/contactsheet?frames=abc", http.StatusBadRequest, 0, 0, 0, 0},
+		{"too many frames", "/id/animated/contactsheet?frames=101", http.StatusBadRequest, 0, 0, 0, 0},
+		{"invalid width", "/id/animated/contactsheet?w=0", http.StatusBadRequest, 0, 0, 0, 0},
+		{"too wide", "/id/animated/contactsheet?cols=4&w=1500", http.StatusBadRequest, 0, 0, 0, 0},
+		{"too tall", "/id/animated/contactsheet?cols=1&frames=10&w=500", http.StatusBadRequest, 0, 0, 0, 0},
+		{"nonexistent image", "/id/nonexistant/contactsheet", http.StatusNotFound, 0, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			if processor.task != nil {
+				t.Errorf("%s: processed the image", test.Name)
+			}
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		task := processor.task
+		if !task.ApplySheet || task.SheetColumns != test.ExpectedColumns || task.SheetFrames != test.ExpectedFrames {
+			t.Errorf("%s: wrong layout %d columns of %d frames", test.Name, task.SheetColumns, task.SheetFrames)
+		}
+
+		if task.Width != test.ExpectedWidth || task.Height != test.ExpectedHeight {
+			t.Errorf("%s: wrong cell size %dx%d", test.Name, task.Width, task.Height)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestContentDPR(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	// The image is 300x400, so without upscaling a 200x200 image at dpr=2 is limited to 300x300
+	tests := []struct {
+		Name        string
+		URL         string
+		NoUpscale   bool
+		ExpectedDPR string
+	}{
+		{"no dpr", "/id/1/100/100.jpg", false, ""},
+		{"dpr of 1", "/id/1/100/100.jpg?dpr=1", false, ""},
+		{"dpr", "/id/1/100/100.jpg?dpr=2", false, "2"},
+		{"fractional dpr", "/id/1/100/100.webp?dpr=1.5", false, "1.5"},
+		{"dpr limited by the source", "/id/1/200/200.jpg?dpr=2", true, "1.5"},
+	}
+
+	for _, test := range tests {
+		router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, NoUpscale: test.NoUpscale, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if dpr := w.Header().Get("Content-DPR"); dpr != test.ExpectedDPR {
+			t.Errorf("%s: wrong dpr %q", test.Name, dpr)
+		}
+	}
+}
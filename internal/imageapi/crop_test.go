@@ -0,0 +1,70 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestCrop(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	// The source image is 300x400
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+		ExpectedCrop   image.Region
+		ExpectedWidth  int
+		ExpectedHeight int
+	}{
+		{"no crop", "/id/1/100/100.jpg", http.StatusOK, image.Region{}, 100, 100},
+		{"pixels", "/id/1/100/100.jpg?crop=10,20,150,200", http.StatusOK, image.Region{Left: 10, Top: 20, Width: 150, Height: 200}, 100, 100},
+		{"percentages", "/id/1/100/100.jpg?crop=10%25,10%25,80%25,80%25", http.StatusOK, image.Region{Left: 30, Top: 40, Width: 240, Height: 320}, 100, 100},
+		{"fractional percentages", "/id/1/100/100.jpg?crop=0%25,0%25,33.3%25,50%25", http.StatusOK, image.Region{Left: 0, Top: 0, Width: 100, Height: 200}, 100, 100},
+		{"percentages to the edge", "/id/1/100/100.jpg?crop=50%25,50%25,50%25,50%25", http.StatusOK, image.Region{Left: 150, Top: 200, Width: 150, Height: 200}, 100, 100},
+		{"size of the crop", "/id/1/0/0.jpg?crop=25%25,0%25,50%25,100%25", http.StatusOK, image.Region{Left: 75, Top: 0, Width: 150, Height: 400}, 150, 400},
+		{"aspect ratio", "/id/1/0/0.jpg?crop=ar:16:9", http.StatusOK, image.Region{Left: 0, Top: 116, Width: 300, Height: 169}, 300, 169},
+		{"aspect ratio with gravity", "/id/1/100/100.jpg?crop=ar:1:1&gravity=south", http.StatusOK, image.Region{Left: 0, Top: 100, Width: 300, Height: 300}, 100, 100},
+		{"pixels outside of the image", "/id/1/100/100.jpg?crop=0,300,100,101", http.StatusBadRequest, image.Region{}, 0, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			if processor.task != nil {
+				t.Errorf("%s: processed the image", test.Name)
+			}
+			continue
+		}
+
+		task := processor.task
+		if task.ApplyCrop != (test.ExpectedCrop != image.Region{}) || task.CropArea != test.ExpectedCrop {
+			t.Errorf("%s: wrong crop %#v", test.Name, task.CropArea)
+		}
+
+		if task.Width != test.ExpectedWidth || task.Height != test.ExpectedHeight {
+			t.Errorf("%s: wrong size %dx%d", test.Name, task.Width, task.Height)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package imageapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// debugResponse is how a request for an image was resolved, for debugging clients
+type debugResponse struct {
+	Params   *params.Params  `json:"params"`
+	Image    *database.Image `json:"image"`
+	Width    int             `json:"width"`
+	Height   int             `json:"height"`
+	Task     *image.Task     `json:"task"`
+	Key      string          `json:"key"`
+	CacheKey string          `json:"cache_key"`
+}
+
+// debugParams responds with the resolved params, the image, the dimensions of the returned image, and the task that would process it
+// The key is the one that identical tasks are processed once for, and the cache key the one that equivalent requests share
+func (a *API) debugParams(w http.ResponseWriter, r *http.Request, p *params.Params, databaseImage *database.Image, width int, height int, task *image.Task) *handler.Error {
+	data, err := json.Marshal(debugResponse{
+		Params:   p,
+		Image:    databaseImage,
+		Width:    width,
+		Height:   height,
+		Task:     task,
+		Key:      task.Key(),
+		CacheKey: params.CacheKey(databaseImage.ID, p),
+	})
+	if err != nil {
+		a.logError(r, "error encoding debug response", err)
+		return handler.InternalServerError()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+	w.Write(append(data, '\n'))
+
+	return nil
+}
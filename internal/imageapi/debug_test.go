@@ -0,0 +1,107 @@
+package imageapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/params"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestDebugParams(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, DebugParams: true}).Router()
+	disabledRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	t.Run("responds with the resolved request", func(t *testing.T) {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/100/100.webp?blur=3&gamma=2.2&ratio=2:1&bg=000000&nearlossless=60&debug", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		if processor.task != nil {
+			t.Error("processed the image")
+		}
+
+		var response struct {
+			Params struct {
+				Width       int
+				Height      int
+				BlurAmount  int
+				Gamma       float64
+				AspectRatio params.AspectRatio
+			} `json:"params"`
+			Image struct {
+				ID     string `json:"id"`
+				Width  int    `json:"width"`
+				Height int    `json:"height"`
+			} `json:"image"`
+			Width    int        `json:"width"`
+			Height   int        `json:"height"`
+			Task     image.Task `json:"task"`
+			Key      string     `json:"key"`
+			CacheKey string     `json:"cache_key"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Params.Width != 100 || response.Params.Height != 100 || response.Params.BlurAmount != 3 || response.Params.Gamma != 2.2 || response.Params.AspectRatio != (params.AspectRatio{Width: 2, Height: 1}) {
+			t.Errorf("wrong params %#v", response.Params)
+		}
+
+		if response.Image.ID != "1" || response.Image.Width != 300 || response.Image.Height != 400 {
+			t.Errorf("wrong image %#v", response.Image)
+		}
+
+		// The image is padded to the 2:1 ratio, which is what's returned
+		if response.Width != 200 || response.Height != 100 {
+			t.Errorf("wrong dimensions %dx%d", response.Width, response.Height)
+		}
+
+		task := response.Task
+		if task.Width != 100 || task.Height != 100 || task.BlurAmount != 3 || !task.ApplyGamma || !task.ApplyPad || task.CanvasWidth != 200 || !task.EncodeLossless || task.LosslessLevel != 60 || task.OutputFormat != image.WebP {
+			t.Errorf("wrong task %#v", task)
+		}
+
+		if response.Key != task.Key() {
+			t.Errorf("wrong key %s", response.Key)
+		}
+
+		if response.CacheKey != "1/100/100.webp?blur=3&gamma=2.2&ratio=2:1&bg=000000&nearlossless=60" {
+			t.Errorf("wrong cache key %s", response.CacheKey)
+		}
+	})
+
+	t.Run("processes the image when disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/100/100.webp?debug", nil)
+		disabledRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || processor.task == nil {
+			t.Errorf("wrong response code %#v, or didn't process the image", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/webp" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package imageapi
+
+import (
+	"sync/atomic"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// degradedMaxAge is how long degraded images can be cached for, so that the full quality images are served again soon after the load goes down
+const degradedMaxAge = "public, max-age=300"
+
+// Degradation lowers the cost of processing images while the admission controller reports a high load, trading quality for throughput
+// It's a last resort before requests get rejected for being over the budget
+type Degradation struct {
+	threshold float64
+	quality   int
+	active    int32
+	degraded  int64
+}
+
+// DegradationStats contains whether the degradation is active, and how many images have been degraded
+type DegradationStats struct {
+	Active   bool  `json:"active"`
+	Degraded int64 `json:"degraded"`
+}
+
+// NewDegradation creates a new Degradation that kicks in once the share of the pixel budget in use reaches threshold
+// Degraded images are encoded with at most the given quality
+func NewDegradation(threshold float64, quality int) *Degradation {
+	return &Degradation{
+		threshold: threshold,
+		quality:   quality,
+	}
+}
+
+// check returns whether processing should be degraded at the given load, and records it in the stats
+func (d *Degradation) check(load float64) bool {
+	if load < d.threshold {
+		atomic.StoreInt32(&d.active, 0)
+		return false
+	}
+
+	atomic.StoreInt32(&d.active, 1)
+	atomic.AddInt64(&d.degraded, 1)
+	return true
+}
+
+// apply makes the task cheaper to process, by lowering the quality and using the fastest encoder settings
+// The quality is never raised above what was picked for the image
+func (d *Degradation) apply(task *image.Task) {
+	task.Quality(d.capQuality(task.EncodeQuality))
+
+	task.Effort(0)
+	task.OptimizeCoding(false)
+}
+
+// capQuality returns the quality, lowered to the quality of degraded images if it's above it
+func (d *Degradation) capQuality(quality int) int {
+	if quality > d.quality {
+		return d.quality
+	}
+
+	return quality
+}
+
+// Stats returns whether the degradation is active, and how many images have been degraded
+func (d *Degradation) Stats() DegradationStats {
+	return DegradationStats{
+		Active:   atomic.LoadInt32(&d.active) == 1,
+		Degraded: atomic.LoadInt64(&d.degraded),
+	}
+}
@@ -0,0 +1,84 @@
+package imageapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/admission"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// recordingProcessor records the last task it processed
+type recordingProcessor struct {
+	task *image.Task
+}
+
+func (p *recordingProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	p.task = task
+	return []byte("image"), nil
+}
+
+func TestDegradation(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	tests := []struct {
+		Name            string
+		InUse           int64
+		URL             string
+		ExpectDegraded  bool
+		ExpectedQuality int
+	}{
+		// The 100x100 request itself uses 1% of the budget
+		{"low load", 400000, "/id/1/100/100.jpg", false, image.DefaultQuality},
+		{"high load", 800000, "/id/1/100/100.jpg", true, 50},
+		{"lower quality is kept", 800000, "/id/1/100/100.jpg?quality=30", true, 30},
+		{"auto quality is skipped", 800000, "/id/1/100/100.jpg?quality=auto", true, 50},
+	}
+
+	for _, test := range tests {
+		// Simulate other requests being processed, by acquiring part of the budget up front
+		controller := admission.New(1000000)
+		controller.Acquire(test.InUse)
+
+		processor := &recordingProcessor{}
+		degradation := api.NewDegradation(0.75, 50)
+		router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Admission: controller, Sources: f.Sources, OptimizeCoding: true, Degradation: degradation}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if degraded := w.Header().Get("X-Degraded") == "true"; degraded != test.ExpectDegraded {
+			t.Errorf("%s: wrong degraded header %t", test.Name, degraded)
+		}
+
+		if stats := degradation.Stats(); stats.Active != test.ExpectDegraded {
+			t.Errorf("%s: wrong stats %#v", test.Name, stats)
+		}
+
+		if processor.task.EncodeQuality != test.ExpectedQuality {
+			t.Errorf("%s: wrong quality %d", test.Name, processor.task.EncodeQuality)
+		}
+
+		if test.ExpectDegraded && (processor.task.EncodeEffort != 0 || processor.task.OptimizeHuffman) {
+			t.Errorf("%s: encoder settings weren't degraded %+v", test.Name, processor.task)
+		}
+
+		// Degraded images are only cached briefly, so that full quality images are served once the load goes down
+		if cacheControl := w.Header().Get("Cache-Control"); test.ExpectDegraded != (cacheControl == "public, max-age=300") {
+			t.Errorf("%s: wrong cache control %s", test.Name, cacheControl)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package imageapi
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// downloadHandler returns a ZIP with variants of the image in each of the requested sizes and formats
+// The variants are processed one at a time and written to the ZIP as soon as they're done, so that only one of them is held at once
+func (a *API) downloadHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	downloadParams, err := params.GetDownloadParams(r)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	_, filename := params.SplitTenantImageID(databaseImage.ID)
+	variants := downloadParams.Variants(databaseImage)
+
+	// The progress of the download is only tracked when the deployment enables it, for the clients that give it a job id
+	var job *downloadJob
+	if downloadParams.Job != "" {
+		if a.DownloadJobs == nil {
+			return handler.BadRequest("Download progress is disabled")
+		}
+
+		var ok bool
+		job, ok = a.DownloadJobs.start(downloadParams.Job, len(variants)*len(downloadParams.Extensions))
+		if !ok {
+			return &handler.Error{Message: "Download job already exists", Code: http.StatusConflict}
+		}
+	}
+
+	failed := true
+	defer func() {
+		job.finish(failed)
+	}()
+
+	var archive *zip.Writer
+	for _, extension := range downloadParams.Extensions {
+		for _, variant := range variants {
+			width, height := variant.Width, variant.Height
+			processedImage, handlerErr := a.processVariant(w, r, databaseImage, width, height, extension)
+			if handlerErr != nil {
+				if archive == nil {
+					return handlerErr
+				}
+
+				// The status can't change once the ZIP has been started, so it's left unfinished for the client to notice
+				a.logError(r, "error processing download variant", errors.New(handlerErr.Message))
+				return nil
+			}
+
+			// Start the ZIP once the first variant is processed, so that errors processing the image get the right status
+			if archive == nil {
+				w.Header().Set("Content-Type", "application/zip")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filename))
+				w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+				w.Header().Set("Picsum-ID", databaseImage.ID)
+				archive = zip.NewWriter(w)
+			}
+
+			// The images are compressed already, so they're stored as is
+			entry, err := archive.CreateHeader(&zip.FileHeader{
+				Name:     buildFilename(filename, &params.Params{Extension: extension}, width, height),
+				Method:   zip.Store,
+				Modified: time.Now(),
+			})
+			if err != nil {
+				a.logError(r, "error writing download variant", err)
+				return nil
+			}
+
+			if _, err := entry.Write(processedImage); err != nil {
+				a.logError(r, "error writing download variant", err)
+				return nil
+			}
+
+			job.progress()
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		a.logError(r, "error finishing download", err)
+		return nil
+	}
+
+	failed = false
+	return nil
+}
+
+// processVariant processes the image in the size and format, with the encoder settings of the service
+func (a *API) processVariant(w http.ResponseWriter, r *http.Request, databaseImage *database.Image, width int, height int, extension string) ([]byte, *handler.Error) {
+	task := image.NewTask(databaseImage.ID, width, height, fmt.Sprintf("Picsum ID: %s", databaseImage.ID), getOutputFormat(extension))
+	task.ProcessingVersion(a.ProcessingVersion)
+	task.Effort(a.EncodeEffort)
+	task.OptimizeCoding(a.OptimizeCoding)
+
+	if a.EmbedICCProfile {
+		task.EmbedProfile()
+	}
+
+	// Each variant is admitted on its own, as only one of them is processed at once
+	if a.Admission != nil {
+		pixels := int64(width) * int64(height)
+		if !a.Admission.Acquire(pixels) {
+			a.backpressureHeaders(w)
+			return nil, &handler.Error{Message: "Server is too busy, try again later", Code: http.StatusServiceUnavailable}
+		}
+
+		start := time.Now()
+		defer func() {
+			a.Admission.Release(pixels, time.Since(start))
+		}()
+	}
+
+	processedImage, err := a.ImageProcessor.ProcessImage(r.Context(), task)
+	if err != nil {
+		return nil, a.processingError(r, databaseImage, &params.Params{}, err)
+	}
+
+	return processedImage, nil
+}
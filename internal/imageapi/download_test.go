@@ -0,0 +1,326 @@
+package imageapi_test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	mockProcessor "github.com/DMarby/picsum-photos/internal/image/mock"
+)
+
+// variantProcessor returns the size and format of the task instead of processing the image
+type variantProcessor struct{}
+
+func (p *variantProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	return []byte(fmt.Sprintf("%dx%d%s", task.Width, task.Height, task.OutputFormat.Extension())), nil
+}
+
+func TestDownload(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{ImageProcessor: &variantProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+	failingRouter := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	t.Run("bundles the variants", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/download.zip?sizes=150,100x100&formats=jpg,webp", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/zip" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="1.zip"` {
+			t.Errorf("wrong content disposition %s", disposition)
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 1.jpg is 300x400, so a width of 150 is 200 high
+		expected := []string{"1-150x200.jpg", "1-100x100.jpg", "1-150x200.webp", "1-100x100.webp"}
+		if len(archive.File) != len(expected) {
+			t.Fatalf("wrong amount of entries %d", len(archive.File))
+		}
+
+		for i, file := range archive.File {
+			if file.Name != expected[i] {
+				t.Errorf("entry %d: wrong name %s", i, file.Name)
+				continue
+			}
+
+			entry, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, _ := ioutil.ReadAll(entry)
+			entry.Close()
+
+			if string(data) != expected[i][2:] {
+				t.Errorf("%s: wrong data %s", file.Name, data)
+			}
+		}
+	})
+
+	t.Run("clamps the sizes of portrait images", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/download.zip?sizes=300,4000,4500", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A width of 4000 would be 5333 high, so both of the larger sizes are clamped to the same variant within the max image size
+		expected := []string{"1-300x400.jpg", "1-3750x5000.jpg"}
+		if len(archive.File) != len(expected) {
+			t.Fatalf("wrong amount of entries %d", len(archive.File))
+		}
+
+		for i, file := range archive.File {
+			if file.Name != expected[i] {
+				t.Errorf("entry %d: wrong name %s", i, file.Name)
+			}
+		}
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		Router         http.Handler
+		ExpectedStatus int
+	}{
+		{"missing sizes", "/id/1/download.zip", router, http.StatusBadRequest},
+		{"invalid size", "/id/1/download.zip?sizes=0", router, http.StatusBadRequest},
+		{"too large size", "/id/1/download.zip?sizes=5001", router, http.StatusBadRequest},
+		{"invalid dimensions", "/id/1/download.zip?sizes=100x", router, http.StatusBadRequest},
+		{"duplicate size", "/id/1/download.zip?sizes=100,100", router, http.StatusBadRequest},
+		{"invalid format", "/id/1/download.zip?sizes=100&formats=gif", router, http.StatusBadRequest},
+		{"duplicate format", "/id/1/download.zip?sizes=100&formats=jpg,JPG", router, http.StatusBadRequest},
+		{"too many variants", "/id/1/download.zip?sizes=1,2,3,4,5,6,7&formats=jpg,webp", router, http.StatusBadRequest},
+		{"nonexistent image", "/id/nonexistant/download.zip?sizes=100", router, http.StatusNotFound},
+		{"processing error", "/id/1/download.zip?sizes=100", failingRouter, http.StatusInternalServerError},
+		{"job without download progress", "/id/1/download.zip?sizes=100&job=abc", router, http.StatusBadRequest},
+		{"job without progress isn't routed", "/download/status/abc", router, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
+
+// gatedProcessor processes each variant once it's let through by the gate
+type gatedProcessor struct {
+	gate chan struct{}
+}
+
+func (p *gatedProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	select {
+	case <-p.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return []byte(fmt.Sprintf("%dx%d%s", task.Width, task.Height, task.OutputFormat.Extension())), nil
+}
+
+func TestDownloadProgress(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &gatedProcessor{gate: make(chan struct{})}
+	jobs := api.NewDownloadJobs(100 * time.Millisecond)
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, DownloadJobs: jobs}).Router()
+
+	// The progress is streamed, so it's served by a real server rather than recorded
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("streams the progress of a job in order", func(t *testing.T) {
+		downloaded := make(chan *http.Response, 1)
+		go func() {
+			res, err := http.Get(server.URL + "/id/1/download.zip?sizes=150,100x100&formats=jpg,webp&job=multi")
+			if err != nil {
+				res = nil
+			}
+			downloaded <- res
+		}()
+
+		// The progress waits for the download to start, as the client starts both at once
+		res, err := http.Get(server.URL + "/download/status/multi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", res.StatusCode)
+		}
+
+		if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		events := readEvents(res)
+		expected := []string{
+			`progress {"done":0,"total":4}`,
+			`progress {"done":1,"total":4}`,
+			`progress {"done":2,"total":4}`,
+			`progress {"done":3,"total":4}`,
+			`progress {"done":4,"total":4}`,
+			`done {"done":4,"total":4}`,
+		}
+
+		// Each variant is let through once the event of the previous one was received, so every event is streamed as it happens
+		// The done event follows the last variant without another one being let through
+		for i, event := range expected {
+			if i > 0 && i < len(expected)-1 {
+				processor.gate <- struct{}{}
+			}
+
+			received, ok := <-events
+			if !ok {
+				t.Fatalf("missing event %d", i)
+			}
+
+			if received != event {
+				t.Errorf("event %d: wrong event %s", i, received)
+			}
+		}
+
+		if event, ok := <-events; ok {
+			t.Errorf("unexpected event %s", event)
+		}
+
+		download := <-downloaded
+		if download == nil || download.StatusCode != http.StatusOK {
+			t.Fatal("download failed")
+		}
+		download.Body.Close()
+
+		// Finished jobs are kept, so that the progress requested late gets the outcome
+		late, err := http.Get(server.URL + "/download/status/multi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer late.Body.Close()
+
+		if event := <-readEvents(late); event != `done {"done":4,"total":4}` {
+			t.Errorf("wrong late event %s", event)
+		}
+	})
+
+	t.Run("reports downloads that fail", func(t *testing.T) {
+		// The client goes away after the first variant, which leaves the download unfinished
+		downloadCtx, cancelDownload := context.WithCancel(context.Background())
+		defer cancelDownload()
+
+		downloaded := make(chan struct{})
+		go func() {
+			req, _ := http.NewRequest("GET", server.URL+"/id/1/download.zip?sizes=150,100&job=failing", nil)
+			if res, err := http.DefaultClient.Do(req.WithContext(downloadCtx)); err == nil {
+				res.Body.Close()
+			}
+			close(downloaded)
+		}()
+
+		res, err := http.Get(server.URL + "/download/status/failing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		events := readEvents(res)
+		if event := <-events; event != `progress {"done":0,"total":2}` {
+			t.Errorf("wrong first event %s", event)
+		}
+
+		processor.gate <- struct{}{}
+		if event := <-events; event != `progress {"done":1,"total":2}` {
+			t.Errorf("wrong second event %s", event)
+		}
+
+		cancelDownload()
+		<-downloaded
+		if event := <-events; event != `error {"done":1,"total":2}` {
+			t.Errorf("wrong last event %s", event)
+		}
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{"unknown job", "/download/status/unknown", http.StatusNotFound},
+		{"invalid job", "/download/status/" + strings.Repeat("a", 65), http.StatusBadRequest},
+		{"invalid download job", "/id/1/download.zip?sizes=100&job=a.b", http.StatusBadRequest},
+		{"empty download job", "/id/1/download.zip?sizes=100&job=", http.StatusBadRequest},
+		{"job that's already tracked", "/id/1/download.zip?sizes=100&job=multi", http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		res, err := http.Get(server.URL + test.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, res.StatusCode)
+		}
+	}
+}
+
+// readEvents reads the server-sent events of the response as "{event} {data}", until the stream ends
+func readEvents(res *http.Response) <-chan string {
+	events := make(chan string)
+	go func() {
+		defer close(events)
+
+		var name string
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				events <- name + " " + strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	return events
+}
@@ -0,0 +1,247 @@
+package imageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// DefaultDownloadJobWait is how long the progress of a download job waits for the download to start by default
+// The client starts both at once, so the progress can be requested before the download has been
+const DefaultDownloadJobWait = 5 * time.Second
+
+// downloadJobRetention is how long finished jobs are kept, so that the progress requested late still gets the outcome
+const downloadJobRetention = time.Minute
+
+// Download events
+const (
+	DownloadProgress = "progress" // A variant was added to the ZIP, or the progress so far when the progress is requested
+	DownloadDone     = "done"     // All the variants were added to the ZIP
+	DownloadFailed   = "error"    // The download was left unfinished
+)
+
+// DownloadEvent is the progress of a download job, sent as a server-sent event
+type DownloadEvent struct {
+	Type  string `json:"-"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// DownloadJobs tracks the progress of downloads that the client gave a job id, for following it while the ZIP is processed
+type DownloadJobs struct {
+	mutex   sync.Mutex
+	jobs    map[string]*downloadJob
+	started chan struct{} // Closed and replaced whenever a job starts
+	wait    time.Duration
+}
+
+// downloadJob is the progress of a single download, and the channels of the clients following it
+type downloadJob struct {
+	jobs        *DownloadJobs
+	id          string
+	last        DownloadEvent
+	subscribers map[chan DownloadEvent]bool
+}
+
+// NewDownloadJobs creates a new DownloadJobs, where the progress waits up to wait for the download of the job to start
+func NewDownloadJobs(wait time.Duration) *DownloadJobs {
+	return &DownloadJobs{
+		jobs:    make(map[string]*downloadJob),
+		started: make(chan struct{}),
+		wait:    wait,
+	}
+}
+
+// start starts tracking a download job with the total amount of variants, it returns false when a job with the id is already tracked
+func (d *DownloadJobs) start(id string, total int) (*downloadJob, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.jobs[id]; ok {
+		return nil, false
+	}
+
+	job := &downloadJob{
+		jobs:        d,
+		id:          id,
+		last:        DownloadEvent{Type: DownloadProgress, Total: total},
+		subscribers: make(map[chan DownloadEvent]bool),
+	}
+	d.jobs[id] = job
+
+	close(d.started)
+	d.started = make(chan struct{})
+
+	return job, true
+}
+
+// subscribe returns the events of the job, starting with its progress so far, waiting for the download to start if it hasn't yet
+// The channel is closed once the job finishes, and unsubscribe has to be called when the client stops following it
+func (d *DownloadJobs) subscribe(ctx context.Context, id string) (chan DownloadEvent, func(), bool) {
+	timeout := time.NewTimer(d.wait)
+	defer timeout.Stop()
+
+	for {
+		d.mutex.Lock()
+		job, ok := d.jobs[id]
+		started := d.started
+		if ok {
+			// The buffer fits every event the job has left, so that sending them never blocks the download
+			events := make(chan DownloadEvent, job.last.Total-job.last.Done+2)
+			events <- job.last
+			if job.last.Type == DownloadProgress {
+				job.subscribers[events] = true
+			} else {
+				close(events)
+			}
+			d.mutex.Unlock()
+
+			return events, func() { job.unsubscribe(events) }, true
+		}
+		d.mutex.Unlock()
+
+		select {
+		case <-started:
+		case <-timeout.C:
+			return nil, nil, false
+		case <-ctx.Done():
+			return nil, nil, false
+		}
+	}
+}
+
+// progress records that another variant was added to the ZIP
+// The methods of the job do nothing when it's nil, for downloads without a job id
+func (j *downloadJob) progress() {
+	if j == nil {
+		return
+	}
+
+	j.jobs.mutex.Lock()
+	defer j.jobs.mutex.Unlock()
+
+	j.last.Done++
+	j.send(j.last)
+}
+
+// finish records the outcome of the download, and keeps the job around for a while for the clients that follow it late
+func (j *downloadJob) finish(failed bool) {
+	if j == nil {
+		return
+	}
+
+	j.jobs.mutex.Lock()
+	defer j.jobs.mutex.Unlock()
+
+	if j.last.Type != DownloadProgress {
+		return
+	}
+
+	j.last.Type = DownloadDone
+	if failed {
+		j.last.Type = DownloadFailed
+	}
+
+	j.send(j.last)
+	for events := range j.subscribers {
+		close(events)
+		delete(j.subscribers, events)
+	}
+
+	time.AfterFunc(downloadJobRetention, func() {
+		j.jobs.mutex.Lock()
+		defer j.jobs.mutex.Unlock()
+
+		delete(j.jobs.jobs, j.id)
+	})
+}
+
+// send sends the event to the subscribers of the job, with the mutex held
+func (j *downloadJob) send(event DownloadEvent) {
+	for events := range j.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// unsubscribe stops sending the events of the job to the channel
+func (j *downloadJob) unsubscribe(events chan DownloadEvent) {
+	j.jobs.mutex.Lock()
+	defer j.jobs.mutex.Unlock()
+
+	delete(j.subscribers, events)
+}
+
+// downloadStatusHandler streams the progress of a download job as server-sent events, until the download finishes
+// It's served outside of the handler timeout, as it lasts as long as the download does
+func (a *API) downloadStatusHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	job := mux.Vars(r)["job"]
+	if !params.IsDownloadJob(job) {
+		return handler.BadRequest(params.ErrInvalidDownloadJob.Error())
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return handler.InternalServerError()
+	}
+
+	events, unsubscribe, ok := a.DownloadJobs.subscribe(r.Context(), job)
+	if !ok {
+		return &handler.Error{Message: "Download job not found", Code: http.StatusNotFound}
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return nil
+			}
+
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// streamRoutes serves the routes that stream their response, without the handler timeout that buffers the response, and the rest with next
+// Without download progress enabled there are none, so everything is left to next
+func (a *API) streamRoutes(next http.Handler) http.Handler {
+	if a.DownloadJobs == nil {
+		return next
+	}
+
+	router := mux.NewRouter()
+	router.NotFoundHandler = next
+
+	routes := router
+	if a.BasePath != "" {
+		routes = router.PathPrefix(a.BasePath).Subrouter()
+		routes.NotFoundHandler = next
+	}
+
+	// Download progress routes
+	// Streams the progress of the download started with ?job={job} as server-sent events, a progress event with the variants done so far, and one for each variant added to the ZIP,
+	// followed by a done or error event once the download finishes, each with the data {"done": {variants}, "total": {variants}}
+	routes.Handle("/download/status/{job}", handler.Handler(a.downloadStatusHandler)).Methods("GET")
+
+	return router
+}
@@ -0,0 +1,48 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestDPI(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	tests := []struct {
+		Name        string
+		URL         string
+		DefaultDPI  int
+		ExpectedDPI int
+	}{
+		{"no dpi", "/id/1/100/100.jpg", 0, 0},
+		{"dpi", "/id/1/100/100.jpg?dpi=300", 0, 300},
+		{"default dpi", "/id/1/100/100.jpg", 72, 72},
+		{"dpi takes precedence over the default", "/id/1/100/100.jpg?dpi=300", 72, 300},
+	}
+
+	for _, test := range tests {
+		processor := &recordingProcessor{}
+		router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, DefaultDPI: test.DefaultDPI}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.OutputDPI != test.ExpectedDPI {
+			t.Errorf("%s: wrong dpi %d", test.Name, processor.task.OutputDPI)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package imageapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// DefaultEncodeFallback is the chain of fallback steps that are tried by default
+const DefaultEncodeFallback = "lossy,fastest"
+
+// FallbackStep makes the encode of an image cheaper, when encoding it with the requested settings takes too long
+type FallbackStep string
+
+// Fallback steps
+const (
+	FallbackLossy   FallbackStep = "lossy"   // Encodes lossless WebP images lossy
+	FallbackFastest FallbackStep = "fastest" // Encodes with the lowest effort and without optimizing the Huffman coding, like while degraded
+	FallbackJPEG    FallbackStep = "jpeg"    // Encodes WebP images as JPEG, flattening any transparent areas of the source onto the background
+)
+
+// EncodeFallback retries images with cheaper encoder settings when processing them takes longer than After, rather than letting the request time out
+// The steps of the chain are applied on top of each other, and each one is tried once the previous attempt has taken longer than After
+// Whichever attempt finishes first is served, so the requested settings are still used when they finish before the fallback
+// Abandoned attempts keep their worker until they finish, so the fallbacks are only faster when there are workers free to process them
+type EncodeFallback struct {
+	After time.Duration
+	Chain []FallbackStep
+}
+
+// ParseEncodeFallback parses a comma separated chain of fallback steps, such as "lossy,fastest,jpeg"
+// An empty chain, or a duration of 0, disables the fallback
+func ParseEncodeFallback(value string, after time.Duration) (*EncodeFallback, error) {
+	if value == "" || after <= 0 {
+		return nil, nil
+	}
+
+	fallback := &EncodeFallback{
+		After: after,
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		step := FallbackStep(strings.ToLower(strings.TrimSpace(name)))
+		switch step {
+		case FallbackLossy, FallbackFastest, FallbackJPEG:
+			fallback.Chain = append(fallback.Chain, step)
+		default:
+			return nil, fmt.Errorf("invalid fallback step %q", name)
+		}
+	}
+
+	return fallback, nil
+}
+
+// fallbackResult is the outcome of one of the attempts at processing an image
+type fallbackResult struct {
+	task           *image.Task
+	processedImage []byte
+	fallback       bool
+	err            error
+}
+
+// process processes the image of the task, starting the next step of the fallback chain each time an attempt takes longer than After
+// It returns the task of the attempt that finished first along with the image, and whether it was a fallback
+func (f *EncodeFallback) process(ctx context.Context, processor image.Processor, task *image.Task) (*image.Task, []byte, bool, error) {
+	// The results are buffered, so that the attempts that are abandoned can finish without anyone waiting for them
+	results := make(chan fallbackResult, len(f.Chain)+1)
+	start := func(task *image.Task, fallback bool) {
+		go func() {
+			processedImage, err := processor.ProcessImage(ctx, task)
+			results <- fallbackResult{task: task, processedImage: processedImage, fallback: fallback, err: err}
+		}()
+	}
+
+	start(task, false)
+
+	timer := time.NewTimer(f.After)
+	defer timer.Stop()
+
+	attempt, step := task, 0
+	for {
+		select {
+		case result := <-results:
+			return result.task, result.processedImage, result.fallback, result.err
+		case <-timer.C:
+			next, ok := f.next(attempt, &step)
+			if !ok {
+				// Nothing is left to fall back to, so wait for the attempts that are already running
+				continue
+			}
+
+			attempt = next
+			start(attempt, true)
+			timer.Reset(f.After)
+		}
+	}
+}
+
+// next returns a copy of the task with the next step of the chain from step applied, skipping the steps that don't change it
+func (f *EncodeFallback) next(task *image.Task, step *int) (*image.Task, bool) {
+	for ; *step < len(f.Chain); *step++ {
+		next := *task
+		if applyFallback(f.Chain[*step], &next) {
+			*step++
+			return &next, true
+		}
+	}
+
+	return nil, false
+}
+
+// applyFallback applies the fallback step to the task, and returns whether it made the encode any cheaper
+func applyFallback(step FallbackStep, task *image.Task) bool {
+	switch step {
+	case FallbackLossy:
+		if task.OutputFormat != image.WebP || !task.EncodeLossless {
+			return false
+		}
+
+		task.EncodeLossless = false
+	case FallbackFastest:
+		if task.EncodeEffort == 0 && !task.OptimizeHuffman {
+			return false
+		}
+
+		task.Effort(0)
+		task.OptimizeCoding(false)
+	case FallbackJPEG:
+		// Masks and transparent padding are requested to be transparent, which JPEG can't encode
+		if task.OutputFormat != image.WebP || task.MaskImageID != "" || task.TransparentPad {
+			return false
+		}
+
+		task.OutputFormat = image.JPEG
+		task.EncodeLossless = false
+	}
+
+	return true
+}
@@ -0,0 +1,102 @@
+package imageapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// slowProcessor takes a long time to process the tasks that slow returns true for, and returns the others right away
+type slowProcessor struct {
+	slow func(task *image.Task) bool
+}
+
+func (p *slowProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	if p.slow(task) {
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return []byte(task.OutputFormat.Extension()), nil
+}
+
+func TestEncodeFallback(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := func(processor image.Processor, chain string) http.Handler {
+		fallback, err := api.ParseEncodeFallback(chain, 20*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, EncodeFallback: fallback}).Router()
+	}
+
+	slowLossless := &slowProcessor{slow: func(task *image.Task) bool { return task.EncodeLossless }}
+	slowWebP := &slowProcessor{slow: func(task *image.Task) bool { return task.OutputFormat == image.WebP }}
+	slowEffort := &slowProcessor{slow: func(task *image.Task) bool { return task.EncodeEffort > 0 }}
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		ExpectedFallback    bool
+		ExpectedContentType string
+		ExpectedBody        string
+	}{
+		{"fast encode", "/id/1/100/100.webp", router(slowLossless, "lossy,fastest"), false, "image/webp", ".webp"},
+		{"slow lossless encode", "/id/1/100/100.webp?lossless", router(slowLossless, "lossy,fastest"), true, "image/webp", ".webp"},
+		{"chain applied on top of each other", "/id/1/100/100.webp?lossless", router(slowEffort, "lossy,fastest"), true, "image/webp", ".webp"},
+		{"slow webp encode", "/id/1/100/100.webp", router(slowWebP, "fastest,jpeg"), true, "image/jpeg", ".jpg"},
+		{"steps that don't apply are skipped", "/id/1/100/100.webp", router(slowWebP, "lossy,jpeg"), true, "image/jpeg", ".jpg"},
+		{"nothing cheaper to fall back to", "/id/1/100/100.webp", router(slowWebP, "fastest"), false, "image/webp", ".webp"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if fallback := w.Header().Get("X-Encode-Fallback") == "true"; fallback != test.ExpectedFallback {
+			t.Errorf("%s: wrong fallback %t", test.Name, fallback)
+		}
+
+		if test.ExpectedFallback && w.Header().Get("Cache-Control") != "public, max-age=300" {
+			t.Errorf("%s: wrong cache control %s", test.Name, w.Header().Get("Cache-Control"))
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if body := w.Body.String(); body != test.ExpectedBody {
+			t.Errorf("%s: wrong image %s", test.Name, body)
+		}
+	}
+
+	t.Run("parses the chain", func(t *testing.T) {
+		if fallback, err := api.ParseEncodeFallback("lossy,fastest", 0); fallback != nil || err != nil {
+			t.Errorf("fallback without a duration %#v %s", fallback, err)
+		}
+
+		if _, err := api.ParseEncodeFallback("lossy,slower", time.Second); err == nil {
+			t.Error("no error for an unknown step")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package imageapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/exif"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/gorilla/mux"
+)
+
+// exifHandler returns the EXIF metadata of the source image as JSON
+// The GPS location is left out unless ExifGPS is set, as it can reveal where the photographer lives
+func (a *API) exifHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	// The policy is part of the key, so that turning off GPS doesn't serve locations cached before
+	key := "exif:" + databaseImage.ID
+	if a.ExifGPS {
+		key = "exif-gps:" + databaseImage.ID
+	}
+
+	data, err := a.FormatCache.Get(key)
+	if err != nil {
+		if err != cache.ErrNotFound {
+			a.logError(r, "error getting exif metadata from cache", err)
+		}
+
+		data, handlerErr = a.readExif(r, databaseImage.ID)
+		if handlerErr != nil {
+			return handlerErr
+		}
+
+		if err := a.FormatCache.Set(key, data); err != nil {
+			a.logError(r, "error caching exif metadata", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+	w.Write(data)
+
+	return nil
+}
+
+// readExif parses the EXIF metadata of the source image, and encodes it according to the GPS policy
+func (a *API) readExif(r *http.Request, imageID string) ([]byte, *handler.Error) {
+	buffer, err := a.Sources.Get(r.Context(), imageID)
+	if err != nil {
+		a.logError(r, "error getting source image", err)
+		return nil, handler.InternalServerError()
+	}
+
+	metadata, err := exif.Parse(buffer)
+	if err != nil {
+		a.logError(r, "error parsing exif metadata", err)
+		return nil, &handler.Error{Message: fmt.Sprintf("Image %s has invalid metadata", imageID), Code: http.StatusUnprocessableEntity}
+	}
+
+	if !a.ExifGPS {
+		metadata.GPS = nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		a.logError(r, "error encoding exif metadata", err)
+		return nil, handler.InternalServerError()
+	}
+
+	return append(data, '\n'), nil
+}
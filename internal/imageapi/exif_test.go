@@ -0,0 +1,89 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	mockProcessor "github.com/DMarby/picsum-photos/internal/image/mock"
+)
+
+func TestExif(t *testing.T) {
+	f := newFixtures("metadata_exif.json")
+	defer f.Close()
+
+	formatCache := memoryCache.New()
+	router := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: formatCache, Sources: f.Sources, OptimizeCoding: true}).Router()
+	gpsRouter := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, ExifGPS: true, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		Router           http.Handler
+		Accept           string
+		ExpectedStatus   int
+		ExpectedResponse []byte
+		ExpectedHeaders  map[string]string
+	}{
+		{
+			"omits gps by default", "/id/exif/exif", router, "", http.StatusOK,
+			[]byte(`{"camera":{"make":"Picsum","model":"Test Camera","lens":"35mm f/2.8"},"exposure":{"time":"1/250","fnumber":2.8,"iso":200,"focal_length":35},"dimensions":{"width":64,"height":48}}` + "\n"),
+			map[string]string{"Content-Type": "application/json", "Cache-Control": "public, max-age=2592000", "Picsum-ID": "exif"},
+		},
+		{
+			"includes gps when enabled", "/id/exif/exif", gpsRouter, "", http.StatusOK,
+			[]byte(`{"camera":{"make":"Picsum","model":"Test Camera","lens":"35mm f/2.8"},"exposure":{"time":"1/250","fnumber":2.8,"iso":200,"focal_length":35},"gps":{"latitude":59.329333,"longitude":18.068667,"altitude":28.5},"dimensions":{"width":64,"height":48}}` + "\n"),
+			map[string]string{"Content-Type": "application/json", "Cache-Control": "public, max-age=2592000", "Picsum-ID": "exif"},
+		},
+		{
+			"image without metadata", "/id/quadrants/exif", router, "", http.StatusOK,
+			[]byte("{}\n"),
+			map[string]string{"Content-Type": "application/json", "Cache-Control": "public, max-age=2592000", "Picsum-ID": "quadrants"},
+		},
+		{
+			"nonexistent image", "/id/nonexistant/exif", router, "application/json", http.StatusNotFound,
+			[]byte(`{"error":"Image does not exist"}` + "\n"),
+			map[string]string{"Content-Type": "application/json", "Cache-Control": "no-cache, no-store, must-revalidate"},
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		for expectedHeader, expectedValue := range test.ExpectedHeaders {
+			if headerValue := w.Header().Get(expectedHeader); headerValue != expectedValue {
+				t.Errorf("%s: wrong header value for %s, %#v", test.Name, expectedHeader, headerValue)
+			}
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), test.ExpectedResponse) {
+			t.Errorf("%s: wrong response %s", test.Name, w.Body.String())
+		}
+	}
+
+	// The metadata is cached per image, without the gps location
+	cached, err := formatCache.Get("exif:exif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(cached, []byte("gps")) {
+		t.Errorf("cached metadata has the gps location %s", cached)
+	}
+}
@@ -0,0 +1,57 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestFitCrop(t *testing.T) {
+	f := newFixtures("metadata_aspect.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	process := func(url string) (int, *image.Task) {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		return w.Code, processor.task
+	}
+
+	// fit=crop is resolved to cover when parsing the params, so the image is processed exactly the same
+	tests := []struct {
+		URL            string
+		ExpectedStatus int
+	}{
+		{"/id/landscape/300/300.jpg?fit=%s", http.StatusOK},
+		{"/id/portrait/400/200.jpg?fit=%s&noupscale", http.StatusOK},
+		{"/id/square/200/500.webp?fit=%s&blur=2", http.StatusOK},
+		{"/id/landscape/300/300.jpg?fit=%s&bg=000", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		coverCode, cover := process(strings.Replace(test.URL, "%s", "cover", 1))
+		cropCode, crop := process(strings.Replace(test.URL, "%s", "crop", 1))
+
+		if coverCode != test.ExpectedStatus || cropCode != test.ExpectedStatus {
+			t.Errorf("%s: wrong response codes %d and %d", test.URL, coverCode, cropCode)
+			continue
+		}
+
+		if !reflect.DeepEqual(crop, cover) {
+			t.Errorf("%s: processed differently from cover %#v", test.URL, crop)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestFitPad(t *testing.T) {
+	f := newFixtures("metadata_aspect.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	// The output is always exactly the requested size, whatever the aspect ratio of the source image
+	tests := []struct {
+		Name               string
+		URL                string
+		ExpectedWidth      int
+		ExpectedHeight     int
+		ExpectedCanvas     [2]int
+		ExpectedBackground image.Color
+	}{
+		{"landscape", "/id/landscape/300/300.jpg?fit=pad", 300, 300, [2]int{300, 300}, image.Color{R: 255, G: 255, B: 255}},
+		{"portrait", "/id/portrait/400/200.jpg?fit=pad", 400, 200, [2]int{400, 200}, image.Color{R: 255, G: 255, B: 255}},
+		{"square", "/id/square/200/500.jpg?fit=pad", 200, 500, [2]int{200, 500}, image.Color{R: 255, G: 255, B: 255}},
+		{"same aspect ratio", "/id/landscape/192/108.jpg?fit=pad", 192, 108, [2]int{192, 108}, image.Color{R: 255, G: 255, B: 255}},
+		{"background", "/id/portrait/300/300.jpg?fit=pad&bg=000", 300, 300, [2]int{300, 300}, image.Color{}},
+		{"noupscale", "/id/portrait/600/600.jpg?fit=pad&noupscale", 300, 300, [2]int{600, 600}, image.Color{R: 255, G: 255, B: 255}},
+		{"noupscale smaller than the image", "/id/square/100/200.jpg?fit=pad&noupscale", 100, 200, [2]int{100, 200}, image.Color{R: 255, G: 255, B: 255}},
+		{"dpr", "/id/square/100/50.jpg?fit=pad&dpr=2", 200, 100, [2]int{200, 100}, image.Color{R: 255, G: 255, B: 255}},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		task := processor.task
+		if task.FitMode != image.Contain {
+			t.Errorf("%s: image isn't contained", test.Name)
+		}
+
+		if task.Width != test.ExpectedWidth || task.Height != test.ExpectedHeight {
+			t.Errorf("%s: wrong size %dx%d", test.Name, task.Width, task.Height)
+		}
+
+		if !task.ApplyPad || task.CanvasWidth != test.ExpectedCanvas[0] || task.CanvasHeight != test.ExpectedCanvas[1] {
+			t.Errorf("%s: wrong canvas %dx%d", test.Name, task.CanvasWidth, task.CanvasHeight)
+		}
+
+		if task.Background != test.ExpectedBackground {
+			t.Errorf("%s: wrong background %#v", test.Name, task.Background)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestFlatten(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	white := image.Color{R: 255, G: 255, B: 255}
+	tests := []struct {
+		Name               string
+		URL                string
+		ExpectedFlatten    bool
+		ExpectedBackground image.Color
+	}{
+		{"jpeg", "/id/1/100/100.jpg", false, white},
+		{"webp", "/id/1/100/100.webp", false, white},
+		{"flatten", "/id/1/100/100.webp?flatten", true, white},
+		{"flatten with bg", "/id/1/100/100.webp?flatten&bg=ff0000", true, image.Color{R: 255}},
+		{"jpeg with bg", "/id/1/100/100.jpg?ratio=1:1&bg=0000ff", false, image.Color{B: 255}},
+		{"transparent padding", "/id/1/100/100.webp?ratio=1:1&bg=transparent", false, image.Color{}},
+	}
+
+	for _, test := range tests {
+		processor := &recordingProcessor{}
+		router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.ApplyFlatten != test.ExpectedFlatten {
+			t.Errorf("%s: wrong flatten %t", test.Name, processor.task.ApplyFlatten)
+		}
+
+		// JPEG images are flattened onto the background when they have transparency, whether or not flatten is requested
+		if processor.task.Background != test.ExpectedBackground {
+			t.Errorf("%s: wrong background %#v", test.Name, processor.task.Background)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package imageapi
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+// DefaultFormatCacheSize is how many bytes of the format, quality and lossless decisions are kept in memory by default
+const DefaultFormatCacheSize = 64 << 20
+
+// DefaultFormatCacheTTL is how long the format, quality and lossless decisions are kept by default, before they're made again
+const DefaultFormatCacheTTL = 24 * time.Hour
+
+// processAutoFormat processes the image in all the formats that the client accepts, and returns the smallest one
+// The chosen format is cached per task, so that later requests only have to encode the image once
+func (a *API) processAutoFormat(ctx context.Context, r *http.Request, task *image.Task, qualities map[image.OutputFormat]int) (image.OutputFormat, []byte, error) {
+	candidates := acceptedFormats(r)
+
+	// Try the format from a previous decision for the same task first
+	task.OutputFormat = image.JPEG
+	key := "format:" + task.Key()
+	if data, err := a.FormatCache.Get(key); err == nil {
+		if format, ok := parseFormat(string(data)); ok && containsFormat(candidates, format) {
+			task.OutputFormat = format
+			setFormatQuality(task, qualities)
+			processedImage, err := a.ImageProcessor.ProcessImage(ctx, task)
+			return format, processedImage, err
+		}
+	} else if err != cache.ErrNotFound {
+		a.logError(r, "error getting format decision from cache", err)
+	}
+
+	var smallestFormat image.OutputFormat
+	var smallestImage []byte
+	for _, format := range candidates {
+		task.OutputFormat = format
+		setFormatQuality(task, qualities)
+		processedImage, err := a.ImageProcessor.ProcessImage(ctx, task)
+		if err != nil {
+			return format, nil, err
+		}
+
+		if smallestImage == nil || len(processedImage) < len(smallestImage) {
+			smallestFormat = format
+			smallestImage = processedImage
+		}
+	}
+
+	// Only cache the decision when there was more than one format to choose from
+	if len(candidates) > 1 {
+		task.OutputFormat = image.JPEG
+		if err := a.FormatCache.Set(key, []byte(formatName(smallestFormat))); err != nil {
+			a.logError(r, "error caching format decision", err)
+		}
+	}
+
+	task.OutputFormat = smallestFormat
+	setFormatQuality(task, qualities)
+	return smallestFormat, smallestImage, nil
+}
+
+// setFormatQuality sets the quality of the task to the quality for its output format, when there's one
+func setFormatQuality(task *image.Task, qualities map[image.OutputFormat]int) {
+	if quality, ok := qualities[task.OutputFormat]; ok {
+		task.Quality(quality)
+	}
+}
+
+// acceptedFormats returns the output formats that the client prefers, which are the ones with the highest quality value in the Accept header
+// WebP has to be accepted explicitly, as clients that can't decode it still accept image/* and */*
+// JPEG is returned when the client accepts none of the formats, as it's what the extension defaults to
+func acceptedFormats(r *http.Request) []image.OutputFormat {
+	accept := parseAccept(r.Header.Get("Accept"))
+	qualities := map[image.OutputFormat]float64{
+		image.JPEG: accept.quality(image.JPEG.ContentType(), true),
+		image.WebP: accept.quality(image.WebP.ContentType(), false),
+	}
+
+	best := 0.0
+	for _, q := range qualities {
+		best = math.Max(best, q)
+	}
+
+	if best == 0 {
+		return []image.OutputFormat{image.JPEG}
+	}
+
+	var formats []image.OutputFormat
+	for _, format := range []image.OutputFormat{image.JPEG, image.WebP} {
+		if qualities[format] == best {
+			formats = append(formats, format)
+		}
+	}
+
+	return formats
+}
+
+// acceptHeader maps the media ranges of an Accept header to their quality values
+type acceptHeader map[string]float64
+
+// parseAccept parses the media ranges and quality values of an Accept header
+// Media ranges with an invalid quality value are left out
+func parseAccept(header string) acceptHeader {
+	accept := acceptHeader{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaRange == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			nameValue := strings.SplitN(param, "=", 2)
+			if len(nameValue) != 2 || strings.ToLower(strings.TrimSpace(nameValue[0])) != "q" {
+				continue
+			}
+
+			var err error
+			q, err = strconv.ParseFloat(strings.TrimSpace(nameValue[1]), 64)
+			if err != nil || math.IsNaN(q) || q < 0 || q > 1 {
+				q = -1
+			}
+		}
+
+		if q >= 0 {
+			accept[mediaRange] = q
+		}
+	}
+
+	return accept
+}
+
+// quality returns the quality value of a media type, from the most specific media range that matches it
+// Wildcards are only matched when allowed, 0 means that the media type isn't accepted
+func (a acceptHeader) quality(mediaType string, wildcards bool) float64 {
+	if q, ok := a[mediaType]; ok {
+		return q
+	}
+
+	if !wildcards {
+		return 0
+	}
+
+	if q, ok := a[strings.SplitN(mediaType, "/", 2)[0]+"/*"]; ok {
+		return q
+	}
+
+	return a["*/*"]
+}
+
+func containsFormat(formats []image.OutputFormat, format image.OutputFormat) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatName(format image.OutputFormat) string {
+	switch format {
+	case image.WebP:
+		return "webp"
+	case image.Raw:
+		return "raw"
+	default:
+		return "jpeg"
+	}
+}
+
+func parseFormat(name string) (image.OutputFormat, bool) {
+	switch name {
+	case "webp":
+		return image.WebP, true
+	case "jpeg":
+		return image.JPEG, true
+	default:
+		return image.JPEG, false
+	}
+}
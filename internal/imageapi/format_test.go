@@ -0,0 +1,49 @@
+package imageapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+)
+
+func TestAcceptedFormats(t *testing.T) {
+	jpeg := []image.OutputFormat{image.JPEG}
+	webp := []image.OutputFormat{image.WebP}
+	both := []image.OutputFormat{image.JPEG, image.WebP}
+
+	tests := []struct {
+		Name     string
+		Accept   string
+		Expected []image.OutputFormat
+	}{
+		{"no accept header", "", jpeg},
+		{"jpeg only", "image/jpeg", jpeg},
+		{"webp only", "image/webp", webp},
+		{"jpeg and webp", "image/jpeg, image/webp", both},
+		{"webp preferred by q-value", "image/avif;q=0.9, image/webp;q=0.8, image/jpeg;q=0.5", webp},
+		{"jpeg preferred by q-value", "image/webp;q=0.4, image/jpeg", jpeg},
+		{"q-values are case insensitive", "image/webp; Q=0.8, image/jpeg;q=0.9", jpeg},
+		{"unsupported formats only", "image/avif, image/png", jpeg},
+		{"webp isn't accepted", "image/webp;q=0, image/*", jpeg},
+		{"nothing is accepted", "image/jpeg;q=0, image/webp;q=0", jpeg},
+		{"invalid q-values are ignored", "image/webp;q=2, image/jpeg;q=0.5", jpeg},
+		{"image wildcard only accepts jpeg", "image/*", jpeg},
+		{"any wildcard only accepts jpeg", "*/*", jpeg},
+		{"specific media range wins over the wildcard", "image/jpeg;q=0.5, image/*, image/webp;q=0.8", webp},
+		{"browser without webp", "image/png,image/*;q=0.8,*/*;q=0.5", jpeg},
+		{"browser with webp", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", both},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/id/1/200/120.jpg?format=auto", nil)
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+
+		if formats := acceptedFormats(req); !reflect.DeepEqual(formats, test.Expected) {
+			t.Errorf("%s: wrong formats %v", test.Name, formats)
+		}
+	}
+}
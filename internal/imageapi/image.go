@@ -1,8 +1,13 @@
 package imageapi
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/DMarby/picsum-photos/internal/handler"
@@ -19,6 +24,12 @@ func (a *API) imageHandler(w http.ResponseWriter, r *http.Request) *handler.Erro
 		return handler.BadRequest(err.Error())
 	}
 
+	// Beacons are the same pixel for every image, so they're returned without looking up or processing the image
+	if p.Beacon {
+		writeBeacon(w, r, p.Extension, p.RawPixelFormat())
+		return nil
+	}
+
 	// Get the image from the database
 	vars := mux.Vars(r)
 	imageID := vars["id"]
@@ -27,43 +38,479 @@ func (a *API) imageHandler(w http.ResponseWriter, r *http.Request) *handler.Erro
 		return handlerErr
 	}
 
+	p.DisabledEffects = a.DisabledEffects
+	p.MaxEffects = a.MaxEffects
+	p.Filters = a.Filters
+	p.CropBounds = a.CropBounds
+	p.QualityBounds = a.QualityBounds
+	p.Rounding = a.Rounding
+	p.ProcessingVersion = a.ProcessingVersion
+
+	// The size limits are checked on the output size, which noupscale and the rounding change
+	if a.NoUpscale {
+		p.NoUpscale = true
+	}
+
+	// Output masked images with alpha rather than rejecting them, when the deployment is configured to
+	p.UpgradeAlphaFormat(a.AlphaFormat)
+
 	// Validate the parameters
 	if err := p.Validate(databaseImage); err != nil {
-		return handler.BadRequest(err.Error())
+		return a.invalidParams(err)
 	}
 
-	width, height := p.Dimensions(databaseImage)
+	// Async requests are only processed in the background when the deployment enables it
+	if p.Async && a.AsyncJobs == nil {
+		return handler.BadRequest("Async processing is disabled")
+	}
+
+	// The image to blend with has to exist as well
+	var blendImage *database.Image
+	if p.Blend != "" {
+		blendImage, handlerErr = a.getImage(r, p.Blend)
+		if handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	// And the image to overlay
+	var overlayImage *database.Image
+	if p.Overlay != "" {
+		overlayImage, handlerErr = a.getImage(r, p.Overlay)
+		if handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	// As does the mask
+	var maskImage *database.Image
+	if p.Mask != "" {
+		maskImage, handlerErr = a.getMask(r, p.Mask)
+		if handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	// Respond without processing the image if the client's copy is still current, which it isn't once any of the sources have changed
+	sourceIDs := []string{databaseImage.ID}
+	for _, source := range []*database.Image{blendImage, overlayImage, maskImage} {
+		if source != nil {
+			sourceIDs = append(sourceIDs, source.ID)
+		}
+	}
+
+	if a.notModified(w, r, sourceIDs...) {
+		w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+		w.Header().Set("Picsum-ID", databaseImage.ID)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	width, height := p.OutputDimensions(databaseImage)
 
 	// Build the image task
 	task := image.NewTask(databaseImage.ID, width, height, fmt.Sprintf("Picsum ID: %s", databaseImage.ID), getOutputFormat(p.Extension))
+	task.ProcessingVersion(a.ProcessingVersion)
+	if task.OutputFormat == image.Raw {
+		task.PixelFormat(getPixelFormat(p.RawPixelFormat()))
+	}
 	if p.Blur {
-		task.Blur(p.BlurAmount)
+		task.Blur(a.clampBlur(r, getBlurAmount(p, width, height), width, height))
+		task.BlurEdge(getBlurEdge(p.BlurEdge))
+
+		if p.HasBlurRegion() {
+			task.BlurRegion(image.Region{Left: p.BlurRegion.X, Top: p.BlurRegion.Y, Width: p.BlurRegion.Width, Height: p.BlurRegion.Height})
+		}
+
+		if p.BlurBefore {
+			task.BlurBeforeResize()
+		}
+
+		// Small blurred images are usually placeholders, where the faster processing isn't noticeable
+		if p.Placeholder || (width <= placeholderSize && height <= placeholderSize) {
+			task.Placeholder()
+		}
+	}
+
+	// An explicit sharpen takes precedence over sharpening downscaled images by default
+	// Blurred images aren't sharpened, and neither are images resized with nearest neighbor, which keeps the hard edges of pixel art
+	if p.HasSharpen() {
+		if p.Sharpen > 0 {
+			task.Sharpen(p.Sharpen)
+		}
+	} else if !p.Blur && p.ResizeFilter != params.ResizeFilterNearest && a.AutoSharpen.applies(databaseImage, width, height) {
+		task.Sharpen(a.AutoSharpen.Sigma)
 	}
 
+	// Grayscale takes precedence over the saturation, as it's the same as a saturation of 0
 	if p.Grayscale {
 		task.Grayscale()
+	} else if p.HasSaturation() {
+		task.Saturate(p.Saturation)
+	}
+
+	if p.HasVibrance() {
+		task.Vibrance(p.Vibrance)
+	}
+
+	if p.Colorize != "" {
+		task.Colorize(p.ColorizeHueChroma())
+	}
+
+	if p.HasSepia() {
+		task.Sepia(p.SepiaAmount)
+	}
+
+	if p.HasGamma() {
+		task.Gamma(p.Gamma)
+	}
+
+	if p.HasBrightness() {
+		task.Brightness(p.Brightness)
+	}
+
+	if p.HasContrast() {
+		task.Contrast(p.Contrast)
+	}
+
+	if p.HasVignette() {
+		task.Vignette(p.Vignette)
+	}
+
+	if p.HasThreshold() {
+		task.Threshold(p.Threshold, p.Dither)
+	}
+
+	task.Filter(getResizeFilter(p.ResizeFilter))
+	task.Fit(getFit(p.Fit))
+	task.Frame(p.Frame)
+
+	// Orientation 1 is upright, so overriding the orientation with it keeps the pixels as they're stored
+	if p.HasOrient() {
+		task.Orient(p.Orient)
+	} else if !p.AutoRotates(!a.NoAutoRotate) {
+		task.Orient(1)
+	}
+
+	if p.HasCrop() {
+		region := p.CropRegion(databaseImage)
+		task.Crop(image.Region{Left: region.X, Top: region.Y, Width: region.Width, Height: region.Height})
+	}
+
+	if p.TrimColor != "" {
+		task.TrimColor(getColor(p.TrimColor, image.Color{}), p.TrimTolerance)
+	} else if p.Trim {
+		task.Trim(p.TrimTolerance)
+	}
+
+	if blendImage != nil {
+		task.Blend(blendImage.ID, getBlendMode(p.BlendMode), p.BlendOpacity)
+	}
+
+	if overlayImage != nil {
+		task.Overlay(overlayImage.ID, getGravity(p.OverlayPos), float64(p.OverlayOpacity)/100, p.OverlaySize)
+	}
+
+	if maskImage != nil {
+		task.Mask(maskImage.ID)
+	}
+
+	if p.Extract != "" {
+		task.Extract(getChannel(p.Extract), a.StrictExtract)
+	}
+
+	// Images in other color spaces than sRGB can't be interpreted without their profile, so always embed it for those
+	colorSpace := getColorSpace(p.ColorSpace)
+	task.ConvertColorSpace(colorSpace)
+	if a.EmbedICCProfile || colorSpace != image.SRGB {
+		task.EmbedProfile()
+	}
+
+	requestedWidth, requestedHeight := p.Dimensions(databaseImage)
+	task.Quality(a.getQuality(p, task.OutputFormat, requestedWidth, requestedHeight))
+	task.OptimizeCoding(a.OptimizeCoding)
+
+	if p.HasEffort() {
+		task.Effort(p.Effort)
+	} else {
+		task.Effort(a.EncodeEffort)
+	}
+
+	if p.Lossless {
+		task.Lossless()
+	} else if p.HasNearLossless() {
+		task.NearLossless(p.NearLossless)
+	}
+
+	// Draw the text on top of the image, defaulting to the requested dimensions like other placeholder services
+	if p.ShowText {
+		text := p.Text
+		if text == "" {
+			logicalWidth, logicalHeight := p.Dimensions(databaseImage)
+			text = fmt.Sprintf("%dx%d", logicalWidth, logicalHeight)
+		}
+
+		task.DrawText(text, getColor(p.TextColor, defaultTextColor), getGravity(p.Gravity))
+	}
+
+	// Pad the image to the requested aspect ratio, or a contained image to the requested size, the canvas is what's returned to the client
+	if p.HasAspectRatio() || (p.Fit == params.FitContain && p.Background != "") || p.Fit == params.FitPad {
+		canvasWidth, canvasHeight := p.CanvasDimensions(width, height)
+		if p.Fit == params.FitPad {
+			// Without upscaling, the image is contained within a smaller box and padded the rest of the way
+			task.Width, task.Height = p.PadBox(databaseImage)
+		}
+
+		if background, alpha := p.BackgroundAlpha(); p.Background == params.ColorTransparent {
+			task.PadTransparent(canvasWidth, canvasHeight)
+		} else if alpha < 255 {
+			task.PadTranslucent(canvasWidth, canvasHeight, getColor(background, defaultBackground), alpha)
+		} else {
+			task.Pad(canvasWidth, canvasHeight, getColor(background, defaultBackground))
+		}
+		width, height = canvasWidth, canvasHeight
+	}
+
+	// Flatten transparent areas onto the background, JPEG images are always flattened onto it as they have no alpha channel
+	if p.Flatten {
+		task.Flatten(getColor(p.Background, defaultBackground))
+	} else if !task.TransparentPad {
+		task.Background = getColor(p.Background, defaultBackground)
+	}
+
+	if p.ColorblindMode != "" {
+		task.Colorblind(getColorblindMode(p.ColorblindMode))
+	}
+
+	if p.HasInvertRegion() {
+		task.InvertRegion(image.Region{Left: p.InvertRegion.X, Top: p.InvertRegion.Y, Width: p.InvertRegion.Width, Height: p.InvertRegion.Height})
+	}
+
+	// The resolution only changes the density in the header of JPEG images, and not their pixels
+	if p.HasDPI() {
+		task.DPI(p.DPI)
+	} else if a.DefaultDPI > 0 {
+		task.DPI(a.DefaultDPI)
+	}
+
+	// Clients that ask to save data get a lower quality, and the smallest format they accept
+	if a.saveData(w, r) {
+		a.applySaveData(p, task)
+	}
+
+	// Clients that can't decode WebP or progressive JPEG images get a baseline JPEG, whichever format was requested
+	// Raw pixels aren't decoded, so they're returned to any client
+	if task.OutputFormat != image.Raw && a.legacyClient(w, r) {
+		p.Extension = ".jpg"
+		p.AutoFormat = false
+		task.OutputFormat = image.JPEG
+		task.Baseline()
+	}
+
+	// Respond with how the request was resolved instead of processing the image, when debugging is enabled
+	if p.Debug && a.DebugParams {
+		return a.debugParams(w, r, p, databaseImage, width, height, task)
+	}
+
+	// Serve the images processed by async jobs from the output cache, and process the images of async requests in the background
+	if a.AsyncJobs != nil {
+		if handlerErr, ok := a.asyncImage(w, r, imageID, p, databaseImage, width, height, task); ok {
+			return handlerErr
+		}
+	}
+
+	// Return the source image as it's stored if processing it would only re-encode it
+	if source, ok := a.passthrough(r, p, databaseImage, task); ok {
+		return a.writeImage(w, r, imageID, p, databaseImage, width, height, source, false, false)
+	}
+
+	// Reject the request if processing it would use more memory than is available
+	if a.Admission != nil {
+		pixels := int64(width) * int64(height)
+		if !a.Admission.Acquire(pixels) {
+			a.backpressureHeaders(w)
+			return &handler.Error{Message: "Server is too busy, try again later", Code: http.StatusServiceUnavailable}
+		}
+
+		start := time.Now()
+		defer func() {
+			a.Admission.Release(pixels, time.Since(start))
+		}()
+	}
+
+	// Under high load, skip picking the quality as it encodes the image several times, and make the encode itself cheaper
+	degraded := a.Degradation != nil && a.Admission != nil && a.Degradation.check(a.Admission.Load())
+	if degraded {
+		a.Degradation.apply(task)
+	}
+
+	// Pick the quality before processing the image, as it depends on how the image looks once encoded
+	if p.AutoQuality && !degraded {
+		quality, err := a.autoQuality(r.Context(), r, task)
+		if err != nil {
+			return a.processingError(r, databaseImage, p, err)
+		}
+
+		task.Quality(quality)
+	}
+
+	// Pick between lossless and lossy encoding for WebP images, as it depends on whether the image is a graphic or a photo
+	if p.AutoLossless && !degraded && (task.OutputFormat == image.WebP || p.AutoFormat) {
+		lossless, err := a.autoLossless(r.Context(), r, task)
+		if err != nil {
+			return a.processingError(r, databaseImage, p, err)
+		}
+
+		if lossless {
+			task.Lossless()
+		}
 	}
 
 	// Process the image
-	processedImage, err := a.ImageProcessor.ProcessImage(r.Context(), task)
+	var processedImage []byte
+	var fallback bool
+	if p.AutoFormat {
+		var format image.OutputFormat
+		format, processedImage, err = a.processAutoFormat(r.Context(), r, task, a.presetQualities(p, degraded))
+		p.Extension = format.Extension()
+
+		// The response depends on the formats the client accepts
+		w.Header().Add("Vary", "Accept")
+	} else if a.EncodeFallback != nil && task.OutputFormat != image.Raw {
+		// Raw pixels aren't encoded, so there's nothing to make cheaper about them
+		var processedTask *image.Task
+		processedTask, processedImage, fallback, err = a.EncodeFallback.process(r.Context(), a.ImageProcessor, task)
+		if err == nil && processedTask.OutputFormat != task.OutputFormat {
+			p.Extension = processedTask.OutputFormat.Extension()
+		}
+	} else {
+		processedImage, err = a.ImageProcessor.ProcessImage(r.Context(), task)
+	}
+
 	if err != nil {
-		a.logError(r, "error processing image", err)
-		return handler.InternalServerError()
+		return a.processingError(r, databaseImage, p, err)
+	}
+
+	return a.writeImage(w, r, imageID, p, databaseImage, width, height, processedImage, degraded, fallback)
+}
+
+// writeImage responds with the image, and the headers for it
+func (a *API) writeImage(w http.ResponseWriter, r *http.Request, imageID string, p *params.Params, databaseImage *database.Image, width int, height int, processedImage []byte, degraded bool, fallback bool) *handler.Error {
+	if p.SizeOnly {
+		return a.writeSize(w, r, p, databaseImage, width, height, processedImage, degraded)
 	}
 
 	// Set the headers
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", buildFilename(imageID, p, width, height)))
-	w.Header().Set("Content-Type", getContentType(p.Extension))
+	w.Header().Set("Content-Type", getOutputFormat(p.Extension).ContentType())
 	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
 	w.Header().Set("Picsum-ID", databaseImage.ID)
+	w.Header().Set("Content-Length", strconv.Itoa(len(processedImage)))
+
+	// Raw pixels have no header, so the layout is described in the response headers instead
+	if getOutputFormat(p.Extension) == image.Raw {
+		pixelFormat := getPixelFormat(p.RawPixelFormat())
+		w.Header().Set("X-Pixel-Format", p.RawPixelFormat())
+		w.Header().Set("X-Pixel-Width", strconv.Itoa(width))
+		w.Header().Set("X-Pixel-Height", strconv.Itoa(height))
+		w.Header().Set("X-Pixel-Channels", strconv.Itoa(pixelFormat.Channels()))
+	}
 
-	// Return the image
-	w.Write(processedImage)
+	// Clients lay the image out at its logical size by the ratio it was scaled by
+	if p.HasDPR() {
+		w.Header().Set("Content-DPR", strconv.FormatFloat(p.EffectiveDPR(databaseImage), 'f', -1, 64))
+	}
+
+	if degraded {
+		w.Header().Set("X-Degraded", "true")
+		w.Header().Set("Cache-Control", degradedMaxAge)
+	}
+
+	// Images encoded with cheaper settings are only cached briefly as well, so that the requested settings are used again once they're fast enough
+	if fallback {
+		w.Header().Set("X-Encode-Fallback", "true")
+		w.Header().Set("Cache-Control", degradedMaxAge)
+	}
+
+	// Clients building srcsets can skip requesting larger variants of images that are already clamped to their native size
+	if clampedWidth, clampedHeight, clamped := p.ClampedDimensions(databaseImage); clamped {
+		w.Header().Set("X-Image-Clamped", "true")
+		w.Header().Set("X-Image-Dimensions", fmt.Sprintf("%dx%d", clampedWidth, clampedHeight))
+	}
+
+	if a.SourceHeader {
+		a.setSourceFormat(w, r, databaseImage.ID)
+	}
+
+	// The whole image is encoded before it's returned, so its length is known and range requests can be served from it
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// HEAD requests only get the headers
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	// Return the image, or the requested range of it
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(processedImage))
+
+	// Record the size of the image, to find the variants that use the most bandwidth
+	format := formatName(getOutputFormat(p.Extension))
+	a.Log.Debugw("image served", handler.LogFields(r, "image-id", databaseImage.ID, "format", format, "width", width, "height", height, "params", params.BuildQuery(p), "bytes", len(processedImage))...)
+	if a.Bandwidth != nil {
+		a.Bandwidth.record(format, width, height, len(processedImage))
+	}
 
 	return nil
 }
 
+// setSourceFormat sets the X-Image-Source-Format header to the format of the source image, which differs from the requested format when it's re-encoded
+// The source was just processed, so it's read from the cache of the sources
+func (a *API) setSourceFormat(w http.ResponseWriter, r *http.Request, imageID string) {
+	buffer, err := a.Sources.Get(r.Context(), imageID)
+	if err != nil {
+		a.logError(r, "error getting source image", err)
+		return
+	}
+
+	w.Header().Set("X-Image-Source-Format", image.SniffSourceFormat(buffer).String())
+}
+
+// processingError maps an error from processing an image to the response for it
+func (a *API) processingError(r *http.Request, databaseImage *database.Image, p *params.Params, err error) *handler.Error {
+	if errors.Is(err, image.ErrFrameOutOfRange) {
+		return handler.BadRequest(fmt.Sprintf("Image %s does not have frame %d", databaseImage.ID, p.Frame))
+	}
+
+	if errors.Is(err, image.ErrNoAlphaChannel) {
+		return &handler.Error{Message: fmt.Sprintf("Image %s has no alpha channel to extract", databaseImage.ID), Code: http.StatusUnprocessableEntity}
+	}
+
+	if errors.Is(err, image.ErrSourceTooLarge) {
+		a.logError(r, "source image too large", err)
+		return &handler.Error{Message: fmt.Sprintf("Image %s is too large to process", databaseImage.ID), Code: http.StatusUnprocessableEntity}
+	}
+
+	if errors.Is(err, image.ErrSourceFormatNotAllowed) {
+		a.logError(r, "source image format not allowed", err)
+		return &handler.Error{Message: fmt.Sprintf("Image %s is in a source format that isn't allowed", databaseImage.ID), Code: http.StatusUnprocessableEntity}
+	}
+
+	if errors.Is(err, image.ErrUnsupportedSourceFormat) {
+		a.logError(r, "error decoding source image", err)
+		return &handler.Error{Message: fmt.Sprintf("Image %s could not be decoded", databaseImage.ID), Code: http.StatusUnprocessableEntity}
+	}
+
+	a.logError(r, "error processing image", err)
+	return handler.InternalServerError()
+}
+
 func (a *API) getImage(r *http.Request, imageID string) (*database.Image, *handler.Error) {
+	// Reject malformed ids before they reach the database, and scope them to the tenant
+	imageID, err := params.ResolveImageID(r, a.ImageIDPattern, a.TenantPattern, imageID)
+	if err != nil {
+		return nil, handler.BadRequest(err.Error())
+	}
+
 	databaseImage, err := a.Database.Get(imageID)
 	if err != nil {
 		if err == database.ErrNotFound {
@@ -77,21 +524,202 @@ func (a *API) getImage(r *http.Request, imageID string) (*database.Image, *handl
 	return databaseImage, nil
 }
 
+// getMask gets the mask image from the database, with an error that tells it apart from the image itself
+func (a *API) getMask(r *http.Request, imageID string) (*database.Image, *handler.Error) {
+	databaseImage, handlerErr := a.getImage(r, imageID)
+	if handlerErr != nil && handlerErr.Code == http.StatusNotFound {
+		return nil, &handler.Error{Message: fmt.Sprintf("Mask image %s does not exist", imageID), Code: http.StatusNotFound}
+	}
+
+	return databaseImage, handlerErr
+}
+
 func getOutputFormat(extension string) image.OutputFormat {
 	switch extension {
 	case ".webp":
 		return image.WebP
+	case ".bin":
+		return image.Raw
 	default:
 		return image.JPEG
 	}
 }
 
-func getContentType(extension string) string {
-	switch extension {
-	case ".webp":
-		return "image/webp"
+func getPixelFormat(pixelFormat string) image.PixelFormat {
+	switch pixelFormat {
+	case params.PixelFormatRGBA8:
+		return image.RGBA8
+	case params.PixelFormatGray8:
+		return image.Gray8
+	default:
+		return image.RGB8
+	}
+}
+
+func getBlendMode(mode string) image.BlendMode {
+	switch mode {
+	case params.BlendModeMultiply:
+		return image.Multiply
+	case params.BlendModeScreen:
+		return image.Screen
+	case params.BlendModeOverlay:
+		return image.Overlay
 	default:
-		return "image/jpeg"
+		return image.Normal
+	}
+}
+
+func getColorblindMode(colorblind string) image.ColorblindMode {
+	switch colorblind {
+	case params.ColorblindDeuteranopia:
+		return image.Deuteranopia
+	case params.ColorblindTritanopia:
+		return image.Tritanopia
+	default:
+		return image.Protanopia
+	}
+}
+
+func getChannel(extract string) image.Channel {
+	switch extract {
+	case params.ExtractGreen:
+		return image.GreenChannel
+	case params.ExtractBlue:
+		return image.BlueChannel
+	case params.ExtractAlpha:
+		return image.AlphaChannel
+	case params.ExtractLuminance:
+		return image.LuminanceChannel
+	default:
+		return image.RedChannel
+	}
+}
+
+func getFit(fit string) image.Fit {
+	switch fit {
+	case params.FitContain, params.FitPad:
+		return image.Contain
+	case params.FitFill, params.FitInside, params.FitOutside:
+		// The dimensions of inside and outside already have the aspect ratio of the image, so there's nothing to crop
+		return image.Fill
+	default:
+		return image.Cover
+	}
+}
+
+// relativeBlurSize is the image size that relative blur amounts apply to as is, they're scaled with the longest side of the image from there
+const relativeBlurSize = 500
+
+// minRelativeBlur is the least blur that relative blur amounts are scaled down to for small images
+const minRelativeBlur = 0.5
+
+// getBlurAmount returns the blur to apply to an image of the given size
+// Relative blur scales with the size, so that the image looks equally blurred at every size
+func getBlurAmount(p *params.Params, width int, height int) float64 {
+	if p.BlurScale != params.BlurScaleRelative {
+		return float64(p.BlurAmount)
+	}
+
+	size := width
+	if height > size {
+		size = height
+	}
+
+	return math.Max(float64(p.BlurAmount)*float64(size)/relativeBlurSize, minRelativeBlur)
+}
+
+// clampBlur caps the blur to the configured ratio of the shorter side of the image, as larger blurs take long to compute and only leave a smear of color
+func (a *API) clampBlur(r *http.Request, amount float64, width int, height int) float64 {
+	if a.MaxBlurRatio <= 0 {
+		return amount
+	}
+
+	size := width
+	if height < size {
+		size = height
+	}
+
+	max := math.Max(a.MaxBlurRatio*float64(size), minRelativeBlur)
+	if amount <= max {
+		return amount
+	}
+
+	a.Log.Debugw("clamped blur", handler.LogFields(r, "blur", amount, "max-blur", max, "width", width, "height", height)...)
+	return max
+}
+
+func getBlurEdge(edge string) image.Edge {
+	switch edge {
+	case params.BlurEdgeMirror:
+		return image.MirrorEdge
+	case params.BlurEdgeWrap:
+		return image.WrapEdge
+	default:
+		return image.ExtendEdge
+	}
+}
+
+func getResizeFilter(filter string) image.ResizeFilter {
+	switch filter {
+	case params.ResizeFilterCubic:
+		return image.Cubic
+	case params.ResizeFilterLinear:
+		return image.Linear
+	case params.ResizeFilterNearest:
+		return image.Nearest
+	default:
+		return image.Lanczos
+	}
+}
+
+func getColorSpace(colorSpace string) image.ColorSpace {
+	switch colorSpace {
+	case params.ColorSpaceDisplayP3:
+		return image.DisplayP3
+	default:
+		return image.SRGB
+	}
+}
+
+// placeholderSize is the max width and height of blurred images that are processed as placeholders, even without the placeholder param
+const placeholderSize = 64
+
+// defaultTextColor is the color used for text when no text color is requested
+var defaultTextColor = image.Color{R: 255, G: 255, B: 255}
+
+// defaultBackground is the color used for padding when no background color is requested
+var defaultBackground = image.Color{R: 255, G: 255, B: 255}
+
+// getColor parses a hex color, returning fallback if it's not set
+func getColor(color string, fallback image.Color) image.Color {
+	value, err := strconv.ParseUint(color, 16, 32)
+	if err != nil {
+		return fallback
+	}
+
+	return image.Color{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value)}
+}
+
+func getGravity(gravity string) image.Gravity {
+	switch gravity {
+	case params.GravityNorth:
+		return image.North
+	case params.GravityNorthEast:
+		return image.NorthEast
+	case params.GravityEast:
+		return image.East
+	case params.GravitySouthEast:
+		return image.SouthEast
+	case params.GravitySouth:
+		return image.South
+	case params.GravitySouthWest:
+		return image.SouthWest
+	case params.GravityWest:
+		return image.West
+	case params.GravityNorthWest:
+		return image.NorthWest
+	default:
+		return image.Center
 	}
 }
 
@@ -110,3 +738,13 @@ func buildFilename(imageID string, p *params.Params, width int, height int) stri
 
 	return filename
 }
+
+// invalidParams returns the error for params that are well-formed, but out of range or contradicting each other
+// They're bad requests like malformed params, unless the API is configured to tell them apart as unprocessable
+func (a *API) invalidParams(err error) *handler.Error {
+	if a.Unprocessable {
+		return handler.UnprocessableEntity(err.Error())
+	}
+
+	return handler.BadRequest(err.Error())
+}
@@ -0,0 +1,67 @@
+package imageapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/health"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"go.uber.org/zap"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	mockDatabase "github.com/DMarby/picsum-photos/internal/database/mock"
+	mockProcessor "github.com/DMarby/picsum-photos/internal/image/mock"
+	fileStorage "github.com/DMarby/picsum-photos/internal/storage/file"
+)
+
+func TestImageIDPattern(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	// The mock database fails every lookup, so a 500 means that the lookup was attempted
+	db := &mockDatabase.Provider{}
+	storage, _ := fileStorage.New("../../test/fixtures/file")
+	imageCache := image.NewCache(memoryCache.New(), storage)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Storage:  storage,
+		Database: db,
+		Cache:    memoryCache.New(),
+		Log:      log,
+	}
+	checker.Run()
+
+	pattern, _ := params.ParseImageIDPattern(params.DefaultImageIDPattern)
+	router := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true, ImageIDPattern: pattern}).Router()
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{"valid id", "/id/1/100/100.jpg", http.StatusInternalServerError},
+		{"invalid id", "/id/a_b/100/100.jpg", http.StatusBadRequest},
+		{"invalid id exif", "/id/a-b/exif", http.StatusBadRequest},
+		{"invalid id palette", "/id/a.b/palette", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
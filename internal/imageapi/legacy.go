@@ -0,0 +1,27 @@
+package imageapi
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// ParseUserAgents parses a regular expression matching user agents, which is case insensitive and can match anywhere in the user agent
+// An empty pattern gives a nil regexp, which disables matching the user agents
+func ParseUserAgents(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// legacyClient returns whether the request is from a client that only gets baseline JPEG images, by its user agent
+// The response then depends on the user agent, so caches are told to vary on it whenever the user agents are matched
+func (a *API) legacyClient(w http.ResponseWriter, r *http.Request) bool {
+	if a.LegacyUserAgents == nil {
+		return false
+	}
+
+	w.Header().Add("Vary", "User-Agent")
+	return a.LegacyUserAgents.MatchString(r.UserAgent())
+}
@@ -0,0 +1,80 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestLegacyUserAgents(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	legacy, err := api.ParseUserAgents("msie [5-8]\\.|Nokia")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, LegacyUserAgents: legacy}).Router()
+	disabledRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	const oldUserAgent = "Mozilla/4.0 (compatible; MSIE 6.0; Windows NT 5.1)"
+	const newUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		UserAgent           string
+		ExpectedContentType string
+		ExpectedFormat      image.OutputFormat
+		ExpectedProgressive bool
+		ExpectedVary        string
+	}{
+		{"legacy client", "/id/1/100/100.jpg", router, oldUserAgent, "image/jpeg", image.JPEG, false, "User-Agent"},
+		{"legacy client requesting webp", "/id/1/100/100.webp", router, oldUserAgent, "image/jpeg", image.JPEG, false, "User-Agent"},
+		{"legacy client with format=auto", "/id/1/100/100.jpg?format=auto", router, oldUserAgent, "image/jpeg", image.JPEG, false, "User-Agent"},
+		{"case insensitive", "/id/1/100/100.jpg", router, "NOKIA6230/2.0", "image/jpeg", image.JPEG, false, "User-Agent"},
+		{"other client", "/id/1/100/100.jpg", router, newUserAgent, "image/jpeg", image.JPEG, true, "User-Agent"},
+		{"other client requesting webp", "/id/1/100/100.webp", router, newUserAgent, "image/webp", image.WebP, true, "User-Agent"},
+		{"no user agent", "/id/1/100/100.webp", router, "", "image/webp", image.WebP, true, "User-Agent"},
+		{"disabled", "/id/1/100/100.webp", disabledRouter, oldUserAgent, "image/webp", image.WebP, true, ""},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		req.Header.Set("User-Agent", test.UserAgent)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if vary := w.Header().Get("Vary"); vary != test.ExpectedVary {
+			t.Errorf("%s: wrong vary %s", test.Name, vary)
+		}
+
+		if processor.task.OutputFormat != test.ExpectedFormat || processor.task.ProgressiveJPEG != test.ExpectedProgressive {
+			t.Errorf("%s: wrong format %d, progressive %t", test.Name, processor.task.OutputFormat, processor.task.ProgressiveJPEG)
+		}
+	}
+
+	if _, err := api.ParseUserAgents("MSIE ("); err == nil {
+		t.Error("no error for an invalid pattern")
+	}
+}
@@ -0,0 +1,84 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestLossless(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	// The lossless=auto decision of the image is cached, so it's not classified with the recording processor
+	formatCache := memoryCache.New()
+	formatCache.Set("lossless:1", []byte("true"))
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: formatCache, Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name             string
+		URL              string
+		ExpectedStatus   int
+		ExpectedLossless bool
+		ExpectedLevel    int
+	}{
+		{"lossy by default", "/id/1/100/100.webp", http.StatusOK, false, 0},
+		{"lossless", "/id/1/100/100.webp?lossless", http.StatusOK, true, image.MaxLosslessLevel},
+		{"near lossless", "/id/1/100/100.webp?nearlossless=60", http.StatusOK, true, 60},
+		{"near lossless of 0", "/id/1/100/100.webp?nearlossless=0", http.StatusOK, true, 0},
+		{"lossless with near lossless", "/id/1/100/100.webp?lossless&nearlossless=60", http.StatusBadRequest, false, 0},
+		{"near lossless with quality", "/id/1/100/100.webp?nearlossless=60&quality=80", http.StatusBadRequest, false, 0},
+		{"invalid near lossless", "/id/1/100/100.webp?nearlossless=101", http.StatusBadRequest, false, 0},
+		{"auto lossless of a graphic", "/id/1/100/100.webp?lossless=auto", http.StatusOK, true, image.MaxLosslessLevel},
+		{"auto lossless of a jpeg", "/id/1/100/100.jpg?lossless=auto", http.StatusOK, false, 0},
+		{"auto lossless with quality", "/id/1/100/100.webp?lossless=auto&quality=80", http.StatusBadRequest, false, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			if processor.task != nil {
+				t.Errorf("%s: processed the image", test.Name)
+			}
+			continue
+		}
+
+		if processor.task.EncodeLossless != test.ExpectedLossless || processor.task.LosslessLevel != test.ExpectedLevel {
+			t.Errorf("%s: wrong lossless %t with level %d", test.Name, processor.task.EncodeLossless, processor.task.LosslessLevel)
+		}
+	}
+
+	t.Run("auto lossless of a photo", func(t *testing.T) {
+		formatCache.Set("lossless:1", []byte("false"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/100/100.webp?lossless=auto", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if processor.task.EncodeLossless {
+			t.Error("photo is encoded losslessly")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package imageapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// notModified sets the Last-Modified header to when the newest of the source images was last modified,
+// and returns whether the copy the client has from If-Modified-Since is still current
+// The sources are the image along with the images it's blended with, overlaid with and masked by, as changing any of them changes the image
+// Storage that can't report modification times is skipped, as the request then can't be conditional
+func (a *API) notModified(w http.ResponseWriter, r *http.Request, imageIDs ...string) bool {
+	var modTime time.Time
+	for _, imageID := range imageIDs {
+		sourceModTime := a.sourceModTime(r, imageID)
+		if sourceModTime.IsZero() {
+			return false
+		}
+
+		if sourceModTime.After(modTime) {
+			modTime = sourceModTime
+		}
+	}
+
+	if modTime.IsZero() {
+		return false
+	}
+
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !modTime.After(since)
+}
+
+// sourceModTime returns when the source image was last modified, in UTC and truncated to the second precision of HTTP dates
+// It's the zero time when the storage can't report modification times
+func (a *API) sourceModTime(r *http.Request, imageID string) time.Time {
+	if a.SourceModTime == nil {
+		return time.Time{}
+	}
+
+	modTime, err := a.SourceModTime.ModTime(r.Context(), imageID)
+	if err != nil {
+		a.logError(r, "error getting source image modification time", err)
+		return time.Time{}
+	}
+
+	if modTime.IsZero() {
+		return time.Time{}
+	}
+
+	return modTime.UTC().Truncate(time.Second)
+}
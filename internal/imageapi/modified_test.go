@@ -0,0 +1,65 @@
+package imageapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// fixedModTimes reports fixed modification times for the images
+type fixedModTimes map[string]time.Time
+
+func (m fixedModTimes) ModTime(ctx context.Context, id string) (time.Time, error) {
+	return m[id], nil
+}
+
+func TestNotModified(t *testing.T) {
+	f := newFixtures("metadata_multiple.json")
+	defer f.Close()
+
+	// The secondary source was modified after the image
+	imageModTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sourceModTime := imageModTime.Add(24 * time.Hour)
+	modTimes := fixedModTimes{"1": imageModTime, "2": sourceModTime}
+
+	router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), SourceModTime: modTimes, Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	// The client's copy is from after the image was modified, but before the secondary source was
+	since := imageModTime.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		Name            string
+		URL             string
+		ExpectedStatus  int
+		ExpectedLastMod time.Time
+	}{
+		{"image", "/id/1/200/120.jpg", http.StatusNotModified, imageModTime},
+		{"blended with a newer image", "/id/1/200/120.jpg?blend=2", http.StatusOK, sourceModTime},
+		{"overlaid with a newer image", "/id/1/200/120.jpg?overlay=2", http.StatusOK, sourceModTime},
+		{"masked by a newer image", "/id/1/200/120.webp?mask=2", http.StatusOK, sourceModTime},
+		{"newer image blended with an older one", "/id/2/200/120.jpg?blend=1", http.StatusOK, sourceModTime},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		req.Header.Set("If-Modified-Since", since)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if lastModified := w.Header().Get("Last-Modified"); lastModified != test.ExpectedLastMod.Format(http.TimeFormat) {
+			t.Errorf("%s: wrong last modified header, %#v", test.Name, lastModified)
+		}
+	}
+}
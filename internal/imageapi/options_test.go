@@ -0,0 +1,43 @@
+package imageapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/params"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestOptions(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "/id/1/200/300.jpg", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("wrong response code, %#v", w.Code)
+	}
+
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("wrong allow header %s", allow)
+	}
+
+	var route params.Route
+	if err := json.Unmarshal(w.Body.Bytes(), &route); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(route.Params) != len(params.DescribeImageParams()) {
+		t.Errorf("wrong params %#v", route.Params)
+	}
+}
@@ -0,0 +1,46 @@
+package imageapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// originalContentSecurityPolicy keeps SVG sources from running scripts, as they're served from the same origin as everything else
+const originalContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; sandbox"
+
+// originalHandler returns the unprocessed bytes of the source image, supporting range requests for partial downloads
+// The content type is sniffed from the source, as it's stored the same way regardless of its format
+func (a *API) originalHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	buffer, err := a.Sources.Get(r.Context(), databaseImage.ID)
+	if err != nil {
+		a.logError(r, "error getting source image", err)
+		return handler.InternalServerError()
+	}
+
+	format := image.SniffSourceFormat(buffer)
+	_, filename := params.SplitTenantImageID(databaseImage.ID)
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.%s\"", filename, format))
+	w.Header().Set("Content-Security-Policy", originalContentSecurityPolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+
+	// ServeContent handles the range and conditional requests, and HEAD requests
+	http.ServeContent(w, r, "", a.sourceModTime(r, databaseImage.ID), bytes.NewReader(buffer))
+
+	return nil
+}
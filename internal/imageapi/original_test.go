@@ -0,0 +1,148 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/health"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"go.uber.org/zap"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
+	mockProcessor "github.com/DMarby/picsum-photos/internal/image/mock"
+	fileStorage "github.com/DMarby/picsum-photos/internal/storage/file"
+)
+
+func TestOriginal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	// The sources are all stored as .jpg, regardless of their format
+	dir, err := ioutil.TempDir("", "original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jpeg, _ := ioutil.ReadFile("../../test/fixtures/file/1.jpg")
+	png, _ := ioutil.ReadFile("../../test/fixtures/file/quadrants.jpg")
+	webp := []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+	sources := map[string][]byte{"jpeg": jpeg, "png": png, "webp": webp}
+	for id, data := range sources {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".jpg"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata := []byte(`[{"id":"jpeg","width":300,"height":400},{"id":"png","width":200,"height":100},{"id":"webp","width":1,"height":1}]`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "metadata.json"), metadata, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, _ := fileStorage.New(dir)
+	db, _ := fileDatabase.New(filepath.Join(dir, "metadata.json"))
+	imageCache := image.NewCache(memoryCache.New(), storage)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Storage:  storage,
+		Database: db,
+		Cache:    memoryCache.New(),
+		Log:      log,
+	}
+	checker.Run()
+
+	router := (&api.API{ImageProcessor: &mockProcessor.Processor{}, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), SourceModTime: storage, Sources: imageCache, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name                string
+		ID                  string
+		ExpectedContentType string
+	}{
+		{"jpeg", "jpeg", "image/jpeg"},
+		{"png", "png", "image/png"},
+		{"webp", "webp", "image/webp"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/"+test.ID+"/original", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if acceptRanges := w.Header().Get("Accept-Ranges"); acceptRanges != "bytes" {
+			t.Errorf("%s: wrong accept ranges %s", test.Name, acceptRanges)
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), sources[test.ID]) {
+			t.Errorf("%s: wrong response", test.Name)
+		}
+	}
+
+	t.Run("range request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/jpeg/original", nil)
+		req.Header.Set("Range", "bytes=0-99")
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentRange := w.Header().Get("Content-Range"); contentRange != fmt.Sprintf("bytes 0-99/%d", len(jpeg)) {
+			t.Errorf("wrong content range %s", contentRange)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), jpeg[:100]) {
+			t.Errorf("wrong response %d bytes", w.Body.Len())
+		}
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/webp/original", nil)
+		req.Header.Set("Range", "bytes=1000-")
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("wrong response code, %#v", w.Code)
+		}
+	})
+
+	t.Run("nonexistent image", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/nonexistant/original", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("wrong response code, %#v", w.Code)
+		}
+	})
+}
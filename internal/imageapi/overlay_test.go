@@ -0,0 +1,63 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestOverlay(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name            string
+		URL             string
+		ExpectedStatus  int
+		ExpectedImageID string
+		ExpectedGravity image.Gravity
+		ExpectedOpacity float64
+		ExpectedPercent int
+	}{
+		{"no overlay", "/id/1/100/100.jpg", http.StatusOK, "", image.Center, 0, 0},
+		{"defaults", "/id/1/100/100.jpg?overlay=1", http.StatusOK, "1", image.SouthEast, 1, 20},
+		{"position, opacity and size", "/id/1/100/100.jpg?overlay=1&overlaypos=northwest&overlayopacity=80&overlaysize=50", http.StatusOK, "1", image.NorthWest, 0.8, 50},
+		{"no opacity", "/id/1/100/100.jpg?overlay=1&overlayopacity=0", http.StatusOK, "1", image.SouthEast, 0, 20},
+		{"missing overlay", "/id/1/100/100.jpg?overlay=nonexistant", http.StatusNotFound, "", image.Center, 0, 0},
+		{"overlay size without overlay", "/id/1/100/100.jpg?overlaysize=50", http.StatusBadRequest, "", image.Center, 0, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			if processor.task != nil {
+				t.Errorf("%s: processed the image", test.Name)
+			}
+			continue
+		}
+
+		task := processor.task
+		if task.OverlayImageID != test.ExpectedImageID || task.OverlayGravity != test.ExpectedGravity || task.OverlayOpacity != test.ExpectedOpacity || task.OverlayPercent != test.ExpectedPercent {
+			t.Errorf("%s: wrong overlay %s at %d with opacity %v and size %d", test.Name, task.OverlayImageID, task.OverlayGravity, task.OverlayOpacity, task.OverlayPercent)
+		}
+	}
+}
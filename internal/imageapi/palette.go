@@ -0,0 +1,97 @@
+package imageapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/palette"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/gorilla/mux"
+)
+
+// palettePrecision is how much of the image a palette is computed from
+type palettePrecision struct {
+	Size       int // The size that the image is scaled down to fit within before quantizing it
+	Iterations int // How many k-means iterations refine the colors of the median cut
+}
+
+// palettePrecisions are the settings of each precision, medium is plenty for finding the main colors of most images
+// Higher precisions analyze more pixels and refine the colors further, which takes longer but gives more stable colors for busy images
+var palettePrecisions = map[string]palettePrecision{
+	params.PrecisionLow:    {50, 0},
+	params.PrecisionMedium: {100, 0},
+	params.PrecisionHigh:   {200, 5},
+}
+
+// paletteHandler returns the main colors of the image as JSON, with how much of the image they cover
+func (a *API) paletteHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	n, err := params.GetPaletteSize(r)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	precision, err := params.GetPalettePrecision(r)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	key := fmt.Sprintf("palette:%s:%d:%s", databaseImage.ID, n, precision)
+	data, err := a.FormatCache.Get(key)
+	if err != nil {
+		if err != cache.ErrNotFound {
+			a.logError(r, "error getting palette from cache", err)
+		}
+
+		data, handlerErr = a.readPalette(r, databaseImage, n, palettePrecisions[precision])
+		if handlerErr != nil {
+			return handlerErr
+		}
+
+		if err := a.FormatCache.Set(key, data); err != nil {
+			a.logError(r, "error caching palette", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+	w.Write(data)
+
+	return nil
+}
+
+// readPalette quantizes a scaled down version of the image into n colors with the given precision, and encodes them
+func (a *API) readPalette(r *http.Request, databaseImage *database.Image, n int, precision palettePrecision) ([]byte, *handler.Error) {
+	task := image.NewTask(databaseImage.ID, precision.Size, precision.Size, "", image.JPEG).Fit(image.Contain).Quality(95)
+	processedImage, err := a.ImageProcessor.ProcessImage(r.Context(), task)
+	if err != nil {
+		return nil, a.processingError(r, databaseImage, &params.Params{}, err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(processedImage))
+	if err != nil {
+		a.logError(r, "error decoding scaled down image", err)
+		return nil, handler.InternalServerError()
+	}
+
+	data, err := json.Marshal(palette.Extract(decoded, n, precision.Iterations))
+	if err != nil {
+		a.logError(r, "error encoding palette", err)
+		return nil, handler.InternalServerError()
+	}
+
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,174 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// halvesProcessor returns a JPEG image of the task size that's red in the left half and blue in the right half
+type halvesProcessor struct {
+	tasks int
+	width int
+}
+
+func (p *halvesProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	p.tasks++
+	p.width = task.Width
+
+	img := goimage.NewRGBA(goimage.Rect(0, 0, task.Width, task.Height))
+	for y := 0; y < task.Height; y++ {
+		for x := 0; x < task.Width; x++ {
+			if x < task.Width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: task.EncodeQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestPalette(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &halvesProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	t.Run("returns the main colors", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/id/1/palette?n=2", nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("wrong response code, %#v", w.Code)
+			}
+
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("wrong content type %s", contentType)
+			}
+
+			var colors []struct {
+				Hex      string  `json:"hex"`
+				Coverage float64 `json:"coverage"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &colors); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(colors) != 2 {
+				t.Fatalf("wrong amount of colors %s", w.Body.String())
+			}
+
+			// The colors are sorted by coverage, which is about the same for both, so compare them in either order
+			red, blue := colors[0], colors[1]
+			if closeHex(t, blue.Hex, 255, 0, 0) {
+				red, blue = blue, red
+			}
+
+			if !closeHex(t, red.Hex, 255, 0, 0) || !closeHex(t, blue.Hex, 0, 0, 255) {
+				t.Errorf("wrong colors %s", w.Body.String())
+			}
+
+			if math.Abs(red.Coverage-50) > 5 || math.Abs(blue.Coverage-50) > 5 {
+				t.Errorf("wrong coverage %s", w.Body.String())
+			}
+		}
+
+		// The second request is served from the cache
+		if processor.tasks != 1 {
+			t.Errorf("processed the image %d times", processor.tasks)
+		}
+	})
+
+	t.Run("analyzes more of the image with a higher precision", func(t *testing.T) {
+		tests := []struct {
+			Precision     string
+			ExpectedWidth int
+		}{
+			{"low", 50},
+			{"medium", 100},
+			{"high", 200},
+		}
+
+		for _, test := range tests {
+			// The palettes of each precision are cached separately
+			processor.width = 0
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/id/1/palette?n=3&precision="+test.Precision, nil)
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: wrong response code, %#v", test.Precision, w.Code)
+			}
+
+			if processor.width != test.ExpectedWidth {
+				t.Errorf("%s: wrong analysis size %d", test.Precision, processor.width)
+			}
+		}
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{"default size", "/id/1/palette", http.StatusOK},
+		{"smallest size", "/id/1/palette?n=1", http.StatusOK},
+		{"largest size", "/id/1/palette?n=16", http.StatusOK},
+		{"too few colors", "/id/1/palette?n=0", http.StatusBadRequest},
+		{"too many colors", "/id/1/palette?n=17", http.StatusBadRequest},
+		{"invalid size", "/id/1/palette?n=abc", http.StatusBadRequest},
+		{"low precision", "/id/1/palette?precision=low", http.StatusOK},
+		{"high precision", "/id/1/palette?precision=HIGH", http.StatusOK},
+		{"invalid precision", "/id/1/palette?precision=max", http.StatusBadRequest},
+		{"nonexistent image", "/id/nonexistant/palette", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
+
+// closeHex returns whether a #rrggbb color is close to the given color, allowing for JPEG artifacts
+func closeHex(t *testing.T, hex string, r, g, b int) bool {
+	t.Helper()
+
+	value, err := strconv.ParseUint(hex[1:], 16, 32)
+	if len(hex) != 7 || err != nil {
+		t.Fatalf("invalid color %s", hex)
+	}
+
+	const tolerance = 24
+	return math.Abs(float64(int(value>>16)-r)) <= tolerance &&
+		math.Abs(float64(int(value>>8&0xff)-g)) <= tolerance &&
+		math.Abs(float64(int(value&0xff)-b)) <= tolerance
+}
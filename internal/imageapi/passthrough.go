@@ -0,0 +1,37 @@
+package imageapi
+
+import (
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/exif"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// passthrough returns the source image as it's stored, when processing it would only re-encode it and lose quality
+// That's when a JPEG source is requested as a JPEG at its own size, without any params that change it
+// The other formats are left out, as the output format can't change, and JPEG images with EXIF metadata are as well,
+// as processing strips it, including the orientation and gps location
+// Settings of the service that change every image, such as embedding the color profile, rule it out as well
+func (a *API) passthrough(r *http.Request, p *params.Params, databaseImage *database.Image, task *image.Task) ([]byte, bool) {
+	if p.Extension != ".jpg" || p.AutoFormat || !p.Unprocessed(databaseImage) {
+		return nil, false
+	}
+
+	if task.ApplySharpen || task.EmbedICCProfile || task.OutputDPI > 0 || !task.ProgressiveJPEG {
+		return nil, false
+	}
+
+	// Fall back to processing the image if the source can't be read, which reports the error
+	buffer, err := a.Sources.Get(r.Context(), databaseImage.ID)
+	if err != nil {
+		return nil, false
+	}
+
+	if image.SniffSourceFormat(buffer) != image.SourceJPEG || exif.HasExif(buffer) {
+		return nil, false
+	}
+
+	return buffer, true
+}
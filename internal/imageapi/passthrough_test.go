@@ -0,0 +1,83 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestPassthrough(t *testing.T) {
+	f := newFixtures("metadata_passthrough.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+	dpiRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, DefaultDPI: 300}).Router()
+
+	// plain.jpg is a 64x48 JPEG without any metadata
+	source, err := ioutil.ReadFile("../../test/fixtures/file/plain.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		ExpectedPassthrough bool
+	}{
+		{"source size without effects", "/id/plain/64/48.jpg", router, true},
+		{"resized", "/id/plain/32/24.jpg", router, false},
+		{"effects", "/id/plain/64/48.jpg?grayscale", router, false},
+		{"encoder params", "/id/plain/64/48.jpg?quality=50", router, false},
+		{"device pixel ratio", "/id/plain/32/24.jpg?dpr=2", router, false},
+		{"another format", "/id/plain/64/48.webp", router, false},
+		{"automatic format", "/id/plain/64/48.jpg?format=auto", router, false},
+		{"source with exif metadata", "/id/exif/64/48.jpg", router, false},
+		{"source in another format", "/id/quadrants/200/100.jpg", router, false},
+		{"resolution set by the service", "/id/plain/64/48.jpg", dpiRouter, false},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if passthrough := processor.task == nil; passthrough != test.ExpectedPassthrough {
+			t.Errorf("%s: wrong passthrough %t", test.Name, passthrough)
+			continue
+		}
+
+		if !test.ExpectedPassthrough {
+			continue
+		}
+
+		if !bytes.Equal(w.Body.Bytes(), source) {
+			t.Errorf("%s: didn't return the source image", test.Name)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if contentLength := w.Header().Get("Content-Length"); contentLength != strconv.Itoa(len(source)) {
+			t.Errorf("%s: wrong content length %s", test.Name, contentLength)
+		}
+	}
+}
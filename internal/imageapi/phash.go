@@ -0,0 +1,112 @@
+package imageapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"github.com/DMarby/picsum-photos/internal/phash"
+	"github.com/gorilla/mux"
+)
+
+// phashSize is the size that the image is scaled down to before hashing it, stretching it so that the hash doesn't depend on the aspect ratio
+const phashSize = 32
+
+// phashHandler returns the perceptual hash of the image as JSON
+func (a *API) phashHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	vars := mux.Vars(r)
+	databaseImage, handlerErr := a.getImage(r, vars["id"])
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	hash, handlerErr := a.getPHash(r, databaseImage)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	return a.writeHashJSON(w, r, databaseImage.ID, struct {
+		PHash string `json:"phash"`
+	}{phash.Format(hash)})
+}
+
+// compareHandler returns the distance between the perceptual hashes of two images as JSON, where a low distance means that they're near duplicates
+func (a *API) compareHandler(w http.ResponseWriter, r *http.Request) *handler.Error {
+	first, second, err := params.GetCompareImages(r)
+	if err != nil {
+		return handler.BadRequest(err.Error())
+	}
+
+	var hashes [2]uint64
+	for i, imageID := range []string{first, second} {
+		databaseImage, handlerErr := a.getImage(r, imageID)
+		if handlerErr != nil {
+			return handlerErr
+		}
+
+		hashes[i], handlerErr = a.getPHash(r, databaseImage)
+		if handlerErr != nil {
+			return handlerErr
+		}
+	}
+
+	return a.writeHashJSON(w, r, "", struct {
+		Distance int `json:"distance"`
+	}{phash.Distance(hashes[0], hashes[1])})
+}
+
+// getPHash returns the perceptual hash of the image, which is cached per image
+func (a *API) getPHash(r *http.Request, databaseImage *database.Image) (uint64, *handler.Error) {
+	key := "phash:" + databaseImage.ID
+	data, err := a.FormatCache.Get(key)
+	if err == nil {
+		if hash, err := phash.Parse(string(data)); err == nil {
+			return hash, nil
+		}
+	} else if err != cache.ErrNotFound {
+		a.logError(r, "error getting phash from cache", err)
+	}
+
+	task := image.NewTask(databaseImage.ID, phashSize, phashSize, "", image.JPEG).Fit(image.Fill).Quality(95)
+	processedImage, err := a.ImageProcessor.ProcessImage(r.Context(), task)
+	if err != nil {
+		return 0, a.processingError(r, databaseImage, &params.Params{}, err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(processedImage))
+	if err != nil {
+		a.logError(r, "error decoding scaled down image", err)
+		return 0, handler.InternalServerError()
+	}
+
+	hash := phash.Hash(decoded)
+	if err := a.FormatCache.Set(key, []byte(phash.Format(hash))); err != nil {
+		a.logError(r, "error caching phash", err)
+	}
+
+	return hash, nil
+}
+
+// writeHashJSON writes the response for the hash routes, which don't change as long as the images stay the same
+func (a *API) writeHashJSON(w http.ResponseWriter, r *http.Request, imageID string, response interface{}) *handler.Error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		a.logError(r, "error encoding phash", err)
+		return handler.InternalServerError()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	if imageID != "" {
+		w.Header().Set("Picsum-ID", imageID)
+	}
+	w.Write(append(data, '\n'))
+
+	return nil
+}
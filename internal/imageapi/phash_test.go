@@ -0,0 +1,128 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// gradientProcessor returns a JPEG image of the task size that goes from black on the left to white on the right
+// The gradient goes the other way for the quadrants image, so that it hashes differently
+type gradientProcessor struct {
+	tasks int
+}
+
+func (p *gradientProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	p.tasks++
+
+	img := goimage.NewGray(goimage.Rect(0, 0, task.Width, task.Height))
+	for y := 0; y < task.Height; y++ {
+		for x := 0; x < task.Width; x++ {
+			value := uint8(x * 255 / task.Width)
+			if task.ImageID == "quadrants" {
+				value = 255 - value
+			}
+
+			img.Set(x, y, color.Gray{value})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: task.EncodeQuality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func TestPHash(t *testing.T) {
+	f := newFixtures("metadata_passthrough.json")
+	defer f.Close()
+
+	processor := &gradientProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	get := func(t *testing.T, url string, response interface{}) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("wrong content type %s", contentType)
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("returns the hash of the image", func(t *testing.T) {
+		var hashes [2]struct {
+			PHash string `json:"phash"`
+		}
+		get(t, "/id/plain/phash", &hashes[0])
+		get(t, "/id/plain/phash", &hashes[1])
+
+		if len(hashes[0].PHash) != 16 || hashes[0].PHash != hashes[1].PHash {
+			t.Errorf("wrong hashes %#v", hashes)
+		}
+
+		// The second request is served from the cache
+		if processor.tasks != 1 {
+			t.Errorf("processed the image %d times", processor.tasks)
+		}
+	})
+
+	t.Run("compares the images", func(t *testing.T) {
+		var comparison struct {
+			Distance int `json:"distance"`
+		}
+
+		// Identical images have the same hash
+		get(t, "/compare?a=plain&b=exif", &comparison)
+		if comparison.Distance != 0 {
+			t.Errorf("wrong distance %d for identical images", comparison.Distance)
+		}
+
+		get(t, "/compare?a=plain&b=quadrants", &comparison)
+		if comparison.Distance < 32 {
+			t.Errorf("wrong distance %d for different images", comparison.Distance)
+		}
+	})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+	}{
+		{"nonexistent image", "/id/nonexistant/phash", http.StatusNotFound},
+		{"missing image to compare", "/compare?a=plain", http.StatusBadRequest},
+		{"nonexistent image to compare", "/compare?a=plain&b=nonexistant", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+		}
+	}
+}
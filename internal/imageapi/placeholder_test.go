@@ -0,0 +1,48 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestPlaceholder(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		ExpectedPlaceholder bool
+	}{
+		{"small blurred image", "/id/1/64/32.jpg?blur", true},
+		{"large blurred image", "/id/1/200/200.jpg?blur", false},
+		{"large blurred image with placeholder", "/id/1/200/200.jpg?blur&placeholder", true},
+		{"small image without blur", "/id/1/32/32.jpg", false},
+		{"small image with higher dpr", "/id/1/32/32.jpg?blur&dpr=3", false},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.FastPlaceholder != test.ExpectedPlaceholder {
+			t.Errorf("%s: wrong placeholder %t", test.Name, processor.task.FastPlaceholder)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package imageapi
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// DPRQuality maps device pixel ratios to the encoder quality to use for them
+// High DPR images are displayed at a higher density, so they can use a lower quality per pixel
+type DPRQuality []DPRQualityStep
+
+// DPRQualityStep is the quality to use from a device pixel ratio and up
+type DPRQualityStep struct {
+	DPR     float64
+	Quality int
+}
+
+// ParseDPRQuality parses a comma separated list of dpr:quality pairs, such as "1:75,2:60,3:50"
+func ParseDPRQuality(value string) (DPRQuality, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var steps DPRQuality
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid dpr quality pair %q", pair)
+		}
+
+		dpr, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dpr %q", parts[0])
+		}
+
+		quality, err := strconv.Atoi(parts[1])
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("invalid quality %q", parts[1])
+		}
+
+		steps = append(steps, DPRQualityStep{DPR: dpr, Quality: quality})
+	}
+
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].DPR < steps[j].DPR
+	})
+
+	return steps, nil
+}
+
+// Quality returns the quality for the largest dpr step that's less than or equal to the given dpr
+func (d DPRQuality) Quality(dpr float64) (quality int, ok bool) {
+	for _, step := range d {
+		if step.DPR > dpr {
+			break
+		}
+
+		quality = step.Quality
+		ok = true
+	}
+
+	return
+}
+
+// SizeQuality maps the requested size of images to the encoder quality to use for them
+// Large images are usually viewed from a distance or scaled down in the layout, so they can use a lower quality per pixel,
+// while small thumbnails need a high quality to look crisp
+type SizeQuality []SizeQualityStep
+
+// SizeQualityStep is the quality to use from a size and up, in pixels of the longest side of the requested dimensions
+type SizeQualityStep struct {
+	Size    int
+	Quality int
+}
+
+// ParseSizeQuality parses a comma separated list of size:quality pairs, such as "0:85,500:75,1500:65"
+func ParseSizeQuality(value string) (SizeQuality, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var steps SizeQuality
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid size quality pair %q", pair)
+		}
+
+		size, err := strconv.Atoi(parts[0])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size %q", parts[0])
+		}
+
+		quality, err := strconv.Atoi(parts[1])
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("invalid quality %q", parts[1])
+		}
+
+		steps = append(steps, SizeQualityStep{Size: size, Quality: quality})
+	}
+
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].Size < steps[j].Size
+	})
+
+	return steps, nil
+}
+
+// Quality returns the quality for the largest size step that's less than or equal to the longest side of the dimensions
+func (s SizeQuality) Quality(width, height int) (quality int, ok bool) {
+	size := width
+	if height > size {
+		size = height
+	}
+
+	for _, step := range s {
+		if step.Size > size {
+			break
+		}
+
+		quality = step.Quality
+		ok = true
+	}
+
+	return
+}
+
+// getQuality returns the encoder quality for the request, in the given format and requested dimensions
+// An explicit quality or preset always wins, otherwise it's based on the dpr and the size when a mapping is configured
+// When both the dpr and the size map to a quality, the lower one is used
+func (a *API) getQuality(p *params.Params, format image.OutputFormat, width, height int) int {
+	if p.HasQuality() {
+		return p.Quality
+	}
+
+	if quality, ok := a.QualityPresets.Quality(format, p.QualityPreset); ok {
+		return quality
+	}
+
+	dprQuality, dprOk := a.DPRQuality.Quality(p.DPR)
+	sizeQuality, sizeOk := a.SizeQuality.Quality(width, height)
+	switch {
+	case dprOk && sizeOk && sizeQuality < dprQuality:
+		return sizeQuality
+	case dprOk:
+		return dprQuality
+	case sizeOk:
+		return sizeQuality
+	}
+
+	return image.DefaultQuality
+}
+
+// DefaultQualityPresets is the quality each preset maps to for each format by default
+const DefaultQualityPresets = "jpeg:low=50,medium=75,high=90;webp:low=45,medium=70,high=85"
+
+// QualityPresets maps the quality presets to the encoder quality to use for them, for each format
+type QualityPresets map[image.OutputFormat]map[string]int
+
+// ParseQualityPresets parses a semicolon separated list of formats with comma separated preset=quality pairs,
+// such as "jpeg:low=50,medium=75,high=90;webp:low=45,medium=70,high=85"
+// Every preset has to be given a quality for each format that's listed
+func ParseQualityPresets(value string) (QualityPresets, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	presets := make(QualityPresets)
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid quality presets %q", entry)
+		}
+
+		format, ok := parseFormat(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("invalid format %q", parts[0])
+		}
+
+		qualities := make(map[string]int)
+		for _, pair := range strings.Split(parts[1], ",") {
+			preset := strings.Split(strings.TrimSpace(pair), "=")
+			if len(preset) != 2 || !isQualityPreset(preset[0]) {
+				return nil, fmt.Errorf("invalid quality preset %q", pair)
+			}
+
+			quality, err := strconv.Atoi(preset[1])
+			if err != nil || quality < 1 || quality > 100 {
+				return nil, fmt.Errorf("invalid quality %q", preset[1])
+			}
+
+			qualities[preset[0]] = quality
+		}
+
+		if len(qualities) != len(params.QualityPresets) {
+			return nil, fmt.Errorf("missing quality presets for %s", parts[0])
+		}
+
+		presets[format] = qualities
+	}
+
+	return presets, nil
+}
+
+// Quality returns the quality the preset maps to for the format
+func (q QualityPresets) Quality(format image.OutputFormat, preset string) (quality int, ok bool) {
+	quality, ok = q[format][preset]
+	return
+}
+
+func isQualityPreset(name string) bool {
+	for _, preset := range params.QualityPresets {
+		if name == preset {
+			return true
+		}
+	}
+
+	return false
+}
+
+// presetQualities returns the quality of the requested preset for each format, for picking the format with format=auto
+// While degraded the qualities are capped like the quality of the task
+func (a *API) presetQualities(p *params.Params, degraded bool) map[image.OutputFormat]int {
+	if p.QualityPreset == "" {
+		return nil
+	}
+
+	qualities := make(map[image.OutputFormat]int)
+	for format := range a.QualityPresets {
+		quality, _ := a.QualityPresets.Quality(format, p.QualityPreset)
+		if degraded {
+			quality = a.Degradation.capQuality(quality)
+		}
+
+		qualities[format] = quality
+	}
+
+	return qualities
+}
@@ -0,0 +1,142 @@
+package imageapi
+
+import (
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+func TestGetQuality(t *testing.T) {
+	mapping, err := ParseDPRQuality("2:60, 1:75,3:50")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presets, err := ParseQualityPresets(DefaultQualityPresets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes, err := ParseSizeQuality("0:85,500:75,1500:65")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name            string
+		DPRQuality      DPRQuality
+		SizeQuality     SizeQuality
+		Quality         int
+		QualityPreset   string
+		Format          image.OutputFormat
+		DPR             float64
+		Width           int
+		Height          int
+		ExpectedQuality int
+	}{
+		{"default quality without a mapping", nil, nil, -1, "", image.JPEG, 2, 100, 100, image.DefaultQuality},
+		{"1x", mapping, nil, -1, "", image.JPEG, 1, 100, 100, 75},
+		{"1.5x", mapping, nil, -1, "", image.JPEG, 1.5, 100, 100, 75},
+		{"2x", mapping, nil, -1, "", image.JPEG, 2, 100, 100, 60},
+		{"3x", mapping, nil, -1, "", image.JPEG, 3, 100, 100, 50},
+		{"4x", mapping, nil, -1, "", image.JPEG, 4, 100, 100, 50},
+		{"explicit quality wins", mapping, nil, 90, "", image.JPEG, 3, 100, 100, 90},
+		{"explicit quality without a mapping", nil, nil, 40, "", image.JPEG, 1, 100, 100, 40},
+		{"low jpeg", mapping, nil, -1, params.QualityLow, image.JPEG, 1, 100, 100, 50},
+		{"medium jpeg", mapping, nil, -1, params.QualityMedium, image.JPEG, 1, 100, 100, 75},
+		{"high jpeg", mapping, nil, -1, params.QualityHigh, image.JPEG, 1, 100, 100, 90},
+		{"low webp", mapping, nil, -1, params.QualityLow, image.WebP, 1, 100, 100, 45},
+		{"medium webp", mapping, nil, -1, params.QualityMedium, image.WebP, 1, 100, 100, 70},
+		{"high webp", mapping, nil, -1, params.QualityHigh, image.WebP, 1, 100, 100, 85},
+		{"preset wins over the dpr", mapping, nil, -1, params.QualityHigh, image.JPEG, 3, 100, 100, 90},
+		{"thumbnail", nil, sizes, -1, "", image.JPEG, 1, 100, 100, 85},
+		{"medium size", nil, sizes, -1, "", image.JPEG, 1, 500, 300, 75},
+		{"by the longest side", nil, sizes, -1, "", image.JPEG, 1, 300, 800, 75},
+		{"large size", nil, sizes, -1, "", image.JPEG, 1, 2000, 1000, 65},
+		{"explicit quality wins over the size", nil, sizes, 90, "", image.JPEG, 1, 2000, 1000, 90},
+		{"preset wins over the size", nil, sizes, -1, params.QualityLow, image.JPEG, 1, 2000, 1000, 50},
+		{"lower size quality than the dpr", mapping, sizes, -1, "", image.JPEG, 1, 2000, 1000, 65},
+		{"lower dpr quality than the size", mapping, sizes, -1, "", image.JPEG, 3, 100, 100, 50},
+	}
+
+	for _, test := range tests {
+		a := &API{DPRQuality: test.DPRQuality, SizeQuality: test.SizeQuality, QualityPresets: presets}
+		quality := a.getQuality(&params.Params{Quality: test.Quality, QualityPreset: test.QualityPreset, DPR: test.DPR}, test.Format, test.Width, test.Height)
+		if quality != test.ExpectedQuality {
+			t.Errorf("%s: wrong quality %d", test.Name, quality)
+		}
+	}
+}
+
+func TestParseDPRQuality(t *testing.T) {
+	tests := []struct {
+		Value         string
+		ExpectedError bool
+	}{
+		{"", false},
+		{"1:75", false},
+		{"1:75,2:60,3:50", false},
+		{"1", true},
+		{"a:75", true},
+		{"1:a", true},
+		{"1:0", true},
+		{"1:101", true},
+	}
+
+	for _, test := range tests {
+		_, err := ParseDPRQuality(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+		}
+	}
+}
+
+func TestParseSizeQuality(t *testing.T) {
+	tests := []struct {
+		Value         string
+		ExpectedError bool
+	}{
+		{"", false},
+		{"0:85", false},
+		{"1500:65, 0:85,500:75", false},
+		{"500", true},
+		{"a:75", true},
+		{"-1:75", true},
+		{"500:a", true},
+		{"500:0", true},
+		{"500:101", true},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSizeQuality(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+		}
+	}
+}
+
+func TestParseQualityPresets(t *testing.T) {
+	tests := []struct {
+		Value         string
+		ExpectedError bool
+	}{
+		{"", false},
+		{DefaultQualityPresets, false},
+		{"jpeg:low=40,medium=60,high=80", false},
+		{"jpeg", true},
+		{"png:low=40,medium=60,high=80", true},
+		{"jpeg:low=40,medium=60", true},
+		{"jpeg:lowest=40,medium=60,high=80", true},
+		{"jpeg:low=a,medium=60,high=80", true},
+		{"jpeg:low=0,medium=60,high=80", true},
+		{"jpeg:low=40,medium=60,high=101", true},
+	}
+
+	for _, test := range tests {
+		_, err := ParseQualityPresets(test.Value)
+		if (err != nil) != test.ExpectedError {
+			t.Errorf("%q: wrong error %v", test.Value, err)
+		}
+	}
+}
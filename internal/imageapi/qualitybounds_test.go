@@ -0,0 +1,66 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/params"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestQualityBounds(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	rejectBounds, _ := params.ParseQualityBounds("jpeg:10-90;webp:20-80", false)
+	clampBounds, _ := params.ParseQualityBounds("jpeg:10-90;webp:20-80", true)
+
+	processor := &recordingProcessor{}
+	rejectRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, QualityBounds: rejectBounds}).Router()
+	clampRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, QualityBounds: clampBounds}).Router()
+
+	tests := []struct {
+		Name            string
+		URL             string
+		Router          http.Handler
+		ExpectedStatus  int
+		ExpectedQuality int
+	}{
+		{"jpeg at the max with reject", "/id/1/100/100.jpg?quality=90", rejectRouter, http.StatusOK, 90},
+		{"jpeg above the max with reject", "/id/1/100/100.jpg?quality=91", rejectRouter, http.StatusBadRequest, 0},
+		{"webp at the min with reject", "/id/1/100/100.webp?quality=20", rejectRouter, http.StatusOK, 20},
+		{"webp below the min with reject", "/id/1/100/100.webp?quality=19", rejectRouter, http.StatusBadRequest, 0},
+		{"jpeg above the max with clamp", "/id/1/100/100.jpg?quality=100", clampRouter, http.StatusOK, 90},
+		{"webp below the min with clamp", "/id/1/100/100.webp?quality=1", clampRouter, http.StatusOK, 20},
+		{"within the bounds with clamp", "/id/1/100/100.webp?quality=50", clampRouter, http.StatusOK, 50},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			if processor.task != nil {
+				t.Errorf("%s: processed the image", test.Name)
+			}
+			continue
+		}
+
+		if processor.task == nil || processor.task.EncodeQuality != test.ExpectedQuality {
+			t.Errorf("%s: wrong quality %#v", test.Name, processor.task)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestImageRanges(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	// The recording processor encodes every image to the 5 bytes "image"
+	tests := []struct {
+		Name                 string
+		Method               string
+		Range                string
+		ExpectedStatus       int
+		ExpectedBody         string
+		ExpectedContentRange string
+	}{
+		{"whole image", "GET", "", http.StatusOK, "image", ""},
+		{"range", "GET", "bytes=1-3", http.StatusPartialContent, "mag", "bytes 1-3/5"},
+		{"open ended range", "GET", "bytes=2-", http.StatusPartialContent, "age", "bytes 2-4/5"},
+		{"unsatisfiable range", "GET", "bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */5"},
+		{"HEAD request", "HEAD", "", http.StatusOK, "", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(test.Method, "/id/1/100/100.jpg", nil)
+		if test.Range != "" {
+			req.Header.Set("Range", test.Range)
+		}
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if acceptRanges := w.Header().Get("Accept-Ranges"); acceptRanges != "bytes" {
+			t.Errorf("%s: wrong accept ranges %s", test.Name, acceptRanges)
+		}
+
+		if test.ExpectedStatus != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != test.ExpectedBody {
+			t.Errorf("%s: wrong body %q", test.Name, w.Body.String())
+		}
+
+		if contentRange := w.Header().Get("Content-Range"); contentRange != test.ExpectedContentRange {
+			t.Errorf("%s: wrong content range %s", test.Name, contentRange)
+		}
+	}
+}
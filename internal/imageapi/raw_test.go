@@ -0,0 +1,96 @@
+package imageapi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// rawProcessor records the task, and returns as many bytes as the raw pixels of it have
+type rawProcessor struct {
+	task *image.Task
+}
+
+func (p *rawProcessor) ProcessImage(ctx context.Context, task *image.Task) ([]byte, error) {
+	p.task = task
+	width, height := task.Width, task.Height
+	if task.ApplyPad {
+		width, height = task.CanvasWidth, task.CanvasHeight
+	}
+
+	return make([]byte, width*height*task.RawPixelFormat.Channels()), nil
+}
+
+func TestRawPixels(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &rawProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	// The length of the pixels is always the width times the height times the channels in the headers
+	tests := []struct {
+		Name                string
+		URL                 string
+		ExpectedPixelFormat image.PixelFormat
+		ExpectedHeaders     map[string]string
+	}{
+		{"default pixel format", "/id/1/100/50.bin", image.RGB8, map[string]string{"X-Pixel-Format": "rgb8", "X-Pixel-Width": "100", "X-Pixel-Height": "50", "X-Pixel-Channels": "3"}},
+		{"rgb8", "/id/1/100/50.bin?pixelformat=rgb8", image.RGB8, map[string]string{"X-Pixel-Format": "rgb8", "X-Pixel-Width": "100", "X-Pixel-Height": "50", "X-Pixel-Channels": "3"}},
+		{"rgba8", "/id/1/100/50.bin?pixelformat=RGBA8", image.RGBA8, map[string]string{"X-Pixel-Format": "rgba8", "X-Pixel-Width": "100", "X-Pixel-Height": "50", "X-Pixel-Channels": "4"}},
+		{"gray8", "/id/1/100/50.bin?pixelformat=gray8", image.Gray8, map[string]string{"X-Pixel-Format": "gray8", "X-Pixel-Width": "100", "X-Pixel-Height": "50", "X-Pixel-Channels": "1"}},
+		{"padded", "/id/1/100/50.bin?ratio=1:1", image.RGB8, map[string]string{"X-Pixel-Format": "rgb8", "X-Pixel-Width": "100", "X-Pixel-Height": "100", "X-Pixel-Channels": "3"}},
+		{"dpr", "/id/1/100/50.bin?dpr=2&pixelformat=gray8", image.Gray8, map[string]string{"X-Pixel-Format": "gray8", "X-Pixel-Width": "200", "X-Pixel-Height": "100", "X-Pixel-Channels": "1"}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.OutputFormat != image.Raw || processor.task.RawPixelFormat != test.ExpectedPixelFormat {
+			t.Errorf("%s: wrong output format %v %v", test.Name, processor.task.OutputFormat, processor.task.RawPixelFormat)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/octet-stream" {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		for header, expected := range test.ExpectedHeaders {
+			if value := w.Header().Get(header); value != expected {
+				t.Errorf("%s: wrong %s header %q", test.Name, header, value)
+			}
+		}
+
+		width, _ := strconv.Atoi(w.Header().Get("X-Pixel-Width"))
+		height, _ := strconv.Atoi(w.Header().Get("X-Pixel-Height"))
+		channels, _ := strconv.Atoi(w.Header().Get("X-Pixel-Channels"))
+		if w.Body.Len() != width*height*channels {
+			t.Errorf("%s: wrong length %d", test.Name, w.Body.Len())
+		}
+	}
+
+	// The pixel format is only valid with the .bin extension
+	for _, url := range []string{"/id/1/100/50.bin?pixelformat=rgb16", "/id/1/100/50.jpg?pixelformat=rgba8", "/id/1/100/50.bin?format=auto"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: wrong response code, %#v", url, w.Code)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package imageapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// DefaultSaveDataQuality is the quality of the images for clients that ask to save data
+const DefaultSaveDataQuality = 50
+
+// saveData returns whether the client asks to save data with the Save-Data client hint, when saving data is enabled
+// The response depends on the header when it's enabled, whether the client sent it or not
+func (a *API) saveData(w http.ResponseWriter, r *http.Request) bool {
+	if a.SaveDataQuality == 0 {
+		return false
+	}
+
+	w.Header().Add("Vary", "Save-Data")
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Save-Data")), "on")
+}
+
+// applySaveData lowers the quality of the task to the save data quality, and picks the smallest of the formats the client accepts
+// Params that ask for a quality are kept, and so are formats other than .jpg, which is what the extension defaults to
+// The resolution is only embedded in JPEG images, so those are kept as well
+func (a *API) applySaveData(p *params.Params, task *image.Task) {
+	explicitQuality := p.HasQuality() || p.QualityPreset != "" || p.AutoQuality || p.Lossless || p.AutoLossless || p.HasNearLossless()
+	if !explicitQuality && task.EncodeQuality > a.SaveDataQuality {
+		task.Quality(a.SaveDataQuality)
+	}
+
+	if p.Extension == ".jpg" && !p.HasDPI() {
+		p.AutoFormat = true
+	}
+}
@@ -0,0 +1,69 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestSaveData(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, SaveDataQuality: api.DefaultSaveDataQuality}).Router()
+	disabledRouter := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		SaveData            string
+		ExpectedQuality     int
+		ExpectedContentType string
+		ExpectedVary        []string
+	}{
+		{"without save data", "/id/1/100/100.jpg", router, "", image.DefaultQuality, "image/jpeg", []string{"Save-Data"}},
+		{"save data", "/id/1/100/100.jpg", router, "on", api.DefaultSaveDataQuality, "image/webp", []string{"Save-Data", "Accept"}},
+		{"save data is case insensitive", "/id/1/100/100.jpg", router, "On", api.DefaultSaveDataQuality, "image/webp", []string{"Save-Data", "Accept"}},
+		{"save data off", "/id/1/100/100.jpg", router, "off", image.DefaultQuality, "image/jpeg", []string{"Save-Data"}},
+		{"explicit quality", "/id/1/100/100.jpg?quality=80", router, "on", 80, "image/webp", []string{"Save-Data", "Accept"}},
+		{"explicit format", "/id/1/100/100.webp", router, "on", api.DefaultSaveDataQuality, "image/webp", []string{"Save-Data"}},
+		{"jpeg only resolution", "/id/1/100/100.jpg?dpi=300", router, "on", api.DefaultSaveDataQuality, "image/jpeg", []string{"Save-Data"}},
+		{"disabled", "/id/1/100/100.jpg", disabledRouter, "on", image.DefaultQuality, "image/jpeg", nil},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		req.Header.Set("Accept", "image/webp")
+		if test.SaveData != "" {
+			req.Header.Set("Save-Data", test.SaveData)
+		}
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.EncodeQuality != test.ExpectedQuality {
+			t.Errorf("%s: wrong quality %d", test.Name, processor.task.EncodeQuality)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if vary := w.Header()["Vary"]; !reflect.DeepEqual(vary, test.ExpectedVary) {
+			t.Errorf("%s: wrong vary %#v", test.Name, vary)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package imageapi
+
+import (
+	"math"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+)
+
+// AutoSharpen sharpens images that are scaled down by a lot by default, as downscaling softens the image
+type AutoSharpen struct {
+	// Ratio is how many times smaller than the source image the image has to be to get sharpened, 0 disables it
+	Ratio float64
+	// Sigma is how much the image is sharpened by
+	Sigma float64
+}
+
+// applies returns whether an image resized from the source image to width x height should be sharpened
+// The image is scaled down by at least the smaller of the ratios between the dimensions, regardless of how it's fit
+func (s AutoSharpen) applies(source *database.Image, width int, height int) bool {
+	if s.Ratio <= 0 || width <= 0 || height <= 0 {
+		return false
+	}
+
+	ratio := math.Min(float64(source.Width)/float64(width), float64(source.Height)/float64(height))
+	return ratio >= s.Ratio
+}
@@ -0,0 +1,59 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestAutoSharpen(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	autoSharpen := api.AutoSharpen{Ratio: 2, Sigma: 0.5}
+
+	// The image is 300x400
+	tests := []struct {
+		Name            string
+		AutoSharpen     api.AutoSharpen
+		URL             string
+		ExpectedSharpen bool
+		ExpectedSigma   float64
+	}{
+		{"scaled down past the ratio", autoSharpen, "/id/1/100/100.jpg", true, 0.5},
+		{"scaled down less than the ratio", autoSharpen, "/id/1/200/200.jpg", false, 0},
+		{"scaled down past the ratio in one dimension", autoSharpen, "/id/1/100/300.jpg", false, 0},
+		{"explicit sharpen", autoSharpen, "/id/1/100/100.jpg?sharpen=2", true, 2},
+		{"explicit sharpen below the ratio", autoSharpen, "/id/1/200/200.jpg?sharpen", true, 1},
+		{"sharpen disabled", autoSharpen, "/id/1/100/100.jpg?sharpen=0", false, 0},
+		{"blurred", autoSharpen, "/id/1/100/100.jpg?blur", false, 0},
+		{"nearest neighbor", autoSharpen, "/id/1/100/100.jpg?resize-filter=nearest", false, 0},
+		{"explicit sharpen with nearest neighbor", autoSharpen, "/id/1/100/100.jpg?resize-filter=nearest&sharpen=2", true, 2},
+		{"lanczos", autoSharpen, "/id/1/100/100.jpg?resize-filter=lanczos", true, 0.5},
+		{"auto sharpen disabled", api.AutoSharpen{}, "/id/1/100/100.jpg", false, 0},
+	}
+
+	for _, test := range tests {
+		processor := &recordingProcessor{}
+		router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, AutoSharpen: test.AutoSharpen}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task.ApplySharpen != test.ExpectedSharpen || processor.task.SharpenSigma != test.ExpectedSigma {
+			t.Errorf("%s: wrong sharpen %t with sigma %f", test.Name, processor.task.ApplySharpen, processor.task.SharpenSigma)
+		}
+	}
+}
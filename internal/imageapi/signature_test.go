@@ -0,0 +1,57 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/signature"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestSignature(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	signer := &signature.Signer{Key: []byte("secret")}
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, Signer: signer}).Router()
+
+	signed := signer.SignPath("/id/1/100/100.jpg", url.Values{"blur": {"2"}})
+
+	tests := []struct {
+		Name           string
+		URL            string
+		ExpectedStatus int
+		ExpectedBlur   float64
+	}{
+		{"valid signature", signed + "?blur=2", http.StatusOK, 2},
+		{"tampered path", strings.Replace(signed, "/100/100", "/200/200", 1) + "?blur=2", http.StatusForbidden, 0},
+		{"tampered query", signed + "?blur=5", http.StatusForbidden, 0},
+		{"added query param", signed + "?blur=2&grayscale", http.StatusForbidden, 0},
+		{"missing signature", "/id/1/100/100.jpg?blur=2", http.StatusForbidden, 0},
+		{"health check", "/health", http.StatusOK, 0},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedBlur != 0 && (processor.task == nil || processor.task.BlurAmount != test.ExpectedBlur) {
+			t.Errorf("%s: image wasn't processed with the signed params", test.Name)
+		}
+	}
+}
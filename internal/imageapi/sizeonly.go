@@ -0,0 +1,47 @@
+package imageapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/params"
+)
+
+// sizeResponse is the size of a processed image, for clients that want to know how large it is before downloading it
+type sizeResponse struct {
+	Bytes       int    `json:"bytes"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// writeSize responds with the size of the processed image instead of the image itself
+// The image is processed the same way as when it's returned, so the size matches the Content-Length of the image
+func (a *API) writeSize(w http.ResponseWriter, r *http.Request, p *params.Params, databaseImage *database.Image, width int, height int, processedImage []byte, degraded bool) *handler.Error {
+	data, err := json.Marshal(sizeResponse{
+		Bytes:       len(processedImage),
+		ContentType: getOutputFormat(p.Extension).ContentType(),
+		Width:       width,
+		Height:      height,
+	})
+	if err != nil {
+		a.logError(r, "error encoding size response", err)
+		return handler.InternalServerError()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=2592000") // Cache for a month
+	w.Header().Set("Picsum-ID", databaseImage.ID)
+
+	// The size of degraded images is only valid while the load is high
+	if degraded {
+		w.Header().Set("X-Degraded", "true")
+		w.Header().Set("Cache-Control", degradedMaxAge)
+	}
+
+	w.Write(append(data, '\n'))
+
+	return nil
+}
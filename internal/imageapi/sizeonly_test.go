@@ -0,0 +1,84 @@
+package imageapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestSizeOnly(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		ExpectedContentType string
+		ExpectedWidth       int
+		ExpectedHeight      int
+	}{
+		{"jpeg", "/id/1/100/200.jpg", "image/jpeg", 100, 200},
+		{"webp with params", "/id/1/100/100.webp?blur=2&grayscale", "image/webp", 100, 100},
+		{"padded", "/id/1/100/100.jpg?ratio=2:1", "image/jpeg", 200, 100},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code for the image, %#v", test.Name, w.Code)
+			continue
+		}
+
+		contentLength := w.Header().Get("Content-Length")
+
+		separator := "?"
+		if strings.Contains(test.URL, "?") {
+			separator = "&"
+		}
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", test.URL+separator+"sizeonly", nil)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code for the size, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		var size struct {
+			Bytes       int    `json:"bytes"`
+			ContentType string `json:"content_type"`
+			Width       int    `json:"width"`
+			Height      int    `json:"height"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &size); err != nil {
+			t.Errorf("%s: invalid response %s", test.Name, w.Body.String())
+			continue
+		}
+
+		if strconv.Itoa(size.Bytes) != contentLength {
+			t.Errorf("%s: size %d doesn't match the content length %s", test.Name, size.Bytes, contentLength)
+		}
+
+		if size.ContentType != test.ExpectedContentType || size.Width != test.ExpectedWidth || size.Height != test.ExpectedHeight {
+			t.Errorf("%s: wrong size %+v", test.Name, size)
+		}
+	}
+}
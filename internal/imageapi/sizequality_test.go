@@ -0,0 +1,55 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestSizeQuality(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	sizes, _ := api.ParseSizeQuality("0:85,200:75,300:65")
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, SizeQuality: sizes}).Router()
+
+	// The source image is 300x400
+	tests := []struct {
+		Name            string
+		URL             string
+		ExpectedQuality int
+	}{
+		{"thumbnail", "/id/1/100/100.jpg", 85},
+		{"medium size", "/id/1/250/100.jpg", 75},
+		{"by the longest side", "/id/1/100/250.webp", 75},
+		{"large size", "/id/1/300/300.jpg", 65},
+		{"size of the source", "/id/1/0/0.jpg", 65},
+		{"before the dpr", "/id/1/100/100.jpg?dpr=3", 85},
+		{"explicit quality", "/id/1/300/300.jpg?quality=95", 95},
+	}
+
+	for _, test := range tests {
+		processor.task = nil
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if processor.task == nil || processor.task.EncodeQuality != test.ExpectedQuality {
+			t.Errorf("%s: wrong quality %#v", test.Name, processor.task)
+		}
+	}
+}
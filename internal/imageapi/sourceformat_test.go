@@ -0,0 +1,47 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestSourceFormatHeader(t *testing.T) {
+	f := newFixtures("metadata_exif.json")
+	defer f.Close()
+
+	// exif.jpg is a JPEG, and quadrants.jpg is a PNG
+	tests := []struct {
+		Name           string
+		URL            string
+		SourceHeader   bool
+		ExpectedFormat string
+	}{
+		{"jpeg source", "/id/exif/100/100.webp", true, "jpeg"},
+		{"png source", "/id/quadrants/100/100.jpg", true, "png"},
+		{"disabled", "/id/quadrants/100/100.jpg", false, ""},
+	}
+
+	for _, test := range tests {
+		router := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, SourceHeader: test.SourceHeader}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if format := w.Header().Get("X-Image-Source-Format"); format != test.ExpectedFormat {
+			t.Errorf("%s: wrong source format %q", test.Name, format)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package imageapi_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/health"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/params"
+	"go.uber.org/zap"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+	fileDatabase "github.com/DMarby/picsum-photos/internal/database/file"
+	fileStorage "github.com/DMarby/picsum-photos/internal/storage/file"
+)
+
+func TestTenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	// The images of tenants are stored under a directory for the tenant
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sources := map[string]string{
+		"1.jpg":        "../../test/fixtures/file/1.jpg",
+		"acme/1.jpg":   "../../test/fixtures/file/1.jpg",
+		"globex/1.jpg": "../../test/fixtures/file/quadrants.jpg",
+	}
+	for name, fixture := range sources {
+		data, _ := ioutil.ReadFile(fixture)
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, _ := fileStorage.New(dir)
+	db, _ := fileDatabase.New("../../test/fixtures/file/metadata_tenants.json")
+	imageCache := image.NewCache(memoryCache.New(), storage)
+
+	checker := &health.Checker{
+		Ctx:      ctx,
+		Storage:  storage,
+		Database: db,
+		Cache:    memoryCache.New(),
+		Log:      log,
+	}
+	checker.Run()
+
+	pattern, _ := params.ParseImageIDPattern(params.DefaultImageIDPattern)
+	tenants, _ := params.ParseImageIDPattern("acme|globex")
+
+	processor := &recordingProcessor{}
+	router := (&api.API{ImageProcessor: processor, Database: db, HealthChecker: checker, Log: log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: imageCache, OptimizeCoding: true, ImageIDPattern: pattern, TenantPattern: tenants}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		ExpectedStatus      int
+		ExpectedContentType string
+		ExpectedPicsumID    string
+	}{
+		{"image of a tenant", "/t/acme/id/1/original", http.StatusOK, "image/jpeg", "acme/1"},
+		{"same id in another tenant", "/t/globex/id/1/original", http.StatusOK, "image/png", "globex/1"},
+		{"same id without a tenant", "/id/1/original", http.StatusOK, "image/jpeg", "1"},
+		{"unknown tenant", "/t/initech/id/1/original", http.StatusBadRequest, "", ""},
+		{"id of another tenant", "/t/acme/id/1/100/100.jpg?blend=globex/1", http.StatusBadRequest, "", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if test.ExpectedStatus != http.StatusOK {
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+
+		if picsumID := w.Header().Get("Picsum-ID"); picsumID != test.ExpectedPicsumID {
+			t.Errorf("%s: wrong picsum id %s", test.Name, picsumID)
+		}
+	}
+
+	t.Run("processes the image of the tenant", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/t/globex/id/1/100/100.jpg?blend=1", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("wrong response code, %#v", w.Code)
+		}
+
+		if processor.task.ImageID != "globex/1" || processor.task.BlendImageID != "globex/1" {
+			t.Errorf("wrong images %s and %s", processor.task.ImageID, processor.task.BlendImageID)
+		}
+	})
+}
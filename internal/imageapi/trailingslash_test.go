@@ -0,0 +1,51 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/handler"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	redirect := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true}).Router()
+	accept := (&api.API{ImageProcessor: &recordingProcessor{}, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: memoryCache.New(), Sources: f.Sources, OptimizeCoding: true, TrailingSlash: handler.AcceptTrailingSlash}).Router()
+
+	tests := []struct {
+		Name                string
+		URL                 string
+		Router              http.Handler
+		ExpectedStatus      int
+		ExpectedContentType string
+	}{
+		{"uppercase extension", "/id/1/100/100.JPG", redirect, http.StatusOK, "image/jpeg"},
+		{"mixed case extension", "/id/1/100/100.WebP", redirect, http.StatusOK, "image/webp"},
+		{"trailing slash redirected", "/id/1/100/100.jpg/", redirect, http.StatusMovedPermanently, ""},
+		{"trailing slash accepted", "/id/1/100/100.jpg/", accept, http.StatusOK, "image/jpeg"},
+		{"trailing slash and uppercase extension accepted", "/id/1/100/100.WEBP/", accept, http.StatusOK, "image/webp"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", test.URL, nil)
+		test.Router.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedStatus {
+			t.Errorf("%s: wrong response code, %#v", test.Name, w.Code)
+			continue
+		}
+
+		if contentType := w.Header().Get("Content-Type"); test.ExpectedContentType != "" && contentType != test.ExpectedContentType {
+			t.Errorf("%s: wrong content type %s", test.Name, contentType)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package imageapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/image"
+	api "github.com/DMarby/picsum-photos/internal/imageapi"
+
+	memoryCache "github.com/DMarby/picsum-photos/internal/cache/memory"
+)
+
+// keyRecordingCache records the keys that are set in the cache
+type keyRecordingCache struct {
+	cache.Provider
+	keys []string
+}
+
+func (c *keyRecordingCache) Set(key string, data []byte) error {
+	c.keys = append(c.keys, key)
+	return c.Provider.Set(key, data)
+}
+
+func TestProcessingVersion(t *testing.T) {
+	f := newFixtures("metadata.json")
+	defer f.Close()
+
+	// Both versions share the cache, like the instances of a deployment that's rolled out to the new version
+	formatCache := &keyRecordingCache{Provider: memoryCache.New()}
+	processor := &recordingProcessor{}
+
+	var tasks []*image.Task
+	for _, version := range []string{"1", "2"} {
+		router := (&api.API{ImageProcessor: processor, Database: f.Database, HealthChecker: f.Checker, Log: f.Log, HandlerTimeout: time.Minute, EncodeEffort: image.DefaultEncodeEffort, FormatCache: formatCache, Sources: f.Sources, OptimizeCoding: true, ProcessingVersion: version}).Router()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/id/1/100/100.jpg?format=auto", nil)
+		req.Header.Set("Accept", "image/webp,image/*")
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("version %s: wrong response code, %#v", version, w.Code)
+		}
+
+		tasks = append(tasks, processor.task)
+	}
+
+	if tasks[0].Key() == tasks[1].Key() {
+		t.Errorf("same task key for both versions %s", tasks[0].Key())
+	}
+
+	// The format decision of the old version isn't used by the new one, which makes its own
+	var formatKeys []string
+	for _, key := range formatCache.keys {
+		if strings.HasPrefix(key, "format:") {
+			formatKeys = append(formatKeys, key)
+		}
+	}
+
+	if len(formatKeys) != 2 || formatKeys[0] == formatKeys[1] {
+		t.Errorf("wrong format keys %v", formatKeys)
+	}
+}
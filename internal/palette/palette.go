@@ -0,0 +1,171 @@
+package palette
+
+import (
+	"fmt"
+	goimage "image"
+	"math"
+	"sort"
+)
+
+// Color is a color of the palette, and how much of the image it covers
+type Color struct {
+	Hex      string  `json:"hex"`
+	Coverage float64 `json:"coverage"`
+}
+
+// box is a group of pixels with similar colors
+type box [][3]uint8
+
+// Extract returns up to n colors of the image using median cut quantization, ordered by how much of the image they cover
+// The colors are then refined with the given amount of k-means iterations, which makes them more accurate but takes longer
+// Coverage is the percentage of the pixels that are grouped into the color, rounded to one decimal
+// Images with fewer distinct colors than n return only those colors
+func Extract(img goimage.Image, n int, iterations int) []Color {
+	bounds := img.Bounds()
+	pixels := make(box, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pixels = append(pixels, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+
+	if len(pixels) == 0 || n < 1 {
+		return nil
+	}
+
+	boxes := []box{pixels}
+	for len(boxes) < n {
+		// Split the box with the widest range of colors at the median of that channel
+		widest, channel, widestRange := -1, 0, 0
+		for i, b := range boxes {
+			if c, r := b.widestChannel(); r > widestRange {
+				widest, channel, widestRange = i, c, r
+			}
+		}
+
+		// All the boxes are a single color
+		if widest < 0 {
+			break
+		}
+
+		b := boxes[widest]
+		sort.Slice(b, func(i, j int) bool { return b[i][channel] < b[j][channel] })
+
+		// Split between different values, so that the same color doesn't end up in both boxes
+		median := len(b) / 2
+		for median > 0 && b[median-1][channel] == b[median][channel] {
+			median--
+		}
+
+		if median == 0 {
+			median = len(b) / 2
+			for b[median-1][channel] == b[median][channel] {
+				median++
+			}
+		}
+
+		boxes = append(boxes[:widest], append([]box{b[:median], b[median:]}, boxes[widest+1:]...)...)
+	}
+
+	for i := 0; i < iterations; i++ {
+		boxes = refine(pixels, boxes)
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool { return len(boxes[i]) > len(boxes[j]) })
+
+	colors := make([]Color, len(boxes))
+	for i, b := range boxes {
+		colors[i] = Color{
+			Hex:      b.average(),
+			Coverage: math.Round(float64(len(b))/float64(len(pixels))*1000) / 10,
+		}
+	}
+
+	return colors
+}
+
+// refine regroups the pixels by which of the average colors of the boxes they're closest to, which is an iteration of k-means
+// Boxes that end up without any pixels are dropped
+func refine(pixels box, boxes []box) []box {
+	centers := make([][3]float64, len(boxes))
+	for i, b := range boxes {
+		centers[i] = b.mean()
+	}
+
+	refined := make([]box, len(boxes))
+	for _, pixel := range pixels {
+		closest, closestDistance := 0, math.Inf(1)
+		for i, center := range centers {
+			distance := 0.0
+			for c := range center {
+				d := float64(pixel[c]) - center[c]
+				distance += d * d
+			}
+
+			if distance < closestDistance {
+				closest, closestDistance = i, distance
+			}
+		}
+
+		refined[closest] = append(refined[closest], pixel)
+	}
+
+	nonEmpty := refined[:0]
+	for _, b := range refined {
+		if len(b) > 0 {
+			nonEmpty = append(nonEmpty, b)
+		}
+	}
+
+	return nonEmpty
+}
+
+// mean returns the average color of the box
+func (b box) mean() (mean [3]float64) {
+	for _, pixel := range b {
+		for c := range mean {
+			mean[c] += float64(pixel[c])
+		}
+	}
+
+	for c := range mean {
+		mean[c] /= float64(len(b))
+	}
+
+	return mean
+}
+
+// widestChannel returns the channel with the widest range of values in the box, and the range
+func (b box) widestChannel() (channel int, width int) {
+	for c := 0; c < 3; c++ {
+		min, max := uint8(255), uint8(0)
+		for _, pixel := range b {
+			if pixel[c] < min {
+				min = pixel[c]
+			}
+
+			if pixel[c] > max {
+				max = pixel[c]
+			}
+		}
+
+		if int(max)-int(min) > width {
+			channel, width = c, int(max)-int(min)
+		}
+	}
+
+	return channel, width
+}
+
+// average returns the average color of the box as a hex color
+func (b box) average() string {
+	var sum [3]int
+	for _, pixel := range b {
+		for c := range sum {
+			sum[c] += int(pixel[c])
+		}
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", (sum[0]+len(b)/2)/len(b), (sum[1]+len(b)/2)/len(b), (sum[2]+len(b)/2)/len(b))
+}
@@ -0,0 +1,111 @@
+package palette_test
+
+import (
+	"fmt"
+	goimage "image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/palette"
+)
+
+// quadrants returns an image that's red in the top left, green in the top right, blue in the bottom left and white in the bottom right
+// The bottom half is twice as tall as the top half
+func quadrants() goimage.Image {
+	img := goimage.NewRGBA(goimage.Rect(0, 0, 20, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 20; x++ {
+			switch {
+			case y < 10 && x < 10:
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			case y < 10:
+				img.Set(x, y, color.RGBA{0, 255, 0, 255})
+			case x < 10:
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			default:
+				img.Set(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	return img
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		Name     string
+		N        int
+		Expected []palette.Color
+	}{
+		{"all colors", 4, []palette.Color{{"#0000ff", 33.3}, {"#ffffff", 33.3}, {"#00ff00", 16.7}, {"#ff0000", 16.7}}},
+		{"more colors than the image has", 8, []palette.Color{{"#0000ff", 33.3}, {"#ffffff", 33.3}, {"#00ff00", 16.7}, {"#ff0000", 16.7}}},
+		{"single color", 1, []palette.Color{{"#8080aa", 100}}},
+	}
+
+	for _, test := range tests {
+		colors := palette.Extract(quadrants(), test.N, 0)
+		if !reflect.DeepEqual(colors, test.Expected) {
+			t.Errorf("%s: wrong palette %v", test.Name, colors)
+		}
+	}
+}
+
+// gradient returns an image that fades from black on the left to yellow on the right
+func gradient() goimage.Image {
+	img := goimage.NewRGBA(goimage.Rect(0, 0, 256, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 256; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(x * x / 255), 0, 255})
+		}
+	}
+
+	return img
+}
+
+// quantizationError returns the total squared distance from the pixels of the image to the closest color of the palette
+func quantizationError(t *testing.T, img goimage.Image, colors []palette.Color) float64 {
+	total := 0.0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			closest := -1.0
+			for _, c := range colors {
+				var cr, cg, cb int
+				if _, err := fmt.Sscanf(c.Hex, "#%02x%02x%02x", &cr, &cg, &cb); err != nil {
+					t.Fatal(err)
+				}
+
+				dr, dg, db := float64(int(r>>8)-cr), float64(int(g>>8)-cg), float64(int(b>>8)-cb)
+				if d := dr*dr + dg*dg + db*db; closest < 0 || d < closest {
+					closest = d
+				}
+			}
+
+			total += closest
+		}
+	}
+
+	return total
+}
+
+func TestExtractIterations(t *testing.T) {
+	// The colors of an image with fewer distinct colors than n are already exact, so the iterations don't change them
+	expected := palette.Extract(quadrants(), 4, 0)
+	if colors := palette.Extract(quadrants(), 4, 5); !reflect.DeepEqual(colors, expected) {
+		t.Errorf("wrong refined palette %v", colors)
+	}
+
+	// The iterations move the colors closer to the pixels of images with more colors than n
+	img := gradient()
+	unrefined := quantizationError(t, img, palette.Extract(img, 3, 0))
+	refined := quantizationError(t, img, palette.Extract(img, 3, 5))
+	if refined >= unrefined {
+		t.Errorf("refined error %f isn't lower than the unrefined error %f", refined, unrefined)
+	}
+
+	if colors := palette.Extract(img, 3, 5); len(colors) != 3 {
+		t.Errorf("wrong amount of colors %v", colors)
+	}
+}
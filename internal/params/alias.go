@@ -0,0 +1,47 @@
+package params
+
+import (
+	"net/http"
+	"sort"
+)
+
+// ShortAliases are the short names that other image services use for params, by the param they're an alias of
+var ShortAliases = map[string]string{
+	"brightness": "bri",
+	"contrast":   "con",
+	"saturation": "sat",
+}
+
+// FitAliases are the names that other image services use for fits, by the fit they're an alias of
+// They're resolved when parsing the fit, so that the rest of the service only ever sees the fit itself
+var FitAliases = map[string]string{
+	"crop": FitCover,
+}
+
+// fitAliasNames returns the names of the fit aliases, sorted so that the description of the params is stable
+func fitAliasNames() []string {
+	names := make([]string, 0, len(FitAliases))
+	for name := range FitAliases {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// aliasedParam gets the value of a query param, falling back to its short alias when it's not present
+// The long name takes precedence when both are present, so that the alias is ignored rather than rejected
+func aliasedParam(r *http.Request, name string) (val string, ok bool) {
+	query := r.URL.Query()
+	if _, ok := query[name]; ok {
+		return query.Get(name), true
+	}
+
+	if alias, ok := ShortAliases[name]; ok {
+		if _, ok := query[alias]; ok {
+			return query.Get(alias), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,30 @@
+package params
+
+import "fmt"
+
+// ParseAlphaFormat parses the format to output images in when they have an alpha channel, and were requested in a format without one
+// An empty format disables upgrading the format, so that those requests are rejected instead
+// WebP is the only output format with an alpha channel
+func ParseAlphaFormat(value string) (string, error) {
+	switch value {
+	case "":
+		return "", nil
+	case "webp":
+		return ".webp", nil
+	default:
+		return "", fmt.Errorf("invalid alpha format %q", value)
+	}
+}
+
+// UpgradeAlphaFormat switches the output to the alpha extension, when a mask or a background with alpha is requested without an extension that supports alpha
+// The image would otherwise lose the alpha channel from them, so the extension is changed rather than the request rejected
+// format=auto picks between formats with and without alpha, so it's still rejected along with them
+func (p *Params) UpgradeAlphaFormat(extension string) {
+	if extension == "" || (p.Mask == "" && !p.hasTransparentBackground()) || p.AutoFormat {
+		return
+	}
+
+	if p.Extension == ".jpg" {
+		p.Extension = extension
+	}
+}
@@ -0,0 +1,36 @@
+package params
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ProcessingVersion identifies how the images are processed and encoded, and is part of the cache key and the keys of the tasks
+// It's bumped when a change to the processing, or an upgrade of the image library, changes the encoded images
+// so that the cache keys of the images change along with them
+const ProcessingVersion = "4"
+
+// CacheKey returns a canonical key for the image with the given id, processed with the given params
+// It's built from the parsed params in a fixed order with the defaults omitted, the same as BuildQuery,
+// so that equivalent requests get the same key regardless of how the params were written
+// Params that don't affect the output, such as debug, sizeonly and async, are left out
+func CacheKey(id string, p *Params) string {
+	var buf bytes.Buffer
+	buf.WriteString(BuildQuery(p))
+
+	// These are set from the configuration of the service rather than the query, so BuildQuery doesn't include them
+	if p.NoUpscale {
+		addParam(&buf, "noupscale")
+	}
+
+	if p.Rounding != Round {
+		addParam(&buf, fmt.Sprintf("rounding=%s", p.Rounding))
+	}
+
+	// The version is left out when it's not set, as the images are then expected to stay the same across versions
+	if p.ProcessingVersion != "" {
+		addParam(&buf, fmt.Sprintf("version=%s", p.ProcessingVersion))
+	}
+
+	return fmt.Sprintf("%s/%d/%d%s%s", id, p.Width, p.Height, p.Extension, buf.String())
+}
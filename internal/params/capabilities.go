@@ -0,0 +1,93 @@
+package params
+
+import "github.com/DMarby/picsum-photos/internal/image"
+
+// Capabilities describes the params that are supported, and their limits
+type Capabilities struct {
+	Extensions     []string `json:"extensions"`
+	Effects        []string `json:"effects"`
+	EffectOrder    []string `json:"effect_order"`
+	ResizeFilters  []string `json:"resize_filters"`
+	BlurEdges      []string `json:"blur_edges"`
+	BlurScales     []string `json:"blur_scales"`
+	Fits           []string `json:"fits"`
+	AutoFeatures   []string `json:"auto_features"`
+	ColorSpaces    []string `json:"colorspaces"`
+	Gravities      []string `json:"gravities"`
+	BlendModes     []string `json:"blend_modes"`
+	Extracts       []string `json:"extracts"`
+	Colorblind     []string `json:"colorblind"`
+	PixelFormats   []string `json:"pixel_formats"`
+	QualityPresets []string `json:"quality_presets"`
+	MaxSize        int      `json:"max_size"`
+	Blur           Range    `json:"blur"`
+	Effort         Range    `json:"effort"`
+	Quality        Range    `json:"quality"`
+	NearLossless   Range    `json:"near_lossless"`
+	DPR            Range    `json:"dpr"`
+	TrimTolerance  Range    `json:"trim_tolerance"`
+	Saturation     Range    `json:"saturation"`
+	Vibrance       Range    `json:"vibrance"`
+	ColorizeHue    Range    `json:"colorize_hue"`
+	Sepia          Range    `json:"sepia"`
+	BlendOpacity   Range    `json:"blend_opacity"`
+	OverlayOpacity Range    `json:"overlay_opacity"`
+	OverlaySize    Range    `json:"overlay_size"`
+	Gamma          Range    `json:"gamma"`
+	Brightness     Range    `json:"brightness"`
+	Contrast       Range    `json:"contrast"`
+	Vignette       Range    `json:"vignette"`
+	Sharpen        Range    `json:"sharpen"`
+	Threshold      Range    `json:"threshold"`
+	DPI            Range    `json:"dpi"`
+	MaxTextLength  int      `json:"max_text_length"`
+}
+
+// Range is the inclusive range of values allowed for a param
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// GetCapabilities returns the params that are supported, and their limits
+func GetCapabilities() Capabilities {
+	return Capabilities{
+		Extensions:     []string{".jpg", ".webp", ".bin"},
+		Effects:        []string{"blur", "grayscale", "trim", "ratio", "text", "blend", "saturation", "vibrance", "colorize", "mask", "extract", "crop", "invertregion", "blurregion", "gamma", "brightness", "contrast", "threshold", "sharpen", "overlay", "colorblind", "sepia", "vignette"},
+		EffectOrder:    image.EffectOrder,
+		ResizeFilters:  []string{ResizeFilterLanczos, ResizeFilterCubic, ResizeFilterLinear, ResizeFilterNearest},
+		BlurEdges:      []string{BlurEdgeExtend, BlurEdgeMirror, BlurEdgeWrap},
+		BlurScales:     []string{BlurScaleAbsolute, BlurScaleRelative},
+		Fits:           []string{FitCover, FitContain, FitFill, FitInside, FitOutside, FitPad},
+		AutoFeatures:   []string{AutoFeatureCompress, AutoFeatureFormat},
+		ColorSpaces:    []string{ColorSpaceSRGB, ColorSpaceDisplayP3},
+		Gravities:      []string{GravityCenter, GravityNorth, GravityNorthEast, GravityEast, GravitySouthEast, GravitySouth, GravitySouthWest, GravityWest, GravityNorthWest},
+		BlendModes:     []string{BlendModeNormal, BlendModeMultiply, BlendModeScreen, BlendModeOverlay},
+		Extracts:       []string{ExtractRed, ExtractGreen, ExtractBlue, ExtractAlpha, ExtractLuminance},
+		Colorblind:     []string{ColorblindProtanopia, ColorblindDeuteranopia, ColorblindTritanopia},
+		PixelFormats:   []string{PixelFormatRGB8, PixelFormatRGBA8, PixelFormatGray8},
+		QualityPresets: QualityPresets,
+		MaxSize:        maxImageSize,
+		Blur:           Range{Min: minBlurAmount, Max: maxBlurAmount},
+		Effort:         Range{Min: minEffort, Max: maxEffort},
+		Quality:        Range{Min: minQuality, Max: maxQuality},
+		NearLossless:   Range{Min: minNearLossless, Max: maxNearLossless},
+		DPR:            Range{Min: minDPR, Max: maxDPR},
+		TrimTolerance:  Range{Min: minTrimTolerance, Max: maxTrimTolerance},
+		Saturation:     Range{Min: minSaturation, Max: maxSaturation},
+		Vibrance:       Range{Min: minVibrance, Max: maxVibrance},
+		ColorizeHue:    Range{Min: 0, Max: maxColorizeHue},
+		Sepia:          Range{Min: minSepia, Max: maxSepia},
+		BlendOpacity:   Range{Min: minBlendOpacity, Max: maxBlendOpacity},
+		OverlayOpacity: Range{Min: minOverlayOpacity, Max: maxOverlayOpacity},
+		OverlaySize:    Range{Min: minOverlaySize, Max: maxOverlaySize},
+		Gamma:          Range{Min: minGamma, Max: maxGamma},
+		Brightness:     Range{Min: minBrightness, Max: maxBrightness},
+		Contrast:       Range{Min: minContrast, Max: maxContrast},
+		Vignette:       Range{Min: minVignette, Max: maxVignette},
+		Sharpen:        Range{Min: minSharpen, Max: maxSharpen},
+		Threshold:      Range{Min: minThreshold, Max: maxThreshold},
+		DPI:            Range{Min: minDPI, Max: maxDPI},
+		MaxTextLength:  maxTextLength,
+	}
+}
@@ -0,0 +1,16 @@
+package params
+
+import (
+	"net/http"
+)
+
+// GetCompareImages gets the ids of the two images to compare from the a and b query params
+func GetCompareImages(r *http.Request) (a string, b string, err error) {
+	a = r.URL.Query().Get("a")
+	b = r.URL.Query().Get("b")
+	if a == "" || b == "" {
+		return "", "", ErrMissingCompareImages
+	}
+
+	return a, b, nil
+}
@@ -0,0 +1,64 @@
+package params
+
+import "fmt"
+
+// ErrConflictingParams is returned when params that contradict each other are combined
+var ErrConflictingParams = fmt.Errorf("Conflicting params")
+
+// conflict is a combination of params where one of them would otherwise be ignored
+type conflict struct {
+	Description string
+	Applies     func(p *Params) bool
+}
+
+// conflicts are all the combinations of params that are rejected, rather than silently ignoring one of them
+var conflicts = []conflict{
+	{"gravity requires text or crop=ar", func(p *Params) bool { return p.Gravity != defaultGravity && !p.ShowText && !p.hasAspectRatioCrop() }},
+	{"textcolor requires text", func(p *Params) bool { return p.TextColor != "" && !p.ShowText }},
+	{"bg requires ratio, fit=contain, fit=pad, or flatten", func(p *Params) bool {
+		return p.Background != "" && !p.HasAspectRatio() && p.Fit != FitContain && p.Fit != FitPad && !p.Flatten
+	}},
+	{"ratio conflicts with fit=pad", func(p *Params) bool { return p.HasAspectRatio() && p.Fit == FitPad }},
+	{"flatten conflicts with bg=transparent", func(p *Params) bool { return p.Flatten && p.Background == ColorTransparent }},
+	{"flatten conflicts with a bg with alpha", func(p *Params) bool {
+		return p.Flatten && p.Background != ColorTransparent && p.hasTransparentBackground()
+	}},
+	{"trimtol requires trim", func(p *Params) bool { return p.TrimTolerance != defaultTrimTolerance && !p.Trim }},
+	{"crop conflicts with trim", func(p *Params) bool { return p.HasCrop() && p.Trim }},
+	{"autorotate conflicts with orient", func(p *Params) bool { return p.AutoRotate != nil && p.HasOrient() }},
+	{"blendmode requires blend", func(p *Params) bool { return p.BlendMode != defaultBlendMode && p.Blend == "" }},
+	{"blendopacity requires blend", func(p *Params) bool { return p.BlendOpacity != defaultBlendOpacity && p.Blend == "" }},
+	{"overlaypos requires overlay", func(p *Params) bool { return p.OverlayPos != defaultOverlayPos && p.Overlay == "" }},
+	{"overlayopacity requires overlay", func(p *Params) bool { return p.OverlayOpacity != defaultOverlayOpacity && p.Overlay == "" }},
+	{"overlaysize requires overlay", func(p *Params) bool { return p.OverlaySize != defaultOverlaySize && p.Overlay == "" }},
+	{"colorize conflicts with grayscale, saturation, and vibrance", func(p *Params) bool {
+		return p.Colorize != "" && (p.Grayscale || p.HasSaturation() || p.HasVibrance())
+	}},
+	{"sharpen conflicts with blur", func(p *Params) bool { return p.Sharpen > 0 && p.Blur }},
+	{"placeholder requires blur", func(p *Params) bool { return p.Placeholder && !p.Blur }},
+	{"bluredge requires blur", func(p *Params) bool { return p.BlurEdge != defaultBlurEdge && !p.Blur }},
+	{"blurscale requires blur", func(p *Params) bool { return p.BlurScale != defaultBlurScale && !p.Blur }},
+	{"blurregion requires blur", func(p *Params) bool { return p.HasBlurRegion() && !p.Blur }},
+	{"blurbefore requires blur", func(p *Params) bool { return p.BlurBefore && !p.Blur }},
+	{"blurbefore conflicts with blurregion and blend", func(p *Params) bool { return p.BlurBefore && (p.HasBlurRegion() || p.Blend != "") }},
+	{"dither requires threshold", func(p *Params) bool { return p.Dither && !p.HasThreshold() }},
+	{"threshold conflicts with colorize", func(p *Params) bool { return p.HasThreshold() && p.Colorize != "" }},
+	{"nearlossless conflicts with lossless", func(p *Params) bool { return p.HasNearLossless() && (p.Lossless || p.AutoLossless) }},
+	{"lossless and nearlossless conflict with quality", func(p *Params) bool {
+		return (p.Lossless || p.AutoLossless || p.HasNearLossless()) && (p.HasQuality() || p.QualityPreset != "" || p.AutoQuality)
+	}},
+	{"format=auto conflicts with the .webp extension", func(p *Params) bool { return p.AutoFormat && p.Extension == ".webp" }},
+	{"format=auto conflicts with the .bin extension", func(p *Params) bool { return p.AutoFormat && p.Extension == ".bin" }},
+	{"pixelformat requires the .bin extension", func(p *Params) bool { return p.PixelFormat != "" && p.Extension != ".bin" }},
+}
+
+// checkConflicts returns an error describing the first conflict between the params, if any
+func (p *Params) checkConflicts() error {
+	for _, c := range conflicts {
+		if c.Applies(p) {
+			return fmt.Errorf("%w: %s", ErrConflictingParams, c.Description)
+		}
+	}
+
+	return nil
+}
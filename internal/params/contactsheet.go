@@ -0,0 +1,82 @@
+package params
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+)
+
+// Contact sheet layouts
+const (
+	minSheetColumns       = 1
+	maxSheetColumns       = 10
+	defaultSheetColumns   = 4
+	minSheetFrames        = 1
+	maxSheetFrames        = 100
+	defaultSheetFrames    = 12
+	minSheetCellWidth     = 1
+	defaultSheetCellWidth = 200
+)
+
+// ContactSheet is the layout of a contact sheet of the frames of an animated image
+type ContactSheet struct {
+	Columns    int
+	Frames     int
+	CellWidth  int
+	CellHeight int
+}
+
+// GetContactSheet parses and validates the layout of a contact sheet from the cols, frames and w query params
+// The cells keep the aspect ratio of the image, and the sheet can't be larger than the max image size when all the frames are included
+func GetContactSheet(r *http.Request, image *database.Image) (*ContactSheet, error) {
+	columns, err := sheetParam(r, "cols", defaultSheetColumns, minSheetColumns, maxSheetColumns, ErrInvalidSheetColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := sheetParam(r, "frames", defaultSheetFrames, minSheetFrames, maxSheetFrames, ErrInvalidSheetFrames)
+	if err != nil {
+		return nil, err
+	}
+
+	cellWidth, err := sheetParam(r, "w", defaultSheetCellWidth, minSheetCellWidth, maxImageSize, ErrInvalidSheetWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	cellHeight := int(math.Max(1, math.Round(float64(cellWidth)*float64(image.Height)/float64(image.Width))))
+
+	// A sheet with fewer frames than columns only has as many columns as frames
+	across := columns
+	if frames < across {
+		across = frames
+	}
+	rows := (frames + columns - 1) / columns
+
+	if across*cellWidth > maxImageSize || rows*cellHeight > maxImageSize {
+		return nil, ErrSheetTooLarge
+	}
+
+	return &ContactSheet{
+		Columns:    columns,
+		Frames:     frames,
+		CellWidth:  cellWidth,
+		CellHeight: cellHeight,
+	}, nil
+}
+
+// sheetParam parses an integer query param within min and max, falling back to the default when it isn't set
+func sheetParam(r *http.Request, name string, defaultValue int, min int, max int, invalidErr error) (int, error) {
+	if _, ok := r.URL.Query()[name]; !ok {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || value < min || value > max {
+		return 0, invalidErr
+	}
+
+	return value, nil
+}
@@ -0,0 +1,56 @@
+package params
+
+import "fmt"
+
+// CropBounds is how crops that extend outside of the source image are handled
+type CropBounds int
+
+const (
+	// CropStrict rejects crops that extend outside of the source image
+	CropStrict CropBounds = iota
+	// CropClamp intersects crops with the source image, only rejecting the ones entirely outside of it
+	CropClamp
+)
+
+var cropBoundsNames = map[CropBounds]string{
+	CropStrict: "strict",
+	CropClamp:  "clamp",
+}
+
+// String returns the name of the crop bounds policy
+func (c CropBounds) String() string {
+	return cropBoundsNames[c]
+}
+
+// ParseCropBounds parses the name of a crop bounds policy, an empty name gives the default of rejecting the crops
+func ParseCropBounds(value string) (CropBounds, error) {
+	if value == "" {
+		return CropStrict, nil
+	}
+
+	for bounds, name := range cropBoundsNames {
+		if name == value {
+			return bounds, nil
+		}
+	}
+
+	return CropStrict, fmt.Errorf("invalid crop bounds %q", value)
+}
+
+// clampCrop intersects a crop in pixels with the source image of the given size
+// It returns false when the intersection is empty, which leaves nothing to crop to
+func (p *Params) clampCrop(width int, height int) bool {
+	if int(p.Crop.X) >= width || int(p.Crop.Y) >= height {
+		return false
+	}
+
+	if int(p.Crop.X)+int(p.Crop.Width) > width {
+		p.Crop.Width = float64(width) - p.Crop.X
+	}
+
+	if int(p.Crop.Y)+int(p.Crop.Height) > height {
+		p.Crop.Height = float64(height) - p.Crop.Y
+	}
+
+	return true
+}
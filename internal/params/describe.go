@@ -0,0 +1,125 @@
+package params
+
+// Param describes a query param that's accepted by the image routes, and its constraints
+type Param struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Range     *Range   `json:"range,omitempty"`
+	Values    []string `json:"values,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Alias     string   `json:"alias,omitempty"`
+}
+
+// The types of the query params
+const (
+	ParamTypeBool    = "bool"
+	ParamTypeInt     = "int"
+	ParamTypeFloat   = "float"
+	ParamTypeEnum    = "enum"
+	ParamTypeList    = "list"   // A comma separated list of values
+	ParamTypeString  = "string" // Free form text, or a hue or color for colorize
+	ParamTypeColor   = "color"  // A hex color, such as ff8800, or a CSS color name, such as red, bg accepts a hex color with an alpha as well, such as ff880080
+	ParamTypeRegion  = "region" // {x},{y},{width},{height}
+	ParamTypeRatio   = "ratio"  // {width}:{height}
+	ParamTypeSize    = "size"   // {width}x{height}
+	ParamTypeImageID = "image_id"
+)
+
+// DescribeImageParams returns the query params that are accepted by the image routes, with the same limits as the validation
+func DescribeImageParams() []Param {
+	c := GetCapabilities()
+	rangeOf := func(r Range) *Range {
+		return &r
+	}
+
+	return []Param{
+		{Name: "grayscale", Type: ParamTypeBool},
+		{Name: "saturation", Type: ParamTypeFloat, Range: rangeOf(c.Saturation), Alias: ShortAliases["saturation"]},
+		{Name: "vibrance", Type: ParamTypeInt, Range: rangeOf(c.Vibrance)},
+		{Name: "colorize", Type: ParamTypeString, Range: rangeOf(c.ColorizeHue)},
+		{Name: "sepia", Type: ParamTypeInt, Range: rangeOf(c.Sepia)},
+		{Name: "gamma", Type: ParamTypeFloat, Range: rangeOf(c.Gamma)},
+		{Name: "brightness", Type: ParamTypeInt, Range: rangeOf(c.Brightness), Alias: ShortAliases["brightness"]},
+		{Name: "contrast", Type: ParamTypeInt, Range: rangeOf(c.Contrast), Alias: ShortAliases["contrast"]},
+		{Name: "vignette", Type: ParamTypeInt, Range: rangeOf(c.Vignette)},
+		{Name: "filter", Type: ParamTypeString},
+		{Name: "threshold", Type: ParamTypeInt, Range: rangeOf(c.Threshold)},
+		{Name: "dither", Type: ParamTypeBool},
+		{Name: "blur", Type: ParamTypeInt, Range: rangeOf(c.Blur)},
+		{Name: "placeholder", Type: ParamTypeBool},
+		{Name: "blurbefore", Type: ParamTypeBool},
+		{Name: "bluredge", Type: ParamTypeEnum, Values: c.BlurEdges},
+		{Name: "blurscale", Type: ParamTypeEnum, Values: c.BlurScales},
+		{Name: "blurregion", Type: ParamTypeRegion},
+		{Name: "sharpen", Type: ParamTypeFloat, Range: rangeOf(c.Sharpen)},
+		{Name: "fit", Type: ParamTypeEnum, Values: append(append([]string{}, c.Fits...), fitAliasNames()...)},
+		{Name: "resize-filter", Type: ParamTypeEnum, Values: c.ResizeFilters},
+		{Name: "effort", Type: ParamTypeInt, Range: rangeOf(c.Effort)},
+		{Name: "colorspace", Type: ParamTypeEnum, Values: c.ColorSpaces},
+		{Name: "format", Type: ParamTypeEnum, Values: []string{"auto"}},
+		{Name: "quality", Type: ParamTypeInt, Range: rangeOf(c.Quality), Values: append(append([]string{}, c.QualityPresets...), "auto")},
+		{Name: "auto", Type: ParamTypeList, Values: c.AutoFeatures},
+		{Name: "lossless", Type: ParamTypeBool, Values: []string{"auto"}},
+		{Name: "nearlossless", Type: ParamTypeInt, Range: rangeOf(c.NearLossless)},
+		{Name: "dpr", Type: ParamTypeFloat, Range: rangeOf(c.DPR)},
+		{Name: "ratio", Type: ParamTypeRatio},
+		{Name: "bg", Type: ParamTypeColor},
+		{Name: "flatten", Type: ParamTypeBool},
+		{Name: "frame", Type: ParamTypeInt},
+		{Name: "crop", Type: ParamTypeRegion},
+		{Name: "trim", Type: ParamTypeBool},
+		{Name: "trimcolor", Type: ParamTypeColor},
+		{Name: "trimtol", Type: ParamTypeInt, Range: rangeOf(c.TrimTolerance)},
+		{Name: "text", Type: ParamTypeString, MaxLength: c.MaxTextLength},
+		{Name: "textcolor", Type: ParamTypeColor},
+		{Name: "gravity", Type: ParamTypeEnum, Values: c.Gravities},
+		{Name: "orient", Type: ParamTypeInt, Range: &Range{Min: minOrientation, Max: maxOrientation}},
+		{Name: "autorotate", Type: ParamTypeBool},
+		{Name: "blend", Type: ParamTypeImageID},
+		{Name: "blendmode", Type: ParamTypeEnum, Values: c.BlendModes},
+		{Name: "blendopacity", Type: ParamTypeFloat, Range: rangeOf(c.BlendOpacity)},
+		{Name: "overlay", Type: ParamTypeImageID},
+		{Name: "overlaypos", Type: ParamTypeEnum, Values: c.Gravities},
+		{Name: "overlayopacity", Type: ParamTypeInt, Range: rangeOf(c.OverlayOpacity)},
+		{Name: "overlaysize", Type: ParamTypeInt, Range: rangeOf(c.OverlaySize)},
+		{Name: "mask", Type: ParamTypeImageID},
+		{Name: "invertregion", Type: ParamTypeRegion},
+		{Name: "colorblind", Type: ParamTypeEnum, Values: c.Colorblind},
+		{Name: "extract", Type: ParamTypeEnum, Values: c.Extracts},
+		{Name: "noupscale", Type: ParamTypeBool},
+		{Name: "dpi", Type: ParamTypeInt, Range: rangeOf(c.DPI)},
+		{Name: "pixelformat", Type: ParamTypeEnum, Values: c.PixelFormats},
+		{Name: "debug", Type: ParamTypeBool},
+		{Name: "sizeonly", Type: ParamTypeBool},
+		{Name: "beacon", Type: ParamTypeBool},
+		{Name: "async", Type: ParamTypeBool},
+	}
+}
+
+// DescribeSizeParams returns the query params for the size of the image, that are accepted by the image routes without the size in the path
+func DescribeSizeParams() []Param {
+	size := &Range{Min: 1, Max: maxImageSize}
+	return []Param{
+		{Name: "w", Type: ParamTypeInt, Range: size},
+		{Name: "h", Type: ParamTypeInt, Range: size},
+		{Name: "size", Type: ParamTypeSize},
+	}
+}
+
+// Route describes the query params of a route, for OPTIONS requests
+type Route struct {
+	Params []Param `json:"params"`
+}
+
+// DescribeRoute returns the description of a route that accepts the params
+func DescribeRoute(params ...[]Param) Route {
+	route := Route{
+		Params: []Param{},
+	}
+
+	for _, p := range params {
+		route.Params = append(route.Params, p...)
+	}
+
+	return route
+}
@@ -0,0 +1,167 @@
+package params
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+)
+
+// maxDownloadVariants is the max amount of sizes times formats that can be bundled in a single download
+const maxDownloadVariants = 12
+
+// maxDownloadJobLength is the max length of the id of a download job
+const maxDownloadJobLength = 64
+
+// DownloadParams contains the sizes and formats of the variants of an image to bundle in a download
+// Job is the id that the client picked to follow the progress of the download with, if any
+type DownloadParams struct {
+	Sizes      []DownloadSize
+	Extensions []string
+	Job        string
+}
+
+// DownloadSize is the size of a variant, the height follows the aspect ratio of the image when it's 0
+type DownloadSize struct {
+	Width  int
+	Height int
+}
+
+// Dimensions returns the width and height of the variant of the image
+// Heights that follow the aspect ratio of portrait images can get larger than the max image size, those are clamped to it along with the width
+func (s DownloadSize) Dimensions(databaseImage *database.Image) (width, height int) {
+	if s.Height != 0 {
+		return s.Width, s.Height
+	}
+
+	height = int(math.Max(1, math.Round(float64(s.Width)*float64(databaseImage.Height)/float64(databaseImage.Width))))
+	if height > maxImageSize && height != databaseImage.Height {
+		width = int(math.Max(1, math.Round(float64(maxImageSize)*float64(databaseImage.Width)/float64(databaseImage.Height))))
+		return width, maxImageSize
+	}
+
+	return s.Width, height
+}
+
+// Variants returns the dimensions of each of the sizes of the image, leaving out the sizes that are clamped to the same dimensions as another one
+func (p *DownloadParams) Variants(databaseImage *database.Image) []DownloadSize {
+	var variants []DownloadSize
+	seen := make(map[DownloadSize]bool)
+	for _, size := range p.Sizes {
+		width, height := size.Dimensions(databaseImage)
+		variant := DownloadSize{Width: width, Height: height}
+		if seen[variant] {
+			continue
+		}
+
+		seen[variant] = true
+		variants = append(variants, variant)
+	}
+
+	return variants
+}
+
+// GetDownloadParams parses and validates the comma separated lists of sizes and formats to bundle from the query params
+// Sizes are either a width, or {width}x{height}, and the formats default to jpg
+func GetDownloadParams(r *http.Request) (*DownloadParams, error) {
+	sizes, err := getDownloadSizes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := getDownloadExtensions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sizes)*len(extensions) > maxDownloadVariants {
+		return nil, ErrTooManyVariants
+	}
+
+	job, err := getDownloadJob(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DownloadParams{
+		Sizes:      sizes,
+		Extensions: extensions,
+		Job:        job,
+	}, nil
+}
+
+// getDownloadJob gets the id of the download job (if present) from the query params
+// It's part of the url of the progress of the job, so it's limited to letters, digits, dashes and underscores
+func getDownloadJob(r *http.Request) (string, error) {
+	job := r.URL.Query().Get("job")
+	if _, ok := r.URL.Query()["job"]; ok && !IsDownloadJob(job) {
+		return "", ErrInvalidDownloadJob
+	}
+
+	return job, nil
+}
+
+// IsDownloadJob returns whether the id is a valid id for a download job
+func IsDownloadJob(job string) bool {
+	if job == "" || len(job) > maxDownloadJobLength {
+		return false
+	}
+
+	for _, c := range job {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getDownloadSizes parses the sizes from the query params, the same size can't be included twice as the variants would have the same name
+func getDownloadSizes(r *http.Request) (sizes []DownloadSize, err error) {
+	val := r.URL.Query().Get("sizes")
+	if val == "" {
+		return nil, ErrInvalidSizes
+	}
+
+	seen := make(map[DownloadSize]bool)
+	for _, s := range strings.Split(val, ",") {
+		var size DownloadSize
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "x") {
+			size.Width, size.Height, err = parseDimensions(s)
+		} else {
+			size.Width, err = strconv.Atoi(s)
+		}
+
+		if err != nil || size.Width < 1 || size.Width > maxImageSize || size.Height > maxImageSize || seen[size] {
+			return nil, ErrInvalidSizes
+		}
+
+		seen[size] = true
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
+// getDownloadExtensions parses the formats from the query params into file extensions
+func getDownloadExtensions(r *http.Request) (extensions []string, err error) {
+	if _, ok := r.URL.Query()["formats"]; !ok {
+		return []string{".jpg"}, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, format := range strings.Split(r.URL.Query().Get("formats"), ",") {
+		extension := "." + strings.ToLower(strings.TrimSpace(format))
+		if (extension != ".jpg" && extension != ".webp") || seen[extension] {
+			return nil, ErrInvalidFormats
+		}
+
+		seen[extension] = true
+		extensions = append(extensions, extension)
+	}
+
+	return extensions, nil
+}
@@ -0,0 +1,151 @@
+package params
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrEffectDisabled is returned when an effect that's disabled in the config is requested
+var ErrEffectDisabled = fmt.Errorf("Effect disabled")
+
+// ErrTooManyEffects is returned when a request combines more effects than the config allows
+var ErrTooManyEffects = fmt.Errorf("Too many effects")
+
+// effect is one of the effects of the capabilities, with the params that request it
+// Remove resets the params of the effect to their defaults, including the ones that require it, so they don't conflict once it's removed
+type effect struct {
+	Name    string
+	Applies func(p *Params) bool
+	Remove  func(p *Params)
+}
+
+// effects are the effects that can be disabled, in the order of the capabilities
+var effects = []effect{
+	{"blur", func(p *Params) bool { return p.Blur }, func(p *Params) {
+		p.Blur, p.BlurAmount, p.Placeholder, p.BlurBefore = false, 0, false, false
+		p.BlurEdge, p.BlurScale, p.BlurRegion = defaultBlurEdge, defaultBlurScale, Region{}
+	}},
+	{"grayscale", func(p *Params) bool { return p.Grayscale }, func(p *Params) { p.Grayscale = false }},
+	{"trim", func(p *Params) bool { return p.Trim }, func(p *Params) {
+		p.Trim, p.TrimColor, p.TrimTolerance = false, "", defaultTrimTolerance
+	}},
+	{"ratio", (*Params).HasAspectRatio, func(p *Params) {
+		p.AspectRatio = AspectRatio{}
+		if p.Fit != FitContain && p.Fit != FitPad && !p.Flatten {
+			p.Background = ""
+		}
+	}},
+	{"text", func(p *Params) bool { return p.ShowText }, func(p *Params) {
+		p.ShowText, p.Text, p.TextColor = false, "", ""
+		// The gravity also positions crops to an aspect ratio
+		if !p.hasAspectRatioCrop() {
+			p.Gravity = defaultGravity
+		}
+	}},
+	{"blend", func(p *Params) bool { return p.Blend != "" }, func(p *Params) {
+		p.Blend, p.BlendMode, p.BlendOpacity = "", defaultBlendMode, defaultBlendOpacity
+	}},
+	{"saturation", (*Params).HasSaturation, func(p *Params) { p.Saturation = defaultSaturation }},
+	{"vibrance", (*Params).HasVibrance, func(p *Params) { p.Vibrance = defaultVibrance }},
+	{"colorize", func(p *Params) bool { return p.Colorize != "" }, func(p *Params) { p.Colorize = "" }},
+	{"mask", func(p *Params) bool { return p.Mask != "" }, func(p *Params) { p.Mask = "" }},
+	{"extract", func(p *Params) bool { return p.Extract != "" }, func(p *Params) { p.Extract = "" }},
+	{"crop", (*Params).HasCrop, func(p *Params) {
+		p.Crop = Crop{}
+		if !p.ShowText {
+			p.Gravity = defaultGravity
+		}
+	}},
+	{"invertregion", (*Params).HasInvertRegion, func(p *Params) { p.InvertRegion = Region{} }},
+	{"blurregion", (*Params).HasBlurRegion, func(p *Params) { p.BlurRegion = Region{} }},
+	{"gamma", (*Params).HasGamma, func(p *Params) { p.Gamma = defaultGamma }},
+	{"brightness", (*Params).HasBrightness, func(p *Params) { p.Brightness = defaultBrightness }},
+	{"contrast", (*Params).HasContrast, func(p *Params) { p.Contrast = defaultContrast }},
+	{"threshold", (*Params).HasThreshold, func(p *Params) { p.Threshold, p.Dither = noThreshold, false }},
+	{"sharpen", (*Params).HasSharpen, func(p *Params) { p.Sharpen = noSharpen }},
+	{"overlay", func(p *Params) bool { return p.Overlay != "" }, func(p *Params) {
+		p.Overlay, p.OverlayPos, p.OverlayOpacity, p.OverlaySize = "", defaultOverlayPos, defaultOverlayOpacity, defaultOverlaySize
+	}},
+	{"colorblind", func(p *Params) bool { return p.ColorblindMode != "" }, func(p *Params) { p.ColorblindMode = "" }},
+	{"sepia", (*Params).HasSepia, func(p *Params) { p.SepiaAmount = 0 }},
+	{"vignette", (*Params).HasVignette, func(p *Params) { p.Vignette = 0 }},
+}
+
+// DisabledEffects are the effects that are disabled in the config, by their names in the capabilities
+type DisabledEffects struct {
+	Effects map[string]bool
+	Ignore  bool // Removes the disabled effects from the requests, rather than rejecting them
+}
+
+// ParseDisabledEffects parses a comma separated list of the effects to disable
+// An empty list disables no effects
+func ParseDisabledEffects(value string, ignore bool) (DisabledEffects, error) {
+	disabled := DisabledEffects{
+		Ignore: ignore,
+	}
+
+	if value == "" {
+		return disabled, nil
+	}
+
+	disabled.Effects = make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !knownEffect(name) {
+			return DisabledEffects{}, fmt.Errorf("invalid effect %q", name)
+		}
+
+		disabled.Effects[name] = true
+	}
+
+	return disabled, nil
+}
+
+// knownEffect returns whether the name is one of the effects that can be disabled
+func knownEffect(name string) bool {
+	for _, e := range effects {
+		if e.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDisabledEffects returns an error naming the first disabled effect that's requested, if any
+// When disabled effects are ignored, they're removed from the params instead
+func (p *Params) checkDisabledEffects() error {
+	for _, e := range effects {
+		if !p.DisabledEffects.Effects[e.Name] || !e.Applies(p) {
+			continue
+		}
+
+		if !p.DisabledEffects.Ignore {
+			return fmt.Errorf("%w: %s", ErrEffectDisabled, e.Name)
+		}
+
+		e.Remove(p)
+	}
+
+	return nil
+}
+
+// checkMaxEffects returns an error when more distinct effects are requested than the max from the config, if there is one
+func (p *Params) checkMaxEffects() error {
+	if p.MaxEffects <= 0 {
+		return nil
+	}
+
+	count := 0
+	for _, e := range effects {
+		if e.Applies(p) {
+			count++
+		}
+	}
+
+	if count > p.MaxEffects {
+		return fmt.Errorf("%w: %d of at most %d", ErrTooManyEffects, count, p.MaxEffects)
+	}
+
+	return nil
+}
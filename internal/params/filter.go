@@ -0,0 +1,203 @@
+package params
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidFilter is returned when the filter isn't one of the filters from the config
+var ErrInvalidFilter = fmt.Errorf("Invalid filter")
+
+// DefaultFilters are the filters that are configured by default
+const DefaultFilters = "vintage=sepia=40&contrast=-10&saturation=0.8&vignette=30;warm=sepia=15&vibrance=20&brightness=5;cool=colorize=210&brightness=5;dramatic=contrast=40&saturation=1.2&brightness=-10&vignette=40"
+
+// Filter is a named combination of the color and tonal adjustments and the vignette, that's requested with a single param
+type Filter struct {
+	Grayscale  bool
+	Saturation float64
+	Vibrance   int
+	Colorize   string
+	Sepia      int
+	Gamma      float64
+	Brightness int
+	Contrast   int
+	Vignette   int
+}
+
+// Filters are the filters from the config, by their names
+type Filters map[string]Filter
+
+// filterParams are the params that a filter can combine, along with their short aliases
+var filterParams = map[string]bool{
+	"grayscale":  true,
+	"saturation": true,
+	"vibrance":   true,
+	"colorize":   true,
+	"sepia":      true,
+	"gamma":      true,
+	"brightness": true,
+	"contrast":   true,
+	"vignette":   true,
+	"bri":        true,
+	"con":        true,
+	"sat":        true,
+}
+
+// ParseFilters parses a semicolon separated list of filters, in the form name=query, with the query made up of the color and tonal adjustments and the vignette
+// For example "vintage=sepia=40&contrast=-10;noir=grayscale&contrast=30"
+// An empty list configures no filters
+func ParseFilters(value string) (Filters, error) {
+	filters := make(Filters)
+	if value == "" {
+		return filters, nil
+	}
+
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid filter %q", entry)
+		}
+
+		name := strings.ToLower(parts[0])
+		if _, ok := filters[name]; ok {
+			return nil, fmt.Errorf("duplicate filter %s", name)
+		}
+
+		query, err := url.ParseQuery(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid query for filter %s: %s", name, err)
+		}
+
+		for param := range query {
+			if !filterParams[param] {
+				return nil, fmt.Errorf("invalid param %q for filter %s", param, name)
+			}
+		}
+
+		filter, err := parseFilter(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %s", name, err)
+		}
+
+		filters[name] = filter
+	}
+
+	return filters, nil
+}
+
+// parseFilter parses the adjustments of a filter with the same parsing and limits as the params of a request
+func parseFilter(query url.Values) (filter Filter, err error) {
+	// Build a request with the query, so that it can be parsed like any other request
+	r := &http.Request{URL: &url.URL{RawQuery: query.Encode()}}
+
+	filter.Grayscale = boolParam(r, "grayscale")
+	if filter.Saturation, err = getSaturation(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Vibrance, err = getVibrance(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Colorize, err = getColorize(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Sepia, err = getSepia(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Gamma, err = getGamma(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Brightness, err = getBrightness(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Contrast, err = getContrast(r); err != nil {
+		return Filter{}, err
+	}
+
+	if filter.Vignette, err = getVignette(r); err != nil {
+		return Filter{}, err
+	}
+
+	// Validate the limits up front, rather than rejecting every request for the filter
+	switch {
+	case filter.Saturation < minSaturation || filter.Saturation > maxSaturation:
+		return Filter{}, ErrInvalidSaturation
+	case filter.Vibrance < minVibrance || filter.Vibrance > maxVibrance:
+		return Filter{}, ErrInvalidVibrance
+	case filter.Sepia < minSepia || filter.Sepia > maxSepia:
+		return Filter{}, ErrInvalidSepia
+	case filter.Gamma < minGamma || filter.Gamma > maxGamma:
+		return Filter{}, ErrInvalidGamma
+	case filter.Brightness < minBrightness || filter.Brightness > maxBrightness:
+		return Filter{}, ErrInvalidBrightness
+	case filter.Contrast < minContrast || filter.Contrast > maxContrast:
+		return Filter{}, ErrInvalidContrast
+	case filter.Vignette < minVignette || filter.Vignette > maxVignette:
+		return Filter{}, ErrInvalidVignette
+	}
+
+	return filter, nil
+}
+
+// getFilter gets the name of the filter (if present) from the query params
+// It's resolved into the adjustments once the filters from the config are known, in Validate
+func getFilter(r *http.Request) string {
+	return strings.ToLower(r.URL.Query().Get("filter"))
+}
+
+// applyFilter resolves the filter into the adjustments it combines, and returns an error when it isn't one of the filters from the config
+// The adjustments in the request take precedence over the ones of the filter, like the params of the request do over the ones of a preset
+// The filter is cleared once it's resolved, so that the redirect and the cache key are the same as when requesting the adjustments directly
+func (p *Params) applyFilter() error {
+	if p.Filter == "" {
+		return nil
+	}
+
+	filter, ok := p.Filters[p.Filter]
+	if !ok {
+		return ErrInvalidFilter
+	}
+
+	p.Grayscale = p.Grayscale || filter.Grayscale
+	if !p.HasSaturation() {
+		p.Saturation = filter.Saturation
+	}
+
+	if !p.HasVibrance() {
+		p.Vibrance = filter.Vibrance
+	}
+
+	if p.Colorize == "" {
+		p.Colorize = filter.Colorize
+	}
+
+	if !p.HasSepia() {
+		p.SepiaAmount = filter.Sepia
+	}
+
+	if !p.HasGamma() {
+		p.Gamma = filter.Gamma
+	}
+
+	if !p.HasBrightness() {
+		p.Brightness = filter.Brightness
+	}
+
+	if !p.HasContrast() {
+		p.Contrast = filter.Contrast
+	}
+
+	if !p.HasVignette() {
+		p.Vignette = filter.Vignette
+	}
+
+	p.Filter = ""
+	return nil
+}
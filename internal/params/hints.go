@@ -0,0 +1,44 @@
+package params
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+)
+
+// ClientHints are the client hint headers that can be used to pick the size of an image
+var ClientHints = []string{"Sec-CH-Width", "Sec-CH-DPR"}
+
+// ApplyClientHints fills in the width and device pixel ratio from the client hints, when they're not set in the URL
+// Hints that are invalid or out of range are ignored, as the client didn't ask for them explicitly
+func ApplyClientHints(r *http.Request, p *Params, databaseImage *database.Image) {
+	if _, ok := r.URL.Query()["dpr"]; !ok {
+		if dpr, err := strconv.ParseFloat(r.Header.Get("Sec-CH-DPR"), 64); err == nil && dpr >= minDPR && dpr <= maxDPR {
+			p.DPR = dpr
+		}
+	}
+
+	if p.Width != 0 {
+		return
+	}
+
+	hintWidth, err := strconv.Atoi(r.Header.Get("Sec-CH-Width"))
+	if err != nil || hintWidth < 1 {
+		return
+	}
+
+	// The width hint is in physical pixels, while the width in the URL is before applying the device pixel ratio
+	width := int(math.Ceil(float64(hintWidth) / p.DPR))
+	if width > maxImageSize {
+		return
+	}
+
+	p.Width = width
+
+	// Keep the aspect ratio of the image when the height isn't set either
+	if p.Height == 0 {
+		p.Height = int(math.Max(1, math.Round(float64(width)*float64(databaseImage.Height)/float64(databaseImage.Width))))
+	}
+}
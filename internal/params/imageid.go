@@ -0,0 +1,82 @@
+package params
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultImageIDPattern only allows alphanumeric image ids
+const DefaultImageIDPattern = "[a-zA-Z0-9]+"
+
+// Errors
+var (
+	ErrInvalidImageID = fmt.Errorf("Invalid image id")
+	ErrInvalidTenant  = fmt.Errorf("Invalid tenant")
+)
+
+// ParseImageIDPattern compiles the regular expression that image ids have to match, where the whole id has to match it
+// An empty pattern returns nil, which allows any id
+func ParseImageIDPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// ValidImageID returns whether an image id matches the pattern, a nil pattern allows any id
+func ValidImageID(pattern *regexp.Regexp, id string) bool {
+	return pattern == nil || pattern.MatchString(id)
+}
+
+// TenantImageID returns the id that the image of a tenant is stored as in the database and storage, prefixed with the tenant
+func TenantImageID(tenant string, id string) string {
+	return tenant + "/" + id
+}
+
+// SplitTenantImageID splits an id from TenantImageID back into the tenant and the id of the image, ids without a tenant have an empty tenant
+func SplitTenantImageID(id string) (tenant string, imageID string) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 {
+		return "", id
+	}
+
+	return parts[0], parts[1]
+}
+
+// ResolveImageID validates an image id from a request, and scopes it to the tenant in the path of tenant routes
+// With tenants enabled, ids can't contain a slash, so that the images of a tenant can't be reached from outside of it
+func ResolveImageID(r *http.Request, idPattern *regexp.Regexp, tenantPattern *regexp.Regexp, id string) (string, error) {
+	if !ValidImageID(idPattern, id) || (tenantPattern != nil && strings.Contains(id, "/")) {
+		return "", ErrInvalidImageID
+	}
+
+	tenant, err := RouteTenant(r, tenantPattern)
+	if err != nil {
+		return "", err
+	}
+
+	if tenant == "" {
+		return id, nil
+	}
+
+	return TenantImageID(tenant, id), nil
+}
+
+// RouteTenant validates the tenant in the path of tenant routes, and returns an empty tenant outside of them
+func RouteTenant(r *http.Request, tenantPattern *regexp.Regexp) (string, error) {
+	tenant, ok := mux.Vars(r)["tenant"]
+	if !ok {
+		return "", nil
+	}
+
+	if tenantPattern == nil || !tenantPattern.MatchString(tenant) {
+		return "", ErrInvalidTenant
+	}
+
+	return tenant, nil
+}
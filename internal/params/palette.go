@@ -0,0 +1,49 @@
+package params
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Palette sizes
+const (
+	minPaletteSize     = 1
+	maxPaletteSize     = 16
+	defaultPaletteSize = 5
+)
+
+// GetPaletteSize parses and validates the amount of colors to include in a palette from the n query param
+func GetPaletteSize(r *http.Request) (int, error) {
+	if _, ok := r.URL.Query()["n"]; !ok {
+		return defaultPaletteSize, nil
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < minPaletteSize || n > maxPaletteSize {
+		return 0, ErrInvalidPaletteSize
+	}
+
+	return n, nil
+}
+
+// Palette precisions, which trade how long the palette takes to compute for how accurate its colors are
+const (
+	PrecisionLow    = "low"
+	PrecisionMedium = "medium"
+	PrecisionHigh   = "high"
+)
+
+// GetPalettePrecision parses and validates the precision of a palette from the precision query param, defaulting to medium
+func GetPalettePrecision(r *http.Request) (string, error) {
+	if _, ok := r.URL.Query()["precision"]; !ok {
+		return PrecisionMedium, nil
+	}
+
+	switch precision := strings.ToLower(r.URL.Query().Get("precision")); precision {
+	case PrecisionLow, PrecisionMedium, PrecisionHigh:
+		return precision, nil
+	default:
+		return "", ErrInvalidPrecision
+	}
+}
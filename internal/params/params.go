@@ -2,9 +2,11 @@ package params
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/DMarby/picsum-photos/internal/database"
 	"github.com/gorilla/mux"
@@ -12,28 +14,361 @@ import (
 
 // Errors
 var (
-	ErrInvalidSize          = fmt.Errorf("Invalid size")
-	ErrInvalidBlurAmount    = fmt.Errorf("Invalid blur amount")
-	ErrInvalidFileExtension = fmt.Errorf("Invalid file extension")
+	ErrInvalidSize            = fmt.Errorf("Invalid size")
+	ErrInvalidBlurAmount      = fmt.Errorf("Invalid blur amount")
+	ErrInvalidFileExtension   = fmt.Errorf("Invalid file extension")
+	ErrInvalidResizeFilter    = fmt.Errorf("Invalid resize filter")
+	ErrInvalidBlurEdge        = fmt.Errorf("Invalid blur edge")
+	ErrInvalidBlurScale       = fmt.Errorf("Invalid blur scale")
+	ErrInvalidBlurRegion      = fmt.Errorf("Invalid blur region")
+	ErrInvalidEffort          = fmt.Errorf("Invalid effort")
+	ErrInvalidColorSpace      = fmt.Errorf("Invalid colorspace")
+	ErrInvalidFormat          = fmt.Errorf("Invalid format")
+	ErrInvalidWidths          = fmt.Errorf("Invalid widths")
+	ErrInvalidDPRs            = fmt.Errorf("Invalid dprs")
+	ErrInvalidPaletteSize     = fmt.Errorf("Invalid palette size")
+	ErrInvalidPrecision       = fmt.Errorf("Invalid precision")
+	ErrInvalidSheetColumns    = fmt.Errorf("Invalid cols")
+	ErrInvalidSheetFrames     = fmt.Errorf("Invalid frames")
+	ErrInvalidSheetWidth      = fmt.Errorf("Invalid cell width")
+	ErrSheetTooLarge          = fmt.Errorf("Contact sheet too large")
+	ErrInvalidSizes           = fmt.Errorf("Invalid sizes")
+	ErrInvalidFormats         = fmt.Errorf("Invalid formats")
+	ErrTooManyVariants        = fmt.Errorf("Too many variants")
+	ErrInvalidDownloadJob     = fmt.Errorf("Invalid download job")
+	ErrMissingCompareImages   = fmt.Errorf("Missing images to compare")
+	ErrInvalidQuality         = fmt.Errorf("Invalid quality")
+	ErrInvalidNearLossless    = fmt.Errorf("Invalid near lossless level")
+	ErrInvalidDPR             = fmt.Errorf("Invalid dpr")
+	ErrInvalidAspectRatio     = fmt.Errorf("Invalid aspect ratio")
+	ErrInvalidBackground      = fmt.Errorf("Invalid background color")
+	ErrInvalidFrame           = fmt.Errorf("Invalid frame")
+	ErrInvalidTrimColor       = fmt.Errorf("Invalid trim color")
+	ErrInvalidTrimTolerance   = fmt.Errorf("Invalid trim tolerance")
+	ErrInvalidText            = fmt.Errorf("Invalid text")
+	ErrInvalidTextColor       = fmt.Errorf("Invalid text color")
+	ErrInvalidGravity         = fmt.Errorf("Invalid gravity")
+	ErrInvalidOrientation     = fmt.Errorf("Invalid orientation")
+	ErrInvalidBlendMode       = fmt.Errorf("Invalid blend mode")
+	ErrInvalidBlendOpacity    = fmt.Errorf("Invalid blend opacity")
+	ErrInvalidOverlayPosition = fmt.Errorf("Invalid overlay position")
+	ErrInvalidOverlayOpacity  = fmt.Errorf("Invalid overlay opacity")
+	ErrInvalidOverlaySize     = fmt.Errorf("Invalid overlay size")
+	ErrInvalidSaturation      = fmt.Errorf("Invalid saturation")
+	ErrInvalidVibrance        = fmt.Errorf("Invalid vibrance")
+	ErrInvalidColorize        = fmt.Errorf("Invalid colorize")
+	ErrInvalidSepia           = fmt.Errorf("Invalid sepia")
+	ErrInvalidExtract         = fmt.Errorf("Invalid extract")
+	ErrInvalidColorblind      = fmt.Errorf("Invalid colorblind")
+	ErrInvalidPixelFormat     = fmt.Errorf("Invalid pixel format")
+	ErrInvalidFit             = fmt.Errorf("Invalid fit")
+	ErrInvalidAuto            = fmt.Errorf("Invalid auto")
+	ErrInvalidInvertRegion    = fmt.Errorf("Invalid invert region")
+	ErrInvalidCrop            = fmt.Errorf("Invalid crop")
+	ErrInvalidThreshold       = fmt.Errorf("Invalid threshold")
+	ErrInvalidGamma           = fmt.Errorf("Invalid gamma")
+	ErrInvalidBrightness      = fmt.Errorf("Invalid brightness")
+	ErrInvalidContrast        = fmt.Errorf("Invalid contrast")
+	ErrInvalidVignette        = fmt.Errorf("Invalid vignette")
+	ErrInvalidSharpen         = fmt.Errorf("Invalid sharpen")
+	ErrMaskRequiresAlpha      = fmt.Errorf("Mask requires the .webp extension")
+	ErrTransparentBackground  = fmt.Errorf("Transparent background requires the .webp extension")
+	ErrInvalidDPI             = fmt.Errorf("Invalid dpi")
+	ErrDPIRequiresJPEG        = fmt.Errorf("DPI requires the .jpg extension")
 )
 
 const (
-	defaultBlurAmount = 5
-	minBlurAmount     = 1
-	maxBlurAmount     = 10
-	maxImageSize      = 5000 // The max allowed image width/height that can be requested
+	defaultBlurAmount     = 5
+	minBlurAmount         = 1
+	maxBlurAmount         = 10
+	maxImageSize          = 5000 // The max allowed image width/height that can be requested
+	minEffort             = 0
+	maxEffort             = 6
+	noEffort              = -1 // Used when no effort is requested, to fall back to the configured default
+	minQuality            = 1
+	maxQuality            = 100
+	noQuality             = -1 // Used when no quality is requested, to fall back to the configured default
+	minNearLossless       = 0
+	maxNearLossless       = 100
+	noNearLossless        = -1 // Used when near lossless encoding isn't requested
+	defaultDPR            = 1
+	minDPR                = 1
+	maxDPR                = 4
+	defaultTrimTolerance  = 10
+	minTrimTolerance      = 0
+	maxTrimTolerance      = 255
+	maxTextLength         = 100 // The max amount of characters of text that can be drawn on the image
+	minOrientation        = 1
+	maxOrientation        = 8
+	defaultBlendOpacity   = 1
+	minBlendOpacity       = 0
+	maxBlendOpacity       = 1
+	defaultOverlayOpacity = 100
+	minOverlayOpacity     = 0
+	maxOverlayOpacity     = 100
+	defaultOverlaySize    = 20 // The percentage of the image the overlay is fit within
+	minOverlaySize        = 1
+	maxOverlaySize        = 100
+	defaultSaturation     = 1
+	minSaturation         = 0
+	maxSaturation         = 3
+	defaultVibrance       = 0
+	minVibrance           = -100
+	maxVibrance           = 100
+	maxColorizeHue        = 360
+	defaultColorizeChroma = 50  // The chroma of the color when colorizing with a hue, in LCh
+	defaultSepia          = 100 // The sepia amount when the param is present without one
+	minSepia              = 0
+	maxSepia              = 100
+	minThreshold          = 0
+	maxThreshold          = 255
+	noThreshold           = -1 // Used when no threshold is requested, as 0 is a valid threshold
+	defaultGamma          = 1
+	minGamma              = 0.1
+	maxGamma              = 3
+	defaultBrightness     = 0
+	minBrightness         = -100
+	maxBrightness         = 100
+	defaultContrast       = 0
+	minContrast           = -100
+	maxContrast           = 100
+	defaultVignette       = 50 // The vignette intensity when the param is present without one
+	minVignette           = 0
+	maxVignette           = 100
+	defaultSharpen        = 1
+	minSharpen            = 0
+	maxSharpen            = 5
+	noSharpen             = -1 // Used when no sharpening is requested, to fall back to the configured default
+	minDPI                = 1
+	maxDPI                = 2400
+	noDPI                 = 0 // Used when no dpi is requested, to fall back to the configured default
+)
+
+// Resize filters
+const (
+	ResizeFilterLanczos = "lanczos"
+	ResizeFilterCubic   = "cubic"
+	ResizeFilterLinear  = "linear"
+	ResizeFilterNearest = "nearest"
+
+	defaultResizeFilter = ResizeFilterLanczos
+)
+
+// Blur edges, how the pixels past the edges of the image are filled in when blurring
+const (
+	BlurEdgeExtend = "extend"
+	BlurEdgeMirror = "mirror"
+	BlurEdgeWrap   = "wrap"
+
+	defaultBlurEdge = BlurEdgeExtend
+)
+
+// Blur scales, whether the blur amount is the same at every size or relative to the size of the image
+const (
+	BlurScaleAbsolute = "absolute"
+	BlurScaleRelative = "relative"
+
+	defaultBlurScale = BlurScaleAbsolute
+)
+
+// Gravities
+const (
+	GravityCenter    = "center"
+	GravityNorth     = "north"
+	GravityNorthEast = "northeast"
+	GravityEast      = "east"
+	GravitySouthEast = "southeast"
+	GravitySouth     = "south"
+	GravitySouthWest = "southwest"
+	GravityWest      = "west"
+	GravityNorthWest = "northwest"
+
+	defaultGravity    = GravityCenter
+	defaultOverlayPos = GravitySouthEast
+)
+
+// Blend modes
+const (
+	BlendModeNormal   = "normal"
+	BlendModeMultiply = "multiply"
+	BlendModeScreen   = "screen"
+	BlendModeOverlay  = "overlay"
+
+	defaultBlendMode = BlendModeNormal
+)
+
+// Features enabled by the auto shorthand
+const (
+	AutoFeatureCompress = "compress"
+	AutoFeatureFormat   = "format"
+)
+
+// Quality presets, mapped to a quality for each format by the image service
+const (
+	QualityLow    = "low"
+	QualityMedium = "medium"
+	QualityHigh   = "high"
+)
+
+// QualityPresets are the names that can be used in place of a numeric quality
+var QualityPresets = []string{QualityLow, QualityMedium, QualityHigh}
+
+// Fits
+const (
+	FitCover   = "cover"
+	FitContain = "contain"
+	FitFill    = "fill"
+	FitInside  = "inside"
+	FitOutside = "outside"
+	FitPad     = "pad"
+
+	defaultFit = FitCover
+)
+
+// Channels that can be extracted
+const (
+	ExtractRed       = "red"
+	ExtractGreen     = "green"
+	ExtractBlue      = "blue"
+	ExtractAlpha     = "alpha"
+	ExtractLuminance = "luminance"
+)
+
+// Types of color blindness that can be simulated
+const (
+	ColorblindProtanopia   = "protanopia"
+	ColorblindDeuteranopia = "deuteranopia"
+	ColorblindTritanopia   = "tritanopia"
+)
+
+// Pixel formats of the raw pixels of .bin images
+const (
+	PixelFormatRGB8  = "rgb8"
+	PixelFormatRGBA8 = "rgba8"
+	PixelFormatGray8 = "gray8"
+
+	defaultPixelFormat = PixelFormatRGB8
+)
+
+// Color spaces
+const (
+	ColorSpaceSRGB      = "srgb"
+	ColorSpaceDisplayP3 = "p3"
+
+	defaultColorSpace = ColorSpaceSRGB
 )
 
 // Params contains all the parameters for a request
 type Params struct {
-	Width      int
-	Height     int
-	Blur       bool
-	BlurAmount int
-	Grayscale  bool
-	Extension  string
+	Width          int
+	Height         int
+	Blur           bool
+	BlurAmount     int
+	Grayscale      bool
+	Extension      string
+	ResizeFilter   string
+	NoUpscale      bool
+	Rounding       Rounding
+	Effort         int
+	ColorSpace     string
+	AutoFormat     bool
+	Quality        int
+	AutoQuality    bool
+	QualityPreset  string
+	Lossless       bool
+	AutoLossless   bool
+	NearLossless   int
+	DPR            float64
+	AspectRatio    AspectRatio
+	Background     string
+	Flatten        bool
+	Frame          int
+	Trim           bool
+	TrimColor      string
+	TrimTolerance  int
+	ShowText       bool
+	Text           string
+	TextColor      string
+	Gravity        string
+	Orient         int
+	AutoRotate     *bool
+	Blend          string
+	BlendMode      string
+	BlendOpacity   float64
+	Overlay        string
+	OverlayPos     string
+	OverlayOpacity int
+	OverlaySize    int
+	Saturation     float64
+	Vibrance       int
+	Colorize       string
+	SepiaAmount    int
+	Gamma          float64
+	Brightness     int
+	Contrast       int
+	Vignette       int
+	Sharpen        float64
+	Threshold      int
+	Dither         bool
+	Placeholder    bool
+	BlurBefore     bool
+	BlurEdge       string
+	BlurScale      string
+	BlurRegion     Region
+	Mask           string
+	Extract        string
+	ColorblindMode string
+	PixelFormat    string
+	Fit            string
+	Crop           Crop
+	CropBounds     CropBounds
+	QualityBounds  QualityBounds
+	InvertRegion   Region
+	DPI            int
+	Debug          bool
+	SizeOnly       bool
+	Beacon         bool
+	Async          bool
+	Filter         string
+
+	IgnoreUnknownAuto bool
+	DisabledEffects   DisabledEffects
+	MaxEffects        int
+	Filters           Filters
+	ProcessingVersion string
+
+	unknownAuto []string
+}
+
+// AspectRatio is an aspect ratio to pad the image to, such as 16:9
+type AspectRatio struct {
+	Width  int
+	Height int
+}
+
+// Region is a rectangle of the output image, in pixels from the top left
+type Region struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// Crop is a rectangle of the source image to crop it to before resizing, from the top left
+// It's either in pixels, or in percent of the source dimensions when Percent is set
+// When Ratio is set, it's the largest region of that aspect ratio instead, positioned by the gravity
+type Crop struct {
+	X       float64
+	Y       float64
+	Width   float64
+	Height  float64
+	Percent bool
+	Ratio   AspectRatio
 }
 
+// cropAspectRatioPrefix is the prefix of crops to an aspect ratio, such as crop=ar:16:9
+const cropAspectRatioPrefix = "ar:"
+
 // GetParams parses and returns all the path and query parameters
 func GetParams(r *http.Request) (*Params, error) {
 	// Get and validate the width and height from the path parameters
@@ -51,23 +386,351 @@ func GetParams(r *http.Request) (*Params, error) {
 	// Get and validate the query parameters for grayscale and blur
 	grayscale, blur, blurAmount := getQueryParams(r)
 
+	// Get the optional resize filter from the query parameters
+	resizeFilter, err := getResizeFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional encoder effort level from the query parameters
+	effort, err := getEffort(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional output colorspace from the query parameters
+	colorSpace, err := getColorSpace(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional automatic format selection from the query parameters
+	autoFormat, err := getAutoFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional encoder quality from the query parameters
+	quality, qualityPreset, autoQuality, err := getQuality(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional shorthand for the automatic features from the query parameters
+	// An explicit quality takes precedence over auto=compress
+	autoCompress, autoFormatFeature, unknownAuto := getAuto(r)
+	autoFormat = autoFormat || autoFormatFeature
+	if autoCompress && quality == noQuality && qualityPreset == "" {
+		autoQuality = true
+	}
+
+	// Get the optional lossless and near lossless encoding from the query parameters
+	lossless, autoLossless := getLossless(r)
+	nearLossless, err := getNearLossless(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional device pixel ratio from the query parameters
+	dpr, err := getDPR(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional aspect ratio to pad to from the query parameters
+	aspectRatio, err := getAspectRatio(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional background color from the query parameters
+	background, err := getBackground(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get whether to flatten transparent areas onto the background from the query parameters
+	flatten := boolParam(r, "flatten")
+
+	// Get the optional frame of an animated image from the query parameters
+	frame, err := getFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional border trimming from the query parameters
+	trim, trimColor, trimTolerance, err := getTrim(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional text overlay from the query parameters
+	showText, text, textColor, err := getText(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional gravity from the query parameters
+	gravity, err := getGravity(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional orientation override from the query parameters
+	orient, err := getOrient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional override of rotating the image by its orientation from the query parameters
+	autoRotate := getAutoRotate(r)
+
+	// Get the optional image to blend with from the query parameters
+	blend, blendMode, blendOpacity, err := getBlend(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional image to overlay from the query parameters
+	overlay, overlayPos, overlayOpacity, overlaySize, err := getOverlay(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional color adjustments from the query parameters
+	saturation, err := getSaturation(r)
+	if err != nil {
+		return nil, err
+	}
+
+	vibrance, err := getVibrance(r)
+	if err != nil {
+		return nil, err
+	}
+
+	colorize, err := getColorize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional sepia amount from the query parameters
+	sepia, err := getSepia(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional gamma correction from the query parameters
+	gamma, err := getGamma(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional tonal adjustments from the query parameters
+	brightness, err := getBrightness(r)
+	if err != nil {
+		return nil, err
+	}
+
+	contrast, err := getContrast(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional vignette intensity from the query parameters
+	vignette, err := getVignette(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional sharpening from the query parameters
+	sharpen, err := getSharpen(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional black and white threshold from the query parameters
+	threshold, err := getThreshold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dither := boolParam(r, "dither")
+
+	// Get the optional mask image from the query parameters
+	mask := r.URL.Query().Get("mask")
+
+	// Get the optional channel to extract from the query parameters
+	extract, err := getExtract(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional color blindness to simulate from the query parameters
+	colorblind, err := getColorblind(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional pixel format of raw output from the query parameters
+	pixelFormat, err := getPixelFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional fit from the query parameters
+	fit, err := getFit(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional region of the source image to crop to from the query parameters
+	crop, err := getCrop(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional region to invert from the query parameters
+	invertRegion, err := getRegion(r, "invertregion", ErrInvalidInvertRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional noupscale flag from the query parameters
+	noUpscale := boolParam(r, "noupscale")
+
+	// Get the optional placeholder flag from the query parameters
+	placeholder := boolParam(r, "placeholder")
+
+	// Get the optional flag to blur the image before resizing it from the query parameters
+	blurBefore := boolParam(r, "blurbefore")
+
+	// Get the optional edge handling of the blur from the query parameters
+	blurEdge, err := getBlurEdge(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional scaling of the blur from the query parameters
+	blurScale, err := getBlurScale(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional region to limit the blur to from the query parameters
+	blurRegion, err := getRegion(r, "blurregion", ErrInvalidBlurRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the optional debug flag from the query parameters, which is only used by the image service
+	debug := boolParam(r, "debug")
+
+	// Get the optional flag to only respond with the size of the processed image, which is only used by the image service
+	sizeOnly := boolParam(r, "sizeonly")
+
+	// Get the optional flag to respond with a transparent pixel instead of the image, which is only used by the image service
+	beacon := boolParam(r, "beacon")
+
+	// Get the optional flag to process the image in the background and respond with a job to poll, which is only used by the image service
+	async := boolParam(r, "async")
+
+	// Get the optional named filter from the query parameters
+	filter := getFilter(r)
+
+	// Get the optional resolution to embed in the image from the query parameters
+	dpi, err := getDPI(r)
+	if err != nil {
+		return nil, err
+	}
+
 	params := &Params{
-		Width:      width,
-		Height:     height,
-		Blur:       blur,
-		BlurAmount: blurAmount,
-		Grayscale:  grayscale,
-		Extension:  extension,
+		Width:          width,
+		Height:         height,
+		Blur:           blur,
+		BlurAmount:     blurAmount,
+		Grayscale:      grayscale,
+		Extension:      extension,
+		ResizeFilter:   resizeFilter,
+		NoUpscale:      noUpscale,
+		Effort:         effort,
+		ColorSpace:     colorSpace,
+		AutoFormat:     autoFormat,
+		Quality:        quality,
+		AutoQuality:    autoQuality,
+		QualityPreset:  qualityPreset,
+		Lossless:       lossless,
+		AutoLossless:   autoLossless,
+		NearLossless:   nearLossless,
+		DPR:            dpr,
+		AspectRatio:    aspectRatio,
+		Background:     background,
+		Flatten:        flatten,
+		Frame:          frame,
+		Trim:           trim,
+		TrimColor:      trimColor,
+		TrimTolerance:  trimTolerance,
+		ShowText:       showText,
+		Text:           text,
+		TextColor:      textColor,
+		Gravity:        gravity,
+		Orient:         orient,
+		AutoRotate:     autoRotate,
+		Blend:          blend,
+		BlendMode:      blendMode,
+		BlendOpacity:   blendOpacity,
+		Overlay:        overlay,
+		OverlayPos:     overlayPos,
+		OverlayOpacity: overlayOpacity,
+		OverlaySize:    overlaySize,
+		Saturation:     saturation,
+		Vibrance:       vibrance,
+		Colorize:       colorize,
+		SepiaAmount:    sepia,
+		Gamma:          gamma,
+		Brightness:     brightness,
+		Contrast:       contrast,
+		Vignette:       vignette,
+		Sharpen:        sharpen,
+		Threshold:      threshold,
+		Dither:         dither,
+		Placeholder:    placeholder,
+		BlurBefore:     blurBefore,
+		BlurEdge:       blurEdge,
+		BlurScale:      blurScale,
+		BlurRegion:     blurRegion,
+		Mask:           mask,
+		Extract:        extract,
+		ColorblindMode: colorblind,
+		PixelFormat:    pixelFormat,
+		Fit:            fit,
+		Crop:           crop,
+		InvertRegion:   invertRegion,
+		DPI:            dpi,
+		Debug:          debug,
+		SizeOnly:       sizeOnly,
+		Beacon:         beacon,
+		Async:          async,
+		Filter:         filter,
+		unknownAuto:    unknownAuto,
 	}
 
 	return params, nil
 }
 
-// getSize gets the image size from the size or the width/height path params, and validates it
+// getSize gets the image size from the size or the width/height path params, or the w/h query params, and validates it
+// The path params take precedence, so the query params are only used by the routes without a size in the path
 func getSize(r *http.Request) (width int, height int, err error) {
+	vars := mux.Vars(r)
+
 	// Check for the size parameter first
 	if size, ok := intParam(r, "size"); ok {
 		width, height = size, size
+	} else if dimensions, ok := vars["dimensions"]; ok {
+		// Then for a combined {width}x{height}
+		return parseDimensions(dimensions)
+	} else if _, ok := vars["width"]; !ok {
+		// Without a size in the path, check for the w/h query params
+		return queryDimensions(r)
 	} else {
 		// If size doesn't exist, check for width/height
 		width, ok = intParam(r, "width")
@@ -84,6 +747,46 @@ func getSize(r *http.Request) (width int, height int, err error) {
 	return
 }
 
+// queryDimensions gets the width and height from the size={width}x{height} query param, or the w/h query params which both have to be set
+// Both of them have to be positive, the same as for size={width}x{height}
+func queryDimensions(r *http.Request) (width int, height int, err error) {
+	if size := r.URL.Query().Get("size"); size != "" {
+		return parseDimensions(size)
+	}
+
+	width, err = strconv.Atoi(r.URL.Query().Get("w"))
+	if err != nil || width < 1 {
+		return -1, -1, ErrInvalidSize
+	}
+
+	height, err = strconv.Atoi(r.URL.Query().Get("h"))
+	if err != nil || height < 1 {
+		return -1, -1, ErrInvalidSize
+	}
+
+	return width, height, nil
+}
+
+// parseDimensions parses a combined {width}x{height} size, where both of them have to be positive
+func parseDimensions(dimensions string) (width int, height int, err error) {
+	parts := strings.Split(dimensions, "x")
+	if len(parts) != 2 {
+		return -1, -1, ErrInvalidSize
+	}
+
+	width, err = strconv.Atoi(parts[0])
+	if err != nil || width < 1 {
+		return -1, -1, ErrInvalidSize
+	}
+
+	height, err = strconv.Atoi(parts[1])
+	if err != nil || height < 1 {
+		return -1, -1, ErrInvalidSize
+	}
+
+	return width, height, nil
+}
+
 // intParam tries to get a param and convert it to an Integer
 func intParam(r *http.Request, name string) (int, bool) {
 	vars := mux.Vars(r)
@@ -108,66 +811,1457 @@ func getFileExtension(r *http.Request) (extension string, err error) {
 		val = ".jpg"
 	}
 
-	if val != ".jpg" && val != ".webp" {
+	if val != ".jpg" && val != ".webp" && val != ".bin" {
 		return "", ErrInvalidFileExtension
 	}
 
 	return val, nil
 }
 
-// getQueryParams returns whether the grayscale and blur queryparams are present
+// getQueryParams returns whether the grayscale and blur queryparams are enabled
+// blur=0 and blur=false turn blur off, so that it can be overridden explicitly
 func getQueryParams(r *http.Request) (grayscale bool, blur bool, blurAmount int) {
-	if _, ok := r.URL.Query()["grayscale"]; ok {
-		grayscale = true
-	}
+	grayscale = boolParam(r, "grayscale")
 
 	if _, ok := r.URL.Query()["blur"]; ok {
+		val := r.URL.Query().Get("blur")
 		blur = true
 		blurAmount = defaultBlurAmount
 
-		if val, err := strconv.Atoi(r.URL.Query().Get("blur")); err == nil {
-			blurAmount = val
+		if amount, err := strconv.Atoi(val); err == nil {
+			if amount == 0 {
+				return grayscale, false, 0
+			}
+
+			blurAmount = amount
 			return
 		}
+
+		if isFalse(val) {
+			return grayscale, false, 0
+		}
 	}
 
 	return
 }
 
-// Validate checks that the size and blur amounts are within the allowed limits
-func (p *Params) Validate(image *database.Image) error {
-	if p.Width > maxImageSize && p.Width != image.Width {
-		return ErrInvalidSize
+// boolParam returns whether a boolean query param is enabled
+// The param is enabled when it's present, unless it's explicitly set to a false value such as grayscale=false
+func boolParam(r *http.Request, name string) bool {
+	if _, ok := r.URL.Query()[name]; !ok {
+		return false
 	}
 
-	if p.Height > maxImageSize && p.Height != image.Height {
-		return ErrInvalidSize
-	}
+	return !isFalse(r.URL.Query().Get(name))
+}
 
-	if p.Blur && p.BlurAmount < minBlurAmount {
-		return ErrInvalidBlurAmount
+// isFalse returns whether a query param value explicitly means false
+func isFalse(val string) bool {
+	switch strings.ToLower(val) {
+	case "false", "0", "no", "off":
+		return true
+	default:
+		return false
 	}
+}
 
-	if p.Blur && p.BlurAmount > maxBlurAmount {
-		return ErrInvalidBlurAmount
-	}
+// getResizeFilter gets the resize filter (if present) from the query params, and validates it
+func getResizeFilter(r *http.Request) (filter string, err error) {
+	val := strings.ToLower(r.URL.Query().Get("resize-filter"))
 
-	return nil
+	switch val {
+	case "":
+		return defaultResizeFilter, nil
+	case ResizeFilterLanczos, ResizeFilterCubic, ResizeFilterLinear, ResizeFilterNearest:
+		return val, nil
+	default:
+		return "", ErrInvalidResizeFilter
+	}
 }
 
-// Dimensions returns the image dimensions based on the given params
-func (p *Params) Dimensions(databaseImage *database.Image) (width, height int) {
-	// Default to the image width/height if 0 is passed
-	width = p.Width
-	height = p.Height
+// getBlurEdge gets how the edges of the image are handled when blurring (if present) from the query params, and validates it
+func getBlurEdge(r *http.Request) (edge string, err error) {
+	val := strings.ToLower(r.URL.Query().Get("bluredge"))
 
-	if width == 0 {
-		width = databaseImage.Width
+	switch val {
+	case "":
+		return defaultBlurEdge, nil
+	case BlurEdgeExtend, BlurEdgeMirror, BlurEdgeWrap:
+		return val, nil
+	default:
+		return "", ErrInvalidBlurEdge
+	}
+}
+
+// getBlurScale gets whether the blur amount is relative to the size of the image (if present) from the query params, and validates it
+func getBlurScale(r *http.Request) (scale string, err error) {
+	val := strings.ToLower(r.URL.Query().Get("blurscale"))
+
+	switch val {
+	case "":
+		return defaultBlurScale, nil
+	case BlurScaleAbsolute, BlurScaleRelative:
+		return val, nil
+	default:
+		return "", ErrInvalidBlurScale
+	}
+}
+
+// getEffort gets the encoder effort level (if present) from the query params
+func getEffort(r *http.Request) (effort int, err error) {
+	if _, ok := r.URL.Query()["effort"]; !ok {
+		return noEffort, nil
+	}
+
+	effort, err = strconv.Atoi(r.URL.Query().Get("effort"))
+	if err != nil {
+		return noEffort, ErrInvalidEffort
+	}
+
+	return effort, nil
+}
+
+// getColorSpace gets the output colorspace (if present) from the query params, and validates it
+func getColorSpace(r *http.Request) (colorSpace string, err error) {
+	val := strings.ToLower(r.URL.Query().Get("colorspace"))
+
+	switch val {
+	case "":
+		return defaultColorSpace, nil
+	case ColorSpaceSRGB, ColorSpaceDisplayP3:
+		return val, nil
+	default:
+		return "", ErrInvalidColorSpace
+	}
+}
+
+// getAutoFormat returns whether automatic format selection is requested in the query params
+func getAutoFormat(r *http.Request) (autoFormat bool, err error) {
+	if _, ok := r.URL.Query()["format"]; !ok {
+		return false, nil
+	}
+
+	if strings.ToLower(r.URL.Query().Get("format")) != "auto" {
+		return false, ErrInvalidFormat
+	}
+
+	return true, nil
+}
+
+// getAuto gets the automatic features (if present) from the comma separated auto query param
+// auto=compress is the same as quality=auto, and auto=format is the same as format=auto
+// Unsupported features are returned so that they can be rejected or ignored when validating the params
+func getAuto(r *http.Request) (compress bool, format bool, unknown []string) {
+	val := r.URL.Query().Get("auto")
+	if val == "" {
+		return false, false, nil
+	}
+
+	for _, feature := range strings.Split(strings.ToLower(val), ",") {
+		switch feature = strings.TrimSpace(feature); feature {
+		case AutoFeatureCompress:
+			compress = true
+		case AutoFeatureFormat:
+			format = true
+		default:
+			unknown = append(unknown, feature)
+		}
+	}
+
+	return compress, format, unknown
+}
+
+// getQuality gets the encoder quality (if present) from the query params
+// quality=auto picks the lowest quality that still looks the same as the full quality image, and quality=low|medium|high a preset
+func getQuality(r *http.Request) (quality int, preset string, auto bool, err error) {
+	if _, ok := r.URL.Query()["quality"]; !ok {
+		return noQuality, "", false, nil
+	}
+
+	val := strings.ToLower(r.URL.Query().Get("quality"))
+	if val == "auto" {
+		return noQuality, "", true, nil
+	}
+
+	for _, name := range QualityPresets {
+		if val == name {
+			return noQuality, name, false, nil
+		}
+	}
+
+	quality, err = strconv.Atoi(val)
+	if err != nil {
+		return noQuality, "", false, ErrInvalidQuality
+	}
+
+	return quality, "", false, nil
+}
+
+// getLossless gets whether to encode the image losslessly (if present) from the query params
+// lossless=auto leaves it to the image service, which picks lossless encoding for graphics and lossy encoding for photos
+func getLossless(r *http.Request) (lossless bool, auto bool) {
+	if strings.ToLower(r.URL.Query().Get("lossless")) == "auto" {
+		return false, true
+	}
+
+	return boolParam(r, "lossless"), false
+}
+
+// getNearLossless gets the near lossless preprocessing level (if present) from the query params
+func getNearLossless(r *http.Request) (level int, err error) {
+	if _, ok := r.URL.Query()["nearlossless"]; !ok {
+		return noNearLossless, nil
+	}
+
+	level, err = strconv.Atoi(r.URL.Query().Get("nearlossless"))
+	if err != nil || level < 0 {
+		return noNearLossless, ErrInvalidNearLossless
+	}
+
+	return level, nil
+}
+
+// getDPR gets the device pixel ratio (if present) from the query params
+func getDPR(r *http.Request) (dpr float64, err error) {
+	if _, ok := r.URL.Query()["dpr"]; !ok {
+		return defaultDPR, nil
+	}
+
+	dpr, err = strconv.ParseFloat(r.URL.Query().Get("dpr"), 64)
+	if err != nil || math.IsNaN(dpr) {
+		return defaultDPR, ErrInvalidDPR
+	}
+
+	return dpr, nil
+}
+
+// getSaturation gets the saturation multiplier (if present) from the query params, or the sat alias
+func getSaturation(r *http.Request) (saturation float64, err error) {
+	val, ok := aliasedParam(r, "saturation")
+	if !ok {
+		return defaultSaturation, nil
+	}
+
+	saturation, err = strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(saturation) {
+		return defaultSaturation, ErrInvalidSaturation
+	}
+
+	return saturation, nil
+}
+
+// getVibrance gets the vibrance adjustment (if present) from the query params
+func getVibrance(r *http.Request) (vibrance int, err error) {
+	if _, ok := r.URL.Query()["vibrance"]; !ok {
+		return defaultVibrance, nil
+	}
+
+	vibrance, err = strconv.Atoi(r.URL.Query().Get("vibrance"))
+	if err != nil {
+		return defaultVibrance, ErrInvalidVibrance
+	}
+
+	return vibrance, nil
+}
+
+// getSharpen gets the amount of sharpening (if present) from the query params
+// sharpen on its own uses the default amount, and sharpen=0 and sharpen=false turn it off, so that it can be overridden explicitly
+func getSharpen(r *http.Request) (sharpen float64, err error) {
+	if _, ok := r.URL.Query()["sharpen"]; !ok {
+		return noSharpen, nil
+	}
+
+	val := r.URL.Query().Get("sharpen")
+	if val == "" {
+		return defaultSharpen, nil
+	}
+
+	if isFalse(val) {
+		return 0, nil
+	}
+
+	sharpen, err = strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(sharpen) || sharpen < 0 {
+		return noSharpen, ErrInvalidSharpen
+	}
+
+	return sharpen, nil
+}
+
+// getGamma gets the gamma correction (if present) from the query params
+func getGamma(r *http.Request) (gamma float64, err error) {
+	if _, ok := r.URL.Query()["gamma"]; !ok {
+		return defaultGamma, nil
+	}
+
+	gamma, err = strconv.ParseFloat(r.URL.Query().Get("gamma"), 64)
+	if err != nil || math.IsNaN(gamma) {
+		return defaultGamma, ErrInvalidGamma
+	}
+
+	return gamma, nil
+}
+
+// getBrightness gets the brightness adjustment (if present) from the query params, or the bri alias
+func getBrightness(r *http.Request) (brightness int, err error) {
+	val, ok := aliasedParam(r, "brightness")
+	if !ok {
+		return defaultBrightness, nil
+	}
+
+	brightness, err = strconv.Atoi(val)
+	if err != nil {
+		return defaultBrightness, ErrInvalidBrightness
+	}
+
+	return brightness, nil
+}
+
+// getContrast gets the contrast adjustment (if present) from the query params, or the con alias
+func getContrast(r *http.Request) (contrast int, err error) {
+	val, ok := aliasedParam(r, "contrast")
+	if !ok {
+		return defaultContrast, nil
+	}
+
+	contrast, err = strconv.Atoi(val)
+	if err != nil {
+		return defaultContrast, ErrInvalidContrast
+	}
+
+	return contrast, nil
+}
+
+// getThreshold gets the luminance threshold to convert the image to black and white at (if present) from the query params
+func getThreshold(r *http.Request) (threshold int, err error) {
+	if _, ok := r.URL.Query()["threshold"]; !ok {
+		return noThreshold, nil
+	}
+
+	// Negative thresholds are rejected here, so that they can't be mistaken for no threshold
+	threshold, err = strconv.Atoi(r.URL.Query().Get("threshold"))
+	if err != nil || threshold < minThreshold {
+		return noThreshold, ErrInvalidThreshold
+	}
+
+	return threshold, nil
+}
+
+// getDPI gets the resolution to embed in the image (if present) from the query params
+func getDPI(r *http.Request) (dpi int, err error) {
+	if _, ok := r.URL.Query()["dpi"]; !ok {
+		return noDPI, nil
+	}
+
+	// A dpi of 0 is rejected here, so that it can't be mistaken for no dpi
+	dpi, err = strconv.Atoi(r.URL.Query().Get("dpi"))
+	if err != nil || dpi < minDPI {
+		return noDPI, ErrInvalidDPI
+	}
+
+	return dpi, nil
+}
+
+// getAspectRatio gets the aspect ratio (if present) from the query params, in the form width:height
+func getAspectRatio(r *http.Request) (aspectRatio AspectRatio, err error) {
+	val := r.URL.Query().Get("ratio")
+	if val == "" {
+		return AspectRatio{}, nil
+	}
+
+	return parseAspectRatio(val, ErrInvalidAspectRatio)
+}
+
+// parseAspectRatio parses an aspect ratio in the form width:height, invalidErr is returned when it's malformed
+func parseAspectRatio(val string, invalidErr error) (AspectRatio, error) {
+	parts := strings.Split(val, ":")
+	if len(parts) != 2 {
+		return AspectRatio{}, invalidErr
+	}
+
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width < 1 {
+		return AspectRatio{}, invalidErr
+	}
+
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height < 1 {
+		return AspectRatio{}, invalidErr
+	}
+
+	return AspectRatio{Width: width, Height: height}, nil
+}
+
+// getRegion gets a region of the output image (if present) from the query param, in the form x,y,width,height
+// invalidErr is returned when the region is malformed, or when any of the values is larger than the max image size,
+// which no region of the output image can be, and which keeps the sums of them from overflowing
+func getRegion(r *http.Request, name string, invalidErr error) (region Region, err error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return Region{}, nil
+	}
+
+	parts := strings.Split(val, ",")
+	if len(parts) != 4 {
+		return Region{}, invalidErr
+	}
+
+	var values [4]int
+	for i, part := range parts {
+		values[i], err = strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || values[i] < 0 || values[i] > maxImageSize {
+			return Region{}, invalidErr
+		}
+	}
+
+	region = Region{X: values[0], Y: values[1], Width: values[2], Height: values[3]}
+	if region.Width < 1 || region.Height < 1 {
+		return Region{}, invalidErr
+	}
+
+	return region, nil
+}
+
+// getCrop gets the region to crop the source image to (if present) from the query params, in the form x,y,width,height
+// The values are either all in pixels, or all in percent of the source dimensions, such as 10%,10%,80%,80%
+// An aspect ratio in the form ar:width:height crops to the largest region of that ratio instead, such as ar:16:9
+func getCrop(r *http.Request) (crop Crop, err error) {
+	val := r.URL.Query().Get("crop")
+	if val == "" {
+		return Crop{}, nil
+	}
+
+	if strings.HasPrefix(strings.ToLower(val), cropAspectRatioPrefix) {
+		crop.Ratio, err = parseAspectRatio(val[len(cropAspectRatioPrefix):], ErrInvalidCrop)
+		if err != nil {
+			return Crop{}, err
+		}
+
+		// The region has to be at least a pixel on each side for any source image
+		if crop.Ratio.Width > maxImageSize*crop.Ratio.Height || crop.Ratio.Height > maxImageSize*crop.Ratio.Width {
+			return Crop{}, ErrInvalidCrop
+		}
+
+		return crop, nil
+	}
+
+	parts := strings.Split(val, ",")
+	if len(parts) != 4 {
+		return Crop{}, ErrInvalidCrop
+	}
+
+	var values [4]float64
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		percent := strings.HasSuffix(part, "%")
+
+		// Mixing pixels and percentages would make the rectangle depend on the aspect ratio of the source in confusing ways
+		if i > 0 && percent != crop.Percent {
+			return Crop{}, ErrInvalidCrop
+		}
+		crop.Percent = percent
+
+		if percent {
+			values[i], err = strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		} else {
+			var pixels int
+			pixels, err = strconv.Atoi(part)
+			values[i] = float64(pixels)
+		}
+
+		// Pixel values are limited to the max image size like the regions are, which keeps the sums of them from overflowing
+		if err != nil || math.IsNaN(values[i]) || values[i] < 0 || (percent && values[i] > 100) || (!percent && values[i] > maxImageSize) {
+			return Crop{}, ErrInvalidCrop
+		}
+	}
+
+	crop.X, crop.Y, crop.Width, crop.Height = values[0], values[1], values[2], values[3]
+	if crop.Width <= 0 || crop.Height <= 0 {
+		return Crop{}, ErrInvalidCrop
+	}
+
+	if crop.Percent && (crop.X+crop.Width > 100 || crop.Y+crop.Height > 100) {
+		return Crop{}, ErrInvalidCrop
+	}
+
+	return crop, nil
+}
+
+// getBackground gets the background color (if present) from the query params
+// transparent and colors with an alpha are allowed as well, and are only valid for the formats that support alpha
+func getBackground(r *http.Request) (background string, err error) {
+	val := r.URL.Query().Get("bg")
+	if val == "" {
+		return "", nil
+	}
+
+	if strings.ToLower(val) == ColorTransparent {
+		return ColorTransparent, nil
+	}
+
+	background, ok := parseAlphaColor(val)
+	if !ok {
+		return "", ErrInvalidBackground
+	}
+
+	return background, nil
+}
+
+// getTrim gets whether to trim the borders of the image (if present) from the query params
+// The border color is detected from the top left corner, unless trimcolor is set, which also enables trimming
+func getTrim(r *http.Request) (trim bool, color string, tolerance int, err error) {
+	trim = boolParam(r, "trim")
+	tolerance = defaultTrimTolerance
+
+	if val := r.URL.Query().Get("trimcolor"); val != "" {
+		var ok bool
+		color, ok = parseColor(val)
+		if !ok {
+			return false, "", 0, ErrInvalidTrimColor
+		}
+
+		trim = true
+	}
+
+	if _, ok := r.URL.Query()["trimtol"]; ok {
+		tolerance, err = strconv.Atoi(r.URL.Query().Get("trimtol"))
+		if err != nil {
+			return false, "", 0, ErrInvalidTrimTolerance
+		}
+	}
+
+	return trim, color, tolerance, nil
+}
+
+// getText gets the text to draw on the image (if present) from the query params
+// An empty text param is allowed, and draws the dimensions of the image instead
+func getText(r *http.Request) (show bool, text string, color string, err error) {
+	// The color is read even without the text, so that Validate can reject it
+	if val := r.URL.Query().Get("textcolor"); val != "" {
+		var ok bool
+		color, ok = parseColor(val)
+		if !ok {
+			return false, "", "", ErrInvalidTextColor
+		}
+	}
+
+	if _, ok := r.URL.Query()["text"]; !ok {
+		return false, "", color, nil
+	}
+
+	text = r.URL.Query().Get("text")
+	if utf8.RuneCountInString(text) > maxTextLength {
+		return false, "", "", ErrInvalidText
+	}
+
+	return true, text, color, nil
+}
+
+// getGravity gets the gravity (if present) from the query params, and validates it
+func getGravity(r *http.Request) (gravity string, err error) {
+	val := strings.ToLower(r.URL.Query().Get("gravity"))
+
+	if val == "" {
+		return defaultGravity, nil
+	}
+
+	if !isGravity(val) {
+		return "", ErrInvalidGravity
+	}
+
+	return val, nil
+}
+
+// isGravity returns whether val is one of the gravities
+func isGravity(val string) bool {
+	switch val {
+	case GravityCenter, GravityNorth, GravityNorthEast, GravityEast, GravitySouthEast, GravitySouth, GravitySouthWest, GravityWest, GravityNorthWest:
+		return true
+	default:
+		return false
+	}
+}
+
+// getOrient gets the EXIF orientation to use instead of the one in the image (if present) from the query params
+func getOrient(r *http.Request) (orient int, err error) {
+	if _, ok := r.URL.Query()["orient"]; !ok {
+		return 0, nil
+	}
+
+	// 0 is used for not overriding the orientation, so it can't be requested
+	orient, err = strconv.Atoi(r.URL.Query().Get("orient"))
+	if err != nil || orient == 0 {
+		return 0, ErrInvalidOrientation
+	}
+
+	return orient, nil
+}
+
+// getBlend gets the id of the image to blend on top of the image (if present) from the query params, along with the blend mode and opacity
+func getBlend(r *http.Request) (blend string, mode string, opacity float64, err error) {
+	blend = r.URL.Query().Get("blend")
+	mode = strings.ToLower(r.URL.Query().Get("blendmode"))
+	opacity = defaultBlendOpacity
+
+	switch mode {
+	case "":
+		mode = defaultBlendMode
+	case BlendModeNormal, BlendModeMultiply, BlendModeScreen, BlendModeOverlay:
+	default:
+		return "", "", 0, ErrInvalidBlendMode
+	}
+
+	if _, ok := r.URL.Query()["blendopacity"]; ok {
+		opacity, err = strconv.ParseFloat(r.URL.Query().Get("blendopacity"), 64)
+		if err != nil || math.IsNaN(opacity) {
+			return "", "", 0, ErrInvalidBlendOpacity
+		}
+	}
+
+	return blend, mode, opacity, nil
+}
+
+// getOverlay gets the id of the image to place on top of the image (if present) from the query params, along with its position, opacity and size
+func getOverlay(r *http.Request) (overlay string, pos string, opacity int, size int, err error) {
+	overlay = r.URL.Query().Get("overlay")
+	pos = strings.ToLower(r.URL.Query().Get("overlaypos"))
+	opacity = defaultOverlayOpacity
+	size = defaultOverlaySize
+
+	if pos == "" {
+		pos = defaultOverlayPos
+	} else if !isGravity(pos) {
+		return "", "", 0, 0, ErrInvalidOverlayPosition
+	}
+
+	if _, ok := r.URL.Query()["overlayopacity"]; ok {
+		opacity, err = strconv.Atoi(r.URL.Query().Get("overlayopacity"))
+		if err != nil {
+			return "", "", 0, 0, ErrInvalidOverlayOpacity
+		}
+	}
+
+	if _, ok := r.URL.Query()["overlaysize"]; ok {
+		size, err = strconv.Atoi(r.URL.Query().Get("overlaysize"))
+		if err != nil {
+			return "", "", 0, 0, ErrInvalidOverlaySize
+		}
+	}
+
+	return overlay, pos, opacity, size, nil
+}
+
+// getExtract gets the channel to output as a grayscale image (if present) from the query params, and validates it
+func getExtract(r *http.Request) (extract string, err error) {
+	extract = strings.ToLower(r.URL.Query().Get("extract"))
+
+	switch extract {
+	case "", ExtractRed, ExtractGreen, ExtractBlue, ExtractAlpha, ExtractLuminance:
+		return extract, nil
+	default:
+		return "", ErrInvalidExtract
+	}
+}
+
+// getPixelFormat gets the pixel format of raw output (if present) from the query params, and validates it
+// The default of rgb8 is left empty, so that requesting it explicitly is the same image
+func getPixelFormat(r *http.Request) (pixelFormat string, err error) {
+	pixelFormat = strings.ToLower(r.URL.Query().Get("pixelformat"))
+
+	switch pixelFormat {
+	case defaultPixelFormat:
+		return "", nil
+	case "", PixelFormatRGBA8, PixelFormatGray8:
+		return pixelFormat, nil
+	default:
+		return "", ErrInvalidPixelFormat
+	}
+}
+
+// RawPixelFormat returns the pixel format of raw output, defaulting to rgb8
+func (p *Params) RawPixelFormat() string {
+	if p.PixelFormat == "" {
+		return defaultPixelFormat
+	}
+
+	return p.PixelFormat
+}
+
+// getColorblind gets the type of color blindness to simulate (if present) from the query params, and validates it
+func getColorblind(r *http.Request) (colorblind string, err error) {
+	colorblind = strings.ToLower(r.URL.Query().Get("colorblind"))
+
+	switch colorblind {
+	case "", ColorblindProtanopia, ColorblindDeuteranopia, ColorblindTritanopia:
+		return colorblind, nil
+	default:
+		return "", ErrInvalidColorblind
+	}
+}
+
+// getFit gets the fit (if present) from the query params, and validates it
+// An absent fit is left empty, so that the default of the deployment can be applied to it
+func getFit(r *http.Request) (fit string, err error) {
+	val := r.URL.Query().Get("fit")
+	if val == "" {
+		return "", nil
+	}
+
+	return ParseFit(val)
+}
+
+// ParseFit parses and validates the name of a fit, an empty name gives the default of cover
+// The aliases of fits are resolved to the fit they're an alias of, such as crop to cover
+func ParseFit(value string) (string, error) {
+	fit := strings.ToLower(value)
+	if alias, ok := FitAliases[fit]; ok {
+		fit = alias
+	}
+
+	switch fit {
+	case "":
+		return defaultFit, nil
+	case FitCover, FitContain, FitFill, FitInside, FitOutside, FitPad:
+		return fit, nil
+	default:
+		return "", ErrInvalidFit
+	}
+}
+
+// getAutoRotate gets whether to rotate the image by its EXIF orientation from the query params, nil when it's not set
+func getAutoRotate(r *http.Request) *bool {
+	if _, ok := r.URL.Query()["autorotate"]; !ok {
+		return nil
+	}
+
+	autoRotate := boolParam(r, "autorotate")
+	return &autoRotate
+}
+
+// AutoRotates returns whether the image is rotated by its EXIF orientation, with the autorotate param overriding the default of the service
+func (p *Params) AutoRotates(byDefault bool) bool {
+	if p.AutoRotate != nil {
+		return *p.AutoRotate
+	}
+
+	return byDefault
+}
+
+// HasOrient returns whether the orientation of the image is overridden
+func (p *Params) HasOrient() bool {
+	return p.Orient != 0
+}
+
+// parseHexColor parses a 3 or 6 digit hex color without the #, and normalizes it to 6 lowercase digits
+func parseHexColor(val string) (color string, ok bool) {
+	val = strings.ToLower(val)
+	if len(val) == 3 {
+		val = string([]byte{val[0], val[0], val[1], val[1], val[2], val[2]})
+	}
+
+	if len(val) != 6 {
+		return "", false
+	}
+
+	if _, err := strconv.ParseUint(val, 16, 32); err != nil {
+		return "", false
+	}
+
+	return val, true
+}
+
+// getColorize gets the hue or hex color to colorize the image with (if present) from the query params
+// Up to three digits, or a number with a fraction, is a hue in degrees, anything else is a hex or named color, so colorize=120 is green rather than #112200
+// Colorizing replaces the colors of the image with a single hue while keeping the lightness, rather than overlaying a color on top of it
+func getColorize(r *http.Request) (colorize string, err error) {
+	val := r.URL.Query().Get("colorize")
+	if val == "" {
+		return "", nil
+	}
+
+	if isColorizeHue(val) {
+		hue, err := strconv.ParseFloat(val, 64)
+		if err != nil || hue < 0 || hue > maxColorizeHue {
+			return "", ErrInvalidColorize
+		}
+
+		return strconv.FormatFloat(hue, 'f', -1, 64), nil
+	}
+
+	colorize, ok := parseColor(val)
+	if !ok {
+		return "", ErrInvalidColorize
+	}
+
+	return colorize, nil
+}
+
+// getSepia gets the sepia amount (if present) from the query params
+// sepia without a value is full sepia, and sepia=0 and sepia=false turn it off, like blur
+func getSepia(r *http.Request) (sepia int, err error) {
+	if _, ok := r.URL.Query()["sepia"]; !ok {
+		return 0, nil
+	}
+
+	val := r.URL.Query().Get("sepia")
+	if val == "" {
+		return defaultSepia, nil
+	}
+
+	if isFalse(val) {
+		return 0, nil
+	}
+
+	sepia, err = strconv.Atoi(val)
+	if err != nil {
+		return 0, ErrInvalidSepia
+	}
+
+	return sepia, nil
+}
+
+// getVignette gets the vignette intensity (if present) from the query params
+// vignette without a value is a medium vignette, and vignette=0 and vignette=false turn it off, like sepia
+func getVignette(r *http.Request) (vignette int, err error) {
+	if _, ok := r.URL.Query()["vignette"]; !ok {
+		return 0, nil
+	}
+
+	val := r.URL.Query().Get("vignette")
+	if val == "" {
+		return defaultVignette, nil
+	}
+
+	if isFalse(val) {
+		return 0, nil
+	}
+
+	vignette, err = strconv.Atoi(val)
+	if err != nil {
+		return 0, ErrInvalidVignette
+	}
+
+	return vignette, nil
+}
+
+// isColorizeHue returns whether a colorize value is a hue rather than a hex color
+func isColorizeHue(val string) bool {
+	if strings.Contains(val, ".") {
+		return true
+	}
+
+	if len(val) > 3 {
+		return false
+	}
+
+	for _, c := range val {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hexToHueChroma converts an sRGB color to its hue and chroma in LCh, with a D65 white point like vips uses
+func hexToHueChroma(r, g, b uint8) (hue float64, chroma float64) {
+	linear := func(value uint8) float64 {
+		v := float64(value) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+
+	lr, lg, lb := linear(r), linear(g), linear(b)
+	x := (0.4124*lr + 0.3576*lg + 0.1805*lb) / 0.95047
+	y := 0.2126*lr + 0.7152*lg + 0.0722*lb
+	z := (0.0193*lr + 0.1192*lg + 0.9505*lb) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+
+		return (24389.0/27*t + 16) / 116
+	}
+
+	labA := 500 * (f(x) - f(y))
+	labB := 200 * (f(y) - f(z))
+
+	hue = math.Atan2(labB, labA) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+
+	return hue, math.Hypot(labA, labB)
+}
+
+// getFrame gets the 0-indexed frame of an animated image (if present) from the query params
+// Whether the frame exists depends on the source image, so that's checked when processing it
+func getFrame(r *http.Request) (frame int, err error) {
+	val := r.URL.Query().Get("frame")
+	if val == "" {
+		return 0, nil
+	}
+
+	frame, err = strconv.Atoi(val)
+	if err != nil || frame < 0 {
+		return 0, ErrInvalidFrame
+	}
+
+	return frame, nil
+}
+
+// HasAspectRatio returns whether an aspect ratio to pad the image to was requested
+func (p *Params) HasAspectRatio() bool {
+	return p.AspectRatio.Width > 0 && p.AspectRatio.Height > 0
+}
+
+// HasBlurRegion returns whether the blur should be limited to a region of the image
+func (p *Params) HasBlurRegion() bool {
+	return p.BlurRegion.Width > 0 && p.BlurRegion.Height > 0
+}
+
+// HasInvertRegion returns whether a region of the image should be inverted
+func (p *Params) HasInvertRegion() bool {
+	return p.InvertRegion.Width > 0 && p.InvertRegion.Height > 0
+}
+
+// HasCrop returns whether the source image should be cropped
+func (p *Params) HasCrop() bool {
+	return (p.Crop.Width > 0 && p.Crop.Height > 0) || p.hasAspectRatioCrop()
+}
+
+// hasAspectRatioCrop returns whether the source image should be cropped to an aspect ratio, rather than to a region
+func (p *Params) hasAspectRatioCrop() bool {
+	return p.Crop.Ratio.Width > 0 && p.Crop.Ratio.Height > 0
+}
+
+// CropRegion returns the region of the source image to crop to in pixels, resolving percentages against the source dimensions
+// It's the whole image when no crop was requested
+func (p *Params) CropRegion(databaseImage *database.Image) Region {
+	if !p.HasCrop() {
+		return Region{Width: databaseImage.Width, Height: databaseImage.Height}
+	}
+
+	if p.hasAspectRatioCrop() {
+		return p.aspectRatioCropRegion(databaseImage)
+	}
+
+	if !p.Crop.Percent {
+		return Region{X: int(p.Crop.X), Y: int(p.Crop.Y), Width: int(p.Crop.Width), Height: int(p.Crop.Height)}
+	}
+
+	region := Region{
+		X:      int(p.Rounding.round(p.Crop.X * float64(databaseImage.Width) / 100)),
+		Y:      int(p.Rounding.round(p.Crop.Y * float64(databaseImage.Height) / 100)),
+		Width:  p.Rounding.apply(p.Crop.Width * float64(databaseImage.Width) / 100),
+		Height: p.Rounding.apply(p.Crop.Height * float64(databaseImage.Height) / 100),
+	}
+
+	// Rounding can end up a pixel outside of the image, a crop at the edge is moved back within it instead
+	if region.Width > databaseImage.Width {
+		region.Width = databaseImage.Width
+	}
+
+	if region.Height > databaseImage.Height {
+		region.Height = databaseImage.Height
+	}
+
+	if region.X+region.Width > databaseImage.Width {
+		region.X = databaseImage.Width - region.Width
+	}
+
+	if region.Y+region.Height > databaseImage.Height {
+		region.Y = databaseImage.Height - region.Height
+	}
+
+	return region
+}
+
+// aspectRatioCropRegion returns the largest region of the aspect ratio of the crop within the source image, positioned by the gravity
+func (p *Params) aspectRatioCropRegion(databaseImage *database.Image) Region {
+	ratio := float64(p.Crop.Ratio.Width) / float64(p.Crop.Ratio.Height)
+	region := Region{Width: databaseImage.Width, Height: databaseImage.Height}
+
+	// The side of the source that's too long for the ratio is shortened, the other one is kept whole
+	if float64(databaseImage.Width) > float64(databaseImage.Height)*ratio {
+		region.Width = p.Rounding.apply(float64(databaseImage.Height) * ratio)
+	} else {
+		region.Height = p.Rounding.apply(float64(databaseImage.Width) / ratio)
+	}
+
+	// Rounding up can end up a pixel larger than the image
+	if region.Width > databaseImage.Width {
+		region.Width = databaseImage.Width
+	}
+
+	if region.Height > databaseImage.Height {
+		region.Height = databaseImage.Height
+	}
+
+	horizontal, vertical := gravityOffsets(p.Gravity)
+	region.X = int(p.Rounding.round(float64(databaseImage.Width-region.Width) * horizontal))
+	region.Y = int(p.Rounding.round(float64(databaseImage.Height-region.Height) * vertical))
+	return region
+}
+
+// gravityOffsets returns how far along the free space a region is placed by the gravity, from 0 at the left or top to 1 at the right or bottom
+func gravityOffsets(gravity string) (horizontal, vertical float64) {
+	horizontal, vertical = 0.5, 0.5
+
+	switch gravity {
+	case GravityNorthWest, GravityWest, GravitySouthWest:
+		horizontal = 0
+	case GravityNorthEast, GravityEast, GravitySouthEast:
+		horizontal = 1
+	}
+
+	switch gravity {
+	case GravityNorthWest, GravityNorth, GravityNorthEast:
+		vertical = 0
+	case GravitySouthWest, GravitySouth, GravitySouthEast:
+		vertical = 1
+	}
+
+	return
+}
+
+// source returns the source image as it is after cropping it, which is what the image is resized from
+func (p *Params) source(databaseImage *database.Image) *database.Image {
+	if !p.HasCrop() {
+		return databaseImage
+	}
+
+	region := p.CropRegion(databaseImage)
+	cropped := *databaseImage
+	cropped.Width = region.Width
+	cropped.Height = region.Height
+	return &cropped
+}
+
+// HasQuality returns whether an encoder quality was requested
+func (p *Params) HasQuality() bool {
+	return p.Quality != noQuality
+}
+
+// HasNearLossless returns whether near lossless encoding was requested
+func (p *Params) HasNearLossless() bool {
+	return p.NearLossless != noNearLossless
+}
+
+// HasSaturation returns whether the saturation should be adjusted
+func (p *Params) HasSaturation() bool {
+	return p.Saturation != defaultSaturation
+}
+
+// HasVibrance returns whether the vibrance should be adjusted
+func (p *Params) HasVibrance() bool {
+	return p.Vibrance != defaultVibrance
+}
+
+// HasSepia returns whether the image should be toned sepia
+func (p *Params) HasSepia() bool {
+	return p.SepiaAmount > 0
+}
+
+// HasGamma returns whether gamma correction should be applied
+func (p *Params) HasGamma() bool {
+	return p.Gamma != defaultGamma
+}
+
+// HasBrightness returns whether the brightness should be adjusted
+func (p *Params) HasBrightness() bool {
+	return p.Brightness != defaultBrightness
+}
+
+// HasContrast returns whether the contrast should be adjusted
+func (p *Params) HasContrast() bool {
+	return p.Contrast != defaultContrast
+}
+
+// HasVignette returns whether the image should be darkened toward its edges
+func (p *Params) HasVignette() bool {
+	return p.Vignette > 0
+}
+
+// HasSharpen returns whether the amount of sharpening was requested, including turning it off with sharpen=0
+func (p *Params) HasSharpen() bool {
+	return p.Sharpen != noSharpen
+}
+
+// HasThreshold returns whether the image should be converted to black and white
+func (p *Params) HasThreshold() bool {
+	return p.Threshold != noThreshold
+}
+
+// ColorizeHueChroma returns the hue and chroma in LCh to colorize the image with
+// A hue on its own uses a moderate chroma, while a hex color uses its own hue and chroma
+func (p *Params) ColorizeHueChroma() (hue float64, chroma float64) {
+	if isColorizeHue(p.Colorize) {
+		hue, _ = strconv.ParseFloat(p.Colorize, 64)
+		return hue, defaultColorizeChroma
+	}
+
+	value, _ := strconv.ParseUint(p.Colorize, 16, 32)
+	return hexToHueChroma(uint8(value>>16), uint8(value>>8), uint8(value))
+}
+
+// HasDPI returns whether a resolution to embed in the image was requested
+func (p *Params) HasDPI() bool {
+	return p.DPI != noDPI
+}
+
+// Unprocessed returns whether the image is requested at the size of the source, without any of the params that change it
+// BuildQuery includes all of those params that aren't the defaults, so processing an image without any of them would only re-encode it
+func (p *Params) Unprocessed(databaseImage *database.Image) bool {
+	width, height := p.OutputDimensions(databaseImage)
+	return width == databaseImage.Width && height == databaseImage.Height && BuildQuery(p) == ""
+}
+
+// HasEffort returns whether an encoder effort level was requested
+func (p *Params) HasEffort() bool {
+	return p.Effort != noEffort
+}
+
+// Validate checks the params against the image and the config of the deployment, and returns the first error it finds
+// It resolves the filter, enforces the disabled effects and the max amount of effects, checks that the sizes and
+// amounts are within their limits, that the crop and the regions are within the image, that the output and padded
+// sizes are within the max image size, and that the output format supports the params, then checks the params for conflicts
+func (p *Params) Validate(image *database.Image) error {
+	// The filter is resolved first, so that its adjustments are validated and count as effects like the ones requested directly
+	if err := p.applyFilter(); err != nil {
+		return err
+	}
+
+	// Disabled effects are checked first, so the params of the ones that are ignored aren't validated
+	if err := p.checkDisabledEffects(); err != nil {
+		return err
+	}
+
+	// The ignored disabled effects have been removed, so they don't count towards the max
+	if err := p.checkMaxEffects(); err != nil {
+		return err
+	}
+
+	if p.Width > maxImageSize && p.Width != image.Width {
+		return ErrInvalidSize
+	}
+
+	if p.Height > maxImageSize && p.Height != image.Height {
+		return ErrInvalidSize
+	}
+
+	if p.Blur && p.BlurAmount < minBlurAmount {
+		return ErrInvalidBlurAmount
+	}
+
+	if p.Blur && p.BlurAmount > maxBlurAmount {
+		return ErrInvalidBlurAmount
+	}
+
+	if p.HasEffort() && (p.Effort < minEffort || p.Effort > maxEffort) {
+		return ErrInvalidEffort
+	}
+
+	if p.HasQuality() && (p.Quality < minQuality || p.Quality > maxQuality) {
+		return ErrInvalidQuality
+	}
+
+	if err := p.checkQualityBounds(); err != nil {
+		return err
+	}
+
+	if p.HasNearLossless() && (p.NearLossless < minNearLossless || p.NearLossless > maxNearLossless) {
+		return ErrInvalidNearLossless
+	}
+
+	if len(p.unknownAuto) > 0 && !p.IgnoreUnknownAuto {
+		return ErrInvalidAuto
+	}
+
+	if p.DPR < minDPR || p.DPR > maxDPR {
+		return ErrInvalidDPR
+	}
+
+	if p.HasOrient() && (p.Orient < minOrientation || p.Orient > maxOrientation) {
+		return ErrInvalidOrientation
+	}
+
+	if p.BlendOpacity < minBlendOpacity || p.BlendOpacity > maxBlendOpacity {
+		return ErrInvalidBlendOpacity
+	}
+
+	if p.OverlayOpacity < minOverlayOpacity || p.OverlayOpacity > maxOverlayOpacity {
+		return ErrInvalidOverlayOpacity
+	}
+
+	if p.OverlaySize < minOverlaySize || p.OverlaySize > maxOverlaySize {
+		return ErrInvalidOverlaySize
+	}
+
+	if p.Saturation < minSaturation || p.Saturation > maxSaturation {
+		return ErrInvalidSaturation
+	}
+
+	if p.Vibrance < minVibrance || p.Vibrance > maxVibrance {
+		return ErrInvalidVibrance
+	}
+
+	if p.SepiaAmount < minSepia || p.SepiaAmount > maxSepia {
+		return ErrInvalidSepia
+	}
+
+	if p.Gamma < minGamma || p.Gamma > maxGamma {
+		return ErrInvalidGamma
+	}
+
+	if p.Brightness < minBrightness || p.Brightness > maxBrightness {
+		return ErrInvalidBrightness
+	}
+
+	if p.Contrast < minContrast || p.Contrast > maxContrast {
+		return ErrInvalidContrast
+	}
+
+	if p.Vignette < minVignette || p.Vignette > maxVignette {
+		return ErrInvalidVignette
+	}
+
+	if p.HasSharpen() && (p.Sharpen < minSharpen || p.Sharpen > maxSharpen) {
+		return ErrInvalidSharpen
+	}
+
+	if p.HasThreshold() && (p.Threshold < minThreshold || p.Threshold > maxThreshold) {
+		return ErrInvalidThreshold
+	}
+
+	// The mask becomes the alpha channel, so the output has to be in a format that supports it
+	if p.Mask != "" && (p.Extension != ".webp" || p.AutoFormat) {
+		return ErrMaskRequiresAlpha
+	}
+
+	// As does a transparent or translucent background
+	if p.hasTransparentBackground() && (p.Extension != ".webp" || p.AutoFormat) {
+		return ErrTransparentBackground
+	}
+
+	if p.HasDPI() && (p.DPI < minDPI || p.DPI > maxDPI) {
+		return ErrInvalidDPI
+	}
+
+	// Only JPEG images have a density in their header, so the resolution would be lost in WebP images and raw pixels
+	if p.HasDPI() && (p.Extension != ".jpg" || p.AutoFormat) {
+		return ErrDPIRequiresJPEG
+	}
+
+	if p.TrimTolerance < minTrimTolerance || p.TrimTolerance > maxTrimTolerance {
+		return ErrInvalidTrimTolerance
+	}
+
+	// The crop has to be within the source image, unless it's configured to be clamped to it
+	if p.HasCrop() && !p.Crop.Percent && !p.hasAspectRatioCrop() && (int(p.Crop.X)+int(p.Crop.Width) > image.Width || int(p.Crop.Y)+int(p.Crop.Height) > image.Height) {
+		if p.CropBounds != CropClamp || !p.clampCrop(image.Width, image.Height) {
+			return ErrInvalidCrop
+		}
+	}
+
+	// The size after applying the device pixel ratio also has to be within the limits
+	width, height := p.OutputDimensions(image)
+	if (width > maxImageSize && width != image.Width) || (height > maxImageSize && height != image.Height) {
+		return ErrInvalidSize
+	}
+
+	// The region to blur has to be within the resized image, as it's blurred before being padded
+	if p.HasBlurRegion() && (p.BlurRegion.X+p.BlurRegion.Width > width || p.BlurRegion.Y+p.BlurRegion.Height > height) {
+		return ErrInvalidBlurRegion
+	}
+
+	// As does the size of the padded canvas
+	canvasWidth, canvasHeight := p.CanvasDimensions(width, height)
+	if (canvasWidth > maxImageSize && canvasWidth != width) || (canvasHeight > maxImageSize && canvasHeight != height) {
+		return ErrInvalidSize
+	}
+
+	// The region to invert has to be within the returned image
+	if p.HasInvertRegion() && (p.InvertRegion.X+p.InvertRegion.Width > canvasWidth || p.InvertRegion.Y+p.InvertRegion.Height > canvasHeight) {
+		return ErrInvalidInvertRegion
+	}
+
+	return p.checkConflicts()
+}
+
+// Dimensions returns the image dimensions based on the given params
+func (p *Params) Dimensions(databaseImage *database.Image) (width, height int) {
+	return p.dimensions(p.source(databaseImage))
+}
+
+// dimensions returns the image dimensions of the source image after cropping it
+func (p *Params) dimensions(databaseImage *database.Image) (width, height int) {
+	// Default to the image width/height if 0 is passed
+	width = p.Width
+	height = p.Height
+
+	if width == 0 {
+		width = databaseImage.Width
 	}
 
 	if height == 0 {
 		height = databaseImage.Height
 	}
 
+	switch p.Fit {
+	case FitInside:
+		width, height = p.resizeInside(width, height, databaseImage)
+	case FitOutside:
+		width, height = p.resizeOutside(width, height, databaseImage)
+	}
+
+	// fit=pad always pads the image to the requested size, even if the image itself isn't upscaled
+	if p.NoUpscale && p.Fit != FitPad {
+		width, height = p.fitWithin(width, height, databaseImage)
+	}
+
+	return
+}
+
+// OutputDimensions returns the dimensions of the processed image, which is the image dimensions scaled by the device pixel ratio
+func (p *Params) OutputDimensions(databaseImage *database.Image) (width, height int) {
+	databaseImage = p.source(databaseImage)
+	width, height = p.dimensions(databaseImage)
+
+	if p.DPR > 1 {
+		width = p.Rounding.apply(float64(width) * p.DPR)
+		height = p.Rounding.apply(float64(height) * p.DPR)
+	}
+
+	if (p.NoUpscale && p.Fit != FitPad) || p.Fit == FitInside {
+		width, height = p.fitWithin(width, height, databaseImage)
+	}
+
 	return
 }
+
+// PadBox returns the size that the image is scaled to fit within with fit=pad, before it's padded to the output dimensions
+// It's the output dimensions, unless upscaling is disabled and the source image is smaller
+func (p *Params) PadBox(databaseImage *database.Image) (width, height int) {
+	width, height = p.OutputDimensions(databaseImage)
+	if p.NoUpscale {
+		width, height = p.fitWithin(width, height, p.source(databaseImage))
+	}
+
+	return
+}
+
+// ClampedDimensions returns the size that the image is scaled to, and whether noupscale clamped it to the size of the source image
+// The size is of the image within the padding for fit=pad, as the padded image is always the requested size
+func (p *Params) ClampedDimensions(databaseImage *database.Image) (width, height int, clamped bool) {
+	width, height = p.PadBox(databaseImage)
+	if !p.NoUpscale {
+		return width, height, false
+	}
+
+	requested := *p
+	requested.NoUpscale = false
+	requestedWidth, requestedHeight := requested.PadBox(databaseImage)
+
+	return width, height, width != requestedWidth || height != requestedHeight
+}
+
+// HasDPR returns whether the image is scaled by a device pixel ratio
+func (p *Params) HasDPR() bool {
+	return p.DPR > defaultDPR
+}
+
+// EffectiveDPR returns the device pixel ratio that the image is scaled by, rounded to two decimals
+// It's lower than the requested ratio when the output is limited to the size of the source image
+func (p *Params) EffectiveDPR(databaseImage *database.Image) float64 {
+	logicalWidth, _ := p.Dimensions(databaseImage)
+	width, _ := p.OutputDimensions(databaseImage)
+	return math.Round(float64(width)/float64(logicalWidth)*100) / 100
+}
+
+// CanvasDimensions returns the dimensions of the canvas that an image of the given size is padded to, to match the aspect ratio
+// The canvas is only ever grown, so the image always fits within it
+func (p *Params) CanvasDimensions(width, height int) (canvasWidth, canvasHeight int) {
+	if !p.HasAspectRatio() {
+		return width, height
+	}
+
+	ratio := float64(p.AspectRatio.Width) / float64(p.AspectRatio.Height)
+	if float64(width)/float64(height) < ratio {
+		// The image is narrower than the ratio, so pad the sides
+		return p.Rounding.apply(float64(height) * ratio), height
+	}
+
+	// The image is wider than the ratio, so pad the top and bottom
+	return width, p.Rounding.apply(float64(width) / ratio)
+}
+
+// resizeInside returns the largest size with the aspect ratio of the image that fits within the box, without upscaling the image
+func (p *Params) resizeInside(width, height int, databaseImage *database.Image) (int, int) {
+	// Scale the image to the width, and to the height, of the box
+	widthByWidth, heightByWidth := width, p.scale(databaseImage.Height, width, databaseImage.Width)
+	widthByHeight, heightByHeight := p.scale(databaseImage.Width, height, databaseImage.Height), height
+
+	// When both of them fit, which can happen due to rounding, the larger one is used so that the size doesn't change when resized again
+	if heightByWidth > height || (widthByHeight <= width && widthByHeight*heightByHeight > widthByWidth*heightByWidth) {
+		width, height = widthByHeight, heightByHeight
+	} else {
+		width, height = widthByWidth, heightByWidth
+	}
+
+	if width > databaseImage.Width || height > databaseImage.Height {
+		return databaseImage.Width, databaseImage.Height
+	}
+
+	return width, height
+}
+
+// resizeOutside returns the smallest size with the aspect ratio of the image that covers the box
+func (p *Params) resizeOutside(width, height int, databaseImage *database.Image) (int, int) {
+	widthByWidth, heightByWidth := width, p.scale(databaseImage.Height, width, databaseImage.Width)
+	widthByHeight, heightByHeight := p.scale(databaseImage.Width, height, databaseImage.Height), height
+
+	// When both of them cover it, the smaller one is used so that the size doesn't change when resized again
+	if heightByWidth < height || (widthByHeight >= width && widthByHeight*heightByHeight < widthByWidth*heightByWidth) {
+		return widthByHeight, heightByHeight
+	}
+
+	return widthByWidth, heightByWidth
+}
+
+// scale scales a dimension of the image by to/from
+// Very narrow sizes are kept at least a pixel wide, as 0 means the size of the image
+func (p *Params) scale(dimension, to, from int) int {
+	scaled := p.Rounding.apply(float64(dimension) * float64(to) / float64(from))
+	if scaled < 1 {
+		return 1
+	}
+
+	return scaled
+}
+
+// fitWithin scales the size down to fit within the image while keeping the aspect ratio
+func (p *Params) fitWithin(width, height int, databaseImage *database.Image) (int, int) {
+	if width <= databaseImage.Width && height <= databaseImage.Height {
+		return width, height
+	}
+
+	scale := math.Min(float64(databaseImage.Width)/float64(width), float64(databaseImage.Height)/float64(height))
+	width = p.Rounding.apply(float64(width) * scale)
+	height = p.Rounding.apply(float64(height) * scale)
+
+	// Rounding up can end up a pixel larger than the image
+	if width > databaseImage.Width {
+		width = databaseImage.Width
+	}
+
+	if height > databaseImage.Height {
+		height = databaseImage.Height
+	}
+
+	return width, height
+}
@@ -0,0 +1,343 @@
+package params
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+	"github.com/gorilla/mux"
+)
+
+// request returns a request with the query
+func request(query string) *http.Request {
+	return &http.Request{URL: &url.URL{RawQuery: query}}
+}
+
+func TestGetRegion(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected Region
+		Err      error
+	}{
+		{"missing", "", Region{}, nil},
+		{"region", "blurregion=10,20,30,40", Region{X: 10, Y: 20, Width: 30, Height: 40}, nil},
+		{"spaces", "blurregion=10,%2020,30,40", Region{X: 10, Y: 20, Width: 30, Height: 40}, nil},
+		{"max image size", "blurregion=5000,5000,5000,5000", Region{X: 5000, Y: 5000, Width: 5000, Height: 5000}, nil},
+		{"too few values", "blurregion=10,20,30", Region{}, ErrInvalidBlurRegion},
+		{"too many values", "blurregion=10,20,30,40,50", Region{}, ErrInvalidBlurRegion},
+		{"not a number", "blurregion=10,20,a,40", Region{}, ErrInvalidBlurRegion},
+		{"negative", "blurregion=-10,20,30,40", Region{}, ErrInvalidBlurRegion},
+		{"empty width", "blurregion=10,20,0,40", Region{}, ErrInvalidBlurRegion},
+		{"empty height", "blurregion=10,20,30,0", Region{}, ErrInvalidBlurRegion},
+		{"x over the max image size", "blurregion=5001,0,10,10", Region{}, ErrInvalidBlurRegion},
+		{"y over the max image size", "blurregion=0,5001,10,10", Region{}, ErrInvalidBlurRegion},
+		{"width over the max image size", "blurregion=0,0,5001,10", Region{}, ErrInvalidBlurRegion},
+		{"height over the max image size", "blurregion=0,0,10,5001", Region{}, ErrInvalidBlurRegion},
+		{"overflowing sum", "blurregion=9223372036854775807,0,1,1", Region{}, ErrInvalidBlurRegion},
+	}
+
+	for _, test := range tests {
+		region, err := getRegion(request(test.Query), "blurregion", ErrInvalidBlurRegion)
+		if err != test.Err {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+			continue
+		}
+
+		if region != test.Expected {
+			t.Errorf("%s: wrong region %#v", test.Name, region)
+		}
+	}
+}
+
+// imageRequest returns a request for an image route of the size, with the query
+func imageRequest(width string, height string, query string) *http.Request {
+	return mux.SetURLVars(request(query), map[string]string{"width": width, "height": height})
+}
+
+func TestGetCrop(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected Crop
+		Err      error
+	}{
+		{"missing", "", Crop{}, nil},
+		{"pixels", "crop=10,20,30,40", Crop{X: 10, Y: 20, Width: 30, Height: 40}, nil},
+		{"spaces", "crop=10,%2020,30,40", Crop{X: 10, Y: 20, Width: 30, Height: 40}, nil},
+		{"percent", "crop=10%25,10%25,80%25,80%25", Crop{X: 10, Y: 10, Width: 80, Height: 80, Percent: true}, nil},
+		{"fractional percent", "crop=12.5%25,0%25,50%25,100%25", Crop{X: 12.5, Y: 0, Width: 50, Height: 100, Percent: true}, nil},
+		{"aspect ratio", "crop=ar:16:9", Crop{Ratio: AspectRatio{Width: 16, Height: 9}}, nil},
+		{"uppercase aspect ratio", "crop=AR:4:3", Crop{Ratio: AspectRatio{Width: 4, Height: 3}}, nil},
+		{"too few values", "crop=10,20,30", Crop{}, ErrInvalidCrop},
+		{"too many values", "crop=10,20,30,40,50", Crop{}, ErrInvalidCrop},
+		{"not a number", "crop=10,20,a,40", Crop{}, ErrInvalidCrop},
+		{"fractional pixels", "crop=10,20,30.5,40", Crop{}, ErrInvalidCrop},
+		{"negative", "crop=-10,20,30,40", Crop{}, ErrInvalidCrop},
+		{"empty width", "crop=10,20,0,40", Crop{}, ErrInvalidCrop},
+		{"empty height", "crop=10,20,30,0", Crop{}, ErrInvalidCrop},
+		{"max image size", "crop=5000,5000,5000,5000", Crop{X: 5000, Y: 5000, Width: 5000, Height: 5000}, nil},
+		{"over the max image size", "crop=0,0,5001,10", Crop{}, ErrInvalidCrop},
+		{"overflowing sum", "crop=0,0,9223372036854775807,1", Crop{}, ErrInvalidCrop},
+		{"mixed pixels and percent", "crop=10,10%25,80%25,80%25", Crop{}, ErrInvalidCrop},
+		{"percent over 100", "crop=0%25,0%25,101%25,50%25", Crop{}, ErrInvalidCrop},
+		{"percent outside of the image", "crop=50%25,0%25,60%25,50%25", Crop{}, ErrInvalidCrop},
+		{"nan percent", "crop=NaN%25,0%25,50%25,50%25", Crop{}, ErrInvalidCrop},
+		{"invalid aspect ratio", "crop=ar:16", Crop{}, ErrInvalidCrop},
+		{"empty aspect ratio", "crop=ar:0:9", Crop{}, ErrInvalidCrop},
+		{"extreme aspect ratio", "crop=ar:5001:1", Crop{}, ErrInvalidCrop},
+	}
+
+	for _, test := range tests {
+		crop, err := getCrop(request(test.Query))
+		if err != test.Err {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+			continue
+		}
+
+		if crop != test.Expected {
+			t.Errorf("%s: wrong crop %#v", test.Name, crop)
+		}
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Query       string
+		Description string
+	}{
+		{"no params", "", ""},
+		{"compatible params", "blur=5&grayscale&text=hello&gravity=north", ""},
+		{"gravity without text", "gravity=north", "gravity requires text or crop=ar"},
+		{"gravity with crop=ar", "gravity=north&crop=ar:1:1", ""},
+		{"textcolor without text", "textcolor=ff0000", "textcolor requires text"},
+		{"bg without padding", "bg=ff0000", "bg requires ratio, fit=contain, fit=pad, or flatten"},
+		{"bg with fit=pad", "bg=ff0000&fit=pad", ""},
+		{"ratio with fit=pad", "ratio=16:9&fit=pad", "ratio conflicts with fit=pad"},
+		{"crop with trim", "crop=0,0,10,10&trim", "crop conflicts with trim"},
+		{"blendmode without blend", "blendmode=multiply", "blendmode requires blend"},
+		{"colorize with grayscale", "colorize=ff0000&grayscale", "colorize conflicts with grayscale, saturation, and vibrance"},
+		{"sharpen with blur", "sharpen=1&blur", "sharpen conflicts with blur"},
+		{"blurregion without blur", "blurregion=0,0,10,10", "blurregion requires blur"},
+		{"blurbefore with blurregion", "blur&blurbefore&blurregion=0,0,10,10", "blurbefore conflicts with blurregion and blend"},
+		{"dither without threshold", "dither", "dither requires threshold"},
+		{"nearlossless with lossless", "nearlossless=60&lossless", "nearlossless conflicts with lossless"},
+	}
+
+	for _, test := range tests {
+		p, err := GetParams(imageRequest("100", "100", test.Query))
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			continue
+		}
+
+		err = p.checkConflicts()
+		if test.Description == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", test.Name, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrConflictingParams) || err.Error() != ErrConflictingParams.Error()+": "+test.Description {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// A portrait image, so that heights that follow the aspect ratio are larger than the widths
+	portrait := &database.Image{ID: "1", Width: 300, Height: 400}
+
+	tests := []struct {
+		Name   string
+		Width  string
+		Height string
+		Query  string
+		Err    error
+	}{
+		{"valid", "100", "100", "", nil},
+		{"max image size", "5000", "5000", "", nil},
+		{"width over the max image size", "5001", "100", "", ErrInvalidSize},
+		{"height over the max image size", "100", "5001", "", ErrInvalidSize},
+		{"dpr over the max image size", "3000", "100", "dpr=2", ErrInvalidSize},
+		{"blur", "100", "100", "blur=10", nil},
+		{"blur amount too large", "100", "100", "blur=11", ErrInvalidBlurAmount},
+		{"quality", "100", "100", "quality=50", nil},
+		{"quality too large", "100", "100", "quality=101", ErrInvalidQuality},
+		{"crop within the image", "100", "100", "crop=0,0,300,400", nil},
+		{"crop outside of the image", "100", "100", "crop=0,0,301,400", ErrInvalidCrop},
+		{"crop at the max image size", "100", "100", "crop=5000,0,5000,1", ErrInvalidCrop},
+		{"percent crop", "100", "100", "crop=0%25,0%25,100%25,100%25", nil},
+		{"blurregion within the image", "100", "100", "blur&blurregion=0,0,100,100", nil},
+		{"blurregion outside of the image", "100", "100", "blur&blurregion=50,0,51,100", ErrInvalidBlurRegion},
+		{"mask without alpha", "100", "100", "mask=2", ErrMaskRequiresAlpha},
+		{"conflicting params", "100", "100", "dither", ErrConflictingParams},
+	}
+
+	for _, test := range tests {
+		p, err := GetParams(imageRequest(test.Width, test.Height, test.Query))
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			continue
+		}
+
+		if err := p.Validate(portrait); !errors.Is(err, test.Err) {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+		}
+	}
+}
+
+func TestGetSrcSetParams(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected *SrcSetParams
+		Err      error
+	}{
+		{"widths", "widths=100,%20200", &SrcSetParams{Widths: []int{100, 200}, Extension: ".jpg"}, nil},
+		{"webp", "widths=100&fm=WEBP", &SrcSetParams{Widths: []int{100}, Extension: ".webp"}, nil},
+		{"dprs", "w=100&dprs=1,2", &SrcSetParams{Width: 100, DPRs: []float64{1, 2}, Extension: ".jpg"}, nil},
+		{"missing widths", "", nil, ErrInvalidWidths},
+		{"invalid width", "widths=100,a", nil, ErrInvalidWidths},
+		{"width over the max image size", "widths=5001", nil, ErrInvalidWidths},
+		{"missing width for dprs", "dprs=1,2", nil, ErrInvalidWidths},
+		{"invalid dpr", "w=100&dprs=1,a", nil, ErrInvalidDPRs},
+		{"dpr over the max image size", "w=3000&dprs=1,2", nil, ErrInvalidDPRs},
+		{"widths and dprs", "widths=100&w=100&dprs=1", nil, ErrConflictingParams},
+		{"invalid format", "widths=100&fm=png", nil, ErrInvalidFileExtension},
+	}
+
+	for _, test := range tests {
+		p, err := GetSrcSetParams(request(test.Query))
+		if !errors.Is(err, test.Err) {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(p, test.Expected) {
+			t.Errorf("%s: wrong params %#v", test.Name, p)
+		}
+	}
+}
+
+func TestSrcSetHeight(t *testing.T) {
+	portrait := &database.Image{ID: "1", Width: 300, Height: 400}
+
+	tests := []struct {
+		Name   string
+		Width  int
+		Height int
+		OK     bool
+	}{
+		{"keeps the aspect ratio", 150, 200, true},
+		{"rounds the height", 100, 133, true},
+		{"max image size", 3750, 5000, true},
+		{"over the max image size", 4000, 5333, false},
+		{"at least a pixel", 0, 1, true},
+	}
+
+	for _, test := range tests {
+		height, ok := SrcSetHeight(test.Width, portrait)
+		if height != test.Height || ok != test.OK {
+			t.Errorf("%s: wrong height %d, %t", test.Name, height, ok)
+		}
+	}
+
+	// The height of the full size image is allowed even when it's over the max image size
+	if _, ok := SrcSetHeight(6000, &database.Image{ID: "2", Width: 6000, Height: 8000}); !ok {
+		t.Error("full size image is rejected")
+	}
+}
+
+func TestGetDownloadParams(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected *DownloadParams
+		Err      error
+	}{
+		{"width", "sizes=100", &DownloadParams{Sizes: []DownloadSize{{Width: 100}}, Extensions: []string{".jpg"}}, nil},
+		{"dimensions", "sizes=100x200,%20300", &DownloadParams{Sizes: []DownloadSize{{Width: 100, Height: 200}, {Width: 300}}, Extensions: []string{".jpg"}}, nil},
+		{"formats", "sizes=100&formats=JPG,webp", &DownloadParams{Sizes: []DownloadSize{{Width: 100}}, Extensions: []string{".jpg", ".webp"}}, nil},
+		{"job", "sizes=100&job=job-1_a", &DownloadParams{Sizes: []DownloadSize{{Width: 100}}, Extensions: []string{".jpg"}, Job: "job-1_a"}, nil},
+		{"missing sizes", "", nil, ErrInvalidSizes},
+		{"invalid size", "sizes=a", nil, ErrInvalidSizes},
+		{"empty width", "sizes=0", nil, ErrInvalidSizes},
+		{"width over the max image size", "sizes=5001", nil, ErrInvalidSizes},
+		{"height over the max image size", "sizes=100x5001", nil, ErrInvalidSizes},
+		{"duplicate size", "sizes=100,100", nil, ErrInvalidSizes},
+		{"invalid format", "sizes=100&formats=png", nil, ErrInvalidFormats},
+		{"duplicate format", "sizes=100&formats=jpg,jpg", nil, ErrInvalidFormats},
+		{"empty formats", "sizes=100&formats=", nil, ErrInvalidFormats},
+		{"too many variants", "sizes=100,200,300,400,500,600,700&formats=jpg,webp", nil, ErrTooManyVariants},
+		{"invalid job", "sizes=100&job=a/b", nil, ErrInvalidDownloadJob},
+		{"empty job", "sizes=100&job=", nil, ErrInvalidDownloadJob},
+	}
+
+	for _, test := range tests {
+		p, err := GetDownloadParams(request(test.Query))
+		if err != test.Err {
+			t.Errorf("%s: wrong error %v", test.Name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(p, test.Expected) {
+			t.Errorf("%s: wrong params %#v", test.Name, p)
+		}
+	}
+}
+
+func TestDownloadVariants(t *testing.T) {
+	portrait := &database.Image{ID: "1", Width: 300, Height: 400}
+
+	tests := []struct {
+		Name     string
+		Sizes    []DownloadSize
+		Expected []DownloadSize
+	}{
+		{"keeps the aspect ratio", []DownloadSize{{Width: 150}}, []DownloadSize{{Width: 150, Height: 200}}},
+		{"keeps the given height", []DownloadSize{{Width: 100, Height: 100}}, []DownloadSize{{Width: 100, Height: 100}}},
+		{"clamps to the max image size", []DownloadSize{{Width: 4000}}, []DownloadSize{{Width: 3750, Height: 5000}}},
+		{"leaves out clamped duplicates", []DownloadSize{{Width: 4000}, {Width: 4500}, {Width: 3750}}, []DownloadSize{{Width: 3750, Height: 5000}}},
+	}
+
+	for _, test := range tests {
+		p := &DownloadParams{Sizes: test.Sizes, Extensions: []string{".jpg"}}
+		if variants := p.Variants(portrait); !reflect.DeepEqual(variants, test.Expected) {
+			t.Errorf("%s: wrong variants %#v", test.Name, variants)
+		}
+	}
+}
+
+func TestQueryDimensions(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Query  string
+		Width  int
+		Height int
+		Err    error
+	}{
+		{"w and h", "w=200&h=100", 200, 100, nil},
+		{"size", "size=200x100", 200, 100, nil},
+		{"size takes precedence", "size=200x100&w=300&h=300", 200, 100, nil},
+		{"empty w", "w=0&h=100", -1, -1, ErrInvalidSize},
+		{"empty h", "w=100&h=0", -1, -1, ErrInvalidSize},
+		{"negative w", "w=-1&h=100", -1, -1, ErrInvalidSize},
+		{"missing h", "w=100", -1, -1, ErrInvalidSize},
+		{"empty size width", "size=0x100", -1, -1, ErrInvalidSize},
+		{"empty size height", "size=100x0", -1, -1, ErrInvalidSize},
+	}
+
+	for _, test := range tests {
+		width, height, err := queryDimensions(request(test.Query))
+		if err != test.Err || width != test.Width || height != test.Height {
+			t.Errorf("%s: wrong dimensions %dx%d, %v", test.Name, width, height, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package params
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Preset is a named set of params
+type Preset struct {
+	Width  int
+	Height int
+	Query  url.Values
+}
+
+// ParsePresets parses a semicolon separated list of presets, in the form name=widthxheight?query
+// For example "og=1200x630;thumbnail=300x300?grayscale&quality=60"
+func ParsePresets(value string) (map[string]Preset, error) {
+	presets := make(map[string]Preset)
+	if value == "" {
+		return presets, nil
+	}
+
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid preset %q", entry)
+		}
+
+		name := parts[0]
+		size := parts[1]
+
+		var query url.Values
+		if i := strings.Index(size, "?"); i != -1 {
+			var err error
+			query, err = url.ParseQuery(size[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid query for preset %s: %s", name, err)
+			}
+
+			size = size[:i]
+		}
+
+		dimensions := strings.Split(size, "x")
+		if len(dimensions) != 2 {
+			return nil, fmt.Errorf("invalid size for preset %s", name)
+		}
+
+		width, err := strconv.Atoi(dimensions[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid width for preset %s", name)
+		}
+
+		height, err := strconv.Atoi(dimensions[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid height for preset %s", name)
+		}
+
+		presets[name] = Preset{
+			Width:  width,
+			Height: height,
+			Query:  query,
+		}
+	}
+
+	return presets, nil
+}
+
+// GetPresetParams parses and returns the params for a preset
+// Query params in the request take precedence over the ones in the preset
+func GetPresetParams(r *http.Request, preset Preset) (*Params, error) {
+	query := url.Values{}
+	for key, values := range preset.Query {
+		query[key] = values
+	}
+
+	for key, values := range r.URL.Query() {
+		query[key] = values
+	}
+
+	// Build a request with the preset size and query, so that it can be parsed like any other request
+	presetRequest := r.Clone(r.Context())
+	presetRequest.URL.RawQuery = query.Encode()
+	presetRequest = mux.SetURLVars(presetRequest, map[string]string{
+		"width":     strconv.Itoa(preset.Width),
+		"height":    strconv.Itoa(preset.Height),
+		"extension": mux.Vars(r)["extension"],
+	})
+
+	return GetParams(presetRequest)
+}
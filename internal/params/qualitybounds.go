@@ -0,0 +1,98 @@
+package params
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QualityBounds are the least and most encoder quality that can be requested for each format, from the config
+type QualityBounds struct {
+	Ranges map[string]QualityRange // By the extension of the format
+	Clamp  bool                    // Clamps the qualities outside of the range to it, rather than rejecting them
+}
+
+// QualityRange is the least and most quality that can be requested for a format
+type QualityRange struct {
+	Min int
+	Max int
+}
+
+// qualityFormats are the extensions of the formats that are encoded with a quality, by the names used in the config
+var qualityFormats = map[string]string{
+	"jpeg": ".jpg",
+	"webp": ".webp",
+}
+
+// ParseQualityBounds parses a semicolon separated list of formats with the min-max range of the quality for them, such as "jpeg:1-90;webp:10-85"
+// An empty list leaves the quality of every format within the 1-100 of the quality param
+func ParseQualityBounds(value string, clamp bool) (QualityBounds, error) {
+	bounds := QualityBounds{
+		Clamp: clamp,
+	}
+
+	if value == "" {
+		return bounds, nil
+	}
+
+	bounds.Ranges = make(map[string]QualityRange)
+	for _, entry := range strings.Split(value, ";") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return QualityBounds{}, fmt.Errorf("invalid quality bounds %q", entry)
+		}
+
+		extension, ok := qualityFormats[strings.ToLower(parts[0])]
+		if !ok {
+			return QualityBounds{}, fmt.Errorf("invalid format %q", parts[0])
+		}
+
+		limits := strings.Split(parts[1], "-")
+		if len(limits) != 2 {
+			return QualityBounds{}, fmt.Errorf("invalid quality range %q", parts[1])
+		}
+
+		min, minErr := strconv.Atoi(limits[0])
+		max, maxErr := strconv.Atoi(limits[1])
+		if minErr != nil || maxErr != nil || min < minQuality || max > maxQuality || min > max {
+			return QualityBounds{}, fmt.Errorf("invalid quality range %q", parts[1])
+		}
+
+		bounds.Ranges[extension] = QualityRange{Min: min, Max: max}
+	}
+
+	return bounds, nil
+}
+
+// checkQualityBounds returns an error when the requested quality is outside of the range of the format, if one is configured
+// When the qualities are clamped, it's clamped to the range instead
+// format=auto can encode the image in any of the formats, so the quality has to be within the ranges of all of them
+func (p *Params) checkQualityBounds() error {
+	if !p.HasQuality() {
+		return nil
+	}
+
+	extensions := []string{p.Extension}
+	if p.AutoFormat {
+		extensions = []string{".jpg", ".webp"}
+	}
+
+	for _, extension := range extensions {
+		limits, ok := p.QualityBounds.Ranges[extension]
+		if !ok || (p.Quality >= limits.Min && p.Quality <= limits.Max) {
+			continue
+		}
+
+		if !p.QualityBounds.Clamp {
+			return fmt.Errorf("%w: %d-%d for %s", ErrInvalidQuality, limits.Min, limits.Max, extension)
+		}
+
+		if p.Quality < limits.Min {
+			p.Quality = limits.Min
+		} else {
+			p.Quality = limits.Max
+		}
+	}
+
+	return nil
+}
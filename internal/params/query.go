@@ -3,29 +3,261 @@ package params
 import (
 	"bytes"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 // Utilities for building a URL with query params
 
-// BuildQuery builds query parameters for the given arguments
-func BuildQuery(grayscale bool, blur bool, blurAmount int) string {
-	if !grayscale && !blur {
-		return ""
-	}
-
+// BuildQuery builds query parameters for the given params
+func BuildQuery(p *Params) string {
 	var buf bytes.Buffer
 
-	if blur {
-		addParam(&buf, fmt.Sprintf("blur=%d", blurAmount))
+	if p.Blur {
+		addParam(&buf, fmt.Sprintf("blur=%d", p.BlurAmount))
+
+		if p.Placeholder {
+			addParam(&buf, "placeholder")
+		}
+
+		if p.BlurBefore {
+			addParam(&buf, "blurbefore")
+		}
+
+		if p.BlurEdge != "" && p.BlurEdge != defaultBlurEdge {
+			addParam(&buf, fmt.Sprintf("bluredge=%s", p.BlurEdge))
+		}
+
+		if p.BlurScale != "" && p.BlurScale != defaultBlurScale {
+			addParam(&buf, fmt.Sprintf("blurscale=%s", p.BlurScale))
+		}
+
+		if p.HasBlurRegion() {
+			addParam(&buf, fmt.Sprintf("blurregion=%d,%d,%d,%d", p.BlurRegion.X, p.BlurRegion.Y, p.BlurRegion.Width, p.BlurRegion.Height))
+		}
 	}
 
-	if grayscale {
+	if p.HasSharpen() {
+		addParam(&buf, fmt.Sprintf("sharpen=%s", strconv.FormatFloat(p.Sharpen, 'f', -1, 64)))
+	}
+
+	if p.Grayscale {
 		addParam(&buf, "grayscale")
 	}
 
+	if p.HasSaturation() {
+		addParam(&buf, fmt.Sprintf("saturation=%s", strconv.FormatFloat(p.Saturation, 'f', -1, 64)))
+	}
+
+	if p.HasVibrance() {
+		addParam(&buf, fmt.Sprintf("vibrance=%d", p.Vibrance))
+	}
+
+	if p.Colorize != "" {
+		addParam(&buf, fmt.Sprintf("colorize=%s", p.Colorize))
+	}
+
+	if p.HasSepia() {
+		addParam(&buf, fmt.Sprintf("sepia=%d", p.SepiaAmount))
+	}
+
+	if p.HasGamma() {
+		addParam(&buf, fmt.Sprintf("gamma=%s", strconv.FormatFloat(p.Gamma, 'f', -1, 64)))
+	}
+
+	if p.HasBrightness() {
+		addParam(&buf, fmt.Sprintf("brightness=%d", p.Brightness))
+	}
+
+	if p.HasContrast() {
+		addParam(&buf, fmt.Sprintf("contrast=%d", p.Contrast))
+	}
+
+	if p.HasVignette() {
+		addParam(&buf, fmt.Sprintf("vignette=%d", p.Vignette))
+	}
+
+	if p.HasThreshold() {
+		addParam(&buf, fmt.Sprintf("threshold=%d", p.Threshold))
+
+		if p.Dither {
+			addParam(&buf, "dither")
+		}
+	}
+
+	if p.Fit != "" && p.Fit != defaultFit {
+		addParam(&buf, fmt.Sprintf("fit=%s", p.Fit))
+	}
+
+	if p.ResizeFilter != "" && p.ResizeFilter != defaultResizeFilter {
+		addParam(&buf, fmt.Sprintf("resize-filter=%s", p.ResizeFilter))
+	}
+
+	if p.ColorSpace != "" && p.ColorSpace != defaultColorSpace {
+		addParam(&buf, fmt.Sprintf("colorspace=%s", p.ColorSpace))
+	}
+
+	if p.AutoFormat {
+		addParam(&buf, "format=auto")
+	}
+
+	if p.HasQuality() {
+		addParam(&buf, fmt.Sprintf("quality=%d", p.Quality))
+	} else if p.QualityPreset != "" {
+		addParam(&buf, fmt.Sprintf("quality=%s", p.QualityPreset))
+	} else if p.AutoQuality {
+		addParam(&buf, "quality=auto")
+	}
+
+	if p.DPR != 0 && p.DPR != defaultDPR {
+		addParam(&buf, fmt.Sprintf("dpr=%s", strconv.FormatFloat(p.DPR, 'f', -1, 64)))
+	}
+
+	if p.HasCrop() {
+		// Only the percent signs need escaping, the commas are kept readable
+		addParam(&buf, fmt.Sprintf("crop=%s", strings.Replace(p.Crop.format(), "%", "%25", -1)))
+	}
+
+	if p.HasDPI() {
+		addParam(&buf, fmt.Sprintf("dpi=%d", p.DPI))
+	}
+
+	if p.PixelFormat != "" {
+		addParam(&buf, fmt.Sprintf("pixelformat=%s", p.PixelFormat))
+	}
+
+	if p.HasInvertRegion() {
+		addParam(&buf, fmt.Sprintf("invertregion=%d,%d,%d,%d", p.InvertRegion.X, p.InvertRegion.Y, p.InvertRegion.Width, p.InvertRegion.Height))
+	}
+
+	if p.ColorblindMode != "" {
+		addParam(&buf, fmt.Sprintf("colorblind=%s", p.ColorblindMode))
+	}
+
+	if p.HasAspectRatio() {
+		addParam(&buf, fmt.Sprintf("ratio=%d:%d", p.AspectRatio.Width, p.AspectRatio.Height))
+	}
+
+	if p.Background != "" {
+		addParam(&buf, fmt.Sprintf("bg=%s", p.Background))
+	}
+
+	if p.Flatten {
+		addParam(&buf, "flatten")
+	}
+
+	if p.Frame > 0 {
+		addParam(&buf, fmt.Sprintf("frame=%d", p.Frame))
+	}
+
+	if p.Trim {
+		addParam(&buf, "trim")
+
+		if p.TrimColor != "" {
+			addParam(&buf, fmt.Sprintf("trimcolor=%s", p.TrimColor))
+		}
+
+		if p.TrimTolerance != defaultTrimTolerance {
+			addParam(&buf, fmt.Sprintf("trimtol=%d", p.TrimTolerance))
+		}
+	}
+
+	if p.ShowText {
+		addParam(&buf, fmt.Sprintf("text=%s", url.QueryEscape(p.Text)))
+
+		if p.TextColor != "" {
+			addParam(&buf, fmt.Sprintf("textcolor=%s", p.TextColor))
+		}
+	}
+
+	if p.Gravity != "" && p.Gravity != defaultGravity {
+		addParam(&buf, fmt.Sprintf("gravity=%s", p.Gravity))
+	}
+
+	if p.HasOrient() {
+		addParam(&buf, fmt.Sprintf("orient=%d", p.Orient))
+	}
+
+	if p.AutoRotate != nil {
+		addParam(&buf, fmt.Sprintf("autorotate=%t", *p.AutoRotate))
+	}
+
+	if p.Blend != "" {
+		addParam(&buf, fmt.Sprintf("blend=%s", url.QueryEscape(p.Blend)))
+
+		if p.BlendMode != "" && p.BlendMode != defaultBlendMode {
+			addParam(&buf, fmt.Sprintf("blendmode=%s", p.BlendMode))
+		}
+
+		if p.BlendOpacity != defaultBlendOpacity {
+			addParam(&buf, fmt.Sprintf("blendopacity=%s", strconv.FormatFloat(p.BlendOpacity, 'f', -1, 64)))
+		}
+	}
+
+	if p.Overlay != "" {
+		addParam(&buf, fmt.Sprintf("overlay=%s", url.QueryEscape(p.Overlay)))
+
+		if p.OverlayPos != "" && p.OverlayPos != defaultOverlayPos {
+			addParam(&buf, fmt.Sprintf("overlaypos=%s", p.OverlayPos))
+		}
+
+		if p.OverlayOpacity != defaultOverlayOpacity {
+			addParam(&buf, fmt.Sprintf("overlayopacity=%d", p.OverlayOpacity))
+		}
+
+		if p.OverlaySize != defaultOverlaySize {
+			addParam(&buf, fmt.Sprintf("overlaysize=%d", p.OverlaySize))
+		}
+	}
+
+	if p.Mask != "" {
+		addParam(&buf, fmt.Sprintf("mask=%s", url.QueryEscape(p.Mask)))
+	}
+
+	if p.Extract != "" {
+		addParam(&buf, fmt.Sprintf("extract=%s", p.Extract))
+	}
+
+	if p.HasEffort() {
+		addParam(&buf, fmt.Sprintf("effort=%d", p.Effort))
+	}
+
+	if p.Lossless {
+		addParam(&buf, "lossless")
+	}
+
+	if p.AutoLossless {
+		addParam(&buf, "lossless=auto")
+	}
+
+	if p.HasNearLossless() {
+		addParam(&buf, fmt.Sprintf("nearlossless=%d", p.NearLossless))
+	}
+
 	return buf.String()
 }
 
+// format formats the crop in the same form as it's parsed, x,y,width,height with the unit of each value
+func (c Crop) format() string {
+	if c.Ratio.Width > 0 && c.Ratio.Height > 0 {
+		return fmt.Sprintf("%s%d:%d", cropAspectRatioPrefix, c.Ratio.Width, c.Ratio.Height)
+	}
+
+	unit := ""
+	if c.Percent {
+		unit = "%"
+	}
+
+	values := []float64{c.X, c.Y, c.Width, c.Height}
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.FormatFloat(value, 'f', -1, 64) + unit
+	}
+
+	return strings.Join(parts, ",")
+}
+
 // addParam adds a query parameter to a byte buffer
 func addParam(buf *bytes.Buffer, param string) {
 	if buf.Len() > 0 {
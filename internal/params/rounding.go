@@ -0,0 +1,68 @@
+package params
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rounding is how fractional pixel dimensions are turned into whole pixels
+type Rounding int
+
+const (
+	// Round rounds to the nearest pixel, with halves rounded away from zero
+	Round Rounding = iota
+	// Floor rounds down
+	Floor
+	// Ceil rounds up
+	Ceil
+)
+
+var roundingNames = map[Rounding]string{
+	Round: "round",
+	Floor: "floor",
+	Ceil:  "ceil",
+}
+
+// String returns the name of the rounding policy
+func (r Rounding) String() string {
+	return roundingNames[r]
+}
+
+// ParseRounding parses the name of a rounding policy, an empty name gives the default of rounding to the nearest pixel
+func ParseRounding(value string) (Rounding, error) {
+	if value == "" {
+		return Round, nil
+	}
+
+	for rounding, name := range roundingNames {
+		if name == value {
+			return rounding, nil
+		}
+	}
+
+	return Round, fmt.Errorf("invalid rounding %q", value)
+}
+
+// apply rounds a fractional dimension to whole pixels, never going below one pixel
+func (r Rounding) apply(value float64) int {
+	return int(math.Max(1, r.round(value)))
+}
+
+// round rounds a fractional position or dimension to whole pixels
+func (r Rounding) round(value float64) float64 {
+	// Snap values that are only off by floating point error, so that flooring 299.99999999999994 gives 300
+	if nearest := math.Round(value); math.Abs(value-nearest) < 1e-9 {
+		value = nearest
+	}
+
+	switch r {
+	case Floor:
+		value = math.Floor(value)
+	case Ceil:
+		value = math.Ceil(value)
+	default:
+		value = math.Round(value)
+	}
+
+	return value
+}
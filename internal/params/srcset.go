@@ -0,0 +1,110 @@
+package params
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DMarby/picsum-photos/internal/database"
+)
+
+// SrcSetParams contains the parameters for building a srcset
+// It either has widths for width descriptors, or a base width and dprs for density descriptors
+type SrcSetParams struct {
+	Widths    []int
+	Width     int
+	DPRs      []float64
+	Extension string
+}
+
+// HasDensities returns whether the srcset should use density descriptors, rather than width descriptors
+func (p *SrcSetParams) HasDensities() bool {
+	return len(p.DPRs) > 0
+}
+
+// GetSrcSetParams parses and returns the query parameters for a srcset
+func GetSrcSetParams(r *http.Request) (*SrcSetParams, error) {
+	var widths []int
+	var width int
+	var dprs []float64
+	var err error
+
+	if _, ok := r.URL.Query()["dprs"]; ok {
+		if _, ok := r.URL.Query()["widths"]; ok {
+			return nil, fmt.Errorf("%w: widths conflicts with dprs", ErrConflictingParams)
+		}
+
+		width, dprs, err = getDensities(r)
+	} else {
+		widths, err = getWidths(r)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The format is given without the dot, to match the rest of the query params
+	extension := "." + strings.ToLower(r.URL.Query().Get("fm"))
+	switch extension {
+	case ".":
+		extension = ".jpg"
+	case ".jpg", ".webp":
+	default:
+		return nil, ErrInvalidFileExtension
+	}
+
+	return &SrcSetParams{
+		Widths:    widths,
+		Width:     width,
+		DPRs:      dprs,
+		Extension: extension,
+	}, nil
+}
+
+// getWidths parses the comma separated list of widths from the query params, and validates them
+func getWidths(r *http.Request) (widths []int, err error) {
+	val := r.URL.Query().Get("widths")
+	if val == "" {
+		return nil, ErrInvalidWidths
+	}
+
+	for _, w := range strings.Split(val, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || width < 1 || width > maxImageSize {
+			return nil, ErrInvalidWidths
+		}
+
+		widths = append(widths, width)
+	}
+
+	return widths, nil
+}
+
+// getDensities parses the base width and the comma separated list of dprs from the query params, and validates them
+// The width scaled by each of the dprs also has to be within the max image size
+func getDensities(r *http.Request) (width int, dprs []float64, err error) {
+	width, err = strconv.Atoi(r.URL.Query().Get("w"))
+	if err != nil || width < 1 || width > maxImageSize {
+		return 0, nil, ErrInvalidWidths
+	}
+
+	for _, d := range strings.Split(r.URL.Query().Get("dprs"), ",") {
+		dpr, err := strconv.ParseFloat(strings.TrimSpace(d), 64)
+		if err != nil || math.IsNaN(dpr) || dpr < minDPR || dpr > maxDPR || math.Round(float64(width)*dpr) > maxImageSize {
+			return 0, nil, ErrInvalidDPRs
+		}
+
+		dprs = append(dprs, dpr)
+	}
+
+	return width, dprs, nil
+}
+
+// SrcSetHeight returns the height of the candidate of a width, keeping the aspect ratio of the image
+// It returns false when the height is over the max image size, as the image routes would reject the candidate
+func SrcSetHeight(width int, image *database.Image) (int, bool) {
+	height := int(math.Max(1, math.Round(float64(width)*float64(image.Height)/float64(image.Width))))
+	return height, height <= maxImageSize || height == image.Height
+}
@@ -0,0 +1,70 @@
+package phash
+
+import (
+	"fmt"
+	goimage "image"
+	"math/bits"
+	"strconv"
+)
+
+// The image is averaged into a grid of hashWidth by hashHeight cells, and each of the 64 bits compares a cell with the one to the right of it
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// Hash returns the difference hash of the image, which stays the same when the image is scaled or re-encoded, and changes in a few bits when it's slightly modified
+func Hash(img goimage.Image) uint64 {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0
+	}
+
+	var cells [hashHeight][hashWidth]float64
+	var counts [hashHeight][hashWidth]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cellY := (y - bounds.Min.Y) * hashHeight / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cellX := (x - bounds.Min.X) * hashWidth / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			cells[cellY][cellX] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cellY][cellX]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if average(cells[y][x], counts[y][x]) < average(cells[y][x+1], counts[y][x+1]) {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// average returns the average luminance of a cell, as images smaller than the grid leave cells empty
+func average(sum float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+
+	return sum / float64(count)
+}
+
+// Format returns the hash as a hex string
+func Format(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Parse parses a hash formatted as a hex string
+func Parse(value string) (uint64, error) {
+	return strconv.ParseUint(value, 16, 64)
+}
+
+// Distance returns the amount of bits that differ between the hashes, where a low distance means that the images are near duplicates
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,85 @@
+package phash_test
+
+import (
+	goimage "image"
+	"image/color"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/phash"
+)
+
+// gradient returns an image that goes from black on the left to white on the right, with a white square in the top left
+func gradient(width, height int) *goimage.RGBA {
+	img := goimage.NewRGBA(goimage.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8(x * 255 / width)
+			if x < width/4 && y < height/4 {
+				value = 255
+			}
+
+			img.Set(x, y, color.RGBA{value, value, value, 255})
+		}
+	}
+
+	return img
+}
+
+func TestHash(t *testing.T) {
+	hash := phash.Hash(gradient(90, 80))
+
+	t.Run("identical images hash equal", func(t *testing.T) {
+		if other := phash.Hash(gradient(90, 80)); other != hash {
+			t.Errorf("wrong hash %s", phash.Format(other))
+		}
+	})
+
+	t.Run("a scaled image hashes equal", func(t *testing.T) {
+		if other := phash.Hash(gradient(180, 160)); other != hash {
+			t.Errorf("wrong hash %s", phash.Format(other))
+		}
+	})
+
+	t.Run("a modified image differs by a small distance", func(t *testing.T) {
+		modified := gradient(90, 80)
+		for y := 60; y < 80; y++ {
+			for x := 10; x < 20; x++ {
+				modified.Set(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+
+		distance := phash.Distance(hash, phash.Hash(modified))
+		if distance == 0 || distance > 8 {
+			t.Errorf("wrong distance %d", distance)
+		}
+	})
+
+	t.Run("a different image differs by a large distance", func(t *testing.T) {
+		flipped := goimage.NewRGBA(goimage.Rect(0, 0, 90, 80))
+		original := gradient(90, 80)
+		for y := 0; y < 80; y++ {
+			for x := 0; x < 90; x++ {
+				flipped.Set(89-x, y, original.At(x, y))
+			}
+		}
+
+		if distance := phash.Distance(hash, phash.Hash(flipped)); distance < 32 {
+			t.Errorf("wrong distance %d", distance)
+		}
+	})
+}
+
+func TestFormat(t *testing.T) {
+	hash, err := phash.Parse(phash.Format(0x00ff00ff00ff00ff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != 0x00ff00ff00ff00ff {
+		t.Errorf("wrong hash %x", hash)
+	}
+
+	if formatted := phash.Format(1); formatted != "0000000000000001" {
+		t.Errorf("wrong formatted hash %s", formatted)
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the windows that have expired are dropped
+const sweepInterval = time.Minute
+
+// window is the count of requests of a client within a window
+type window struct {
+	count int
+	reset time.Time
+}
+
+// Provider implements counting requests in memory, which only limits the requests to a single instance
+type Provider struct {
+	windows   map[string]*window
+	nextSweep time.Time
+	mutex     sync.Mutex
+}
+
+// New returns a new Provider instance
+func New() *Provider {
+	return &Provider{
+		windows: make(map[string]*window),
+	}
+}
+
+// Increment counts a request for the key, starting a new window if the previous one has expired
+func (p *Provider) Increment(key string, now time.Time, reset time.Time) (count int, windowReset time.Time, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Drop the expired windows every now and then, so that clients that stopped making requests don't use memory forever
+	if !now.Before(p.nextSweep) {
+		for key, w := range p.windows {
+			if !now.Before(w.reset) {
+				delete(p.windows, key)
+			}
+		}
+
+		p.nextSweep = now.Add(sweepInterval)
+	}
+
+	w, exists := p.windows[key]
+	if !exists || !now.Before(w.reset) {
+		w = &window{reset: reset}
+		p.windows[key] = w
+	}
+
+	w.count++
+	return w.count, w.reset, nil
+}
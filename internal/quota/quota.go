@@ -0,0 +1,105 @@
+package quota
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Provider is an interface for counting the requests of clients within windows of time
+type Provider interface {
+	// Increment counts a request for the key, starting a new window that resets at reset if the previous one has expired
+	// It returns the count within the window, and when the window resets
+	Increment(key string, now time.Time, reset time.Time) (count int, windowReset time.Time, err error)
+}
+
+// Quota limits how many requests each client can make within a window, such as a day
+// Windows either start at fixed times, which is at midnight UTC for a window of a day, or at the first request of the client when Rolling is set
+// Clients are identified by the ip the request came from, or by the one that the TrustedProxies forwarded it for
+type Quota struct {
+	Limit          int
+	Window         time.Duration
+	Rolling        bool
+	Exempt         []*net.IPNet
+	TrustedProxies []*net.IPNet
+	Provider       Provider
+}
+
+// Take counts a request from the client, and returns how many requests the client has left and when the window resets
+// The client is over the quota once it has made more requests than the limit within the window, which is when ok is false
+func (q *Quota) Take(client string, now time.Time) (remaining int, reset time.Time, ok bool, err error) {
+	next := now.Add(q.Window)
+	if !q.Rolling {
+		// Truncate is relative to the zero time, so windows of whole days start at midnight UTC
+		next = now.Truncate(q.Window).Add(q.Window)
+	}
+
+	count, reset, err := q.Provider.Increment(client, now, next)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+
+	remaining = q.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, reset, count <= q.Limit, nil
+}
+
+// Exempted returns whether the ip is in one of the networks of trusted clients, which aren't limited by the quota
+func (q *Quota) Exempted(ip net.IP) bool {
+	return containsIP(q.Exempt, ip)
+}
+
+// TrustedProxy returns whether the ip is in one of the networks of the proxies, which the forwarded ips of the clients are trusted from
+func (q *Quota) TrustedProxy(ip net.IP) bool {
+	return containsIP(q.TrustedProxies, ip)
+}
+
+// containsIP returns whether the ip is in one of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseNetworks parses a comma separated list of networks, such as "10.0.0.0/8,192.0.2.1"
+// IPs without a prefix length are networks of just that ip
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, network := range strings.Split(value, ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %s", network)
+			}
+
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", network)
+		}
+
+		networks = append(networks, ipNet)
+	}
+
+	return networks, nil
+}
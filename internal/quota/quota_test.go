@@ -0,0 +1,103 @@
+package quota_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/quota"
+	"github.com/DMarby/picsum-photos/internal/quota/memory"
+)
+
+func TestQuota(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("exhausts the quota", func(t *testing.T) {
+		q := &quota.Quota{Limit: 2, Window: 24 * time.Hour, Provider: memory.New()}
+
+		expected := []struct {
+			Remaining int
+			OK        bool
+		}{{1, true}, {0, true}, {0, false}, {0, false}}
+
+		for i, test := range expected {
+			remaining, _, ok, err := q.Take("192.0.2.1", start)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if remaining != test.Remaining || ok != test.OK {
+				t.Errorf("request %d: wrong result %d %t", i, remaining, ok)
+			}
+		}
+
+		// Other clients have their own quota
+		if _, _, ok, _ := q.Take("192.0.2.2", start); !ok {
+			t.Error("another client is over the quota")
+		}
+	})
+
+	t.Run("resets at midnight utc", func(t *testing.T) {
+		q := &quota.Quota{Limit: 1, Window: 24 * time.Hour, Provider: memory.New()}
+
+		_, reset, _, _ := q.Take("192.0.2.1", start)
+		if expected := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC); !reset.Equal(expected) {
+			t.Errorf("wrong reset %s", reset)
+		}
+
+		if _, _, ok, _ := q.Take("192.0.2.1", start.Add(11*time.Hour)); ok {
+			t.Error("not over the quota before midnight")
+		}
+
+		if remaining, _, ok, _ := q.Take("192.0.2.1", start.Add(12*time.Hour)); !ok || remaining != 0 {
+			t.Errorf("didn't reset at midnight, %d %t", remaining, ok)
+		}
+	})
+
+	t.Run("resets a day after the first request when rolling", func(t *testing.T) {
+		q := &quota.Quota{Limit: 1, Window: 24 * time.Hour, Rolling: true, Provider: memory.New()}
+
+		_, reset, _, _ := q.Take("192.0.2.1", start)
+		if expected := start.Add(24 * time.Hour); !reset.Equal(expected) {
+			t.Errorf("wrong reset %s", reset)
+		}
+
+		if _, _, ok, _ := q.Take("192.0.2.1", start.Add(23*time.Hour)); ok {
+			t.Error("reset before the end of the window")
+		}
+
+		if _, _, ok, _ := q.Take("192.0.2.1", start.Add(24*time.Hour)); !ok {
+			t.Error("didn't reset at the end of the window")
+		}
+	})
+
+	t.Run("exempts trusted networks", func(t *testing.T) {
+		networks, err := quota.ParseNetworks("10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := &quota.Quota{Exempt: networks}
+		tests := map[string]bool{
+			"10.1.2.3":    true,
+			"192.0.2.1":   true,
+			"192.0.2.2":   false,
+			"2001:db8::1": true,
+			"2001:db9::1": false,
+		}
+
+		for ip, expected := range tests {
+			if exempted := q.Exempted(net.ParseIP(ip)); exempted != expected {
+				t.Errorf("%s: wrong result %t", ip, exempted)
+			}
+		}
+	})
+
+	t.Run("rejects invalid networks", func(t *testing.T) {
+		for _, value := range []string{"10.0.0.0/33", "not an ip", "192.0.2.256"} {
+			if _, err := quota.ParseNetworks(value); err == nil {
+				t.Errorf("%s: parsed", value)
+			}
+		}
+	})
+}
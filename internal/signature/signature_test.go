@@ -0,0 +1,73 @@
+package signature_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/signature"
+)
+
+func TestSigner(t *testing.T) {
+	signer := &signature.Signer{Key: []byte("secret")}
+	query := url.Values{"grayscale": {""}, "blur": {"2"}}
+	sig := signer.Sign("/id/1/200/300.jpg", query)
+
+	t.Run("validates the signature", func(t *testing.T) {
+		if !signer.Valid(sig, "/id/1/200/300.jpg", query) {
+			t.Error("valid signature is invalid")
+		}
+	})
+
+	t.Run("doesn't depend on the order of the query", func(t *testing.T) {
+		reordered, _ := url.ParseQuery("blur=2&grayscale")
+		if !signer.Valid(sig, "/id/1/200/300.jpg", reordered) {
+			t.Error("reordered query is invalid")
+		}
+	})
+
+	tests := []struct {
+		Name  string
+		Path  string
+		Query url.Values
+	}{
+		{"tampered path", "/id/2/200/300.jpg", query},
+		{"tampered query", "/id/1/200/300.jpg", url.Values{"grayscale": {""}, "blur": {"3"}}},
+		{"added query param", "/id/1/200/300.jpg", url.Values{"grayscale": {""}, "blur": {"2"}, "sharpen": {""}}},
+		{"missing query", "/id/1/200/300.jpg", url.Values{}},
+	}
+
+	for _, test := range tests {
+		if signer.Valid(sig, test.Path, test.Query) {
+			t.Errorf("%s: signature is valid", test.Name)
+		}
+	}
+
+	t.Run("depends on the key", func(t *testing.T) {
+		other := &signature.Signer{Key: []byte("other")}
+		if other.Valid(sig, "/id/1/200/300.jpg", query) {
+			t.Error("signature is valid with another key")
+		}
+	})
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		Path              string
+		ExpectedSignature string
+		ExpectedPath      string
+		ExpectedOK        bool
+	}{
+		{"/s/abc/id/1/200/300.jpg", "abc", "/id/1/200/300.jpg", true},
+		{"/s/abc/t/acme/id/1/200/300.jpg", "abc", "/t/acme/id/1/200/300.jpg", true},
+		{"/id/1/200/300.jpg", "", "", false},
+		{"/s//id/1/200/300.jpg", "", "", false},
+		{"/s/abc", "", "", false},
+	}
+
+	for _, test := range tests {
+		sig, path, ok := signature.SplitPath(test.Path)
+		if sig != test.ExpectedSignature || path != test.ExpectedPath || ok != test.ExpectedOK {
+			t.Errorf("%s: wrong result %s %s %t", test.Path, sig, path, ok)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Provider implements a file-based image storage
@@ -26,10 +27,50 @@ func New(path string) (*Provider, error) {
 
 // Get returns the image data for an image id
 func (p *Provider) Get(ctx context.Context, id string) ([]byte, error) {
-	imageData, err := ioutil.ReadFile(filepath.Join(p.path, fmt.Sprintf("%s.jpg", id)))
+	imageData, err := ioutil.ReadFile(p.imagePath(id))
 	if err != nil {
 		return nil, err
 	}
 
 	return imageData, nil
 }
+
+// Put stores the image data for an image id
+// The data is written to a temporary file that's renamed into place, so that a partially written image is never read
+func (p *Provider) Put(ctx context.Context, id string, data []byte) error {
+	path := p.imagePath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// ModTime returns when the image for an image id was last modified
+func (p *Provider) ModTime(ctx context.Context, id string) (time.Time, error) {
+	info, err := os.Stat(p.imagePath(id))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func (p *Provider) imagePath(id string) string {
+	return filepath.Join(p.path, fmt.Sprintf("%s.jpg", id))
+}
@@ -3,6 +3,7 @@ package file_test
 import (
 	"context"
 	"io/ioutil"
+	"os"
 	"reflect"
 
 	"github.com/DMarby/picsum-photos/internal/storage/file"
@@ -28,6 +29,47 @@ func TestFile(t *testing.T) {
 		}
 	})
 
+	t.Run("Get the modification time of an image by id", func(t *testing.T) {
+		modTime, err := provider.ModTime(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, _ := os.Stat("../../../test/fixtures/file/1.jpg")
+		if !modTime.Equal(info.ModTime()) {
+			t.Errorf("wrong modification time %s", modTime)
+		}
+	})
+
+	t.Run("Returns error for the modification time of a nonexistant image", func(t *testing.T) {
+		_, err := provider.ModTime(context.Background(), "nonexistant")
+		if err == nil {
+			t.FailNow()
+		}
+	})
+
+	t.Run("Put an image by id", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writable, _ := file.New(dir)
+		if err := writable.Put(context.Background(), "acme/1", []byte("image")); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := writable.Get(context.Background(), "acme/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf) != "image" {
+			t.Errorf("wrong image data %s", buf)
+		}
+	})
+
 	t.Run("Returns error on a nonexistant path", func(t *testing.T) {
 		_, err := file.New("")
 		if err == nil {
@@ -0,0 +1,45 @@
+package modtime
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache"
+	"github.com/DMarby/picsum-photos/internal/storage"
+)
+
+// Provider wraps a storage provider that reports when images were modified, and caches the modification times
+// so that conditional requests don't ask the storage for each of the source images of every request
+// Errors aren't cached, and the modification times are only as current as the expiry of the cache
+type Provider struct {
+	provider storage.ModTimeProvider
+	cache    cache.Provider
+}
+
+// New returns a new Provider instance
+func New(provider storage.ModTimeProvider, cache cache.Provider) *Provider {
+	return &Provider{
+		provider: provider,
+		cache:    cache,
+	}
+}
+
+// ModTime returns when the image for an image id was last modified, from the cache when it's there
+func (p *Provider) ModTime(ctx context.Context, id string) (time.Time, error) {
+	key := "modtime:" + id
+	if data, err := p.cache.Get(key); err == nil {
+		if nanoseconds, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+			return time.Unix(0, nanoseconds), nil
+		}
+	}
+
+	modTime, err := p.provider.ModTime(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Failing to cache the modification time only means that it's asked for again
+	p.cache.Set(key, []byte(strconv.FormatInt(modTime.UnixNano(), 10)))
+	return modTime, nil
+}
@@ -0,0 +1,65 @@
+package modtime_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/cache/lru"
+	"github.com/DMarby/picsum-photos/internal/storage/modtime"
+)
+
+// countingProvider reports the modification times of the images, and counts how often it's asked
+type countingProvider struct {
+	modTimes map[string]time.Time
+	calls    int
+}
+
+func (p *countingProvider) ModTime(ctx context.Context, id string) (time.Time, error) {
+	p.calls++
+	modTime, ok := p.modTimes[id]
+	if !ok {
+		return time.Time{}, errors.New("not found")
+	}
+
+	return modTime, nil
+}
+
+func TestModTime(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	storage := &countingProvider{modTimes: map[string]time.Time{"1": modTime}}
+	provider := modtime.New(storage, lru.New(1024, 10*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		cached, err := provider.ModTime(context.Background(), "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !cached.Equal(modTime) {
+			t.Errorf("wrong modification time %s", cached)
+		}
+	}
+
+	if storage.calls != 1 {
+		t.Errorf("asked the storage %d times", storage.calls)
+	}
+
+	// The modification time is asked for again once it expires
+	time.Sleep(20 * time.Millisecond)
+	if _, err := provider.ModTime(context.Background(), "1"); err != nil || storage.calls != 2 {
+		t.Errorf("didn't ask the storage again, %d times, %v", storage.calls, err)
+	}
+
+	// Errors aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := provider.ModTime(context.Background(), "2"); err == nil {
+			t.Error("no error for a missing image")
+		}
+	}
+
+	if storage.calls != 4 {
+		t.Errorf("cached an error, asked the storage %d times", storage.calls)
+	}
+}
@@ -0,0 +1,69 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/storage"
+)
+
+// Provider wraps a storage provider, and retries getting images when it fails with a transient error
+type Provider struct {
+	provider    storage.Provider
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// New returns a new Provider instance
+// Each image is attempted up to maxAttempts times, waiting backoff before the first retry and doubling it for each one after
+func New(provider storage.Provider, maxAttempts int, backoff time.Duration) *Provider {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &Provider{
+		provider:    provider,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Get returns the image data for an image id
+// It stops retrying early when the request is cancelled, or when waiting for the next attempt would pass its deadline
+func (p *Provider) Get(ctx context.Context, id string) ([]byte, error) {
+	backoff := p.backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		data, err = p.provider.Get(ctx, id)
+		if err == nil || !retryable(err) || attempt >= p.maxAttempts {
+			return data, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+// retryable returns whether an error might go away when trying again
+// Missing images won't appear by retrying, and cancelled requests have no one left waiting for them
+func retryable(err error) bool {
+	return !errors.Is(err, storage.ErrNotFound) &&
+		!errors.Is(err, os.ErrNotExist) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,111 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DMarby/picsum-photos/internal/storage"
+	"github.com/DMarby/picsum-photos/internal/storage/retry"
+)
+
+// flakyProvider fails until the successfulAttempt, with err
+type flakyProvider struct {
+	successfulAttempt int
+	err               error
+	attempts          int
+}
+
+func (p *flakyProvider) Get(ctx context.Context, id string) ([]byte, error) {
+	p.attempts++
+	if p.attempts < p.successfulAttempt {
+		return nil, p.err
+	}
+
+	return []byte(id), nil
+}
+
+var errThrottled = fmt.Errorf("SlowDown: please reduce your request rate")
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		Name              string
+		SuccessfulAttempt int
+		Err               error
+		MaxAttempts       int
+		ExpectedAttempts  int
+		ExpectedErr       error
+	}{
+		{"succeeds on the first attempt", 1, errThrottled, 3, 1, nil},
+		{"succeeds on the third attempt", 3, errThrottled, 3, 3, nil},
+		{"gives up after the max attempts", 4, errThrottled, 3, 3, errThrottled},
+		{"no retries with one attempt", 2, errThrottled, 1, 1, errThrottled},
+		{"missing images fail fast", 2, storage.ErrNotFound, 3, 1, storage.ErrNotFound},
+	}
+
+	for _, test := range tests {
+		flaky := &flakyProvider{successfulAttempt: test.SuccessfulAttempt, err: test.Err}
+		provider := retry.New(flaky, test.MaxAttempts, time.Millisecond)
+
+		data, err := provider.Get(context.Background(), "1")
+		if flaky.attempts != test.ExpectedAttempts {
+			t.Errorf("%s: wrong attempts %d", test.Name, flaky.attempts)
+		}
+
+		if !errors.Is(err, test.ExpectedErr) {
+			t.Errorf("%s: wrong error %#v", test.Name, err)
+		}
+
+		if err == nil && string(data) != "1" {
+			t.Errorf("%s: wrong data %s", test.Name, data)
+		}
+	}
+
+	t.Run("backs off exponentially", func(t *testing.T) {
+		flaky := &flakyProvider{successfulAttempt: 4, err: errThrottled}
+		provider := retry.New(flaky, 4, 10*time.Millisecond)
+
+		start := time.Now()
+		if _, err := provider.Get(context.Background(), "1"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 10ms, 20ms and 40ms between the attempts
+		if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+			t.Errorf("didn't back off, took %s", elapsed)
+		}
+	})
+
+	t.Run("stops at the request deadline", func(t *testing.T) {
+		flaky := &flakyProvider{successfulAttempt: 3, err: errThrottled}
+		provider := retry.New(flaky, 3, time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		start := time.Now()
+		_, err := provider.Get(ctx, "1")
+		if !errors.Is(err, errThrottled) || flaky.attempts != 1 {
+			t.Errorf("wrong error %#v after %d attempts", err, flaky.attempts)
+		}
+
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("waited for the backoff past the deadline, took %s", elapsed)
+		}
+	})
+
+	t.Run("stops when the request is cancelled", func(t *testing.T) {
+		flaky := &flakyProvider{successfulAttempt: 3, err: errThrottled}
+		provider := retry.New(flaky, 3, time.Minute)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		_, err := provider.Get(ctx, "1")
+		if !errors.Is(err, errThrottled) || flaky.attempts != 1 {
+			t.Errorf("wrong error %#v after %d attempts", err, flaky.attempts)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/DMarby/picsum-photos/internal/storage"
 	"github.com/aws/aws-sdk-go/aws"
@@ -72,3 +73,26 @@ func (p *Provider) Get(ctx context.Context, id string) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// ModTime returns when the image for an image id was last modified
+func (p *Provider) ModTime(ctx context.Context, id string) (time.Time, error) {
+	object := s3.HeadObjectInput{
+		Bucket: &p.space,
+		Key:    aws.String(fmt.Sprintf("%s.jpg", id)),
+	}
+
+	output, err := p.spaces.HeadObjectWithContext(ctx, &object)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return time.Time{}, storage.ErrNotFound
+		}
+
+		return time.Time{}, err
+	}
+
+	if output.LastModified == nil {
+		return time.Time{}, fmt.Errorf("no last modified time for image %s", id)
+	}
+
+	return *output.LastModified, nil
+}
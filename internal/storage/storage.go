@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 // Provider is an interface for retrieving images
@@ -10,6 +11,16 @@ type Provider interface {
 	Get(ctx context.Context, id string) ([]byte, error)
 }
 
+// ModTimeProvider is implemented by providers that can report when an image was last modified
+type ModTimeProvider interface {
+	ModTime(ctx context.Context, id string) (time.Time, error)
+}
+
+// Writer is implemented by providers that images can be stored in, such as the faster tiers of a tiered storage
+type Writer interface {
+	Put(ctx context.Context, id string, data []byte) error
+}
+
 // Errors
 var (
 	ErrNotFound = errors.New("Image does not exist")
@@ -0,0 +1,91 @@
+package tiered
+
+import (
+	"context"
+	"sync"
+
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/storage"
+)
+
+// maxPendingWrites is how many images can be waiting to be written back at once, further images aren't written back until there's room
+const maxPendingWrites = 16
+
+// Provider tries a list of storage providers in order, such as a local copy of the popular images in front of the full set of images
+type Provider struct {
+	log       *logger.Logger
+	tiers     []storage.Provider
+	writeBack bool
+	pending   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New returns a new Provider instance
+// When writeBack is set, images that are found in a later tier are written to the earlier tiers that support it, in the background
+func New(log *logger.Logger, tiers []storage.Provider, writeBack bool) *Provider {
+	return &Provider{
+		log:       log,
+		tiers:     tiers,
+		writeBack: writeBack,
+		pending:   make(chan struct{}, maxPendingWrites),
+	}
+}
+
+// Get returns the image data for an image id from the first tier that has it
+// The error from the last tier is returned when none of them have it
+func (p *Provider) Get(ctx context.Context, id string) ([]byte, error) {
+	var err error
+	for i, tier := range p.tiers {
+		var data []byte
+		data, err = tier.Get(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if p.writeBack && i > 0 {
+			p.put(id, data, p.tiers[:i])
+		}
+
+		return data, nil
+	}
+
+	return nil, err
+}
+
+// put writes the image back to the given tiers in the background, without holding up the request
+// The image is skipped when too many are already waiting to be written
+func (p *Provider) put(id string, data []byte, tiers []storage.Provider) {
+	select {
+	case p.pending <- struct{}{}:
+	default:
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.pending
+			p.wg.Done()
+		}()
+
+		for _, tier := range tiers {
+			writer, ok := tier.(storage.Writer)
+			if !ok {
+				continue
+			}
+
+			if err := writer.Put(context.Background(), id, data); err != nil {
+				p.log.Errorw("error writing image back to storage", "image-id", id, "error", err)
+			}
+		}
+	}()
+}
+
+// Wait waits for the images that are being written back, for shutting down without losing them
+func (p *Provider) Wait() {
+	p.wg.Wait()
+}
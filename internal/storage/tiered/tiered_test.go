@@ -0,0 +1,164 @@
+package tiered_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/DMarby/picsum-photos/internal/logger"
+	"github.com/DMarby/picsum-photos/internal/storage"
+	"github.com/DMarby/picsum-photos/internal/storage/tiered"
+	"go.uber.org/zap"
+)
+
+// memoryProvider stores images in memory, and records the ids it was asked for
+type memoryProvider struct {
+	mutex  sync.Mutex
+	images map[string][]byte
+	gets   []string
+}
+
+func (p *memoryProvider) Get(ctx context.Context, id string) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.gets = append(p.gets, id)
+	data, ok := p.images[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+
+	return data, nil
+}
+
+func (p *memoryProvider) Put(ctx context.Context, id string, data []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.images[id] = data
+	return nil
+}
+
+// readOnlyProvider can't be written back to
+type readOnlyProvider struct {
+	provider *memoryProvider
+}
+
+func (p *readOnlyProvider) Get(ctx context.Context, id string) ([]byte, error) {
+	return p.provider.Get(ctx, id)
+}
+
+func TestTiered(t *testing.T) {
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	setup := func(writeBack bool) (*tiered.Provider, *memoryProvider, *memoryProvider) {
+		hot := &memoryProvider{images: map[string][]byte{"1": []byte("hot 1")}}
+		cold := &memoryProvider{images: map[string][]byte{"1": []byte("cold 1"), "2": []byte("cold 2")}}
+		return tiered.New(log, []storage.Provider{hot, cold}, writeBack), hot, cold
+	}
+
+	t.Run("uses the first tier that has the image", func(t *testing.T) {
+		provider, hot, cold := setup(false)
+
+		data, err := provider.Get(context.Background(), "1")
+		if err != nil || string(data) != "hot 1" {
+			t.Errorf("wrong image %s, %#v", data, err)
+		}
+
+		if !reflect.DeepEqual(hot.gets, []string{"1"}) || len(cold.gets) != 0 {
+			t.Errorf("wrong tiers tried, %#v and %#v", hot.gets, cold.gets)
+		}
+	})
+
+	t.Run("falls back to the next tier", func(t *testing.T) {
+		provider, hot, cold := setup(false)
+
+		data, err := provider.Get(context.Background(), "2")
+		if err != nil || string(data) != "cold 2" {
+			t.Errorf("wrong image %s, %#v", data, err)
+		}
+
+		if !reflect.DeepEqual(hot.gets, []string{"2"}) || !reflect.DeepEqual(cold.gets, []string{"2"}) {
+			t.Errorf("wrong tiers tried, %#v and %#v", hot.gets, cold.gets)
+		}
+
+		// Without write back, the image stays in the later tier only
+		provider.Wait()
+		if _, ok := hot.images["2"]; ok {
+			t.Error("wrote the image back")
+		}
+	})
+
+	t.Run("returns the error of the last tier", func(t *testing.T) {
+		provider, _, _ := setup(true)
+
+		if _, err := provider.Get(context.Background(), "3"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("wrong error %#v", err)
+		}
+	})
+
+	t.Run("writes the image back to the earlier tiers", func(t *testing.T) {
+		provider, hot, cold := setup(true)
+
+		if _, err := provider.Get(context.Background(), "2"); err != nil {
+			t.Fatal(err)
+		}
+		provider.Wait()
+
+		if string(hot.images["2"]) != "cold 2" {
+			t.Fatalf("didn't write the image back, %#v", hot.images)
+		}
+
+		// The next request is served by the first tier
+		data, err := provider.Get(context.Background(), "2")
+		if err != nil || string(data) != "cold 2" {
+			t.Errorf("wrong image %s, %#v", data, err)
+		}
+
+		if len(cold.gets) != 1 {
+			t.Errorf("got the image from the last tier %d times", len(cold.gets))
+		}
+	})
+
+	t.Run("skips tiers that can't be written to", func(t *testing.T) {
+		hot := &memoryProvider{images: map[string][]byte{}}
+		warm := &memoryProvider{images: map[string][]byte{}}
+		cold := &memoryProvider{images: map[string][]byte{"1": []byte("cold 1")}}
+		provider := tiered.New(log, []storage.Provider{hot, &readOnlyProvider{warm}, cold}, true)
+
+		if _, err := provider.Get(context.Background(), "1"); err != nil {
+			t.Fatal(err)
+		}
+		provider.Wait()
+
+		if string(hot.images["1"]) != "cold 1" || len(warm.images) != 0 {
+			t.Errorf("wrong images written back, %#v and %#v", hot.images, warm.images)
+		}
+	})
+
+	t.Run("stops when the request is cancelled", func(t *testing.T) {
+		cold := &memoryProvider{images: map[string][]byte{"1": []byte("cold 1")}}
+		provider := tiered.New(log, []storage.Provider{&cancelledProvider{}, cold}, false)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := provider.Get(ctx, "1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("wrong error %#v", err)
+		}
+
+		if len(cold.gets) != 0 {
+			t.Errorf("tried the next tier")
+		}
+	})
+}
+
+// cancelledProvider fails with the error of the context
+type cancelledProvider struct{}
+
+func (p *cancelledProvider) Get(ctx context.Context, id string) ([]byte, error) {
+	return nil, ctx.Err()
+}
@@ -8,24 +8,1178 @@ void log_handler(char const* log_domain, GLogLevelFlags log_level, char const* m
   log_callback((char*)message);
 }
 
-int save_image_to_jpeg_buffer(VipsImage *image, void **buf, size_t *len) {
-  return vips_jpegsave_buffer(image, buf, len, "interlace", TRUE, "optimize_coding", TRUE, NULL);
+int save_image_to_jpeg_buffer(VipsImage *image, void **buf, size_t *len, int quality, gboolean optimize_coding, gboolean interlace) {
+  return vips_jpegsave_buffer(image, buf, len, "Q", quality, "interlace", interlace, "optimize_coding", optimize_coding, NULL);
 }
 
-int save_image_to_webp_buffer(VipsImage *image, void **buf, size_t *len) {
-  return vips_webpsave_buffer(image, buf, len, NULL);
+int save_image_to_webp_buffer(VipsImage *image, void **buf, size_t *len, int quality, int effort) {
+// reduction_effort is only available from libvips 8.8
+#if (VIPS_MINOR_VERSION >= 8)
+  return vips_webpsave_buffer(image, buf, len, "Q", quality, "reduction_effort", effort, NULL);
+#else
+  return vips_webpsave_buffer(image, buf, len, "Q", quality, NULL);
+#endif
 }
 
-int resize_image(void *buf, size_t len, VipsImage **out, int width, int height, VipsInteresting interesting) {
-  return vips_thumbnail_buffer(buf, len, out, width, "height", height, "crop", interesting, NULL);
+int save_image_to_lossless_webp_buffer(VipsImage *image, void **buf, size_t *len, int level, int effort) {
+  // In near lossless mode, Q is the amount of preprocessing, where 100 is none
+  gboolean near_lossless = level < 100;
+
+#if (VIPS_MINOR_VERSION >= 8)
+  return vips_webpsave_buffer(image, buf, len, "lossless", TRUE, "near_lossless", near_lossless, "Q", level, "reduction_effort", effort, NULL);
+#else
+  return vips_webpsave_buffer(image, buf, len, "lossless", TRUE, "near_lossless", near_lossless, "Q", level, NULL);
+#endif
+}
+
+int save_image_to_raw_buffer(VipsImage *in, void **buf, size_t *len, int bands) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  // Convert to black and white for a single band, and sRGB otherwise, then add or remove the alpha channel to get the number of bands
+  VipsInterpretation interpretation = bands == 1 ? VIPS_INTERPRETATION_B_W : VIPS_INTERPRETATION_sRGB;
+  if (vips_colourspace(in, &t[0], interpretation, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  int err;
+  if (bands == 4 && !vips_image_hasalpha(t[0])) {
+    err = vips_bandjoin_const1(t[0], &t[1], 255, NULL);
+  } else {
+    err = vips_extract_band(t[0], &t[1], 0, "n", bands, NULL);
+  }
+
+  if (err || vips_cast_uchar(t[1], &t[2], NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  // The pixels are written interleaved, row by row from the top left
+  *buf = vips_image_write_to_memory(t[2], len);
+  g_object_unref(base);
+
+  return *buf ? 0 : -1;
+}
+
+int has_loader(char const* name) {
+  return vips_type_find("VipsOperation", name) != 0;
+}
+
+int get_image_size(void *buf, size_t len, int *width, int *height) {
+  // Loading from a buffer only reads the header, the pixels are decoded when they're used
+  VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+  if (!image) {
+    return -1;
+  }
+
+  *width = vips_image_get_width(image);
+  *height = vips_image_get_height(image);
+
+  g_object_unref(image);
+  return 0;
+}
+
+int get_image_frames(void *buf, size_t len, int *frames) {
+  VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+  if (!image) {
+    return -1;
+  }
+
+  // Only loaders for formats with multiple pages or frames set n-pages
+  *frames = 1;
+  if (vips_image_get_typeof(image, VIPS_META_N_PAGES)) {
+    vips_image_get_int(image, VIPS_META_N_PAGES, frames);
+  }
+
+  g_object_unref(image);
+  return 0;
+}
+
+// jpeg_shrink_factor returns the largest shrink-on-load factor for a JPEG image that still decodes it at or above the requested size
+// The image may be rotated after loading, so the smallest side has to fit the largest requested side
+static int jpeg_shrink_factor(VipsImage *image, int width, int height) {
+  int side = VIPS_MIN(image->Xsize, image->Ysize);
+  int target = VIPS_MAX(width, height);
+  int shrink = 1;
+
+  while (shrink < 8 && side / (shrink * 2) >= target) {
+    shrink *= 2;
+  }
+
+  return shrink;
+}
+
+// background_bands fills background with one value per band of the image for the given color, and returns the number of bands
+// Grayscale images use the luminance of the color, and the alpha channel is set to opaque
+static int background_bands(VipsImage *in, double r, double g, double b, double *background) {
+  int n = 0;
+
+  if (in->Bands - vips_image_hasalpha(in) < 3) {
+    background[n++] = 0.2126 * r + 0.7152 * g + 0.0722 * b;
+  } else {
+    background[n++] = r;
+    background[n++] = g;
+    background[n++] = b;
+  }
+
+  if (vips_image_hasalpha(in)) {
+    background[n++] = 255;
+  }
+
+  return n;
+}
+
+// trim_image crops off the borders of an image that are within threshold of the given color
+// When use_color is false, the color of the top left pixel is used instead
+static int trim_image(VipsImage *in, VipsImage **out, int use_color, double r, double g, double b, double threshold) {
+  VipsArrayDouble *arr;
+
+  if (use_color) {
+    double background[4];
+    int n = background_bands(in, r, g, b, background);
+    arr = vips_array_double_new(background, n);
+  } else {
+    double *corner;
+    int n;
+
+    if (vips_getpoint(in, &corner, &n, 0, 0, NULL)) {
+      return -1;
+    }
+
+    arr = vips_array_double_new(corner, n);
+    g_free(corner);
+  }
+
+  int left, top, width, height;
+  int result = vips_find_trim(in, &left, &top, &width, &height, "background", arr, "threshold", threshold, NULL);
+  vips_area_unref(VIPS_AREA(arr));
+
+  if (result) {
+    return -1;
+  }
+
+  // Nothing is left when the whole image matches the color, so keep it as is
+  if (width == 0 || height == 0) {
+    return vips_copy(in, out, NULL);
+  }
+
+  return vips_extract_area(in, out, left, top, width, height, NULL);
+}
+
+// orient_image rotates and flips an image as described by an EXIF orientation (1-8), so that it displays upright
+static int orient_image(VipsImage *in, VipsImage **out, int orientation) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+  int result;
+
+  switch (orientation) {
+  case 2:
+    result = vips_flip(in, out, VIPS_DIRECTION_HORIZONTAL, NULL);
+    break;
+  case 3:
+    result = vips_rot(in, out, VIPS_ANGLE_D180, NULL);
+    break;
+  case 4:
+    result = vips_flip(in, out, VIPS_DIRECTION_VERTICAL, NULL);
+    break;
+  case 5:
+    result = vips_rot(in, &t[0], VIPS_ANGLE_D90, NULL) || vips_flip(t[0], out, VIPS_DIRECTION_HORIZONTAL, NULL);
+    break;
+  case 6:
+    result = vips_rot(in, out, VIPS_ANGLE_D90, NULL);
+    break;
+  case 7:
+    result = vips_rot(in, &t[0], VIPS_ANGLE_D90, NULL) || vips_flip(t[0], out, VIPS_DIRECTION_VERTICAL, NULL);
+    break;
+  case 8:
+    result = vips_rot(in, out, VIPS_ANGLE_D270, NULL);
+    break;
+  default:
+    result = vips_copy(in, out, NULL);
+  }
+
+  g_object_unref(base);
+  return result;
+}
+
+// import_profile transforms an image with an embedded profile to sRGB, the same way vips_thumbnail does, so that the colors don't depend on the resize path
+// Grayscale images are kept as they are, as transforming them to sRGB would turn them into color images
+static int import_profile(VipsImage *in, VipsImage **out) {
+  if (!vips_image_get_typeof(in, VIPS_META_ICC_NAME) || is_grayscale(in)) {
+    return vips_copy(in, out, NULL);
+  }
+
+  if (vips_icc_transform(in, out, "srgb", "embedded", TRUE, "intent", VIPS_INTENT_PERCEPTUAL, NULL)) {
+    return -1;
+  }
+
+  // Remove the attached sRGB profile, so that vips_thumbnail_image doesn't transform the image again
+  vips_image_remove(*out, VIPS_META_ICC_NAME);
+  return 0;
+}
+
+int resize_image(void *buf, size_t len, VipsImage **out, int width, int height, VipsInteresting interesting, VipsSize size, VipsKernel kernel, int page, int orientation,
+                 int crop, int crop_left, int crop_top, int crop_width, int crop_height,
+                 int trim, int trim_use_color, double trim_r, double trim_g, double trim_b, double trim_threshold, int grayscale) {
+  // Only pass the page to the loader when it's needed, as loaders for single page formats don't support it
+  char options[32] = "";
+  if (page > 0) {
+    vips_snprintf(options, sizeof(options), "page=%d", page);
+  }
+
+  // vips_thumbnail always uses lanczos3 and the orientation from the image, so only take the slower path when
+  // another kernel, an orientation override, cropping, trimming or grayscale is requested
+  // It already uses shrink-on-load when possible
+  if (kernel == VIPS_KERNEL_LANCZOS3 && !orientation && !crop && !trim && !grayscale) {
+    return vips_thumbnail_buffer(buf, len, out, width, "height", height, "crop", interesting, "size", size, "option_string", options, NULL);
+  }
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  // Loading from a buffer only reads the header, so we can check the size before decoding
+  if (!(t[0] = vips_image_new_from_buffer(buf, len, options, NULL))) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  // Decode JPEG images at a reduced scale when they're much larger than the requested size
+  // The shrink is based on the full image, so it's skipped when cropping or trimming would make the image smaller
+  const char *loader = vips_foreign_find_load_buffer(buf, len);
+  if (!crop && !trim && loader && vips_isprefix("VipsForeignLoadJpeg", loader)) {
+    int shrink = jpeg_shrink_factor(t[0], width, height);
+
+    if (shrink > 1) {
+      g_object_unref(t[0]);
+      if (!(t[0] = vips_image_new_from_buffer(buf, len, "", "shrink", shrink, NULL))) {
+        g_object_unref(base);
+        return -1;
+      }
+    }
+  }
+
+  // Remove the orientation on a copy when it's overridden, so that it's not applied again when saving or resizing
+  if (vips_copy(t[0], &t[1], NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  if (orientation) {
+    vips_image_remove(t[1], VIPS_META_ORIENTATION);
+  }
+
+  if (orientation ? orient_image(t[1], &t[2], orientation) : vips_autorot(t[1], &t[2], NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  VipsImage *in = t[2];
+  if (crop) {
+    // Clip the region to the image, in case the oriented image is smaller than expected
+    VipsRect image_rect = { 0, 0, in->Xsize, in->Ysize };
+    VipsRect crop_rect = { crop_left, crop_top, crop_width, crop_height };
+    vips_rect_intersectrect(&image_rect, &crop_rect, &crop_rect);
+
+    if (vips_rect_isempty(&crop_rect)) {
+      vips_error("resize_image", "crop is outside of the image");
+      g_object_unref(base);
+      return -1;
+    }
+
+    if (vips_extract_area(in, &t[3], crop_rect.left, crop_rect.top, crop_rect.width, crop_rect.height, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    in = t[3];
+  }
+
+  if (trim) {
+    if (trim_image(in, &t[4], trim_use_color, trim_r, trim_g, trim_b, trim_threshold)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    in = t[4];
+  }
+
+  // Import the embedded profile before the other conversions, as vips_resize leaves it to the caller
+  if (import_profile(in, &t[7])) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  in = t[7];
+
+  // Convert to grayscale before resizing, so that only a single band is resized
+  if (grayscale) {
+    if (vips_colourspace(in, &t[6], VIPS_INTERPRETATION_B_W, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    in = t[6];
+  }
+
+  if (kernel == VIPS_KERNEL_LANCZOS3) {
+    int result = vips_thumbnail_image(in, out, width, "height", height, "crop", interesting, "size", size, NULL);
+    g_object_unref(base);
+    return result;
+  }
+
+  double hscale = (double) width / in->Xsize;
+  double vscale = (double) height / in->Ysize;
+
+  // Stretch the image to the requested size
+  if (size == VIPS_SIZE_FORCE) {
+    int result = vips_resize(in, out, hscale, "vscale", vscale, "kernel", kernel, NULL);
+    g_object_unref(base);
+    return result;
+  }
+
+  // Scale so that the image fits within the requested size, without cropping it
+  if (interesting == VIPS_INTERESTING_NONE) {
+    int result = vips_resize(in, out, VIPS_MIN(hscale, vscale), "kernel", kernel, NULL);
+    g_object_unref(base);
+    return result;
+  }
+
+  // Scale so that the image covers the requested size, then crop off the excess
+  if (vips_resize(in, &t[5], VIPS_MAX(hscale, vscale), "kernel", kernel, NULL) ||
+      vips_smartcrop(t[5], out, VIPS_MIN(width, t[5]->Xsize), VIPS_MIN(height, t[5]->Ysize), "interesting", interesting, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
 }
 
 int change_colorspace(VipsImage *in, VipsImage **out, VipsInterpretation colorspace) {
-  return vips_call("colourspace", in, out, colorspace, NULL);
+  if (!vips_image_hasalpha(in)) {
+    return vips_call("colourspace", in, out, colorspace, NULL);
+  }
+
+  // Split off the alpha channel, convert the remaining bands, and join the alpha back on
+  // so that transparent regions stay transparent
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, NULL) ||
+      vips_call("colourspace", t[0], &t[2], colorspace, NULL) ||
+      vips_bandjoin2(t[2], t[1], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+// The highest chroma an sRGB color has in LCh, used to scale the vibrance so that saturated colors are barely changed
+#define MAX_CHROMA 134.0
+
+// The range of hues in LCh that roughly covers skin tones, which vibrance only boosts by half as much
+#define SKIN_HUE_MIN 20.0
+#define SKIN_HUE_MAX 70.0
+
+int adjust_chroma(VipsImage *in, VipsImage **out, double saturation, double vibrance) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 18);
+
+  // Split off the alpha channel, and adjust the chroma of the remaining bands in LCh
+  VipsImage *color = in;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(in, &t[1], in->Bands - 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    color = t[0];
+  }
+
+  if (vips_colourspace(color, &t[2], VIPS_INTERPRETATION_LCH, NULL) ||
+      vips_extract_band(t[2], &t[3], 0, NULL) ||
+      vips_extract_band(t[2], &t[4], 1, NULL) ||
+      vips_extract_band(t[2], &t[5], 2, NULL) ||
+      vips_linear1(t[4], &t[6], saturation, 0, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  VipsImage *chroma = t[6];
+  if (vibrance != 0) {
+    // Scale the vibrance by how muted each pixel is, so that the least saturated colors change the most
+    if (vips_linear1(chroma, &t[7], -1.0 / MAX_CHROMA, 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    VipsImage *weight = t[7];
+    if (vibrance > 0) {
+      // Halve the boost for skin tones, so that people don't turn orange
+      if (vips_relational_const1(t[5], &t[8], VIPS_OPERATION_RELATIONAL_MOREEQ, SKIN_HUE_MIN, NULL) ||
+          vips_relational_const1(t[5], &t[9], VIPS_OPERATION_RELATIONAL_LESSEQ, SKIN_HUE_MAX, NULL) ||
+          vips_andimage(t[8], t[9], &t[10], NULL) ||
+          vips_linear1(t[10], &t[11], -0.5 / 255.0, 1, NULL) ||
+          vips_multiply(weight, t[11], &t[12], NULL)) {
+        g_object_unref(base);
+        return -1;
+      }
+
+      weight = t[12];
+    }
+
+    if (vips_linear1(weight, &t[13], vibrance, 1, NULL) ||
+        vips_multiply(chroma, t[13], &t[14], NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    chroma = t[14];
+  }
+
+  // Join the bands back together, and convert back to sRGB before joining the alpha back on
+  VipsImage *bands[3] = {t[3], chroma, t[5]};
+
+  if (vips_bandjoin(bands, &t[15], 3, NULL) ||
+      vips_copy(t[15], &t[16], "interpretation", VIPS_INTERPRETATION_LCH, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  int result;
+  if (color == in) {
+    result = vips_colourspace(t[16], out, VIPS_INTERPRETATION_sRGB, NULL);
+  } else {
+    result = vips_colourspace(t[16], &t[17], VIPS_INTERPRETATION_sRGB, NULL) ||
+             vips_bandjoin2(t[17], t[1], out, NULL);
+  }
+
+  g_object_unref(base);
+  return result ? -1 : 0;
+}
+
+int colorize_image(VipsImage *in, VipsImage **out, double hue, double chroma) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 9);
+
+  // Split off the alpha channel, and replace the chroma and hue of the remaining bands in LCh
+  VipsImage *color = in;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(in, &t[1], in->Bands - 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    color = t[0];
+  }
+
+  // The chroma and hue bands are constant, made from the lightness band so that they have the same size and format
+  if (vips_colourspace(color, &t[2], VIPS_INTERPRETATION_LCH, NULL) ||
+      vips_extract_band(t[2], &t[3], 0, NULL) ||
+      vips_linear1(t[3], &t[4], 0, chroma, NULL) ||
+      vips_linear1(t[3], &t[5], 0, hue, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  VipsImage *bands[3] = {t[3], t[4], t[5]};
+
+  if (vips_bandjoin(bands, &t[6], 3, NULL) ||
+      vips_copy(t[6], &t[7], "interpretation", VIPS_INTERPRETATION_LCH, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  int result;
+  if (color == in) {
+    result = vips_colourspace(t[7], out, VIPS_INTERPRETATION_sRGB, NULL);
+  } else {
+    result = vips_colourspace(t[7], &t[8], VIPS_INTERPRETATION_sRGB, NULL) ||
+             vips_bandjoin2(t[8], t[1], out, NULL);
+  }
+
+  g_object_unref(base);
+  return result ? -1 : 0;
+}
+
+int recomb_image(VipsImage *in, VipsImage **out, double *matrix) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 7);
+
+  // Split off the alpha channel, and recombine the remaining bands in linear light
+  VipsImage *color = in;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(in, &t[1], in->Bands - 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    color = t[0];
+  }
+
+  t[2] = vips_image_new_matrix_from_array(3, 3, matrix, 9);
+  if (!t[2] ||
+      vips_colourspace(color, &t[3], VIPS_INTERPRETATION_scRGB, NULL) ||
+      vips_recomb(t[3], &t[4], t[2], NULL) ||
+      vips_copy(t[4], &t[5], "interpretation", VIPS_INTERPRETATION_scRGB, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  int result;
+  if (color == in) {
+    result = vips_colourspace(t[5], out, VIPS_INTERPRETATION_sRGB, NULL);
+  } else {
+    result = vips_colourspace(t[5], &t[6], VIPS_INTERPRETATION_sRGB, NULL) ||
+             vips_bandjoin2(t[6], t[1], out, NULL);
+  }
+
+  g_object_unref(base);
+  return result ? -1 : 0;
 }
 
-int blur_image(VipsImage *in, VipsImage **out, double blur) {
-  return vips_call("gaussblur", in, out, blur, NULL);
+int extract_channel(VipsImage *in, VipsImage **out, int channel) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+
+  // Split off the alpha channel, so that the color channels can be read without it
+  VipsImage *color = in;
+  VipsImage *alpha = NULL;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(in, &t[1], in->Bands - 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    color = t[0];
+    alpha = t[1];
+  }
+
+  VipsImage *band;
+  switch (channel) {
+    case 3: // Alpha
+      // Images without an alpha channel are fully opaque, which is an inverted black image
+      if (alpha == NULL) {
+        if (vips_black(&t[2], in->Xsize, in->Ysize, NULL) ||
+            vips_invert(t[2], &t[3], NULL)) {
+          g_object_unref(base);
+          return -1;
+        }
+
+        alpha = t[3];
+      }
+
+      band = alpha;
+      break;
+    case 4: // Luminance
+      if (vips_colourspace(color, &t[2], VIPS_INTERPRETATION_B_W, NULL)) {
+        g_object_unref(base);
+        return -1;
+      }
+
+      band = t[2];
+      break;
+    default: // Red, green or blue
+      if (vips_colourspace(color, &t[2], VIPS_INTERPRETATION_sRGB, NULL) ||
+          vips_extract_band(t[2], &t[3], channel, NULL)) {
+        g_object_unref(base);
+        return -1;
+      }
+
+      band = t[3];
+      break;
+  }
+
+  if (vips_cast_uchar(band, &t[4], NULL) ||
+      vips_copy(t[4], out, "interpretation", VIPS_INTERPRETATION_B_W, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  // The color profile of the image doesn't apply to a single channel
+  vips_image_remove(*out, VIPS_META_ICC_NAME);
+
+  g_object_unref(base);
+  return 0;
+}
+
+int invert_region(VipsImage *in, VipsImage **out, int left, int top, int width, int height) {
+  // Clip the region to the image, as the image can end up smaller than requested
+  width = VIPS_MIN(width, in->Xsize - left);
+  height = VIPS_MIN(height, in->Ysize - top);
+  if (left < 0 || top < 0 || width <= 0 || height <= 0) {
+    return vips_copy(in, out, NULL);
+  }
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+
+  if (vips_extract_area(in, &t[0], left, top, width, height, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  // Only invert the color bands, so that transparent regions stay transparent
+  VipsImage *inverted;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(t[0], &t[1], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(t[0], &t[2], in->Bands - 1, NULL) ||
+        vips_invert(t[1], &t[3], NULL) ||
+        vips_bandjoin2(t[3], t[2], &t[4], NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    inverted = t[4];
+  } else {
+    if (vips_invert(t[0], &t[3], NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    inverted = t[3];
+  }
+
+  int result = vips_insert(in, inverted, out, left, top, NULL);
+  g_object_unref(base);
+  return result;
+}
+
+int gamma_image(VipsImage *in, VipsImage **out, double gamma) {
+  if (!vips_image_hasalpha(in)) {
+    return vips_gamma(in, out, "exponent", gamma, NULL);
+  }
+
+  // Only correct the color bands, so that the transparency is left as is
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, NULL) ||
+      vips_gamma(t[0], &t[2], "exponent", gamma, NULL) ||
+      vips_bandjoin2(t[2], t[1], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int scale_image(VipsImage *in, VipsImage **out, double hscale, double vscale, VipsKernel kernel) {
+  return vips_resize(in, out, hscale, "vscale", vscale, "kernel", kernel, NULL);
+}
+
+int linear_image(VipsImage *in, VipsImage **out, double scale, double offset) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  if (!vips_image_hasalpha(in)) {
+    if (vips_linear1(in, &t[0], scale, offset, NULL) ||
+        vips_cast(t[0], out, in->BandFmt, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    g_object_unref(base);
+    return 0;
+  }
+
+  // Only adjust the color bands, so that the transparency is left as is
+  if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, NULL) ||
+      vips_linear1(t[0], &t[2], scale, offset, NULL) ||
+      vips_cast(t[2], &t[3], in->BandFmt, NULL) ||
+      vips_bandjoin2(t[3], t[1], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int vignette_image(VipsImage *in, VipsImage **out, double amount) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 10);
+
+  // The coordinates of the center of each pixel, scaled to -1 and 1 at the edges so that the falloff follows the size of the image
+  double scale[2] = {2.0 / in->Xsize, 2.0 / in->Ysize};
+  double offset[2] = {1.0 / in->Xsize - 1, 1.0 / in->Ysize - 1};
+
+  // The mean of the squared coordinates is 0 in the center and 1 in the corners, which are darkened by the full amount
+  if (vips_xyz(&t[0], in->Xsize, in->Ysize, NULL) ||
+      vips_linear(t[0], &t[1], scale, offset, 2, NULL) ||
+      vips_multiply(t[1], t[1], &t[2], NULL) ||
+      vips_bandmean(t[2], &t[3], NULL) ||
+      vips_linear1(t[3], &t[4], -amount, 1, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  if (!vips_image_hasalpha(in)) {
+    if (vips_multiply(in, t[4], &t[5], NULL) ||
+        vips_cast(t[5], out, in->BandFmt, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    g_object_unref(base);
+    return 0;
+  }
+
+  // Only darken the color bands, so that the transparency is left as is
+  if (vips_extract_band(in, &t[6], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[7], in->Bands - 1, NULL) ||
+      vips_multiply(t[6], t[4], &t[8], NULL) ||
+      vips_cast(t[8], &t[9], in->BandFmt, NULL) ||
+      vips_bandjoin2(t[9], t[7], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int sharpen_image(VipsImage *in, VipsImage **out, double sigma) {
+  if (!vips_image_hasalpha(in)) {
+    return vips_sharpen(in, out, "sigma", sigma, NULL);
+  }
+
+  // Only sharpen the color bands, so that the edges of transparent regions aren't sharpened into halos
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+      vips_extract_band(in, &t[1], in->Bands - 1, NULL) ||
+      vips_sharpen(t[0], &t[2], "sigma", sigma, NULL) ||
+      vips_bandjoin2(t[2], t[1], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int threshold_image(VipsImage *in, VipsImage **out, int level, gboolean dither) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  // Split off the alpha channel, so that transparent regions stay transparent
+  VipsImage *color = in;
+  VipsImage *alpha = NULL;
+  if (vips_image_hasalpha(in)) {
+    if (vips_extract_band(in, &t[0], 0, "n", in->Bands - 1, NULL) ||
+        vips_extract_band(in, &t[1], in->Bands - 1, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    color = t[0];
+    alpha = t[1];
+  }
+
+  if (vips_colourspace(color, &t[2], VIPS_INTERPRETATION_B_W, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  if (dither) {
+    // Ordered dithering with an 8x8 bayer matrix, where the thresholds are spread around the level
+    // The thresholds stay within 0-255, so that black and white are never dithered
+    t[3] = vips_image_new_matrix(8, 8);
+    for (int y = 0; y < 8; y++) {
+      for (int x = 0; x < 8; x++) {
+        int v = 0;
+        for (int bit = 0; bit < 3; bit++) {
+          int xb = (x >> bit) & 1;
+          int yb = (y >> bit) & 1;
+          v |= ((xb ^ yb) << (5 - 2 * bit)) | (yb << (4 - 2 * bit));
+        }
+
+        double position = (v + 0.5) / 64;
+        double threshold = position < 0.5 ? 2 * position * level : level + (2 * position - 1) * (255 - level);
+        *VIPS_MATRIX(t[3], x, y) = threshold;
+      }
+    }
+
+    if (vips_replicate(t[3], &t[4], VIPS_ROUND_UP(in->Xsize, 8) / 8, VIPS_ROUND_UP(in->Ysize, 8) / 8, NULL) ||
+        vips_extract_area(t[4], &t[5], 0, 0, in->Xsize, in->Ysize, NULL) ||
+        vips_moreeq(t[2], t[5], &t[6], NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+  } else {
+    if (vips_moreeq_const1(t[2], &t[6], level, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+  }
+
+  int result;
+  if (alpha != NULL) {
+    result = vips_bandjoin2(t[6], alpha, &t[7], NULL) ||
+      vips_copy(t[7], out, "interpretation", VIPS_INTERPRETATION_B_W, NULL);
+  } else {
+    result = vips_copy(t[6], out, "interpretation", VIPS_INTERPRETATION_B_W, NULL);
+  }
+
+  g_object_unref(base);
+  return result ? -1 : 0;
+}
+
+int embed_image(VipsImage *in, VipsImage **out, int width, int height, double r, double g, double b) {
+  double background[4];
+  int n = background_bands(in, r, g, b, background);
+
+  VipsArrayDouble *arr = vips_array_double_new(background, n);
+  int x = (width - in->Xsize) / 2;
+  int y = (height - in->Ysize) / 2;
+
+  int result = vips_embed(in, out, x, y, width, height, "extend", VIPS_EXTEND_BACKGROUND, "background", arr, NULL);
+  vips_area_unref(VIPS_AREA(arr));
+
+  return result;
+}
+
+int flatten_image(VipsImage *in, VipsImage **out, double r, double g, double b) {
+  // The background has a value for each band other than the alpha channel
+  double background[4];
+  int n = background_bands(in, r, g, b, background) - 1;
+
+  VipsArrayDouble *arr = vips_array_double_new(background, n);
+  int result = vips_flatten(in, out, "background", arr, NULL);
+  vips_area_unref(VIPS_AREA(arr));
+
+  return result;
+}
+
+int is_opaque(VipsImage *in, int *opaque) {
+  VipsImage *alpha;
+  if (vips_extract_band(in, &alpha, in->Bands - 1, NULL)) {
+    return -1;
+  }
+
+  // The image is opaque when even the most transparent pixel has the largest alpha value of its format
+  double min;
+  int err = vips_min(alpha, &min, NULL);
+  g_object_unref(alpha);
+  if (err) {
+    return -1;
+  }
+
+  *opaque = min >= vips_interpretation_max_alpha(in->Type);
+
+  return 0;
+}
+
+int remove_alpha(VipsImage *in, VipsImage **out) {
+  return vips_extract_band(in, out, 0, "n", in->Bands - 1, NULL);
+}
+
+int embed_image_alpha(VipsImage *in, VipsImage **out, int width, int height, double r, double g, double b, double a) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  // The padding has an alpha, so the image needs an alpha channel if it doesn't have one already
+  VipsImage *source = in;
+  if (!vips_image_hasalpha(in)) {
+    if (vips_addalpha(in, &t[0], NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    source = t[0];
+  }
+
+  // The last band is the alpha channel, which background_bands sets to opaque
+  double background[4];
+  int n = background_bands(source, r, g, b, background);
+  background[n - 1] = a;
+
+  VipsArrayDouble *arr = vips_array_double_new(background, n);
+  int x = (width - source->Xsize) / 2;
+  int y = (height - source->Ysize) / 2;
+
+  int result = vips_embed(source, out, x, y, width, height, "extend", VIPS_EXTEND_BACKGROUND, "background", arr, NULL);
+  vips_area_unref(VIPS_AREA(arr));
+  g_object_unref(base);
+
+  return result;
+}
+
+// The smallest font size that text is shrunk to, and how many times it's rendered again while shrinking it
+#define MIN_FONT_SIZE 1
+#define MAX_TEXT_FITS 8
+
+// render_text renders the markup as a mask at the font size, wrapped to the width
+static int render_text(VipsImage **out, char const* markup, int size, int width) {
+  char font[64];
+  vips_snprintf(font, sizeof(font), "sans bold %d", size);
+
+  return vips_text(out, markup, "font", font, "width", width, "align", VIPS_ALIGN_CENTRE, "dpi", 72, NULL);
+}
+
+// fit_text renders the markup at a font size proportional to the smaller side of the image, shrinking it until the text fits within width and height
+static int fit_text(VipsImage *in, VipsImage **out, char const* markup, int width, int height) {
+  int size = VIPS_MAX(VIPS_MIN(in->Xsize, in->Ysize) / 8, MIN_FONT_SIZE);
+
+  VipsImage *text;
+  if (render_text(&text, markup, size, width)) {
+    return -1;
+  }
+
+  for (int i = 0; i < MAX_TEXT_FITS && size > MIN_FONT_SIZE && (text->Xsize > width || text->Ysize > height); i++) {
+    // Shrink by how much the text overflows, and at least by a point, as the wrapping changes along with the size
+    double scale = VIPS_MIN((double) width / text->Xsize, (double) height / text->Ysize);
+    size = VIPS_MAX(VIPS_MIN((int) (size * scale), size - 1), MIN_FONT_SIZE);
+
+    g_object_unref(text);
+    if (render_text(&text, markup, size, width)) {
+      return -1;
+    }
+  }
+
+  *out = text;
+  return 0;
+}
+
+int draw_text(VipsImage *in, VipsImage **out, char const* text, double r, double g, double b, VipsCompassDirection direction) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  // vips_text takes pango markup, so escape the text to draw it as is
+  char *markup = g_markup_escape_text(text, -1);
+
+  // Size the text relative to the image, and fit it within the margins, which are at least a pixel wide even for the smallest images
+  int margin = VIPS_MAX(VIPS_MIN(in->Xsize, in->Ysize) / 20, 1);
+  int width = VIPS_MAX(in->Xsize - margin * 2, 1);
+  int height = VIPS_MAX(in->Ysize - margin * 2, 1);
+
+  int result = fit_text(in, &t[0], markup, width, height);
+  g_free(markup);
+
+  if (result) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  // The text is a mask, so pad it with the margin, place it on an image sized mask, and use it to blend the color onto the image
+  double background[4];
+  int n = background_bands(in, r, g, b, background);
+
+  if (vips_embed(t[0], &t[1], margin, margin, t[0]->Xsize + margin * 2, t[0]->Ysize + margin * 2, NULL) ||
+      vips_gravity(t[1], &t[2], direction, in->Xsize, in->Ysize, NULL) ||
+      !(t[3] = vips_image_new_from_image(in, background, n)) ||
+      vips_ifthenelse(t[2], t[3], in, out, "blend", TRUE, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int join_images(VipsImage **in, VipsImage **out, int n, int across) {
+  // Cells that are left over in the last row are filled with the black background
+  return vips_arrayjoin(in, out, n, "across", across, NULL);
+}
+
+int blend_images(VipsImage *in, VipsImage *overlay, VipsImage **out, VipsBlendMode mode, double opacity) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 6);
+
+  // Blend in sRGB, adding an alpha band to the overlay if it doesn't have one so the opacity can be applied to it
+  if (vips_colourspace(in, &t[0], VIPS_INTERPRETATION_sRGB, NULL) ||
+      vips_colourspace(overlay, &t[1], VIPS_INTERPRETATION_sRGB, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  VipsImage *source = t[1];
+  if (!vips_image_hasalpha(source)) {
+    if (vips_bandjoin_const1(source, &t[2], 255, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    source = t[2];
+  }
+
+  double a[4] = {1.0, 1.0, 1.0, opacity};
+  double b[4] = {0.0, 0.0, 0.0, 0.0};
+
+  // The composite always has an alpha band, so drop it again unless the image had one to begin with
+  int bands = vips_image_hasalpha(t[0]) ? 4 : 3;
+
+  if (vips_linear(source, &t[3], a, b, 4, NULL) ||
+      vips_composite2(t[0], t[3], &t[4], mode, NULL) ||
+      vips_extract_band(t[4], &t[5], 0, "n", bands, NULL) ||
+      vips_cast(t[5], out, VIPS_FORMAT_UCHAR, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int overlay_image(VipsImage *in, VipsImage *overlay, VipsImage **out, VipsCompassDirection direction, double opacity) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  // Composite in sRGB, adding an alpha band to the overlay if it doesn't have one so the opacity can be applied to it
+  if (vips_colourspace(in, &t[0], VIPS_INTERPRETATION_sRGB, NULL) ||
+      vips_colourspace(overlay, &t[1], VIPS_INTERPRETATION_sRGB, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  VipsImage *source = t[1];
+  if (!vips_image_hasalpha(source)) {
+    if (vips_bandjoin_const1(source, &t[2], 255, NULL)) {
+      g_object_unref(base);
+      return -1;
+    }
+
+    source = t[2];
+  }
+
+  double a[4] = {1.0, 1.0, 1.0, opacity};
+  double b[4] = {0.0, 0.0, 0.0, 0.0};
+
+  // Pad the overlay with a transparent margin, the same as for text, and place it on a transparent image sized canvas
+  int margin = VIPS_MAX(VIPS_MIN(in->Xsize, in->Ysize) / 20, 1);
+
+  // The composite always has an alpha band, so drop it again unless the image had one to begin with
+  int bands = vips_image_hasalpha(t[0]) ? 4 : 3;
+
+  if (vips_linear(source, &t[3], a, b, 4, NULL) ||
+      vips_embed(t[3], &t[4], margin, margin, t[3]->Xsize + margin * 2, t[3]->Ysize + margin * 2, NULL) ||
+      vips_gravity(t[4], &t[5], direction, in->Xsize, in->Ysize, NULL) ||
+      vips_composite2(t[0], t[5], &t[6], VIPS_BLEND_MODE_OVER, NULL) ||
+      vips_extract_band(t[6], &t[7], 0, "n", bands, NULL) ||
+      vips_cast(t[7], out, VIPS_FORMAT_UCHAR, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int mask_image(VipsImage *in, void *buf, size_t len, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 4);
+
+  // Stretch the mask to the exact size of the image, and use its first band as the alpha channel
+  int bands = vips_image_hasalpha(in) ? in->Bands - 1 : in->Bands;
+
+  if (vips_thumbnail_buffer(buf, len, &t[0], in->Xsize, "height", in->Ysize, "size", VIPS_SIZE_FORCE, NULL) ||
+      vips_colourspace(t[0], &t[1], VIPS_INTERPRETATION_B_W, NULL) ||
+      vips_extract_band(t[1], &t[2], 0, NULL) ||
+      vips_extract_band(in, &t[3], 0, "n", bands, NULL) ||
+      vips_bandjoin2(t[3], t[2], out, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int blur_image(VipsImage *in, VipsImage **out, double blur, gboolean approximate, VipsExtend extend) {
+  // The approximate precision uses a few box blurs, which is much faster for large amounts of blur
+  VipsPrecision precision = approximate ? VIPS_PRECISION_APPROXIMATE : VIPS_PRECISION_INTEGER;
+
+  // vips_gaussblur copies the pixels at the edges, which is the default
+  if (extend == VIPS_EXTEND_COPY) {
+    return vips_gaussblur(in, out, blur, "precision", precision, NULL);
+  }
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+
+  // Otherwise pad the image by more than the radius of the blur, and crop the padding off again afterwards
+  int margin = (int) VIPS_CEIL(blur * 3) + 1;
+
+  if (vips_embed(in, &t[0], margin, margin, in->Xsize + margin * 2, in->Ysize + margin * 2, "extend", extend, NULL) ||
+      vips_gaussblur(t[0], &t[1], blur, "precision", precision, NULL) ||
+      vips_extract_area(t[1], out, margin, margin, in->Xsize, in->Ysize, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
+int blur_region(VipsImage *in, VipsImage **out, double blur, gboolean approximate, VipsExtend extend, int left, int top, int width, int height) {
+  // Clip the region to the image, as the image can end up smaller than requested
+  VipsRect image_rect = { 0, 0, in->Xsize, in->Ysize };
+  VipsRect region = { left, top, width, height };
+  vips_rect_intersectrect(&image_rect, &region, &region);
+  if (vips_rect_isempty(&region)) {
+    return vips_copy(in, out, NULL);
+  }
+
+  // Blur the region along with the pixels around it that are within the radius of the blur, so that it blends in with the rest of the image
+  int margin = (int) VIPS_CEIL(blur * 3) + 1;
+  VipsRect surrounding = { region.left - margin, region.top - margin, region.width + margin * 2, region.height + margin * 2 };
+  vips_rect_intersectrect(&image_rect, &surrounding, &surrounding);
+
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  if (vips_extract_area(in, &t[0], surrounding.left, surrounding.top, surrounding.width, surrounding.height, NULL) ||
+      blur_image(t[0], &t[1], blur, approximate, extend) ||
+      vips_extract_area(t[1], &t[2], region.left - surrounding.left, region.top - surrounding.top, region.width, region.height, NULL)) {
+    g_object_unref(base);
+    return -1;
+  }
+
+  int result = vips_insert(in, t[2], out, region.left, region.top, NULL);
+  g_object_unref(base);
+  return result;
+}
+
+gboolean is_grayscale(VipsImage *image) {
+  return image->Type == VIPS_INTERPRETATION_B_W || image->Type == VIPS_INTERPRETATION_GREY16 || image->Bands - vips_image_hasalpha(image) == 1;
+}
+
+int icc_transform(VipsImage *in, VipsImage **out, char const* profile, gboolean embed) {
+  // Grayscale images can't be transformed with the RGB profiles, and look the same in sRGB and Display P3 as they share the white point and tone curve,
+  // so they're left as they are, without embedding a profile that doesn't match their bands
+  if (is_grayscale(in)) {
+    if (vips_copy(in, out, NULL)) {
+      return -1;
+    }
+
+    vips_image_remove(*out, VIPS_META_ICC_NAME);
+    return 0;
+  }
+
+  // The metadata, including any embedded profile, has been stripped at this point, so treat the input as sRGB
+  if (vips_icc_transform(in, out, profile, "input_profile", "srgb", "intent", VIPS_INTENT_PERCEPTUAL, NULL)) {
+    return -1;
+  }
+
+  // vips_icc_transform attaches the output profile, remove it unless it should be embedded
+  if (!embed) {
+    vips_image_remove(*out, VIPS_META_ICC_NAME);
+  }
+
+  return 0;
 }
 
 static void * remove_metadata(VipsImage *image, const char *field, GValue *value, void *my_data) {
@@ -49,3 +1203,13 @@ void set_user_comment(VipsImage *image, char const* comment) {
   // Set the user comment
   vips_image_set_string(image, "exif-ifd2-UserComment", comment);
 }
+
+int set_resolution(VipsImage *in, VipsImage **out, double resolution) {
+  if (vips_copy(in, out, "xres", resolution, "yres", resolution, NULL)) {
+    return -1;
+  }
+
+  // Save the density in inches, which more programs support than centimeters
+  vips_image_set_string(*out, "resolution-unit", "in");
+  return 0;
+}
@@ -12,9 +12,41 @@ void setup_logging();
 void log_handler(char const* log_domain, GLogLevelFlags log_level, char const* message, void* ignore);
 extern void log_callback(char* message);
 
-int save_image_to_jpeg_buffer(VipsImage *image, void **buf, size_t *len);
-int save_image_to_webp_buffer(VipsImage *image, void **buf, size_t *len);
-int resize_image(void *buf, size_t len, VipsImage **out, int width, int height, VipsInteresting interesting);
+int save_image_to_jpeg_buffer(VipsImage *image, void **buf, size_t *len, int quality, gboolean optimize_coding, gboolean interlace);
+int save_image_to_webp_buffer(VipsImage *image, void **buf, size_t *len, int quality, int effort);
+int save_image_to_lossless_webp_buffer(VipsImage *image, void **buf, size_t *len, int level, int effort);
+int save_image_to_raw_buffer(VipsImage *in, void **buf, size_t *len, int bands);
+int has_loader(char const* name);
+int get_image_size(void *buf, size_t len, int *width, int *height);
+int get_image_frames(void *buf, size_t len, int *frames);
+int resize_image(void *buf, size_t len, VipsImage **out, int width, int height, VipsInteresting interesting, VipsSize size, VipsKernel kernel, int page, int orientation,
+                 int crop, int crop_left, int crop_top, int crop_width, int crop_height,
+                 int trim, int trim_use_color, double trim_r, double trim_g, double trim_b, double trim_threshold, int grayscale);
 int change_colorspace(VipsImage *in, VipsImage **out, VipsInterpretation colorspace);
-int blur_image(VipsImage *in, VipsImage **out, double blur);
+int adjust_chroma(VipsImage *in, VipsImage **out, double saturation, double vibrance);
+int colorize_image(VipsImage *in, VipsImage **out, double hue, double chroma);
+int recomb_image(VipsImage *in, VipsImage **out, double *matrix);
+int extract_channel(VipsImage *in, VipsImage **out, int channel);
+int invert_region(VipsImage *in, VipsImage **out, int left, int top, int width, int height);
+int gamma_image(VipsImage *in, VipsImage **out, double gamma);
+int scale_image(VipsImage *in, VipsImage **out, double hscale, double vscale, VipsKernel kernel);
+int linear_image(VipsImage *in, VipsImage **out, double scale, double offset);
+int vignette_image(VipsImage *in, VipsImage **out, double amount);
+int sharpen_image(VipsImage *in, VipsImage **out, double sigma);
+int threshold_image(VipsImage *in, VipsImage **out, int level, gboolean dither);
+int embed_image(VipsImage *in, VipsImage **out, int width, int height, double r, double g, double b);
+int embed_image_alpha(VipsImage *in, VipsImage **out, int width, int height, double r, double g, double b, double a);
+int flatten_image(VipsImage *in, VipsImage **out, double r, double g, double b);
+int is_opaque(VipsImage *in, int *opaque);
+int remove_alpha(VipsImage *in, VipsImage **out);
+int draw_text(VipsImage *in, VipsImage **out, char const* text, double r, double g, double b, VipsCompassDirection direction);
+int join_images(VipsImage **in, VipsImage **out, int n, int across);
+int blend_images(VipsImage *in, VipsImage *overlay, VipsImage **out, VipsBlendMode mode, double opacity);
+int overlay_image(VipsImage *in, VipsImage *overlay, VipsImage **out, VipsCompassDirection direction, double opacity);
+int mask_image(VipsImage *in, void *buf, size_t len, VipsImage **out);
+int blur_image(VipsImage *in, VipsImage **out, double blur, gboolean approximate, VipsExtend extend);
+int blur_region(VipsImage *in, VipsImage **out, double blur, gboolean approximate, VipsExtend extend, int left, int top, int width, int height);
+gboolean is_grayscale(VipsImage *image);
+int icc_transform(VipsImage *in, VipsImage **out, char const* profile, gboolean embed);
 void set_user_comment(VipsImage *image, char const* comment);
+int set_resolution(VipsImage *in, VipsImage **out, double resolution);
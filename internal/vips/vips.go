@@ -58,6 +58,17 @@ func Initialize(logger *logger.Logger) error {
 	return err
 }
 
+// SetConcurrency sets how many threads libvips uses for each image, which is shared by all the images being processed
+// As libvips processes images lazily, the threads are used while the image is encoded, which does the processing as well
+func SetConcurrency(threads int) {
+	C.vips_concurrency_set(C.int(threads))
+}
+
+// Concurrency returns how many threads libvips uses for each image
+func Concurrency() int {
+	return int(C.vips_concurrency_get())
+}
+
 // log_callback catches logs from libvips
 //export log_callback
 func log_callback(message *C.char) {
@@ -82,8 +93,123 @@ func catchVipsError() error {
 	return fmt.Errorf("%s", s)
 }
 
-// ResizeImage loads an image from a buffer and resizes it.
-func ResizeImage(buffer []byte, width int, height int) (Image, error) {
+// HasLoader returns whether libvips is built with the loader of the given name, such as heifload_buffer
+// Some loaders depend on optional libraries, such as libheif for HEIC and AVIF images
+func HasLoader(name string) bool {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	return C.has_loader(cName) != 0
+}
+
+// ImageSize returns the dimensions of an image in a buffer, by only reading the image header
+func ImageSize(buffer []byte) (width int, height int, err error) {
+	if len(buffer) == 0 {
+		return 0, 0, fmt.Errorf("empty buffer")
+	}
+
+	imageBuffer := unsafe.Pointer(&buffer[0])
+	imageBufferSize := C.size_t(len(buffer))
+
+	var cWidth, cHeight C.int
+
+	errCode := C.get_image_size(imageBuffer, imageBufferSize, &cWidth, &cHeight)
+
+	// Prevent buffer from being garbage collected until after get_image_size has been called
+	runtime.KeepAlive(buffer)
+
+	if errCode != 0 {
+		return 0, 0, fmt.Errorf("error reading image header from buffer %s", catchVipsError())
+	}
+
+	return int(cWidth), int(cHeight), nil
+}
+
+// ImageFrames returns the number of frames or pages of an image in a buffer, by only reading the image header
+func ImageFrames(buffer []byte) (frames int, err error) {
+	if len(buffer) == 0 {
+		return 0, fmt.Errorf("empty buffer")
+	}
+
+	imageBuffer := unsafe.Pointer(&buffer[0])
+	imageBufferSize := C.size_t(len(buffer))
+
+	var cFrames C.int
+
+	errCode := C.get_image_frames(imageBuffer, imageBufferSize, &cFrames)
+
+	// Prevent buffer from being garbage collected until after get_image_frames has been called
+	runtime.KeepAlive(buffer)
+
+	if errCode != 0 {
+		return 0, fmt.Errorf("error reading image header from buffer %s", catchVipsError())
+	}
+
+	return int(cFrames), nil
+}
+
+// Kernel is the interpolation kernel to use when resizing an image
+type Kernel int
+
+const (
+	// KernelNearest represents nearest-neighbour interpolation
+	KernelNearest Kernel = C.VIPS_KERNEL_NEAREST
+	// KernelLinear represents linear interpolation
+	KernelLinear Kernel = C.VIPS_KERNEL_LINEAR
+	// KernelCubic represents cubic interpolation
+	KernelCubic Kernel = C.VIPS_KERNEL_CUBIC
+	// KernelLanczos3 represents lanczos3 interpolation
+	KernelLanczos3 Kernel = C.VIPS_KERNEL_LANCZOS3
+)
+
+// Trim configures trimming the borders of an image before it's resized
+type Trim struct {
+	Enabled bool
+	// UseColor trims borders of the given color, rather than the color of the top left pixel
+	UseColor  bool
+	R         uint8
+	G         uint8
+	B         uint8
+	Threshold int
+}
+
+// Crop configures cropping an image to a region before it's resized
+type Crop struct {
+	Enabled bool
+	Left    int
+	Top     int
+	Width   int
+	Height  int
+}
+
+// Fit is how an image is resized to the requested size
+type Fit int
+
+const (
+	// FitCover scales the image to cover the requested size, and crops off the excess
+	FitCover Fit = iota
+	// FitContain scales the image to fit within the requested size, without cropping it
+	FitContain
+	// FitFill stretches the image to the requested size
+	FitFill
+)
+
+// ResizeOptions configures how an image is loaded and resized
+type ResizeOptions struct {
+	Kernel Kernel
+	Fit    Fit
+	// Frame is the 0-indexed frame to load from animated images
+	Frame int
+	// Orientation overrides the EXIF orientation (1-8) of the image, 0 uses the orientation from the image
+	Orientation int
+	Crop        Crop
+	Trim        Trim
+	// Grayscale converts the image to grayscale before resizing it, which is faster than converting it after
+	Grayscale bool
+}
+
+// ResizeImage loads an image from a buffer and resizes it using the given options.
+func ResizeImage(buffer []byte, width int, height int, options ResizeOptions) (Image, error) {
 	if len(buffer) == 0 {
 		return nil, fmt.Errorf("empty buffer")
 	}
@@ -93,7 +219,43 @@ func ResizeImage(buffer []byte, width int, height int) (Image, error) {
 
 	var image *C.VipsImage
 
-	errCode := C.resize_image(imageBuffer, imageBufferSize, &image, C.int(width), C.int(height), C.VIPS_INTERESTING_CENTRE)
+	trim := options.Trim
+
+	cTrim := C.int(0)
+	if trim.Enabled {
+		cTrim = C.int(1)
+	}
+
+	cTrimUseColor := C.int(0)
+	if trim.UseColor {
+		cTrimUseColor = C.int(1)
+	}
+
+	crop := options.Crop
+
+	cCrop := C.int(0)
+	if crop.Enabled {
+		cCrop = C.int(1)
+	}
+
+	cGrayscale := C.int(0)
+	if options.Grayscale {
+		cGrayscale = C.int(1)
+	}
+
+	interesting := C.VipsInteresting(C.VIPS_INTERESTING_CENTRE)
+	size := C.VipsSize(C.VIPS_SIZE_BOTH)
+	switch options.Fit {
+	case FitContain:
+		interesting = C.VIPS_INTERESTING_NONE
+	case FitFill:
+		interesting = C.VIPS_INTERESTING_NONE
+		size = C.VIPS_SIZE_FORCE
+	}
+
+	errCode := C.resize_image(imageBuffer, imageBufferSize, &image, C.int(width), C.int(height), interesting, size, C.VipsKernel(options.Kernel), C.int(options.Frame), C.int(options.Orientation),
+		cCrop, C.int(crop.Left), C.int(crop.Top), C.int(crop.Width), C.int(crop.Height),
+		cTrim, cTrimUseColor, C.double(trim.R), C.double(trim.G), C.double(trim.B), C.double(trim.Threshold), cGrayscale)
 
 	// Prevent buffer from being garbage collected until after resize_image has been called
 	runtime.KeepAlive(buffer)
@@ -105,14 +267,42 @@ func ResizeImage(buffer []byte, width int, height int) (Image, error) {
 	return image, nil
 }
 
-// SaveToJpegBuffer saves an image as JPEG to a buffer
-func SaveToJpegBuffer(image Image) ([]byte, error) {
+// Scale resizes an image that's already loaded to width x height, stretching it if the aspect ratio differs
+func Scale(image Image, width int, height int, kernel Kernel) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	imageWidth, imageHeight := ImageDimensions(image)
+	err := C.scale_image(image, &result, C.double(float64(width)/float64(imageWidth)), C.double(float64(height)/float64(imageHeight)), C.VipsKernel(kernel))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error scaling image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// SaveToJpegBuffer saves an image as JPEG to a buffer, with the given quality (1-100)
+// Optimizing the Huffman coding makes the image a few percent smaller, at the cost of a slower encode
+// Progressive images are shown in increasing quality as they load, while baseline images are decoded by more clients
+func SaveToJpegBuffer(image Image, quality int, optimizeCoding bool, progressive bool) ([]byte, error) {
 	defer UnrefImage(image)
 
 	var bufferPointer unsafe.Pointer
 	bufferLength := C.size_t(0)
 
-	err := C.save_image_to_jpeg_buffer(image, &bufferPointer, &bufferLength)
+	cOptimizeCoding := C.gboolean(0)
+	if optimizeCoding {
+		cOptimizeCoding = C.gboolean(1)
+	}
+
+	cInterlace := C.gboolean(0)
+	if progressive {
+		cInterlace = C.gboolean(1)
+	}
+
+	err := C.save_image_to_jpeg_buffer(image, &bufferPointer, &bufferLength, C.int(quality), cOptimizeCoding, cInterlace)
 
 	if err != 0 {
 		return nil, fmt.Errorf("error saving to jpeg buffer %s", catchVipsError())
@@ -125,14 +315,14 @@ func SaveToJpegBuffer(image Image) ([]byte, error) {
 	return buffer, nil
 }
 
-// SaveToWebPBuffer saves an image as WebP to a buffer
-func SaveToWebPBuffer(image Image) ([]byte, error) {
+// SaveToWebPBuffer saves an image as WebP to a buffer, with the given quality (1-100) and reduction effort (0-6)
+func SaveToWebPBuffer(image Image, quality int, effort int) ([]byte, error) {
 	defer UnrefImage(image)
 
 	var bufferPointer unsafe.Pointer
 	bufferLength := C.size_t(0)
 
-	err := C.save_image_to_webp_buffer(image, &bufferPointer, &bufferLength)
+	err := C.save_image_to_webp_buffer(image, &bufferPointer, &bufferLength, C.int(quality), C.int(effort))
 
 	if err != 0 {
 		return nil, fmt.Errorf("error saving to webp buffer %s", catchVipsError())
@@ -145,6 +335,48 @@ func SaveToWebPBuffer(image Image) ([]byte, error) {
 	return buffer, nil
 }
 
+// SaveToLosslessWebPBuffer saves an image as lossless WebP to a buffer, with the given near lossless level (0-100) and reduction effort (0-6)
+// Levels below 100 preprocess the image to compress better, at the cost of some fidelity
+func SaveToLosslessWebPBuffer(image Image, level int, effort int) ([]byte, error) {
+	defer UnrefImage(image)
+
+	var bufferPointer unsafe.Pointer
+	bufferLength := C.size_t(0)
+
+	err := C.save_image_to_lossless_webp_buffer(image, &bufferPointer, &bufferLength, C.int(level), C.int(effort))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error saving to lossless webp buffer %s", catchVipsError())
+	}
+
+	buffer := C.GoBytes(bufferPointer, C.int(bufferLength))
+
+	C.g_free(C.gpointer(bufferPointer))
+
+	return buffer, nil
+}
+
+// SaveToRawBuffer saves the uncompressed 8 bit pixels of an image to a buffer, with the given number of bands
+// A single band is grayscale, three are RGB and four are RGBA, where images without alpha get an opaque alpha channel
+func SaveToRawBuffer(image Image, bands int) ([]byte, error) {
+	defer UnrefImage(image)
+
+	var bufferPointer unsafe.Pointer
+	bufferLength := C.size_t(0)
+
+	err := C.save_image_to_raw_buffer(image, &bufferPointer, &bufferLength, C.int(bands))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error saving to raw buffer %s", catchVipsError())
+	}
+
+	buffer := C.GoBytes(bufferPointer, C.int(bufferLength))
+
+	C.g_free(C.gpointer(bufferPointer))
+
+	return buffer, nil
+}
+
 // Grayscale converts an image to grayscale
 func Grayscale(image Image) (Image, error) {
 	defer UnrefImage(image)
@@ -160,13 +392,448 @@ func Grayscale(image Image) (Image, error) {
 	return result, nil
 }
 
-// Blur applies gaussian blur to an image
-func Blur(image Image, blur int) (Image, error) {
+// Saturate multiplies the saturation of an image by the given amount
+func Saturate(image Image, saturation float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.adjust_chroma(image, &result, C.double(saturation), C.double(0))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error adjusting image saturation %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Vibrance adjusts the saturation of the muted colors of an image by the given amount (-1-1)
+// Colors that are already saturated are mostly left as is, and skin tones are only boosted by half as much
+func Vibrance(image Image, vibrance float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.adjust_chroma(image, &result, C.double(1), C.double(vibrance))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error adjusting image vibrance %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Colorize replaces the chroma and hue of every pixel of an image with the given ones in LCh, keeping the lightness
+func Colorize(image Image, hue float64, chroma float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.colorize_image(image, &result, C.double(hue), C.double(chroma))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error colorizing image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Recomb recombines the color bands of an image with a 3x3 matrix in linear light, where each row makes one of the red, green and blue bands
+func Recomb(image Image, matrix [9]float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.recomb_image(image, &result, (*C.double)(unsafe.Pointer(&matrix[0])))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error recombining image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Embed centers an image on a canvas of the given size, filling the rest with the given background color
+func Embed(image Image, width int, height int, r uint8, g uint8, b uint8) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.embed_image(image, &result, C.int(width), C.int(height), C.double(r), C.double(g), C.double(b))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error embedding image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// EmbedAlpha centers an image on a canvas of the given size, filling the rest with the given color and alpha, where an alpha of 0 leaves it transparent
+func EmbedAlpha(image Image, width int, height int, r uint8, g uint8, b uint8, a uint8) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.embed_image_alpha(image, &result, C.int(width), C.int(height), C.double(r), C.double(g), C.double(b), C.double(a))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error embedding image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Flatten removes the alpha channel of an image, by compositing it onto the given background color
+func Flatten(image Image, r uint8, g uint8, b uint8) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.flatten_image(image, &result, C.double(r), C.double(g), C.double(b))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error flattening image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// IsOpaque returns whether none of the pixels of an image with an alpha channel are transparent
+func IsOpaque(image Image) (bool, error) {
+	var opaque C.int
+
+	err := C.is_opaque(image, &opaque)
+
+	if err != 0 {
+		return false, fmt.Errorf("error reading alpha channel %s", catchVipsError())
+	}
+
+	return opaque != 0, nil
+}
+
+// RemoveAlpha removes the alpha channel of an image, without compositing it onto a background
+func RemoveAlpha(image Image) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.remove_alpha(image, &result)
+
+	if err != 0 {
+		return nil, fmt.Errorf("error removing alpha channel %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Gravity is the position to place something on an image
+type Gravity int
+
+const (
+	// GravityCenter represents the center of the image
+	GravityCenter Gravity = C.VIPS_COMPASS_DIRECTION_CENTRE
+	// GravityNorth represents the top of the image
+	GravityNorth Gravity = C.VIPS_COMPASS_DIRECTION_NORTH
+	// GravityNorthEast represents the top right of the image
+	GravityNorthEast Gravity = C.VIPS_COMPASS_DIRECTION_NORTH_EAST
+	// GravityEast represents the right of the image
+	GravityEast Gravity = C.VIPS_COMPASS_DIRECTION_EAST
+	// GravitySouthEast represents the bottom right of the image
+	GravitySouthEast Gravity = C.VIPS_COMPASS_DIRECTION_SOUTH_EAST
+	// GravitySouth represents the bottom of the image
+	GravitySouth Gravity = C.VIPS_COMPASS_DIRECTION_SOUTH
+	// GravitySouthWest represents the bottom left of the image
+	GravitySouthWest Gravity = C.VIPS_COMPASS_DIRECTION_SOUTH_WEST
+	// GravityWest represents the left of the image
+	GravityWest Gravity = C.VIPS_COMPASS_DIRECTION_WEST
+	// GravityNorthWest represents the top left of the image
+	GravityNorthWest Gravity = C.VIPS_COMPASS_DIRECTION_NORTH_WEST
+)
+
+// DrawText draws text in the given color onto an image, at the position given by gravity
+func DrawText(image Image, text string, r uint8, g uint8, b uint8, gravity Gravity) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+
+	err := C.draw_text(image, &result, cText, C.double(r), C.double(g), C.double(b), C.VipsCompassDirection(gravity))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error drawing text on image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// BlendMode is the mode to use when blending an image on top of another
+type BlendMode int
+
+const (
+	// BlendModeNormal places the overlay on top of the image
+	BlendModeNormal BlendMode = C.VIPS_BLEND_MODE_OVER
+	// BlendModeMultiply multiplies the colors of the image and the overlay, darkening the image
+	BlendModeMultiply BlendMode = C.VIPS_BLEND_MODE_MULTIPLY
+	// BlendModeScreen inverts, multiplies and inverts the colors again, lightening the image
+	BlendModeScreen BlendMode = C.VIPS_BLEND_MODE_SCREEN
+	// BlendModeOverlay multiplies or screens the colors depending on the colors of the image
+	BlendModeOverlay BlendMode = C.VIPS_BLEND_MODE_OVERLAY
+)
+
+// JoinImages lays out images of the same size in a grid, with the given amount of images across
+// The images are unreferenced after joining them
+func JoinImages(images []Image, across int) (Image, error) {
+	cImages := make([]*C.VipsImage, len(images))
+	for i, image := range images {
+		cImages[i] = image
+		defer UnrefImage(image)
+	}
+
+	var result *C.VipsImage
+
+	err := C.join_images(&cImages[0], &result, C.int(len(cImages)), C.int(across))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error joining images %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Blend blends the overlay on top of an image with the given mode and opacity (0-1)
+// The overlay has to be the same size as the image, and is unreferenced along with the image
+func Blend(image Image, overlay Image, mode BlendMode, opacity float64) (Image, error) {
+	defer UnrefImage(image)
+	defer UnrefImage(overlay)
+
+	var result *C.VipsImage
+
+	err := C.blend_images(image, overlay, &result, C.VipsBlendMode(mode), C.double(opacity))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error blending images %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Overlay places the overlay on top of an image at the position given by gravity, with the given opacity (0-1)
+// The overlay is placed within a margin from the edges, and is unreferenced along with the image
+func Overlay(image Image, overlay Image, gravity Gravity, opacity float64) (Image, error) {
+	defer UnrefImage(image)
+	defer UnrefImage(overlay)
+
+	var result *C.VipsImage
+
+	err := C.overlay_image(image, overlay, &result, C.VipsCompassDirection(gravity), C.double(opacity))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error overlaying image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Mask loads a grayscale mask from a buffer, resizes it to the size of an image, and uses it as the alpha channel of the image
+// Any existing alpha channel of the image is replaced
+func Mask(image Image, mask []byte) (Image, error) {
 	defer UnrefImage(image)
 
+	if len(mask) == 0 {
+		return nil, fmt.Errorf("empty buffer")
+	}
+
+	maskBuffer := unsafe.Pointer(&mask[0])
+	maskBufferSize := C.size_t(len(mask))
+
 	var result *C.VipsImage
 
-	err := C.blur_image(image, &result, C.double(blur))
+	err := C.mask_image(image, maskBuffer, maskBufferSize, &result)
+
+	// Prevent mask from being garbage collected until after mask_image has been called
+	runtime.KeepAlive(mask)
+
+	if err != 0 {
+		return nil, fmt.Errorf("error masking image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Channel is a channel of an image to extract
+type Channel int
+
+const (
+	// ChannelRed is the red channel
+	ChannelRed Channel = iota
+	// ChannelGreen is the green channel
+	ChannelGreen
+	// ChannelBlue is the blue channel
+	ChannelBlue
+	// ChannelAlpha is the alpha channel, images without one get an opaque alpha channel
+	ChannelAlpha
+	// ChannelLuminance is the lightness of the image
+	ChannelLuminance
+)
+
+// ExtractChannel returns a single channel of an image, as a grayscale image
+func ExtractChannel(image Image, channel Channel) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.extract_channel(image, &result, C.int(channel))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error extracting channel from image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// InvertRegion inverts the colors of a region of an image, leaving the alpha channel as is
+// The region is clipped to the image
+func InvertRegion(image Image, left int, top int, width int, height int) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.invert_region(image, &result, C.int(left), C.int(top), C.int(width), C.int(height))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error inverting image region %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Gamma applies gamma correction to an image, raising each pixel to the power of 1/gamma
+// A gamma above 1 brightens the midtones of the image, and below 1 darkens them, while black and white are unchanged
+func Gamma(image Image, gamma float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.gamma_image(image, &result, C.double(gamma))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error applying gamma to image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Brightness brightens (or darkens) an image by adding amount (-1-1) of the full range to each pixel
+func Brightness(image Image, amount float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.linear_image(image, &result, C.double(1), C.double(amount*255))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error adjusting image brightness %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Contrast increases (or reduces) the contrast of an image by amount (-1-1), stretching the pixels away from the midpoint
+// A contrast of -1 turns the image into flat gray, and 1 doubles the distance of each pixel from the midpoint
+func Contrast(image Image, amount float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	scale := 1 + amount
+	err := C.linear_image(image, &result, C.double(scale), C.double(128*(1-scale)))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error adjusting image contrast %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Vignette darkens an image toward its edges by amount (0-1), where 1 turns the corners black
+// The falloff is relative to the size of the image, so that the vignette looks the same at any size
+func Vignette(image Image, amount float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.vignette_image(image, &result, C.double(amount))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error applying vignette to image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Sharpen sharpens the lightness of an image with an unsharp mask, where sigma is the size of the details to sharpen
+func Sharpen(image Image, sigma float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	err := C.sharpen_image(image, &result, C.double(sigma))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error sharpening image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Threshold converts an image to pure black and white, with the pixels at or above the luminance level (0-255) becoming white
+// Dithering spreads the threshold using an ordered pattern, so that the gray areas become a mix of black and white pixels
+func Threshold(image Image, level int, dither bool) (Image, error) {
+	defer UnrefImage(image)
+
+	cDither := C.gboolean(0)
+	if dither {
+		cDither = C.gboolean(1)
+	}
+
+	var result *C.VipsImage
+
+	err := C.threshold_image(image, &result, C.int(level), cDither)
+
+	if err != 0 {
+		return nil, fmt.Errorf("error thresholding image %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// Extend is how the pixels past the edges of an image are filled in
+type Extend int
+
+const (
+	// ExtendCopy repeats the pixels at the edges
+	ExtendCopy Extend = C.VIPS_EXTEND_COPY
+	// ExtendMirror reflects the image at the edges
+	ExtendMirror Extend = C.VIPS_EXTEND_MIRROR
+	// ExtendRepeat tiles the image, continuing with the opposite side
+	ExtendRepeat Extend = C.VIPS_EXTEND_REPEAT
+)
+
+// Blur applies gaussian blur to an image, filling in the pixels past the edges with extend
+// An approximate blur uses box blurs instead, which is faster but not as smooth
+func Blur(image Image, blur float64, approximate bool, extend Extend) (Image, error) {
+	defer UnrefImage(image)
+
+	cApproximate := C.gboolean(0)
+	if approximate {
+		cApproximate = C.gboolean(1)
+	}
+
+	var result *C.VipsImage
+
+	err := C.blur_image(image, &result, C.double(blur), cApproximate, C.VipsExtend(extend))
 
 	if err != 0 {
 		return nil, fmt.Errorf("error applying blur to image %s", catchVipsError())
@@ -175,11 +842,93 @@ func Blur(image Image, blur int) (Image, error) {
 	return result, nil
 }
 
+// BlurRegion applies gaussian blur to a region of an image, leaving the rest of it as is
+// The pixels around the region are taken into account, and the ones past the edges of the image are filled in with extend
+// The region is clipped to the image
+func BlurRegion(image Image, blur float64, approximate bool, extend Extend, left int, top int, width int, height int) (Image, error) {
+	defer UnrefImage(image)
+
+	cApproximate := C.gboolean(0)
+	if approximate {
+		cApproximate = C.gboolean(1)
+	}
+
+	var result *C.VipsImage
+
+	err := C.blur_region(image, &result, C.double(blur), cApproximate, C.VipsExtend(extend), C.int(left), C.int(top), C.int(width), C.int(height))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error applying blur to image region %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// ICCTransform converts an image to the given ICC profile, optionally embedding the profile in the image
+// The profile can be a path to an ICC profile, or the name of a profile built into libvips, such as "srgb" or "p3"
+func ICCTransform(image Image, profile string, embed bool) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	cProfile := C.CString(profile)
+	defer C.free(unsafe.Pointer(cProfile))
+
+	cEmbed := C.gboolean(0)
+	if embed {
+		cEmbed = C.gboolean(1)
+	}
+
+	err := C.icc_transform(image, &result, cProfile, cEmbed)
+
+	if err != 0 {
+		return nil, fmt.Errorf("error transforming image colorspace %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
 // SetUserComment sets the UserComment field in the exif metadata for an image
 func SetUserComment(image Image, comment string) {
 	C.set_user_comment(image, C.CString(comment))
 }
 
+// SetResolution sets the resolution of an image in dots per inch, which is written to the density of the saved image
+func SetResolution(image Image, dpi float64) (Image, error) {
+	defer UnrefImage(image)
+
+	var result *C.VipsImage
+
+	// libvips keeps the resolution in pixels per millimeter
+	err := C.set_resolution(image, &result, C.double(dpi/25.4))
+
+	if err != 0 {
+		return nil, fmt.Errorf("error setting image resolution %s", catchVipsError())
+	}
+
+	return result, nil
+}
+
+// HasAlpha returns whether an image has an alpha channel
+func HasAlpha(image Image) bool {
+	return C.vips_image_hasalpha(image) != 0
+}
+
+// IsGrayscale returns whether an image is grayscale, with a single band besides any alpha channel
+func IsGrayscale(image Image) bool {
+	return C.is_grayscale(image) != 0
+}
+
+// ImageDimensions returns the width and height of an image
+func ImageDimensions(image Image) (width int, height int) {
+	return int(image.Xsize), int(image.Ysize)
+}
+
+// ImageBands returns the number of bands of an image, including any alpha channel
+func ImageBands(image Image) int {
+	return int(image.Bands)
+}
+
 // UnrefImage unrefs an image object
 func UnrefImage(image Image) {
 	C.g_object_unref(C.gpointer(image))
@@ -1,7 +1,12 @@
 package vips_test
 
 import (
+	"bytes"
 	"fmt"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
 	"reflect"
 	"runtime"
 	"strings"
@@ -16,7 +21,7 @@ import (
 )
 
 func resizeImage(t *testing.T, imageBuffer []byte) vips.Image {
-	resizedImage, err := vips.ResizeImage(imageBuffer, 500, 500)
+	resizedImage, err := vips.ResizeImage(imageBuffer, 500, 500, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,16 +47,58 @@ func TestVips(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	transparentBuffer, err := ioutil.ReadFile("../../test/fixtures/transparent.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	t.Run("SaveToJpegBuffer", func(t *testing.T) {
 		t.Run("saves an image to buffer", func(t *testing.T) {
-			_, err := vips.SaveToJpegBuffer(resizeImage(t, imageBuffer))
+			_, err := vips.SaveToJpegBuffer(resizeImage(t, imageBuffer), 75, true, true)
 			if err != nil {
 				t.Error(err)
 			}
 		})
 
+		t.Run("saves progressive or baseline images", func(t *testing.T) {
+			// The start of frame marker is SOF2 for progressive images, and SOF0 for baseline images
+			progressive, _ := vips.SaveToJpegBuffer(resizeImage(t, imageBuffer), 75, true, true)
+			if !bytes.Contains(progressive, []byte{0xFF, 0xC2}) {
+				t.Error("not a progressive image")
+			}
+
+			baseline, _ := vips.SaveToJpegBuffer(resizeImage(t, imageBuffer), 75, true, false)
+			if !bytes.Contains(baseline, []byte{0xFF, 0xC0}) || bytes.Contains(baseline, []byte{0xFF, 0xC2}) {
+				t.Error("not a baseline image")
+			}
+		})
+
+		t.Run("starts progressive images with a preview scan", func(t *testing.T) {
+			buf, err := vips.SaveToJpegBuffer(resizeImage(t, imageBuffer), 75, true, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Walk the segments after the start of image marker to the first start of scan, which holds the scan header
+			offset := 2
+			for offset+4 <= len(buf) && buf[offset+1] != 0xDA {
+				offset += 2 + (int(buf[offset+2])<<8 | int(buf[offset+3]))
+			}
+
+			if offset+5 > len(buf) {
+				t.Fatal("no start of scan marker")
+			}
+
+			// The first scan has to carry the DC coefficients of all three components, a scaled down preview of the whole image
+			components := int(buf[offset+4])
+			spectral := buf[offset+5+components*2 : offset+5+components*2+2]
+			if components != 3 || spectral[0] != 0 || spectral[1] != 0 {
+				t.Errorf("first scan has %d components and spectral selection %d-%d", components, spectral[0], spectral[1])
+			}
+		})
+
 		t.Run("errors on an invalid image", func(t *testing.T) {
-			_, err := vips.SaveToJpegBuffer(vips.NewEmptyImage())
+			_, err := vips.SaveToJpegBuffer(vips.NewEmptyImage(), 75, true, true)
 			if err == nil || !strings.Contains(err.Error(), "error saving to jpeg buffer") || !strings.Contains(err.Error(), "vips_image_pio_input: no image data") {
 				t.Error(err)
 			}
@@ -60,28 +107,126 @@ func TestVips(t *testing.T) {
 
 	t.Run("SaveToWebPBuffer", func(t *testing.T) {
 		t.Run("saves an image to buffer", func(t *testing.T) {
-			_, err := vips.SaveToWebPBuffer(resizeImage(t, imageBuffer))
+			_, err := vips.SaveToWebPBuffer(resizeImage(t, imageBuffer), 75, 4)
 			if err != nil {
 				t.Error(err)
 			}
 		})
 
+		t.Run("uses the given effort", func(t *testing.T) {
+			fastest, err := vips.SaveToWebPBuffer(resizeImage(t, imageBuffer), 75, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			smallest, err := vips.SaveToWebPBuffer(resizeImage(t, imageBuffer), 75, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(fastest, smallest) {
+				t.Error("image data matches for different effort levels")
+			}
+		})
+
 		t.Run("errors on an invalid image", func(t *testing.T) {
-			_, err := vips.SaveToWebPBuffer(vips.NewEmptyImage())
+			_, err := vips.SaveToWebPBuffer(vips.NewEmptyImage(), 75, 4)
 			if err == nil || !strings.Contains(err.Error(), "error saving to webp buffer") || !strings.Contains(err.Error(), "vips_image_pio_input: no image data") {
 				t.Error(err)
 			}
 		})
 	})
 
+	t.Run("HasLoader", func(t *testing.T) {
+		t.Run("has the loaders of the default source formats", func(t *testing.T) {
+			for _, loader := range []string{"jpegload_buffer", "pngload_buffer", "webpload_buffer"} {
+				if !vips.HasLoader(loader) {
+					t.Errorf("missing loader %s", loader)
+				}
+			}
+		})
+
+		t.Run("doesn't have unknown loaders", func(t *testing.T) {
+			if vips.HasLoader("unknownload_buffer") {
+				t.Error("has an unknown loader")
+			}
+		})
+	})
+
+	t.Run("ImageSize", func(t *testing.T) {
+		t.Run("reads the size from the image header", func(t *testing.T) {
+			width, height, err := vips.ImageSize(imageBuffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if width != 6000 || height != 4000 {
+				t.Errorf("wrong size %dx%d", width, height)
+			}
+		})
+
+		t.Run("reads the size without decoding the image", func(t *testing.T) {
+			// huge.jpg is a PNG header without any image data that claims to be 20000x20000
+			buf, err := ioutil.ReadFile("../../test/fixtures/file/huge.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			width, height, err := vips.ImageSize(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if width != 20000 || height != 20000 {
+				t.Errorf("wrong size %dx%d", width, height)
+			}
+		})
+
+		t.Run("errors when given an invalid image", func(t *testing.T) {
+			_, _, err := vips.ImageSize(make([]byte, 5))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	})
+
+	t.Run("ImageFrames", func(t *testing.T) {
+		t.Run("reads the frame count of an animated image", func(t *testing.T) {
+			buf, err := ioutil.ReadFile("../../test/fixtures/file/animated.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frames, err := vips.ImageFrames(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if frames != 3 {
+				t.Errorf("wrong frame count %d", frames)
+			}
+		})
+
+		t.Run("returns a single frame for still images", func(t *testing.T) {
+			frames, err := vips.ImageFrames(imageBuffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if frames != 1 {
+				t.Errorf("wrong frame count %d", frames)
+			}
+		})
+	})
+
 	t.Run("ResizeImage", func(t *testing.T) {
 		t.Run("loads and resizes an image as jpeg", func(t *testing.T) {
-			image, err := vips.ResizeImage(imageBuffer, 500, 500)
+			image, err := vips.ResizeImage(imageBuffer, 500, 500, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
 			if err != nil {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToJpegBuffer(image)
+			buf, _ := vips.SaveToJpegBuffer(image, 75, true, true)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/resize_result_%s.jpg", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
@@ -89,28 +234,98 @@ func TestVips(t *testing.T) {
 		})
 
 		t.Run("loads and resizes an image as webp", func(t *testing.T) {
-			image, err := vips.ResizeImage(imageBuffer, 500, 500)
+			image, err := vips.ResizeImage(imageBuffer, 500, 500, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
 			if err != nil {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToWebPBuffer(image)
+			buf, _ := vips.SaveToWebPBuffer(image, 75, 4)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/resize_result_%s.webp", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
 			}
 		})
 
+		t.Run("resizes an image using the given kernel", func(t *testing.T) {
+			image, err := vips.ResizeImage(imageBuffer, 500, 500, vips.ResizeOptions{Kernel: vips.KernelNearest})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, _ := vips.SaveToJpegBuffer(image, 75, true, true)
+			config, err := jpeg.DecodeConfig(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Width != 500 || config.Height != 500 {
+				t.Errorf("wrong dimensions %dx%d", config.Width, config.Height)
+			}
+
+			lanczosFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/resize_result_%s.jpg", runtime.GOOS))
+			if reflect.DeepEqual(buf, lanczosFixture) {
+				t.Error("nearest image data matches lanczos image data")
+			}
+		})
+
+		t.Run("imports the embedded profile with every kernel", func(t *testing.T) {
+			// A saturated red, which has different values in display p3 than in srgb
+			red := goimage.NewRGBA(goimage.Rect(0, 0, 100, 100))
+			for i := 0; i < len(red.Pix); i += 4 {
+				copy(red.Pix[i:i+4], []uint8{255, 0, 0, 255})
+			}
+
+			var source bytes.Buffer
+			if err := png.Encode(&source, red); err != nil {
+				t.Fatal(err)
+			}
+
+			// Convert the image to display p3 and embed the profile, so that it has to be imported to get back to srgb
+			resized, err := vips.ResizeImage(source.Bytes(), 100, 100, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			converted, err := vips.ICCTransform(resized, "p3", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p3Buffer, err := vips.SaveToJpegBuffer(converted, 100, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, kernel := range []vips.Kernel{vips.KernelLanczos3, vips.KernelNearest, vips.KernelLinear, vips.KernelCubic} {
+				image, err := vips.ResizeImage(p3Buffer, 50, 50, vips.ResizeOptions{Kernel: kernel})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				buf, err := vips.SaveToRawBuffer(image, 3)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Sample the center, away from any ringing at the edges
+				offset := (25*50 + 25) * 3
+				pixel := color.RGBA{buf[offset], buf[offset+1], buf[offset+2], 255}
+				if pixel.R < 245 || pixel.G > 10 || pixel.B > 10 {
+					t.Errorf("wrong color %v for kernel %d", pixel, kernel)
+				}
+			}
+		})
+
 		t.Run("errors when given an empty buffer", func(t *testing.T) {
 			var buf []byte
-			_, err := vips.ResizeImage(buf, 500, 500)
+			_, err := vips.ResizeImage(buf, 500, 500, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
 			if err == nil || err.Error() != "empty buffer" {
 				t.Error(err)
 			}
 		})
 
 		t.Run("errors when given an invalid image", func(t *testing.T) {
-			_, err := vips.ResizeImage(make([]byte, 5), 500, 500)
+			_, err := vips.ResizeImage(make([]byte, 5), 500, 500, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
 			if err == nil || err.Error() != "error processing image from buffer VipsForeignLoad: buffer is not in a known format\n" {
 				t.Error(err)
 			}
@@ -124,7 +339,7 @@ func TestVips(t *testing.T) {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToJpegBuffer(image)
+			buf, _ := vips.SaveToJpegBuffer(image, 75, true, true)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/grayscale_result_%s.jpg", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
@@ -137,13 +352,73 @@ func TestVips(t *testing.T) {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToWebPBuffer(image)
+			buf, _ := vips.SaveToWebPBuffer(image, 75, 4)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/grayscale_result_%s.webp", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
 			}
 		})
 
+		t.Run("converts an image to a single band", func(t *testing.T) {
+			image, err := vips.Grayscale(resizeImage(t, imageBuffer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer vips.UnrefImage(image)
+
+			if !vips.IsGrayscale(image) {
+				t.Error("image isn't grayscale")
+			}
+
+			if bands := vips.ImageBands(image); bands != 1 {
+				t.Errorf("wrong band count %d", bands)
+			}
+		})
+
+		t.Run("preserves the alpha channel", func(t *testing.T) {
+			original, err := vips.SaveToRawBuffer(resizeImage(t, transparentBuffer), 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			image, err := vips.Grayscale(resizeImage(t, transparentBuffer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !vips.HasAlpha(image) {
+				t.Error("image has no alpha channel")
+			}
+
+			if !vips.IsGrayscale(image) {
+				t.Error("image isn't grayscale")
+			}
+
+			if bands := vips.ImageBands(image); bands != 2 {
+				t.Errorf("wrong band count %d", bands)
+			}
+
+			buf, err := vips.SaveToRawBuffer(image, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(buf) != len(original) {
+				t.Fatalf("wrong buffer length %d", len(buf))
+			}
+
+			// The alpha channel is kept as it is, while the color bands become equal
+			for i := 0; i < len(buf); i += 4 {
+				if buf[i+3] != original[i+3] {
+					t.Fatalf("wrong alpha %d at pixel %d", buf[i+3], i/4)
+				}
+
+				if buf[i] != buf[i+1] || buf[i] != buf[i+2] {
+					t.Fatalf("pixel %d isn't gray", i/4)
+				}
+			}
+		})
+
 		t.Run("errors when given an invalid image", func(t *testing.T) {
 			_, err := vips.Grayscale(vips.NewEmptyImage())
 			if err == nil || err.Error() != "error changing image colorspace vips_image_pio_input: no image data\n" {
@@ -154,12 +429,12 @@ func TestVips(t *testing.T) {
 
 	t.Run("Blur", func(t *testing.T) {
 		t.Run("blurs an image as jpeg", func(t *testing.T) {
-			image, err := vips.Blur(resizeImage(t, imageBuffer), 5)
+			image, err := vips.Blur(resizeImage(t, imageBuffer), 5, false, vips.ExtendCopy)
 			if err != nil {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToJpegBuffer(image)
+			buf, _ := vips.SaveToJpegBuffer(image, 75, true, true)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/blur_result_%s.jpg", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
@@ -167,12 +442,12 @@ func TestVips(t *testing.T) {
 		})
 
 		t.Run("blurs an image as webp", func(t *testing.T) {
-			image, err := vips.Blur(resizeImage(t, imageBuffer), 5)
+			image, err := vips.Blur(resizeImage(t, imageBuffer), 5, false, vips.ExtendCopy)
 			if err != nil {
 				t.Error(err)
 			}
 
-			buf, _ := vips.SaveToWebPBuffer(image)
+			buf, _ := vips.SaveToWebPBuffer(image, 75, 4)
 			resultFixture, _ := ioutil.ReadFile(fmt.Sprintf("../../test/fixtures/vips/blur_result_%s.webp", runtime.GOOS))
 			if !reflect.DeepEqual(buf, resultFixture) {
 				t.Error("image data doesn't match")
@@ -180,10 +455,133 @@ func TestVips(t *testing.T) {
 		})
 
 		t.Run("errors when given an invalid image", func(t *testing.T) {
-			_, err := vips.Blur(vips.NewEmptyImage(), 5)
+			_, err := vips.Blur(vips.NewEmptyImage(), 5, false, vips.ExtendCopy)
 			if err == nil || err.Error() != "error applying blur to image vips_image_pio_input: no image data\n" {
 				t.Error(err)
 			}
 		})
 	})
+
+	t.Run("ICCTransform", func(t *testing.T) {
+		t.Run("converts an image and embeds the profile", func(t *testing.T) {
+			image, err := vips.ICCTransform(resizeImage(t, imageBuffer), "p3", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, _ := vips.SaveToJpegBuffer(image, 75, true, true)
+			if !bytes.Contains(buf, []byte("ICC_PROFILE")) {
+				t.Error("image is missing the embedded profile")
+			}
+		})
+
+		t.Run("keeps grayscale images as they are", func(t *testing.T) {
+			grayscale, err := vips.Grayscale(resizeImage(t, imageBuffer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected, err := vips.SaveToRawBuffer(grayscale, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			grayscale, err = vips.Grayscale(resizeImage(t, imageBuffer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			image, err := vips.ICCTransform(grayscale, "p3", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !vips.IsGrayscale(image) {
+				t.Error("image isn't grayscale")
+			}
+
+			buf, _ := vips.SaveToRawBuffer(image, 1)
+			if !reflect.DeepEqual(buf, expected) {
+				t.Error("image data doesn't match")
+			}
+		})
+
+		t.Run("errors when given an invalid image", func(t *testing.T) {
+			_, err := vips.ICCTransform(vips.NewEmptyImage(), "p3", false)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	})
+}
+
+func BenchmarkResizeImage(b *testing.B) {
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	err := vips.Initialize(log)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	imageBuffer, err := ioutil.ReadFile("../../test/fixtures/fixture.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The source is 6000x4000, so small sizes can be decoded with shrink-on-load while large sizes can't
+	kernels := []struct {
+		Name   string
+		Kernel vips.Kernel
+	}{
+		{"lanczos3", vips.KernelLanczos3},
+		{"nearest", vips.KernelNearest},
+	}
+
+	for _, kernel := range kernels {
+		for _, size := range []int{300, 3000} {
+			b.Run(fmt.Sprintf("%s %dx%d", kernel.Name, size, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					image, err := vips.ResizeImage(imageBuffer, size, size, vips.ResizeOptions{Kernel: kernel.Kernel})
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					// Saving forces the image to be decoded and resized
+					vips.SaveToJpegBuffer(image, 75, true, true)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkEncodeThreads(b *testing.B) {
+	log := logger.New(zap.FatalLevel)
+	defer log.Sync()
+
+	err := vips.Initialize(log)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer vips.SetConcurrency(1)
+
+	imageBuffer, err := ioutil.ReadFile("../../test/fixtures/fixture.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The source is 6000x4000, large enough for the threads to split the work of processing and encoding it
+	for _, threads := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("webp with %d threads", threads), func(b *testing.B) {
+			vips.SetConcurrency(threads)
+			for i := 0; i < b.N; i++ {
+				image, err := vips.ResizeImage(imageBuffer, 4000, 4000, vips.ResizeOptions{Kernel: vips.KernelLanczos3})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				vips.SaveToWebPBuffer(image, 75, 4)
+			}
+		})
+	}
 }